@@ -0,0 +1,397 @@
+// Package acme is a minimal ACME (RFC 8555) client that obtains
+// certificates from Let's Encrypt or another ACME server with HTTP-01
+// challenges. Account keys are ECDSA P-256 and requests are signed ES256.
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem is an error document (RFC 7807) returned by the ACME server
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:"), p.Detail)
+}
+
+// Solver publishes keyAuthorization at
+// http://<domain>/.well-known/acme-challenge/<token> and returns a function
+// that removes it again
+type Solver func(domain, token, keyAuthorization string) (cleanup func(), err error)
+
+// Client talks to one ACME server with one account key
+type Client struct {
+	directoryURL string
+	key          *ecdsa.PrivateKey
+	http         *http.Client
+	dir          *directory
+	kid          string
+	nonces       []string
+}
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+	Error          *Problem `json:"error"`
+}
+
+type authorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Token  string   `json:"token"`
+	Status string   `json:"status"`
+	Error  *Problem `json:"error"`
+}
+
+// NewClient returns a client for the ACME server at directoryURL using an
+// account key, which must be on the P-256 curve
+func NewClient(directoryURL string, key *ecdsa.PrivateKey) (*Client, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("acme: account key must be ECDSA P-256")
+	}
+	return &Client{directoryURL: directoryURL, key: key, http: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Register creates the account of the client's key, or finds it when it
+// exists, agreeing to the server's terms of service
+func (c *Client) Register(ctx context.Context, email string) error {
+	if err := c.discover(ctx); err != nil {
+		return err
+	}
+	account := map[string]interface{}{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	resp, err := c.post(ctx, c.dir.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("acme: failed to register account: %w", err)
+	}
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return fmt.Errorf("acme: account response has no Location")
+	}
+	return nil
+}
+
+// Obtain orders a certificate for domains, answers the HTTP-01 challenges
+// through solve and returns the PEM certificate chain. csr is a DER
+// certificate request for the same domains. Register must be called first.
+func (c *Client) Obtain(ctx context.Context, domains []string, csr []byte, solve Solver) ([]byte, error) {
+	if c.kid == "" {
+		return nil, fmt.Errorf("acme: account is not registered")
+	}
+
+	identifiers := make([]map[string]string, 0, len(domains))
+	for _, domain := range domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	var o order
+	resp, err := c.post(ctx, c.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &o)
+	if err != nil {
+		return nil, fmt.Errorf("acme: failed to create order: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	for _, authzURL := range o.Authorizations {
+		if err := c.authorize(ctx, authzURL, solve); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp, err = c.post(ctx, o.Finalize, map[string]string{"csr": encode(csr)}, &o); err != nil {
+		return nil, fmt.Errorf("acme: failed to finalize order: %w", err)
+	}
+	for o.Status != "valid" {
+		if o.Status == "invalid" {
+			return nil, fmt.Errorf("acme: order failed: %v", o.Error)
+		}
+		if err := wait(ctx, resp); err != nil {
+			return nil, err
+		}
+		if resp, err = c.post(ctx, orderURL, nil, &o); err != nil {
+			return nil, fmt.Errorf("acme: failed to poll order: %w", err)
+		}
+	}
+
+	var chain []byte
+	if _, err := c.post(ctx, o.Certificate, nil, &chain); err != nil {
+		return nil, fmt.Errorf("acme: failed to download certificate: %w", err)
+	}
+	return chain, nil
+}
+
+// authorize answers the HTTP-01 challenge of an authorization and waits for
+// the server to validate it
+func (c *Client) authorize(ctx context.Context, authzURL string, solve Solver) error {
+	var authz authorization
+	if _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("acme: failed to get authorization: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "http-01" {
+			chal = &authz.Challenges[i]
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	cleanup, err := solve(authz.Identifier.Value, chal.Token, chal.Token+"."+c.thumbprint())
+	if err != nil {
+		return fmt.Errorf("acme: failed to publish challenge for %s: %w", authz.Identifier.Value, err)
+	}
+	defer cleanup()
+
+	resp, err := c.post(ctx, chal.URL, struct{}{}, nil)
+	if err != nil {
+		return fmt.Errorf("acme: failed to accept challenge: %w", err)
+	}
+	for {
+		if err := wait(ctx, resp); err != nil {
+			return err
+		}
+		if resp, err = c.post(ctx, authzURL, nil, &authz); err != nil {
+			return fmt.Errorf("acme: failed to poll authorization: %w", err)
+		}
+		switch authz.Status {
+		case "valid":
+			return nil
+		case "pending", "processing":
+			continue
+		}
+		for _, ch := range authz.Challenges {
+			if ch.Type == "http-01" && ch.Error != nil {
+				return fmt.Errorf("acme: validation of %s failed: %w", authz.Identifier.Value, ch.Error)
+			}
+		}
+		return fmt.Errorf("acme: authorization of %s is %s", authz.Identifier.Value, authz.Status)
+	}
+}
+
+func (c *Client) discover(ctx context.Context) error {
+	if c.dir != nil {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.directoryURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("acme: failed to fetch directory: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("acme: directory %s returned %s", c.directoryURL, resp.Status)
+	}
+	var dir directory
+	if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil {
+		return fmt.Errorf("acme: invalid directory: %w", err)
+	}
+	if dir.NewNonce == "" || dir.NewAccount == "" || dir.NewOrder == "" {
+		return fmt.Errorf("acme: directory %s is incomplete", c.directoryURL)
+	}
+	c.dir = &dir
+	return nil
+}
+
+// post sends a JWS-signed request. A nil payload makes it a POST-as-GET.
+// out may be nil, a *[]byte for the raw body, or a value to decode JSON
+// into. A rejected nonce is retried once, as RFC 8555 asks clients to.
+func (c *Client) post(ctx context.Context, url string, payload, out interface{}) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := c.postOnce(ctx, url, payload, out)
+		var problem *Problem
+		if attempt == 0 && errors.As(err, &problem) && problem.Type == "urn:ietf:params:acme:error:badNonce" {
+			continue
+		}
+		return resp, err
+	}
+}
+
+func (c *Client) postOnce(ctx context.Context, url string, payload, out interface{}) (*http.Response, error) {
+	nonce, err := c.nonce(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.sign(url, nonce, payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if n := resp.Header.Get("Replay-Nonce"); n != "" {
+		c.nonces = append(c.nonces, n)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		problem := &Problem{Status: resp.StatusCode}
+		if json.Unmarshal(data, problem) != nil || problem.Type == "" {
+			return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil, problem
+	}
+	switch v := out.(type) {
+	case nil:
+	case *[]byte:
+		*v = data
+	default:
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %w", url, err)
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	if err := c.discover(ctx); err != nil {
+		return "", err
+	}
+	if n := len(c.nonces); n > 0 {
+		nonce := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		return nonce, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", c.dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("acme: failed to get nonce: %w", err)
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", fmt.Errorf("acme: server returned no nonce")
+	}
+	return nonce, nil
+}
+
+// sign builds the flattened JWS of a request. Until the account is
+// registered the key itself is embedded, afterwards its URL (kid).
+func (c *Client) sign(url, nonce string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+	header, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	encodedPayload := ""
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encodedPayload = encode(data)
+	}
+
+	signingInput := encode(header) + "." + encodedPayload
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": encode(header),
+		"payload":   encodedPayload,
+		"signature": encode(signature),
+	})
+}
+
+// jwk returns the public account key as a JSON Web Key
+func (c *Client) jwk() map[string]string {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	c.key.X.FillBytes(x)
+	c.key.Y.FillBytes(y)
+	return map[string]string{"crv": "P-256", "kty": "EC", "x": encode(x), "y": encode(y)}
+}
+
+// thumbprint is the RFC 7638 thumbprint of the account key, which binds
+// key authorizations to the account
+func (c *Client) thumbprint() string {
+	jwk := c.jwk()
+	// Members in lexicographic order, without whitespace
+	canonical := fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])
+	sum := sha256.Sum256([]byte(canonical))
+	return encode(sum[:])
+}
+
+// wait sleeps for the response's Retry-After, or two seconds
+func wait(ctx context.Context, resp *http.Response) error {
+	delay := 2 * time.Second
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 && s < 60 {
+			delay = time.Duration(s) * time.Second
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("acme: %w", ctx.Err())
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// getInactivityReport reads the request counters of every pool and reports
+// the pools without requests for days (default inactivity.days). It does
+// not apply the inactivity policy.
+func (r *Router) getInactivityReport(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	days := 0
+	if v := req.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs.add("days", "must be a whole number of at least 1")
+		}
+		days = n
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	report, err := r.pools(req).CheckActivity(days)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"lightweight-php/manager"
+)
+
+// listAudit returns audit log entries, newest first. request_id, target
+// and actor narrow the list; limit defaults to 100.
+func (r *Router) listAudit(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter := manager.AuditFilter{
+		RequestID: query.Get("request_id"),
+		Target:    query.Get("target"),
+		Actor:     query.Get("actor"),
+	}
+
+	var errs fieldErrors
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			errs.add("limit", "must be a number from 1 to 1000")
+		}
+		filter.Limit = n
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	entries, err := r.poolManager.ListAudit(filter)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, entries)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBatchOperations bounds one batch so a request cannot hold pool locks
+// and defer reloads indefinitely
+const maxBatchOperations = 1000
+
+func (r *Router) batchPools(w http.ResponseWriter, req *http.Request) {
+	var ops []manager.BatchOperation
+	if !r.decodeBody(w, req, &ops) {
+		return
+	}
+
+	if len(ops) == 0 {
+		jsonError(w, http.StatusBadRequest, "No operations provided")
+		return
+	}
+	if len(ops) > maxBatchOperations {
+		jsonError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("A batch is limited to %d operations", maxBatchOperations))
+		return
+	}
+
+	var errs fieldErrors
+	for i, op := range ops {
+		prefix := fmt.Sprintf("[%d].", i)
+		errs.required(prefix+"op", op.Op)
+		errs.oneOf(prefix+"op", op.Op, manager.BatchCreate, manager.BatchUpdate, manager.BatchDelete)
+		errs.required(prefix+"username", op.Username)
+		errs.check(prefix+"username", op.Username, validation.Username)
+		errs.check(prefix+"php_version", op.PHPVersion, validation.PHPVersion)
+		errs.oneOf(prefix+"provider", op.Provider, providerNames...)
+		errs.check(prefix+"profile", op.Profile, validation.ProfileName)
+
+		var settingErrs fieldErrors
+		r.validateSettings(&settingErrs, withoutNulls(op.Settings, true), poolSettingsSchema)
+		for _, e := range settingErrs {
+			errs.add(prefix+"settings."+e.Field, "%s", e.Message)
+		}
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	results, err := r.pools(req).ApplyBatch(ops)
+	if err != nil {
+		errorResponse(w, err, map[string]interface{}{"results": results})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("Applied %d pool operations", len(results)),
+		"results": results,
+	})
+}
+
+// patchPoolsConfig merges a settings patch into every pool matching a
+// selector, with one reload per FPM service
+func (r *Router) patchPoolsConfig(w http.ResponseWriter, req *http.Request) {
+	var reqBody struct {
+		Selector manager.PoolSelector   `json:"selector"`
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	selector := reqBody.Selector
+	if selector.IsEmpty() && !selector.All {
+		errs.add("selector", "must select pools by labels, php_version or provider, or set all to true")
+	}
+	errs.check("selector.php_version", selector.PHPVersion, validation.PHPVersion)
+	errs.oneOf("selector.provider", selector.Provider, providerNames...)
+	for key, value := range selector.Labels {
+		if err := manager.ValidateLabel(key, value); err != nil {
+			errs.add("selector.labels."+key, "%v", err)
+		}
+	}
+	if len(reqBody.Settings) == 0 {
+		errs.add("settings", "is required")
+	}
+	r.validateProfileSettings(&errs, withoutNulls(reqBody.Settings, true))
+	if errs.respond(w) {
+		return
+	}
+
+	results, err := r.pools(req).PatchPoolsConfig(selector, reqBody.Settings)
+	if err != nil {
+		errorResponse(w, err, map[string]interface{}{"results": results})
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("Updated %d pools", len(results)),
+		"results": results,
+	})
+}
+
+func (r *Router) getPoolLabels(w http.ResponseWriter, req *http.Request) {
+	labels, err := r.poolManager.GetPoolLabels(mux.Vars(req)["username"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, labels)
+}
+
+// updatePoolLabels merges labels into a pool's labels; null removes a label
+func (r *Router) updatePoolLabels(w http.ResponseWriter, req *http.Request) {
+	var patch map[string]*string
+	if !r.decodeBody(w, req, &patch) {
+		return
+	}
+
+	var errs fieldErrors
+	set := make(map[string]string)
+	var remove []string
+	for key, value := range patch {
+		if value == nil {
+			remove = append(remove, key)
+			continue
+		}
+		if err := manager.ValidateLabel(key, *value); err != nil {
+			errs.add(key, "%v", err)
+		}
+		set[key] = *value
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	labels, err := r.poolManager.UpdatePoolLabels(mux.Vars(req)["username"], set, remove)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, labels)
+}
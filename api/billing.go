@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"lightweight-php/billing"
+)
+
+// getBillingUsage returns the metered usage of every pool in a period
+// (default the current month) as JSON, or as CSV with format=csv
+func (r *Router) getBillingUsage(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	query := req.URL.Query()
+	period := query.Get("period")
+	if period == "" {
+		period = billing.PeriodOf(time.Now())
+	}
+	errs.check("period", period, func(p string) error {
+		_, _, err := billing.ParsePeriod(p)
+		return err
+	})
+	format := query.Get("format")
+	errs.oneOf("format", format, "json", "csv")
+	if errs.respond(w) {
+		return
+	}
+
+	report, err := r.pools(req).UsageReport(period)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	if format != "csv" {
+		jsonResponse(w, http.StatusOK, report)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="usage-`+period+`.csv"`)
+	w.WriteHeader(http.StatusOK)
+	billing.WriteCSV(w, report)
+}
+
+// exportBillingUsage sends the usage of a finished period to the
+// configured billing exporter
+func (r *Router) exportBillingUsage(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Period string `json:"period"`
+	}
+	if !r.decodeBody(w, req, &body) {
+		return
+	}
+	var errs fieldErrors
+	errs.required("period", body.Period)
+	errs.check("period", body.Period, func(p string) error {
+		_, _, err := billing.ParsePeriod(p)
+		return err
+	})
+	if errs.respond(w) {
+		return
+	}
+
+	exporter, err := r.pools(req).ExportUsage(body.Period)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "Usage exported",
+		"period":   body.Period,
+		"exporter": exporter,
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"lightweight-php/app"
+	"lightweight-php/lock"
+	"lightweight-php/target"
+)
+
+// newTestRouter returns a router on a development sandbox, where every
+// external command succeeds without running, with one pool for bob
+func newTestRouter(t *testing.T) *Router {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"etc", "run", "var/lib/lightweight-php", "home/bob"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	osRelease := "ID=\"lightweight-php-dev\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"9\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "etc/os-release"), []byte(osRelease), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target.EnableDev(dir, "true")
+	t.Cleanup(func() { target.EnableDev("") })
+	defaultLocks := lock.Default
+	lock.Default = lock.NewManager(filepath.Join(dir, lock.DefaultLockDir))
+	t.Cleanup(func() { lock.Default = defaultLocks })
+
+	a, err := app.New(filepath.Join(dir, "var/lib/lightweight-php/test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	if err := a.Pools.CreatePool("bob", "8.2", "remi"); err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(a)
+}
+
+// sendConfig sends settings to bob's config with method and returns the
+// stored settings
+func sendConfig(t *testing.T, r *Router, method string, settings map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	body, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, "/api/v1/pools/bob/config", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", "*")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s config: %d %s", method, w.Code, w.Body)
+	}
+
+	config, err := r.poolManager.GetPoolConfig("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.Settings
+}
+
+func TestPatchConfigRemovesNullKeys(t *testing.T) {
+	r := newTestRouter(t)
+	sendConfig(t, r, http.MethodPut, map[string]interface{}{
+		"memory_limit":       "256M",
+		"max_execution_time": float64(60),
+		"env":                map[string]interface{}{"APP_ENV": "prod", "APP_DEBUG": "0"},
+	})
+
+	settings := sendConfig(t, r, http.MethodPatch, map[string]interface{}{
+		"max_execution_time": nil,
+		"env":                map[string]interface{}{"APP_DEBUG": nil},
+	})
+	if _, ok := settings["max_execution_time"]; ok {
+		t.Errorf("max_execution_time sent as null is still set: %v", settings["max_execution_time"])
+	}
+	if settings["memory_limit"] != "256M" {
+		t.Errorf("memory_limit = %v, want 256M kept by the patch", settings["memory_limit"])
+	}
+	if env := settings["env"]; !reflect.DeepEqual(env, map[string]interface{}{"APP_ENV": "prod"}) {
+		t.Errorf("env = %v, want APP_DEBUG removed and APP_ENV kept", env)
+	}
+}
+
+func TestPutConfigReplacesSettings(t *testing.T) {
+	r := newTestRouter(t)
+	sendConfig(t, r, http.MethodPut, map[string]interface{}{
+		"memory_limit":       "256M",
+		"max_execution_time": float64(60),
+		"env":                map[string]interface{}{"APP_ENV": "prod"},
+	})
+
+	settings := sendConfig(t, r, http.MethodPut, map[string]interface{}{
+		"max_execution_time": float64(30),
+	})
+	want := map[string]interface{}{"max_execution_time": float64(30)}
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("settings after PUT = %v, want %v", settings, want)
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listCrons(w http.ResponseWriter, req *http.Request) {
+	crons, err := r.pools(req).ListCrons(mux.Vars(req)["username"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, crons)
+}
+
+// createCron adds a cron job to the user's crontab; the manager validates
+// the body and answers 422 for invalid fields
+func (r *Router) createCron(w http.ResponseWriter, req *http.Request) {
+	var job manager.CronJob
+	if !r.decodeBody(w, req, &job) {
+		return
+	}
+	c, err := r.pools(req).AddCron(mux.Vars(req)["username"], job)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, c)
+}
+
+func (r *Router) deleteCron(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		var errs fieldErrors
+		errs.add("id", "must be a cron job id")
+		errs.respond(w)
+		return
+	}
+	if err := r.pools(req).RemoveCron(vars["username"], id); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "Cron job removed",
+		"username": vars["username"],
+		"id":       id,
+	})
+}
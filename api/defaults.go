@@ -0,0 +1,43 @@
+package api
+
+import (
+	"net/http"
+)
+
+func (r *Router) getPoolDefaults(w http.ResponseWriter, req *http.Request) {
+	defaults, err := r.poolManager.GetPoolDefaults()
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, defaults)
+}
+
+// updatePoolDefaults replaces the settings new pools start from. Existing
+// pools keep their settings.
+func (r *Router) updatePoolDefaults(w http.ResponseWriter, req *http.Request) {
+	var reqBody struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	r.validateProfileSettings(&errs, reqBody.Settings)
+	if errs.respond(w) {
+		return
+	}
+
+	pools := r.pools(req)
+	if err := pools.SetPoolDefaults(reqBody.Settings); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	defaults, err := pools.GetPoolDefaults()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, defaults)
+}
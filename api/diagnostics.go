@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+)
+
+// getDiagnostics runs the doctor checks on the host and every pool, or
+// with ?username= on one pool. Failed checks are findings in a 200
+// response; ok is false when any of them is an error.
+func (r *Router) getDiagnostics(w http.ResponseWriter, req *http.Request) {
+	report, err := r.pools(req).Doctor(req.URL.Query().Get("username"))
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, report)
+}
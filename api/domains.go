@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listDomains(w http.ResponseWriter, req *http.Request) {
+	domains, err := r.pools(req).ListDomains(r.sites(req), mux.Vars(req)["username"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, domains)
+}
+
+// createDomain adds a site for the pool's user; its document root must be
+// inside the user's home
+func (r *Router) createDomain(w http.ResponseWriter, req *http.Request) {
+	var spec manager.DomainSpec
+	if !r.decodeBody(w, req, &spec) {
+		return
+	}
+	var errs fieldErrors
+	errs.required("domain", spec.Domain)
+	if errs.respond(w) {
+		return
+	}
+
+	domain, err := r.pools(req).AddDomain(r.sites(req), mux.Vars(req)["username"], spec)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, domain)
+}
+
+func (r *Router) getDomain(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	domain, err := r.pools(req).GetDomain(r.sites(req), vars["username"], vars["domain"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, domain)
+}
+
+// updateDomain moves a domain's document root or replaces its PHP values
+func (r *Router) updateDomain(w http.ResponseWriter, req *http.Request) {
+	var update manager.DomainUpdate
+	if !r.decodeBody(w, req, &update) {
+		return
+	}
+	vars := mux.Vars(req)
+	domain, err := r.pools(req).UpdateDomain(r.sites(req), vars["username"], vars["domain"], update)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, domain)
+}
+
+func (r *Router) deleteDomain(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	if err := r.pools(req).RemoveDomain(r.sites(req), vars["username"], vars["domain"]); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message":  "Domain removed",
+		"username": vars["username"],
+		"domain":   vars["domain"],
+	})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/billing"
+	"lightweight-php/dns"
+	"lightweight-php/lock"
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+)
+
+// errorKinds maps domain errors to a status and code, the first match
+// winning. Errors matching none are internal_error with 500.
+var errorKinds = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{manager.ErrRevisionMismatch, http.StatusPreconditionFailed, "revision_mismatch"},
+	{manager.ErrPoolNotFound, http.StatusNotFound, "pool_not_found"},
+	{manager.ErrPoolExists, http.StatusConflict, "pool_exists"},
+	{manager.ErrUserMissing, http.StatusUnprocessableEntity, "user_missing"},
+	{manager.ErrPoolSuspended, http.StatusConflict, "pool_suspended"},
+	{manager.ErrSpecConflict, http.StatusConflict, "spec_conflict"},
+	{manager.ErrNoWorkers, http.StatusConflict, "no_workers"},
+	{manager.ErrNotDockerPool, http.StatusConflict, "not_docker_pool"},
+	{manager.ErrRevisionNotFound, http.StatusNotFound, "revision_not_found"},
+	{manager.ErrProfileNotFound, http.StatusNotFound, "profile_not_found"},
+	{manager.ErrProfileExists, http.StatusConflict, "profile_exists"},
+	{manager.ErrTenantNotFound, http.StatusNotFound, "tenant_not_found"},
+	{manager.ErrTenantExists, http.StatusConflict, "tenant_exists"},
+	{manager.ErrTenantInUse, http.StatusConflict, "tenant_in_use"},
+	{manager.ErrVersionReserved, http.StatusConflict, "version_reserved"},
+	{manager.ErrVersionInUse, http.StatusConflict, "version_in_use"},
+	{manager.ErrInstallLogNotFound, http.StatusNotFound, "install_log_not_found"},
+	{manager.ErrSiteNotFound, http.StatusNotFound, "site_not_found"},
+	{manager.ErrChangeNotFound, http.StatusNotFound, "change_not_found"},
+	{manager.ErrChangeNotPending, http.StatusConflict, "change_not_pending"},
+	{manager.ErrScheduleNotFound, http.StatusNotFound, "schedule_not_found"},
+	{manager.ErrScheduleExists, http.StatusConflict, "schedule_exists"},
+	{manager.ErrScheduleReadOnly, http.StatusConflict, "schedule_read_only"},
+	{manager.ErrScheduleRunning, http.StatusConflict, "schedule_running"},
+	{manager.ErrWorkerNotFound, http.StatusNotFound, "worker_not_found"},
+	{manager.ErrWorkerExists, http.StatusConflict, "worker_exists"},
+	{manager.ErrWorkersUnsupported, http.StatusConflict, "workers_unsupported"},
+	{manager.ErrCronNotFound, http.StatusNotFound, "cron_not_found"},
+	{manager.ErrUpgradeUnsupported, http.StatusConflict, "upgrade_unsupported"},
+	{manager.ErrBatchRejected, http.StatusUnprocessableEntity, "batch_rejected"},
+	{manager.ErrBatchFailed, http.StatusInternalServerError, "batch_failed"},
+	{manager.ErrFPMConfigInvalid, http.StatusUnprocessableEntity, "fpm_config_invalid"},
+	{manager.ErrPeriodNotOver, http.StatusConflict, "period_not_over"},
+	{manager.ErrConfigRejected, http.StatusUnprocessableEntity, "config_rejected"},
+	{dns.ErrNoProvider, http.StatusConflict, "dns_provider_missing"},
+	{billing.ErrNoExporter, http.StatusConflict, "billing_exporter_missing"},
+}
+
+// statusCodes are the codes of errors answered with a bare status
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusLocked:                "locked",
+	http.StatusPreconditionRequired:  "precondition_required",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// classifyError returns the status, code and retryability of a manager
+// error
+func classifyError(err error) (int, string, bool) {
+	var busy *lock.BusyError
+	if errors.As(err, &busy) {
+		// Locks are released when the operation holding them ends
+		if busy.Key == lock.KeyPackageManager {
+			return http.StatusLocked, "package_manager_busy", true
+		}
+		return http.StatusLocked, "resource_busy", true
+	}
+	if errors.Is(err, lock.ErrBusy) {
+		return http.StatusLocked, "resource_busy", true
+	}
+	var invalid validation.Errors
+	if errors.As(err, &invalid) {
+		return http.StatusUnprocessableEntity, "validation_failed", false
+	}
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.status, kind.code, false
+		}
+	}
+	return http.StatusInternalServerError, "internal_error", false
+}
+
+// statusCode names the code of a bare status
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
+
+// jsonError answers with the error envelope for a status and message
+func jsonError(w http.ResponseWriter, status int, message string) {
+	jsonResponse(w, status, apitypes.Error{
+		Error:     message,
+		Code:      statusCode(status),
+		Message:   message,
+		Retryable: status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests,
+	})
+}
+
+// errorResponse answers with the envelope of a manager error. details,
+// when not nil, carries data that comes with the error. Validation errors
+// are answered field by field, like invalid requests.
+func errorResponse(w http.ResponseWriter, err error, details interface{}) {
+	if respondInvalid(w, err) {
+		return
+	}
+	status, code, retryable := classifyError(err)
+	jsonResponse(w, status, apitypes.Error{
+		Error:     err.Error(),
+		Code:      code,
+		Message:   err.Error(),
+		Details:   details,
+		Retryable: retryable,
+	})
+}
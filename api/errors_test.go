@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"lightweight-php/validation"
+)
+
+func TestValidationFailuresCarryFieldDetails(t *testing.T) {
+	r := newTestRouter(t)
+	tests := []struct {
+		name, method, path, body string
+		want                     map[string]string
+	}{
+		{
+			// Rejected by the handler
+			name:   "request body",
+			method: "PATCH",
+			path:   "/api/v1/pools/bob/config",
+			body:   `{"max_children": 0, "maxchildren": 5}`,
+			want: map[string]string{
+				"max_children": "must be from 1 to 10000",
+				"maxchildren":  "unknown setting",
+			},
+		},
+		{
+			// Rejected by the manager
+			name:   "manager",
+			method: "POST",
+			path:   "/api/v1/pools/bob/crons",
+			body:   `{"schedule": "", "command": "php a.php\nrm -rf /"}`,
+			want: map[string]string{
+				"command":  "must be a single line without control characters",
+				"schedule": "is required",
+			},
+		},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader([]byte(tt.body)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: %d %s, want 422", tt.name, w.Code, w.Body)
+			continue
+		}
+
+		var body struct {
+			Code    string                  `json:"code"`
+			Fields  []validation.FieldError `json:"fields"`
+			Details map[string]string       `json:"details"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if body.Code != "validation_failed" {
+			t.Errorf("%s: code %q, want validation_failed", tt.name, body.Code)
+		}
+		if !reflect.DeepEqual(body.Details, tt.want) {
+			t.Errorf("%s: details %v, want %v", tt.name, body.Details, tt.want)
+		}
+		if len(body.Fields) != len(tt.want) {
+			t.Errorf("%s: fields %v, want one per field of %v", tt.name, body.Fields, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	// cacheRevalidate lets clients keep a copy but check it with
+	// If-None-Match before every use
+	cacheRevalidate = "private, no-cache"
+	// cacheCatalog lets clients reuse lists that change with package
+	// repositories or releases rather than with API calls
+	cacheCatalog = "private, max-age=300"
+)
+
+// contentETag returns a strong ETag of v's JSON encoding, which changes
+// with any byte of the response. Pools carry their row's updated_at, so
+// any change to the row changes the tag.
+func contentETag(v interface{}) string {
+	encoded, _ := json.Marshal(v)
+	sum := sha256.Sum256(encoded)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatches reports whether a If-None-Match or If-Match header lists
+// etag or is "*". If-None-Match compares weakly, ignoring W/; If-Match
+// compares strongly (RFC 9110), where a weak tag on either side never
+// matches.
+func etagMatches(header, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if candidate == etag && !strings.HasPrefix(etag, "W/") {
+				return true
+			}
+		} else if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the response's ETag and answers 304 Not Modified when
+// the request's If-None-Match already holds it
+func notModified(w http.ResponseWriter, req *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", cacheRevalidate)
+	if header := req.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, false) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// cachedResponse answers a GET with v under its content ETag, or with 304
+func cachedResponse(w http.ResponseWriter, req *http.Request, v interface{}) {
+	if notModified(w, req, contentETag(v)) {
+		return
+	}
+	jsonResponse(w, http.StatusOK, v)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header, etag string
+		strong, want bool
+	}{
+		{`"a"`, `"a"`, true, true},
+		{`"b", "a"`, `"a"`, true, true},
+		{`*`, `"a"`, true, true},
+		{`W/"a"`, `"a"`, true, false},
+		{`W/"a"`, `W/"a"`, true, false},
+		{`"b"`, `"a"`, true, false},
+		{`W/"a"`, `"a"`, false, true},
+		{`"a"`, `W/"a"`, false, true},
+		{`"b"`, `"a"`, false, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag, tt.strong); got != tt.want {
+			t.Errorf("etagMatches(%s, %s, strong %v) = %v, want %v", tt.header, tt.etag, tt.strong, got, tt.want)
+		}
+	}
+}
+
+func TestDeletePoolIfMatch(t *testing.T) {
+	r := newTestRouter(t)
+	send := func(method, path, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	etag := send("GET", "/api/v1/pools/bob", "").Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("GET pool: ETag %q, want a strong tag", etag)
+	}
+	for _, stale := range []string{"W/" + etag, `"0123456789abcdef01234567"`} {
+		if w := send("DELETE", "/api/v1/pools/bob", stale); w.Code != http.StatusPreconditionFailed {
+			t.Errorf("DELETE with If-Match %s: %d %s, want 412", stale, w.Code, w.Body)
+		}
+	}
+	if w := send("DELETE", "/api/v1/pools/bob", etag); w.Code != http.StatusOK {
+		t.Fatalf("DELETE with the current ETag: %d %s", w.Code, w.Body)
+	}
+	if w := send("DELETE", "/api/v1/pools/bob", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE of a deleted pool with If-Match: %d %s, want 412", w.Code, w.Body)
+	}
+}
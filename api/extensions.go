@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/gorilla/mux"
+)
+
+// installExtension installs a PECL extension for a PHP version from the
+// provider's package, or compiles it with build_from_source
+func (r *Router) installExtension(w http.ResponseWriter, req *http.Request) {
+	var reqBody struct {
+		Name            string `json:"name"`
+		Provider        string `json:"provider"`
+		BuildFromSource bool   `json:"build_from_source"`
+	}
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+	if reqBody.Provider == "" {
+		reqBody.Provider = "remi"
+	}
+
+	var errs fieldErrors
+	errs.required("name", reqBody.Name)
+	if reqBody.Name != "" {
+		if err := manager.ValidateExtensionSpec(reqBody.Name, reqBody.BuildFromSource); err != nil {
+			errs.add("name", "%v", err)
+		}
+	}
+	errs.oneOf("provider", reqBody.Provider, providerNames...)
+	packages, ok := r.targetPackages(w, req, &errs)
+	if !ok {
+		return
+	}
+
+	status, err := packages.InstallExtension(mux.Vars(req)["version"], provider.ProviderType(reqBody.Provider), reqBody.Name, reqBody.BuildFromSource)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, status)
+}
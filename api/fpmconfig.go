@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+
+	"github.com/gorilla/mux"
+)
+
+// getFPMConfig returns the master ([global]) settings of a PHP version
+func (r *Router) getFPMConfig(w http.ResponseWriter, req *http.Request) {
+	packages, providerType, ok := r.fpmPackages(w, req, nil)
+	if !ok {
+		return
+	}
+	cfg, err := packages.GetFPMConfig(mux.Vars(req)["version"], providerType)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, cfg)
+}
+
+// updateFPMConfig changes master settings of a PHP version; null removes a
+// managed setting
+func (r *Router) updateFPMConfig(w http.ResponseWriter, req *http.Request) {
+	var changes map[string]interface{}
+	if !r.decodeBody(w, req, &changes) {
+		return
+	}
+
+	var errs fieldErrors
+	if len(changes) == 0 {
+		errs.add("settings", "at least one setting is required")
+	}
+	for key, value := range changes {
+		if _, err := manager.NormalizeFPMSetting(key, value); err != nil {
+			errs.add(key, "%v", err)
+		}
+	}
+	packages, providerType, ok := r.fpmPackages(w, req, &errs)
+	if !ok {
+		return
+	}
+
+	cfg, err := packages.UpdateFPMConfig(mux.Vars(req)["version"], providerType, changes)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, cfg)
+}
+
+// getFPMLog returns the tail of a PHP version's FPM master log, where
+// php-fpm explains why pools fail to start
+func (r *Router) getFPMLog(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	lines := 100
+	if v := req.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			errs.add("lines", "must be a number between 1 and 1000")
+		}
+		lines = n
+	}
+	packages, providerType, ok := r.fpmPackages(w, req, &errs)
+	if !ok {
+		return
+	}
+
+	log, err := packages.FPMMasterLog(mux.Vars(req)["version"], providerType, lines)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, log)
+}
+
+// fpmPackages validates the provider and target query parameters and
+// responds with errs (which may be nil) when anything is invalid
+func (r *Router) fpmPackages(w http.ResponseWriter, req *http.Request, errs *fieldErrors) (*manager.PackageManager, provider.ProviderType, bool) {
+	if errs == nil {
+		errs = &fieldErrors{}
+	}
+	providerParam := req.URL.Query().Get("provider")
+	if providerParam != "" {
+		errs.oneOf("provider", providerParam, providerNames...)
+		if provider.ProviderType(providerParam) == provider.ProviderLiteSpeed {
+			errs.add("provider", "lsphp has no PHP-FPM master process")
+		}
+	}
+	packages, ok := r.targetPackages(w, req, errs)
+	if !ok {
+		return nil, "", false
+	}
+	providerType := provider.ProviderType(providerParam)
+	if providerType == "" {
+		providerType = provider.ProviderType(packages.GetProvider().GetProviderType())
+	}
+	return packages, providerType, true
+}
+
+// targetPackages returns the package manager of the target query
+// parameter and responds with errs (which may not be nil) when anything is
+// invalid
+func (r *Router) targetPackages(w http.ResponseWriter, req *http.Request, errs *fieldErrors) (*manager.PackageManager, bool) {
+	t, err := target.Parse(req.URL.Query().Get("target"))
+	if err != nil {
+		errs.add("target", "%v", err)
+	}
+	if errs.respond(w) {
+		return nil, false
+	}
+
+	packages := r.packages(req)
+	if !t.IsHost() {
+		if packages, err = packages.WithTarget(t); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return nil, false
+		}
+	}
+	return packages, true
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/provider"
+	"lightweight-php/target"
+
+	"github.com/gorilla/mux"
+)
+
+// getProviderHealth checks that the repositories of a provider can be
+// reached, on the host or with ?target= inside a container. Failed checks
+// are reported in a 200 response; ok is false when any of them is an
+// error.
+func (r *Router) getProviderHealth(w http.ResponseWriter, req *http.Request) {
+	providerName := mux.Vars(req)["provider"]
+
+	var errs fieldErrors
+	errs.oneOf("provider", providerName, providerNames...)
+	t, err := target.Parse(req.URL.Query().Get("target"))
+	if err != nil {
+		errs.add("target", "%v", err)
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	packages := r.packages(req)
+	if !t.IsHost() {
+		if packages, err = packages.WithTarget(t); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	report, err := packages.ProviderHealth(provider.ProviderType(providerName))
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) getPoolHistory(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	history, err := r.poolManager.PoolHistory(username)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"username":  username,
+		"revisions": history,
+	})
+}
+
+// getPoolConfigDiff returns a unified diff from the pool file at a revision
+// to the current file; "diff" is empty when they are equal
+func (r *Router) getPoolConfigDiff(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	username := vars["username"]
+	revision, ok := historyRevision(w, vars["revision"])
+	if !ok {
+		return
+	}
+
+	diff, err := r.poolManager.DiffPoolConfig(username, revision)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"username": username,
+		"revision": revision,
+		"diff":     diff,
+	})
+}
+
+// rollbackPoolConfig restores the settings of a revision. If-Match is
+// optional here; when sent, the rollback only applies to that revision.
+func (r *Router) rollbackPoolConfig(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	username := vars["username"]
+	revision, ok := historyRevision(w, vars["revision"])
+	if !ok {
+		return
+	}
+	expected := manager.AnyRevision
+	if req.Header.Get("If-Match") != "" {
+		if expected, ok = ifMatchRevision(w, req); !ok {
+			return
+		}
+	}
+
+	pools := r.pools(req)
+	newRevision, err := pools.RollbackPoolConfig(username, revision, expected)
+	if respondInvalid(w, err) {
+		return
+	}
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	poolConfig, err := pools.GetPoolConfig(username)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(newRevision))
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message":     "Pool configuration rolled back successfully",
+		"username":    username,
+		"rolled_back": revision,
+		"settings":    poolConfig.Settings,
+		"revision":    newRevision,
+	})
+}
+
+func historyRevision(w http.ResponseWriter, value string) (int64, bool) {
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision < 0 {
+		jsonError(w, http.StatusBadRequest, "Invalid revision")
+		return 0, false
+	}
+	return revision, true
+}
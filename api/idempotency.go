@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/db"
+	"lightweight-php/manager"
+)
+
+// maxIdempotentBody bounds the body of requests whose key is recorded
+const maxIdempotentBody = 1 << 20
+
+// idempotencyKeyPattern accepts keys such as UUIDs or "whmcs-42-create"
+var idempotencyKeyPattern = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+// responseRecorder keeps a copy of a response for replaying it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// idempotent makes a handler safe to retry: a request with an
+// Idempotency-Key header runs once, and repeating it with the same key,
+// method, path and body returns the first response again with
+// Idempotent-Replayed: true. Reusing a key for another request is refused
+// with 422, and a repeat arriving while the first request runs gets 409.
+// Responses with a 5xx status are not kept, so the request can be retried.
+func (r *Router) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, req)
+			return
+		}
+		if !idempotencyKeyPattern.MatchString(key) {
+			jsonError(w, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 visible ASCII characters")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxIdempotentBody+1))
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, "Failed to read request body: "+err.Error())
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			jsonError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Requests with an Idempotency-Key are limited to %d bytes", maxIdempotentBody))
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256([]byte(req.Method + " " + req.URL.Path + "\n" + string(body)))
+		hash := hex.EncodeToString(sum[:])
+
+		database := r.poolManager.GetDatabase()
+		existing, err := claimIdempotencyKey(database, key, hash)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != hash:
+				jsonResponse(w, http.StatusUnprocessableEntity, apitypes.Error{
+					Error:   "Idempotency-Key was used for a different request",
+					Code:    "idempotency_key_reused",
+					Message: "Idempotency-Key was used for a different request",
+				})
+			case existing.Status == 0:
+				jsonResponse(w, http.StatusConflict, apitypes.Error{
+					Error:     "A request with this Idempotency-Key is still running",
+					Code:      "idempotency_key_in_progress",
+					Message:   "A request with this Idempotency-Key is still running",
+					Retryable: true,
+				})
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				io.WriteString(w, existing.Response)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, req)
+		if rec.status >= 500 {
+			database.DeleteIdempotencyKey(key)
+			return
+		}
+		database.FinishIdempotencyKey(key, rec.status, rec.body.String())
+	}
+}
+
+// claimIdempotencyKey records a key for a new request, or returns the
+// request that holds it. A key older than manager.IdempotencyTTL is released
+// first.
+func claimIdempotencyKey(database *db.Database, key, hash string) (*db.IdempotencyKey, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		createErr := database.CreateIdempotencyKey(key, hash, time.Now())
+		if createErr == nil {
+			return nil, nil
+		}
+		existing, err := database.GetIdempotencyKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+		}
+		if existing == nil {
+			return nil, fmt.Errorf("failed to record idempotency key: %w", createErr)
+		}
+		if time.Since(existing.CreatedAt) < manager.IdempotencyTTL {
+			return existing, nil
+		}
+		if err := database.DeleteIdempotencyKey(key); err != nil {
+			return nil, fmt.Errorf("failed to release expired idempotency key: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("failed to record idempotency key %s", key)
+}
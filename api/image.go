@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// rebuildPool builds a Docker pool's image from its current settings and
+// recreates its container
+func (r *Router) rebuildPool(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	build, err := r.pools(req).RebuildPool(username)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, build)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/gorilla/mux"
+)
+
+// runInstall runs an install and answers with its outcome. With
+// async=true the response is 202 as soon as the install is recorded, with
+// the install log to follow; the install continues after the request ends
+// and its output is streamed as php.install.output events.
+func (r *Router) runInstall(w http.ResponseWriter, req *http.Request, packages *manager.PackageManager, version, providerName string, install func(*manager.PackageManager) error) {
+	if req.URL.Query().Get("rollback") == "true" {
+		packages = packages.WithRollback()
+	}
+	if req.URL.Query().Get("async") != "true" {
+		if err := install(packages); err != nil {
+			installError(w, err)
+			return
+		}
+		jsonResponse(w, http.StatusOK, apitypes.InstallResult{
+			Message:  "PHP installed successfully",
+			Version:  version,
+			Provider: providerName,
+		})
+		return
+	}
+
+	started := make(chan int64, 1)
+	done := make(chan error, 1)
+	packages = packages.WithContext(context.WithoutCancel(req.Context())).WithProgress(manager.InstallProgress{
+		Started: func(id int64) { started <- id },
+	})
+	go func() { done <- install(packages) }()
+
+	select {
+	case id := <-started:
+		logPath := fmt.Sprintf("/api/v1/providers/%s/installs/%d", providerName, id)
+		w.Header().Set("Location", logPath)
+		jsonResponse(w, http.StatusAccepted, apitypes.InstallResult{
+			Message:   "PHP install started",
+			Version:   version,
+			Provider:  providerName,
+			InstallID: id,
+			Log:       logPath,
+		})
+	case err := <-done:
+		// Finished or failed before it was recorded, e.g. on a busy lock
+		if err != nil {
+			installError(w, err)
+			return
+		}
+		jsonResponse(w, http.StatusOK, apitypes.InstallResult{
+			Message:  "PHP installed successfully",
+			Version:  version,
+			Provider: providerName,
+		})
+	}
+}
+
+// installError answers a failed install, listing what a rollback undid
+func installError(w http.ResponseWriter, err error) {
+	var installErr *manager.InstallError
+	if !errors.As(err, &installErr) {
+		errorResponse(w, err, nil)
+		return
+	}
+	errorResponse(w, installErr.Err, map[string]interface{}{"rollback": installErr.RolledBack})
+}
+
+func (r *Router) listInstallLogs(w http.ResponseWriter, req *http.Request) {
+	providerName := mux.Vars(req)["provider"]
+
+	var errs fieldErrors
+	errs.oneOf("provider", providerName, providerNames...)
+	limit := 0
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs.add("limit", "must be a positive number")
+		}
+		limit = n
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	logs, err := r.packageManager.ListInstallLogs(provider.ProviderType(providerName), limit)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, logs)
+}
+
+func (r *Router) getInstallLog(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	providerName := vars["provider"]
+
+	var errs fieldErrors
+	errs.oneOf("provider", providerName, providerNames...)
+	id, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		errs.add("id", "must be an install log id")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	log, err := r.packageManager.GetInstallLog(provider.ProviderType(providerName), id)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, log)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+// scopeContextKey carries the tenants a request's API key is limited to
+type scopeContextKey struct{}
+
+// tenantRoutes are the routes a key limited to tenants may use, by path
+// template and method. Handlers of the list routes filter their results
+// with tenantScope; pool routes are checked against the pool's tenant.
+var tenantRoutes = map[string][]string{
+	"/api/v1/pools":                 {"GET", "POST"},
+	"/api/v1/php/versions":          {"GET"},
+	"/api/v1/tenants":               {"GET"},
+	"/api/v1/tenants/{name}":        {"GET"},
+	"/api/v1/provisioning/accounts": {"POST"},
+}
+
+// matchAPIKey returns the configured key the request carries as a bearer
+// token or in X-API-Key, or nil. Keys given as key_sha256 are matched by
+// the token's digest.
+func matchAPIKey(req *http.Request, keys []config.APIKey) *config.APIKey {
+	token := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return nil
+	}
+	digest := sha256.Sum256([]byte(token))
+	for i := range keys {
+		if keys[i].KeySHA256 != "" {
+			want, err := hex.DecodeString(keys[i].KeySHA256)
+			if err == nil && subtle.ConstantTimeCompare(digest[:], want) == 1 {
+				return &keys[i]
+			}
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(keys[i].Key)) == 1 {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// tenantScope returns the tenants the request is limited to, or nil when
+// it may use everything
+func tenantScope(req *http.Request) map[string]bool {
+	scope, _ := req.Context().Value(scopeContextKey{}).(map[string]bool)
+	return scope
+}
+
+// inScope reports whether a tenant is visible to the request
+func inScope(req *http.Request, tenant string) bool {
+	scope := tenantScope(req)
+	return scope == nil || scope[tenant]
+}
+
+// authorizeKeys requires a configured API key on every request when
+// api.keys is set. A key limited to tenants may only use tenantRoutes and
+// the routes of pools in its tenants; other pools look like they do not
+// exist.
+func (r *Router) authorizeKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cfg := config.Get().API
+		path := routeTemplate(req)
+		if len(cfg.Keys) == 0 || path == "/health" || (path == "/api/v1/ws" && len(cfg.StreamTokens) > 0) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		key := matchAPIKey(req, cfg.Keys)
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			jsonError(w, http.StatusUnauthorized, "a valid API key is required")
+			return
+		}
+		if len(key.Tenants) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		scope := make(map[string]bool, len(key.Tenants))
+		for _, t := range key.Tenants {
+			scope[t] = true
+		}
+		if username, ok := mux.Vars(req)["username"]; ok && (strings.HasPrefix(path, "/api/v1/pools/{username}") || strings.HasPrefix(path, "/api/v1/provisioning/accounts/{username}")) {
+			dbPool, err := r.poolManager.GetDatabase().GetPool(username)
+			if err != nil {
+				jsonError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if dbPool == nil || !scope[dbPool.Tenant] {
+				errorResponse(w, fmt.Errorf("%w: %s", manager.ErrPoolNotFound, username), nil)
+				return
+			}
+		} else if !containsMethod(tenantRoutes[path], req.Method) {
+			jsonError(w, http.StatusForbidden, "this API key is limited to tenants "+strings.Join(key.Tenants, ", "))
+			return
+		}
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), scopeContextKey{}, scope)))
+	})
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
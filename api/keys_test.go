@@ -0,0 +1,34 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"testing"
+
+	"lightweight-php/config"
+)
+
+func TestMatchAPIKeyByDigest(t *testing.T) {
+	digest := sha256.Sum256([]byte("fedcba9876543210fedc"))
+	keys := []config.APIKey{
+		{Name: "panel", Key: "0123456789abcdef0123"},
+		{Name: "reseller", KeySHA256: hex.EncodeToString(digest[:])},
+	}
+	for token, want := range map[string]string{
+		"0123456789abcdef0123":        "panel",
+		"fedcba9876543210fedc":        "reseller",
+		hex.EncodeToString(digest[:]): "",
+		"fedcba9876543210fedd":        "",
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/pools", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		got := ""
+		if key := matchAPIKey(req, keys); key != nil {
+			got = key.Name
+		}
+		if got != want {
+			t.Errorf("token %s matched %q, want %q", token, got, want)
+		}
+	}
+}
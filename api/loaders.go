@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listLoaders(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	version := vars["version"]
+
+	providerParam := req.URL.Query().Get("provider")
+	if providerParam == "" {
+		providerParam = "remi"
+	}
+
+	var errs fieldErrors
+	errs.oneOf("provider", providerParam, providerNames...)
+	if errs.respond(w) {
+		return
+	}
+
+	loaders, err := r.packageManager.ListLoaders(version, provider.ProviderType(providerParam))
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, loaders)
+}
+
+func (r *Router) installLoader(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	version := vars["version"]
+
+	var reqBody struct {
+		Loader   string `json:"loader"`
+		Provider string `json:"provider"`
+	}
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+	if reqBody.Provider == "" {
+		reqBody.Provider = "remi"
+	}
+
+	var errs fieldErrors
+	errs.required("loader", reqBody.Loader)
+	errs.oneOf("loader", reqBody.Loader, manager.Loaders...)
+	errs.oneOf("provider", reqBody.Provider, providerNames...)
+	if errs.respond(w) {
+		return
+	}
+
+	status, err := r.packageManager.InstallLoader(version, provider.ProviderType(reqBody.Provider), reqBody.Loader)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, status)
+}
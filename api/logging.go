@@ -0,0 +1,120 @@
+package api
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"lightweight-php/audit"
+	"lightweight-php/config"
+
+	"github.com/gorilla/mux"
+)
+
+// requestIDPattern limits the request IDs accepted from clients and
+// proxies; others are replaced by a generated one
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// logRequests logs each request with its ID, caller, status and latency.
+// The ID comes from the X-Request-ID header when a proxy set one and is
+// generated otherwise; it is returned in X-Request-ID and handed with the
+// caller to the managers, which record it in the audit log.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = audit.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		caller := audit.Caller{RequestID: id, Actor: callerIdentity(req), Remote: remoteHost(req)}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(audit.WithCaller(req.Context(), caller)))
+
+		attrs := []any{
+			"request_id", id,
+			"method", req.Method,
+			"path", req.URL.Path,
+			"route", routeTemplate(req),
+			"status", rec.status,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"remote", caller.Remote,
+			"actor", caller.Actor,
+		}
+		if traceID := w.Header().Get("X-Trace-Id"); traceID != "" {
+			attrs = append(attrs, "trace_id", traceID)
+		}
+		level := requestLevel(rec.status)
+		if level >= parseLogLevel(config.Get().API.LogLevel) {
+			slog.Log(req.Context(), level, "request", attrs...)
+		}
+	})
+}
+
+// requestLevel is the level a request is logged at: error for 5xx, warn
+// for 4xx and info otherwise
+func requestLevel(status int) slog.Level {
+	switch {
+	case status >= 500:
+		return slog.LevelError
+	case status >= 400:
+		return slog.LevelWarn
+	}
+	return slog.LevelInfo
+}
+
+// parseLogLevel reads api.log_level, which config validation limits to
+// info, warn and error
+func parseLogLevel(level string) slog.Level {
+	switch level {
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// callerIdentity names the API client: "key:" and the name of its API key,
+// the user an authenticating reverse proxy passed in X-Remote-User, the
+// HTTP basic auth user, or "anonymous". Without API keys this is only as
+// trustworthy as the proxy in front of it.
+func callerIdentity(req *http.Request) string {
+	if key := matchAPIKey(req, config.Get().API.Keys); key != nil && key.Name != "" {
+		return "key:" + key.Name
+	}
+	if user := req.Header.Get("X-Remote-User"); user != "" {
+		return user
+	}
+	if user, _, ok := req.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+// remoteHost returns the client's address without the port, or "unix"
+// for clients on a unix socket
+func remoteHost(req *http.Request) string {
+	if _, ok := req.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return "unix"
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// routeTemplate returns the matched route's path template, or the path
+// when no route matched
+func routeTemplate(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return req.URL.Path
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// profileBody is the request body of POST and PUT /api/v1/profiles
+type profileBody struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Settings    map[string]interface{} `json:"settings"`
+}
+
+func (r *Router) listProfiles(w http.ResponseWriter, req *http.Request) {
+	profiles, err := r.poolManager.ListProfiles()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, profiles)
+}
+
+func (r *Router) getProfile(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	profile, err := r.poolManager.GetProfile(vars["name"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, profile)
+}
+
+func (r *Router) createProfile(w http.ResponseWriter, req *http.Request) {
+	var reqBody profileBody
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.required("name", reqBody.Name)
+	errs.check("name", reqBody.Name, validation.ProfileName)
+	r.validateProfileSettings(&errs, reqBody.Settings)
+	if errs.respond(w) {
+		return
+	}
+
+	profile := manager.Profile{
+		Name:        reqBody.Name,
+		Description: reqBody.Description,
+		Settings:    reqBody.Settings,
+	}
+	if err := r.poolManager.CreateProfile(&profile); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	created, err := r.poolManager.GetProfile(profile.Name)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusCreated, created)
+}
+
+// updateProfile replaces a profile's description and settings; the name in
+// the path wins over one in the body
+func (r *Router) updateProfile(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	name := vars["name"]
+
+	var reqBody profileBody
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	if reqBody.Name != "" && reqBody.Name != name {
+		errs.add("name", "does not match the profile in the path")
+	}
+	r.validateProfileSettings(&errs, reqBody.Settings)
+	if errs.respond(w) {
+		return
+	}
+
+	profile := manager.Profile{
+		Name:        name,
+		Description: reqBody.Description,
+		Settings:    reqBody.Settings,
+	}
+	if err := r.poolManager.UpdateProfile(&profile); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	updated, err := r.poolManager.GetProfile(name)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+func (r *Router) deleteProfile(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	name := vars["name"]
+
+	if err := r.poolManager.DeleteProfile(name); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message": "Profile deleted successfully",
+		"name":    name,
+	})
+}
+
+// validateProfileSettings checks profile settings against the pool settings
+// schema, reporting errors under "settings.<key>"
+func (r *Router) validateProfileSettings(errs *fieldErrors, settings map[string]interface{}) {
+	var settingErrs fieldErrors
+	r.validateSettings(&settingErrs, settings, poolSettingsSchema)
+	for _, e := range settingErrs {
+		errs.add("settings."+e.Field, "%s", e.Message)
+	}
+}
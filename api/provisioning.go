@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+// The provisioning API is the subset billing systems such as WHMCS drive:
+// create, suspend, unsuspend, terminate and change package. Its paths and
+// bodies are kept stable across releases, and every mutation honours
+// Idempotency-Key so a billing module may retry after a timeout.
+
+func (r *Router) getAccount(w http.ResponseWriter, req *http.Request) {
+	account, err := r.pools(req).GetAccount(mux.Vars(req)["username"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, account)
+}
+
+// createAccount creates a pool with the settings of its package; the
+// manager validates the body and answers 422 for invalid fields
+func (r *Router) createAccount(w http.ResponseWriter, req *http.Request) {
+	var spec manager.AccountSpec
+	if !r.decodeBody(w, req, &spec) {
+		return
+	}
+	var errs fieldErrors
+	errs.oneOf("provider", spec.Provider, providerNames...)
+	if scope := tenantScope(req); scope != nil && spec.Tenant == "" && len(scope) == 1 {
+		for name := range scope {
+			spec.Tenant = name
+		}
+	}
+	if !inScope(req, spec.Tenant) {
+		errs.add("tenant", "is not one of this API key's tenants")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	account, err := r.pools(req).ProvisionAccount(spec)
+	if respondInvalid(w, err) {
+		return
+	}
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, account)
+}
+
+// suspendAccount suspends an account; suspending it again changes nothing
+func (r *Router) suspendAccount(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if req.ContentLength != 0 && !r.decodeBody(w, req, &body) {
+		return
+	}
+	pm := r.pools(req)
+	username := mux.Vars(req)["username"]
+	if err := pm.SuspendPool(username, body.Reason); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	r.respondAccount(w, pm, username)
+}
+
+// unsuspendAccount restores an account; unsuspending an active account
+// changes nothing
+func (r *Router) unsuspendAccount(w http.ResponseWriter, req *http.Request) {
+	pm := r.pools(req)
+	username := mux.Vars(req)["username"]
+	if err := pm.UnsuspendPool(username); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	r.respondAccount(w, pm, username)
+}
+
+// changeAccountPackage moves an account to another package (profile)
+func (r *Router) changeAccountPackage(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Package string `json:"package"`
+	}
+	if !r.decodeBody(w, req, &body) {
+		return
+	}
+	var errs fieldErrors
+	errs.required("package", body.Package)
+	if errs.respond(w) {
+		return
+	}
+
+	account, err := r.pools(req).ChangePackage(mux.Vars(req)["username"], body.Package)
+	if respondInvalid(w, err) {
+		return
+	}
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, account)
+}
+
+// terminateAccount deletes an account's pool and its data, and with
+// remove_user locks or deletes its system user
+func (r *Router) terminateAccount(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		RemoveUser bool `json:"remove_user"`
+	}
+	if req.ContentLength != 0 && !r.decodeBody(w, req, &body) {
+		return
+	}
+	username := mux.Vars(req)["username"]
+	action, err := r.pools(req).TerminateAccount(username, body.RemoveUser)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	response := map[string]string{
+		"message":  "Account terminated",
+		"username": username,
+	}
+	if action != "" {
+		response["user"] = action
+	}
+	jsonResponse(w, http.StatusOK, response)
+}
+
+func (r *Router) respondAccount(w http.ResponseWriter, pm *manager.PoolManager, username string) {
+	account, err := pm.GetAccount(username)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, account)
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/config"
+)
+
+// listDiscrepancies returns the discrepancies the reconciliation loop
+// found and could not heal
+func (r *Router) listDiscrepancies(w http.ResponseWriter, req *http.Request) {
+	discrepancies, err := r.pools(req).ListDiscrepancies()
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"discrepancies": discrepancies,
+	})
+}
+
+// reconcile runs a reconciliation pass now, healing the kinds in the
+// optional body's heal
+func (r *Router) reconcile(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Heal []string `json:"heal"`
+	}
+	if req.ContentLength != 0 && !r.decodeBody(w, req, &body) {
+		return
+	}
+	var errs fieldErrors
+	for _, kind := range body.Heal {
+		errs.oneOf("heal", kind, config.HealConfig, config.HealService)
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	report, err := r.pools(req).Reconcile(body.Heal)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, report)
+}
@@ -0,0 +1,19 @@
+package api
+
+import "net/http"
+
+// reloadConfig reads the config file again without restarting the server
+func (r *Router) reloadConfig(w http.ResponseWriter, req *http.Request) {
+	restart, err := r.pools(req).ReloadConfig()
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message":          "Configuration reloaded",
+		"restart_required": restart,
+	})
+}
@@ -1,12 +1,21 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
+	"lightweight-php/apitypes"
+	"lightweight-php/app"
+	"lightweight-php/config"
 	"lightweight-php/manager"
 	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/validation"
 
 	"github.com/gorilla/mux"
 )
@@ -15,46 +24,199 @@ type Router struct {
 	*mux.Router
 	poolManager    *manager.PoolManager
 	packageManager *manager.PackageManager
-}
+	siteManager    *manager.SiteManager
+	scheduler      *manager.Scheduler
 
-func NewRouter() (*Router, error) {
-	poolMgr, err := manager.NewPoolManager()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize pool manager: %w", err)
-	}
+	// relaxedValidation accepts unknown request fields for older clients,
+	// whatever api.relaxed_validation says
+	relaxedValidation bool
 
-	pkgMgr, err := manager.NewPackageManager()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize package manager: %w", err)
-	}
+	// watchOnce starts the pool watcher feeding the event stream
+	watchOnce sync.Once
+}
 
+// NewRouter builds the API on the application's shared managers
+func NewRouter(a *app.App) *Router {
 	r := &Router{
 		Router:         mux.NewRouter(),
-		poolManager:    poolMgr,
-		packageManager: pkgMgr,
+		poolManager:    a.Pools,
+		packageManager: a.Packages,
+		siteManager:    a.Sites,
+		scheduler:      a.Schedules,
 	}
 	r.setupRoutes()
-	return r, nil
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		jsonError(w, http.StatusNotFound, "No such endpoint: "+req.URL.Path)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		jsonError(w, http.StatusMethodNotAllowed, req.Method+" is not allowed on "+req.URL.Path)
+	})
+	r.Use(logRequests)
+	r.Use(traceRequests)
+	r.Use(r.authorizeKeys)
+	r.Use(validatePathVars)
+	return r
+}
+
+// SetRelaxedValidation makes the API ignore unknown request fields and
+// settings instead of rejecting them with 422
+func (r *Router) SetRelaxedValidation(relaxed bool) {
+	r.relaxedValidation = relaxed
+}
+
+// relaxed reports whether relaxed validation is on, by SetRelaxedValidation
+// or by api.relaxed_validation as of the last config reload
+func (r *Router) relaxed() bool {
+	return r.relaxedValidation || config.Get().API.RelaxedValidation
 }
 
 func (r *Router) setupRoutes() {
 	// Pool management endpoints
 	r.HandleFunc("/api/v1/pools", r.listPools).Methods("GET")
 	r.HandleFunc("/api/v1/pools", r.createPool).Methods("POST")
+	r.HandleFunc("/api/v1/pools/import", r.importPoolBundle).Methods("POST")
+	r.HandleFunc("/api/v1/pools/batch", r.batchPools).Methods("POST")
+	r.HandleFunc("/api/v1/pools/config", r.patchPoolsConfig).Methods("PATCH")
 	r.HandleFunc("/api/v1/pools/{username}", r.getPool).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}", r.putPool).Methods("PUT")
+	r.HandleFunc("/api/v1/pools/{username}/spec", r.getPoolSpec).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/bundle", r.exportPoolBundle).Methods("GET")
 	r.HandleFunc("/api/v1/pools/{username}", r.deletePool).Methods("DELETE")
+	r.HandleFunc("/api/v1/pools/{username}/status", r.getPoolStatus).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/config", r.getPoolConfig).Methods("GET")
 	r.HandleFunc("/api/v1/pools/{username}/config", r.updatePoolConfig).Methods("PUT", "PATCH")
+	r.HandleFunc("/api/v1/pools/{username}/history", r.getPoolHistory).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/history/{revision}/diff", r.getPoolConfigDiff).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/history/{revision}/rollback", r.rollbackPoolConfig).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/opcache/reset", r.resetPoolOpcache).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/tune", r.getPoolTuning).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/tune", r.tunePool).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/test", r.testPool).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/rebuild", r.rebuildPool).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/suspend", r.suspendPool).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/unsuspend", r.unsuspendPool).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/labels", r.getPoolLabels).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/labels", r.updatePoolLabels).Methods("PATCH")
+	r.HandleFunc("/api/v1/pools/{username}/tenant", r.setPoolTenant).Methods("PUT")
+	r.HandleFunc("/api/v1/pools/{username}/workers", r.listWorkers).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/workers", r.createWorker).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/workers/{name}", r.getWorker).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/workers/{name}", r.deleteWorker).Methods("DELETE")
+	r.HandleFunc("/api/v1/pools/{username}/workers/{name}/start", r.controlWorker(manager.WorkerStart)).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/workers/{name}/stop", r.controlWorker(manager.WorkerStop)).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/workers/{name}/restart", r.controlWorker(manager.WorkerRestart)).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/workers/{name}/logs", r.getWorkerLogs).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/crons", r.listCrons).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/crons", r.createCron).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/crons/{id}", r.deleteCron).Methods("DELETE")
+	r.HandleFunc("/api/v1/pools/{username}/domains", r.listDomains).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/domains", r.createDomain).Methods("POST")
+	r.HandleFunc("/api/v1/pools/{username}/domains/{domain}", r.getDomain).Methods("GET")
+	r.HandleFunc("/api/v1/pools/{username}/domains/{domain}", r.updateDomain).Methods("PUT")
+	r.HandleFunc("/api/v1/pools/{username}/domains/{domain}", r.deleteDomain).Methods("DELETE")
+
+	// Scheduled pool changes
+	r.HandleFunc("/api/v1/scheduled-changes", r.listScheduledChanges).Methods("GET")
+	r.HandleFunc("/api/v1/scheduled-changes", r.createScheduledChange).Methods("POST")
+	r.HandleFunc("/api/v1/scheduled-changes/{id}", r.getScheduledChange).Methods("GET")
+	r.HandleFunc("/api/v1/scheduled-changes/{id}", r.updateScheduledChange).Methods("PATCH")
+	r.HandleFunc("/api/v1/scheduled-changes/{id}", r.cancelScheduledChange).Methods("DELETE")
+
+	// Recurring tasks run by the server
+	r.HandleFunc("/api/v1/schedules", r.listSchedules).Methods("GET")
+	r.HandleFunc("/api/v1/schedules", r.createSchedule).Methods("POST")
+	r.HandleFunc("/api/v1/schedules/{name}", r.getSchedule).Methods("GET")
+	r.HandleFunc("/api/v1/schedules/{name}", r.deleteSchedule).Methods("DELETE")
+	r.HandleFunc("/api/v1/schedules/{name}/runs", r.listScheduleRuns).Methods("GET")
+	r.HandleFunc("/api/v1/schedules/{name}/run", r.runSchedule).Methods("POST")
+
+	// Provisioning for billing systems
+	r.HandleFunc("/api/v1/provisioning/accounts", r.idempotent(r.createAccount)).Methods("POST")
+	r.HandleFunc("/api/v1/provisioning/accounts/{username}", r.getAccount).Methods("GET")
+	r.HandleFunc("/api/v1/provisioning/accounts/{username}/suspend", r.idempotent(r.suspendAccount)).Methods("POST")
+	r.HandleFunc("/api/v1/provisioning/accounts/{username}/unsuspend", r.idempotent(r.unsuspendAccount)).Methods("POST")
+	r.HandleFunc("/api/v1/provisioning/accounts/{username}/package", r.idempotent(r.changeAccountPackage)).Methods("POST")
+	r.HandleFunc("/api/v1/provisioning/accounts/{username}/terminate", r.idempotent(r.terminateAccount)).Methods("POST")
+
+	// Pool profiles
+	r.HandleFunc("/api/v1/profiles", r.listProfiles).Methods("GET")
+	r.HandleFunc("/api/v1/profiles", r.createProfile).Methods("POST")
+	r.HandleFunc("/api/v1/profiles/{name}", r.getProfile).Methods("GET")
+	r.HandleFunc("/api/v1/profiles/{name}", r.updateProfile).Methods("PUT")
+	r.HandleFunc("/api/v1/profiles/{name}", r.deleteProfile).Methods("DELETE")
+
+	// Settings every new pool starts from
+	r.HandleFunc("/api/v1/defaults", r.getPoolDefaults).Methods("GET")
+	r.HandleFunc("/api/v1/defaults", r.updatePoolDefaults).Methods("PUT")
+
+	// Tenants
+	r.HandleFunc("/api/v1/tenants", r.listTenants).Methods("GET")
+	r.HandleFunc("/api/v1/tenants", r.createTenant).Methods("POST")
+	r.HandleFunc("/api/v1/tenants/{name}", r.getTenant).Methods("GET")
+	r.HandleFunc("/api/v1/tenants/{name}", r.updateTenant).Methods("PUT")
+	r.HandleFunc("/api/v1/tenants/{name}", r.deleteTenant).Methods("DELETE")
+
+	// Site endpoints
+	r.HandleFunc("/api/v1/sites", r.listSites).Methods("GET")
+	r.HandleFunc("/api/v1/sites", r.createSite).Methods("POST")
+	r.HandleFunc("/api/v1/sites/{domain}", r.getSite).Methods("GET")
+	r.HandleFunc("/api/v1/sites/{domain}/snippet", r.getSiteSnippet).Methods("GET")
+	r.HandleFunc("/api/v1/sites/{domain}", r.deleteSite).Methods("DELETE")
+	r.HandleFunc("/api/v1/sites/{domain}/bindings", r.bindSitePath).Methods("PUT")
+	r.HandleFunc("/api/v1/sites/{domain}/bindings", r.unbindSitePath).Methods("DELETE")
+	r.HandleFunc("/api/v1/sites/{domain}/dns", r.checkSiteDNS).Methods("GET")
+	r.HandleFunc("/api/v1/sites/{domain}/dns", r.updateSiteDNS).Methods("PUT")
+	r.HandleFunc("/api/v1/sites/{domain}/certificate", r.issueSiteCertificate).Methods("POST")
+	r.HandleFunc("/api/v1/certificates", r.listCertificates).Methods("GET")
+	r.HandleFunc("/api/v1/certificates/renew", r.renewCertificates).Methods("POST")
 
 	// PHP installation endpoints
-	r.HandleFunc("/api/v1/php/install/{version}", r.installPHP).Methods("POST")
+	r.HandleFunc("/api/v1/php/install/{spec}", r.installPHP).Methods("POST")
 	r.HandleFunc("/api/v1/php/versions", r.listPHPVersions).Methods("GET")
+	r.HandleFunc("/api/v1/php/upgrade", r.upgradeAllPHP).Methods("POST")
+	r.HandleFunc("/api/v1/php/{version}", r.uninstallPHP).Methods("DELETE")
 	r.HandleFunc("/api/v1/php/available", r.listAvailablePHP).Methods("GET")
+	r.HandleFunc("/api/v1/php/{version}/upgrade", r.upgradePHP).Methods("POST")
+	r.HandleFunc("/api/v1/php/{version}/extensions", r.installExtension).Methods("POST")
+	r.HandleFunc("/api/v1/php/{version}/opcache", r.updatePHPOpcache).Methods("PUT")
+	r.HandleFunc("/api/v1/php/{version}/fpm-config", r.getFPMConfig).Methods("GET")
+	r.HandleFunc("/api/v1/php/{version}/fpm-config", r.updateFPMConfig).Methods("PATCH")
+	r.HandleFunc("/api/v1/php/{version}/fpm-log", r.getFPMLog).Methods("GET")
+	r.HandleFunc("/api/v1/php/{version}/loaders", r.listLoaders).Methods("GET")
+	r.HandleFunc("/api/v1/php/{version}/loaders", r.installLoader).Methods("POST")
+	r.HandleFunc("/api/v1/php/{version}/tenant", r.setPHPVersionTenant).Methods("PUT")
 	
 	// Provider endpoints
 	r.HandleFunc("/api/v1/providers", r.listProviders).Methods("GET")
-	r.HandleFunc("/api/v1/providers/{provider}/install/{version}", r.installPHPWithProvider).Methods("POST")
+	r.HandleFunc("/api/v1/providers/{provider}/install/{spec}", r.installPHPWithProvider).Methods("POST")
 	r.HandleFunc("/api/v1/providers/{provider}/versions", r.listPHPVersionsByProvider).Methods("GET")
 	r.HandleFunc("/api/v1/providers/{provider}/available", r.listAvailablePHPByProvider).Methods("GET")
+	r.HandleFunc("/api/v1/providers/{provider}/installs", r.listInstallLogs).Methods("GET")
+	r.HandleFunc("/api/v1/providers/{provider}/installs/{id}", r.getInstallLog).Methods("GET")
+	r.HandleFunc("/api/v1/providers/{provider}/health", r.getProviderHealth).Methods("GET")
+
+	// Audit log
+	r.HandleFunc("/api/v1/audit", r.listAudit).Methods("GET")
+
+	// Reports
+	r.HandleFunc("/api/v1/reports/inactivity", r.getInactivityReport).Methods("GET")
+	r.HandleFunc("/api/v1/diagnostics", r.getDiagnostics).Methods("GET")
+	r.HandleFunc("/api/v1/reconcile", r.listDiscrepancies).Methods("GET")
+	r.HandleFunc("/api/v1/reconcile", r.reconcile).Methods("POST")
+	r.HandleFunc("/api/v1/reload", r.reloadConfig).Methods("POST")
+	r.HandleFunc("/api/v1/stats/top", r.getTop).Methods("GET")
+
+	// Metered usage for billing
+	r.HandleFunc("/api/v1/billing/usage", r.getBillingUsage).Methods("GET")
+	r.HandleFunc("/api/v1/billing/usage/export", r.exportBillingUsage).Methods("POST")
+
+	// Host state, for comparing replicas
+	r.HandleFunc("/api/v1/state", r.getState).Methods("GET")
+	r.HandleFunc("/api/v1/system", r.getSystem).Methods("GET")
+	r.HandleFunc("/api/v1/system/info", r.getSystemInfo).Methods("GET")
+
+	// Live updates for dashboards
+	r.HandleFunc("/api/v1/ws", r.streamEvents).Methods("GET")
 
 	// Health check
 	r.HandleFunc("/health", r.healthCheck).Methods("GET")
@@ -64,29 +226,61 @@ func (r *Router) healthCheck(w http.ResponseWriter, req *http.Request) {
 	jsonResponse(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+// listPools lists the pools visible to the caller, optionally only those
+// of ?tenant=
 func (r *Router) listPools(w http.ResponseWriter, req *http.Request) {
 	pools, err := r.poolManager.ListPools()
 	if err != nil {
 		jsonError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	jsonResponse(w, http.StatusOK, pools)
+	tenant, filter := req.URL.Query()["tenant"]
+	visible := make([]manager.Pool, 0, len(pools))
+	for _, pool := range pools {
+		if inScope(req, pool.Tenant) && (!filter || pool.Tenant == tenant[0]) {
+			visible = append(visible, pool)
+		}
+	}
+	cachedResponse(w, req, visible)
 }
 
 func (r *Router) createPool(w http.ResponseWriter, req *http.Request) {
-	var reqBody struct {
-		Username   string `json:"username"`
-		PHPVersion string `json:"php_version"`
-		Provider   string `json:"provider"`
-	}
-
-	if err := json.NewDecoder(req.Body).Decode(&reqBody); err != nil {
-		jsonError(w, http.StatusBadRequest, "Invalid request body")
+	var reqBody apitypes.CreatePoolRequest
+	if !r.decodeBody(w, req, &reqBody) {
 		return
 	}
 
-	if reqBody.Username == "" {
-		jsonError(w, http.StatusBadRequest, "username is required")
+	var errs fieldErrors
+	errs.required("username", reqBody.Username)
+	errs.check("username", reqBody.Username, validation.Username)
+	errs.check("php_version", reqBody.PHPVersion, validation.PHPVersion)
+	errs.oneOf("provider", reqBody.Provider, providerNames...)
+	errs.check("profile", reqBody.Profile, validation.ProfileName)
+	errs.check("tenant", reqBody.Tenant, validation.TenantName)
+	if scope := tenantScope(req); scope != nil && reqBody.Tenant == "" && len(scope) == 1 {
+		// A key limited to one tenant creates its pools there
+		for name := range scope {
+			reqBody.Tenant = name
+		}
+	}
+	if !inScope(req, reqBody.Tenant) {
+		errs.add("tenant", "is not one of this API key's tenants")
+	}
+	t, err := target.Parse(reqBody.Target)
+	if err != nil {
+		errs.add("target", "%v", err)
+	}
+	userOpts := manager.UserOptions{Shell: reqBody.Shell, SSHKey: reqBody.SSHKey}
+	if !reqBody.CreateUser && (userOpts.Shell != "" || userOpts.SSHKey != "") {
+		errs.add("create_user", "must be true when shell or ssh_key is given")
+	}
+	if err := (manager.UserOptions{Shell: userOpts.Shell}).Validate(); err != nil {
+		errs.add("shell", "%v", err)
+	}
+	if err := (manager.UserOptions{SSHKey: userOpts.SSHKey}).Validate(); err != nil {
+		errs.add("ssh_key", "%v", err)
+	}
+	if errs.respond(w) {
 		return
 	}
 
@@ -98,14 +292,86 @@ func (r *Router) createPool(w http.ResponseWriter, req *http.Request) {
 		reqBody.Provider = "remi"
 	}
 
-	if err := r.poolManager.CreatePool(reqBody.Username, reqBody.PHPVersion, reqBody.Provider); err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+	pm := r.pools(req).WithTarget(t).WithTenant(reqBody.Tenant)
+	if reqBody.CreateUser {
+		err = pm.CreatePoolWithUser(reqBody.Username, reqBody.PHPVersion, reqBody.Provider, reqBody.Profile, userOpts)
+	} else {
+		err = pm.CreatePoolWithProfile(reqBody.Username, reqBody.PHPVersion, reqBody.Provider, reqBody.Profile)
+	}
+	if respondInvalid(w, err) {
+		return
+	}
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	response := apitypes.PoolResult{
+		Message:    "Pool created successfully",
+		Username:   reqBody.Username,
+		PHPVersion: reqBody.PHPVersion,
+		Provider:   reqBody.Provider,
+		Profile:    reqBody.Profile,
+		Tenant:     reqBody.Tenant,
+	}
+	if !t.IsHost() {
+		response.Target = t.String()
+	}
+	jsonResponse(w, http.StatusCreated, response)
+}
+
+// exportPoolBundle returns the export bundle of a pool, as written by
+// pool export-bundle
+func (r *Router) exportPoolBundle(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	// Buffered so that a failure is still answered with an error status
+	var bundle bytes.Buffer
+	if err := r.poolManager.ExportBundle(username, &bundle); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", username+".tar.gz"))
+	w.Write(bundle.Bytes())
+}
+
+func (r *Router) importPoolBundle(w http.ResponseWriter, req *http.Request) {
+	phpVersion := req.URL.Query().Get("php_version")
+
+	bundle, err := manager.ReadBundle(req.Body)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The bundled settings are checked like those of PUT /config
+	var errs fieldErrors
+	r.validateSettings(&errs, bundle.Settings, poolSettingsSchema)
+	r.checkAdminSettings(&errs, req, bundle.Metadata.Username, bundle.Settings, true, "settings.")
+	if errs.respond(w) {
+		return
+	}
+
+	report, err := r.pools(req).ImportBundle(bundle, phpVersion)
+	if respondInvalid(w, err) {
+		return
+	}
+	if err != nil {
+		errorResponse(w, err, nil)
 		return
 	}
 
-	jsonResponse(w, http.StatusCreated, map[string]string{
-		"message":  "Pool created successfully",
-		"username": reqBody.Username,
+	if phpVersion == "" {
+		phpVersion = bundle.Metadata.PHPVersion
+	}
+
+	jsonResponse(w, http.StatusCreated, map[string]interface{}{
+		"message":       "Pool imported successfully",
+		"username":      bundle.Metadata.Username,
+		"php_version":   phpVersion,
+		"provider":      bundle.Metadata.Provider,
+		"sites_created": report.SitesCreated,
+		"sites_skipped": report.SitesSkipped,
 	})
 }
 
@@ -113,34 +379,77 @@ func (r *Router) getPool(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	username := vars["username"]
 
-	pools, err := r.poolManager.ListPools()
+	pool, err := r.poolManager.FindPool(username)
 	if err != nil {
 		jsonError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	if pool == nil {
+		errorResponse(w, fmt.Errorf("%w: %s", manager.ErrPoolNotFound, username), nil)
+		return
+	}
+	// Disk usage is measured on every request and left out of the ETag
+	if notModified(w, req, contentETag(pool)) {
+		return
+	}
+	if pool.DiskUsage, err = r.poolManager.DiskUsage(username); err != nil {
+		pool.DiskUsage = &manager.DiskUsage{Error: err.Error()}
+	}
+	jsonResponse(w, http.StatusOK, pool)
+}
 
-	for _, pool := range pools {
-		if pool.User == username {
-			jsonResponse(w, http.StatusOK, pool)
-			return
+func (r *Router) deletePool(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	username := vars["username"]
+	purgeData := req.URL.Query().Get("purge_data") == "true"
+	removeUser := req.URL.Query().Get("remove_user") == "true"
+
+	// With If-Match, only delete the pool as the client last read it
+	var match func(*manager.Pool) bool
+	if header := req.Header.Get("If-Match"); header != "" {
+		match = func(pool *manager.Pool) bool {
+			return pool != nil && etagMatches(header, contentETag(pool), true)
 		}
 	}
 
-	jsonError(w, http.StatusNotFound, "Pool not found")
+	pm := r.pools(req)
+	if err := pm.DeletePoolIfMatch(username, purgeData, match); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	response := apitypes.PoolResult{
+		Message:  "Pool deleted successfully",
+		Username: username,
+	}
+	if removeUser {
+		action, err := pm.RemovePoolUser(username)
+		if err != nil {
+			jsonError(w, http.StatusInternalServerError, "Pool deleted but removing the user failed: "+err.Error())
+			return
+		}
+		response.User = action
+	}
+	jsonResponse(w, http.StatusOK, response)
 }
 
-func (r *Router) deletePool(w http.ResponseWriter, req *http.Request) {
+func (r *Router) getPoolConfig(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	username := vars["username"]
 
-	if err := r.poolManager.DeletePool(username); err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+	poolConfig, err := r.poolManager.GetPoolConfig(username)
+	if err != nil {
+		errorResponse(w, err, nil)
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, map[string]string{
-		"message":  "Pool deleted successfully",
+	if notModified(w, req, revisionETag(poolConfig.Revision)) {
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
 		"username": username,
+		"settings": poolConfig.Settings,
+		"revision": poolConfig.Revision,
 	})
 }
 
@@ -148,9 +457,13 @@ func (r *Router) updatePoolConfig(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	username := vars["username"]
 
+	revision, ok := ifMatchRevision(w, req)
+	if !ok {
+		return
+	}
+
 	var settings map[string]interface{}
-	if err := json.NewDecoder(req.Body).Decode(&settings); err != nil {
-		jsonError(w, http.StatusBadRequest, "Invalid request body")
+	if !r.decodeBody(w, req, &settings) {
 		return
 	}
 
@@ -159,53 +472,203 @@ func (r *Router) updatePoolConfig(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := r.poolManager.UpdatePoolConfig(username, settings); err != nil {
+	// PUT replaces the whole settings document; PATCH merges onto it and
+	// null removes a key
+	patch := req.Method == http.MethodPatch
+
+	var errs fieldErrors
+	r.validateSettings(&errs, withoutNulls(settings, patch), poolSettingsSchema)
+	r.checkAdminSettings(&errs, req, username, settings, !patch, "")
+	if errs.respond(w) {
+		return
+	}
+
+	pools := r.pools(req)
+	var newRevision int64
+	var err error
+	if patch {
+		newRevision, err = pools.PatchPoolConfigIfMatch(username, settings, revision)
+	} else {
+		newRevision, err = pools.UpdatePoolConfigIfMatch(username, settings, revision)
+	}
+	if respondInvalid(w, err) {
+		return
+	}
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	poolConfig, err := pools.GetPoolConfig(username)
+	if err != nil {
 		jsonError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
+	response := map[string]interface{}{
 		"message":  "Pool configuration updated successfully",
 		"username": username,
+		"settings": poolConfig.Settings,
+		"revision": newRevision,
+	}
+	if report, err := pools.CheckSharedMemory(); err == nil && len(report.Warnings) > 0 {
+		response["warnings"] = report.Warnings
+	}
+
+	w.Header().Set("ETag", revisionETag(newRevision))
+	jsonResponse(w, http.StatusOK, response)
+}
+
+func (r *Router) getState(w http.ResponseWriter, req *http.Request) {
+	snapshot, err := r.poolManager.Snapshot()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, snapshot)
+}
+
+func (r *Router) getPoolStatus(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	username := vars["username"]
+
+	status, err := r.poolManager.GetPoolStatus(username)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, status)
+}
+
+func (r *Router) resetPoolOpcache(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	username := vars["username"]
+
+	if err := r.poolManager.ResetOpcache(username); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message":  "OPcache reset successfully",
+		"username": username,
+	})
+}
+
+// testPool sends a FastCGI test request to the pool. The body is optional:
+// {"script": "/path/info.php"} requests that script as well.
+func (r *Router) testPool(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	var body struct {
+		Script string `json:"script"`
+	}
+	if req.ContentLength != 0 && !r.decodeBody(w, req, &body) {
+		return
+	}
+	if body.Script != "" && !strings.HasPrefix(body.Script, "/") {
+		var errs fieldErrors
+		errs.add("script", "must be an absolute path")
+		errs.respond(w)
+		return
+	}
+
+	result, err := r.poolManager.TestPool(username, body.Script)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, result)
+}
+
+func (r *Router) updatePHPOpcache(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	version := vars["version"]
+
+	providerParam := req.URL.Query().Get("provider")
+	if providerParam == "" {
+		providerParam = "remi"
+	}
+
+	var settings map[string]interface{}
+	if !r.decodeBody(w, req, &settings) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.oneOf("provider", providerParam, providerNames...)
+	r.validateSettings(&errs, settings, opcacheSettingsSchema)
+	if errs.respond(w) {
+		return
+	}
+
+	iniPath, err := r.packageManager.ConfigureOpcache(version, provider.ProviderType(providerParam), settings)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message":  "OPcache configuration updated successfully",
+		"version":  version,
+		"provider": providerParam,
+		"path":     iniPath,
 		"settings": settings,
 	})
 }
 
 func (r *Router) installPHP(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
-	version := vars["version"]
-	
+
 	// Check for provider parameter in query string
 	providerParam := req.URL.Query().Get("provider")
-	
-	var err error
-	if providerParam != "" {
-		// Use specific provider
-		providerType := provider.ProviderType(providerParam)
-		err = r.packageManager.InstallPHPWithProvider(version, providerType)
-	} else {
-		// Use default provider
-		err = r.packageManager.InstallPHP(version)
+
+	// Install inside a chroot or container instead of on the host
+	t, err := target.Parse(req.URL.Query().Get("target"))
+	if err != nil {
+		var errs fieldErrors
+		errs.add("target", "%v", err)
+		errs.respond(w)
+		return
+	}
+	packages := r.packages(req)
+	if !t.IsHost() {
+		if packages, err = packages.WithTarget(t); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 	}
 
+	// A constraint such as 8 installs the newest matching version
+	version, err := packages.ResolveVersion(vars["spec"], provider.ProviderType(providerParam))
+	if respondInvalid(w, err) {
+		return
+	}
 	if err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+		errorResponse(w, err, nil)
 		return
 	}
 
 	providerUsed := providerParam
 	if providerUsed == "" {
-		providerUsed = "remi" // default
+		providerUsed = packages.GetProvider().GetProviderType()
 	}
 
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"message":  "PHP installed successfully",
-		"version":  version,
-		"provider": providerUsed,
+	r.runInstall(w, req, packages, version, providerUsed, func(pm *manager.PackageManager) error {
+		if providerParam != "" {
+			// Use specific provider
+			return pm.InstallPHPWithProvider(version, provider.ProviderType(providerParam))
+		}
+		// Use default provider
+		return pm.InstallPHP(version)
 	})
 }
 
 func (r *Router) listPHPVersions(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Query().Get("refresh") == "true" {
+		r.packageManager.RefreshInstalledPHP()
+	}
 	// Get PHP versions from database (includes provider information)
 	dbVersions, err := r.poolManager.GetDatabase().ListPHPVersions()
 	if err != nil {
@@ -213,19 +676,24 @@ func (r *Router) listPHPVersions(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Convert to response format with provider information
-	versions := make([]map[string]string, 0, len(dbVersions))
+	// Convert to response format with provider information. With
+	// ?tenant= or a key limited to tenants, shared versions are listed
+	// with the tenants' own.
+	tenant, filter := req.URL.Query()["tenant"]
+	list := apitypes.PHPVersionList{Versions: make([]apitypes.PHPVersion, 0, len(dbVersions))}
 	for _, v := range dbVersions {
-		versions = append(versions, map[string]string{
-			"version":  v.Version,
-			"provider": v.PackageManager,
-			"status":   v.Status,
+		if v.Tenant != "" && (!inScope(req, v.Tenant) || (filter && v.Tenant != tenant[0])) {
+			continue
+		}
+		list.Versions = append(list.Versions, apitypes.PHPVersion{
+			Version:  v.Version,
+			Provider: v.PackageManager,
+			Status:   v.Status,
+			Tenant:   v.Tenant,
 		})
 	}
 
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"versions": versions,
-	})
+	cachedResponse(w, req, list)
 }
 
 func (r *Router) listAvailablePHP(w http.ResponseWriter, req *http.Request) {
@@ -234,6 +702,7 @@ func (r *Router) listAvailablePHP(w http.ResponseWriter, req *http.Request) {
 		jsonError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	w.Header().Set("Cache-Control", cacheCatalog)
 	jsonResponse(w, http.StatusOK, map[string]interface{}{
 		"versions": versions,
 	})
@@ -265,7 +734,14 @@ func (r *Router) listProviders(w http.ResponseWriter, req *http.Request) {
 			"description": "Docker-hosted PHP containers",
 			"status":      "stub",
 		},
+		{
+			"type":        "system",
+			"name":        "Distribution PHP",
+			"description": "Distro-native packages (AppStream module streams on RHEL, stock Debian packages), no third-party repositories",
+			"status":      "active",
+		},
 	}
+	w.Header().Set("Cache-Control", cacheCatalog)
 	jsonResponse(w, http.StatusOK, map[string]interface{}{
 		"providers": providers,
 	})
@@ -273,19 +749,20 @@ func (r *Router) listProviders(w http.ResponseWriter, req *http.Request) {
 
 func (r *Router) installPHPWithProvider(w http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
-	version := vars["version"]
 	providerTypeStr := vars["provider"]
-	
+
 	providerType := provider.ProviderType(providerTypeStr)
-	if err := r.packageManager.InstallPHPWithProvider(version, providerType); err != nil {
-		jsonError(w, http.StatusInternalServerError, err.Error())
+	packages := r.packages(req)
+	version, err := packages.ResolveVersion(vars["spec"], providerType)
+	if respondInvalid(w, err) {
 		return
 	}
-
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"message":  "PHP installed successfully",
-		"version":  version,
-		"provider": providerTypeStr,
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	r.runInstall(w, req, packages, version, providerTypeStr, func(pm *manager.PackageManager) error {
+		return pm.InstallPHPWithProvider(version, providerType)
 	})
 }
 
@@ -300,6 +777,9 @@ func (r *Router) listPHPVersionsByProvider(w http.ResponseWriter, req *http.Requ
 		return
 	}
 
+	if req.URL.Query().Get("refresh") == "true" {
+		r.packageManager.RefreshInstalledPHP()
+	}
 	versions, err := phpProvider.ListInstalledPHP()
 	if err != nil {
 		jsonError(w, http.StatusInternalServerError, err.Error())
@@ -310,7 +790,7 @@ func (r *Router) listPHPVersionsByProvider(w http.ResponseWriter, req *http.Requ
 		versions = []string{}
 	}
 	
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
+	cachedResponse(w, req, map[string]interface{}{
 		"provider": providerTypeStr,
 		"versions": versions,
 	})
@@ -333,6 +813,7 @@ func (r *Router) listAvailablePHPByProvider(w http.ResponseWriter, req *http.Req
 		return
 	}
 	
+	w.Header().Set("Cache-Control", cacheCatalog)
 	jsonResponse(w, http.StatusOK, map[string]interface{}{
 		"provider": providerTypeStr,
 		"versions": versions,
@@ -345,6 +826,52 @@ func jsonResponse(w http.ResponseWriter, status int, data interface{}) {
 	json.NewEncoder(w).Encode(data)
 }
 
-func jsonError(w http.ResponseWriter, status int, message string) {
-	jsonResponse(w, status, map[string]string{"error": message})
+// revisionETag formats a settings revision as a strong ETag
+func revisionETag(revision int64) string {
+	return fmt.Sprintf("\"%d\"", revision)
+}
+
+// ifMatchRevision reads the required If-Match header. "*" matches any
+// revision. A missing or malformed header is answered with 428/400.
+func ifMatchRevision(w http.ResponseWriter, req *http.Request) (int64, bool) {
+	header := strings.TrimSpace(req.Header.Get("If-Match"))
+	if header == "" {
+		jsonError(w, http.StatusPreconditionRequired, "If-Match header is required; GET the config first and send its ETag")
+		return 0, false
+	}
+	if header == "*" {
+		return manager.AnyRevision, true
+	}
+
+	revision, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(header, "W/"), `"`), 10, 64)
+	if err != nil || revision < 0 {
+		jsonError(w, http.StatusBadRequest, "Invalid If-Match header")
+		return 0, false
+	}
+	return revision, true
+}
+
+// pools returns the pool manager for a request, failing fast on busy locks
+// with ?no_wait=true and tracing under the request's span
+func (r *Router) pools(req *http.Request) *manager.PoolManager {
+	pm := r.poolManager
+	if req.URL.Query().Get("no_wait") == "true" {
+		pm = pm.WithNoWait()
+	}
+	return pm.WithContext(req.Context())
+}
+
+// packages returns the package manager for a request, failing fast on busy
+// locks with ?no_wait=true and tracing under the request's span
+func (r *Router) packages(req *http.Request) *manager.PackageManager {
+	pm := r.packageManager
+	if req.URL.Query().Get("no_wait") == "true" {
+		pm = pm.WithNoWait()
+	}
+	return pm.WithContext(req.Context())
+}
+
+// sites returns the site manager tracing under the request's span
+func (r *Router) sites(req *http.Request) *manager.SiteManager {
+	return r.siteManager.WithContext(req.Context())
 }
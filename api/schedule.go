@@ -0,0 +1,138 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/db"
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listScheduledChanges(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	var errs fieldErrors
+	errs.check("username", query.Get("username"), validation.Username)
+	errs.oneOf("status", query.Get("status"), db.ChangePending, db.ChangeRunning, db.ChangeApplied, db.ChangeFailed, db.ChangeCancelled)
+	if errs.respond(w) {
+		return
+	}
+
+	changes, err := r.poolManager.ListScheduledChanges(query.Get("username"), query.Get("status"))
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, changes)
+}
+
+func (r *Router) createScheduledChange(w http.ResponseWriter, req *http.Request) {
+	var reqBody apitypes.ScheduledChangeRequest
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.required("username", reqBody.Username)
+	errs.check("username", reqBody.Username, validation.Username)
+	r.validateScheduledChange(&errs, reqBody.Settings, reqBody.PHPVersion, reqBody.RunAt)
+	if len(reqBody.Settings) == 0 && reqBody.PHPVersion == "" {
+		errs.add("settings", "settings or php_version is required")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	var runAt time.Time
+	if reqBody.RunAt != nil {
+		runAt = *reqBody.RunAt
+	}
+	change, err := r.poolManager.ScheduleChange(reqBody.Username, reqBody.Settings, reqBody.PHPVersion, runAt)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, change)
+}
+
+func (r *Router) getScheduledChange(w http.ResponseWriter, req *http.Request) {
+	id, ok := scheduledChangeID(w, req)
+	if !ok {
+		return
+	}
+	change, err := r.poolManager.GetScheduledChange(id)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, change)
+}
+
+func (r *Router) updateScheduledChange(w http.ResponseWriter, req *http.Request) {
+	id, ok := scheduledChangeID(w, req)
+	if !ok {
+		return
+	}
+	var update manager.ChangeUpdate
+	if !r.decodeBody(w, req, &update) {
+		return
+	}
+
+	var errs fieldErrors
+	var settings map[string]interface{}
+	if update.Settings != nil {
+		settings = *update.Settings
+	}
+	phpVersion := ""
+	if update.PHPVersion != nil {
+		phpVersion = *update.PHPVersion
+	}
+	r.validateScheduledChange(&errs, settings, phpVersion, update.RunAt)
+	if errs.respond(w) {
+		return
+	}
+
+	change, err := r.poolManager.UpdateScheduledChange(id, update)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, change)
+}
+
+// cancelScheduledChange cancels a pending change; the record is kept
+func (r *Router) cancelScheduledChange(w http.ResponseWriter, req *http.Request) {
+	id, ok := scheduledChangeID(w, req)
+	if !ok {
+		return
+	}
+	change, err := r.poolManager.CancelScheduledChange(id)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, change)
+}
+
+func (r *Router) validateScheduledChange(errs *fieldErrors, settings map[string]interface{}, phpVersion string, runAt *time.Time) {
+	errs.check("php_version", phpVersion, validation.PHPVersion)
+	r.validateProfileSettings(errs, withoutNulls(settings, true))
+	if runAt != nil && runAt.Before(time.Now().Add(-time.Minute)) {
+		errs.add("run_at", "must not be in the past")
+	}
+}
+
+func scheduledChangeID(w http.ResponseWriter, req *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+	if err != nil {
+		var errs fieldErrors
+		errs.add("id", "must be a scheduled change id")
+		errs.respond(w)
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/cron"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listSchedules(w http.ResponseWriter, req *http.Request) {
+	schedules, err := r.scheduler.ListSchedules()
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, schedules)
+}
+
+func (r *Router) createSchedule(w http.ResponseWriter, req *http.Request) {
+	var reqBody apitypes.ScheduleRequest
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.required("name", reqBody.Name)
+	errs.check("name", reqBody.Name, validation.ScheduleName)
+	errs.required("task", reqBody.Task)
+	errs.oneOf("task", reqBody.Task, "verify-config", "php-upgrade", "prune", "backup")
+	errs.required("cron", reqBody.Cron)
+	errs.check("cron", reqBody.Cron, func(expr string) error {
+		_, err := cron.Parse(expr)
+		return err
+	})
+	if errs.respond(w) {
+		return
+	}
+
+	schedule, err := r.scheduler.CreateSchedule(reqBody.Name, reqBody.Task, reqBody.Cron)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, schedule)
+}
+
+func (r *Router) getSchedule(w http.ResponseWriter, req *http.Request) {
+	schedule, err := r.scheduler.GetSchedule(mux.Vars(req)["name"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, schedule)
+}
+
+// deleteSchedule removes a schedule created through the API; those of the
+// config file answer 409
+func (r *Router) deleteSchedule(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	if err := r.scheduler.DeleteSchedule(name); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message": "Schedule deleted",
+		"name":    name,
+	})
+}
+
+func (r *Router) listScheduleRuns(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	limit := 20
+	if v := req.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs.add("limit", "must be a positive number")
+		}
+		limit = n
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	runs, err := r.scheduler.ListRuns(mux.Vars(req)["name"], limit)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, runs)
+}
+
+// runSchedule starts a schedule's task now and answers 202 with the run,
+// which GET .../runs follows
+func (r *Router) runSchedule(w http.ResponseWriter, req *http.Request) {
+	run, err := r.scheduler.Start(mux.Vars(req)["name"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusAccepted, run)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/dns"
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listSites(w http.ResponseWriter, req *http.Request) {
+	sites, err := r.siteManager.ListSites()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, sites)
+}
+
+func (r *Router) createSite(w http.ResponseWriter, req *http.Request) {
+	var reqBody apitypes.CreateSiteRequest
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.required("domain", reqBody.Domain)
+	errs.required("username", reqBody.Username)
+	errs.required("document_root", reqBody.DocumentRoot)
+	errs.check("php_version", reqBody.PHPVersion, validation.PHPVersion)
+	if errs.respond(w) {
+		return
+	}
+
+	sm := r.sites(req)
+	site, err := sm.CreateSite(reqBody.Domain, reqBody.Username, reqBody.DocumentRoot, reqBody.PHPVersion)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// DNS problems are reported with the site, they do not fail its creation
+	if reqBody.CheckDNS || reqBody.UpdateDNS {
+		status, err := sm.CheckDNS(site.Domain)
+		if err == nil && reqBody.UpdateDNS && !status.PointsHere {
+			status, err = sm.UpdateDNS(site.Domain)
+		}
+		if err != nil {
+			if status == nil {
+				status = &manager.SiteDNS{Check: &dns.Check{Domain: site.Domain}}
+			}
+			status.Warnings = append(status.Warnings, err.Error())
+		}
+		site.DNS = status
+	}
+
+	// A failed issuance is recorded on the site's certificate
+	if reqBody.Certificate {
+		dnsStatus := site.DNS
+		sm.IssueCertificate(site.Domain)
+		if site, err = sm.GetSite(site.Domain); err != nil {
+			errorResponse(w, err, nil)
+			return
+		}
+		site.DNS = dnsStatus
+	}
+
+	jsonResponse(w, http.StatusCreated, site)
+}
+
+// issueSiteCertificate obtains or renews a site's ACME certificate now
+func (r *Router) issueSiteCertificate(w http.ResponseWriter, req *http.Request) {
+	cert, err := r.sites(req).IssueCertificate(mux.Vars(req)["domain"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, cert)
+}
+
+// listCertificates returns the certificates of all sites, soonest expiry
+// first
+func (r *Router) listCertificates(w http.ResponseWriter, req *http.Request) {
+	certificates, err := r.siteManager.ListCertificates()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"certificates": certificates})
+}
+
+// renewCertificates renews the certificates that expire within
+// acme.renew_before now, as the server does on its own, and returns the
+// result of each renewal
+func (r *Router) renewCertificates(w http.ResponseWriter, req *http.Request) {
+	renewed, err := r.sites(req).RenewCertificates(time.Now())
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	if renewed == nil {
+		renewed = []manager.Certificate{}
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{"certificates": renewed})
+}
+
+// checkSiteDNS reports whether a site's domain resolves to this server
+func (r *Router) checkSiteDNS(w http.ResponseWriter, req *http.Request) {
+	status, err := r.siteManager.CheckDNS(mux.Vars(req)["domain"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, status)
+}
+
+// updateSiteDNS points a site's A/AAAA records at this server through the
+// configured DNS provider
+func (r *Router) updateSiteDNS(w http.ResponseWriter, req *http.Request) {
+	status, err := r.sites(req).UpdateDNS(mux.Vars(req)["domain"])
+	if err != nil {
+		var details interface{}
+		if status != nil && len(status.Changes) > 0 {
+			details = map[string]interface{}{"changes": status.Changes}
+		}
+		errorResponse(w, err, details)
+		return
+	}
+	jsonResponse(w, http.StatusOK, status)
+}
+
+func (r *Router) getSite(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	site, err := r.siteManager.GetSite(vars["domain"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, site)
+}
+
+// getSiteSnippet returns the nginx snippet of a site as it would be
+// written now
+func (r *Router) getSiteSnippet(w http.ResponseWriter, req *http.Request) {
+	domain := mux.Vars(req)["domain"]
+	snippet, err := r.siteManager.RenderSnippet(domain)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"domain":  domain,
+		"snippet": snippet,
+	})
+}
+
+func (r *Router) deleteSite(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	domain := vars["domain"]
+
+	if err := r.sites(req).DeleteSite(domain); err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message": "Site deleted successfully",
+		"domain":  domain,
+	})
+}
+
+func (r *Router) bindSitePath(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	var reqBody struct {
+		Path       string `json:"path"`
+		PHPVersion string `json:"php_version"`
+	}
+
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.required("path", reqBody.Path)
+	errs.required("php_version", reqBody.PHPVersion)
+	errs.check("php_version", reqBody.PHPVersion, validation.PHPVersion)
+	if errs.respond(w) {
+		return
+	}
+
+	site, err := r.sites(req).BindPath(vars["domain"], reqBody.Path, reqBody.PHPVersion)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, site)
+}
+
+func (r *Router) unbindSitePath(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+
+	path := req.URL.Query().Get("path")
+	if path == "" {
+		jsonError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+
+	site, err := r.sites(req).UnbindPath(vars["domain"], path)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, site)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/manager"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// putPool makes the user's pool match the full spec in the body. The
+// response says whether the pool was created, updated or left unchanged.
+func (r *Router) putPool(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	var spec manager.PoolSpec
+	if !r.decodeBody(w, req, &spec) {
+		return
+	}
+
+	var errs fieldErrors
+	if spec.Username != "" && spec.Username != username {
+		errs.add("username", "must match the pool in the URL")
+	}
+	spec.Username = username
+	errs.check("username", spec.Username, validation.Username)
+	errs.required("php_version", spec.PHPVersion)
+	errs.check("php_version", spec.PHPVersion, validation.PHPVersion)
+	errs.oneOf("provider", spec.Provider, providerNames...)
+	if _, err := target.Parse(spec.Target); err != nil {
+		errs.add("target", "%v", err)
+	}
+	r.validateProfileSettings(&errs, spec.Settings)
+	r.checkAdminSettings(&errs, req, username, spec.Settings, true, "settings.")
+	if errs.respond(w) {
+		return
+	}
+
+	// If-Match is optional; when sent, the spec only applies to that
+	// settings revision of an existing pool
+	expected := manager.AnyRevision
+	if req.Header.Get("If-Match") != "" {
+		var ok bool
+		if expected, ok = ifMatchRevision(w, req); !ok {
+			return
+		}
+	}
+
+	resource, err := r.pools(req).ApplyPoolSpecIfMatch(spec, expected)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	status := http.StatusOK
+	if resource.Result == manager.SpecCreated {
+		status = http.StatusCreated
+	}
+	w.Header().Set("ETag", revisionETag(resource.Revision))
+	jsonResponse(w, status, resource)
+}
+
+func (r *Router) getPoolSpec(w http.ResponseWriter, req *http.Request) {
+	resource, err := r.poolManager.GetPoolResource(mux.Vars(req)["username"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	if notModified(w, req, revisionETag(resource.Revision)) {
+		return
+	}
+	jsonResponse(w, http.StatusOK, resource)
+}
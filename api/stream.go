@@ -0,0 +1,177 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/events"
+	"lightweight-php/manager"
+	"lightweight-php/ws"
+)
+
+const (
+	// poolWatchInterval is how often pool records are compared to catch
+	// changes made outside the API server, e.g. from the CLI
+	poolWatchInterval = 2 * time.Second
+	// streamBuffer is how many events a slow client may fall behind by
+	// before it starts missing them
+	streamBuffer = 256
+	// streamWriteTimeout drops clients that stop reading
+	streamWriteTimeout = 10 * time.Second
+	// streamPingInterval keeps idle connections open through proxies
+	streamPingInterval = 30 * time.Second
+)
+
+// streamEvents upgrades to a WebSocket and pushes events as JSON text
+// messages until the client disconnects
+func (r *Router) streamEvents(w http.ResponseWriter, req *http.Request) {
+	allowed, ok := streamAccess(req, config.Get().API.StreamTokens)
+	if !ok {
+		jsonError(w, http.StatusUnauthorized, "A valid stream token is required")
+		return
+	}
+
+	// Clients may narrow the stream further, but not widen their token
+	users := allowed
+	if requested := req.URL.Query().Get("users"); requested != "" {
+		users = make(map[string]bool)
+		for _, u := range strings.Split(requested, ",") {
+			if u = strings.TrimSpace(u); u != "" && (allowed == nil || allowed[u]) {
+				users[u] = true
+			}
+		}
+	}
+
+	conn, err := ws.Upgrade(w, req)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer conn.Close()
+
+	sub := events.Default.Subscribe(streamBuffer)
+	defer sub.Close()
+	r.watchOnce.Do(func() { go r.watchPools() })
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-conn.Done():
+			return
+		case e := <-sub.C:
+			// Events without a pool user (installs) are host-wide and only
+			// go to unrestricted clients
+			if users != nil && !users[e.Username] {
+				continue
+			}
+			message, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if err := conn.WriteText(message, streamWriteTimeout); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteText([]byte(`{"type":"ping"}`), streamWriteTimeout); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamAccess checks the request's token and returns the pool users it is
+// limited to (nil for all). With no tokens configured, access is open.
+func streamAccess(req *http.Request, tokens []config.StreamToken) (map[string]bool, bool) {
+	if len(tokens) == 0 {
+		return nil, true
+	}
+
+	// Browsers cannot set headers on a WebSocket, so a query parameter is
+	// accepted as well
+	token := req.URL.Query().Get("token")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token == "" {
+		return nil, false
+	}
+
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) != 1 {
+			continue
+		}
+		if len(t.Users) == 0 {
+			return nil, true
+		}
+		users := make(map[string]bool, len(t.Users))
+		for _, u := range t.Users {
+			users[u] = true
+		}
+		return users, true
+	}
+	return nil, false
+}
+
+// watchPools publishes pool.created, pool.deleted and pool.status events by
+// comparing pool records, so changes from any process reach the stream
+func (r *Router) watchPools() {
+	var known map[string]manager.Pool
+	for {
+		if events.Default.Subscribers() == 0 {
+			// Start from a fresh baseline when the next client connects
+			known = nil
+			time.Sleep(poolWatchInterval)
+			continue
+		}
+
+		pools, err := r.poolManager.ListPools()
+		if err != nil {
+			log.Printf("Event stream: %v", err)
+			time.Sleep(poolWatchInterval)
+			continue
+		}
+		current := make(map[string]manager.Pool, len(pools))
+		for _, p := range pools {
+			current[p.User] = p
+		}
+
+		if known != nil {
+			for user, p := range current {
+				old, existed := known[user]
+				switch {
+				case !existed:
+					events.Publish(events.PoolCreated, user, poolEventData(p))
+				case old.Status != p.Status:
+					data := poolEventData(p)
+					data["previous_status"] = old.Status
+					events.Publish(events.PoolStatus, user, data)
+				}
+			}
+			for user, p := range known {
+				if _, ok := current[user]; !ok {
+					events.Publish(events.PoolDeleted, user, poolEventData(p))
+				}
+			}
+		}
+		known = current
+		time.Sleep(poolWatchInterval)
+	}
+}
+
+func poolEventData(p manager.Pool) map[string]interface{} {
+	data := map[string]interface{}{
+		"php_version": p.PHPVersion,
+		"provider":    p.Provider,
+		"status":      p.Status,
+	}
+	if p.Target != "" {
+		data["target"] = p.Target
+	}
+	return data
+}
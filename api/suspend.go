@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"lightweight-php/db"
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+// suspendPool stops serving PHP for a pool. The body is optional:
+// {"reason": "unpaid invoice"} is stored with the suspension.
+func (r *Router) suspendPool(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if req.ContentLength != 0 && !r.decodeBody(w, req, &body) {
+		return
+	}
+
+	if err := r.pools(req).SuspendPool(username, body.Reason); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message":  "Pool suspended successfully",
+		"username": username,
+		"status":   db.PoolSuspended,
+	})
+}
+
+func (r *Router) unsuspendPool(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	pm := r.pools(req)
+	if err := pm.UnsuspendPool(username); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	pool, err := pm.GetDatabase().GetPool(username)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if pool == nil {
+		errorResponse(w, fmt.Errorf("%w: %s", manager.ErrPoolNotFound, username), nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message":  "Pool unsuspended successfully",
+		"username": username,
+		"status":   pool.Status,
+	})
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/target"
+)
+
+// getSystemInfo returns the OS release and architecture of the host, or
+// with ?target= of a container
+func (r *Router) getSystemInfo(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	t, err := target.Parse(req.URL.Query().Get("target"))
+	if err != nil {
+		errs.add("target", "%v", err)
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	packages := r.packages(req)
+	if !t.IsHost() {
+		if packages, err = packages.WithTarget(t); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	info, err := packages.SystemInfo()
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, info)
+}
+
+// getSystem returns the host's OS, capacity, init system and webservers
+// and how many PHP versions and pools it manages
+func (r *Router) getSystem(w http.ResponseWriter, req *http.Request) {
+	status, err := r.pools(req).SystemStatus()
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, status)
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"net/http"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listTenants(w http.ResponseWriter, req *http.Request) {
+	tenants, err := r.poolManager.ListTenants()
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := make([]manager.Tenant, 0, len(tenants))
+	for _, t := range tenants {
+		if inScope(req, t.Name) {
+			visible = append(visible, t)
+		}
+	}
+	jsonResponse(w, http.StatusOK, visible)
+}
+
+func (r *Router) getTenant(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+	if !inScope(req, name) {
+		jsonError(w, http.StatusNotFound, manager.ErrTenantNotFound.Error()+": "+name)
+		return
+	}
+
+	tenant, err := r.poolManager.GetTenant(name)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, tenant)
+}
+
+func (r *Router) createTenant(w http.ResponseWriter, req *http.Request) {
+	var reqBody apitypes.TenantRequest
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.required("name", reqBody.Name)
+	errs.check("name", reqBody.Name, validation.TenantName)
+	r.validateProfileSettings(&errs, reqBody.Settings)
+	if errs.respond(w) {
+		return
+	}
+
+	tenant := manager.Tenant{
+		Name:        reqBody.Name,
+		Description: reqBody.Description,
+		Settings:    reqBody.Settings,
+	}
+	if err := r.poolManager.CreateTenant(&tenant); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	created, err := r.poolManager.GetTenant(tenant.Name)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusCreated, created)
+}
+
+// updateTenant replaces a tenant's description and default settings; the
+// name in the path wins over one in the body
+func (r *Router) updateTenant(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	var reqBody apitypes.TenantRequest
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	if reqBody.Name != "" && reqBody.Name != name {
+		errs.add("name", "does not match the tenant in the path")
+	}
+	r.validateProfileSettings(&errs, reqBody.Settings)
+	if errs.respond(w) {
+		return
+	}
+
+	tenant := manager.Tenant{
+		Name:        name,
+		Description: reqBody.Description,
+		Settings:    reqBody.Settings,
+	}
+	if err := r.poolManager.UpdateTenant(&tenant); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	updated, err := r.poolManager.GetTenant(name)
+	if err != nil {
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, updated)
+}
+
+func (r *Router) deleteTenant(w http.ResponseWriter, req *http.Request) {
+	name := mux.Vars(req)["name"]
+
+	if err := r.poolManager.DeleteTenant(name); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message": "Tenant deleted successfully",
+		"name":    name,
+	})
+}
+
+// setPoolTenant moves a pool into a tenant, or out of any with ""
+func (r *Router) setPoolTenant(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	var reqBody struct {
+		Tenant string `json:"tenant"`
+	}
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.check("tenant", reqBody.Tenant, validation.TenantName)
+	if !inScope(req, reqBody.Tenant) {
+		errs.add("tenant", "is not one of this API key's tenants")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	if err := r.pools(req).SetPoolTenant(username, reqBody.Tenant); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message":  "Pool tenant updated successfully",
+		"username": username,
+		"tenant":   reqBody.Tenant,
+	})
+}
+
+// setPHPVersionTenant reserves an installed PHP version for a tenant, or
+// shares it again with ""
+func (r *Router) setPHPVersionTenant(w http.ResponseWriter, req *http.Request) {
+	version := mux.Vars(req)["version"]
+
+	var reqBody struct {
+		Tenant string `json:"tenant"`
+	}
+	if !r.decodeBody(w, req, &reqBody) {
+		return
+	}
+
+	var errs fieldErrors
+	errs.check("tenant", reqBody.Tenant, validation.TenantName)
+	if errs.respond(w) {
+		return
+	}
+
+	if err := r.pools(req).SetPHPVersionTenant(version, reqBody.Tenant); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message": "PHP version tenant updated successfully",
+		"version": version,
+		"tenant":  reqBody.Tenant,
+	})
+}
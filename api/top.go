@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lightweight-php/manager"
+)
+
+// getTop samples every pool's worker CPU and RSS from /proc and its status
+// page counters. CPU usage is measured over interval (default 1s, at most
+// 10s), so the request takes that long.
+func (r *Router) getTop(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	query := req.URL.Query()
+	interval := time.Second
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 100*time.Millisecond || d > 10*time.Second {
+			errs.add("interval", "must be a duration between 100ms and 10s")
+		}
+		interval = d
+	}
+	sortBy := query.Get("sort")
+	errs.oneOf("sort", sortBy, manager.TopSorts...)
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errs.add("limit", "must be a positive number")
+		}
+		limit = n
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	report, err := r.pools(req).Top(interval, sortBy)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	if limit > 0 && len(report.Pools) > limit {
+		report.Pools = report.Pools[:limit]
+	}
+	jsonResponse(w, http.StatusOK, report)
+}
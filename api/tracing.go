@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"lightweight-php/audit"
+	"lightweight-php/tracing"
+)
+
+// traceRequests records a server span per request, named after the matched
+// route, and continues the caller's trace when it sends a traceparent
+// header. The trace ID is returned in X-Trace-Id.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !tracing.Enabled() {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		name := routeTemplate(req)
+		ctx := tracing.WithTraceparent(req.Context(), req.Header.Get("traceparent"))
+		ctx, span := tracing.StartKind(ctx, req.Method+" "+name, tracing.KindServer)
+		defer span.End()
+		span.SetAttr("http.request.method", req.Method)
+		span.SetAttr("http.route", name)
+		span.SetAttr("url.path", req.URL.Path)
+		if id := audit.FromContext(req.Context()).RequestID; id != "" {
+			span.SetAttr("http.request_id", id)
+		}
+		if span != nil {
+			w.Header().Set("X-Trace-Id", span.TraceID())
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, req.WithContext(ctx))
+		span.SetAttr("http.response.status_code", rec.status)
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(rec.status)))
+		}
+	})
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Hijack lets the event stream take over the connection
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response does not support hijacking")
+	}
+	s.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
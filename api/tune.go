@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// getPoolTuning returns the recommended process manager settings without
+// applying them
+func (r *Router) getPoolTuning(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	rec, err := r.poolManager.TunePool(username, false)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, rec)
+}
+
+func (r *Router) tunePool(w http.ResponseWriter, req *http.Request) {
+	username := mux.Vars(req)["username"]
+
+	rec, err := r.pools(req).TunePool(username, true)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, rec)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+
+	"github.com/gorilla/mux"
+)
+
+// uninstallPHP removes a PHP version of a provider. Pools on the version
+// block it with 409 unless migrate_to moves them to another version first.
+func (r *Router) uninstallPHP(w http.ResponseWriter, req *http.Request) {
+	version := mux.Vars(req)["version"]
+	query := req.URL.Query()
+	migrateTo := query.Get("migrate_to")
+
+	var errs fieldErrors
+	t, err := target.Parse(query.Get("target"))
+	if err != nil {
+		errs.add("target", "%v", err)
+	}
+	if migrateTo == version {
+		errs.add("migrate_to", "must differ from the version being uninstalled")
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	packages := r.packages(req)
+	if !t.IsHost() {
+		if packages, err = packages.WithTarget(t); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	providerType := provider.ProviderType(query.Get("provider"))
+	if providerType == "" {
+		providerType = provider.ProviderType(packages.GetProvider().GetProviderType())
+	}
+
+	var migrated []string
+	if migrateTo != "" {
+		if migrated, err = r.pools(req).WithTarget(t).MigrateVersionPools(version, providerType, migrateTo); err != nil {
+			errorResponse(w, err, map[string]interface{}{"migrated": migrated})
+			return
+		}
+	}
+
+	if err := packages.UninstallPHP(version, providerType); err != nil {
+		var inUse *manager.VersionInUseError
+		if errors.As(err, &inUse) {
+			errorResponse(w, err, map[string]interface{}{"pools": inUse.Pools})
+			return
+		}
+		errorResponse(w, err, nil)
+		return
+	}
+
+	response := apitypes.UninstallResult{
+		Message:  "PHP uninstalled successfully",
+		Version:  version,
+		Provider: string(providerType),
+	}
+	if migrateTo != "" {
+		response.Migrated = migrated
+		response.MigratedTo = migrateTo
+	}
+	jsonResponse(w, http.StatusOK, response)
+}
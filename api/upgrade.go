@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/gorilla/mux"
+)
+
+// upgradePHP upgrades one version's packages within its branch and
+// restarts its FPM service
+func (r *Router) upgradePHP(w http.ResponseWriter, req *http.Request) {
+	version := mux.Vars(req)["version"]
+
+	var errs fieldErrors
+	providerParam := req.URL.Query().Get("provider")
+	errs.oneOf("provider", providerParam, providerNames...)
+	packages, ok := r.targetPackages(w, req, &errs)
+	if !ok {
+		return
+	}
+	providerType := provider.ProviderType(providerParam)
+	if providerType == "" {
+		providerType = provider.ProviderType(packages.GetProvider().GetProviderType())
+	}
+
+	if err := packages.UpgradePHP(version, providerType); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, manager.UpgradeResult{Version: version, Provider: string(providerType)})
+}
+
+// upgradeAllPHP upgrades every installed version whose provider can
+// upgrade in place. Failures of single versions are reported in the
+// results rather than stopping the others.
+func (r *Router) upgradeAllPHP(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	packages, ok := r.targetPackages(w, req, &errs)
+	if !ok {
+		return
+	}
+
+	results, err := packages.UpgradeAllPHP()
+	if err != nil {
+		errorResponse(w, err, map[string]interface{}{"results": results})
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"message": fmt.Sprintf("Upgraded %d PHP versions", len(results)),
+		"results": results,
+	})
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+	"lightweight-php/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// FieldError describes one invalid field in a request body
+type FieldError = validation.FieldError
+
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *fieldErrors) required(field, value string) {
+	if value == "" {
+		e.add(field, "is required")
+	}
+}
+
+// check runs a validation function on an optional string field
+func (e *fieldErrors) check(field, value string, validate func(string) error) {
+	if value == "" {
+		return
+	}
+	if err := validate(value); err != nil {
+		e.add(field, "%s", err)
+	}
+}
+
+// match checks an optional string field against a pattern
+func (e *fieldErrors) match(field, value string, pattern *regexp.Regexp, hint string) {
+	if value != "" && !pattern.MatchString(value) {
+		e.add(field, "%s", hint)
+	}
+}
+
+func (e *fieldErrors) oneOf(field, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	e.add(field, "must be one of: %s", strings.Join(allowed, ", "))
+}
+
+// respond writes a 422 response when there are errors and reports whether it did
+func (e fieldErrors) respond(w http.ResponseWriter) bool {
+	if len(e) == 0 {
+		return false
+	}
+	sort.SliceStable(e, func(i, j int) bool { return e[i].Field < e[j].Field })
+	// Details has the reason by field, several reasons of a field joined
+	reasons := make(map[string]string, len(e))
+	for _, f := range e {
+		if reason, ok := reasons[f.Field]; ok {
+			reasons[f.Field] = reason + "; " + f.Message
+		} else {
+			reasons[f.Field] = f.Message
+		}
+	}
+	jsonResponse(w, http.StatusUnprocessableEntity, apitypes.Error{
+		Error:   "request validation failed",
+		Code:    "validation_failed",
+		Message: "request validation failed",
+		Fields:  e,
+		Details: reasons,
+	})
+	return true
+}
+
+// respondInvalid writes the fields of a validation error returned by a
+// manager as a 422 response and reports whether it did
+func respondInvalid(w http.ResponseWriter, err error) bool {
+	var invalid validation.Errors
+	if !errors.As(err, &invalid) {
+		return false
+	}
+	return fieldErrors(invalid).respond(w)
+}
+
+var providerNames = []string{"remi", "lsphp", "alt-php", "docker", "system"}
+
+// pathVarChecks validate route variables before a handler sees them, since
+// they end up in file paths and commands
+var pathVarChecks = map[string]func(string) error{
+	"username": validation.Username,
+	"version":  validation.PHPVersion,
+	"spec":     validation.PHPVersionSpec,
+	"domain":   validation.Domain,
+	"name":     validation.ProfileName,
+}
+
+// pathVarFields names route variables in errors where the documented
+// parameter differs from the variable
+var pathVarFields = map[string]string{"spec": "version"}
+
+// validatePathVars answers 422 for a request whose route variables are
+// malformed
+func validatePathVars(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var errs fieldErrors
+		for name, value := range mux.Vars(req) {
+			if validate, ok := pathVarChecks[name]; ok {
+				if err := validate(value); err != nil {
+					field := name
+					if f, ok := pathVarFields[name]; ok {
+						field = f
+					}
+					errs.add(field, "%s", err)
+				}
+			}
+		}
+		if name, ok := mux.Vars(req)["provider"]; ok {
+			errs.oneOf("provider", name, providerNames...)
+		}
+		if errs.respond(w) {
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// decodeBody decodes a JSON request body into v. Unknown fields are rejected
+// unless relaxed validation is enabled. Malformed JSON yields 400 and
+// type mismatches or unknown fields 422; it reports whether decoding succeeded.
+func (r *Router) decodeBody(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(req.Body)
+	if !r.relaxed() {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var errs fieldErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "(body)"
+		}
+		errs.add(field, "must be %s, got %s", jsonTypeName(typeErr.Type.Kind().String()), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		errs.add(field, "unknown field")
+	case errors.Is(err, io.EOF):
+		jsonError(w, http.StatusBadRequest, "Request body is empty")
+		return false
+	default:
+		jsonError(w, http.StatusBadRequest, "Invalid request body")
+		return false
+	}
+	errs.respond(w)
+	return false
+}
+
+func jsonTypeName(kind string) string {
+	switch kind {
+	case "string":
+		return "a string"
+	case "bool":
+		return "a boolean"
+	case "map", "struct":
+		return "an object"
+	case "slice", "array":
+		return "an array"
+	}
+	return "a number"
+}
+
+// settingKind is the accepted JSON type of a settings map entry
+type settingKind int
+
+const (
+	kindString         settingKind = iota
+	kindCount                      // non-negative integer
+	kindStringOrNumber             // ini value such as "128M" or 30
+	kindFlag                       // bool, 0/1 or on/off
+	kindStringList                 // array of strings or a comma-separated string
+	kindSize                       // size such as "256M" or "1.5G", or a number of bytes
+	kindDuration                   // duration such as "90s" or "2m", or a number of seconds
+	kindEnv                        // object of environment variable names and string values
+	kindPoolLines                  // raw pool directives as a string of lines or an array of lines
+)
+
+var poolSettingsSchema = map[string]settingKind{
+	"max_children":                  kindCount,
+	"start_servers":                 kindCount,
+	"min_spare_servers":             kindCount,
+	"max_spare_servers":             kindCount,
+	"max_requests":                  kindCount,
+	"process_manager":               kindString,
+	"memory_limit":                  kindSize,
+	"max_execution_time":            kindDuration,
+	"upload_max_filesize":           kindSize,
+	"post_max_size":                 kindSize,
+	"display_errors":                kindFlag,
+	"log_errors":                    kindFlag,
+	"date_timezone":                 kindString,
+	"sendmail_path":                 kindString,
+	"process_idle_timeout":          kindDuration,
+	"listen_mode":                   kindString,
+	"listen_type":                   kindString,
+	"listen_address":                kindString,
+	"listen_port":                   kindCount,
+	"allowed_clients":               kindStringList,
+	"opcache_memory_consumption":    kindStringOrNumber,
+	"opcache_max_accelerated_files": kindStringOrNumber,
+	"opcache_validate_timestamps":   kindFlag,
+	"opcache_jit":                   kindStringOrNumber,
+	"apcu_enabled":                  kindFlag,
+	"apcu_shm_size":                 kindStringOrNumber,
+	"security_level":                kindString,
+	"disable_functions_allow":       kindStringList,
+	"disable_functions_extra":       kindStringList,
+	"image_extensions":              kindStringList,
+	"allow_url_fopen":               kindFlag,
+	"auto_tune":                     kindFlag,
+	"burst_max_children":            kindCount,
+	"disk_quota":                    kindSize,
+	"clear_env":                     kindFlag,
+	"env":                           kindEnv,
+	"custom_config":                 kindPoolLines,
+}
+
+// adminSettings are the pool settings a key limited to tenants may not
+// change: custom_config can set anything the structured settings restrict
+var adminSettings = []string{"custom_config"}
+
+// settingChoices restricts string settings to a fixed set of values
+var settingChoices = map[string][]string{
+	"process_manager": {"static", "dynamic", "ondemand"},
+	"security_level":  manager.SecurityLevels,
+	"listen_type":     {manager.ListenSocket, manager.ListenTCP},
+}
+
+var opcacheSettingsSchema = map[string]settingKind{
+	"memory_consumption":    kindStringOrNumber,
+	"max_accelerated_files": kindStringOrNumber,
+	"validate_timestamps":   kindFlag,
+	"jit":                   kindStringOrNumber,
+	"jit_buffer_size":       kindStringOrNumber,
+}
+
+// validateSettings checks a settings map against a schema. Unknown keys are
+// only reported in strict mode.
+func (r *Router) validateSettings(errs *fieldErrors, settings map[string]interface{}, schema map[string]settingKind) {
+	for key, value := range settings {
+		kind, known := schema[key]
+		if !known {
+			if !r.relaxed() {
+				errs.add(key, "unknown setting")
+			}
+			continue
+		}
+
+		switch kind {
+		case kindString:
+			s, ok := value.(string)
+			if !ok {
+				errs.add(key, "must be a string")
+			} else if choices, restricted := settingChoices[key]; restricted {
+				errs.oneOf(key, s, choices...)
+			}
+		case kindCount:
+			v, ok := value.(float64)
+			if !ok {
+				errs.add(key, "must be an integer")
+				continue
+			}
+			var bounds validation.Errors
+			validation.SettingCount(&bounds, key, v)
+			*errs = append(*errs, bounds...)
+		case kindStringOrNumber:
+			switch value.(type) {
+			case string, float64:
+			default:
+				errs.add(key, "must be a string or number")
+			}
+		case kindFlag:
+			switch v := value.(type) {
+			case bool, float64:
+			case string:
+				switch strings.ToLower(v) {
+				case "1", "0", "on", "off", "true", "false", "yes", "no":
+				default:
+					errs.add(key, "must be a boolean or one of on/off, 1/0")
+				}
+			default:
+				errs.add(key, "must be a boolean or one of on/off, 1/0")
+			}
+		case kindSize:
+			if _, err := manager.NormalizeSize(key, value); err != nil {
+				errs.add(key, "%s", strings.TrimPrefix(err.Error(), "invalid "+key+": "))
+			}
+		case kindDuration:
+			if _, err := manager.NormalizeDuration(key, value); err != nil {
+				errs.add(key, "%s", strings.TrimPrefix(err.Error(), "invalid "+key+": "))
+			}
+		case kindEnv:
+			env, ok := value.(map[string]interface{})
+			if !ok {
+				errs.add(key, "must be an object of names and values")
+				continue
+			}
+			for name, v := range env {
+				// null removes a variable in a PATCH
+				if v == nil {
+					continue
+				}
+				s, ok := v.(string)
+				if !ok {
+					errs.add(key+"."+name, "must be a string")
+					continue
+				}
+				if err := manager.ValidateEnv(name, s); err != nil {
+					errs.add(key+"."+name, "%v", err)
+				}
+			}
+		case kindStringList:
+			switch v := value.(type) {
+			case string:
+			case []interface{}:
+				for _, item := range v {
+					if _, ok := item.(string); !ok {
+						errs.add(key, "must be an array of strings")
+						break
+					}
+				}
+			default:
+				errs.add(key, "must be an array of strings or a comma-separated string")
+			}
+		case kindPoolLines:
+			if err := manager.ValidateCustomConfig(value); err != nil {
+				errs.add(key, "%s", strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(err.Error(), "invalid "+key), ":")))
+			}
+		}
+	}
+}
+
+// checkAdminSettings reports changes to adminSettings in a request made with
+// a key limited to tenants. With replace, a setting missing from settings
+// is removed, which is a change too. Errors are reported under prefix+key.
+func (r *Router) checkAdminSettings(errs *fieldErrors, req *http.Request, username string, settings map[string]interface{}, replace bool, prefix string) {
+	if tenantScope(req) == nil {
+		return
+	}
+	current := map[string]interface{}{}
+	if poolConfig, err := r.poolManager.GetPoolConfig(username); err == nil {
+		current = poolConfig.Settings
+	}
+	for _, key := range adminSettings {
+		value, set := settings[key]
+		if !set && !replace {
+			continue
+		}
+		if !reflect.DeepEqual(value, current[key]) {
+			errs.add(prefix+key, "can only be changed with an API key that is not limited to tenants")
+		}
+	}
+}
+
+// withoutNulls drops null entries, which a merge patch uses to remove keys
+func withoutNulls(settings map[string]interface{}, patch bool) map[string]interface{} {
+	if !patch {
+		return settings
+	}
+	filtered := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if value != nil {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
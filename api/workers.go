@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"lightweight-php/manager"
+
+	"github.com/gorilla/mux"
+)
+
+func (r *Router) listWorkers(w http.ResponseWriter, req *http.Request) {
+	workers, err := r.pools(req).ListWorkers(mux.Vars(req)["username"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, workers)
+}
+
+// createWorker defines a worker of a pool and starts it; the manager
+// validates the body and answers 422 for invalid fields
+func (r *Router) createWorker(w http.ResponseWriter, req *http.Request) {
+	var spec manager.WorkerSpec
+	if !r.decodeBody(w, req, &spec) {
+		return
+	}
+	worker, err := r.pools(req).AddWorker(mux.Vars(req)["username"], spec)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, worker)
+}
+
+func (r *Router) getWorker(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	worker, err := r.pools(req).GetWorker(vars["username"], vars["name"])
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, worker)
+}
+
+func (r *Router) deleteWorker(w http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	if err := r.pools(req).DeleteWorker(vars["username"], vars["name"]); err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"message":  "Worker deleted",
+		"username": vars["username"],
+		"name":     vars["name"],
+	})
+}
+
+// controlWorker returns a handler starting, stopping or restarting a
+// worker's processes
+func (r *Router) controlWorker(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+		worker, err := r.pools(req).ControlWorker(vars["username"], vars["name"], action)
+		if err != nil {
+			errorResponse(w, err, nil)
+			return
+		}
+		jsonResponse(w, http.StatusOK, worker)
+	}
+}
+
+// getWorkerLogs returns the last lines a worker's processes printed
+func (r *Router) getWorkerLogs(w http.ResponseWriter, req *http.Request) {
+	var errs fieldErrors
+	lines := 100
+	if v := req.URL.Query().Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			errs.add("lines", "must be a number between 1 and 1000")
+		}
+		lines = n
+	}
+	if errs.respond(w) {
+		return
+	}
+
+	vars := mux.Vars(req)
+	log, err := r.pools(req).WorkerLogs(vars["username"], vars["name"], lines)
+	if err != nil {
+		errorResponse(w, err, nil)
+		return
+	}
+	jsonResponse(w, http.StatusOK, log)
+}
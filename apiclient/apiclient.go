@@ -0,0 +1,186 @@
+// Package apiclient calls the REST API of a lightweight-php server, over
+// HTTP(S) or a unix socket
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"lightweight-php/apitypes"
+)
+
+// Client calls one server
+type Client struct {
+	base    string
+	apiKey  string
+	http    *http.Client
+	headers http.Header
+}
+
+// Error is an error response of the API
+type Error struct {
+	Status int
+	// Code is the machine-readable code of the error, e.g. pool_not_found
+	Code      string
+	Message   string
+	Retryable bool
+	// Details is the raw details member of the body, such as the results
+	// of a batch that failed part way
+	Details json.RawMessage
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New returns a client of server: http://HOST:PORT, https://HOST:PORT or
+// unix:///PATH for a server listening on a unix socket. apiKey is sent as
+// a bearer token when set.
+func New(server, apiKey string) (*Client, error) {
+	c := &Client{apiKey: apiKey, http: &http.Client{}}
+	switch {
+	case strings.HasPrefix(server, "unix://"):
+		socket := "/" + strings.TrimLeft(strings.TrimPrefix(server, "unix://"), "/")
+		if socket == "/" {
+			return nil, fmt.Errorf("invalid server %q: expected unix:///PATH", server)
+		}
+		// The host is ignored; every connection goes to the socket
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case strings.HasPrefix(server, "http://"), strings.HasPrefix(server, "https://"):
+		c.base = strings.TrimSuffix(server, "/")
+		c.http.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	default:
+		return nil, fmt.Errorf("invalid server %q: expected http://HOST:PORT, https://HOST:PORT or unix:///PATH", server)
+	}
+	return c, nil
+}
+
+// WithTimeout returns a copy of the client whose requests fail after d
+func (c *Client) WithTimeout(d time.Duration) *Client {
+	cp := *c
+	cp.http = &http.Client{Transport: c.http.Transport, Timeout: d}
+	return &cp
+}
+
+// WithHeader returns a copy of the client that sends the header with
+// every request, e.g. If-Match
+func (c *Client) WithHeader(name, value string) *Client {
+	cp := *c
+	cp.headers = c.headers.Clone()
+	if cp.headers == nil {
+		cp.headers = http.Header{}
+	}
+	cp.headers.Set(name, value)
+	return &cp
+}
+
+// Do sends a request with body encoded as JSON, when not nil, and decodes
+// a successful response into out, when not nil. Error responses are
+// returned as *Error.
+func (c *Client) Do(method, path string, body, out interface{}) error {
+	resp, err := c.Raw(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// Raw sends a request like Do and returns the response of a successful
+// one for the caller to read and close
+func (c *Client) Raw(method, path string, body interface{}) (*http.Response, error) {
+	if body == nil {
+		return c.send(method, path, "", nil)
+	}
+	if raw, ok := body.(json.RawMessage); ok {
+		return c.send(method, path, "application/json", bytes.NewReader(raw))
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(method, path, "application/json", bytes.NewReader(encoded))
+}
+
+// Upload posts body as contentType, e.g. an export bundle, and decodes a
+// successful response into out, when not nil
+func (c *Client) Upload(path, contentType string, body io.Reader, out interface{}) error {
+	resp, err := c.send(http.MethodPost, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response of POST %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Client) send(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach the server: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// responseError reads the {"error": ...} body of an error response, with
+// the field errors of a failed validation
+func responseError(resp *http.Response) error {
+	content, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		apitypes.Error
+		Details json.RawMessage `json:"details"`
+	}
+	if json.Unmarshal(content, &body) == nil && body.Error.Error != "" {
+		message := body.Error.Error
+		for _, f := range body.Fields {
+			message += fmt.Sprintf("; %s: %s", f.Field, f.Message)
+		}
+		return &Error{Status: resp.StatusCode, Code: body.Code, Message: message, Retryable: body.Retryable, Details: body.Details}
+	}
+	message := strings.TrimSpace(string(content))
+	if message == "" {
+		message = resp.Status
+	}
+	return &Error{Status: resp.StatusCode, Message: message}
+}
@@ -0,0 +1,128 @@
+// Package apitypes holds the request and response bodies of the REST API
+// that the server and the CLI's remote mode share
+package apitypes
+
+import (
+	"time"
+
+	"lightweight-php/validation"
+)
+
+// CreatePoolRequest is the body of POST /api/v1/pools
+type CreatePoolRequest struct {
+	Username   string `json:"username"`
+	PHPVersion string `json:"php_version"`
+	Provider   string `json:"provider"`
+	Profile    string `json:"profile"`
+	Target     string `json:"target"`
+	Tenant     string `json:"tenant"`
+	CreateUser bool   `json:"create_user"`
+	Shell      string `json:"shell"`
+	SSHKey     string `json:"ssh_key"`
+}
+
+// PoolResult answers creating or deleting a pool
+type PoolResult struct {
+	Message  string `json:"message"`
+	Username string `json:"username"`
+	// PHPVersion and Provider are those the pool was created with, after
+	// the defaults were applied
+	PHPVersion string `json:"php_version,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	Profile    string `json:"profile,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	Target     string `json:"target,omitempty"`
+	// User is what happened to the system user with remove_user=true
+	User string `json:"user,omitempty"`
+}
+
+// PHPVersion is an installed PHP version as listed by
+// GET /api/v1/php/versions
+type PHPVersion struct {
+	Version  string `json:"version"`
+	Provider string `json:"provider"`
+	Status   string `json:"status"`
+	Tenant   string `json:"tenant"`
+}
+
+// PHPVersionList is the body of GET /api/v1/php/versions
+type PHPVersionList struct {
+	Versions []PHPVersion `json:"versions"`
+}
+
+// InstallResult answers installing a PHP version. InstallID and Log are
+// set when the install was started with async=true.
+type InstallResult struct {
+	Message   string `json:"message"`
+	Version   string `json:"version"`
+	Provider  string `json:"provider"`
+	InstallID int64  `json:"install_id,omitempty"`
+	Log       string `json:"log,omitempty"`
+}
+
+// UninstallResult answers removing a PHP version
+type UninstallResult struct {
+	Message    string   `json:"message"`
+	Version    string   `json:"version"`
+	Provider   string   `json:"provider"`
+	Migrated   []string `json:"migrated,omitempty"`
+	MigratedTo string   `json:"migrated_to,omitempty"`
+}
+
+// CreateSiteRequest is the body of POST /api/v1/sites
+type CreateSiteRequest struct {
+	Domain       string `json:"domain"`
+	Username     string `json:"username"`
+	DocumentRoot string `json:"document_root"`
+	PHPVersion   string `json:"php_version"`
+	// CheckDNS warns when the domain does not resolve to this server
+	CheckDNS bool `json:"check_dns"`
+	// UpdateDNS also points the records at this server through the
+	// configured DNS provider when they do not already
+	UpdateDNS bool `json:"update_dns"`
+	// Certificate requests an ACME certificate for the domain
+	Certificate bool `json:"certificate"`
+}
+
+// TenantRequest is the body of POST and PUT /api/v1/tenants
+type TenantRequest struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Settings    map[string]interface{} `json:"settings"`
+}
+
+// ScheduledChangeRequest is the body of POST /api/v1/scheduled-changes
+type ScheduledChangeRequest struct {
+	Username   string                 `json:"username"`
+	Settings   map[string]interface{} `json:"settings"`
+	PHPVersion string                 `json:"php_version"`
+	// RunAt defaults to the start of the next maintenance window
+	RunAt *time.Time `json:"run_at"`
+}
+
+// ScheduleRequest is the body of POST /api/v1/schedules
+type ScheduleRequest struct {
+	Name string `json:"name"`
+	Task string `json:"task"`
+	Cron string `json:"cron"`
+}
+
+// Error is the body of every error response
+type Error struct {
+	// Error repeats Message for clients of the original {"error": ...}
+	// body
+	Error string `json:"error"`
+	// Code names the kind of error, such as pool_not_found, for clients
+	// to branch on instead of parsing Message
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Fields lists the invalid fields of a validation_failed error
+	Fields []validation.FieldError `json:"fields,omitempty"`
+	// Details carries error specific data, such as the pools using a
+	// version that cannot be uninstalled, or the reason of each invalid
+	// field of a validation_failed error by field
+	Details interface{} `json:"details,omitempty"`
+	// Retryable is set when the same request may succeed later unchanged,
+	// e.g. once a busy lock is released
+	Retryable bool `json:"retryable"`
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+
+	"lightweight-php/db"
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+	"lightweight-php/system"
+)
+
+// App wires the managers on one database handle, one OS detection and one
+// provider factory. The CLI and the API server each build a single App.
+type App struct {
+	DB        *db.Database
+	OSFamily  system.OSFamily
+	Providers *provider.ProviderFactory
+	Pools     *manager.PoolManager
+	Packages  *manager.PackageManager
+	Sites     *manager.SiteManager
+	Schedules *manager.Scheduler
+}
+
+// New opens the database at dbPath ("" for the default) and builds the managers
+func New(dbPath string) (*App, error) {
+	// Refuse unsupported platforms before touching the filesystem
+	osFamily, err := system.NewOSDetector().Detect()
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := db.NewDatabase(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	factory := provider.NewProviderFactoryWithDeps(database, osFamily)
+
+	packages, err := manager.NewPackageManagerWithDeps(database, factory)
+	if err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to initialize package manager: %w", err)
+	}
+
+	pools := manager.NewPoolManagerWithDeps(database, osFamily, factory)
+	return &App{
+		DB:        database,
+		OSFamily:  osFamily,
+		Providers: factory,
+		Pools:     pools,
+		Packages:  packages,
+		Sites:     manager.NewSiteManagerWithDeps(database),
+		Schedules: manager.NewScheduler(pools, packages),
+	}, nil
+}
+
+// Close releases the database handle
+func (a *App) Close() error {
+	return a.DB.Close()
+}
@@ -0,0 +1,72 @@
+// Package audit carries the identity of whoever asked for an operation,
+// and the ID of the API request it came from, through the managers into
+// the audit log.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"os/user"
+	"sync"
+)
+
+// Caller is who started an operation
+type Caller struct {
+	// RequestID is the API request the operation belongs to; empty for
+	// the CLI and background jobs
+	RequestID string
+	// Actor identifies the caller, e.g. the user an authenticating proxy
+	// reported, "anonymous" for the API or "local:root" for the CLI
+	Actor string
+	// Remote is the API client's address
+	Remote string
+}
+
+type callerKey struct{}
+
+// WithCaller returns a context carrying c
+func WithCaller(ctx context.Context, c Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// FromContext returns the caller carried by ctx. Without one the operation
+// was started on this host, by the CLI or a background job, and the actor
+// is the local user running the process.
+func FromContext(ctx context.Context) Caller {
+	if c, ok := ctx.Value(callerKey{}).(Caller); ok {
+		return c
+	}
+	return Caller{Actor: localActor()}
+}
+
+// CallerEnv names the user the privileged helper runs an operation for;
+// the operation is then attributed to "helper:USER"
+const CallerEnv = "LIGHTWEIGHT_PHP_CALLER"
+
+var (
+	localOnce sync.Once
+	local     string
+)
+
+func localActor() string {
+	localOnce.Do(func() {
+		local = "local"
+		if name := os.Getenv(CallerEnv); name != "" && os.Geteuid() == 0 {
+			local = "helper:" + name
+			return
+		}
+		if u, err := user.Current(); err == nil {
+			local = "local:" + u.Username
+		}
+	})
+	return local
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,133 @@
+// Package billing describes the metered usage of pools in a month and
+// hands it to exporters, which push it to a billing system such as Stripe
+// or WHMCS.
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"lightweight-php/config"
+)
+
+// ErrNoExporter is returned when usage should be exported but no exporter
+// is configured
+var ErrNoExporter = errors.New("no billing exporter configured (billing.exporter)")
+
+// periodLayout is the form of billing periods: a month, as YYYY-MM
+const periodLayout = "2006-01"
+
+// Report is the usage of every pool metered in a period
+type Report struct {
+	Period string    `json:"period"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Host   string    `json:"host"`
+	// Complete is false while the period is still running
+	Complete    bool      `json:"complete"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// BandwidthMetered reports whether the sites' access logs are read;
+	// without them the pools have no bandwidth figures
+	BandwidthMetered bool        `json:"bandwidth_metered"`
+	Pools            []PoolUsage `json:"pools"`
+}
+
+// PoolUsage is the usage of one pool in a period. Pools deleted during
+// the period are included up to their deletion.
+type PoolUsage struct {
+	Username string `json:"username"`
+	// CPUSeconds is the user and system CPU time of the pool's PHP-FPM
+	// workers
+	CPUSeconds  float64 `json:"cpu_seconds"`
+	PeakWorkers int64   `json:"peak_workers"`
+	// BandwidthBytes is the response bytes of the pool's sites; nil when
+	// bandwidth is not metered
+	BandwidthBytes *int64 `json:"bandwidth_bytes,omitempty"`
+	// DiskBytesAverage and DiskBytesPeak are nil when the user's disk
+	// usage could not be read, e.g. without filesystem quotas
+	DiskBytesAverage *int64 `json:"disk_bytes_average,omitempty"`
+	DiskBytesPeak    *int64 `json:"disk_bytes_peak,omitempty"`
+	// Samples is how many times the pool was sampled
+	Samples       int64     `json:"samples"`
+	FirstSampleAt time.Time `json:"first_sample_at"`
+	LastSampleAt  time.Time `json:"last_sample_at"`
+}
+
+// PeriodOf returns the period t falls in, in UTC
+func PeriodOf(t time.Time) string {
+	return t.UTC().Format(periodLayout)
+}
+
+// ParsePeriod returns the start of a period and the start of the next one
+func ParsePeriod(period string) (time.Time, time.Time, error) {
+	from, err := time.Parse(periodLayout, period)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid period %q; expected a month as YYYY-MM", period)
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// Exporter pushes usage reports to a billing system
+type Exporter interface {
+	// Export delivers the report of a finished period. Exporting a period
+	// again, e.g. after a failure, should replace what was delivered.
+	Export(ctx context.Context, r *Report) error
+	// String returns the exporter's name and destination
+	String() string
+}
+
+// Factory creates an exporter from the billing settings
+type Factory func(cfg config.BillingConfig) (Exporter, error)
+
+var factories = struct {
+	sync.Mutex
+	m map[string]Factory
+}{m: make(map[string]Factory)}
+
+// Register makes an exporter available as billing.exporter = name. It is
+// meant for init functions of files compiled into the binary, such as a
+// Stripe or WHMCS client, and panics if the name is taken.
+func Register(name string, f Factory) {
+	factories.Lock()
+	defer factories.Unlock()
+	if _, ok := factories.m[name]; ok || name == "webhook" || name == "command" {
+		panic("billing: exporter " + name + " registered twice")
+	}
+	factories.m[name] = f
+}
+
+// Exporters returns the names of the available exporters
+func Exporters() []string {
+	factories.Lock()
+	defer factories.Unlock()
+	names := []string{"command", "webhook"}
+	for name := range factories.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewExporter returns the exporter configured in cfg, or ErrNoExporter
+func NewExporter(cfg config.BillingConfig) (Exporter, error) {
+	switch cfg.Exporter {
+	case "":
+		return nil, ErrNoExporter
+	case "webhook":
+		return NewWebhook(cfg.URL, cfg.Token), nil
+	case "command":
+		return NewCommand(cfg.Command), nil
+	}
+	factories.Lock()
+	f, ok := factories.m[cfg.Exporter]
+	factories.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown billing exporter %q (available: %s)", cfg.Exporter, strings.Join(Exporters(), ", "))
+	}
+	return f(cfg)
+}
@@ -0,0 +1,131 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportTimeout bounds one delivery of a report
+const exportTimeout = time.Minute
+
+// CSVHeader is the first row of WriteCSV
+var CSVHeader = []string{
+	"period", "username", "cpu_seconds", "peak_workers", "bandwidth_bytes",
+	"disk_bytes_average", "disk_bytes_peak", "samples", "first_sample_at", "last_sample_at",
+}
+
+// WriteCSV writes a report as CSV, one row per pool. Figures that are not
+// metered are empty.
+func WriteCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	optional := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	for _, p := range r.Pools {
+		row := []string{
+			r.Period,
+			p.Username,
+			strconv.FormatFloat(p.CPUSeconds, 'f', 2, 64),
+			strconv.FormatInt(p.PeakWorkers, 10),
+			optional(p.BandwidthBytes),
+			optional(p.DiskBytesAverage),
+			optional(p.DiskBytesPeak),
+			strconv.FormatInt(p.Samples, 10),
+			p.FirstSampleAt.UTC().Format(time.RFC3339),
+			p.LastSampleAt.UTC().Format(time.RFC3339),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Webhook posts reports as JSON to a URL, e.g. an endpoint of the billing
+// system or a small service that forwards usage to it
+type Webhook struct {
+	url   string
+	token string
+}
+
+// NewWebhook returns an exporter posting to url, with token as a bearer
+// token unless it is empty
+func NewWebhook(url, token string) *Webhook {
+	return &Webhook{url: url, token: token}
+}
+
+func (h *Webhook) String() string {
+	return "webhook " + h.url
+}
+
+func (h *Webhook) Export(ctx context.Context, r *Report) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post usage of %s: %w", r.Period, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("billing webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Command runs a program with a report as JSON on its standard input, for
+// scripts that talk to a billing system's API
+type Command struct {
+	path string
+}
+
+// NewCommand returns an exporter running the program at path
+func NewCommand(path string) *Command {
+	return &Command{path: path}
+}
+
+func (c *Command) String() string {
+	return "command " + c.path
+}
+
+func (c *Command) Export(ctx context.Context, r *Report) error {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.path, r.Period)
+	cmd.Stdin = bytes.NewReader(payload)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", c.path, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage tenant accounts and their stored data",
+}
+
+var accountEraseCmd = &cobra.Command{
+	Use:   "erase [username]",
+	Short: "Erase all data stored about an account",
+	Long: "Remove the account's sites, pools, session and tmp directories, PHP error logs and migration state, " +
+		"keeping only what the retention policy in the config file requires. A pseudonymous erasure record " +
+		"is stored and the report is printed.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		yes, _ := cmd.Flags().GetBool("yes")
+		if !yes {
+			if !isInteractive() {
+				usagef("Error: erasing an account cannot be undone; pass --yes to confirm")
+			}
+			if newPrompter().ask(fmt.Sprintf("Erase all data of %s? This cannot be undone. Type the username to confirm", username), "") != username {
+				fmt.Println("Aborted")
+				return
+			}
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		report, err := pm.EraseAccount(username)
+		if err != nil {
+			fatalf("Error erasing account: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		printErasureReport(report)
+	},
+}
+
+var accountErasuresCmd = &cobra.Command{
+	Use:   "erasures [username]",
+	Short: "List erasure records, or check whether an account was erased",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := ""
+		if len(args) == 1 {
+			username = args[0]
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		reports, err := pm.ListErasures(username)
+		if err != nil {
+			fatalf("Error listing erasures: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(reports, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		if len(reports) == 0 {
+			if username != "" {
+				fmt.Printf("No erasure recorded for %s\n", username)
+			} else {
+				fmt.Println("No erasures recorded")
+			}
+			return
+		}
+		for _, r := range reports {
+			fmt.Printf("#%d %s subject %s: %d pools, %d sites, %d files, %d retained\n",
+				r.ID, r.ErasedAt.Format(time.RFC3339), r.Subject[:12], len(r.Pools), r.SiteCount, r.FileCount, len(r.Retained))
+		}
+	},
+}
+
+var accountPurgeExpiredCmd = &cobra.Command{
+	Use:   "purge-expired",
+	Short: "Delete retained data and erasure records whose retention has passed",
+	Long:  "Run periodically (e.g. from a daily timer) to enforce the retention section of the config file.",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		sweep, err := pm.PurgeExpiredRetention()
+		if err != nil {
+			fatalf("Error purging expired data: %v", err)
+		}
+		fmt.Printf("Removed %d retained paths and %d erasure records\n", sweep.RemovedPaths, sweep.DeletedRecords)
+	},
+}
+
+func printErasureReport(r *manager.ErasureReport) {
+	fmt.Printf("Erased account %s (record #%d, subject %s)\n", r.Username, r.ID, r.Subject)
+	fmt.Printf("Erased at: %s\n", r.ErasedAt.Format(time.RFC3339))
+	for _, p := range r.Pools {
+		fmt.Printf("  pool  PHP %s\n", p)
+	}
+	for _, s := range r.Sites {
+		fmt.Printf("  site  %s\n", s)
+	}
+	for _, path := range r.Removed {
+		fmt.Printf("  file  %s\n", path)
+	}
+	for _, kept := range r.Retained {
+		path := kept.Path
+		if kept.Target != "" {
+			path = kept.Target + ":" + path
+		}
+		fmt.Printf("  kept  %s until %s: %s\n", path, kept.Until.Format("2006-01-02"), kept.Reason)
+	}
+	for _, note := range r.Notes {
+		fmt.Printf("Note: %s\n", note)
+	}
+}
+
+func init() {
+	accountCmd.AddCommand(accountEraseCmd)
+	accountCmd.AddCommand(accountErasuresCmd)
+	accountCmd.AddCommand(accountPurgeExpiredCmd)
+	accountEraseCmd.Flags().Bool("yes", false, "Do not ask for confirmation")
+	accountEraseCmd.Flags().Bool("json", false, "Print the erasure report as JSON")
+	accountErasuresCmd.Flags().Bool("json", false, "Print the records as JSON")
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolInactiveCmd = &cobra.Command{
+	Use:   "inactive",
+	Short: "Report pools that had no requests for a number of days",
+	Long: `Read the request counters of every pool from its PHP-FPM status page and
+report how long each pool has gone without requests. Pools are first counted
+as active when they are first checked, so a pool shows as inactive only after
+it was checked for the given number of days. The inactivity policy of the
+config file (inactivity.action) is applied by the API server, not here.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		days, _ := cmd.Flags().GetInt("days")
+		if days < 0 {
+			usagef("Error: --days must be at least 1")
+		}
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		report, err := pm.CheckActivity(days)
+		if err != nil {
+			fatalf("Error checking pool activity: %v", err)
+		}
+		printInactivity(cmd, report)
+	},
+}
+
+func printInactivity(cmd *cobra.Command, report *manager.InactivityReport) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+
+	fmt.Printf("%-20s %-10s %-10s %-22s %s\n", "USER", "PM", "IDLE DAYS", "LAST REQUEST", "STATUS")
+	for _, p := range report.Pools {
+		if p.Error != "" {
+			fmt.Printf("%-20s %-10s %-10s %-22s error: %s\n", p.Username, "-", "-", "-", p.Error)
+			continue
+		}
+		status := "active"
+		if p.Inactive {
+			status = "inactive"
+		}
+		if p.ConvertedAt != nil {
+			status += ", ondemand since " + p.ConvertedAt.Format("2006-01-02")
+		}
+		fmt.Printf("%-20s %-10s %-10.1f %-22s %s\n", p.Username, p.ProcessManager, p.IdleDays, p.LastRequestAt.Format("2006-01-02 15:04 MST"), status)
+	}
+}
+
+func init() {
+	poolCmd.AddCommand(poolInactiveCmd)
+	poolInactiveCmd.Flags().Int("days", 0, "Days without requests that count as inactive (default: inactivity.days from the config file)")
+	poolInactiveCmd.Flags().Bool("json", false, "Print the report as JSON")
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolAPCuCmd = &cobra.Command{
+	Use:   "apcu",
+	Short: "Manage APCu for a pool",
+}
+
+var poolAPCuSetCmd = &cobra.Command{
+	Use:   "set [username] [key=value...]",
+	Short: "Enable APCu and size its allocation for a pool",
+	Long:  "Enable APCu and size its allocation for a pool, installing the extension when needed. Keys: enabled, shm_size",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+
+		parsed, err := parseSettingArgs(args[1:])
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		settings := make(map[string]interface{}, len(parsed))
+		for key, value := range parsed {
+			settings["apcu_"+key] = value
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		if err := pm.PatchPoolConfig(username, settings); err != nil {
+			fatalf("Error updating pool: %v", err)
+		}
+		fmt.Printf("APCu settings updated for user: %s\n", username)
+
+		if report, err := pm.CheckSharedMemory(); err == nil {
+			for _, warning := range report.Warnings {
+				fmt.Printf("Warning: %s\n", warning)
+			}
+		}
+	},
+}
+
+var poolStatusCmd = &cobra.Command{
+	Use:   "status [username]",
+	Short: "Show a pool with its APCu and shared memory usage",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		status, err := pm.GetPoolStatus(args[0])
+		if err != nil {
+			fatalf("Error getting pool status: %v", err)
+		}
+		printPoolStatus(status)
+	},
+}
+
+func printPoolStatus(status *manager.PoolStatus) {
+	pool := status.Pool
+	fmt.Printf("User: %s, PHP Version: %s, Provider: %s, Status: %s\n", pool.User, pool.PHPVersion, pool.Provider, pool.Status)
+	switch {
+	case status.APCu == nil:
+		fmt.Printf("APCu: unavailable (%s)\n", status.APCuError)
+	case !status.APCu.Loaded:
+		fmt.Println("APCu: not loaded")
+	case !status.APCu.Enabled:
+		fmt.Println("APCu: disabled")
+	default:
+		apcu := status.APCu
+		fmt.Printf("APCu: %dM of %dM used, %d entries, %d hits, %d misses\n",
+			apcu.Used>>20, apcu.SegmentSize>>20, apcu.Entries, apcu.Hits, apcu.Misses)
+	}
+
+	if shm := status.SharedMemory; shm != nil {
+		fmt.Printf("Shared memory: %dM allocated across pools, limit %dM (%s)\n", shm.Allocated>>20, shm.Limit>>20, shm.LimitSource)
+		for _, warning := range shm.Warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+	}
+}
+
+func init() {
+	poolCmd.AddCommand(poolAPCuCmd)
+	poolAPCuCmd.AddCommand(poolAPCuSetCmd)
+	poolCmd.AddCommand(poolStatusCmd)
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/config"
+
+	"github.com/spf13/cobra"
+)
+
+// serverURL is the API server commands that call the API talk to. Set,
+// it also runs the other commands through the API (see remote.go).
+var serverURL string
+
+// apiKeyEnv holds the API key when --api-key is not given, keeping it out
+// of the process list
+const apiKeyEnv = "LIGHTWEIGHT_PHP_API_KEY"
+
+// apiKey authenticates requests to the server
+var apiKey string
+
+var apiCmd = &cobra.Command{
+	Use:   "api [METHOD] PATH",
+	Short: "Call the REST API of a server",
+	Long: "Send a request to the API of the server given with --server and print the response. " +
+		"Without --server the first unix socket in server.listen is used, else the first TCP address of the server " +
+		"that accepts a connection, trying network.loopback_addresses for addresses on all interfaces. " +
+		"METHOD defaults to GET, or POST with --data.",
+	Example: "  lightweight-php api /api/v1/pools\n" +
+		"  lightweight-php --server unix:///run/lightweight-php.sock api POST /api/v1/pools --data '{\"username\":\"alice\",\"php_version\":\"8.3\"}'",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		data, _ := cmd.Flags().GetString("data")
+		method, path := "GET", args[0]
+		if data != "" {
+			method = "POST"
+		}
+		if len(args) == 2 {
+			method, path = strings.ToUpper(args[0]), args[1]
+		}
+		if !strings.HasPrefix(path, "/") {
+			usagef("Error: PATH must start with /, as in /api/v1/pools")
+		}
+
+		var body interface{}
+		if data != "" {
+			raw := []byte(data)
+			if data == "-" {
+				var err error
+				if raw, err = io.ReadAll(os.Stdin); err != nil {
+					fatalf("Error reading standard input: %v", err)
+				}
+			}
+			if !json.Valid(raw) {
+				usagef("Error: --data is not valid JSON")
+			}
+			body = json.RawMessage(raw)
+		}
+
+		client, err := newAPIClient()
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+		resp, err := client.Raw(method, path, body)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		defer resp.Body.Close()
+		content, err := io.ReadAll(resp.Body)
+		if err != nil {
+			fatalf("Error reading the response: %v", err)
+		}
+		var indented bytes.Buffer
+		if json.Indent(&indented, content, "", "  ") == nil {
+			content = indented.Bytes()
+		}
+		fmt.Println(strings.TrimRight(string(content), "\n"))
+	},
+}
+
+// localDialTimeout bounds probing each address of the local server
+const localDialTimeout = time.Second
+
+// apiServer returns --server, or the local server from the config: the
+// first of its addresses that accepts a connection, else the first address
+// so that the error names it
+func apiServer() string {
+	if serverURL != "" {
+		return serverURL
+	}
+	servers := localServers(config.Get())
+	if len(servers) == 1 || strings.HasPrefix(servers[0], "unix://") {
+		return servers[0]
+	}
+	for _, server := range servers {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(server, "http://"), localDialTimeout)
+		if err == nil {
+			conn.Close()
+			return server
+		}
+	}
+	return servers[0]
+}
+
+// localServers returns the URLs the local server can be reached at: the
+// first unix socket in server.listen, else its TCP addresses, or
+// server.bind_addresses on server.port when listen is empty. Addresses on
+// all interfaces are reached through network.loopback_addresses in order.
+func localServers(cfg *config.Config) []string {
+	var addrs []string
+	for _, spec := range cfg.Server.Listen {
+		network, addr, err := config.ParseListen(spec)
+		if err != nil {
+			continue
+		}
+		if network == "unix" {
+			return []string{"unix://" + addr}
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		bound, err := config.ListenAddresses(cfg.Server.BindAddresses, cfg.Server.Port)
+		if err != nil {
+			bound, _ = config.ListenAddresses(nil, cfg.Server.Port)
+		}
+		addrs = bound
+	}
+
+	var servers []string
+	seen := map[string]bool{}
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		hosts := []string{host}
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			hosts = cfg.Network.LoopbackAddresses
+		}
+		for _, h := range hosts {
+			server := "http://" + net.JoinHostPort(strings.Trim(h, "[]"), port)
+			if !seen[server] {
+				seen[server] = true
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers
+}
+
+// newAPIClient returns a client of the server commands talk to
+func newAPIClient() (*apiclient.Client, error) {
+	key := apiKey
+	if key == "" {
+		key = os.Getenv(apiKeyEnv)
+	}
+	return apiclient.New(apiServer(), key)
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&serverURL, "server", "", "API server to talk to: http(s)://HOST:PORT or unix:///PATH")
+	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key for --server (default $"+apiKeyEnv+")")
+	apiCmd.Flags().String("data", "", "JSON request body, or - to read it from standard input")
+	rootCmd.AddCommand(apiCmd)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"lightweight-php/config"
+)
+
+func TestLocalServers(t *testing.T) {
+	tests := []struct {
+		name   string
+		server config.ServerConfig
+		want   []string
+	}{
+		{
+			name:   "unix socket first",
+			server: config.ServerConfig{Listen: []string{"127.0.0.1:9000", "unix:/run/lightweight-php.sock"}},
+			want:   []string{"unix:///run/lightweight-php.sock"},
+		},
+		{
+			name:   "tcp listen",
+			server: config.ServerConfig{Listen: []string{"[::1]:9000", "10.0.0.5:9001"}},
+			want:   []string{"http://[::1]:9000", "http://10.0.0.5:9001"},
+		},
+		{
+			name:   "wildcard listen",
+			server: config.ServerConfig{Listen: []string{"0.0.0.0:9000"}},
+			want:   []string{"http://[::1]:9000", "http://127.0.0.1:9000"},
+		},
+		{
+			name:   "bind addresses",
+			server: config.ServerConfig{BindAddresses: []string{"10.0.0.5", "::1"}, Port: 8081},
+			want:   []string{"http://10.0.0.5:8081", "http://[::1]:8081"},
+		},
+		{
+			name:   "all addresses",
+			server: config.ServerConfig{Port: 8080},
+			want:   []string{"http://[::1]:8080", "http://127.0.0.1:8080"},
+		},
+		{
+			name:   "wildcard bind addresses",
+			server: config.ServerConfig{BindAddresses: []string{"::", "127.0.0.1"}, Port: 8080},
+			want:   []string{"http://[::1]:8080", "http://127.0.0.1:8080"},
+		},
+	}
+	for _, tt := range tests {
+		cfg := config.Defaults()
+		cfg.Server = tt.server
+		if got := localServers(cfg); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: localServers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// The loopbacks are tried in the configured order
+	cfg := config.Defaults()
+	cfg.Server = config.ServerConfig{Port: 8080}
+	cfg.Network.LoopbackAddresses = []string{"127.0.0.1", "::1"}
+	want := []string{"http://127.0.0.1:8080", "http://[::1]:8080"}
+	if got := localServers(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("localServers = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"path/filepath"
+	"sync"
+
+	"lightweight-php/app"
+	"lightweight-php/db"
+	"lightweight-php/manager"
+	"lightweight-php/target"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sharedApp     *app.App
+	sharedAppErr  error
+	sharedAppOnce sync.Once
+)
+
+// getApp builds the application container once per process so every
+// command shares one database handle
+func getApp() (*app.App, error) {
+	sharedAppOnce.Do(func() {
+		sharedApp, sharedAppErr = app.New(databasePath())
+	})
+	return sharedApp, sharedAppErr
+}
+
+// databasePath returns the database location, "" for the default
+func databasePath() string {
+	if devMode {
+		return filepath.Join(target.DevRoot(), db.DefaultDBPath)
+	}
+	return ""
+}
+
+func newPoolManager() (*manager.PoolManager, error) {
+	a, err := getApp()
+	if err != nil {
+		return nil, err
+	}
+	return a.Pools, nil
+}
+
+func newScheduler() (*manager.Scheduler, error) {
+	a, err := getApp()
+	if err != nil {
+		return nil, err
+	}
+	return a.Schedules, nil
+}
+
+func newPackageManager() (*manager.PackageManager, error) {
+	a, err := getApp()
+	if err != nil {
+		return nil, err
+	}
+	return a.Packages, nil
+}
+
+func newSiteManager() (*manager.SiteManager, error) {
+	a, err := getApp()
+	if err != nil {
+		return nil, err
+	}
+	return a.Sites, nil
+}
+
+// newPackageManagerFor returns the package manager for the command's
+// --target flag, the host when it is not set
+func newPackageManagerFor(cmd *cobra.Command) (*manager.PackageManager, error) {
+	pm, err := newPackageManager()
+	if err != nil {
+		return nil, err
+	}
+	spec, _ := cmd.Flags().GetString("target")
+	t, err := target.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsHost() {
+		return pm, nil
+	}
+	return pm.WithTarget(t)
+}
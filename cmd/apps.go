@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var appCmd = &cobra.Command{
+	Use:   "app",
+	Short: "Install web applications on top of pools and sites",
+}
+
+var appInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install a web application",
+}
+
+var appInstallWordPressCmd = &cobra.Command{
+	Use:   "wordpress",
+	Short: "Set up a WordPress site with its pool, database and nginx snippet",
+	Long: `Set up a WordPress site in one go: the user's pool with the wordpress
+profile (unless the user has a pool already), a MySQL database from
+databases.admin_dsn, the site and its nginx snippet, wp-cli in
+` + manager.WPCLIPath + `, and a WordPress download and install run as the
+user with the pool's PHP. Steps already done are skipped, so a failed run
+can be repeated. The generated admin password is printed once.`,
+	Example: `  lightweight-php app install wordpress --user bob --domain example.com
+  lightweight-php app install wordpress --user bob --domain example.com --create-user --admin-email bob@example.com`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := manager.WordPressOptions{}
+		opts.Username, _ = cmd.Flags().GetString("user")
+		opts.Domain, _ = cmd.Flags().GetString("domain")
+		opts.PHPVersion, _ = cmd.Flags().GetString("php-version")
+		opts.Provider, _ = cmd.Flags().GetString("provider")
+		opts.CreateUser, _ = cmd.Flags().GetBool("create-user")
+		opts.DocumentRoot, _ = cmd.Flags().GetString("docroot")
+		opts.Title, _ = cmd.Flags().GetString("title")
+		opts.AdminUser, _ = cmd.Flags().GetString("admin-user")
+		opts.AdminEmail, _ = cmd.Flags().GetString("admin-email")
+		if opts.CreateUser {
+			opts.User.Shell, _ = cmd.Flags().GetString("shell")
+			if err := opts.User.Validate(); err != nil {
+				usagef("Error: %v", err)
+			}
+		}
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if !asJSON {
+			opts.Step = func(step string) { fmt.Printf("%s...\n", step) }
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		install, err := pm.InstallWordPress(sm, opts)
+		if err != nil {
+			fatalf("Error installing WordPress: %v", err)
+		}
+
+		if asJSON {
+			encoded, _ := json.MarshalIndent(install, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		fmt.Printf("WordPress is installed at %s\n", install.URL)
+		fmt.Printf("  Document root:  %s\n", install.Site.DocumentRoot)
+		fmt.Printf("  Admin user:     %s\n", install.AdminUser)
+		if install.AdminPassword != "" {
+			fmt.Printf("  Admin password: %s\n", install.AdminPassword)
+		} else {
+			fmt.Fprintln(os.Stderr, "WordPress was installed before; its admin password is unchanged")
+		}
+		fmt.Printf("Include %s in the nginx server block of %s\n", install.Site.SnippetPath, install.Site.Domain)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(appCmd)
+	appCmd.AddCommand(appInstallCmd)
+	appInstallCmd.AddCommand(appInstallWordPressCmd)
+	appInstallWordPressCmd.Flags().String("user", "", "System user the site runs as")
+	appInstallWordPressCmd.Flags().String("domain", "", "Domain of the site")
+	appInstallWordPressCmd.Flags().String("php-version", "8.2", "PHP version of a new pool")
+	appInstallWordPressCmd.Flags().String("provider", "remi", "PHP provider of a new pool (remi, lsphp, alt-php, docker, system)")
+	appInstallWordPressCmd.Flags().Bool("create-user", false, "Create the system user if it does not exist (see users in config.json)")
+	appInstallWordPressCmd.Flags().String("shell", "", "Login shell of a created user (default users.shell)")
+	appInstallWordPressCmd.Flags().String("docroot", "", "Document root (default public_html in the user's home)")
+	appInstallWordPressCmd.Flags().String("title", "", "Site title (default the domain)")
+	appInstallWordPressCmd.Flags().String("admin-user", "admin", "WordPress admin user")
+	appInstallWordPressCmd.Flags().String("admin-email", "", "WordPress admin email (default admin@DOMAIN)")
+	appInstallWordPressCmd.Flags().Bool("json", false, "Print the result as JSON")
+	appInstallWordPressCmd.MarkFlagRequired("user")
+	appInstallWordPressCmd.MarkFlagRequired("domain")
+}
@@ -0,0 +1,25 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseSettingArgs converts key=value arguments into a settings map using
+// the same value types the JSON API produces (numbers become float64)
+func parseSettingArgs(args []string) (map[string]interface{}, error) {
+	settings := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid setting %q, expected key=value", arg)
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			settings[key] = n
+		} else {
+			settings[key] = value
+		}
+	}
+	return settings, nil
+}
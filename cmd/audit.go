@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "List recorded operations from the audit log",
+	Long: "List pool, site and PHP operations, newest first, with who started them and, for API " +
+		"calls, the request ID returned in X-Request-ID.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		entries, err := pm.ListAudit(auditFilter(cmd))
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		printAudit(cmd, entries)
+	},
+}
+
+func auditFilter(cmd *cobra.Command) manager.AuditFilter {
+	filter := manager.AuditFilter{}
+	filter.RequestID, _ = cmd.Flags().GetString("request-id")
+	filter.Target, _ = cmd.Flags().GetString("target")
+	filter.Actor, _ = cmd.Flags().GetString("actor")
+	filter.Limit, _ = cmd.Flags().GetInt("limit")
+	return filter
+}
+
+func printAudit(cmd *cobra.Command, entries []manager.AuditEntry) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(entries, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("No operations recorded")
+		return
+	}
+	for _, e := range entries {
+		outcome := "ok"
+		if e.Error != "" {
+			outcome = "failed: " + e.Error
+		}
+		requestID := e.RequestID
+		if requestID == "" {
+			requestID = "-"
+		}
+		fmt.Printf("%s  %-32s %-16s %-20s %-20s %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), requestID, e.Actor, e.Action, e.Target, outcome)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().String("request-id", "", "Only operations of this API request")
+	auditCmd.Flags().String("target", "", "Only operations on this pool user, site or PHP version")
+	auditCmd.Flags().String("actor", "", "Only operations started by this caller")
+	auditCmd.Flags().Int("limit", 100, "Show at most this many entries")
+	auditCmd.Flags().Bool("json", false, "Print the entries as JSON")
+}
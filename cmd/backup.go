@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/config"
+	"lightweight-php/manager"
+	"lightweight-php/objstore"
+
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up and restore accounts, locally or to offsite storage",
+}
+
+var backupCreateCmd = &cobra.Command{
+	Use:   "create [username]",
+	Short: "Back up an account's pools, sites and site files",
+	Long: "Write a backup of the account's pools (as export bundles), sites and document roots " +
+		"to a local file (--output) or upload it to --to or backup.url (s3://, ssh:// or file://).",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		output, _ := cmd.Flags().GetString("output")
+		noFiles, _ := cmd.Flags().GetBool("no-files")
+		files := !noFiles && !config.Get().Backup.SkipFiles
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		if output == "-" {
+			if _, err := pm.WriteBackup(username, files, os.Stdout); err != nil {
+				fatalf("Error backing up %s: %v", username, err)
+			}
+			return
+		}
+		if output != "" {
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				fatalf("Error creating %s: %v", output, err)
+			}
+			metadata, err := pm.WriteBackup(username, files, f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(output)
+				fatalf("Error backing up %s: %v", username, err)
+			}
+			fmt.Printf("Backed up %s (%d pools, %d sites) to %s\n", username, len(metadata.Pools), len(metadata.Sites), output)
+			return
+		}
+
+		store, err := backupStore(cmd, "to")
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		object, err := pm.UploadBackup(store, username, files)
+		if err != nil {
+			fatalf("Error backing up %s: %v", username, err)
+		}
+		fmt.Printf("Uploaded %s/%s (%d bytes)\n", store, object.Key, object.Size)
+	},
+}
+
+var backupRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Back up every account to offsite storage and prune old backups",
+	Long: "Upload a backup of every account with a pool to --to or backup.url and delete backups " +
+		"older than backup.retention_days, keeping each account's newest. The API server does this " +
+		"every backup.interval when backup.url is set; use this command from cron otherwise.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.Get()
+		store, err := backupStore(cmd, "to")
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		uploaded, err := pm.RunBackups(store, !cfg.Backup.SkipFiles, cfg.Backup.RetentionDays)
+		for _, object := range uploaded {
+			fmt.Printf("Uploaded %s/%s (%d bytes)\n", store, object.Key, object.Size)
+		}
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+	},
+}
+
+var backupListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Short: "List backups in offsite storage",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := ""
+		if len(args) == 1 {
+			username = args[0]
+		}
+		store, err := backupStore(cmd, "from")
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		backups, err := manager.ListBackups(store, username)
+		if err != nil {
+			fatalf("Error listing backups: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(backups, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		if len(backups) == 0 {
+			fmt.Printf("No backups in %s\n", store)
+			return
+		}
+		for _, b := range backups {
+			fmt.Printf("%-16s %s  %10d bytes  %s/%s\n", b.Username, b.CreatedAt.Local().Format("2006-01-02 15:04:05"), b.Size, store, b.Key)
+		}
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore an account from a backup",
+	Long: "Restore an account from a local backup file or an object URL such as " +
+		"s3://bucket/backups/alice/20260301T020000Z.tar.gz. Missing pools and sites are recreated " +
+		"(the PHP versions must be installed) and the site files are written back, owned by the account.",
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString("from")
+		if from == "" {
+			usagef("Error: --from is required")
+		}
+		noFiles, _ := cmd.Flags().GetBool("no-files")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		r, err := manager.OpenBackup(from, config.Get().S3)
+		if err != nil {
+			fatalf("Error opening backup: %v", err)
+		}
+		defer r.Close()
+
+		report, err := pm.RestoreBackup(r, !noFiles)
+		if err != nil {
+			fatalf("Error restoring backup: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		fmt.Printf("Restored %s from the backup of %s\n", report.Username, report.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+		for _, v := range report.PoolsCreated {
+			fmt.Printf("  Pool PHP %s: created\n", v)
+		}
+		for _, v := range report.PoolsSkipped {
+			fmt.Printf("  Pool PHP %s: already exists, left unchanged\n", v)
+		}
+		for _, d := range report.SitesCreated {
+			fmt.Printf("  Site %s: created\n", d)
+		}
+		for _, d := range report.SitesSkipped {
+			fmt.Printf("  Site %s: already exists, left unchanged\n", d)
+		}
+		fmt.Printf("  Files written: %d\n", report.FilesWritten)
+	},
+}
+
+// backupStore returns the store named by the flag or backup.url
+func backupStore(cmd *cobra.Command, flag string) (objstore.Store, error) {
+	cfg := config.Get()
+	url, _ := cmd.Flags().GetString(flag)
+	if url == "" {
+		url = cfg.Backup.URL
+	}
+	if url == "" {
+		return nil, fmt.Errorf("no backup target; pass --%s or set backup.url in %s", flag, config.DefaultConfigPath)
+	}
+	return objstore.Open(url, cfg.S3)
+}
+
+func init() {
+	backupCmd.AddCommand(backupCreateCmd)
+	backupCmd.AddCommand(backupRunCmd)
+	backupCmd.AddCommand(backupListCmd)
+	backupCmd.AddCommand(backupRestoreCmd)
+
+	backupCreateCmd.Flags().StringP("output", "o", "", "Write the backup to this file, or - for standard output, instead of uploading it")
+	backupCreateCmd.Flags().String("to", "", "Upload to this store instead of backup.url (s3://bucket/prefix, ssh://host/path, file:///path)")
+	backupCreateCmd.Flags().Bool("no-files", false, "Leave the sites' document roots out")
+	backupRunCmd.Flags().String("to", "", "Upload to this store instead of backup.url")
+	backupListCmd.Flags().String("from", "", "Store to list instead of backup.url")
+	backupListCmd.Flags().Bool("json", false, "Print backups as JSON")
+	backupRestoreCmd.Flags().String("from", "", "Backup file or object URL (s3://bucket/key, ssh://host/path/key, file:///path)")
+	backupRestoreCmd.Flags().Bool("no-files", false, "Only recreate pools and sites, do not write site files")
+	backupRestoreCmd.Flags().Bool("json", false, "Print the restore report as JSON")
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"lightweight-php/billing"
+
+	"github.com/spf13/cobra"
+)
+
+var billingCmd = &cobra.Command{
+	Use:   "billing",
+	Short: "Show and export the metered usage of pools",
+	Long: `The API server samples every pool's CPU time, workers and disk usage
+every billing.sample_interval and, with billing.access_log, the bandwidth of
+its sites, and adds them up per month. Once a month is over its usage is
+sent to billing.exporter.`,
+}
+
+var billingUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show the usage of every pool in a month",
+	Long: `Show the usage of every pool in a month as a table, JSON or CSV.
+
+  lightweight-php billing usage --period 2024-06 --format csv > usage-2024-06.csv`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		period := usagePeriod(cmd)
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		report, err := pm.UsageReport(period)
+		if err != nil {
+			fatalf("Error reading usage: %v", err)
+		}
+		printUsage(cmd, report)
+	},
+}
+
+// usagePeriod validates the flags of billing usage and returns the period
+func usagePeriod(cmd *cobra.Command) string {
+	period, _ := cmd.Flags().GetString("period")
+	format, _ := cmd.Flags().GetString("format")
+	if period == "" {
+		period = billing.PeriodOf(time.Now())
+	}
+	if _, _, err := billing.ParsePeriod(period); err != nil {
+		usagef("Error: %v", err)
+	}
+	if !containsString([]string{"table", "json", "csv"}, format) {
+		usagef("Error: --format must be one of: table, json, csv")
+	}
+	return period
+}
+
+func printUsage(cmd *cobra.Command, report *billing.Report) {
+	switch format, _ := cmd.Flags().GetString("format"); format {
+	case "json":
+		encoded, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	case "csv":
+		if err := billing.WriteCSV(os.Stdout, report); err != nil {
+			fatalf("Error writing CSV: %v", err)
+		}
+		return
+	}
+
+	state := "complete"
+	if !report.Complete {
+		state = "in progress"
+	}
+	fmt.Printf("Usage of %s (%s)\n\n", report.Period, state)
+	if len(report.Pools) == 0 {
+		fmt.Println("No usage recorded; the API server samples the pools")
+		return
+	}
+	fmt.Printf("%-20s %12s %8s %10s %10s %10s\n", "USER", "CPU SECONDS", "WORKERS", "BANDWIDTH", "DISK AVG", "DISK PEAK")
+	for _, p := range report.Pools {
+		fmt.Printf("%-20s %12.2f %8d %10s %10s %10s\n", p.Username, p.CPUSeconds, p.PeakWorkers,
+			formatUsageBytes(p.BandwidthBytes), formatUsageBytes(p.DiskBytesAverage), formatUsageBytes(p.DiskBytesPeak))
+	}
+}
+
+var billingExportCmd = &cobra.Command{
+	Use:   "export PERIOD",
+	Short: "Send the usage of a finished month to the billing exporter",
+	Long: `Send the usage of a finished month, given as YYYY-MM, to billing.exporter.
+The API server does this by itself once a month is over; export a month
+again after a failure or after changing the exporter.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if _, _, err := billing.ParsePeriod(args[0]); err != nil {
+			usagef("Error: %v", err)
+		}
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		exporter, err := pm.ExportUsage(args[0])
+		if err != nil {
+			fatalf("Error exporting usage: %v", err)
+		}
+		fmt.Printf("Usage of %s exported with %s\n", args[0], exporter)
+	},
+}
+
+func formatUsageBytes(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return formatRSS(*v)
+}
+
+func init() {
+	rootCmd.AddCommand(billingCmd)
+	billingCmd.AddCommand(billingUsageCmd)
+	billingCmd.AddCommand(billingExportCmd)
+	billingUsageCmd.Flags().String("period", "", "Month as YYYY-MM (default the current month)")
+	billingUsageCmd.Flags().String("format", "table", "Output format: table, json or csv")
+}
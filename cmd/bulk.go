@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolCreateBulkCmd = &cobra.Command{
+	Use:   "create-bulk",
+	Short: "Create pools from a CSV file in one batch",
+	Long: `Create pools from a CSV file in one batch with a single PHP-FPM reload per
+service. The first row is a header: "username" is required, "php_version",
+"provider" and "profile" are optional, and any other column is applied as a
+pool setting (e.g. memory_limit). Empty cells are ignored. If any pool cannot
+be created, the pools created before it are removed again.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ops := readBulkFile(cmd)
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+
+		beginMaintenance(cmd)
+		results, err := pm.ApplyBatch(ops)
+		printBatchResults(results)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Printf("Created %d pools\n", len(results))
+	},
+}
+
+// readBulkFile reads the create operations of the --file CSV
+func readBulkFile(cmd *cobra.Command) []manager.BatchOperation {
+	file, _ := cmd.Flags().GetString("file")
+	if file == "" {
+		usagef("Error: --file is required")
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		fatalf("Error opening %s: %v", file, err)
+	}
+	defer f.Close()
+	ops, err := readBulkCSV(f)
+	if err != nil {
+		fatalf("Error reading %s: %v", file, err)
+	}
+	return ops
+}
+
+// readBulkCSV turns CSV rows into create operations
+func readBulkCSV(r io.Reader) ([]manager.BatchOperation, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	hasUsername := false
+	for _, column := range header {
+		hasUsername = hasUsername || column == "username"
+	}
+	if !hasUsername {
+		return nil, fmt.Errorf("header must contain a username column")
+	}
+
+	var ops []manager.BatchOperation
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		op := manager.BatchOperation{Op: manager.BatchCreate}
+		var settingArgs []string
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			switch header[i] {
+			case "username":
+				op.Username = value
+			case "php_version":
+				op.PHPVersion = value
+			case "provider":
+				op.Provider = value
+			case "profile":
+				op.Profile = value
+			default:
+				settingArgs = append(settingArgs, header[i]+"="+value)
+			}
+		}
+		if len(settingArgs) > 0 {
+			if op.Settings, err = parseSettingArgs(settingArgs); err != nil {
+				return nil, err
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("no pools listed")
+	}
+	return ops, nil
+}
+
+func init() {
+	poolCmd.AddCommand(poolCreateBulkCmd)
+	poolCreateBulkCmd.Flags().StringP("file", "f", "", "CSV file listing the pools to create")
+	addMaintenanceFlags(poolCreateBulkCmd)
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "Generate the shell completion script",
+	Long: `Generate the shell completion script. Usernames, PHP versions and
+providers are completed from this host's database.
+
+  bash: lightweight-php completion bash > /etc/bash_completion.d/lightweight-php
+  zsh:  lightweight-php completion zsh > "${fpath[1]}/_lightweight-php"
+  fish: lightweight-php completion fish > ~/.config/fish/completions/lightweight-php.fish`,
+	Args:                  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs:             []string{"bash", "zsh", "fish"},
+	DisableFlagsInUseLine: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		default:
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+	},
+}
+
+// providerChoices are the values accepted by --provider
+var providerChoices = []string{"remi", "lsphp", "alt-php", "docker", "system"}
+
+// completePoolUsername completes the first argument with the users that
+// have a pool
+func completePoolUsername(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return poolUsernames(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completePHPVersion completes the first argument with installed PHP versions
+func completePHPVersion(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return installedVersions(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completePHPVersionFlag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return installedVersions(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+func completeProviderFlag(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return providerChoices, cobra.ShellCompDirectiveNoFileComp
+}
+
+// registerVersionCompletion wires dynamic completion to the PHP version and
+// provider flags of a command, whichever it has
+func registerVersionCompletion(c *cobra.Command) {
+	for _, name := range []string{"php-version", "php"} {
+		if c.Flags().Lookup(name) != nil {
+			c.RegisterFlagCompletionFunc(name, completePHPVersionFlag)
+		}
+	}
+	if c.Flags().Lookup("provider") != nil {
+		c.RegisterFlagCompletionFunc("provider", completeProviderFlag)
+	}
+}
+
+func poolUsernames(prefix string) []string {
+	a, err := getApp()
+	if err != nil {
+		return nil
+	}
+	pools, err := a.DB.ListPools()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, p := range pools {
+		if strings.HasPrefix(p.Username, prefix) && !seen[p.Username] {
+			seen[p.Username] = true
+			names = append(names, p.Username)
+		}
+	}
+	return names
+}
+
+func installedVersions(prefix string) []string {
+	a, err := getApp()
+	if err != nil {
+		return nil
+	}
+	versions, err := a.DB.ListPHPVersions()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range versions {
+		if strings.HasPrefix(v.Version, prefix) && !seen[v.Version] {
+			seen[v.Version] = true
+			names = append(names, v.Version)
+		}
+	}
+	return names
+}
+
+// registerCompletions wires dynamic completion into the commands. It runs
+// from Execute because the flags are defined in the init of each file.
+func registerCompletions() {
+	for _, c := range []*cobra.Command{
+		poolDeleteCmd, poolExportBundleCmd, poolAPCuSetCmd, poolStatusCmd,
+		poolOpcacheSetCmd, poolOpcacheResetCmd, migrateAccountCmd, accountEraseCmd,
+		poolTuneCmd, poolTestCmd, backupCreateCmd, poolSetCmd, poolLabelCmd, scheduleAddCmd,
+		templatesDebugCmd, poolSuspendCmd, poolUnsuspendCmd,
+	} {
+		c.ValidArgsFunction = completePoolUsername
+	}
+	phpOpcacheSetCmd.ValidArgsFunction = completePHPVersion
+	phpUninstallCmd.ValidArgsFunction = completePHPVersion
+	for _, c := range []*cobra.Command{phpFPMConfigShowCmd, phpFPMConfigSetCmd, phpFPMLogCmd} {
+		c.ValidArgsFunction = completePHPVersion
+	}
+	phpUninstallCmd.RegisterFlagCompletionFunc("migrate-to", completePHPVersionFlag)
+
+	for _, c := range []*cobra.Command{
+		poolCreateCmd, poolImportBundleCmd, migrateAccountCmd, siteCreateCmd, siteBindCmd,
+		phpOpcacheSetCmd, phpLoaderInstallCmd, phpLoaderListCmd, poolSetCmd,
+		scheduleAddCmd, scheduleUpdateCmd, phpUninstallCmd,
+		phpFPMConfigShowCmd, phpFPMConfigSetCmd, phpFPMLogCmd,
+	} {
+		registerVersionCompletion(c)
+	}
+}
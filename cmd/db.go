@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/objstore"
+	"lightweight-php/replica"
+
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the state database",
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.OpenDatabase(databasePath())
+		if err != nil {
+			fatalf("Error opening database: %v", err)
+		}
+		defer database.Close()
+
+		applied, err := database.Migrate()
+		for _, m := range applied {
+			fmt.Printf("Applied migration %d: %s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			fatalf("Error migrating database: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Printf("Database is up to date (schema version %d)\n", db.LatestSchemaVersion())
+		}
+	},
+}
+
+var dbStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the schema version and pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.OpenDatabase(databasePath())
+		if err != nil {
+			fatalf("Error opening database: %v", err)
+		}
+		defer database.Close()
+
+		current, err := database.SchemaVersion()
+		if err != nil {
+			fatalf("Error reading schema version: %v", err)
+		}
+		statuses, err := database.MigrationStatus()
+		if err != nil {
+			fatalf("Error reading migrations: %v", err)
+		}
+
+		fmt.Printf("Database: %s\n", database.Driver())
+		fmt.Printf("Schema version: %d (latest: %d)\n", current, db.LatestSchemaVersion())
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("  %3d  %-40s %s\n", s.Version, s.Description, state)
+		}
+	},
+}
+
+var dbReplicateCmd = &cobra.Command{
+	Use:   "replicate",
+	Short: "Upload a snapshot of the database to the replication target",
+	Long: "Upload a snapshot of the database to replication.url if it changed since the last one, " +
+		"and prune snapshots older than replication.retention_days. The API server does this " +
+		"every replication.interval; --watch does it here instead.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg := config.Get()
+		store, err := replica.OpenStore(cfg)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		database, err := db.NewDatabase(databasePath())
+		if err != nil {
+			fatalf("Error opening database: %v", err)
+		}
+		defer database.Close()
+
+		r := replica.New(database, store, cfg.Replication.RetentionDays)
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			interval, _ := time.ParseDuration(cfg.Replication.Interval)
+			fmt.Printf("Replicating to %s every %s\n", store, interval)
+			r.Run(interval, nil, func(format string, args ...interface{}) {
+				fmt.Printf(time.Now().Format(time.RFC3339)+" "+format+"\n", args...)
+			})
+			return
+		}
+
+		snapshot, err := r.Sync()
+		if err != nil {
+			fatalf("Error replicating database: %v", err)
+		}
+		if snapshot == nil {
+			fmt.Printf("Database unchanged since the last snapshot in %s\n", store)
+			return
+		}
+		fmt.Printf("Uploaded %s/%s (%d bytes)\n", store, snapshot.Key, snapshot.Size)
+	},
+}
+
+var dbSnapshotsCmd = &cobra.Command{
+	Use:   "snapshots",
+	Short: "List the database snapshots available for restore",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := replicaStore(cmd)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		snapshots, err := replica.List(store)
+		if err != nil {
+			fatalf("Error listing snapshots: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(snapshots, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		if len(snapshots) == 0 {
+			fmt.Printf("No snapshots in %s\n", store)
+			return
+		}
+		for _, s := range snapshots {
+			fmt.Printf("%s  %s  %8d bytes\n", s.Time.Local().Format("2006-01-02 15:04:05 MST"), s.Hash, s.Size)
+		}
+	},
+}
+
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the database from a replicated snapshot",
+	Long: "Restore the database as of --timestamp (the newest snapshot taken at or before it, " +
+		"default the latest). The current database is kept beside it as .before-restore. " +
+		"Stop the API server first; it keeps using the replaced file until restarted.",
+	Run: func(cmd *cobra.Command, args []string) {
+		at := time.Now()
+		if value, _ := cmd.Flags().GetString("timestamp"); value != "" {
+			parsed, err := parseTimestamp(value)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+			at = parsed
+		}
+		store, err := replicaStore(cmd)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		dest, _ := cmd.Flags().GetString("output")
+		if dest == "" {
+			dest = databasePath()
+		}
+
+		snapshot, err := replica.Restore(store, at, dest)
+		if err != nil {
+			fatalf("Error restoring database: %v", err)
+		}
+		fmt.Printf("Restored %s from the snapshot of %s\n", dest, snapshot.Time.Local().Format("2006-01-02 15:04:05 MST"))
+	},
+}
+
+// replicaStore returns the store named by --from or replication.url
+func replicaStore(cmd *cobra.Command) (objstore.Store, error) {
+	cfg := config.Get()
+	if from, _ := cmd.Flags().GetString("from"); from != "" {
+		return objstore.Open(from, cfg.S3)
+	}
+	return replica.OpenStore(cfg)
+}
+
+// parseTimestamp accepts RFC 3339 or a local "YYYY-MM-DD[ HH:MM[:SS]]"
+func parseTimestamp(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			if layout == "2006-01-02" {
+				// A bare date means the state at the end of that day
+				t = t.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q; use RFC 3339 or \"YYYY-MM-DD HH:MM:SS\"", value)
+}
+
+func init() {
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbStatusCmd)
+	dbCmd.AddCommand(dbReplicateCmd)
+	dbCmd.AddCommand(dbSnapshotsCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+
+	dbReplicateCmd.Flags().Bool("watch", false, "Keep replicating every replication.interval")
+	dbSnapshotsCmd.Flags().String("from", "", "Store URL to read instead of replication.url")
+	dbSnapshotsCmd.Flags().Bool("json", false, "Print snapshots as JSON")
+	dbRestoreCmd.Flags().String("timestamp", "", "Restore the state as of this time (RFC 3339 or local \"YYYY-MM-DD HH:MM:SS\")")
+	dbRestoreCmd.Flags().String("from", "", "Store URL to restore from instead of replication.url, e.g. s3://bucket/prefix")
+	dbRestoreCmd.Flags().String("output", "", "Write the restored database here instead of replacing the live one")
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"lightweight-php/db"
+
+	"github.com/spf13/cobra"
+)
+
+var dbSecretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Inspect and rotate the key that encrypts secrets in the database",
+	Long: `Pool environment variables are stored encrypted (AES-256-GCM) in the state
+database, together with the copies of them in profiles, tenant defaults,
+scheduled changes and the config history. The key is read from
+` + db.SecretKeyEnv + ` (base64) when set, otherwise from secrets.key_file
+(default ` + db.SecretKeyPath + `), which is created on first use.`,
+}
+
+var dbSecretsStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the key in use and how many secrets are encrypted",
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.OpenDatabase(databasePath())
+		if err != nil {
+			fatalf("Error opening database: %v", err)
+		}
+		defer database.Close()
+
+		status, err := database.SecretsStatus()
+		if err != nil {
+			fatalf("Error reading secrets: %v", err)
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(status, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		fmt.Printf("Key: %s", status.KeySource)
+		if status.KeyID != "" {
+			fmt.Printf(" (id %s)", status.KeyID)
+		}
+		fmt.Println()
+		if len(status.OtherKeys) > 0 {
+			fmt.Printf("Older keys still accepted: %s\n", strings.Join(status.OtherKeys, ", "))
+		}
+		plaintext := 0
+		for _, c := range status.Columns {
+			fmt.Printf("  %-32s %4d encrypted  %4d plaintext\n", c.Table+"."+c.Column, c.Sealed, c.Plaintext)
+			plaintext += c.Plaintext
+		}
+		if plaintext > 0 {
+			fmt.Println("Run 'db secrets rotate' to encrypt the plaintext values.")
+		}
+	},
+}
+
+var dbSecretsRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Re-encrypt every secret with a new key",
+	Long: `Re-encrypt every secret in one transaction. With a key file, a new key is
+written as <key_file>.new first and replaces the key once the database is
+updated; the old key is kept as <key_file>.previous so that older database
+snapshots can still be read. With ` + db.SecretKeyEnv + `, set it to the new
+key and ` + db.PreviousSecretKeyEnv + ` to the old one, then run this to
+re-encrypt with the new key. Values stored before encryption are encrypted
+as well.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.OpenDatabase(databasePath())
+		if err != nil {
+			fatalf("Error opening database: %v", err)
+		}
+		defer database.Close()
+
+		written, err := database.RotateSecrets()
+		if err != nil {
+			fatalf("Error rotating secrets: %v", err)
+		}
+		status, err := database.SecretsStatus()
+		if err != nil {
+			fatalf("Error reading secrets: %v", err)
+		}
+		fmt.Printf("Re-encrypted %d rows with key %s (%s)\n", written, status.KeyID, status.KeySource)
+	},
+}
+
+func init() {
+	dbCmd.AddCommand(dbSecretsCmd)
+	dbSecretsCmd.AddCommand(dbSecretsStatusCmd)
+	dbSecretsCmd.AddCommand(dbSecretsRotateCmd)
+	dbSecretsStatusCmd.Flags().Bool("json", false, "Print the status as JSON")
+}
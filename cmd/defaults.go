@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var defaultsCmd = &cobra.Command{
+	Use:   "defaults",
+	Short: "Manage the settings every new pool starts from",
+	Long: `Default pool settings are stored in the database and applied to every pool
+created with 'pool create', before the settings of its tenant and profile.
+Existing pools keep their settings.`,
+}
+
+var defaultsShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the default pool settings",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		defaults, err := pm.GetPoolDefaults()
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		printDefaults(cmd, defaults)
+	},
+}
+
+func printDefaults(cmd *cobra.Command, defaults *manager.PoolDefaults) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(defaults, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(defaults.Settings) == 0 {
+		fmt.Println("No default settings; new pools start from the template")
+		return
+	}
+	keys := make([]string, 0, len(defaults.Settings))
+	for key := range defaults.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s = %v\n", key, defaults.Settings[key])
+	}
+	if defaults.UpdatedAt != nil {
+		fmt.Printf("\nUpdated %s\n", defaults.UpdatedAt.Format(time.RFC3339))
+	}
+}
+
+var defaultsSetCmd = &cobra.Command{
+	Use:   "set key=value...",
+	Short: "Change default pool settings",
+	Long: `Merge settings into the defaults. Keys are pool settings or the PHP-FPM
+directives they stand for, such as pm.max_children. An empty value (key=)
+removes a setting.
+
+  lightweight-php defaults set memory_limit=256M pm.max_children=30`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		patch, err := parseSettingArgs(args)
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		defaults, err := pm.GetPoolDefaults()
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		if err := pm.SetPoolDefaults(patchDefaults(defaults.Settings, patch)); err != nil {
+			fatalf("Error updating defaults: %v", err)
+		}
+		fmt.Println("Default pool settings updated")
+	},
+}
+
+// patchDefaults merges key=value arguments into the default settings; an
+// empty value removes the setting
+func patchDefaults(settings, patch map[string]interface{}) map[string]interface{} {
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		key = manager.SettingName(key)
+		if value == "" {
+			delete(settings, key)
+		} else {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+func init() {
+	rootCmd.AddCommand(defaultsCmd)
+	defaultsCmd.AddCommand(defaultsShowCmd)
+	defaultsCmd.AddCommand(defaultsSetCmd)
+	defaultsShowCmd.Flags().Bool("json", false, "Print the defaults as JSON")
+}
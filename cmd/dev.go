@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/lock"
+	"lightweight-php/target"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// devMode runs against a sandbox directory instead of the host
+	devMode bool
+	devDir  string
+)
+
+// devOSRelease is the os-release of a new sandbox
+const devOSRelease = `NAME="lightweight-php development sandbox"
+ID="lightweight-php-dev"
+ID_LIKE="rhel fedora"
+VERSION_ID="9"
+PRETTY_NAME="lightweight-php development sandbox (RHEL 9)"
+`
+
+// devExecCmd stands in for every external command in development mode: it
+// appends the command line to the log and succeeds without output
+var devExecCmd = &cobra.Command{
+	Use:                "dev-exec LOG COMMAND [ARGS...]",
+	Hidden:             true,
+	DisableFlagParsing: true,
+	Args:               cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		quoted := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$") {
+				arg = strconv.Quote(arg)
+			}
+			quoted = append(quoted, arg)
+		}
+
+		f, err := os.OpenFile(args[0], os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fatalf("Error opening command log: %v", err)
+		}
+		defer f.Close()
+		fmt.Fprintf(f, "%s %s\n", time.Now().Format(time.RFC3339), strings.Join(quoted, " "))
+	},
+}
+
+// enableDevMode points host paths, commands and locks into the sandbox
+func enableDevMode() error {
+	dir, err := filepath.Abs(devDir)
+	if err != nil {
+		return err
+	}
+	for _, sub := range []string{"etc", "run", "var/lib/lightweight-php"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return fmt.Errorf("failed to create sandbox: %w", err)
+		}
+	}
+
+	// The sandbox poses as a RHEL 9 host until its etc/os-release is
+	// edited. Older sandboxes without one keep their release files.
+	if !fileExists(filepath.Join(dir, "etc/os-release")) && !fileExists(filepath.Join(dir, "etc/redhat-release")) && !fileExists(filepath.Join(dir, "etc/debian_version")) && !fileExists(filepath.Join(dir, "etc/SUSE-brand")) {
+		if err := os.WriteFile(filepath.Join(dir, "etc/os-release"), []byte(devOSRelease), 0644); err != nil {
+			return fmt.Errorf("failed to create sandbox: %w", err)
+		}
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+	logPath := filepath.Join(dir, "commands.log")
+	target.EnableDev(dir, self, devExecCmd.Name(), logPath)
+	lock.Default = lock.NewManager(filepath.Join(dir, lock.DefaultLockDir))
+
+	fmt.Fprintf(os.Stderr, "Development mode: sandbox %s, commands are logged to %s\n", dir, logPath)
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [username]",
+	Short: "Check the host and the pools for common problems",
+	Long: "Check the state database, free disk space, the package repositories and PHP-FPM " +
+		"services the pools use, the FPM configuration, and each pool's file, permissions, user, " +
+		"socket and SELinux labels, and print what to do about each problem. With a username " +
+		"only that user's pool and its PHP version are checked. Exits non-zero when a check fails.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := ""
+		if len(args) == 1 {
+			username = args[0]
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		report, err := pm.Doctor(username)
+		if err != nil {
+			fatalf("Error running diagnostics: %v", err)
+		}
+		printDiagnostics(cmd, report)
+	},
+}
+
+// printDiagnostics prints the findings of doctor, exiting non-zero when a
+// check failed
+func printDiagnostics(cmd *cobra.Command, report *manager.Diagnostics) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(encoded))
+	} else {
+		quiet, _ := cmd.Flags().GetBool("problems")
+		for _, f := range report.Findings {
+			if quiet && f.Status == manager.FindingOK {
+				continue
+			}
+			fmt.Printf("%-9s %-11s %s: %s\n", "["+f.Status+"]", f.Check, f.Subject, f.Message)
+			if f.Fix != "" {
+				fmt.Printf("%-21s fix: %s\n", "", f.Fix)
+			}
+		}
+		if report.OK && report.Warnings == 0 {
+			fmt.Println("No problems found")
+		} else {
+			fmt.Printf("%d errors, %d warnings\n", report.Errors, report.Warnings)
+		}
+	}
+	if !report.OK {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().Bool("json", false, "Print the findings as JSON")
+	doctorCmd.Flags().Bool("problems", false, "Only print warnings and errors")
+}
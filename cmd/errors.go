@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/lock"
+	"lightweight-php/manager"
+	"lightweight-php/objstore"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Exit codes of failed commands. `state diff` keeps diff(1)'s 1 and 2.
+const (
+	exitFailure  = 1 // the operation failed
+	exitUsage    = 2 // invalid arguments or flags
+	exitNotFound = 3 // the pool, site, profile or object does not exist
+	exitConflict = 4 // another operation holds the lock or changed the data first
+)
+
+// Values of --error-format
+const (
+	errorFormatAuto = "auto"
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// errorFormat selects how failures are written to stderr; "auto" writes
+// JSON when stderr is not a terminal, e.g. under Ansible or in scripts
+var errorFormat = errorFormatAuto
+
+// commandPath names the running command in JSON errors
+var commandPath string
+
+// cliError is the JSON document written to stderr when a command fails
+type cliError struct {
+	Error    string `json:"error"`
+	Code     string `json:"code"`
+	ExitCode int    `json:"exit_code"`
+	Command  string `json:"command,omitempty"`
+}
+
+// fatalf reports a failure and exits. The exit code is derived from the
+// first error among args.
+func fatalf(format string, args ...interface{}) {
+	code := exitFailure
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			code = exitCodeFor(err)
+			break
+		}
+	}
+	exitf(code, format, args...)
+}
+
+// usagef reports invalid arguments or flags and exits with exitUsage
+func usagef(format string, args ...interface{}) {
+	exitf(exitUsage, format, args...)
+}
+
+// exitf reports a failure and exits with code
+func exitf(code int, format string, args ...interface{}) {
+	reportError(fmt.Sprintf(format, args...), code)
+	os.Exit(code)
+}
+
+func reportError(message string, code int) {
+	if !jsonErrors() {
+		fmt.Fprintln(os.Stderr, message)
+		return
+	}
+	encoded, _ := json.Marshal(cliError{
+		Error:    strings.TrimPrefix(message, "Error: "),
+		Code:     errorCodeName(code),
+		ExitCode: code,
+		Command:  commandPath,
+	})
+	fmt.Fprintln(os.Stderr, string(encoded))
+}
+
+func jsonErrors() bool {
+	switch errorFormat {
+	case errorFormatJSON:
+		return true
+	case errorFormatText:
+		return false
+	}
+	return !isatty.IsTerminal(os.Stderr.Fd())
+}
+
+func exitCodeFor(err error) int {
+	var apiErr *apiclient.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Status {
+		case http.StatusNotFound:
+			return exitNotFound
+		case http.StatusConflict, http.StatusLocked, http.StatusPreconditionFailed:
+			return exitConflict
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			return exitUsage
+		}
+		return exitFailure
+	}
+	switch {
+	case errors.Is(err, manager.ErrPoolNotFound), errors.Is(err, manager.ErrSiteNotFound),
+		errors.Is(err, manager.ErrProfileNotFound), errors.Is(err, manager.ErrInstallLogNotFound),
+		errors.Is(err, manager.ErrChangeNotFound), errors.Is(err, manager.ErrRevisionNotFound), errors.Is(err, objstore.ErrNotFound), errors.Is(err, os.ErrNotExist),
+		errors.Is(err, manager.ErrScheduleNotFound), errors.Is(err, manager.ErrWorkerNotFound),
+		errors.Is(err, manager.ErrCronNotFound):
+		return exitNotFound
+	case errors.Is(err, lock.ErrBusy), errors.Is(err, manager.ErrRevisionMismatch),
+		errors.Is(err, manager.ErrProfileExists), errors.Is(err, manager.ErrSpecConflict),
+		errors.Is(err, manager.ErrChangeNotPending), errors.Is(err, manager.ErrVersionInUse),
+		errors.Is(err, manager.ErrPoolExists), errors.Is(err, manager.ErrScheduleExists),
+		errors.Is(err, manager.ErrScheduleReadOnly), errors.Is(err, manager.ErrScheduleRunning),
+		errors.Is(err, manager.ErrWorkerExists), errors.Is(err, manager.ErrWorkersUnsupported),
+		errors.Is(err, manager.ErrPeriodNotOver):
+		return exitConflict
+	case errors.Is(err, manager.ErrUserMissing):
+		return exitUsage
+	}
+	return exitFailure
+}
+
+func errorCodeName(code int) string {
+	switch code {
+	case exitUsage:
+		return "usage"
+	case exitNotFound:
+		return "not_found"
+	case exitConflict:
+		return "conflict"
+	}
+	return "failed"
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/spf13/cobra"
+)
+
+var phpExtensionCmd = &cobra.Command{
+	Use:   "extension",
+	Short: "Manage PECL extensions per PHP version",
+}
+
+var phpExtensionInstallCmd = &cobra.Command{
+	Use:   "install [name]",
+	Short: "Install a PECL extension for a PHP version",
+	Long: `Install a PECL extension such as swoole or redis for a PHP version from the
+provider's package. With --build-from-source an extension without a package is
+compiled from the PECL source instead: the provider's development package and
+a compiler are installed, the build runs as nobody in a scratch directory, and
+the module is enabled with an ini snippet. A release can then be pinned, as in
+swoole-5.1.2.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		version, _ := cmd.Flags().GetString("php")
+		providerName, _ := cmd.Flags().GetString("provider")
+		fromSource, _ := cmd.Flags().GetBool("build-from-source")
+
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		status, err := pm.InstallExtension(version, provider.ProviderType(providerName), args[0], fromSource)
+		if err != nil {
+			fatalf("Error installing extension: %v", err)
+		}
+		printExtensionStatus(cmd, version, providerName, status)
+	},
+}
+
+func printExtensionStatus(cmd *cobra.Command, version, providerName string, status *manager.ExtensionStatus) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if status.Method == manager.ExtensionFromSource {
+		fmt.Printf("%s %s built from source for PHP %s: %s (enabled in %s)\n", status.Extension, status.Release, version, status.Path, status.INIPath)
+		return
+	}
+	fmt.Printf("%s installed for PHP %s from the %s package\n", status.Extension, version, providerName)
+}
+
+func init() {
+	phpCmd.AddCommand(phpExtensionCmd)
+	phpExtensionCmd.AddCommand(phpExtensionInstallCmd)
+	phpExtensionInstallCmd.Flags().String("php", "8.2", "PHP version")
+	phpExtensionInstallCmd.Flags().String("provider", "remi", "PHP provider (remi, lsphp, alt-php, docker, system)")
+	phpExtensionInstallCmd.Flags().Bool("build-from-source", false, "Compile the extension from the PECL source when no package exists")
+	phpExtensionInstallCmd.Flags().Bool("json", false, "Print the result as JSON")
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/spf13/cobra"
+)
+
+var phpFPMConfigCmd = &cobra.Command{
+	Use:   "fpm-config",
+	Short: "Manage PHP-FPM master ([global]) settings per PHP version",
+	Long: `Manage the [global] section of a PHP version's php-fpm.conf. Managed keys:
+error_log (absolute path or syslog), log_level (alert, error, warning,
+notice, debug), daemonize, emergency_restart_threshold,
+emergency_restart_interval, process_control_timeout (durations such as 60
+or 1m) and process.max. Distro units usually start php-fpm with
+--nodaemonize, which wins over daemonize.`,
+}
+
+var phpFPMConfigShowCmd = &cobra.Command{
+	Use:   "show [version]",
+	Short: "Show the master settings of a PHP version",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, providerType := fpmPackageManager(cmd)
+		cfg, err := pm.GetFPMConfig(args[0], providerType)
+		if err != nil {
+			fatalf("Error reading FPM config: %v", err)
+		}
+		printFPMConfig(cmd, cfg)
+	},
+}
+
+func printFPMConfig(cmd *cobra.Command, cfg *manager.FPMConfig) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(cfg, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	fmt.Printf("%s\n", cfg.Path)
+	for _, key := range manager.FPMConfigKeys {
+		value, set := cfg.Effective[key]
+		source := "managed"
+		switch {
+		case !set:
+			value, source = "-", "php-fpm default"
+		case cfg.Settings[key] == "":
+			source = "distro"
+		}
+		fmt.Printf("  %-28s %-40s (%s)\n", key, value, source)
+	}
+}
+
+var phpFPMConfigSetCmd = &cobra.Command{
+	Use:   "set [version] [key=value...]",
+	Short: "Change master settings of a PHP version and reload its FPM service",
+	Long:  "Change master settings of a PHP version, test the file with php-fpm -t and reload its FPM service. An empty value (key=) removes the managed setting and restores the distro's line.",
+	Example: `  lightweight-php php fpm-config set 8.3 log_level=debug
+  lightweight-php php fpm-config set 8.3 emergency_restart_threshold=10 emergency_restart_interval=1m
+  lightweight-php php fpm-config set 8.3 error_log=/var/log/php83-fpm.log
+  lightweight-php php fpm-config set 8.3 log_level=`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		changes := parseFPMConfigArgs(args[1:])
+		pm, providerType := fpmPackageManager(cmd)
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		cfg, err := pm.UpdateFPMConfig(args[0], providerType, changes)
+		if err != nil {
+			fatalf("Error configuring PHP-FPM: %v", err)
+		}
+		printFPMSettings(args[0], cfg)
+	},
+}
+
+// parseFPMConfigArgs parses key=value master settings; an empty value is
+// nil, which removes the managed setting
+func parseFPMConfigArgs(args []string) map[string]interface{} {
+	changes, err := parseSettingArgs(args)
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	for key, value := range changes {
+		if value == "" {
+			changes[key] = nil
+		}
+		if _, err := manager.NormalizeFPMSetting(key, changes[key]); err != nil {
+			usagef("Error: invalid %s: %v", key, err)
+		}
+	}
+	return changes
+}
+
+func printFPMSettings(version string, cfg *manager.FPMConfig) {
+	var parts []string
+	for _, key := range manager.FPMConfigKeys {
+		if value, ok := cfg.Settings[key]; ok {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	if len(parts) == 0 {
+		fmt.Printf("PHP-FPM %s uses the distro's master settings (%s)\n", version, cfg.Path)
+		return
+	}
+	fmt.Printf("PHP-FPM %s master settings in %s: %s\n", version, cfg.Path, strings.Join(parts, " "))
+}
+
+var phpFPMLogCmd = &cobra.Command{
+	Use:   "fpm-log [version]",
+	Short: "Show the tail of a PHP version's FPM master log",
+	Long: `Show the tail of a PHP version's FPM master log, where php-fpm reports why
+pools fail to start. It is read from error_log, or from the service's journal
+when php-fpm logs to syslog.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lines := linesFlag(cmd)
+		pm, providerType := fpmPackageManager(cmd)
+		log, err := pm.FPMMasterLog(args[0], providerType, lines)
+		if err != nil {
+			fatalf("Error reading FPM master log: %v", err)
+		}
+		for _, line := range log.Lines {
+			fmt.Println(line)
+		}
+	},
+}
+
+func fpmPackageManager(cmd *cobra.Command) (*manager.PackageManager, provider.ProviderType) {
+	pm, err := newPackageManagerFor(cmd)
+	if err != nil {
+		fatalf("Error initializing package manager: %v", err)
+	}
+	providerType := provider.ProviderType(pm.GetProvider().GetProviderType())
+	if name, _ := cmd.Flags().GetString("provider"); name != "" {
+		providerType = provider.ProviderType(name)
+	}
+	if providerType == provider.ProviderLiteSpeed {
+		usagef("Error: lsphp has no PHP-FPM master process")
+	}
+	return pm, providerType
+}
+
+func init() {
+	phpCmd.AddCommand(phpFPMConfigCmd)
+	phpCmd.AddCommand(phpFPMLogCmd)
+	phpFPMConfigCmd.AddCommand(phpFPMConfigShowCmd)
+	phpFPMConfigCmd.AddCommand(phpFPMConfigSetCmd)
+	for _, c := range []*cobra.Command{phpFPMConfigShowCmd, phpFPMConfigSetCmd, phpFPMLogCmd} {
+		c.Flags().String("provider", "", "PHP provider (default: the default provider)")
+	}
+	phpFPMConfigShowCmd.Flags().Bool("json", false, "Print the settings as JSON")
+	phpFPMLogCmd.Flags().IntP("lines", "n", 100, "Number of lines to show")
+}
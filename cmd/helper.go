@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"lightweight-php/audit"
+	"lightweight-php/config"
+	"lightweight-php/helper"
+	"lightweight-php/target"
+
+	"github.com/spf13/cobra"
+)
+
+var helperCmd = &cobra.Command{
+	Use:   "helper",
+	Short: "Run operations for unprivileged users (run as root)",
+	Long: "Listen on helper.socket and run lightweight-php commands for the users and groups " +
+		"the helper.rules of the config file allow. Commands run by other users than root " +
+		"are sent to the helper when its socket exists.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if os.Geteuid() != 0 {
+			fatalf("Error: the helper must run as root")
+		}
+		cfg := config.Get()
+		self, err := os.Executable()
+		if err != nil {
+			fatalf("Error locating executable: %v", err)
+		}
+		l, err := helper.Listen(cfg.Helper.Socket, cfg.Helper.Group)
+		if err != nil {
+			fatalf("Error listening on %s: %v", cfg.Helper.Socket, err)
+		}
+		defer os.Remove(cfg.Helper.Socket)
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			l.Close()
+		}()
+
+		log.Printf("Helper listening on %s with %d policy rules", cfg.Helper.Socket, len(cfg.Helper.Rules))
+		s := &helper.Server{Executable: self, Operation: helperOperation, Rules: cfg.Helper.Rules}
+		if err := s.Serve(l); err != nil {
+			fatalf("Error serving helper requests: %v", err)
+		}
+	},
+}
+
+// localOperations are never sent to the helper: the helper itself, the
+// API server, interactive shells, and commands that need no privileges
+var localOperations = map[string]bool{
+	"helper":     true,
+	"server":     true,
+	"pool.shell": true,
+	"completion": true,
+	"dev-exec":   true,
+	"api":        true,
+}
+
+func runsLocally(cmd *cobra.Command) bool {
+	return !cmd.HasParent() || localOperations[operationName(cmd)] ||
+		cmd.Name() == "help" || strings.HasPrefix(cmd.Name(), "__")
+}
+
+// operationName names a command for the helper policy, e.g. pool.create
+func operationName(cmd *cobra.Command) string {
+	path := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	return strings.ReplaceAll(path, " ", ".")
+}
+
+// helperOperation names the operation of a request's arguments, refusing
+// commands the helper does not run and development mode, which would let
+// callers pick a directory written to as root
+func helperOperation(args []string) (string, error) {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--dev" || strings.HasPrefix(arg, "--dev=") || arg == "--dev-dir" || strings.HasPrefix(arg, "--dev-dir=") {
+			return "", fmt.Errorf("development mode is not available through the helper")
+		}
+	}
+	cmd, _, err := rootCmd.Find(args)
+	if err != nil {
+		return "", err
+	}
+	if runsLocally(cmd) || !cmd.Runnable() {
+		return "", fmt.Errorf("%s is not run by the helper", cmd.CommandPath())
+	}
+	return operationName(cmd), nil
+}
+
+// helperOutputs are the operations that write a file the caller names,
+// with the file each writes ("" for none, "-" for standard output). The
+// helper would create it as root, so the client opens it as the caller and
+// has the operation write to standard output instead (see
+// forwardToHelper), and the helper refuses any other file.
+var helperOutputs = map[string]func(cmd *cobra.Command, args []string) string{
+	"pool.export-bundle":  bundleOutput,
+	"pool.export-compose": outputFlag,
+	"pool.export":         outputFlag,
+	"backup.create":       outputFlag,
+}
+
+func outputFlag(cmd *cobra.Command, args []string) string {
+	output, _ := cmd.Flags().GetString("output")
+	return output
+}
+
+// helperFileFlags are flags that name a file or directory on the host for
+// an operation to read or write, which the helper refuses
+var helperFileFlags = map[string][]string{
+	"pool.create":        {"ssh-key-file"},
+	"pool.create-bulk":   {"file"},
+	"templates.debug":    {"file"},
+	"templates.validate": {"file"},
+	"db.restore":         {"output"},
+}
+
+// helperStoreFlags name object stores, which the helper refuses when they
+// are local directories (a path or file://); the default store is the one
+// the config file sets
+var helperStoreFlags = map[string][]string{
+	"backup.create":  {"to"},
+	"backup.run":     {"to"},
+	"backup.list":    {"from"},
+	"backup.restore": {"from"},
+	"db.snapshots":   {"from"},
+	"db.restore":     {"from"},
+}
+
+// helperFileArgs are operations whose arguments are files to read, which
+// the helper refuses
+var helperFileArgs = map[string]bool{
+	"pool.import":        true,
+	"pool.import-bundle": true,
+}
+
+// checkHelperRequest refuses what an operation run by the helper for
+// caller could do as root beyond what its policy allows: read or write a
+// file the caller names, work inside a chroot the caller picks, or, when
+// the rule only allows the caller's own pool (scope helper.ScopeOwn), act
+// on another pool. cmd's flags must be parsed.
+func checkHelperRequest(cmd *cobra.Command, args []string, caller, scope string) error {
+	operation := operationName(cmd)
+	refuse := func(format string, a ...interface{}) error {
+		return fmt.Errorf("%w: %s", helper.ErrDenied, fmt.Sprintf(format, a...))
+	}
+
+	if output, ok := helperOutputs[operation]; ok {
+		if file := output(cmd, args); file != "" && file != "-" {
+			return refuse("%s may only write to standard output through the helper, not to %s", operation, file)
+		}
+	}
+	for _, name := range helperFileFlags[operation] {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return refuse("--%s of %s names a file on the host; run it as root", name, operation)
+		}
+	}
+	for _, name := range helperStoreFlags[operation] {
+		if value, _ := cmd.Flags().GetString(name); strings.HasPrefix(value, "/") || strings.HasPrefix(value, "file:") {
+			return refuse("--%s of %s is a local directory; run it as root", name, operation)
+		}
+	}
+	if helperFileArgs[operation] {
+		return refuse("%s reads a file on the host; run it as root", operation)
+	}
+	if flag := cmd.Flags().Lookup("target"); flag != nil && strings.HasPrefix(flag.Value.String(), target.KindChroot+":") {
+		return refuse("--target of %s may not be a chroot through the helper; run it as root", operation)
+	}
+
+	if scope != helper.ScopeOwn {
+		return nil
+	}
+	fields := strings.Fields(cmd.Use)
+	if len(fields) < 2 || fields[1] != "[username]" || len(args) == 0 {
+		return refuse("%s may only act on their own pool, and %s names none", caller, operation)
+	}
+	if all := cmd.Flags().Lookup("all"); all != nil && all.Changed {
+		return refuse("%s may only act on their own pool, not with --all", caller)
+	}
+	if args[0] != caller {
+		return refuse("%s may only act on their own pool, not on %s", caller, args[0])
+	}
+	return nil
+}
+
+// checkHelperCaller applies checkHelperRequest when the helper runs this
+// process for a caller; it alone sets helper.ScopeEnv
+func checkHelperCaller(cmd *cobra.Command, args []string) {
+	scope := os.Getenv(helper.ScopeEnv)
+	if scope == "" || os.Geteuid() != 0 {
+		return
+	}
+	if err := checkHelperRequest(cmd, args, os.Getenv(audit.CallerEnv), scope); err != nil {
+		fatalf("Error: %v", err)
+	}
+}
+
+// forwardToHelper sends the command line to the helper when an
+// unprivileged user runs a command that needs root and the helper is
+// listening, and exits with the operation's exit code. It returns when
+// the command should run here. A file the operation writes is created here,
+// as the caller, and filled from the operation's standard output.
+func forwardToHelper(cmd *cobra.Command, positional []string) {
+	if os.Geteuid() == 0 || devMode || runsLocally(cmd) || !cmd.Runnable() {
+		return
+	}
+	socket := config.Get().Helper.Socket
+	if _, err := os.Stat(socket); err != nil {
+		return
+	}
+	// The helper's stderr is never a terminal; keep this one's format
+	format := errorFormatText
+	if jsonErrors() {
+		format = errorFormatJSON
+	}
+	args := append([]string{"--error-format", format}, os.Args[1:]...)
+
+	var stdout io.Writer = os.Stdout
+	file := ""
+	if output, ok := helperOutputs[operationName(cmd)]; ok {
+		if file = output(cmd, positional); file == "-" {
+			file = ""
+		}
+	}
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			fatalf("Error creating %s: %v", file, err)
+		}
+		defer f.Close()
+		stdout = f
+		args = insertFlag(args, "--output=-")
+	}
+
+	code, err := helper.Run(socket, args, stdout, os.Stderr)
+	if err == nil && file != "" {
+		err = stdout.(*os.File).Close()
+	}
+	if file != "" && (err != nil || code != 0) {
+		os.Remove(file)
+	}
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if file != "" && code == 0 {
+		fmt.Printf("Written to %s\n", file)
+	}
+	os.Exit(code)
+}
+
+// insertFlag adds flag to a command line before the arguments that follow
+// "--", overriding an earlier value of the flag
+func insertFlag(args []string, flag string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return append(append(append([]string{}, args[:i]...), flag), args[i:]...)
+		}
+	}
+	return append(args, flag)
+}
+
+func init() {
+	rootCmd.AddCommand(helperCmd)
+}
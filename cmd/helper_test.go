@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"lightweight-php/helper"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// parseCommandLine finds the command of a command line and parses its
+// flags as cobra does before running it. The commands are shared, so their
+// flags are reset before and after.
+func parseCommandLine(t *testing.T, line ...string) (*cobra.Command, []string) {
+	t.Helper()
+	cmd, rest, err := rootCmd.Find(line)
+	if err != nil {
+		t.Fatalf("%v: %v", line, err)
+	}
+	resetFlags(cmd)
+	t.Cleanup(func() { resetFlags(cmd) })
+	if err := cmd.ParseFlags(rest); err != nil {
+		t.Fatalf("%v: %v", line, err)
+	}
+	return cmd, cmd.Flags().Args()
+}
+
+func resetFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			f.Value.Set(f.DefValue)
+			f.Changed = false
+		}
+	})
+}
+
+func TestHelperRefusesHostFiles(t *testing.T) {
+	denied := [][]string{
+		{"pool", "export-bundle", "bob", "-o", "/etc/cron.d/x"},
+		{"pool", "export-bundle", "bob", "--output=/etc/cron.d/x"},
+		{"pool", "export-bundle", "bob", "-o", "x"},
+		{"pool", "export-bundle", "bob"},
+		{"pool", "export-compose", "bob"},
+		{"pool", "export-compose", "bob", "-o", "/etc/cron.d/x"},
+		{"pool", "export", "bob", "-o", "/etc/cron.d/x"},
+		{"backup", "create", "bob", "-o", "/etc/cron.d/x"},
+		{"backup", "create", "bob", "--to", "file:///etc/cron.d"},
+		{"backup", "create", "bob", "--to", "/etc/cron.d"},
+		{"backup", "restore", "--from", "/root/secret.tar.gz"},
+		{"db", "restore", "--output", "/etc/passwd"},
+		{"pool", "create", "bob", "--ssh-key-file", "/root/.ssh/id_ed25519"},
+		{"pool", "create-bulk", "--file", "/etc/shadow"},
+		{"pool", "import-bundle", "/root/bob.tar.gz"},
+		{"pool", "import", "/root/bob.json"},
+		{"pool", "create", "bob", "--target", "chroot:/home/bob/jail"},
+		{"php", "install", "8.3", "--target", "chroot:/home/bob/jail"},
+	}
+	for _, line := range denied {
+		cmd, args := parseCommandLine(t, line...)
+		err := checkHelperRequest(cmd, args, "bob", helper.ScopeAny)
+		if !errors.Is(err, helper.ErrDenied) {
+			t.Errorf("%v: checkHelperRequest = %v, want it denied", line, err)
+		}
+	}
+
+	allowed := [][]string{
+		{"pool", "export-bundle", "bob", "-o", "-"},
+		{"pool", "export-compose", "bob", "-o", "-"},
+		{"pool", "export", "bob"},
+		{"backup", "create", "bob"},
+		{"backup", "create", "bob", "--to", "s3://backups/web1"},
+		{"pool", "create", "bob", "--target", "nspawn:web1"},
+		{"pool", "set", "alice", "memory_limit=256M"},
+	}
+	for _, line := range allowed {
+		cmd, args := parseCommandLine(t, line...)
+		if err := checkHelperRequest(cmd, args, "bob", helper.ScopeAny); err != nil {
+			t.Errorf("%v: checkHelperRequest = %v, want nil", line, err)
+		}
+	}
+}
+
+func TestHelperOwnPools(t *testing.T) {
+	denied := [][]string{
+		{"pool", "set", "alice", "memory_limit=256M"},
+		{"pool", "delete", "alice"},
+		{"pool", "env", "set", "alice", "A=1"},
+		{"pool", "set", "--all", "memory_limit=256M"},
+		{"pool", "set", "--all", "bob"},
+		{"pool", "list"},
+		{"site", "delete", "example.com"},
+	}
+	for _, line := range denied {
+		cmd, args := parseCommandLine(t, line...)
+		err := checkHelperRequest(cmd, args, "bob", helper.ScopeOwn)
+		if !errors.Is(err, helper.ErrDenied) {
+			t.Errorf("%v: checkHelperRequest = %v, want it denied", line, err)
+		}
+	}
+
+	allowed := [][]string{
+		{"pool", "set", "bob", "memory_limit=256M"},
+		{"pool", "env", "set", "bob", "A=1"},
+		{"pool", "export-bundle", "bob", "-o", "-"},
+		{"pool", "status", "bob"},
+	}
+	for _, line := range allowed {
+		cmd, args := parseCommandLine(t, line...)
+		if err := checkHelperRequest(cmd, args, "bob", helper.ScopeOwn); err != nil {
+			t.Errorf("%v: checkHelperRequest = %v, want nil", line, err)
+		}
+	}
+}
+
+func TestInsertFlag(t *testing.T) {
+	got := insertFlag([]string{"pool", "export-bundle", "bob", "-o", "x"}, "--output=-")
+	want := []string{"pool", "export-bundle", "bob", "-o", "x", "--output=-"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("insertFlag = %v, want %v", got, want)
+	}
+	got = insertFlag([]string{"pool", "shell", "bob", "--", "ls", "-o"}, "--output=-")
+	want = []string{"pool", "shell", "bob", "--output=-", "--", "ls", "-o"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("insertFlag = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var hostCmd = &cobra.Command{
+	Use:   "host",
+	Short: "Manage this host's service state",
+}
+
+var hostEvacuateCmd = &cobra.Command{
+	Use:   "evacuate",
+	Short: "Migrate all accounts to other hosts and drain this host",
+	Long: "Migrate every account (pool bundle + home directory) to the target hosts, " +
+		"distributing accounts round-robin, then mark this host as drained so no new pools are created.",
+	Run: func(cmd *cobra.Command, args []string) {
+		targets, _ := cmd.Flags().GetString("to")
+		opts := manager.EvacuationOptions{}
+		opts.SSHUser, _ = cmd.Flags().GetString("ssh-user")
+		opts.APIKey, _ = cmd.Flags().GetString("target-api-key")
+		if opts.APIKey == "" {
+			opts.APIKey = os.Getenv(targetAPIKeyEnv)
+		}
+		opts.Throttle, _ = cmd.Flags().GetDuration("throttle")
+		opts.BandwidthKBs, _ = cmd.Flags().GetInt("bwlimit")
+		for _, t := range strings.Split(targets, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				opts.Targets = append(opts.Targets, t)
+			}
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		err = pm.EvacuateHost(opts, func(p manager.EvacuationProgress) {
+			fmt.Printf("[%d/%d] %s -> %s: %s\n", p.Index, p.Total, p.Username, p.Target, p.Step)
+		})
+		if err != nil {
+			fatalf("Error evacuating host: %v", err)
+		}
+		fmt.Println("Host evacuated and marked as drained")
+	},
+}
+
+var hostStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether this host is drained",
+	Run: func(cmd *cobra.Command, args []string) {
+		state, err := manager.GetHostDrainState()
+		if err != nil {
+			fatalf("Error reading host state: %v", err)
+		}
+		if state == nil {
+			fmt.Println("Host is in service")
+			return
+		}
+		fmt.Printf("Host drained at %s (%d accounts moved to %s)\n",
+			state.DrainedAt.Format(time.RFC3339), state.Migrated, strings.Join(state.Targets, ", "))
+	},
+}
+
+var hostUndrainCmd = &cobra.Command{
+	Use:   "undrain",
+	Short: "Put a drained host back into service",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := manager.UndrainHost(); err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Println("Host is back in service")
+	},
+}
+
+var hostFirewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "Open firewall ports for the TCP pools on this host",
+	Long: "Open the port of every pool listening on TCP beyond loopback in the firewall set by firewall.tool, " +
+		"scoped to firewall.pool_sources. Pools changed after the firewall was configured are handled automatically.",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		opened, err := pm.SyncFirewall()
+		for _, p := range opened {
+			sources := "any"
+			if len(p.Sources) > 0 {
+				sources = strings.Join(p.Sources, ", ")
+			}
+			fmt.Printf("Opened port %d/tcp for %s (%s)\n", p.Port, sources, p.Comment)
+		}
+		if err != nil {
+			fatalf("Error updating firewall: %v", err)
+		}
+		if len(opened) == 0 {
+			fmt.Println("No TCP pools need open ports")
+		}
+	},
+}
+
+var hostSFTPCmd = &cobra.Command{
+	Use:   "sftp",
+	Short: "Give the users of existing pools an SFTP-only login",
+	Long: "Write the chrooted SFTP-only login of sftp.enabled for the user of every pool on this host that has none yet. " +
+		"Pools created after SFTP logins were enabled get theirs automatically.",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		added, err := pm.SyncSFTP()
+		for _, username := range added {
+			fmt.Printf("Added SFTP login for %s\n", username)
+		}
+		if err != nil {
+			fatalf("Error adding SFTP logins: %v", err)
+		}
+		if len(added) == 0 {
+			fmt.Println("All pools have an SFTP login")
+		}
+	},
+}
+
+func init() {
+	hostCmd.AddCommand(hostEvacuateCmd)
+	hostCmd.AddCommand(hostFirewallCmd)
+	hostCmd.AddCommand(hostSFTPCmd)
+	hostCmd.AddCommand(hostStatusCmd)
+	hostCmd.AddCommand(hostUndrainCmd)
+	hostEvacuateCmd.Flags().String("to", "", "Comma-separated target hosts")
+	hostEvacuateCmd.Flags().String("ssh-user", "root", "SSH user on the target hosts")
+	hostEvacuateCmd.Flags().String("target-api-key", "", "API key for the targets' APIs (default $"+targetAPIKeyEnv+")")
+	hostEvacuateCmd.Flags().Duration("throttle", 30*time.Second, "Pause between accounts")
+	hostEvacuateCmd.Flags().Int("bwlimit", 0, "rsync bandwidth limit in KB/s (0 = unlimited)")
+	hostEvacuateCmd.MarkFlagRequired("to")
+}
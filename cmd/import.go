@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import pools and sites from a hosting panel",
+}
+
+// newPanelImportCmd returns the import command of one hosting panel
+func newPanelImportCmd(panel, name, long string) *cobra.Command {
+	c := &cobra.Command{
+		Use:   panel,
+		Short: "Create pools and sites for the PHP-FPM accounts of " + name,
+		Long:  long,
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			scan, _ := cmd.Flags().GetBool("scan")
+			providerType, _ := cmd.Flags().GetString("provider")
+
+			plan, err := manager.ScanPanel(panel)
+			if err != nil {
+				fatalf("Error scanning %s: %v", name, err)
+			}
+			pm, err := newPoolManager()
+			if err != nil {
+				fatalf("Error initializing pool manager: %v", err)
+			}
+			if noWait {
+				pm = pm.WithNoWait()
+			}
+			if !scan {
+				beginMaintenance(cmd)
+			}
+			if err := pm.ImportPanel(plan, providerType, !scan); err != nil {
+				fatalf("Error importing from %s: %v", name, err)
+			}
+
+			if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+				encoded, _ := json.MarshalIndent(plan, "", "  ")
+				fmt.Println(string(encoded))
+			} else {
+				printPanelImport(plan)
+			}
+			failed := 0
+			for _, a := range plan.Accounts {
+				for _, p := range a.Pools {
+					if p.Result == manager.PanelFailed {
+						failed++
+					}
+				}
+				for _, s := range a.Sites {
+					if s.Result == manager.PanelFailed {
+						failed++
+					}
+				}
+			}
+			if failed > 0 {
+				exitf(exitFailure, "Error: %d pools or sites cannot be imported", failed)
+			}
+		},
+	}
+	c.Flags().Bool("scan", false, "Only report what would be imported")
+	c.Flags().String("provider", "remi", "PHP provider of the created pools (remi, lsphp, alt-php, docker, system)")
+	c.Flags().Bool("json", false, "Print the import as JSON")
+	addMaintenanceFlags(c)
+	return c
+}
+
+func printPanelImport(plan *manager.PanelImport) {
+	if len(plan.Accounts) == 0 {
+		fmt.Println("No PHP-FPM accounts found")
+	}
+	for _, a := range plan.Accounts {
+		fmt.Printf("%s\n", a.Username)
+		for _, p := range a.Pools {
+			line := fmt.Sprintf("  pool PHP %-6s %-8s %d settings", p.PHPVersion, p.Result, len(p.Settings))
+			if p.Source != "" {
+				line += " from " + p.Source
+			}
+			if p.Error != "" {
+				line += ": " + p.Error
+			}
+			fmt.Println(line)
+		}
+		for _, s := range a.Sites {
+			line := fmt.Sprintf("  site %-30s %-8s PHP %s, %s", s.Domain, s.Result, s.PHPVersion, s.DocumentRoot)
+			if s.Error != "" {
+				line += ": " + s.Error
+			}
+			fmt.Println(line)
+		}
+	}
+	for _, s := range plan.Skipped {
+		fmt.Printf("skipped: %s\n", s)
+	}
+}
+
+func init() {
+	importCmd.AddCommand(newPanelImportCmd(manager.PanelCPanel, "cPanel", `Read the domains of every cPanel account from /var/cpanel/userdata and the
+EasyApache 4 (MultiPHP) PHP version each is served by, and create a pool per
+account and PHP version with the settings of the domain's ea-php PHP-FPM pool
+(pm, pm.* and php_value/php_admin_value overrides such as memory_limit) plus
+a site per domain. Addon domains keep their own name; parked domains are not
+imported.
+
+Pools and sites that already exist are left alone, so the import can be run
+again after installing missing PHP versions. --scan only reports the plan.`))
+	importCmd.AddCommand(newPanelImportCmd(manager.PanelPlesk, "Plesk", `Read the domains under /var/www/vhosts/system and find the Plesk PHP version
+whose PHP-FPM pool directory has a pool for each. The pool's user becomes the
+pool user, its pm and php_value settings the pool settings, and the document
+root comes from the domain's generated nginx configuration.
+
+Pools and sites that already exist are left alone, so the import can be run
+again after installing missing PHP versions. --scan only reports the plan.`))
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/spf13/cobra"
+)
+
+var phpInstallsCmd = &cobra.Command{
+	Use:   "installs [id]",
+	Short: "Show the install history of a provider",
+	Long: "List the recorded PHP and extension installs of a provider with their outcome, " +
+		"or print the full transcript of one install (repository setup, package manager output and service start).",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		providerName, _ := cmd.Flags().GetString("provider")
+		pm, err := newPackageManager()
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+
+		if len(args) == 1 {
+			log, err := pm.GetInstallLog(provider.ProviderType(providerName), parseInstallID(args[0]))
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+			printInstallLog(cmd, log)
+			return
+		}
+
+		limit, _ := cmd.Flags().GetInt("limit")
+		logs, err := pm.ListInstallLogs(provider.ProviderType(providerName), limit)
+		if err != nil {
+			fatalf("Error listing installs: %v", err)
+		}
+		printInstallLogs(cmd, providerName, logs)
+	},
+}
+
+func parseInstallID(arg string) int64 {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		usagef("Error: invalid install id %q", arg)
+	}
+	return id
+}
+
+func printInstallLog(cmd *cobra.Command, log *manager.InstallLog) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(log, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	fmt.Printf("#%d %s %s %s: %s (started %s)\n", log.ID, log.Provider, log.Operation, log.Version, log.Status, log.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	if log.Error != "" {
+		fmt.Printf("Error: %s\n", log.Error)
+	}
+	for _, r := range log.Rollback {
+		if r.Error != "" {
+			fmt.Printf("Could not undo: %s: %s\n", r.Change, r.Error)
+		} else {
+			fmt.Printf("Rolled back: %s\n", r.Change)
+		}
+	}
+	fmt.Println()
+	fmt.Print(log.Output)
+}
+
+func printInstallLogs(cmd *cobra.Command, providerName string, logs []manager.InstallLog) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(logs, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(logs) == 0 {
+		fmt.Printf("No installs recorded for provider %s\n", providerName)
+		return
+	}
+	for _, l := range logs {
+		where := l.Target
+		if where == "" {
+			where = "host"
+		}
+		fmt.Printf("%5d  %s  %-16s %-6s %-8s %7.1fs  %s\n", l.ID, l.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			l.Operation, l.Version, l.Status, float64(l.DurationMs)/1000, where)
+	}
+}
+
+func init() {
+	phpCmd.AddCommand(phpInstallsCmd)
+	phpInstallsCmd.Flags().String("provider", "remi", "PHP provider (remi, lsphp, alt-php, docker, system)")
+	phpInstallsCmd.Flags().Int("limit", 20, "Number of installs to list (0 for all)")
+	phpInstallsCmd.Flags().Bool("json", false, "Print as JSON")
+}
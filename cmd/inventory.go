@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "Print pools and PHP versions as an Ansible inventory",
+	Long: `Print the pools as an Ansible inventory. Every pool is a host named
+USER-VERSION-PROVIDER (bob-8.3-remi), with its user, PHP version, provider,
+listener and settings as hostvars, grouped by PHP version (php_8_2), by
+provider (provider_remi) and by user (user_bob). The installed PHP versions
+are in the php_installed variable of the "all" group.
+
+The command follows the dynamic inventory protocol; to use it as an
+inventory script, wrap it in an executable file:
+
+  #!/bin/sh
+  exec lightweight-php inventory "$@"
+
+--list (the default) prints the whole inventory with _meta.hostvars and
+--host NAME prints the hostvars of one pool.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ansibleHost := inventoryOptions(cmd)
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error building inventory: %v", err)
+		}
+		pools, err := pm.ListPools()
+		if err != nil {
+			fatalf("Error building inventory: %v", err)
+		}
+		var installed []string
+		if packages, err := newPackageManager(); err == nil {
+			if versions, err := packages.ListInstalledPHP(); err == nil {
+				installed = versions
+			}
+		}
+		settings := func(pool manager.Pool) map[string]interface{} {
+			if cfg, err := pm.GetPoolConfig(pool.User); err == nil {
+				return cfg.Settings
+			}
+			return nil
+		}
+		printInventory(cmd, buildAnsibleInventory(pools, installed, settings, ansibleHost))
+	},
+}
+
+// inventoryOptions validates the flags of inventory and returns
+// --ansible-host
+func inventoryOptions(cmd *cobra.Command) string {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "ansible" {
+		usagef("Error: unknown format %q; supported: ansible", format)
+	}
+	ansibleHost, _ := cmd.Flags().GetString("ansible-host")
+	return ansibleHost
+}
+
+// printInventory prints the whole inventory, or with --host the hostvars
+// of one pool
+func printInventory(cmd *cobra.Command, inventory *ansibleInventory) {
+	var out interface{} = inventory
+	if host, _ := cmd.Flags().GetString("host"); host != "" {
+		vars, ok := inventory.Meta.HostVars[host]
+		if !ok {
+			exitf(exitNotFound, "Error: no pool named %s", host)
+		}
+		out = vars
+	}
+	encoded, _ := json.MarshalIndent(out, "", "  ")
+	fmt.Println(string(encoded))
+}
+
+// ansibleInventory is the JSON document of an Ansible dynamic inventory
+// script: one entry per group plus _meta with the hostvars of every host
+type ansibleInventory struct {
+	Groups map[string]ansibleGroup
+	Meta   struct {
+		HostVars map[string]map[string]interface{} `json:"hostvars"`
+	}
+}
+
+type ansibleGroup struct {
+	Hosts    []string               `json:"hosts,omitempty"`
+	Children []string               `json:"children,omitempty"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+}
+
+func (inv *ansibleInventory) MarshalJSON() ([]byte, error) {
+	doc := make(map[string]interface{}, len(inv.Groups)+1)
+	for name, group := range inv.Groups {
+		doc[name] = group
+	}
+	doc["_meta"] = inv.Meta
+	return json.Marshal(doc)
+}
+
+func (inv *ansibleInventory) addHost(group, host string) {
+	g := inv.Groups[group]
+	g.Hosts = append(g.Hosts, host)
+	inv.Groups[group] = g
+}
+
+// buildAnsibleInventory makes the inventory of pools, with the installed
+// PHP versions, when known, and the settings that settings returns. Pools
+// come newest first per user, as ListPools returns them; settings is only
+// asked for the newest, whose settings the pool commands change.
+func buildAnsibleInventory(pools []manager.Pool, installed []string, settings func(manager.Pool) map[string]interface{}, ansibleHost string) *ansibleInventory {
+	inv := &ansibleInventory{Groups: map[string]ansibleGroup{}}
+	inv.Meta.HostVars = make(map[string]map[string]interface{}, len(pools))
+	all := ansibleGroup{Vars: map[string]interface{}{}}
+	if installed != nil {
+		all.Vars["php_installed"] = installed
+	}
+
+	settled := map[string]bool{}
+	for _, pool := range pools {
+		host := inventoryHostName(pool)
+		vars := map[string]interface{}{
+			"pool_user":   pool.User,
+			"php_version": pool.PHPVersion,
+			"provider":    pool.Provider,
+			"status":      pool.Status,
+			"config_path": pool.ConfigPath,
+			"socket_path": pool.SocketPath,
+		}
+		if pool.Target != "" {
+			vars["target"] = pool.Target
+		}
+		if ansibleHost != "" {
+			vars["ansible_host"] = ansibleHost
+		}
+		if !settled[pool.User] {
+			settled[pool.User] = true
+			if s := settings(pool); s != nil {
+				vars["settings"] = s
+			}
+		}
+		inv.Meta.HostVars[host] = vars
+
+		inv.addHost(inventoryGroupName("php", pool.PHPVersion), host)
+		inv.addHost(inventoryGroupName("provider", pool.Provider), host)
+		inv.addHost(inventoryGroupName("user", pool.User), host)
+	}
+
+	for name := range inv.Groups {
+		all.Children = append(all.Children, name)
+	}
+	sort.Strings(all.Children)
+	inv.Groups["all"] = all
+	return inv
+}
+
+// inventoryHostName names a pool's host; a user can have a pool per PHP
+// version and provider
+func inventoryHostName(pool manager.Pool) string {
+	return pool.User + "-" + pool.PHPVersion + "-" + pool.Provider
+}
+
+// inventoryGroupName makes a valid Ansible group name such as php_8_2
+func inventoryGroupName(prefix, value string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, value)
+	return prefix + "_" + name
+}
+
+func init() {
+	inventoryCmd.Flags().String("format", "ansible", "Inventory format (ansible)")
+	inventoryCmd.Flags().Bool("list", true, "Print the whole inventory (dynamic inventory protocol)")
+	inventoryCmd.Flags().String("host", "", "Print the hostvars of one pool (dynamic inventory protocol)")
+	inventoryCmd.Flags().String("ansible-host", "", "Set ansible_host on every pool so playbooks connect to this server")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"lightweight-php/manager"
+)
+
+func TestInventoryKeepsEveryPoolOfAUser(t *testing.T) {
+	// Newest first per user, as ListPools returns them
+	pools := []manager.Pool{
+		{User: "bob", PHPVersion: "8.3", Provider: "remi"},
+		{User: "bob", PHPVersion: "8.2", Provider: "remi"},
+		{User: "bob", PHPVersion: "8.2", Provider: "litespeed"},
+		{User: "carol", PHPVersion: "8.2", Provider: "remi"},
+	}
+	var asked []string
+	settings := func(pool manager.Pool) map[string]interface{} {
+		asked = append(asked, inventoryHostName(pool))
+		return map[string]interface{}{"memory_limit": "256M"}
+	}
+
+	inv := buildAnsibleInventory(pools, []string{"8.2", "8.3"}, settings, "")
+
+	if len(inv.Meta.HostVars) != len(pools) {
+		t.Fatalf("hostvars of %d pools, want %d: %v", len(inv.Meta.HostVars), len(pools), inv.Meta.HostVars)
+	}
+	for _, pool := range pools {
+		vars := inv.Meta.HostVars[inventoryHostName(pool)]
+		if vars["pool_user"] != pool.User || vars["php_version"] != pool.PHPVersion || vars["provider"] != pool.Provider {
+			t.Errorf("%s: hostvars %v", inventoryHostName(pool), vars)
+		}
+	}
+	groups := map[string][]string{
+		"php_8_2":            {"bob-8.2-remi", "bob-8.2-litespeed", "carol-8.2-remi"},
+		"php_8_3":            {"bob-8.3-remi"},
+		"provider_litespeed": {"bob-8.2-litespeed"},
+		"user_bob":           {"bob-8.3-remi", "bob-8.2-remi", "bob-8.2-litespeed"},
+	}
+	for name, want := range groups {
+		if got := inv.Groups[name].Hosts; !reflect.DeepEqual(got, want) {
+			t.Errorf("group %s: hosts %v, want %v", name, got, want)
+		}
+	}
+
+	// Only the newest pool's settings are stored per user
+	if want := []string{"bob-8.3-remi", "carol-8.2-remi"}; !reflect.DeepEqual(asked, want) {
+		t.Errorf("settings asked for %v, want %v", asked, want)
+	}
+	if _, ok := inv.Meta.HostVars["bob-8.2-remi"]["settings"]; ok {
+		t.Errorf("bob's PHP 8.2 pool shows the settings of his PHP 8.3 pool")
+	}
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Manage recurring tasks run by the API server",
+	Long: `Manage recurring tasks that the API server runs on a cron schedule:
+verify-config checks the configuration like 'doctor', php-upgrade upgrades
+installed PHP versions within their branch, prune drops history past its
+retention, and backup backs up every account to backup.url. Jobs come from
+schedules.jobs in the config file or are added here or through the API.`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recurring tasks with their next and last runs",
+	Run: func(cmd *cobra.Command, args []string) {
+		scheduler, err := newScheduler()
+		if err != nil {
+			fatalf("Error initializing scheduler: %v", err)
+		}
+		schedules, err := scheduler.ListSchedules()
+		if err != nil {
+			fatalf("Error listing jobs: %v", err)
+		}
+		printJobs(cmd, schedules)
+	},
+}
+
+func printJobs(cmd *cobra.Command, schedules []manager.Schedule) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(schedules, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(schedules) == 0 {
+		fmt.Println("No jobs")
+		return
+	}
+	for _, s := range schedules {
+		next, last := "-", "never run"
+		if s.NextRun != nil {
+			next = s.NextRun.Local().Format("2006-01-02 15:04")
+		}
+		if s.LastRun != nil {
+			last = fmt.Sprintf("%s %s", s.LastRun.Status, s.LastRun.StartedAt.Local().Format("2006-01-02 15:04"))
+		}
+		fmt.Printf("%-20s %-14s %-16s %-7s next %s, last %s\n", s.Name, s.Task, s.Cron, s.Source, next, last)
+	}
+}
+
+var jobsAddCmd = &cobra.Command{
+	Use:   "add [name] [task] [cron]",
+	Short: "Add a recurring task",
+	Example: `  lightweight-php jobs add nightly-check verify-config "30 2 * * *"
+  lightweight-php jobs add weekly-upgrade php-upgrade "0 4 * * sun"`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		scheduler, err := newScheduler()
+		if err != nil {
+			fatalf("Error initializing scheduler: %v", err)
+		}
+		schedule, err := scheduler.CreateSchedule(args[0], args[1], args[2])
+		if err != nil {
+			fatalf("Error adding job: %v", err)
+		}
+		printJobAdded(schedule)
+	},
+}
+
+func printJobAdded(schedule *manager.Schedule) {
+	fmt.Printf("Added job %s (%s), next run %s\n", schedule.Name, schedule.Task, schedule.NextRun.Local().Format("2006-01-02 15:04"))
+}
+
+var jobsDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a recurring task added with 'jobs add' or the API",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scheduler, err := newScheduler()
+		if err != nil {
+			fatalf("Error initializing scheduler: %v", err)
+		}
+		if err := scheduler.DeleteSchedule(args[0]); err != nil {
+			fatalf("Error deleting job: %v", err)
+		}
+		fmt.Printf("Deleted job %s\n", args[0])
+	},
+}
+
+var jobsRunsCmd = &cobra.Command{
+	Use:   "runs [name]",
+	Short: "Show the run history of a recurring task",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		limit, _ := cmd.Flags().GetInt("limit")
+		scheduler, err := newScheduler()
+		if err != nil {
+			fatalf("Error initializing scheduler: %v", err)
+		}
+		runs, err := scheduler.ListRuns(args[0], limit)
+		if err != nil {
+			fatalf("Error listing runs: %v", err)
+		}
+		printJobRuns(cmd, runs)
+	},
+}
+
+func printJobRuns(cmd *cobra.Command, runs []manager.ScheduleRun) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(runs, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs")
+		return
+	}
+	for _, r := range runs {
+		fmt.Printf("%-5d %-8s %s\n", r.ID, r.Status, r.StartedAt.Local().Format("2006-01-02 15:04:05"))
+		if r.Error != "" {
+			fmt.Printf("      error: %s\n", r.Error)
+		}
+	}
+}
+
+var jobsRunCmd = &cobra.Command{
+	Use:   "run [name]",
+	Short: "Run a recurring task now and wait for it",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		scheduler, err := newScheduler()
+		if err != nil {
+			fatalf("Error initializing scheduler: %v", err)
+		}
+		run, err := scheduler.RunNow(args[0])
+		if err != nil {
+			fatalf("Error running job: %v", err)
+		}
+		printJobRun(run)
+	},
+}
+
+// printJobRun prints a finished run of jobs run, failing when it failed
+func printJobRun(run *manager.ScheduleRun) {
+	if run.Output != "" {
+		fmt.Println(run.Output)
+	}
+	if run.Error != "" {
+		exitf(exitFailure, "Error: job %s failed: %s", run.Schedule, run.Error)
+	}
+	fmt.Printf("Job %s finished\n", run.Schedule)
+}
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsAddCmd)
+	jobsCmd.AddCommand(jobsDeleteCmd)
+	jobsCmd.AddCommand(jobsRunsCmd)
+	jobsCmd.AddCommand(jobsRunCmd)
+	jobsListCmd.Flags().Bool("json", false, "Print the jobs as JSON")
+	jobsRunsCmd.Flags().Bool("json", false, "Print the runs as JSON")
+	jobsRunsCmd.Flags().Int("limit", 20, "Number of runs to show, newest first")
+}
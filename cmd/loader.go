@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/spf13/cobra"
+)
+
+var phpLoaderCmd = &cobra.Command{
+	Use:   "loader",
+	Short: "Manage ionCube and SourceGuardian loaders per PHP version",
+}
+
+var phpLoaderInstallCmd = &cobra.Command{
+	Use:   "install [loader]",
+	Short: "Download and enable a loader for a PHP version",
+	Long:  "Download and enable a loader for a PHP version. Loaders: " + strings.Join(manager.Loaders, ", "),
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		version, _ := cmd.Flags().GetString("php")
+		providerName, _ := cmd.Flags().GetString("provider")
+
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+		status, err := pm.InstallLoader(version, provider.ProviderType(providerName), args[0])
+		if err != nil {
+			fatalf("Error installing loader: %v", err)
+		}
+		printLoaderInstalled(version, status)
+	},
+}
+
+func printLoaderInstalled(version string, status *manager.LoaderStatus) {
+	fmt.Printf("%s loader installed for PHP %s: %s (enabled in %s)\n", status.Loader, version, status.Path, status.INIPath)
+}
+
+var phpLoaderListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Show which loaders are installed for a PHP version",
+	Run: func(cmd *cobra.Command, args []string) {
+		version, _ := cmd.Flags().GetString("php")
+		providerName, _ := cmd.Flags().GetString("provider")
+
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+		loaders, err := pm.ListLoaders(version, provider.ProviderType(providerName))
+		if err != nil {
+			fatalf("Error listing loaders: %v", err)
+		}
+		printLoaders(loaders)
+	},
+}
+
+func printLoaders(loaders []manager.LoaderStatus) {
+	for _, l := range loaders {
+		state := "not installed"
+		if l.Active {
+			state = "active"
+		} else if l.Installed {
+			state = "installed but not loading"
+		}
+		fmt.Printf("%-16s %s\n", l.Loader, state)
+	}
+}
+
+func init() {
+	phpCmd.AddCommand(phpLoaderCmd)
+	phpLoaderCmd.AddCommand(phpLoaderInstallCmd)
+	phpLoaderCmd.AddCommand(phpLoaderListCmd)
+	for _, c := range []*cobra.Command{phpLoaderInstallCmd, phpLoaderListCmd} {
+		c.Flags().String("php", "8.2", "PHP version")
+		c.Flags().String("provider", "remi", "PHP provider (remi, lsphp, alt-php, docker, system)")
+	}
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"lightweight-php/maintenance"
+
+	"github.com/spf13/cobra"
+)
+
+// addMaintenanceFlags registers the flags of commands that run as heavy
+// maintenance operations
+func addMaintenanceFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("now", false, "Start outside the configured maintenance windows")
+	cmd.Flags().Bool("no-background", false, "Run at normal CPU and I/O priority")
+}
+
+// beginMaintenance waits for a maintenance window unless --now is given and
+// lowers the priority of the process unless --no-background is given
+func beginMaintenance(cmd *cobra.Command) {
+	now, _ := cmd.Flags().GetBool("now")
+	if !now {
+		maintenance.Wait(true, func(next time.Time) {
+			fmt.Printf("Waiting for the maintenance window at %s (use --now to start immediately)\n", next.Format("15:04"))
+		})
+	}
+
+	if foreground, _ := cmd.Flags().GetBool("no-background"); !foreground {
+		if err := maintenance.LowerPriority(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+// targetAPIKeyEnv holds the target's API key when --target-api-key is not
+// given, keeping it out of the process list
+const targetAPIKeyEnv = "LIGHTWEIGHT_PHP_TARGET_API_KEY"
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate accounts to another server",
+}
+
+var migrateAccountCmd = &cobra.Command{
+	Use:   "account [username]",
+	Short: "Migrate an account's data and pool to another server",
+	Long: "Copy the user's home directory to the target with rsync over SSH, recreate the pool " +
+		"on the target through its API, then switch the sites over: the local snippets proxy " +
+		"every request to the target and the DNS records are pointed at it through the " +
+		"target's API. " +
+		"Re-running the command resumes from the first unfinished step.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		opts := manager.MigrationOptions{}
+		opts.TargetHost, _ = cmd.Flags().GetString("to")
+		opts.SSHUser, _ = cmd.Flags().GetString("ssh-user")
+		opts.APIURL, _ = cmd.Flags().GetString("api-url")
+		opts.APIKey, _ = cmd.Flags().GetString("target-api-key")
+		if opts.APIKey == "" {
+			opts.APIKey = os.Getenv(targetAPIKeyEnv)
+		}
+		opts.PHPVersion, _ = cmd.Flags().GetString("php-version")
+		opts.Restart, _ = cmd.Flags().GetBool("restart")
+		opts.BandwidthKBs, _ = cmd.Flags().GetInt("bwlimit")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		beginMaintenance(cmd)
+		err = pm.MigrateAccount(username, opts, func(step string) {
+			fmt.Printf("==> %s\n", step)
+		})
+		if err != nil {
+			fatalf("Error migrating account: %v", err)
+		}
+		fmt.Printf("Account %s migrated to %s\n", username, opts.TargetHost)
+	},
+}
+
+func init() {
+	migrateCmd.AddCommand(migrateAccountCmd)
+	migrateAccountCmd.Flags().String("to", "", "Target host (SSH)")
+	migrateAccountCmd.Flags().String("ssh-user", "root", "SSH user on the target host")
+	migrateAccountCmd.Flags().String("api-url", "", "Target API URL (default: http://<target>:8080)")
+	migrateAccountCmd.Flags().String("target-api-key", "", "API key for the target API (default $"+targetAPIKeyEnv+")")
+	migrateAccountCmd.Flags().String("php-version", "", "PHP version to use on the target (default: same as source)")
+	migrateAccountCmd.Flags().Bool("restart", false, "Ignore saved progress and run all steps again")
+	migrateAccountCmd.Flags().Int("bwlimit", 0, "rsync bandwidth limit in KB/s (0 = unlimited)")
+	addMaintenanceFlags(migrateAccountCmd)
+	migrateAccountCmd.MarkFlagRequired("to")
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+
+	"lightweight-php/provider"
+
+	"github.com/spf13/cobra"
+)
+
+var phpOpcacheCmd = &cobra.Command{
+	Use:   "opcache",
+	Short: "Manage OPcache settings per PHP version",
+}
+
+var phpOpcacheSetCmd = &cobra.Command{
+	Use:   "set [version] [key=value...]",
+	Short: "Write the OPcache ini for a PHP version",
+	Long:  "Write the OPcache ini for a PHP version. Keys: memory_consumption, max_accelerated_files, validate_timestamps, jit, jit_buffer_size",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		version := args[0]
+		providerName, _ := cmd.Flags().GetString("provider")
+
+		settings, err := parseSettingArgs(args[1:])
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+		iniPath, err := pm.ConfigureOpcache(version, provider.ProviderType(providerName), settings)
+		if err != nil {
+			fatalf("Error configuring OPcache: %v", err)
+		}
+		fmt.Printf("OPcache settings for PHP %s written to %s\n", version, iniPath)
+	},
+}
+
+var poolOpcacheCmd = &cobra.Command{
+	Use:   "opcache",
+	Short: "Manage OPcache for a pool",
+}
+
+var poolOpcacheSetCmd = &cobra.Command{
+	Use:   "set [username] [key=value...]",
+	Short: "Set per-pool OPcache overrides",
+	Long:  "Set per-pool OPcache overrides via php_admin_value. Keys: memory_consumption, max_accelerated_files, validate_timestamps, jit",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+
+		parsed, err := parseSettingArgs(args[1:])
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		settings := make(map[string]interface{}, len(parsed))
+		for key, value := range parsed {
+			settings["opcache_"+key] = value
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		if err := pm.PatchPoolConfig(username, settings); err != nil {
+			fatalf("Error updating pool: %v", err)
+		}
+		fmt.Printf("OPcache settings updated for user: %s\n", username)
+	},
+}
+
+var poolOpcacheResetCmd = &cobra.Command{
+	Use:   "reset [username]",
+	Short: "Reset the OPcache of a pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if err := pm.ResetOpcache(username); err != nil {
+			fatalf("Error resetting OPcache: %v", err)
+		}
+		fmt.Printf("OPcache reset for user: %s\n", username)
+	},
+}
+
+func init() {
+	phpCmd.AddCommand(phpOpcacheCmd)
+	phpOpcacheCmd.AddCommand(phpOpcacheSetCmd)
+	phpOpcacheSetCmd.Flags().String("provider", "remi", "PHP provider (remi, lsphp, alt-php, docker, system)")
+
+	poolCmd.AddCommand(poolOpcacheCmd)
+	poolOpcacheCmd.AddCommand(poolOpcacheSetCmd)
+	poolOpcacheCmd.AddCommand(poolOpcacheResetCmd)
+}
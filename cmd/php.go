@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	"lightweight-php/manager"
+	"lightweight-php/provider"
+	"lightweight-php/target"
 
 	"github.com/spf13/cobra"
 )
@@ -17,35 +21,184 @@ var phpCmd = &cobra.Command{
 var phpInstallCmd = &cobra.Command{
 	Use:   "install [version]",
 	Short: "Install a PHP version from Remi",
-	Args:  cobra.ExactArgs(1),
+	Long: `Install a PHP version. Instead of a major.minor version, a constraint
+installs the newest matching version the provider offers.`,
+	Example: `  lightweight-php php install 8.3
+  lightweight-php php install 8
+  lightweight-php php install ">=7.4 <8.4"`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		version, err := pm.ResolveVersion(args[0], "")
+		if err != nil {
+			fatalf("Error installing PHP: %v", err)
+		}
+		if rollback, _ := cmd.Flags().GetBool("rollback"); rollback {
+			pm = pm.WithRollback()
+		}
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		progress, stop := installProgress(verbose)
+		err = pm.WithProgress(progress).InstallPHP(version)
+		stop()
+		var installErr *manager.InstallError
+		if errors.As(err, &installErr) {
+			for _, r := range installErr.RolledBack {
+				if r.Error != "" {
+					fmt.Fprintf(os.Stderr, "Could not undo: %s: %s\n", r.Change, r.Error)
+				} else {
+					fmt.Fprintf(os.Stderr, "Rolled back: %s\n", r.Change)
+				}
+			}
+			fatalf("Error installing PHP: %v", installErr.Err)
+		}
+		if err != nil {
+			fatalf("Error installing PHP: %v", err)
+		}
+		fmt.Printf("PHP %s installed successfully\n", version)
+	},
+}
+
+var phpUninstallCmd = &cobra.Command{
+	Use:   "uninstall [version]",
+	Short: "Remove a PHP version that no pool uses",
+	Long: `Stop a PHP version's FPM service and remove its packages. Pools running on
+the version block the removal; --migrate-to switches them to another
+installed version first.`,
+	Example: `  lightweight-php php uninstall 8.1
+  lightweight-php php uninstall 8.1 --migrate-to 8.3`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		version := args[0]
-		pm, err := manager.NewPackageManager()
+		migrateTo, _ := cmd.Flags().GetString("migrate-to")
+		if migrateTo == version {
+			usagef("Error: --migrate-to must differ from the version being uninstalled")
+		}
+		pm, err := newPackageManagerFor(cmd)
 		if err != nil {
-			fmt.Printf("Error initializing package manager: %v\n", err)
-			return
+			fatalf("Error initializing package manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		providerType := provider.ProviderType(pm.GetProvider().GetProviderType())
+		if name, _ := cmd.Flags().GetString("provider"); name != "" {
+			providerType = provider.ProviderType(name)
+		}
+
+		if migrateTo != "" {
+			spec, _ := cmd.Flags().GetString("target")
+			t, err := target.Parse(spec)
+			if err != nil {
+				usagef("Error: %v", err)
+			}
+			poolManager, err := newPoolManager()
+			if err != nil {
+				fatalf("Error initializing pool manager: %v", err)
+			}
+			if noWait {
+				poolManager = poolManager.WithNoWait()
+			}
+			migrated, err := poolManager.WithTarget(t).MigrateVersionPools(version, providerType, migrateTo)
+			for _, username := range migrated {
+				fmt.Printf("Moved pool %s to PHP %s\n", username, migrateTo)
+			}
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+		}
+
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		progress, stop := installProgress(verbose)
+		err = pm.WithProgress(progress).UninstallPHP(version, providerType)
+		stop()
+		if err != nil {
+			fatalf("Error uninstalling PHP: %v", err)
 		}
-		if err := pm.InstallPHP(version); err != nil {
-			fmt.Printf("Error installing PHP: %v\n", err)
+		fmt.Printf("PHP %s uninstalled\n", version)
+	},
+}
+
+var phpUpgradeCmd = &cobra.Command{
+	Use:   "upgrade [version]",
+	Short: "Upgrade installed PHP versions to the newest release of their branch",
+	Long: `Upgrade a version's packages, e.g. 8.3.10 to 8.3.12, and restart its FPM
+service. Without a version every installed version whose provider can
+upgrade in place is upgraded.`,
+	Example: `  lightweight-php php upgrade 8.3
+  lightweight-php php upgrade`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		progress, stop := installProgress(verbose)
+		pm = pm.WithProgress(progress)
+
+		if len(args) == 0 {
+			results, err := pm.UpgradeAllPHP()
+			stop()
+			printUpgradeResults(results)
+			if err != nil {
+				fatalf("Error upgrading PHP: %v", err)
+			}
+			if len(results) == 0 {
+				fmt.Println("No installed version can be upgraded in place")
+			}
 			return
 		}
-		fmt.Printf("PHP %s installed successfully\n", version)
+
+		providerType := provider.ProviderType(pm.GetProvider().GetProviderType())
+		if name, _ := cmd.Flags().GetString("provider"); name != "" {
+			providerType = provider.ProviderType(name)
+		}
+		err = pm.UpgradePHP(args[0], providerType)
+		stop()
+		if err != nil {
+			fatalf("Error upgrading PHP: %v", err)
+		}
+		fmt.Printf("PHP %s upgraded\n", args[0])
 	},
 }
 
+func printUpgradeResults(results []manager.UpgradeResult) {
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Printf("PHP %s (%s): %s\n", r.Version, r.Provider, r.Error)
+		} else {
+			fmt.Printf("PHP %s (%s) upgraded\n", r.Version, r.Provider)
+		}
+	}
+}
+
 var phpListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List installed PHP versions",
+	Long: `List installed PHP versions. Long-running processes such as the API
+server cache the detected versions for a minute, and installs and uninstalls
+drop the cache. --refresh detects them again, e.g. after installing packages
+by hand.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		pm, err := manager.NewPackageManager()
+		pm, err := newPackageManagerFor(cmd)
 		if err != nil {
-			fmt.Printf("Error initializing package manager: %v\n", err)
-			return
+			fatalf("Error initializing package manager: %v", err)
+		}
+		if refresh, _ := cmd.Flags().GetBool("refresh"); refresh {
+			pm.RefreshInstalledPHP()
 		}
 		versions, err := pm.ListInstalledPHP()
 		if err != nil {
-			fmt.Printf("Error listing PHP versions: %v\n", err)
-			return
+			fatalf("Error listing PHP versions: %v", err)
 		}
 		for _, v := range versions {
 			fmt.Printf("PHP %s\n", v)
@@ -55,5 +208,16 @@ var phpListCmd = &cobra.Command{
 
 func init() {
 	phpCmd.AddCommand(phpInstallCmd)
+	phpCmd.AddCommand(phpUninstallCmd)
+	phpCmd.AddCommand(phpUpgradeCmd)
 	phpCmd.AddCommand(phpListCmd)
+	phpInstallCmd.Flags().Bool("rollback", false, "If the install fails or PHP-FPM does not come up, remove the packages it installed and undo its repository and service changes")
+	phpInstallCmd.Flags().BoolP("verbose", "v", false, "Print the package manager's output line by line instead of a progress line")
+	phpUninstallCmd.Flags().BoolP("verbose", "v", false, "Print the package manager's output line by line instead of a progress line")
+	phpUninstallCmd.Flags().String("provider", "", "Provider the version was installed with (default: the default provider)")
+	phpUninstallCmd.Flags().String("migrate-to", "", "Switch pools on the version to this installed PHP version first")
+	phpUpgradeCmd.Flags().BoolP("verbose", "v", false, "Print the package manager's output line by line instead of a progress line")
+	phpListCmd.Flags().Bool("refresh", false, "Detect the installed versions again instead of using cached ones")
+	phpUpgradeCmd.Flags().String("provider", "", "Provider the version was installed with (default: the default provider)")
+	phpCmd.PersistentFlags().String("target", "", "Operate inside a chroot or container: chroot:DIR, nspawn:NAME or lxc:NAME")
 }
@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 
 	"lightweight-php/manager"
+	"lightweight-php/target"
 
 	"github.com/spf13/cobra"
 )
@@ -17,26 +19,76 @@ var poolCmd = &cobra.Command{
 var poolCreateCmd = &cobra.Command{
 	Use:   "create [username]",
 	Short: "Create a PHP-FPM pool for a user",
-	Args:  cobra.ExactArgs(1),
+	Long:  "Create a PHP-FPM pool for a user. Without a username, a wizard asks for the options not given as flags when run in a terminal.",
+	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		username := args[0]
 		phpVersion, _ := cmd.Flags().GetString("php-version")
 		provider, _ := cmd.Flags().GetString("provider")
+		profile, _ := cmd.Flags().GetString("profile")
+
+		var username string
+		if len(args) == 1 {
+			username = args[0]
+		} else {
+			if !isInteractive() {
+				usagef("Error: username is required")
+			}
+			if !poolCreateWizard(cmd, &username, &phpVersion, &provider, &profile) {
+				fmt.Println("Cancelled")
+				return
+			}
+		}
 		
 		if provider == "" {
 			provider = "remi"
 		}
 		
-		pm, err := manager.NewPoolManager()
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		targetSpec, _ := cmd.Flags().GetString("target")
+		t, err := target.Parse(targetSpec)
 		if err != nil {
-			fmt.Printf("Error initializing pool manager: %v\n", err)
-			return
+			fatalf("Error: %v", err)
 		}
-		if err := pm.CreatePool(username, phpVersion, provider); err != nil {
-			fmt.Printf("Error creating pool: %v\n", err)
-			return
+		if !t.IsHost() {
+			pm = pm.WithTarget(t)
+		}
+		tenant, _ := cmd.Flags().GetString("tenant")
+		if tenant != "" {
+			pm = pm.WithTenant(tenant)
+		}
+		if createUser, _ := cmd.Flags().GetBool("create-user"); createUser {
+			opts := manager.UserOptions{}
+			opts.Shell, _ = cmd.Flags().GetString("shell")
+			if keyFile, _ := cmd.Flags().GetString("ssh-key-file"); keyFile != "" {
+				key, err := os.ReadFile(keyFile)
+				if err != nil {
+					fatalf("Error reading %s: %v", keyFile, err)
+				}
+				opts.SSHKey = string(key)
+			}
+			if err := opts.Validate(); err != nil {
+				usagef("Error: %v", err)
+			}
+			err = pm.CreatePoolWithUser(username, phpVersion, provider, profile, opts)
+		} else {
+			err = pm.CreatePoolWithProfile(username, phpVersion, provider, profile)
+		}
+		if err != nil {
+			fatalf("Error creating pool: %v", err)
 		}
 		fmt.Printf("Pool created for user: %s with PHP %s (provider: %s)\n", username, phpVersion, provider)
+		if tenant != "" {
+			fmt.Printf("Tenant: %s\n", tenant)
+		}
+		if profile != "" {
+			fmt.Printf("Applied profile: %s\n", profile)
+		}
 	},
 }
 
@@ -46,43 +98,101 @@ var poolDeleteCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		username := args[0]
-		pm, err := manager.NewPoolManager()
+		purgeData, _ := cmd.Flags().GetBool("purge-data")
+		pm, err := newPoolManager()
 		if err != nil {
-			fmt.Printf("Error initializing pool manager: %v\n", err)
-			return
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
 		}
-		if err := pm.DeletePool(username); err != nil {
-			fmt.Printf("Error deleting pool: %v\n", err)
-			return
+		if err := pm.DeletePool(username, purgeData); err != nil {
+			fatalf("Error deleting pool: %v", err)
 		}
 		fmt.Printf("Pool deleted for user: %s\n", username)
+		if removeUser, _ := cmd.Flags().GetBool("remove-user"); removeUser {
+			action, err := pm.RemovePoolUser(username)
+			if err != nil {
+				fatalf("Error removing user: %v", err)
+			}
+			fmt.Printf("User %s %s\n", username, action)
+		}
+	},
+}
+
+var poolProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "List pool profiles usable with 'pool create --profile'",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		profiles, err := pm.ListProfiles()
+		if err != nil {
+			fatalf("Error listing profiles: %v", err)
+		}
+		printProfiles(profiles)
 	},
 }
 
+func printProfiles(profiles []manager.Profile) {
+	for _, p := range profiles {
+		kind := "custom"
+		if p.Builtin {
+			kind = "built-in"
+		}
+		fmt.Printf("%-16s %-9s %s\n", p.Name, kind, p.Description)
+	}
+}
+
 var poolListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all PHP-FPM pools",
 	Run: func(cmd *cobra.Command, args []string) {
-		pm, err := manager.NewPoolManager()
+		pm, err := newPoolManager()
 		if err != nil {
-			fmt.Printf("Error initializing pool manager: %v\n", err)
-			return
+			fatalf("Error initializing pool manager: %v", err)
 		}
 		pools, err := pm.ListPools()
 		if err != nil {
-			fmt.Printf("Error listing pools: %v\n", err)
-			return
+			fatalf("Error listing pools: %v", err)
 		}
+		tenant, _ := cmd.Flags().GetString("tenant")
 		for _, pool := range pools {
-			fmt.Printf("User: %s, PHP Version: %s, Provider: %s, Status: %s\n", pool.User, pool.PHPVersion, pool.Provider, pool.Status)
+			if tenant != "" && pool.Tenant != tenant {
+				continue
+			}
+			printPool(pool)
 		}
 	},
 }
 
+func printPool(pool manager.Pool) {
+	fmt.Printf("User: %s, PHP Version: %s, Provider: %s, Status: %s", pool.User, pool.PHPVersion, pool.Provider, pool.Status)
+	if pool.Target != "" {
+		fmt.Printf(", Target: %s", pool.Target)
+	}
+	if pool.Tenant != "" {
+		fmt.Printf(", Tenant: %s", pool.Tenant)
+	}
+	fmt.Println()
+}
+
 func init() {
 	poolCmd.AddCommand(poolCreateCmd)
 	poolCmd.AddCommand(poolDeleteCmd)
 	poolCmd.AddCommand(poolListCmd)
+	poolCmd.AddCommand(poolProfilesCmd)
 	poolCreateCmd.Flags().String("php-version", "8.2", "PHP version to use")
-	poolCreateCmd.Flags().String("provider", "remi", "PHP provider (remi, lsphp, alt-php, docker)")
+	poolCreateCmd.Flags().String("provider", "remi", "PHP provider (remi, lsphp, alt-php, docker, system)")
+	poolCreateCmd.Flags().String("profile", "", "Apply a pool profile such as wordpress, laravel or highmem")
+	poolCreateCmd.Flags().String("target", "", "Create the pool inside a chroot or container: chroot:DIR, nspawn:NAME or lxc:NAME")
+	poolCreateCmd.Flags().String("tenant", "", "Create the pool in a tenant, starting from its default settings")
+	poolCreateCmd.Flags().Bool("create-user", false, "Create the system user if it does not exist (see users in config.json)")
+	poolCreateCmd.Flags().String("shell", "", "Login shell of a created user (default users.shell)")
+	poolCreateCmd.Flags().String("ssh-key-file", "", "Public key file to install in a created user's authorized_keys")
+	poolListCmd.Flags().String("tenant", "", "Only list pools of this tenant")
+	poolDeleteCmd.Flags().Bool("purge-data", false, "Also remove the user's session and tmp directories and the pool's database")
+	poolDeleteCmd.Flags().Bool("remove-user", false, "Also lock or delete the system user if it was created with --create-user (users.remove_mode)")
 }
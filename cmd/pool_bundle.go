@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolExportBundleCmd = &cobra.Command{
+	Use:   "export-bundle [username]",
+	Short: "Export a pool as a migration bundle",
+	Long:  "Export a pool's settings, sites with their php.ini overrides, cron jobs and metadata (not site files) as a .tar.gz bundle for migration to another server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		output := bundleOutput(cmd, args)
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		if output == "-" {
+			if err := pm.ExportBundle(username, os.Stdout); err != nil {
+				fatalf("Error exporting bundle: %v", err)
+			}
+			return
+		}
+		f, err := os.Create(output)
+		if err != nil {
+			fatalf("Error creating bundle file: %v", err)
+		}
+		defer f.Close()
+
+		if err := pm.ExportBundle(username, f); err != nil {
+			os.Remove(output)
+			fatalf("Error exporting bundle: %v", err)
+		}
+		fmt.Printf("Pool for user %s exported to %s\n", username, output)
+	},
+}
+
+var poolImportBundleCmd = &cobra.Command{
+	Use:   "import-bundle [file]",
+	Short: "Recreate a pool from a migration bundle",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		phpVersion, _ := cmd.Flags().GetString("php-version")
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			fatalf("Error opening bundle: %v", err)
+		}
+		defer f.Close()
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+
+		bundle, err := manager.ReadBundle(f)
+		if err != nil {
+			fatalf("Error reading bundle: %v", err)
+		}
+		report, err := pm.ImportBundle(bundle, phpVersion)
+		if err != nil {
+			fatalf("Error importing bundle: %v", err)
+		}
+		if phpVersion == "" {
+			phpVersion = bundle.Metadata.PHPVersion
+		}
+		printBundleImport(bundle.Metadata.Username, phpVersion, bundle.Metadata.Provider, report)
+	},
+}
+
+// bundleOutput returns the file export-bundle writes, or - for standard
+// output
+func bundleOutput(cmd *cobra.Command, args []string) string {
+	output, _ := cmd.Flags().GetString("output")
+	if output == "" {
+		output = fmt.Sprintf("%s.tar.gz", args[0])
+	}
+	return output
+}
+
+func printBundleImport(username, phpVersion, providerName string, report *manager.RestoreReport) {
+	fmt.Printf("Pool imported for user: %s with PHP %s (provider: %s)\n", username, phpVersion, providerName)
+	for _, domain := range report.SitesCreated {
+		fmt.Printf("  site created: %s\n", domain)
+	}
+	for _, domain := range report.SitesSkipped {
+		fmt.Printf("  site skipped (exists): %s\n", domain)
+	}
+}
+
+func init() {
+	poolCmd.AddCommand(poolExportBundleCmd)
+	poolCmd.AddCommand(poolImportBundleCmd)
+	poolExportBundleCmd.Flags().StringP("output", "o", "", "Output file, or - for standard output (default: <username>.tar.gz)")
+	poolImportBundleCmd.Flags().String("php-version", "", "Local PHP version to use (default: version from the bundle)")
+}
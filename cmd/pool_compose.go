@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolExportComposeCmd = &cobra.Command{
+	Use:   "export-compose [username]",
+	Short: "Export a pool as a docker-compose.yml",
+	Long: `Write a docker-compose.yml that runs the pool in a PHP-FPM container:
+the pool's configuration with all its settings, the user's home directory
+(APP_ROOT), the pool user's uid and gid, and memory and process limits
+derived from memory_limit and max_children. PHP-FPM listens on port 9000,
+published on 127.0.0.1 (PHP_FPM_PORT), instead of the pool's socket.
+Sessions and temporary files are kept on tmpfs. Extensions beyond those of
+the image have to be added to it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		output, _ := cmd.Flags().GetString("output")
+		image, _ := cmd.Flags().GetString("image")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		compose, err := pm.ExportCompose(username, manager.ComposeOptions{Image: image})
+		if err != nil {
+			fatalf("Error exporting pool: %v", err)
+		}
+		if output == "-" {
+			fmt.Print(compose)
+			return
+		}
+		if err := os.WriteFile(output, []byte(compose), 0644); err != nil {
+			fatalf("Error writing %s: %v", output, err)
+		}
+		fmt.Printf("Pool for user %s exported to %s\n", username, output)
+	},
+}
+
+func init() {
+	poolCmd.AddCommand(poolExportComposeCmd)
+	poolExportComposeCmd.Flags().StringP("output", "o", "docker-compose.yml", "File to write, or - for standard output")
+	poolExportComposeCmd.Flags().String("image", "", "PHP-FPM image (default: php:VERSION-fpm)")
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolCronCmd = &cobra.Command{
+	Use:   "cron",
+	Short: "Manage the cron jobs of a pool",
+	Long: `Manage cron jobs run as a pool's user. They are kept in a block of the
+user's crontab that lightweight-php rewrites; the user's own lines are left
+alone. A command starting with php runs the pool's PHP (the user's CLI
+wrapper). Jobs are exported with pool manifests and removed with the pool.`,
+}
+
+var poolCronListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Short: "List the cron jobs of a pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		crons, err := pm.ListCrons(args[0])
+		if err != nil {
+			fatalf("Error listing cron jobs: %v", err)
+		}
+		printCrons(cmd, args[0], crons)
+	},
+}
+
+func printCrons(cmd *cobra.Command, username string, crons []manager.Cron) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(crons, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(crons) == 0 {
+		fmt.Printf("No cron jobs for user: %s\n", username)
+		return
+	}
+	for _, c := range crons {
+		fmt.Printf("%-5d %s\n", c.ID, c.Line)
+	}
+}
+
+var poolCronAddCmd = &cobra.Command{
+	Use:   "add [username] SCHEDULE COMMAND",
+	Short: "Add a cron job to a pool",
+	Long: `Add a cron job to a pool. SCHEDULE is a crontab(5) expression or a macro
+such as @hourly; COMMAND runs through the user's shell.
+
+  lightweight-php pool cron add bob "*/5 * * * *" "php artisan schedule:run"
+  lightweight-php pool cron add bob @daily "php /home/bob/bin/cleanup.php"`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		job := manager.CronJob{Schedule: args[1], Command: args[2]}
+		if err := job.Validate(); err != nil {
+			usagef("Error: %v", err)
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		c, err := pm.AddCron(args[0], job)
+		if err != nil {
+			fatalf("Error adding cron job: %v", err)
+		}
+		fmt.Printf("Cron job %d added for user: %s\n  %s\n", c.ID, args[0], c.Line)
+	},
+}
+
+var poolCronRemoveCmd = &cobra.Command{
+	Use:   "remove [username] ID",
+	Short: "Remove a cron job of a pool",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := parseCronID(args[1])
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		if err := pm.RemoveCron(args[0], id); err != nil {
+			fatalf("Error removing cron job: %v", err)
+		}
+		fmt.Printf("Cron job %d removed for user: %s\n", id, args[0])
+	},
+}
+
+func parseCronID(arg string) int64 {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		usagef("Error: invalid cron job id %q", arg)
+	}
+	return id
+}
+
+func init() {
+	poolCmd.AddCommand(poolCronCmd)
+	poolCronCmd.AddCommand(poolCronListCmd)
+	poolCronCmd.AddCommand(poolCronAddCmd)
+	poolCronCmd.AddCommand(poolCronRemoveCmd)
+	poolCronListCmd.Flags().Bool("json", false, "Print the cron jobs as JSON")
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolDomainCmd = &cobra.Command{
+	Use:   "domain",
+	Short: "Manage the domains and document roots of a pool",
+	Long: `Manage the sites of a pool's user for customers with several domains. Each
+domain has its own document root inside the user's home and may override
+php.ini directives for it; the overrides are written to .user.ini in the
+document root, which PHP-FPM reads for scripts below it.`,
+}
+
+var poolDomainListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Short: "List the domains of a pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, sm := newDomainManagers()
+		domains, err := pm.ListDomains(sm, args[0])
+		if err != nil {
+			fatalf("Error listing domains: %v", err)
+		}
+		printDomains(cmd, args[0], domains)
+	},
+}
+
+func printDomains(cmd *cobra.Command, username string, domains []manager.Domain) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(domains, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(domains) == 0 {
+		fmt.Printf("No domains for user: %s\n", username)
+		return
+	}
+	for _, d := range domains {
+		fmt.Printf("%-30s PHP %-5s %s\n", d.Domain, d.PHPVersion, d.DocumentRoot)
+		printPHPValues(d.PHPValues)
+	}
+}
+
+var poolDomainAddCmd = &cobra.Command{
+	Use:   "add [username] DOMAIN [NAME=value...]",
+	Short: "Add a domain to a pool",
+	Long: `Add a site for a pool's user, creating its document root if needed.
+NAME=value arguments are php.ini overrides for the document root.
+
+  lightweight-php pool domain add bob shop.example.com --docroot /home/bob/shop memory_limit=256M
+  lightweight-php pool domain add bob blog.example.com --php-version 8.1`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec := domainAddSpec(cmd, args)
+		pm, sm := newDomainManagers()
+		domain, err := pm.AddDomain(sm, args[0], spec)
+		if err != nil {
+			fatalf("Error adding domain: %v", err)
+		}
+		printDomainAdded(args[0], domain)
+	},
+}
+
+// domainAddSpec returns the domain pool domain add creates
+func domainAddSpec(cmd *cobra.Command, args []string) manager.DomainSpec {
+	spec := manager.DomainSpec{Domain: args[1]}
+	spec.DocumentRoot, _ = cmd.Flags().GetString("docroot")
+	spec.PHPVersion, _ = cmd.Flags().GetString("php-version")
+	values, err := parsePHPValueArgs(args[2:])
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	for name, value := range values {
+		if value != nil {
+			if spec.PHPValues == nil {
+				spec.PHPValues = make(map[string]string)
+			}
+			spec.PHPValues[name] = *value
+		}
+	}
+	return spec
+}
+
+func printDomainAdded(username string, domain *manager.Domain) {
+	fmt.Printf("Domain %s added for user: %s (PHP %s, %s)\n", domain.Domain, username, domain.PHPVersion, domain.DocumentRoot)
+	fmt.Printf("Include %s in the nginx server block of %s\n", domain.SnippetPath, domain.Domain)
+}
+
+var poolDomainSetCmd = &cobra.Command{
+	Use:   "set [username] DOMAIN [NAME=value...]",
+	Short: "Change the document root or PHP values of a domain",
+	Long: `Change the document root or php.ini overrides of a domain. NAME=value
+sets an override, NAME= removes it and the others are kept.
+
+  lightweight-php pool domain set bob shop.example.com max_execution_time=120 display_errors=
+  lightweight-php pool domain set bob shop.example.com --docroot /home/bob/shop/public`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		update, changes := domainSetUpdate(cmd, args)
+		pm, sm := newDomainManagers()
+		if len(changes) > 0 {
+			current, err := pm.GetDomain(sm, args[0], args[1])
+			if err != nil {
+				fatalf("Error getting domain: %v", err)
+			}
+			update.PHPValues = mergePHPValues(current.PHPValues, changes)
+		}
+		domain, err := pm.UpdateDomain(sm, args[0], args[1], update)
+		if err != nil {
+			fatalf("Error updating domain: %v", err)
+		}
+		printDomainUpdated(args[0], domain)
+	},
+}
+
+// domainSetUpdate returns the document root change of pool domain set and
+// its PHP value changes, which are merged into the domain's current values
+func domainSetUpdate(cmd *cobra.Command, args []string) (manager.DomainUpdate, map[string]*string) {
+	var update manager.DomainUpdate
+	update.DocumentRoot, _ = cmd.Flags().GetString("docroot")
+	changes, err := parsePHPValueArgs(args[2:])
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	if update.DocumentRoot == "" && len(changes) == 0 {
+		usagef("Error: nothing to change; give --docroot or NAME=value")
+	}
+	return update, changes
+}
+
+// mergePHPValues applies changes to values; a nil change removes the value
+func mergePHPValues(values map[string]string, changes map[string]*string) map[string]string {
+	if values == nil {
+		values = make(map[string]string)
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(values, name)
+		} else {
+			values[name] = *value
+		}
+	}
+	return values
+}
+
+func printDomainUpdated(username string, domain *manager.Domain) {
+	fmt.Printf("Domain %s updated for user: %s (%s)\n", domain.Domain, username, domain.DocumentRoot)
+	printPHPValues(domain.PHPValues)
+}
+
+var poolDomainRemoveCmd = &cobra.Command{
+	Use:   "remove [username] DOMAIN",
+	Short: "Remove a domain of a pool, keeping its files",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, sm := newDomainManagers()
+		if err := pm.RemoveDomain(sm, args[0], args[1]); err != nil {
+			fatalf("Error removing domain: %v", err)
+		}
+		fmt.Printf("Domain %s removed for user: %s\n", args[1], args[0])
+	},
+}
+
+func newDomainManagers() (*manager.PoolManager, *manager.SiteManager) {
+	pm, err := newPoolManager()
+	if err != nil {
+		fatalf("Error initializing pool manager: %v", err)
+	}
+	if noWait {
+		pm = pm.WithNoWait()
+	}
+	sm, err := newSiteManager()
+	if err != nil {
+		fatalf("Error initializing site manager: %v", err)
+	}
+	return pm, sm
+}
+
+// parsePHPValueArgs parses NAME=value arguments; NAME= gives nil, which
+// removes the override
+func parsePHPValueArgs(args []string) (map[string]*string, error) {
+	values := make(map[string]*string, len(args))
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid PHP value %q; expected NAME=value", arg)
+		}
+		if value == "" {
+			values[name] = nil
+		} else {
+			values[name] = &value
+		}
+	}
+	return values, nil
+}
+
+func printPHPValues(values map[string]string) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("    %s = %s\n", name, values[name])
+	}
+}
+
+func init() {
+	poolCmd.AddCommand(poolDomainCmd)
+	poolDomainCmd.AddCommand(poolDomainListCmd)
+	poolDomainCmd.AddCommand(poolDomainAddCmd)
+	poolDomainCmd.AddCommand(poolDomainSetCmd)
+	poolDomainCmd.AddCommand(poolDomainRemoveCmd)
+	poolDomainListCmd.Flags().Bool("json", false, "Print the domains as JSON")
+	poolDomainAddCmd.Flags().String("docroot", "", "Document root inside the user's home (default public_html)")
+	poolDomainAddCmd.Flags().String("php-version", "", "PHP version serving the domain (default the user's pool)")
+	poolDomainSetCmd.Flags().String("docroot", "", "Move the domain to this document root")
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var poolEnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Manage the environment variables of a pool",
+	Long: `Manage the environment variables PHP-FPM passes to a pool's workers (env[...]
+in the pool file). They are the pool's "env" setting, so they take part in
+config history, manifests and the API like any other setting, and their values
+are stored encrypted.`,
+}
+
+var poolEnvSetCmd = &cobra.Command{
+	Use:   "set [username] NAME=value...",
+	Short: "Set environment variables of a pool",
+	Long: `Set environment variables of a pool, keeping the others.
+
+  lightweight-php pool env set bob APP_ENV=production DB_HOST=10.0.0.5
+  lightweight-php pool env set bob --clear-env=no`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		updatePoolEnv(args[0], envSetPatch(cmd, args[1:]))
+	},
+}
+
+// envSetPatch returns the settings patch of pool env set
+func envSetPatch(cmd *cobra.Command, args []string) map[string]interface{} {
+	env := make(map[string]interface{})
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			usagef("Error: invalid variable %q; expected NAME=value", arg)
+		}
+		env[name] = value
+	}
+	patch := make(map[string]interface{})
+	if len(env) > 0 {
+		patch["env"] = env
+	}
+	if cmd.Flags().Changed("clear-env") {
+		clearEnv, _ := cmd.Flags().GetString("clear-env")
+		patch["clear_env"] = clearEnv
+	}
+	if len(patch) == 0 {
+		usagef("Error: no variables given; expected NAME=value")
+	}
+	return patch
+}
+
+var poolEnvUnsetCmd = &cobra.Command{
+	Use:   "unset [username] NAME...",
+	Short: "Remove environment variables of a pool",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		updatePoolEnv(args[0], envUnsetPatch(args[1:]))
+	},
+}
+
+// envUnsetPatch returns the settings patch removing the variables names
+func envUnsetPatch(names []string) map[string]interface{} {
+	env := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		env[name] = nil
+	}
+	return map[string]interface{}{"env": env}
+}
+
+var poolEnvListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Short: "Show the environment variables of a pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		cfg, err := pm.GetPoolConfig(args[0])
+		if err != nil {
+			fatalf("Error getting pool config: %v", err)
+		}
+		printEnv(cmd, args[0], cfg.Settings)
+	},
+}
+
+// printEnv prints the env and clear_env of a pool's settings
+func printEnv(cmd *cobra.Command, username string, settings map[string]interface{}) {
+	env, _ := settings["env"].(map[string]interface{})
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(map[string]interface{}{
+			"username":  username,
+			"env":       env,
+			"clear_env": settings["clear_env"],
+		}, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if clearEnv, ok := settings["clear_env"]; ok {
+		fmt.Printf("clear_env = %v\n", clearEnv)
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s=%v\n", name, env[name])
+	}
+}
+
+func updatePoolEnv(username string, patch map[string]interface{}) {
+	pm, err := newPoolManager()
+	if err != nil {
+		fatalf("Error initializing pool manager: %v", err)
+	}
+	if noWait {
+		pm = pm.WithNoWait()
+	}
+	if err := pm.PatchPoolConfig(username, patch); err != nil {
+		fatalf("Error updating pool environment: %v", err)
+	}
+	fmt.Printf("Environment updated for user: %s\n", username)
+}
+
+func init() {
+	poolCmd.AddCommand(poolEnvCmd)
+	poolEnvCmd.AddCommand(poolEnvSetCmd)
+	poolEnvCmd.AddCommand(poolEnvUnsetCmd)
+	poolEnvCmd.AddCommand(poolEnvListCmd)
+	poolEnvSetCmd.Flags().String("clear-env", "", "Set clear_env (yes or no); no passes the PHP-FPM master's environment to the workers")
+	poolEnvListCmd.Flags().Bool("json", false, "Print the environment as JSON")
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolHistoryCmd = &cobra.Command{
+	Use:   "history [username]",
+	Short: "Show the revisions of a pool's configuration",
+	Long: `Show the revisions of a pool's configuration, newest first, with who made each
+change. Every settings change keeps the previous pool file and settings; the
+last 50 revisions are kept. Use 'pool diff' to compare one with the current
+file and 'pool rollback' to restore it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		history, err := pm.PoolHistory(args[0])
+		if err != nil {
+			fatalf("Error getting pool history: %v", err)
+		}
+		printHistory(cmd, history)
+	},
+}
+
+func printHistory(cmd *cobra.Command, history []manager.ConfigRevision) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(history, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	fmt.Printf("%-9s %-20s %-24s %s\n", "REVISION", "CHANGED", "ACTOR", "SETTINGS")
+	for _, r := range history {
+		revision := strconv.FormatInt(r.Revision, 10)
+		if r.Current {
+			revision += "*"
+		}
+		changed := "-"
+		if r.ChangedAt != nil {
+			changed = r.ChangedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		actor := r.Actor
+		if actor == "" {
+			actor = "-"
+		}
+		fmt.Printf("%-9s %-20s %-24s %s\n", revision, changed, actor, formatSettings(r.Settings))
+	}
+}
+
+var poolDiffCmd = &cobra.Command{
+	Use:   "diff [username] [revision]",
+	Short: "Compare a revision of a pool's configuration with the current file",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		revision := parseRevisionArg(args[1])
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		diff, err := pm.DiffPoolConfig(args[0], revision)
+		if err != nil {
+			fatalf("Error comparing pool config: %v", err)
+		}
+		printDiff(args[0], revision, diff)
+	},
+}
+
+func printDiff(username string, revision int64, diff string) {
+	if diff == "" {
+		fmt.Printf("The pool file of %s is unchanged since revision %d\n", username, revision)
+		return
+	}
+	fmt.Print(diff)
+}
+
+var poolRollbackCmd = &cobra.Command{
+	Use:   "rollback [username] [revision]",
+	Short: "Restore the settings a pool had at a revision",
+	Long: `Restore the settings a pool had at a revision from 'pool history'. The pool file
+is rendered from them and PHP-FPM reloaded like for any settings change, so
+the rollback is a new revision and can itself be rolled back.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		revision := parseRevisionArg(args[1])
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		newRevision, err := pm.RollbackPoolConfig(args[0], revision, manager.AnyRevision)
+		if err != nil {
+			fatalf("Error rolling back pool config: %v", err)
+		}
+		fmt.Printf("Pool for user %s rolled back to revision %d (now revision %d)\n", args[0], revision, newRevision)
+	},
+}
+
+func parseRevisionArg(arg string) int64 {
+	revision, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		usagef("Error: invalid revision %q", arg)
+	}
+	return revision
+}
+
+// formatSettings renders settings as sorted key=value pairs
+func formatSettings(settings map[string]interface{}) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, settings[key]))
+	}
+	if len(pairs) == 0 {
+		return "(defaults)"
+	}
+	return strings.Join(pairs, " ")
+}
+
+func init() {
+	poolCmd.AddCommand(poolHistoryCmd)
+	poolCmd.AddCommand(poolDiffCmd)
+	poolCmd.AddCommand(poolRollbackCmd)
+	poolHistoryCmd.Flags().Bool("json", false, "Print the history as JSON")
+}
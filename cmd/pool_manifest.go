@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolExportCmd = &cobra.Command{
+	Use:   "export [username]",
+	Short: "Export a pool and its sites as a manifest",
+	Long: `Write a manifest of the user's pool: PHP version, provider, tenant, settings
+including php.ini overrides, labels and the user's sites with their path
+bindings. 'pool import' recreates all of it on another server; site files are
+not included (use 'backup create' for those).
+
+The manifest is JSON, which YAML parsers also read, so it can be kept as
+bob.yaml next to other configuration. Without -o, or with -o -, it is printed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		manifest, err := pm.ExportManifest(args[0])
+		if err != nil {
+			fatalf("Error exporting pool: %v", err)
+		}
+		encoded, _ := json.MarshalIndent(manifest, "", "  ")
+		encoded = append(encoded, '\n')
+		if output == "" || output == "-" {
+			os.Stdout.Write(encoded)
+			return
+		}
+		if err := os.WriteFile(output, encoded, 0600); err != nil {
+			fatalf("Error writing manifest: %v", err)
+		}
+		fmt.Printf("Pool for user %s exported to %s\n", args[0], output)
+	},
+}
+
+var poolImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Recreate a pool and its sites from a manifest",
+	Long: `Recreate a pool from a manifest written by 'pool export' ("-" reads standard
+input). The PHP version must be installed and the manifest's tenant must
+exist. A pool the user already has for the version is left unchanged, as are
+existing sites; missing sites are created with their bindings.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		phpVersion, _ := cmd.Flags().GetString("php-version")
+
+		var r io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				fatalf("Error opening manifest: %v", err)
+			}
+			defer f.Close()
+			r = f
+		}
+		manifest, err := manager.ReadManifest(r)
+		if err != nil {
+			fatalf("Error reading manifest: %v", err)
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+
+		report, err := pm.ImportManifest(manifest, phpVersion)
+		if err != nil {
+			fatalf("Error importing manifest: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		fmt.Printf("Imported %s from the manifest of %s (provider: %s)\n", report.Username, manifest.Host, manifest.Provider)
+		for _, v := range report.PoolsCreated {
+			fmt.Printf("  Pool PHP %s: created\n", v)
+		}
+		for _, v := range report.PoolsSkipped {
+			fmt.Printf("  Pool PHP %s: already exists, left unchanged\n", v)
+		}
+		for _, d := range report.SitesCreated {
+			fmt.Printf("  Site %s: created\n", d)
+		}
+		for _, d := range report.SitesSkipped {
+			fmt.Printf("  Site %s: already exists, left unchanged\n", d)
+		}
+	},
+}
+
+func init() {
+	poolCmd.AddCommand(poolExportCmd)
+	poolCmd.AddCommand(poolImportCmd)
+	poolExportCmd.Flags().StringP("output", "o", "", "Write the manifest to a file instead of printing it")
+	poolImportCmd.Flags().String("php-version", "", "Local PHP version to use (default: version from the manifest)")
+	poolImportCmd.Flags().Bool("json", false, "Print the import report as JSON")
+}
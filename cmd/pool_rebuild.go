@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolRebuildCmd = &cobra.Command{
+	Use:   "rebuild [username]",
+	Short: "Rebuild a Docker pool's image and recreate its container",
+	Long: `Build the image of a pool that uses the docker provider from its
+settings: the official php:VERSION-fpm image with the extensions listed in
+image_extensions, the pool user, and memory_limit, upload_max_filesize,
+post_max_size, max_execution_time and date_timezone in its php.ini. Images
+are tagged with a hash of their Dockerfile, so an unchanged pool reuses its
+image. The pool's container is recreated with the new image.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		build, err := pm.RebuildPool(username)
+		if err != nil {
+			fatalf("Error rebuilding pool: %v", err)
+		}
+		printImageBuild(cmd, username, build)
+	},
+}
+
+func printImageBuild(cmd *cobra.Command, username string, build *manager.ImageBuild) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		data, _ := json.MarshalIndent(build, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+	if build.Cached {
+		fmt.Printf("Image %s is up to date\n", build.Image)
+	} else {
+		fmt.Printf("Built image %s\n", build.Image)
+	}
+	fmt.Printf("Container %s restarted for user %s\n", build.Container, username)
+}
+
+func init() {
+	poolCmd.AddCommand(poolRebuildCmd)
+	poolRebuildCmd.Flags().Bool("json", false, "Output as JSON")
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolSetCmd = &cobra.Command{
+	Use:   "set [username] key=value...",
+	Short: "Change settings of one pool or of every matching pool",
+	Long: `Merge settings into a pool, or with --all into every pool matching the
+selector flags in one batch with a single PHP-FPM reload per service. If one
+pool cannot be updated, the pools updated before it are restored. An empty
+value (key=) removes a setting.
+
+  lightweight-php pool set alice memory_limit=512M
+  lightweight-php pool set --all --php-version 8.1 memory_limit=256M
+  lightweight-php pool set --all --label env=staging pm_max_children=4`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		username, settings := parsePoolSetArgs(args)
+		checkPoolSetTarget(username, all)
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+
+		if username != "" {
+			if err := pm.PatchPoolConfig(username, settings); err != nil {
+				fatalf("Error updating pool: %v", err)
+			}
+			fmt.Printf("Settings updated for user: %s\n", username)
+			return
+		}
+
+		selector := poolSetSelector(cmd)
+		results, err := pm.PatchPoolsConfig(selector, settings)
+		printBatchResults(results)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Printf("Updated %d pools\n", len(results))
+	},
+}
+
+var poolLabelCmd = &cobra.Command{
+	Use:   "label [username] [key=value|key-...]",
+	Short: "Show, set or remove labels of a pool",
+	Long: `Show the labels of a pool, or set labels with key=value and remove them
+with key-. Labels select pools for bulk changes (pool set --all --label).`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		set, remove := parseLabelChanges(args[1:])
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		var labels map[string]string
+		if len(args) == 1 {
+			labels, err = pm.GetPoolLabels(username)
+		} else {
+			labels, err = pm.UpdatePoolLabels(username, set, remove)
+		}
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		printLabels(labels)
+	},
+}
+
+// parseLabelChanges parses key=value labels to set and key- labels to
+// remove
+func parseLabelChanges(args []string) (set map[string]string, remove []string) {
+	set = make(map[string]string)
+	for _, arg := range args {
+		if key, ok := strings.CutSuffix(arg, "-"); ok && !strings.Contains(arg, "=") {
+			remove = append(remove, key)
+			continue
+		}
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			usagef("Error: invalid label %q, expected key=value or key-", arg)
+		}
+		set[key] = value
+	}
+	return set, remove
+}
+
+func printLabels(labels map[string]string) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%s=%s\n", key, labels[key])
+	}
+}
+
+func printBatchResults(results []manager.BatchResult) {
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Printf("%-20s %-12s %s\n", r.Username, r.Status, r.Error)
+		} else {
+			fmt.Printf("%-20s %s\n", r.Username, r.Status)
+		}
+	}
+}
+
+// parsePoolSetArgs splits the arguments of pool set into the username, if
+// given, and the settings; an empty value is nil, which removes the setting
+func parsePoolSetArgs(args []string) (string, map[string]interface{}) {
+	username := ""
+	if !strings.Contains(args[0], "=") {
+		username, args = args[0], args[1:]
+	}
+	if len(args) == 0 {
+		usagef("Error: no settings given; expected key=value")
+	}
+	settings, err := parseSettingArgs(args)
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	for key, value := range settings {
+		if value == "" {
+			settings[key] = nil
+		}
+	}
+	return username, settings
+}
+
+// checkPoolSetTarget rejects pool set without a username or --all, or with
+// both
+func checkPoolSetTarget(username string, all bool) {
+	if username != "" && all {
+		usagef("Error: give a username or --all, not both")
+	}
+	if username == "" && !all {
+		usagef("Error: give a username, or --all to update every pool matching --label, --php-version, --provider and --tenant")
+	}
+}
+
+// poolSetSelector returns the pools pool set --all selects with its flags
+func poolSetSelector(cmd *cobra.Command) manager.PoolSelector {
+	selector := manager.PoolSelector{All: true}
+	selector.PHPVersion, _ = cmd.Flags().GetString("php-version")
+	selector.Provider, _ = cmd.Flags().GetString("provider")
+	selector.Tenant, _ = cmd.Flags().GetString("tenant")
+	labelArgs, _ := cmd.Flags().GetStringArray("label")
+	var err error
+	if selector.Labels, err = parseLabelArgs(labelArgs); err != nil {
+		usagef("Error: %v", err)
+	}
+	return selector
+}
+
+// parseLabelArgs parses key=value label selectors
+func parseLabelArgs(args []string) (map[string]string, error) {
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", arg)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+func init() {
+	poolCmd.AddCommand(poolSetCmd)
+	poolCmd.AddCommand(poolLabelCmd)
+	poolSetCmd.Flags().Bool("all", false, "Update every pool matching the selector flags instead of one pool")
+	poolSetCmd.Flags().StringArray("label", nil, "Only pools with this label (key=value, repeatable)")
+	poolSetCmd.Flags().String("php-version", "", "Only pools running this PHP version")
+	poolSetCmd.Flags().String("provider", "", "Only pools of this provider")
+	poolSetCmd.Flags().String("tenant", "", "Only pools of this tenant")
+}
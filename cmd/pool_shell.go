@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/spf13/cobra"
+)
+
+var poolShellCmd = &cobra.Command{
+	Use:   "shell [username] [-- command...]",
+	Short: "Open a shell as the pool user with the pool's PHP CLI",
+	Long: `Run a shell, or the given command, as the pool's user with php on PATH
+being the user's wrapper script. The wrapper, /usr/local/bin/php-USERNAME, runs
+the CLI binary of the pool's PHP version with the pool's ini settings, and is
+kept up to date as the pool changes; point cron jobs at it:
+
+  */5 * * * * /usr/local/bin/php-bob /home/bob/public_html/cron.php
+
+Examples:
+  lightweight-php pool shell bob
+  lightweight-php pool shell bob -- php artisan migrate`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		shell, err := pm.PoolShell(args[0], args[1:])
+		if err != nil {
+			fatalf("Error starting shell: %v", err)
+		}
+		shell.Stdin, shell.Stdout, shell.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := shell.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitErr.ExitCode())
+			}
+			fatalf("Error running shell: %v", err)
+		}
+	},
+}
+
+func init() {
+	poolCmd.AddCommand(poolShellCmd)
+}
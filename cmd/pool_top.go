@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+var poolTopCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show the pools using the most CPU and memory, refreshing live",
+	Long: `Show the worker count, CPU and RSS of every pool's PHP-FPM workers,
+read from /proc, together with the active and idle workers and listen queue
+from each pool's status page. On a terminal the view is redrawn every
+--interval until interrupted with Ctrl-C; otherwise, or with --once or
+--json, one sample is printed after measuring CPU usage over --interval.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		sortBy, _ := cmd.Flags().GetString("sort")
+		limit, _ := cmd.Flags().GetInt("limit")
+		once, _ := cmd.Flags().GetBool("once")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if interval < 100*time.Millisecond {
+			usagef("Error: --interval must be at least 100ms")
+		}
+		if !containsString(manager.TopSorts, sortBy) {
+			usagef("Error: --sort must be one of: %s", strings.Join(manager.TopSorts, ", "))
+		}
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		if once || asJSON || !isatty.IsTerminal(os.Stdout.Fd()) {
+			report, err := pm.Top(interval, sortBy)
+			if err != nil {
+				fatalf("Error reading pool usage: %v", err)
+			}
+			if limit > 0 && len(report.Pools) > limit {
+				report.Pools = report.Pools[:limit]
+			}
+			if asJSON {
+				encoded, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(encoded))
+				return
+			}
+			printTop(report, limit)
+			return
+		}
+
+		if limit == 0 {
+			// Fill the terminal below the header lines
+			limit = terminalHeight() - 4
+		}
+		sampler := pm.NewTopSampler()
+		for {
+			report, err := sampler.Sample(sortBy)
+			if err != nil {
+				fatalf("Error reading pool usage: %v", err)
+			}
+			fmt.Print("\033[H\033[2J")
+			printTop(report, limit)
+			time.Sleep(interval)
+		}
+	},
+}
+
+func printTop(report *manager.TopReport, limit int) {
+	fmt.Printf("%s  %d pools, %d workers, CPU %.1f%%, RSS %s (sorted by %s)\n\n",
+		report.SampledAt.Local().Format("15:04:05"), len(report.Pools), report.Workers,
+		report.CPUPercent, formatRSS(report.RSS), report.Sort)
+	fmt.Printf("%-20s %-6s %7s %6s %6s %6s %7s %10s  %s\n", "USER", "PHP", "WORKERS", "ACTIVE", "IDLE", "QUEUE", "CPU%", "RSS", "STATUS")
+	for i, p := range report.Pools {
+		if limit > 0 && i == limit {
+			break
+		}
+		status := p.Status
+		if p.Error != "" {
+			status = "error: " + p.Error
+		}
+		fmt.Printf("%-20s %-6s %7d %6s %6s %6s %7.1f %10s  %s\n", p.Username, p.PHPVersion, p.Workers,
+			optionalCount(p.Active), optionalCount(p.Idle), optionalCount(p.ListenQueue),
+			p.CPUPercent, formatRSS(p.RSS), status)
+	}
+}
+
+func optionalCount(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+func formatRSS(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(bytes)/(1<<20))
+	default:
+		return fmt.Sprintf("%dK", bytes>>10)
+	}
+}
+
+// terminalHeight returns the terminal's rows from $LINES, or 24
+func terminalHeight() int {
+	if n, err := strconv.Atoi(os.Getenv("LINES")); err == nil && n > 5 {
+		return n
+	}
+	return 24
+}
+
+func init() {
+	poolCmd.AddCommand(poolTopCmd)
+	poolTopCmd.Flags().Duration("interval", 2*time.Second, "Time between refreshes, and that CPU usage is measured over")
+	poolTopCmd.Flags().String("sort", "cpu", "Sort by: "+strings.Join(manager.TopSorts, ", "))
+	poolTopCmd.Flags().Int("limit", 0, "Show at most this many pools (default: all, or what fits the terminal)")
+	poolTopCmd.Flags().Bool("once", false, "Print one sample instead of refreshing")
+	poolTopCmd.Flags().Bool("json", false, "Print one sample as JSON")
+}
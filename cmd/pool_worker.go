@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolWorkerCmd = &cobra.Command{
+	Use:   "worker",
+	Short: "Manage long-running PHP workers of a pool",
+	Long: `Manage long-running PHP commands of a pool such as Laravel queue workers.
+Each worker runs as the pool's user with the pool's PHP (the user's CLI
+wrapper) under a systemd template unit, one instance per process, or a
+supervisord program, and is restarted when it exits.`,
+}
+
+var poolWorkerListCmd = &cobra.Command{
+	Use:   "list [username]",
+	Short: "List the workers of a pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		workers, err := pm.ListWorkers(args[0])
+		if err != nil {
+			fatalf("Error listing workers: %v", err)
+		}
+		printWorkers(cmd, args[0], workers)
+	},
+}
+
+func printWorkers(cmd *cobra.Command, username string, workers []manager.Worker) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(workers, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(workers) == 0 {
+		fmt.Printf("No workers for user: %s\n", username)
+		return
+	}
+	for _, w := range workers {
+		fmt.Printf("%-20s %-9s %2dx  %s  (in %s)\n", w.Name, w.Status, w.Processes, w.Command, w.Directory)
+	}
+}
+
+var poolWorkerAddCmd = &cobra.Command{
+	Use:   "add [username] NAME COMMAND...",
+	Short: "Add a worker to a pool and start it",
+	Long: `Add a worker to a pool and start it. COMMAND is what the pool's PHP runs;
+its arguments may only contain letters, digits and _ . / : = , @ + -. Put
+COMMAND after -- when it has options of its own.
+
+  lightweight-php pool worker add bob queue -- artisan queue:work --tries=3
+  lightweight-php pool worker add bob queue --processes 4 --directory /home/bob/app -- artisan queue:work`,
+	Args: cobra.MinimumNArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		spec := workerAddSpec(cmd, args)
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		worker, err := pm.AddWorker(args[0], spec)
+		if err != nil {
+			fatalf("Error adding worker: %v", err)
+		}
+		fmt.Printf("Worker %s added for user: %s (%s, %s)\n", worker.Name, args[0], worker.Service, worker.Status)
+	},
+}
+
+// workerAddSpec returns the worker pool worker add defines
+func workerAddSpec(cmd *cobra.Command, args []string) manager.WorkerSpec {
+	spec := manager.WorkerSpec{Name: args[1], Command: strings.Join(args[2:], " ")}
+	spec.Directory, _ = cmd.Flags().GetString("directory")
+	spec.Processes, _ = cmd.Flags().GetInt("processes")
+	if err := spec.Validate(); err != nil {
+		usagef("Error: %v", err)
+	}
+	return spec
+}
+
+var poolWorkerDeleteCmd = &cobra.Command{
+	Use:   "delete [username] NAME",
+	Short: "Stop a worker and remove it",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		if err := pm.DeleteWorker(args[0], args[1]); err != nil {
+			fatalf("Error deleting worker: %v", err)
+		}
+		fmt.Printf("Worker %s deleted for user: %s\n", args[1], args[0])
+	},
+}
+
+// newWorkerControlCmd returns the start, stop or restart command
+func newWorkerControlCmd(action, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   action + " [username] NAME",
+		Short: short,
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			pm, err := newPoolManager()
+			if err != nil {
+				fatalf("Error initializing pool manager: %v", err)
+			}
+			worker, err := pm.ControlWorker(args[0], args[1], action)
+			if err != nil {
+				fatalf("Error: %v", err)
+			}
+			fmt.Printf("Worker %s of %s is %s\n", worker.Name, args[0], worker.Status)
+		},
+	}
+}
+
+var poolWorkerLogsCmd = &cobra.Command{
+	Use:   "logs [username] NAME",
+	Short: "Show the last lines a worker printed",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		lines := linesFlag(cmd)
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		log, err := pm.WorkerLogs(args[0], args[1], lines)
+		if err != nil {
+			fatalf("Error reading worker log: %v", err)
+		}
+		for _, line := range log.Lines {
+			fmt.Println(line)
+		}
+	},
+}
+
+// linesFlag returns --lines, rejecting counts the API would refuse
+func linesFlag(cmd *cobra.Command) int {
+	lines, _ := cmd.Flags().GetInt("lines")
+	if lines < 1 || lines > 1000 {
+		usagef("Error: --lines must be between 1 and 1000")
+	}
+	return lines
+}
+
+func init() {
+	poolCmd.AddCommand(poolWorkerCmd)
+	poolWorkerCmd.AddCommand(poolWorkerListCmd)
+	poolWorkerCmd.AddCommand(poolWorkerAddCmd)
+	poolWorkerCmd.AddCommand(poolWorkerDeleteCmd)
+	poolWorkerCmd.AddCommand(newWorkerControlCmd(manager.WorkerStart, "Start a worker and start it at boot"))
+	poolWorkerCmd.AddCommand(newWorkerControlCmd(manager.WorkerStop, "Stop a worker and keep it stopped at boot"))
+	poolWorkerCmd.AddCommand(newWorkerControlCmd(manager.WorkerRestart, "Restart a worker's processes, e.g. after a deploy"))
+	poolWorkerCmd.AddCommand(poolWorkerLogsCmd)
+	poolWorkerListCmd.Flags().Bool("json", false, "Print the workers as JSON")
+	poolWorkerAddCmd.Flags().String("directory", "", "Working directory (default the user's home)")
+	poolWorkerAddCmd.Flags().Int("processes", 1, "Number of processes to run")
+	poolWorkerLogsCmd.Flags().Int("lines", 100, "Number of lines to show")
+}
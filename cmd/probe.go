@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolTestCmd = &cobra.Command{
+	Use:   "test [username]",
+	Short: "Send a FastCGI test request to a pool",
+	Long: "Connect to the pool's socket over FastCGI, run a small probe script and report " +
+		"the response status, response time and the PHP version that served it. " +
+		"--script also requests an existing script, given as a path inside the pool's target. " +
+		"Exits non-zero when the pool does not answer correctly.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		script, _ := cmd.Flags().GetString("script")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+
+		result, err := pm.TestPool(username, script)
+		if err != nil {
+			fatalf("Error testing pool: %v", err)
+		}
+		printTestResult(cmd, username, result)
+	},
+}
+
+// printTestResult prints the result of pool test and exits non-zero when
+// the pool does not work
+func printTestResult(cmd *cobra.Command, username string, result *manager.PoolTestResult) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(encoded))
+	} else {
+		if result.Script != "" {
+			fmt.Printf("Script:        %s\n", result.Script)
+		}
+		if result.Status != 0 {
+			fmt.Printf("Status:        %d\n", result.Status)
+			fmt.Printf("Response time: %.1f ms\n", result.ResponseTimeMs)
+		}
+		if result.PHPVersion != "" {
+			fmt.Printf("PHP version:   %s (%s, expected %s)\n", result.PHPVersion, result.SAPI, result.ExpectedVersion)
+		}
+		if result.Stderr != "" {
+			fmt.Printf("Stderr:        %s\n", result.Stderr)
+		}
+		for _, problem := range result.Problems {
+			fmt.Printf("Problem: %s\n", problem)
+		}
+		if result.OK {
+			fmt.Printf("Pool %s is working\n", username)
+		} else {
+			fmt.Printf("Pool %s is not working\n", username)
+		}
+	}
+	if !result.OK {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	poolCmd.AddCommand(poolTestCmd)
+	poolTestCmd.Flags().String("script", "", "Also request this script (absolute path inside the pool's target)")
+	poolTestCmd.Flags().Bool("json", false, "Print the result as JSON")
+}
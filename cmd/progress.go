@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/mattn/go-isatty"
+)
+
+// spinnerFrames are drawn in turn while an install runs
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// installProgress shows the output of an install on stderr: on a terminal
+// as one redrawn line with a spinner, the elapsed time and the latest
+// output line, otherwise line by line for logs. stop ends the display and
+// must be called before printing the outcome.
+func installProgress(verbose bool) (progress manager.InstallProgress, stop func()) {
+	if verbose || !isatty.IsTerminal(os.Stderr.Fd()) {
+		return manager.InstallProgress{
+			Line: func(line string) { fmt.Fprintln(os.Stderr, line) },
+		}, func() {}
+	}
+
+	var mu sync.Mutex
+	last := "starting"
+	start := time.Now()
+	width := terminalWidth()
+	draw := func(frame int) {
+		mu.Lock()
+		line := last
+		mu.Unlock()
+		elapsed := time.Since(start).Truncate(time.Second)
+		status := fmt.Sprintf("%s %s %s", spinnerFrames[frame%len(spinnerFrames)], elapsed, line)
+		if len(status) > width-1 {
+			status = status[:width-1]
+		}
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", status)
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(150 * time.Millisecond)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			draw(frame)
+			select {
+			case <-done:
+				fmt.Fprint(os.Stderr, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return manager.InstallProgress{
+		Line: func(line string) {
+			mu.Lock()
+			last = line
+			mu.Unlock()
+		},
+	}, func() {
+		once.Do(func() {
+			close(done)
+			<-finished
+		})
+	}
+}
+
+// terminalWidth returns $COLUMNS, or 80 when it is not set
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 20 {
+		return n
+	}
+	return 80
+}
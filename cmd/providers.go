@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+	"lightweight-php/provider"
+
+	"github.com/spf13/cobra"
+)
+
+var providersCmd = &cobra.Command{
+	Use:   "providers",
+	Short: "Inspect PHP providers",
+}
+
+var providersCheckCmd = &cobra.Command{
+	Use:   "check [provider...]",
+	Short: "Check that the package repositories of providers are reachable",
+	Long: "Check that the metadata of the repositories the providers install from can be fetched, " +
+		"that their signing keys are present and valid, and that the package manager's cache of them " +
+		"is recent, so that a network policy blocking a repository is found before an install waits " +
+		"for the package manager to time out. Without arguments every provider is checked; providers " +
+		"that use only the distribution's repositories have nothing to check. Exits non-zero when a " +
+		"check fails.",
+	ValidArgs: providerChoices,
+	Run: func(cmd *cobra.Command, args []string) {
+		args = checkedProviders(args)
+		pm, err := newPackageManagerFor(cmd)
+		if err != nil {
+			fatalf("Error initializing package manager: %v", err)
+		}
+
+		reports := make([]*manager.ProviderHealth, 0, len(args))
+		for _, name := range args {
+			report, err := pm.ProviderHealth(provider.ProviderType(name))
+			if err != nil {
+				fatalf("Error checking provider %s: %v", name, err)
+			}
+			reports = append(reports, report)
+		}
+		printProviderHealth(cmd, reports)
+	},
+}
+
+// checkedProviders validates the providers given to providers check, all
+// of them when none is
+func checkedProviders(args []string) []string {
+	for _, name := range args {
+		if !containsString(providerChoices, name) {
+			usagef("Error: unknown provider %q", name)
+		}
+	}
+	if len(args) == 0 {
+		return providerChoices
+	}
+	return args
+}
+
+// printProviderHealth prints the checks of providers check, exiting
+// non-zero when one failed
+func printProviderHealth(cmd *cobra.Command, reports []*manager.ProviderHealth) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(reports, "", "  ")
+		fmt.Println(string(encoded))
+	} else {
+		for _, report := range reports {
+			if len(report.Checks) == 0 {
+				if len(reports) == 1 {
+					fmt.Printf("%s: no third-party repository to check\n", report.Provider)
+				}
+				continue
+			}
+			fmt.Printf("%s:\n", report.Provider)
+			for _, c := range report.Checks {
+				fmt.Printf("  %-9s %-12s %s: %s\n", "["+c.Status+"]", c.Check, c.Subject, c.Message)
+				if c.Fix != "" {
+					fmt.Printf("  %-22s fix: %s\n", "", c.Fix)
+				}
+			}
+		}
+	}
+	for _, report := range reports {
+		if !report.OK {
+			os.Exit(1)
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(providersCmd)
+	providersCmd.AddCommand(providersCheckCmd)
+	providersCmd.PersistentFlags().String("target", "", "Operate inside a chroot or container: chroot:DIR, nspawn:NAME or lxc:NAME")
+	providersCheckCmd.Flags().Bool("json", false, "Print the checks as JSON")
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/config"
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Compare the database with the host and report or heal discrepancies",
+	Long: `Look for active pools whose pool file is missing, PHP versions pools run on
+whose php-fpm binary is gone and PHP-FPM services of active pools that are not
+running. The API server does this every reconcile.interval and heals what
+reconcile.heal lists; here --heal chooses. Discrepancies stay recorded until
+they are gone. Exits non-zero while any is left.
+
+  lightweight-php reconcile --heal config,service`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		heal := reconcileHeal(cmd)
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		report, err := pm.Reconcile(heal)
+		if err != nil {
+			fatalf("Error reconciling: %v", err)
+		}
+		printReconcileReport(cmd, report)
+	},
+}
+
+func reconcileHeal(cmd *cobra.Command) []string {
+	heal, _ := cmd.Flags().GetStringSlice("heal")
+	for _, kind := range heal {
+		if kind != config.HealConfig && kind != config.HealService {
+			usagef("Error: --heal takes config and service")
+		}
+	}
+	return heal
+}
+
+// printReconcileReport prints a reconciliation pass, exiting non-zero while
+// a discrepancy is left
+func printReconcileReport(cmd *cobra.Command, report *manager.ReconcileReport) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(encoded))
+	} else {
+		for _, d := range report.Healed {
+			fmt.Printf("%-9s %-16s %s: %s\n", "[healed]", d.Kind, d.Subject, d.Message)
+		}
+		for _, d := range report.Resolved {
+			fmt.Printf("%-9s %-16s %s: %s\n", "[gone]", d.Kind, d.Subject, d.Message)
+		}
+		for _, d := range report.Discrepancies {
+			fmt.Printf("%-9s %-16s %s: %s (since %s)\n", "[open]", d.Kind, d.Subject, d.Message, d.FirstSeenAt.Local().Format("2006-01-02 15:04"))
+			if d.HealError != "" {
+				fmt.Printf("%-26s heal failed: %s\n", "", d.HealError)
+			}
+		}
+		if len(report.Discrepancies) == 0 {
+			fmt.Println("The database and the host agree")
+		}
+	}
+	if len(report.Discrepancies) > 0 {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+	reconcileCmd.Flags().StringSlice("heal", nil, "Repair these kinds of discrepancies: config renders missing pool files, service starts stopped services")
+	reconcileCmd.Flags().Bool("json", false, "Print the report as JSON")
+}
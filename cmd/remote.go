@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+// remoteCommand runs a command through the API instead of the local
+// managers, printing like the local command
+type remoteCommand func(cmd *cobra.Command, args []string, client *apiclient.Client)
+
+// remoteCommands are the commands --server runs on the server, by
+// operation name. The commands in localCommands fail with --server rather
+// than silently changing the local host.
+var remoteCommands = map[string]remoteCommand{
+	"pool.create":           remotePoolCreate,
+	"pool.create-bulk":      remotePoolCreateBulk,
+	"pool.delete":           remotePoolDelete,
+	"pool.list":             remotePoolList,
+	"pool.profiles":         remotePoolProfiles,
+	"pool.status":           remotePoolStatus,
+	"pool.set":              remotePoolSet,
+	"pool.label":            remotePoolLabel,
+	"pool.history":          remotePoolHistory,
+	"pool.diff":             remotePoolDiff,
+	"pool.rollback":         remotePoolRollback,
+	"pool.apcu.set":         remotePoolAPCuSet,
+	"pool.opcache.set":      remotePoolOpcacheSet,
+	"pool.opcache.reset":    remotePoolOpcacheReset,
+	"pool.env.set":          remotePoolEnvSet,
+	"pool.env.unset":        remotePoolEnvUnset,
+	"pool.env.list":         remotePoolEnvList,
+	"pool.cron.list":        remotePoolCronList,
+	"pool.cron.add":         remotePoolCronAdd,
+	"pool.cron.remove":      remotePoolCronRemove,
+	"pool.domain.list":      remotePoolDomainList,
+	"pool.domain.add":       remotePoolDomainAdd,
+	"pool.domain.set":       remotePoolDomainSet,
+	"pool.domain.remove":    remotePoolDomainRemove,
+	"pool.worker.list":      remotePoolWorkerList,
+	"pool.worker.add":       remotePoolWorkerAdd,
+	"pool.worker.delete":    remotePoolWorkerDelete,
+	"pool.worker.start":     remoteWorkerControl(manager.WorkerStart),
+	"pool.worker.stop":      remoteWorkerControl(manager.WorkerStop),
+	"pool.worker.restart":   remoteWorkerControl(manager.WorkerRestart),
+	"pool.worker.logs":      remotePoolWorkerLogs,
+	"pool.rebuild":          remotePoolRebuild,
+	"pool.tune":             remotePoolTune,
+	"pool.test":             remotePoolTest,
+	"pool.inactive":         remotePoolInactive,
+	"pool.suspend":          remotePoolSuspend,
+	"pool.unsuspend":        remotePoolUnsuspend,
+	"pool.top":              remotePoolTop,
+	"pool.export-bundle":    remotePoolExportBundle,
+	"pool.import-bundle":    remotePoolImportBundle,
+	"php.install":           remotePHPInstall,
+	"php.uninstall":         remotePHPUninstall,
+	"php.upgrade":           remotePHPUpgrade,
+	"php.list":              remotePHPList,
+	"php.installs":          remotePHPInstalls,
+	"php.extension.install": remotePHPExtensionInstall,
+	"php.loader.list":       remotePHPLoaderList,
+	"php.loader.install":    remotePHPLoaderInstall,
+	"php.opcache.set":       remotePHPOpcacheSet,
+	"php.fpm-config.show":   remotePHPFPMConfigShow,
+	"php.fpm-config.set":    remotePHPFPMConfigSet,
+	"php.fpm-log":           remotePHPFPMLog,
+	"site.create":           remoteSiteCreate,
+	"site.bind":             remoteSiteBind,
+	"site.unbind":           remoteSiteUnbind,
+	"site.list":             remoteSiteList,
+	"site.show":             remoteSiteShow,
+	"site.delete":           remoteSiteDelete,
+	"site.dns":              remoteSiteDNS,
+	"site.cert.issue":       remoteSiteCertIssue,
+	"site.cert.list":        remoteSiteCertList,
+	"site.cert.renew":       remoteSiteCertRenew,
+	"defaults.show":         remoteDefaultsShow,
+	"defaults.set":          remoteDefaultsSet,
+	"tenant.list":           remoteTenantList,
+	"tenant.show":           remoteTenantShow,
+	"tenant.create":         remoteTenantCreate,
+	"tenant.set":            remoteTenantSet,
+	"tenant.delete":         remoteTenantDelete,
+	"tenant.assign":         remoteTenantAssign,
+	"schedule.add":          remoteScheduleAdd,
+	"schedule.list":         remoteScheduleList,
+	"schedule.update":       remoteScheduleUpdate,
+	"schedule.cancel":       remoteScheduleCancel,
+	"jobs.list":             remoteJobsList,
+	"jobs.add":              remoteJobsAdd,
+	"jobs.delete":           remoteJobsDelete,
+	"jobs.runs":             remoteJobsRuns,
+	"jobs.run":              remoteJobsRun,
+	"doctor":                remoteDoctor,
+	"reconcile":             remoteReconcile,
+	"billing.usage":         remoteBillingUsage,
+	"billing.export":        remoteBillingExport,
+	"audit":                 remoteAudit,
+	"providers.check":       remoteProvidersCheck,
+	"inventory":             remoteInventory,
+	"state.show":            remoteStateShow,
+	"state.diff":            remoteStateDiff,
+}
+
+// localCommands are the commands that have no API endpoint because they
+// work on this host itself, with why --server refuses them
+var localCommands = map[string]string{
+	"server":                "it runs the server",
+	"helper":                "it runs operations for the unprivileged users of this host",
+	"dev-exec":              "it runs commands in the local development sandbox",
+	"pool.shell":            "it opens a shell on this host",
+	"pool.export":           "it writes local files",
+	"pool.import":           "it reads local files",
+	"pool.export-compose":   "it writes local files",
+	"schedule.run":          "the server applies due changes itself",
+	"backup.create":         "backups are taken on the host holding the account",
+	"backup.list":           "it reads the offsite storage configured on this host",
+	"backup.restore":        "backups are restored on the host receiving the account",
+	"backup.run":            "backups are taken on the host holding the account",
+	"account.erase":         "erasure runs on the host holding the account's data",
+	"account.erasures":      "erasure runs on the host holding the account's data",
+	"account.purge-expired": "erasure runs on the host holding the account's data",
+	"app.install.wordpress": "it installs files into a local document root",
+	"db.migrate":            "it works on the local database",
+	"db.replicate":          "it works on the local database",
+	"db.restore":            "it works on the local database",
+	"db.secrets.rotate":     "it works on the local database and key",
+	"db.secrets.status":     "it works on the local database and key",
+	"db.snapshots":          "it works on the local database",
+	"db.status":             "it works on the local database",
+	"host.evacuate":         "it drives the migration from the host being emptied",
+	"host.firewall":         "it changes the local firewall",
+	"host.sftp":             "it changes the local SSH configuration",
+	"host.status":           "it reads the local host's drain state",
+	"host.undrain":          "it changes the local host's drain state",
+	"import.cpanel":         "it reads the panel's files on this host",
+	"import.plesk":          "it reads the panel's files on this host",
+	"migrate.account":       "it drives the migration from the source host",
+	"services.graph":        "it reads the units on this host",
+	"templates.debug":       "it renders the local templates",
+	"templates.failures":    "it reads the local template failures",
+	"templates.list":        "it lists the local templates",
+	"templates.show":        "it prints a local template",
+	"templates.validate":    "it checks the local templates",
+}
+
+// forwardToServer makes cmd run through the API of --server when it is
+// given. Commands that only talk to a server, like api, keep running here.
+func forwardToServer(cmd *cobra.Command) error {
+	if serverURL == "" || !cmd.HasParent() || !cmd.Runnable() || cmd.Name() == "help" || strings.HasPrefix(cmd.Name(), "__") {
+		return nil
+	}
+	operation := operationName(cmd)
+	if operation == "api" || strings.HasPrefix(operation, "completion") {
+		return nil
+	}
+	if devMode {
+		return fmt.Errorf("--dev cannot be combined with --server")
+	}
+	remote, ok := remoteCommands[operation]
+	if !ok {
+		if reason, local := localCommands[operation]; local {
+			return fmt.Errorf("%s is not available with --server: %s", cmd.CommandPath(), reason)
+		}
+		return fmt.Errorf("%s is not available with --server; 'lightweight-php api' reaches the rest of the API", cmd.CommandPath())
+	}
+	client, err := newAPIClient()
+	if err != nil {
+		return err
+	}
+	cmd.RunE = nil
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		remote(cmd, args, client)
+	}
+	return nil
+}
+
+// withQuery appends the non-empty values to path
+func withQuery(path string, values map[string]string) string {
+	query := url.Values{}
+	for key, value := range values {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// boolQuery is "true" when a boolean flag is set, else omitted by withQuery
+func boolQuery(cmd *cobra.Command, flag string) string {
+	if set, _ := cmd.Flags().GetBool(flag); set {
+		return "true"
+	}
+	return ""
+}
+
+// rejectRemoteTarget fails a command whose endpoint has no target
+// parameter when --target is given
+func rejectRemoteTarget(cmd *cobra.Command) {
+	if targetSpec, _ := cmd.Flags().GetString("target"); targetSpec != "" {
+		usagef("Error: --target is not available for %s with --server", strings.ReplaceAll(operationName(cmd), ".", " "))
+	}
+}
+
+func remotePoolCreate(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	if len(args) == 0 {
+		usagef("Error: username is required with --server")
+	}
+	body := apitypes.CreatePoolRequest{Username: args[0]}
+	body.PHPVersion, _ = cmd.Flags().GetString("php-version")
+	body.Provider, _ = cmd.Flags().GetString("provider")
+	body.Profile, _ = cmd.Flags().GetString("profile")
+	body.Target, _ = cmd.Flags().GetString("target")
+	body.Tenant, _ = cmd.Flags().GetString("tenant")
+	body.CreateUser, _ = cmd.Flags().GetBool("create-user")
+	body.Shell, _ = cmd.Flags().GetString("shell")
+	if keyFile, _ := cmd.Flags().GetString("ssh-key-file"); keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			fatalf("Error reading %s: %v", keyFile, err)
+		}
+		body.SSHKey = string(key)
+	}
+
+	var result apitypes.PoolResult
+	if err := client.Do("POST", "/api/v1/pools", body, &result); err != nil {
+		fatalf("Error creating pool: %v", err)
+	}
+	fmt.Printf("Pool created for user: %s with PHP %s (provider: %s)\n", result.Username, result.PHPVersion, result.Provider)
+	if result.Target != "" {
+		fmt.Printf("Target: %s\n", result.Target)
+	}
+	if result.Tenant != "" {
+		fmt.Printf("Tenant: %s\n", result.Tenant)
+	}
+	if result.Profile != "" {
+		fmt.Printf("Applied profile: %s\n", result.Profile)
+	}
+}
+
+func remotePoolDelete(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	path := withQuery("/api/v1/pools/"+url.PathEscape(args[0]), map[string]string{
+		"purge_data":  boolQuery(cmd, "purge-data"),
+		"remove_user": boolQuery(cmd, "remove-user"),
+	})
+	var result apitypes.PoolResult
+	if err := client.Do("DELETE", path, nil, &result); err != nil {
+		fatalf("Error deleting pool: %v", err)
+	}
+	fmt.Printf("Pool deleted for user: %s\n", result.Username)
+	if result.User != "" {
+		fmt.Printf("User %s %s\n", result.Username, result.User)
+	}
+}
+
+func remotePoolList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	tenant, _ := cmd.Flags().GetString("tenant")
+	var pools []manager.Pool
+	if err := client.Do("GET", withQuery("/api/v1/pools", map[string]string{"tenant": tenant}), nil, &pools); err != nil {
+		fatalf("Error listing pools: %v", err)
+	}
+	for _, pool := range pools {
+		printPool(pool)
+	}
+}
+
+func remotePoolProfiles(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var profiles []manager.Profile
+	if err := client.Do("GET", "/api/v1/profiles", nil, &profiles); err != nil {
+		fatalf("Error listing profiles: %v", err)
+	}
+	printProfiles(profiles)
+}
+
+func remotePHPInstall(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	targetSpec, _ := cmd.Flags().GetString("target")
+	path := withQuery("/api/v1/php/install/"+url.PathEscape(args[0]), map[string]string{
+		"rollback": boolQuery(cmd, "rollback"),
+		"target":   targetSpec,
+	})
+	var result apitypes.InstallResult
+	if err := client.Do("POST", path, nil, &result); err != nil {
+		fatalf("Error installing PHP: %v", err)
+	}
+	fmt.Printf("PHP %s installed successfully\n", result.Version)
+}
+
+func remotePHPUninstall(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	targetSpec, _ := cmd.Flags().GetString("target")
+	providerName, _ := cmd.Flags().GetString("provider")
+	migrateTo, _ := cmd.Flags().GetString("migrate-to")
+	path := withQuery("/api/v1/php/"+url.PathEscape(args[0]), map[string]string{
+		"provider":   providerName,
+		"migrate_to": migrateTo,
+		"target":     targetSpec,
+	})
+	var result apitypes.UninstallResult
+	if err := client.Do("DELETE", path, nil, &result); err != nil {
+		fatalf("Error uninstalling PHP: %v", err)
+	}
+	for _, username := range result.Migrated {
+		fmt.Printf("Moved pool %s to PHP %s\n", username, result.MigratedTo)
+	}
+	fmt.Printf("PHP %s uninstalled\n", result.Version)
+}
+
+func remotePHPList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	rejectRemoteTarget(cmd)
+	var list apitypes.PHPVersionList
+	path := withQuery("/api/v1/php/versions", map[string]string{"refresh": boolQuery(cmd, "refresh")})
+	if err := client.Do("GET", path, nil, &list); err != nil {
+		fatalf("Error listing PHP versions: %v", err)
+	}
+	// The server lists the versions of every provider
+	for _, v := range list.Versions {
+		fmt.Printf("PHP %s (provider: %s)\n", v.Version, v.Provider)
+	}
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+// phpPath returns the API path of a PHP version, followed by rest
+func phpPath(version, rest string) string {
+	return "/api/v1/php/" + url.PathEscape(version) + rest
+}
+
+func remotePHPInstalls(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	providerName, _ := cmd.Flags().GetString("provider")
+	base := "/api/v1/providers/" + url.PathEscape(providerName) + "/installs"
+
+	if len(args) == 1 {
+		var log manager.InstallLog
+		if err := client.Do("GET", base+"/"+strconv.FormatInt(parseInstallID(args[0]), 10), nil, &log); err != nil {
+			fatalf("Error: %v", err)
+		}
+		printInstallLog(cmd, &log)
+		return
+	}
+
+	query := map[string]string{}
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 {
+		query["limit"] = strconv.Itoa(limit)
+	}
+	var logs []manager.InstallLog
+	if err := client.Do("GET", withQuery(base, query), nil, &logs); err != nil {
+		fatalf("Error listing installs: %v", err)
+	}
+	printInstallLogs(cmd, providerName, logs)
+}
+
+func remotePHPLoaderList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	rejectRemoteTarget(cmd)
+	version, _ := cmd.Flags().GetString("php")
+	providerName, _ := cmd.Flags().GetString("provider")
+	var loaders []manager.LoaderStatus
+	path := withQuery(phpPath(version, "/loaders"), map[string]string{"provider": providerName})
+	if err := client.Do("GET", path, nil, &loaders); err != nil {
+		fatalf("Error listing loaders: %v", err)
+	}
+	printLoaders(loaders)
+}
+
+func remotePHPLoaderInstall(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	rejectRemoteTarget(cmd)
+	version, _ := cmd.Flags().GetString("php")
+	var body struct {
+		Loader   string `json:"loader"`
+		Provider string `json:"provider"`
+	}
+	body.Loader = args[0]
+	body.Provider, _ = cmd.Flags().GetString("provider")
+	var status manager.LoaderStatus
+	if err := client.Do("POST", phpPath(version, "/loaders"), body, &status); err != nil {
+		fatalf("Error installing loader: %v", err)
+	}
+	printLoaderInstalled(version, &status)
+}
+
+func remotePHPOpcacheSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	rejectRemoteTarget(cmd)
+	providerName, _ := cmd.Flags().GetString("provider")
+	settings, err := parseSettingArgs(args[1:])
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	var result struct {
+		Path string `json:"path"`
+	}
+	path := withQuery(phpPath(args[0], "/opcache"), map[string]string{"provider": providerName})
+	if err := client.Do("PUT", path, settings, &result); err != nil {
+		fatalf("Error configuring OPcache: %v", err)
+	}
+	fmt.Printf("OPcache settings for PHP %s written to %s\n", args[0], result.Path)
+}
+
+// fpmQuery returns the provider and target parameters of the fpm-config
+// and fpm-log endpoints
+func fpmQuery(cmd *cobra.Command) map[string]string {
+	query := map[string]string{}
+	query["provider"], _ = cmd.Flags().GetString("provider")
+	query["target"], _ = cmd.Flags().GetString("target")
+	return query
+}
+
+func remotePHPFPMConfigShow(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var cfg manager.FPMConfig
+	if err := client.Do("GET", withQuery(phpPath(args[0], "/fpm-config"), fpmQuery(cmd)), nil, &cfg); err != nil {
+		fatalf("Error reading FPM config: %v", err)
+	}
+	printFPMConfig(cmd, &cfg)
+}
+
+func remotePHPFPMConfigSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	changes := parseFPMConfigArgs(args[1:])
+	query := fpmQuery(cmd)
+	query["no_wait"] = noWaitQuery()
+	var cfg manager.FPMConfig
+	if err := client.Do("PATCH", withQuery(phpPath(args[0], "/fpm-config"), query), changes, &cfg); err != nil {
+		fatalf("Error configuring PHP-FPM: %v", err)
+	}
+	printFPMSettings(args[0], &cfg)
+}
+
+func remotePHPFPMLog(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	query := fpmQuery(cmd)
+	query["lines"] = strconv.Itoa(linesFlag(cmd))
+	var log manager.FPMLog
+	if err := client.Do("GET", withQuery(phpPath(args[0], "/fpm-log"), query), nil, &log); err != nil {
+		fatalf("Error reading FPM master log: %v", err)
+	}
+	for _, line := range log.Lines {
+		fmt.Println(line)
+	}
+}
+
+func remotePHPExtensionInstall(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	version, _ := cmd.Flags().GetString("php")
+	targetSpec, _ := cmd.Flags().GetString("target")
+	var body struct {
+		Name            string `json:"name"`
+		Provider        string `json:"provider"`
+		BuildFromSource bool   `json:"build_from_source"`
+	}
+	body.Name = args[0]
+	body.Provider, _ = cmd.Flags().GetString("provider")
+	body.BuildFromSource, _ = cmd.Flags().GetBool("build-from-source")
+
+	var status manager.ExtensionStatus
+	path := withQuery(phpPath(version, "/extensions"), map[string]string{
+		"target":  targetSpec,
+		"no_wait": noWaitQuery(),
+	})
+	if err := client.Do("POST", path, body, &status); err != nil {
+		fatalf("Error installing extension: %v", err)
+	}
+	printExtensionStatus(cmd, version, body.Provider, &status)
+}
+
+func remotePHPUpgrade(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	targetSpec, _ := cmd.Flags().GetString("target")
+	providerName, _ := cmd.Flags().GetString("provider")
+
+	if len(args) == 0 {
+		var result struct {
+			Results []manager.UpgradeResult `json:"results"`
+		}
+		path := withQuery("/api/v1/php/upgrade", map[string]string{
+			"target":  targetSpec,
+			"no_wait": noWaitQuery(),
+		})
+		err := client.Do("POST", path, nil, &result)
+		if apiErr, ok := err.(*apiclient.Error); ok {
+			json.Unmarshal(apiErr.Details, &result)
+		}
+		printUpgradeResults(result.Results)
+		if err != nil {
+			fatalf("Error upgrading PHP: %v", err)
+		}
+		if len(result.Results) == 0 {
+			fmt.Println("No installed version can be upgraded in place")
+		}
+		return
+	}
+
+	path := withQuery(phpPath(args[0], "/upgrade"), map[string]string{
+		"provider": providerName,
+		"target":   targetSpec,
+		"no_wait":  noWaitQuery(),
+	})
+	if err := client.Do("POST", path, nil, nil); err != nil {
+		fatalf("Error upgrading PHP: %v", err)
+	}
+	fmt.Printf("PHP %s upgraded\n", args[0])
+}
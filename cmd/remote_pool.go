@@ -0,0 +1,520 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/manager"
+
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// poolPath returns the API path of a pool, followed by rest
+func poolPath(username, rest string) string {
+	return "/api/v1/pools/" + url.PathEscape(username) + rest
+}
+
+// noWaitQuery is "true" with --no-wait, else omitted by withQuery
+func noWaitQuery() string {
+	if noWait {
+		return "true"
+	}
+	return ""
+}
+
+// remotePatchPool merges settings into a pool on the server, whatever its
+// revision like the local commands, and returns the shared memory warnings
+// it answers with
+func remotePatchPool(client *apiclient.Client, username string, settings map[string]interface{}, failure string) []string {
+	var result struct {
+		Warnings []string `json:"warnings"`
+	}
+	path := withQuery(poolPath(username, "/config"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.WithHeader("If-Match", "*").Do("PATCH", path, settings, &result); err != nil {
+		fatalf("%s: %v", failure, err)
+	}
+	return result.Warnings
+}
+
+func remotePoolStatus(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var status manager.PoolStatus
+	if err := client.Do("GET", poolPath(args[0], "/status"), nil, &status); err != nil {
+		fatalf("Error getting pool status: %v", err)
+	}
+	printPoolStatus(&status)
+}
+
+func remotePoolAPCuSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	parsed, err := parseSettingArgs(args[1:])
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	settings := make(map[string]interface{}, len(parsed))
+	for key, value := range parsed {
+		settings["apcu_"+key] = value
+	}
+	warnings := remotePatchPool(client, args[0], settings, "Error updating pool")
+	fmt.Printf("APCu settings updated for user: %s\n", args[0])
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+}
+
+func remotePoolOpcacheSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	parsed, err := parseSettingArgs(args[1:])
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	settings := make(map[string]interface{}, len(parsed))
+	for key, value := range parsed {
+		settings["opcache_"+key] = value
+	}
+	remotePatchPool(client, args[0], settings, "Error updating pool")
+	fmt.Printf("OPcache settings updated for user: %s\n", args[0])
+}
+
+func remotePoolOpcacheReset(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	if err := client.Do("POST", poolPath(args[0], "/opcache/reset"), nil, nil); err != nil {
+		fatalf("Error resetting OPcache: %v", err)
+	}
+	fmt.Printf("OPcache reset for user: %s\n", args[0])
+}
+
+func remotePoolSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	all, _ := cmd.Flags().GetBool("all")
+	username, settings := parsePoolSetArgs(args)
+	checkPoolSetTarget(username, all)
+
+	if username != "" {
+		remotePatchPool(client, username, settings, "Error updating pool")
+		fmt.Printf("Settings updated for user: %s\n", username)
+		return
+	}
+
+	body := map[string]interface{}{
+		"selector": poolSetSelector(cmd),
+		"settings": settings,
+	}
+	var result struct {
+		Results []manager.BatchResult `json:"results"`
+	}
+	path := withQuery("/api/v1/pools/config", map[string]string{"no_wait": noWaitQuery()})
+	err := client.Do("PATCH", path, body, &result)
+	if apiErr, ok := err.(*apiclient.Error); ok {
+		result.Results = batchResultDetails(apiErr)
+	}
+	printBatchResults(result.Results)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	fmt.Printf("Updated %d pools\n", len(result.Results))
+}
+
+// batchResultDetails returns the per-pool results the server attaches to
+// a failed batch
+func batchResultDetails(err *apiclient.Error) []manager.BatchResult {
+	var details struct {
+		Results []manager.BatchResult `json:"results"`
+	}
+	json.Unmarshal(err.Details, &details)
+	return details.Results
+}
+
+func remotePoolLabel(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	set, remove := parseLabelChanges(args[1:])
+	var labels map[string]string
+	var err error
+	if len(args) == 1 {
+		err = client.Do("GET", poolPath(args[0], "/labels"), nil, &labels)
+	} else {
+		patch := make(map[string]*string, len(set)+len(remove))
+		for key, value := range set {
+			value := value
+			patch[key] = &value
+		}
+		for _, key := range remove {
+			patch[key] = nil
+		}
+		err = client.Do("PATCH", poolPath(args[0], "/labels"), patch, &labels)
+	}
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	printLabels(labels)
+}
+
+func remotePoolHistory(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var result struct {
+		Revisions []manager.ConfigRevision `json:"revisions"`
+	}
+	if err := client.Do("GET", poolPath(args[0], "/history"), nil, &result); err != nil {
+		fatalf("Error getting pool history: %v", err)
+	}
+	printHistory(cmd, result.Revisions)
+}
+
+func remotePoolDiff(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	revision := parseRevisionArg(args[1])
+	var result struct {
+		Diff string `json:"diff"`
+	}
+	path := poolPath(args[0], "/history/"+strconv.FormatInt(revision, 10)+"/diff")
+	if err := client.Do("GET", path, nil, &result); err != nil {
+		fatalf("Error comparing pool config: %v", err)
+	}
+	printDiff(args[0], revision, result.Diff)
+}
+
+func remotePoolRollback(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	revision := parseRevisionArg(args[1])
+	var result struct {
+		Revision int64 `json:"revision"`
+	}
+	path := withQuery(poolPath(args[0], "/history/"+strconv.FormatInt(revision, 10)+"/rollback"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("POST", path, nil, &result); err != nil {
+		fatalf("Error rolling back pool config: %v", err)
+	}
+	fmt.Printf("Pool for user %s rolled back to revision %d (now revision %d)\n", args[0], revision, result.Revision)
+}
+
+func remotePoolEnvSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	remotePatchPool(client, args[0], envSetPatch(cmd, args[1:]), "Error updating pool environment")
+	fmt.Printf("Environment updated for user: %s\n", args[0])
+}
+
+func remotePoolEnvUnset(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	remotePatchPool(client, args[0], envUnsetPatch(args[1:]), "Error updating pool environment")
+	fmt.Printf("Environment updated for user: %s\n", args[0])
+}
+
+func remotePoolEnvList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var cfg struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+	if err := client.Do("GET", poolPath(args[0], "/config"), nil, &cfg); err != nil {
+		fatalf("Error getting pool config: %v", err)
+	}
+	printEnv(cmd, args[0], cfg.Settings)
+}
+
+func remotePoolCronList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var crons []manager.Cron
+	if err := client.Do("GET", poolPath(args[0], "/crons"), nil, &crons); err != nil {
+		fatalf("Error listing cron jobs: %v", err)
+	}
+	printCrons(cmd, args[0], crons)
+}
+
+func remotePoolCronAdd(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	job := manager.CronJob{Schedule: args[1], Command: args[2]}
+	if err := job.Validate(); err != nil {
+		usagef("Error: %v", err)
+	}
+	var c manager.Cron
+	path := withQuery(poolPath(args[0], "/crons"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("POST", path, job, &c); err != nil {
+		fatalf("Error adding cron job: %v", err)
+	}
+	fmt.Printf("Cron job %d added for user: %s\n  %s\n", c.ID, args[0], c.Line)
+}
+
+func remotePoolCronRemove(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	id := parseCronID(args[1])
+	path := withQuery(poolPath(args[0], "/crons/"+strconv.FormatInt(id, 10)), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("DELETE", path, nil, nil); err != nil {
+		fatalf("Error removing cron job: %v", err)
+	}
+	fmt.Printf("Cron job %d removed for user: %s\n", id, args[0])
+}
+
+func remotePoolDomainList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var domains []manager.Domain
+	if err := client.Do("GET", poolPath(args[0], "/domains"), nil, &domains); err != nil {
+		fatalf("Error listing domains: %v", err)
+	}
+	printDomains(cmd, args[0], domains)
+}
+
+func remotePoolDomainAdd(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	spec := domainAddSpec(cmd, args)
+	var domain manager.Domain
+	path := withQuery(poolPath(args[0], "/domains"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("POST", path, spec, &domain); err != nil {
+		fatalf("Error adding domain: %v", err)
+	}
+	printDomainAdded(args[0], &domain)
+}
+
+func remotePoolDomainSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	update, changes := domainSetUpdate(cmd, args)
+	domainPath := poolPath(args[0], "/domains/"+url.PathEscape(args[1]))
+	// A map rather than DomainUpdate, whose php_values is omitted when
+	// empty, so that removing the last value is sent
+	body := map[string]interface{}{}
+	if update.DocumentRoot != "" {
+		body["document_root"] = update.DocumentRoot
+	}
+	if len(changes) > 0 {
+		var current manager.Domain
+		if err := client.Do("GET", domainPath, nil, &current); err != nil {
+			fatalf("Error getting domain: %v", err)
+		}
+		body["php_values"] = mergePHPValues(current.PHPValues, changes)
+	}
+	var domain manager.Domain
+	path := withQuery(domainPath, map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("PUT", path, body, &domain); err != nil {
+		fatalf("Error updating domain: %v", err)
+	}
+	printDomainUpdated(args[0], &domain)
+}
+
+func remotePoolDomainRemove(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	path := withQuery(poolPath(args[0], "/domains/"+url.PathEscape(args[1])), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("DELETE", path, nil, nil); err != nil {
+		fatalf("Error removing domain: %v", err)
+	}
+	fmt.Printf("Domain %s removed for user: %s\n", args[1], args[0])
+}
+
+func remotePoolWorkerList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var workers []manager.Worker
+	if err := client.Do("GET", poolPath(args[0], "/workers"), nil, &workers); err != nil {
+		fatalf("Error listing workers: %v", err)
+	}
+	printWorkers(cmd, args[0], workers)
+}
+
+func remotePoolWorkerAdd(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	spec := workerAddSpec(cmd, args)
+	var worker manager.Worker
+	path := withQuery(poolPath(args[0], "/workers"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("POST", path, spec, &worker); err != nil {
+		fatalf("Error adding worker: %v", err)
+	}
+	fmt.Printf("Worker %s added for user: %s (%s, %s)\n", worker.Name, args[0], worker.Service, worker.Status)
+}
+
+func remotePoolWorkerDelete(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	path := withQuery(poolPath(args[0], "/workers/"+url.PathEscape(args[1])), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("DELETE", path, nil, nil); err != nil {
+		fatalf("Error deleting worker: %v", err)
+	}
+	fmt.Printf("Worker %s deleted for user: %s\n", args[1], args[0])
+}
+
+// remoteWorkerControl returns the handler of pool worker start, stop or
+// restart
+func remoteWorkerControl(action string) remoteCommand {
+	return func(cmd *cobra.Command, args []string, client *apiclient.Client) {
+		var worker manager.Worker
+		if err := client.Do("POST", poolPath(args[0], "/workers/"+url.PathEscape(args[1])+"/"+action), nil, &worker); err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Printf("Worker %s of %s is %s\n", worker.Name, args[0], worker.Status)
+	}
+}
+
+func remotePoolWorkerLogs(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	lines := linesFlag(cmd)
+	var log manager.WorkerLog
+	path := withQuery(poolPath(args[0], "/workers/"+url.PathEscape(args[1])+"/logs"), map[string]string{"lines": strconv.Itoa(lines)})
+	if err := client.Do("GET", path, nil, &log); err != nil {
+		fatalf("Error reading worker log: %v", err)
+	}
+	for _, line := range log.Lines {
+		fmt.Println(line)
+	}
+}
+
+func remotePoolRebuild(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var build manager.ImageBuild
+	path := withQuery(poolPath(args[0], "/rebuild"), map[string]string{"no_wait": noWaitQuery()})
+	// Building an image can take minutes
+	if err := client.WithTimeout(30*time.Minute).Do("POST", path, nil, &build); err != nil {
+		fatalf("Error rebuilding pool: %v", err)
+	}
+	printImageBuild(cmd, args[0], &build)
+}
+
+func remotePoolTune(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var rec manager.TuneRecommendation
+	var err error
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		err = client.Do("GET", poolPath(args[0], "/tune"), nil, &rec)
+	} else {
+		err = client.Do("POST", withQuery(poolPath(args[0], "/tune"), map[string]string{"no_wait": noWaitQuery()}), nil, &rec)
+	}
+	if err != nil {
+		fatalf("Error tuning pool: %v", err)
+	}
+	printTuning(cmd, &rec)
+}
+
+func remotePoolTest(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var body struct {
+		Script string `json:"script,omitempty"`
+	}
+	body.Script, _ = cmd.Flags().GetString("script")
+	var result manager.PoolTestResult
+	if err := client.Do("POST", poolPath(args[0], "/test"), body, &result); err != nil {
+		fatalf("Error testing pool: %v", err)
+	}
+	printTestResult(cmd, args[0], &result)
+}
+
+func remotePoolInactive(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	days, _ := cmd.Flags().GetInt("days")
+	if days < 0 {
+		usagef("Error: --days must be at least 1")
+	}
+	query := map[string]string{}
+	if days > 0 {
+		query["days"] = strconv.Itoa(days)
+	}
+	var report manager.InactivityReport
+	if err := client.Do("GET", withQuery("/api/v1/reports/inactivity", query), nil, &report); err != nil {
+		fatalf("Error checking pool activity: %v", err)
+	}
+	printInactivity(cmd, &report)
+}
+
+func remotePoolSuspend(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var body struct {
+		Reason string `json:"reason,omitempty"`
+	}
+	body.Reason, _ = cmd.Flags().GetString("reason")
+	path := withQuery(poolPath(args[0], "/suspend"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("POST", path, body, nil); err != nil {
+		fatalf("Error suspending pool: %v", err)
+	}
+	fmt.Printf("Pool suspended for user: %s\n", args[0])
+}
+
+func remotePoolUnsuspend(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	path := withQuery(poolPath(args[0], "/unsuspend"), map[string]string{"no_wait": noWaitQuery()})
+	if err := client.Do("POST", path, nil, nil); err != nil {
+		fatalf("Error unsuspending pool: %v", err)
+	}
+	fmt.Printf("Pool unsuspended for user: %s\n", args[0])
+}
+
+func remotePoolExportBundle(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	username := args[0]
+	output := bundleOutput(cmd, args)
+
+	resp, err := client.Raw("GET", poolPath(username, "/bundle"), nil)
+	if err != nil {
+		fatalf("Error exporting bundle: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if output == "-" {
+		if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+			fatalf("Error exporting bundle: %v", err)
+		}
+		return
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		fatalf("Error creating bundle file: %v", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		os.Remove(output)
+		fatalf("Error exporting bundle: %v", err)
+	}
+	fmt.Printf("Pool for user %s exported to %s\n", username, output)
+}
+
+func remotePoolImportBundle(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	phpVersion, _ := cmd.Flags().GetString("php-version")
+	bundle, err := os.ReadFile(args[0])
+	if err != nil {
+		fatalf("Error opening bundle: %v", err)
+	}
+
+	var result struct {
+		Username     string   `json:"username"`
+		PHPVersion   string   `json:"php_version"`
+		Provider     string   `json:"provider"`
+		SitesCreated []string `json:"sites_created"`
+		SitesSkipped []string `json:"sites_skipped"`
+	}
+	path := withQuery("/api/v1/pools/import", map[string]string{
+		"php_version": phpVersion,
+		"no_wait":     noWaitQuery(),
+	})
+	if err := client.Upload(path, "application/gzip", bytes.NewReader(bundle), &result); err != nil {
+		fatalf("Error importing bundle: %v", err)
+	}
+	printBundleImport(result.Username, result.PHPVersion, result.Provider, &manager.RestoreReport{
+		SitesCreated: result.SitesCreated,
+		SitesSkipped: result.SitesSkipped,
+	})
+}
+
+func remotePoolCreateBulk(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	ops := readBulkFile(cmd)
+	var result struct {
+		Results []manager.BatchResult `json:"results"`
+	}
+	path := withQuery("/api/v1/pools/batch", map[string]string{"no_wait": noWaitQuery()})
+	err := client.Do("POST", path, ops, &result)
+	if apiErr, ok := err.(*apiclient.Error); ok {
+		result.Results = batchResultDetails(apiErr)
+	}
+	printBatchResults(result.Results)
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	fmt.Printf("Created %d pools\n", len(result.Results))
+}
+
+func remotePoolTop(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	interval, _ := cmd.Flags().GetDuration("interval")
+	sortBy, _ := cmd.Flags().GetString("sort")
+	limit, _ := cmd.Flags().GetInt("limit")
+	once, _ := cmd.Flags().GetBool("once")
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if interval < 100*time.Millisecond || interval > 10*time.Second {
+		usagef("Error: --interval must be between 100ms and 10s with --server")
+	}
+
+	live := !once && !asJSON && isatty.IsTerminal(os.Stdout.Fd())
+	query := map[string]string{"interval": interval.String(), "sort": sortBy}
+	if limit > 0 {
+		query["limit"] = strconv.Itoa(limit)
+	} else if live {
+		// Fill the terminal below the header lines
+		limit = terminalHeight() - 4
+	}
+	path := withQuery("/api/v1/stats/top", query)
+	for {
+		// The server measures CPU usage over the interval before answering
+		var report manager.TopReport
+		if err := client.Do("GET", path, nil, &report); err != nil {
+			fatalf("Error reading pool usage: %v", err)
+		}
+		if !live {
+			if asJSON {
+				encoded, _ := json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(encoded))
+				return
+			}
+			printTop(&report, limit)
+			return
+		}
+		fmt.Print("\033[H\033[2J")
+		printTop(&report, limit)
+	}
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/apitypes"
+	"lightweight-php/billing"
+	"lightweight-php/manager"
+	"lightweight-php/version"
+
+	"github.com/spf13/cobra"
+)
+
+func remoteDoctor(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	username := ""
+	if len(args) == 1 {
+		username = args[0]
+	}
+	var report manager.Diagnostics
+	if err := client.Do("GET", withQuery("/api/v1/diagnostics", map[string]string{"username": username}), nil, &report); err != nil {
+		fatalf("Error running diagnostics: %v", err)
+	}
+	printDiagnostics(cmd, &report)
+}
+
+func remoteReconcile(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var body struct {
+		Heal []string `json:"heal"`
+	}
+	body.Heal = reconcileHeal(cmd)
+	var report manager.ReconcileReport
+	if err := client.Do("POST", "/api/v1/reconcile", body, &report); err != nil {
+		fatalf("Error reconciling: %v", err)
+	}
+	printReconcileReport(cmd, &report)
+}
+
+func remoteBillingUsage(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	period := usagePeriod(cmd)
+	var report billing.Report
+	if err := client.Do("GET", withQuery("/api/v1/billing/usage", map[string]string{"period": period}), nil, &report); err != nil {
+		fatalf("Error reading usage: %v", err)
+	}
+	printUsage(cmd, &report)
+}
+
+func remoteBillingExport(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	if _, _, err := billing.ParsePeriod(args[0]); err != nil {
+		usagef("Error: %v", err)
+	}
+	body := map[string]string{"period": args[0]}
+	var result struct {
+		Exporter string `json:"exporter"`
+	}
+	if err := client.Do("POST", "/api/v1/billing/usage/export", body, &result); err != nil {
+		fatalf("Error exporting usage: %v", err)
+	}
+	fmt.Printf("Usage of %s exported with %s\n", args[0], result.Exporter)
+}
+
+func remoteAudit(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	filter := auditFilter(cmd)
+	query := map[string]string{
+		"request_id": filter.RequestID,
+		"target":     filter.Target,
+		"actor":      filter.Actor,
+	}
+	if filter.Limit > 0 {
+		query["limit"] = strconv.Itoa(filter.Limit)
+	}
+	var entries []manager.AuditEntry
+	if err := client.Do("GET", withQuery("/api/v1/audit", query), nil, &entries); err != nil {
+		fatalf("Error: %v", err)
+	}
+	printAudit(cmd, entries)
+}
+
+func remoteProvidersCheck(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	targetSpec, _ := cmd.Flags().GetString("target")
+	names := checkedProviders(args)
+	reports := make([]*manager.ProviderHealth, 0, len(names))
+	for _, name := range names {
+		var report manager.ProviderHealth
+		path := withQuery("/api/v1/providers/"+url.PathEscape(name)+"/health", map[string]string{"target": targetSpec})
+		if err := client.Do("GET", path, nil, &report); err != nil {
+			fatalf("Error checking provider %s: %v", name, err)
+		}
+		reports = append(reports, &report)
+	}
+	printProviderHealth(cmd, reports)
+}
+
+func remoteInventory(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	ansibleHost := inventoryOptions(cmd)
+	var pools []manager.Pool
+	if err := client.Do("GET", "/api/v1/pools", nil, &pools); err != nil {
+		fatalf("Error building inventory: %v", err)
+	}
+	var installed []string
+	var list apitypes.PHPVersionList
+	if err := client.Do("GET", "/api/v1/php/versions", nil, &list); err == nil {
+		// The server lists each version once per provider
+		seen := map[string]bool{}
+		installed = []string{}
+		for _, v := range list.Versions {
+			if !seen[v.Version] {
+				seen[v.Version] = true
+				installed = append(installed, v.Version)
+			}
+		}
+		version.Sort(installed)
+	}
+	settings := func(pool manager.Pool) map[string]interface{} {
+		var cfg struct {
+			Settings map[string]interface{} `json:"settings"`
+		}
+		if err := client.Do("GET", poolPath(pool.User, "/config"), nil, &cfg); err != nil {
+			return nil
+		}
+		return cfg.Settings
+	}
+	printInventory(cmd, buildAnsibleInventory(pools, installed, settings, ansibleHost))
+}
+
+func remoteStateShow(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var snapshot manager.StateSnapshot
+	if err := client.Do("GET", "/api/v1/state", nil, &snapshot); err != nil {
+		fatalf("Error reading state: %v", err)
+	}
+	encoded, _ := json.MarshalIndent(&snapshot, "", "  ")
+	fmt.Println(string(encoded))
+}
+
+func remoteStateDiff(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	against := stateDiffAgainst(cmd)
+	var local manager.StateSnapshot
+	if err := client.Do("GET", "/api/v1/state", nil, &local); err != nil {
+		exitf(2, "Error reading local state: %v", err)
+	}
+	printStateDiff(cmd, against, &local)
+}
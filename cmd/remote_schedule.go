@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/apitypes"
+	"lightweight-php/db"
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+// scheduledChangePath returns the API path of a scheduled change
+func scheduledChangePath(id int64) string {
+	return "/api/v1/scheduled-changes/" + strconv.FormatInt(id, 10)
+}
+
+// jobPath returns the API path of a recurring task, followed by rest
+func jobPath(name, rest string) string {
+	return "/api/v1/schedules/" + url.PathEscape(name) + rest
+}
+
+func remoteScheduleAdd(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	settings, err := parseScheduleSettings(args[1:])
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	body := apitypes.ScheduledChangeRequest{Username: args[0], Settings: settings}
+	body.PHPVersion, _ = cmd.Flags().GetString("php-version")
+	if body.RunAt, err = scheduleTime(cmd); err != nil {
+		usagef("Error: %v", err)
+	}
+	var change manager.ScheduledChange
+	if err := client.Do("POST", "/api/v1/scheduled-changes", body, &change); err != nil {
+		fatalf("Error scheduling change: %v", err)
+	}
+	printChangeScheduled(&change)
+}
+
+func remoteScheduleList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	username, _ := cmd.Flags().GetString("user")
+	status, _ := cmd.Flags().GetString("status")
+	var changes []manager.ScheduledChange
+	path := withQuery("/api/v1/scheduled-changes", map[string]string{"username": username, "status": status})
+	if err := client.Do("GET", path, nil, &changes); err != nil {
+		fatalf("Error listing scheduled changes: %v", err)
+	}
+	printScheduledChanges(cmd, changes)
+}
+
+func remoteScheduleUpdate(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	id := scheduleID(args[0])
+	var change manager.ScheduledChange
+	if err := client.Do("PATCH", scheduledChangePath(id), scheduleUpdate(cmd, args), &change); err != nil {
+		fatalf("Error updating scheduled change: %v", err)
+	}
+	printChangeUpdated(&change)
+}
+
+func remoteScheduleCancel(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	id := scheduleID(args[0])
+	if err := client.Do("DELETE", scheduledChangePath(id), nil, nil); err != nil {
+		fatalf("Error cancelling scheduled change: %v", err)
+	}
+	fmt.Printf("Cancelled change %d\n", id)
+}
+
+func remoteJobsList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var schedules []manager.Schedule
+	if err := client.Do("GET", "/api/v1/schedules", nil, &schedules); err != nil {
+		fatalf("Error listing jobs: %v", err)
+	}
+	printJobs(cmd, schedules)
+}
+
+func remoteJobsAdd(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	body := apitypes.ScheduleRequest{Name: args[0], Task: args[1], Cron: args[2]}
+	var schedule manager.Schedule
+	if err := client.Do("POST", "/api/v1/schedules", body, &schedule); err != nil {
+		fatalf("Error adding job: %v", err)
+	}
+	printJobAdded(&schedule)
+}
+
+func remoteJobsDelete(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	if err := client.Do("DELETE", jobPath(args[0], ""), nil, nil); err != nil {
+		fatalf("Error deleting job: %v", err)
+	}
+	fmt.Printf("Deleted job %s\n", args[0])
+}
+
+func remoteJobsRuns(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	limit, _ := cmd.Flags().GetInt("limit")
+	var runs []manager.ScheduleRun
+	path := withQuery(jobPath(args[0], "/runs"), map[string]string{"limit": strconv.Itoa(limit)})
+	if err := client.Do("GET", path, nil, &runs); err != nil {
+		fatalf("Error listing runs: %v", err)
+	}
+	printJobRuns(cmd, runs)
+}
+
+func remoteJobsRun(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var run manager.ScheduleRun
+	if err := client.Do("POST", jobPath(args[0], "/run"), nil, &run); err != nil {
+		fatalf("Error running job: %v", err)
+	}
+	// The server runs the task in the background; follow it in the run
+	// history until it finishes
+	path := withQuery(jobPath(args[0], "/runs"), map[string]string{"limit": "20"})
+	for run.Status == db.RunRunning {
+		time.Sleep(time.Second)
+		var runs []manager.ScheduleRun
+		if err := client.Do("GET", path, nil, &runs); err != nil {
+			fatalf("Error following job: %v", err)
+		}
+		for _, r := range runs {
+			if r.ID == run.ID {
+				run = r
+			}
+		}
+	}
+	printJobRun(&run)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+// sitePath returns the API path of a site, followed by rest
+func sitePath(domain, rest string) string {
+	return "/api/v1/sites/" + url.PathEscape(domain) + rest
+}
+
+func remoteSiteCreate(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	body := apitypes.CreateSiteRequest{Domain: args[0]}
+	body.Username, _ = cmd.Flags().GetString("user")
+	body.DocumentRoot, _ = cmd.Flags().GetString("docroot")
+	body.PHPVersion, _ = cmd.Flags().GetString("php-version")
+	body.CheckDNS, _ = cmd.Flags().GetBool("check-dns")
+	body.UpdateDNS, _ = cmd.Flags().GetBool("update-dns")
+	body.Certificate, _ = cmd.Flags().GetBool("certificate")
+
+	var site manager.Site
+	if err := client.Do("POST", "/api/v1/sites", body, &site); err != nil {
+		fatalf("Error creating site: %v", err)
+	}
+	printSiteCreated(&site)
+	// The server reports DNS problems with the site instead of failing
+	if site.DNS != nil {
+		printSiteDNS(site.DNS)
+		printDNSChanges(site.DNS)
+	}
+	if body.Certificate {
+		cert := site.Certificate
+		if cert == nil || cert.LastError != "" || cert.NotAfter == nil {
+			reason := "no certificate was issued"
+			if cert != nil && cert.LastError != "" {
+				reason = cert.LastError
+			}
+			fatalf("Site created, but issuing its certificate failed: %s\nRetry with: lightweight-php site cert issue %s", reason, site.Domain)
+		}
+		fmt.Printf("Certificate for %s issued, valid until %s\n", cert.Domain, cert.NotAfter.Local().Format("2006-01-02"))
+	}
+}
+
+func remoteSiteBind(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var body struct {
+		Path       string `json:"path"`
+		PHPVersion string `json:"php_version"`
+	}
+	body.Path, _ = cmd.Flags().GetString("path")
+	body.PHPVersion, _ = cmd.Flags().GetString("php-version")
+	if err := client.Do("PUT", sitePath(args[0], "/bindings"), body, nil); err != nil {
+		fatalf("Error binding path: %v", err)
+	}
+	fmt.Printf("Site %s: %s now served by PHP %s\n", args[0], body.Path, body.PHPVersion)
+}
+
+func remoteSiteUnbind(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	path, _ := cmd.Flags().GetString("path")
+	if err := client.Do("DELETE", withQuery(sitePath(args[0], "/bindings"), map[string]string{"path": path}), nil, nil); err != nil {
+		fatalf("Error removing binding: %v", err)
+	}
+	fmt.Printf("Site %s: binding for %s removed\n", args[0], path)
+}
+
+func remoteSiteList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var sites []manager.Site
+	if err := client.Do("GET", "/api/v1/sites", nil, &sites); err != nil {
+		fatalf("Error listing sites: %v", err)
+	}
+	printSites(sites)
+}
+
+func remoteSiteShow(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var result struct {
+		Snippet string `json:"snippet"`
+	}
+	if err := client.Do("GET", sitePath(args[0], "/snippet"), nil, &result); err != nil {
+		fatalf("Error rendering site: %v", err)
+	}
+	fmt.Println(result.Snippet)
+}
+
+func remoteSiteDelete(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	if err := client.Do("DELETE", sitePath(args[0], ""), nil, nil); err != nil {
+		fatalf("Error deleting site: %v", err)
+	}
+	fmt.Printf("Site deleted: %s\n", args[0])
+}
+
+func remoteSiteDNS(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	method := "GET"
+	if update, _ := cmd.Flags().GetBool("update"); update {
+		method = "PUT"
+	}
+	var status manager.SiteDNS
+	if err := client.Do(method, sitePath(args[0], "/dns"), nil, &status); err != nil {
+		fatalf("Error: %v", err)
+	}
+	printSiteDNSResult(cmd, args[0], &status, nil)
+}
+
+func remoteSiteCertIssue(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var cert manager.Certificate
+	if err := client.Do("POST", sitePath(args[0], "/certificate"), nil, &cert); err != nil {
+		fatalf("Error issuing certificate: %v", err)
+	}
+	printCertificateIssued(&cert)
+}
+
+func remoteSiteCertList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var result struct {
+		Certificates []manager.Certificate `json:"certificates"`
+	}
+	if err := client.Do("GET", "/api/v1/certificates", nil, &result); err != nil {
+		fatalf("Error listing certificates: %v", err)
+	}
+	printCertificates(cmd, result.Certificates)
+}
+
+func remoteSiteCertRenew(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var result struct {
+		Certificates []manager.Certificate `json:"certificates"`
+	}
+	if err := client.Do("POST", "/api/v1/certificates/renew", nil, &result); err != nil {
+		fatalf("Error renewing certificates: %v", err)
+	}
+	printRenewals(result.Certificates)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/apitypes"
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+// tenantPath returns the API path of a tenant
+func tenantPath(name string) string {
+	return "/api/v1/tenants/" + url.PathEscape(name)
+}
+
+func remoteDefaultsShow(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var defaults manager.PoolDefaults
+	if err := client.Do("GET", "/api/v1/defaults", nil, &defaults); err != nil {
+		fatalf("Error: %v", err)
+	}
+	printDefaults(cmd, &defaults)
+}
+
+func remoteDefaultsSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	patch, err := parseSettingArgs(args)
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	var defaults manager.PoolDefaults
+	if err := client.Do("GET", "/api/v1/defaults", nil, &defaults); err != nil {
+		fatalf("Error: %v", err)
+	}
+	body := map[string]interface{}{"settings": patchDefaults(defaults.Settings, patch)}
+	if err := client.Do("PUT", "/api/v1/defaults", body, nil); err != nil {
+		fatalf("Error updating defaults: %v", err)
+	}
+	fmt.Println("Default pool settings updated")
+}
+
+func remoteTenantList(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var tenants []manager.Tenant
+	if err := client.Do("GET", "/api/v1/tenants", nil, &tenants); err != nil {
+		fatalf("Error listing tenants: %v", err)
+	}
+	printTenants(cmd, tenants)
+}
+
+func remoteTenantShow(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	var t manager.Tenant
+	if err := client.Do("GET", tenantPath(args[0]), nil, &t); err != nil {
+		fatalf("Error: %v", err)
+	}
+	printTenant(cmd, &t)
+}
+
+func remoteTenantCreate(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	settings, err := parseSettingArgs(args[1:])
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	description, _ := cmd.Flags().GetString("description")
+	body := apitypes.TenantRequest{Name: args[0], Description: description, Settings: settings}
+	if err := client.Do("POST", "/api/v1/tenants", body, nil); err != nil {
+		fatalf("Error creating tenant: %v", err)
+	}
+	fmt.Printf("Tenant created: %s\n", args[0])
+}
+
+func remoteTenantSet(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	patch, err := parseSettingArgs(args[1:])
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	var t manager.Tenant
+	if err := client.Do("GET", tenantPath(args[0]), nil, &t); err != nil {
+		fatalf("Error: %v", err)
+	}
+	patchTenant(cmd, &t, patch)
+	body := apitypes.TenantRequest{Name: t.Name, Description: t.Description, Settings: t.Settings}
+	if err := client.Do("PUT", tenantPath(args[0]), body, nil); err != nil {
+		fatalf("Error updating tenant: %v", err)
+	}
+	fmt.Printf("Tenant updated: %s\n", t.Name)
+}
+
+func remoteTenantDelete(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	if err := client.Do("DELETE", tenantPath(args[0]), nil, nil); err != nil {
+		fatalf("Error deleting tenant: %v", err)
+	}
+	fmt.Printf("Tenant deleted: %s\n", args[0])
+}
+
+func remoteTenantAssign(cmd *cobra.Command, args []string, client *apiclient.Client) {
+	tenant, pools, versions := tenantAssignment(cmd, args)
+	body := map[string]string{"tenant": tenant}
+	// Versions first, so pools can move onto a version just reserved
+	for _, version := range versions {
+		if err := client.Do("PUT", phpPath(version, "/tenant"), body, nil); err != nil {
+			fatalf("Error assigning PHP %s: %v", version, err)
+		}
+	}
+	for _, username := range pools {
+		if err := client.Do("PUT", poolPath(username, "/tenant"), body, nil); err != nil {
+			fatalf("Error assigning pool %s: %v", username, err)
+		}
+	}
+	printTenantAssigned(tenant, pools, versions)
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// runRemote runs a command line with --server pointing at a test server
+// that answers with replies, keyed by method and path ({} otherwise), and
+// returns the requests it received as "METHOD URI BODY"
+func runRemote(t *testing.T, replies map[string]string, line ...string) []string {
+	t.Helper()
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		requests = append(requests, strings.TrimSpace(req.Method+" "+req.URL.RequestURI()+" "+strings.TrimSpace(string(body))))
+		reply, ok := replies[req.Method+" "+req.URL.Path]
+		if !ok {
+			reply = "{}"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, reply)
+	}))
+	defer server.Close()
+
+	cmd, args := parseCommandLine(t, line...)
+	run, runE := cmd.Run, cmd.RunE
+	defer func() { cmd.Run, cmd.RunE = run, runE }()
+	defer func(url string) { serverURL = url }(serverURL)
+	serverURL = server.URL
+	if err := forwardToServer(cmd); err != nil {
+		t.Fatalf("%v: %v", line, err)
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	defer func(stdout *os.File) { os.Stdout = stdout }(os.Stdout)
+	os.Stdout = devNull
+	// state diff compares against an instance of its own
+	if cmd.Flags().Lookup("against") != nil {
+		cmd.Flags().Set("against", server.URL)
+	}
+	cmd.Run(cmd, args)
+	return requests
+}
+
+func TestRemoteCommandsForward(t *testing.T) {
+	tests := []struct {
+		line    []string
+		replies map[string]string
+		want    []string
+	}{
+		{
+			line: []string{"site", "create", "example.com", "--user", "bob", "--php-version", "8.3"},
+			want: []string{`POST /api/v1/sites {"domain":"example.com","username":"bob","document_root":"","php_version":"8.3","check_dns":false,"update_dns":false,"certificate":false}`},
+		},
+		{
+			line: []string{"site", "unbind", "example.com", "--path", "/old/"},
+			want: []string{"DELETE /api/v1/sites/example.com/bindings?path=%2Fold%2F"},
+		},
+		{
+			line:    []string{"site", "cert", "renew"},
+			replies: map[string]string{"POST /api/v1/certificates/renew": `{"certificates":[]}`},
+			want:    []string{"POST /api/v1/certificates/renew"},
+		},
+		{
+			line:    []string{"defaults", "set", "memory_limit=256M", "max_execution_time="},
+			replies: map[string]string{"GET /api/v1/defaults": `{"settings":{"max_execution_time":"60","pm":"ondemand"}}`},
+			want: []string{
+				"GET /api/v1/defaults",
+				`PUT /api/v1/defaults {"settings":{"memory_limit":"256M","pm":"ondemand"}}`,
+			},
+		},
+		{
+			line:    []string{"tenant", "set", "acme", "--description", "ACME Corp", "memory_limit=512M"},
+			replies: map[string]string{"GET /api/v1/tenants/acme": `{"name":"acme","pools":1,"settings":{"pm":"static"}}`},
+			want: []string{
+				"GET /api/v1/tenants/acme",
+				`PUT /api/v1/tenants/acme {"name":"acme","description":"ACME Corp","settings":{"memory_limit":"512M","pm":"static"}}`,
+			},
+		},
+		{
+			line: []string{"tenant", "assign", "acme", "--pool", "bob", "--php-version", "8.3"},
+			want: []string{
+				`PUT /api/v1/php/8.3/tenant {"tenant":"acme"}`,
+				`PUT /api/v1/pools/bob/tenant {"tenant":"acme"}`,
+			},
+		},
+		{
+			line: []string{"schedule", "add", "bob", "memory_limit=256M", "--at", "2030-01-02T03:04:05Z"},
+			want: []string{`POST /api/v1/scheduled-changes {"username":"bob","settings":{"memory_limit":"256M"},"php_version":"","run_at":"2030-01-02T03:04:05Z"}`},
+		},
+		{
+			line:    []string{"schedule", "list", "--user", "bob", "--status", "pending"},
+			replies: map[string]string{"GET /api/v1/scheduled-changes": "[]"},
+			want:    []string{"GET /api/v1/scheduled-changes?status=pending&username=bob"},
+		},
+		{
+			line: []string{"schedule", "cancel", "7"},
+			want: []string{"DELETE /api/v1/scheduled-changes/7"},
+		},
+		{
+			line:    []string{"jobs", "add", "nightly", "rebuild", "0 3 * * *"},
+			replies: map[string]string{"POST /api/v1/schedules": `{"name":"nightly","task":"rebuild","next_run":"2030-01-02T03:00:00Z"}`},
+			want:    []string{`POST /api/v1/schedules {"name":"nightly","task":"rebuild","cron":"0 3 * * *"}`},
+		},
+		{
+			line: []string{"jobs", "run", "nightly"},
+			replies: map[string]string{
+				"POST /api/v1/schedules/nightly/run": `{"id":4,"schedule":"nightly","status":"running"}`,
+				"GET /api/v1/schedules/nightly/runs": `[{"id":5,"status":"running"},{"id":4,"schedule":"nightly","status":"success"}]`,
+			},
+			want: []string{
+				"POST /api/v1/schedules/nightly/run",
+				"GET /api/v1/schedules/nightly/runs?limit=20",
+			},
+		},
+		{
+			line:    []string{"doctor", "bob"},
+			replies: map[string]string{"GET /api/v1/diagnostics": `{"ok":true}`},
+			want:    []string{"GET /api/v1/diagnostics?username=bob"},
+		},
+		{
+			line: []string{"reconcile", "--heal", "config"},
+			want: []string{`POST /api/v1/reconcile {"heal":["config"]}`},
+		},
+		{
+			line: []string{"billing", "usage", "--period", "2026-09", "--format", "json"},
+			want: []string{"GET /api/v1/billing/usage?period=2026-09"},
+		},
+		{
+			line: []string{"billing", "export", "2026-09"},
+			want: []string{`POST /api/v1/billing/usage/export {"period":"2026-09"}`},
+		},
+		{
+			line:    []string{"audit", "--target", "bob", "--limit", "5"},
+			replies: map[string]string{"GET /api/v1/audit": "[]"},
+			want:    []string{"GET /api/v1/audit?limit=5&target=bob"},
+		},
+		{
+			line:    []string{"providers", "check", "remi", "--target", "nspawn:web1"},
+			replies: map[string]string{"GET /api/v1/providers/remi/health": `{"provider":"remi","ok":true}`},
+			want:    []string{"GET /api/v1/providers/remi/health?target=nspawn%3Aweb1"},
+		},
+		{
+			line: []string{"inventory"},
+			replies: map[string]string{
+				"GET /api/v1/pools":        `[{"user":"bob","php_version":"8.3"}]`,
+				"GET /api/v1/php/versions": `{"versions":[{"version":"8.3"}]}`,
+			},
+			want: []string{
+				"GET /api/v1/pools",
+				"GET /api/v1/php/versions",
+				"GET /api/v1/pools/bob/config",
+			},
+		},
+		{
+			line: []string{"state", "diff"},
+			want: []string{"GET /api/v1/state", "GET /api/v1/state"},
+		},
+	}
+	for _, tt := range tests {
+		got := runRemote(t, tt.replies, tt.line...)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v sent\n  %s\nwant\n  %s", tt.line, strings.Join(got, "\n  "), strings.Join(tt.want, "\n  "))
+		}
+	}
+}
+
+func TestLocalCommandsRefuseServer(t *testing.T) {
+	defer func(url string) { serverURL = url }(serverURL)
+	serverURL = "http://127.0.0.1:1"
+	for _, line := range [][]string{{"backup", "create", "bob"}, {"account", "erase", "bob"}, {"schedule", "run"}} {
+		cmd, _ := parseCommandLine(t, line...)
+		err := forwardToServer(cmd)
+		if err == nil || !strings.Contains(err.Error(), localCommands[operationName(cmd)]) {
+			t.Errorf("%v: forwardToServer = %v, want the command refused", line, err)
+		}
+	}
+}
+
+// Every command either runs on the server or says why it does not
+func TestRemoteCommandsCoverEveryCommand(t *testing.T) {
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		for _, sub := range cmd.Commands() {
+			walk(sub)
+			operation := operationName(sub)
+			if !sub.Runnable() || operation == "api" || strings.HasPrefix(operation, "completion") {
+				continue
+			}
+			_, remote := remoteCommands[operation]
+			_, local := localCommands[operation]
+			if remote == local {
+				t.Errorf("%s: in remoteCommands %v, in localCommands %v; want exactly one", operation, remote, local)
+			}
+		}
+	}
+	walk(rootCmd)
+	for operation := range localCommands {
+		if _, _, err := rootCmd.Find(strings.Split(operation, ".")); err != nil {
+			t.Errorf("localCommands names %s, which does not exist", operation)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+
 	"github.com/spf13/cobra"
 )
 
@@ -8,14 +12,71 @@ var rootCmd = &cobra.Command{
 	Use:   "lightweight-php",
 	Short: "PHP-FPM pool manager with REST API",
 	Long:  "A CLI tool to manage PHP-FPM pools per user and install PHP versions from Remi repository",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		commandPath = cmd.CommandPath()
+		switch errorFormat {
+		case errorFormatAuto, errorFormatText, errorFormatJSON:
+		default:
+			return fmt.Errorf("invalid --error-format %q: must be auto, text or json", errorFormat)
+		}
+		checkHelperCaller(cmd, args)
+		if err := forwardToServer(cmd); err != nil {
+			return err
+		}
+		if devMode {
+			return enableDevMode()
+		}
+		forwardToHelper(cmd, args)
+		return nil
+	},
 }
 
+// noWait makes locked operations fail immediately instead of waiting
+var noWait bool
+
+// Execute runs the CLI. Errors are reported by the commands themselves
+// (see errors.go); the returned error has already been printed and is only
+// used for the exit status.
 func Execute() error {
-	return rootCmd.Execute()
+	registerCompletions()
+	// Usage errors from cobra (unknown flags, wrong argument counts) are
+	// reported like any other failure, with exitUsage
+	rootCmd.SilenceErrors = true
+	rootCmd.SilenceUsage = true
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil {
+		commandPath = cmd.CommandPath()
+		message := "Error: " + err.Error()
+		if !jsonErrors() {
+			message += fmt.Sprintf("\nRun '%s --help' for usage.", cmd.CommandPath())
+		}
+		exitf(exitUsage, "%s", message)
+	}
+	return nil
 }
 
 func init() {
+	rootCmd.PersistentFlags().BoolVar(&noWait, "no-wait", false, "Fail immediately if another operation holds the lock instead of waiting")
+	rootCmd.PersistentFlags().BoolVar(&devMode, "dev", false, "Development mode: use a sandbox directory and log commands instead of running them (no root or systemd needed)")
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", errorFormatAuto, "How failures are written to stderr: text, json, or auto (json when stderr is not a terminal)")
+	rootCmd.PersistentFlags().StringVar(&devDir, "dev-dir", filepath.Join(os.TempDir(), "lightweight-php-dev"), "Sandbox directory for --dev")
+
 	rootCmd.AddCommand(serverCmd)
 	rootCmd.AddCommand(poolCmd)
 	rootCmd.AddCommand(phpCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(hostCmd)
+	rootCmd.AddCommand(siteCmd)
+	rootCmd.AddCommand(dbCmd)
+	rootCmd.AddCommand(templatesCmd)
+	rootCmd.AddCommand(stateCmd)
+	rootCmd.AddCommand(servicesCmd)
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.AddCommand(accountCmd)
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(inventoryCmd)
+	rootCmd.AddCommand(scheduleCmd)
+	rootCmd.AddCommand(tenantCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(devExecCmd)
 }
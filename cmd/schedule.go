@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Queue pool changes to run at a later time",
+	Long: `Queue a settings change or PHP version switch to run at a later time, for
+example in a maintenance window. The API server applies changes once they
+are due; 'schedule run' applies due changes without the server, e.g. from
+cron. Without --at or --in a change runs in the next maintenance window.`,
+}
+
+var scheduleAddCmd = &cobra.Command{
+	Use:   "add [username] [key=value...]",
+	Short: "Schedule a settings change and/or PHP version switch for a pool",
+	Example: `  lightweight-php schedule add alice memory_limit=512M --at "2026-11-02 03:00"
+  lightweight-php schedule add alice --php-version 8.3 --in 6h`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := parseScheduleSettings(args[1:])
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+		phpVersion, _ := cmd.Flags().GetString("php-version")
+		runAt, err := scheduleTime(cmd)
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+		if runAt == nil {
+			runAt = &time.Time{}
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		change, err := pm.ScheduleChange(args[0], settings, phpVersion, *runAt)
+		if err != nil {
+			fatalf("Error scheduling change: %v", err)
+		}
+		printChangeScheduled(change)
+	},
+}
+
+func printChangeScheduled(change *manager.ScheduledChange) {
+	fmt.Printf("Scheduled change %d for %s at %s\n", change.ID, change.Username, change.RunAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+var scheduleListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List scheduled changes",
+	Run: func(cmd *cobra.Command, args []string) {
+		username, _ := cmd.Flags().GetString("user")
+		status, _ := cmd.Flags().GetString("status")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		changes, err := pm.ListScheduledChanges(username, status)
+		if err != nil {
+			fatalf("Error listing scheduled changes: %v", err)
+		}
+		printScheduledChanges(cmd, changes)
+	},
+}
+
+func printScheduledChanges(cmd *cobra.Command, changes []manager.ScheduledChange) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(changes, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(changes) == 0 {
+		fmt.Println("No scheduled changes")
+		return
+	}
+	for _, c := range changes {
+		fmt.Printf("%-5d %-16s %-10s %s  %s\n", c.ID, c.Username, c.Status, c.RunAt.Local().Format("2006-01-02 15:04"), describeChange(c))
+		if c.Error != "" {
+			fmt.Printf("      error: %s\n", c.Error)
+		}
+	}
+}
+
+var scheduleUpdateCmd = &cobra.Command{
+	Use:   "update [id] [key=value...]",
+	Short: "Modify a pending scheduled change",
+	Long:  "Modify a pending change. Settings given replace the change's settings; --php-version \"\" drops the version switch.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := scheduleID(args[0])
+		update := scheduleUpdate(cmd, args)
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		change, err := pm.UpdateScheduledChange(id, update)
+		if err != nil {
+			fatalf("Error updating scheduled change: %v", err)
+		}
+		printChangeUpdated(change)
+	},
+}
+
+// scheduleUpdate reads the changes schedule update makes from its
+// arguments and flags
+func scheduleUpdate(cmd *cobra.Command, args []string) manager.ChangeUpdate {
+	var update manager.ChangeUpdate
+	if len(args) > 1 {
+		settings, err := parseScheduleSettings(args[1:])
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+		update.Settings = &settings
+	}
+	if cmd.Flags().Changed("php-version") {
+		phpVersion, _ := cmd.Flags().GetString("php-version")
+		update.PHPVersion = &phpVersion
+	}
+	runAt, err := scheduleTime(cmd)
+	if err != nil {
+		usagef("Error: %v", err)
+	}
+	update.RunAt = runAt
+	return update
+}
+
+func printChangeUpdated(change *manager.ScheduledChange) {
+	fmt.Printf("Change %d for %s runs at %s: %s\n", change.ID, change.Username, change.RunAt.Local().Format("2006-01-02 15:04:05"), describeChange(*change))
+}
+
+var scheduleCancelCmd = &cobra.Command{
+	Use:   "cancel [id]",
+	Short: "Cancel a pending scheduled change",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := scheduleID(args[0])
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if _, err := pm.CancelScheduledChange(id); err != nil {
+			fatalf("Error cancelling scheduled change: %v", err)
+		}
+		fmt.Printf("Cancelled change %d\n", id)
+	},
+}
+
+var scheduleRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Apply the scheduled changes that are due",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		ran, err := pm.RunDueChanges(time.Now())
+		failed := 0
+		for _, c := range ran {
+			if c.Error != "" {
+				failed++
+				fmt.Printf("Change %d for %s failed: %s\n", c.ID, c.Username, c.Error)
+			} else {
+				fmt.Printf("Applied change %d for %s\n", c.ID, c.Username)
+			}
+		}
+		if err != nil {
+			fatalf("Error running scheduled changes: %v", err)
+		}
+		if failed > 0 {
+			exitf(exitFailure, "Error: %d of %d scheduled changes failed", failed, len(ran))
+		}
+		if len(ran) == 0 {
+			fmt.Println("No changes are due")
+		}
+	},
+}
+
+// parseScheduleSettings parses key=value settings; an empty value removes
+// the setting when the change runs
+func parseScheduleSettings(args []string) (map[string]interface{}, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	settings, err := parseSettingArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range settings {
+		if value == "" {
+			settings[key] = nil
+		}
+	}
+	return settings, nil
+}
+
+// scheduleTime reads --at or --in; nil means neither was given
+func scheduleTime(cmd *cobra.Command) (*time.Time, error) {
+	at, _ := cmd.Flags().GetString("at")
+	in, _ := cmd.Flags().GetDuration("in")
+	if at != "" && in != 0 {
+		return nil, fmt.Errorf("give --at or --in, not both")
+	}
+	if in != 0 {
+		t := time.Now().Add(in)
+		return &t, nil
+	}
+	if at == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, at); err == nil {
+		return &t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04"} {
+		if t, err := time.ParseInLocation(layout, at, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid --at %q; expected e.g. \"2026-11-02 03:00\" or RFC 3339", at)
+}
+
+func scheduleID(arg string) int64 {
+	id, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		usagef("Error: invalid change id %q", arg)
+	}
+	return id
+}
+
+func describeChange(c manager.ScheduledChange) string {
+	var parts []string
+	if c.PHPVersion != "" {
+		parts = append(parts, "php_version="+c.PHPVersion)
+	}
+	keys := make([]string, 0, len(c.Settings))
+	for key := range c.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if c.Settings[key] == nil {
+			parts = append(parts, key+"=")
+		} else {
+			parts = append(parts, fmt.Sprintf("%s=%v", key, c.Settings[key]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func init() {
+	scheduleCmd.AddCommand(scheduleAddCmd)
+	scheduleCmd.AddCommand(scheduleListCmd)
+	scheduleCmd.AddCommand(scheduleUpdateCmd)
+	scheduleCmd.AddCommand(scheduleCancelCmd)
+	scheduleCmd.AddCommand(scheduleRunCmd)
+	for _, c := range []*cobra.Command{scheduleAddCmd, scheduleUpdateCmd} {
+		c.Flags().String("php-version", "", "Switch the pool to this PHP version")
+		c.Flags().String("at", "", "When to apply the change, e.g. \"2026-11-02 03:00\" (local time) or RFC 3339")
+		c.Flags().Duration("in", 0, "Apply the change after this delay, e.g. 2h")
+	}
+	scheduleListCmd.Flags().String("user", "", "Only changes of this pool user")
+	scheduleListCmd.Flags().String("status", "", "Only changes with this status (pending, running, applied, failed, cancelled)")
+	scheduleListCmd.Flags().Bool("json", false, "Print the changes as JSON")
+}
@@ -1,38 +1,392 @@
 package cmd
 
 import (
-	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"lightweight-php/api"
+	"lightweight-php/config"
+	"lightweight-php/maintenance"
+	"lightweight-php/manager"
+	"lightweight-php/objstore"
+	"lightweight-php/replica"
+	"lightweight-php/tracing"
+	"lightweight-php/unixsock"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	serverHost string
-	serverPort int
+	serverHosts []string
+	serverPort  int
 )
 
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start the REST API server",
-	Long:  "Start the REST API server for managing PHP-FPM pools and PHP installations",
+	Long: "Start the REST API server for managing PHP-FPM pools and PHP installations. " +
+		"SIGHUP reloads the config file without a restart, like POST /api/v1/reload.",
 	Run: func(cmd *cobra.Command, args []string) {
-		router, err := api.NewRouter()
+		cfg := config.Get()
+		hosts := cfg.Server.BindAddresses
+		if cmd.Flags().Changed("host") {
+			hosts = serverHosts
+		}
+		port := cfg.Server.Port
+		if cmd.Flags().Changed("port") {
+			port = serverPort
+		}
+
+		logFormat := cfg.API.LogFormat
+		if cmd.Flags().Changed("log-format") {
+			logFormat, _ = cmd.Flags().GetString("log-format")
+		}
+		switch logFormat {
+		case "json":
+			// log.Printf output goes through the handler as well
+			slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+		case "text":
+		default:
+			log.Fatalf("Invalid --log-format %q: must be text or json", logFormat)
+		}
+
+		specs := cfg.Server.Listen
+		if cmd.Flags().Changed("listen") {
+			specs, _ = cmd.Flags().GetStringSlice("listen")
+		}
+		if len(specs) == 0 {
+			addrs, err := config.ListenAddresses(hosts, port)
+			if err != nil {
+				log.Fatalf("Invalid bind address: %v", err)
+			}
+			specs = addrs
+		} else if cmd.Flags().Changed("host") || cmd.Flags().Changed("port") {
+			log.Fatalf("--listen replaces --host and --port; list TCP addresses as HOST:PORT in --listen")
+		}
+		socketMode := cfg.Server.SocketMode
+		if cmd.Flags().Changed("socket-mode") {
+			socketMode, _ = cmd.Flags().GetString("socket-mode")
+		}
+		mode, err := unixsock.ParseMode(socketMode)
+		if err != nil {
+			log.Fatalf("Invalid --socket-mode: %v", err)
+		}
+		socketGroup := cfg.Server.SocketGroup
+		if cmd.Flags().Changed("socket-group") {
+			socketGroup, _ = cmd.Flags().GetString("socket-group")
+		}
+
+		a, err := getApp()
 		if err != nil {
-			log.Fatalf("Failed to initialize router: %v", err)
+			log.Fatalf("Failed to initialize: %v", err)
+		}
+		if err := tracing.Setup(cfg.Tracing); err != nil {
+			log.Fatalf("Invalid tracing settings: %v", err)
+		}
+		if tracing.Enabled() {
+			log.Printf("Exporting traces over OTLP as %s", cfg.Tracing.ServiceName)
 		}
-		addr := fmt.Sprintf("%s:%d", serverHost, serverPort)
-		log.Printf("Starting server on %s", addr)
-		if err := http.ListenAndServe(addr, router); err != nil {
-			log.Fatal(err)
+		router := api.NewRouter(a)
+		reloadOnHangup(a.Pools)
+		if relaxed, _ := cmd.Flags().GetBool("relaxed-validation"); relaxed {
+			router.SetRelaxedValidation(true)
+		}
+		if interval, _ := cmd.Flags().GetDuration("auto-tune-interval"); interval > 0 {
+			go autoTuneLoop(a.Pools, interval)
+		}
+		go scheduledChangesLoop(a.Pools, scheduledChangesInterval)
+		go recurringTasksLoop(a.Schedules, recurringTasksInterval)
+		quotaInterval, _ := time.ParseDuration(cfg.Quota.CheckInterval)
+		go quotaLoop(a.Pools, quotaInterval)
+		inactivityInterval, _ := time.ParseDuration(cfg.Inactivity.CheckInterval)
+		go inactivityLoop(a.Pools, inactivityInterval)
+		burstInterval, _ := time.ParseDuration(cfg.Burst.CheckInterval)
+		go burstLoop(a.Pools, burstInterval)
+		certInterval, _ := time.ParseDuration(cfg.ACME.CheckInterval)
+		go certificateLoop(a.Sites, certInterval)
+		usageInterval, _ := time.ParseDuration(cfg.Billing.SampleInterval)
+		go usageLoop(a.Pools, usageInterval)
+		reconcileInterval, _ := time.ParseDuration(cfg.Reconcile.Interval)
+		go reconcileLoop(a.Pools, reconcileInterval)
+		if cfg.Replication.URL != "" {
+			store, err := replica.OpenStore(cfg)
+			if err != nil {
+				log.Fatalf("Invalid replication settings: %v", err)
+			}
+			interval, _ := time.ParseDuration(cfg.Replication.Interval)
+			log.Printf("Replicating the database to %s every %s", store, interval)
+			go replica.New(a.DB, store, cfg.Replication.RetentionDays).Run(interval, nil, log.Printf)
+		}
+		if cfg.Backup.URL != "" {
+			store, err := objstore.Open(cfg.Backup.URL, cfg.S3)
+			if err != nil {
+				log.Fatalf("Invalid backup settings: %v", err)
+			}
+			go backupLoop(a.Pools, store, cfg.Backup)
+		}
+
+		// Bind every address before serving so a bad address fails startup
+		listeners := make([]net.Listener, 0, len(specs))
+		var tcpAddrs []string
+		for _, spec := range specs {
+			network, addr, err := config.ParseListen(spec)
+			if err != nil {
+				log.Fatalf("Invalid listen address: %v", err)
+			}
+			var ln net.Listener
+			if network == "unix" {
+				ln, err = unixsock.Listen(addr, mode, socketGroup)
+			} else {
+				ln, err = net.Listen(network, addr)
+				tcpAddrs = append(tcpAddrs, addr)
+			}
+			if err != nil {
+				log.Fatalf("Failed to listen on %s: %v", spec, err)
+			}
+			listeners = append(listeners, ln)
 		}
+		if len(tcpAddrs) > 0 {
+			if err := manager.OpenAPIFirewall(tcpAddrs); err != nil {
+				log.Printf("Warning: failed to open the API port in the firewall: %v", err)
+			}
+		}
+
+		errs := make(chan error, len(listeners))
+		for _, ln := range listeners {
+			log.Printf("Starting server on %s", ln.Addr())
+			go func(ln net.Listener) {
+				errs <- http.Serve(ln, router)
+			}(ln)
+		}
+		log.Fatal(<-errs)
 	},
 }
 
+// reloadOnHangup reloads the config file whenever the server gets SIGHUP,
+// like POST /api/v1/reload
+func reloadOnHangup(pm *manager.PoolManager) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			restart, err := pm.ReloadConfig()
+			if err != nil {
+				log.Printf("Reload: %v", err)
+				continue
+			}
+			log.Printf("Reloaded %s", config.DefaultConfigPath)
+			if len(restart) > 0 {
+				log.Printf("Restart the server to apply %s", strings.Join(restart, ", "))
+			}
+		}
+	}()
+}
+
+// autoTuneLoop re-tunes pools with auto_tune enabled every interval
+func autoTuneLoop(pm *manager.PoolManager, interval time.Duration) {
+	log.Printf("Auto-tuning pools every %s", interval)
+	for range time.Tick(interval) {
+		tuned, err := pm.AutoTunePools()
+		for _, rec := range tuned {
+			log.Printf("Auto-tuned pool %s: max_children %v -> %v", rec.Username, rec.Previous["max_children"], rec.Settings["max_children"])
+		}
+		if err != nil {
+			log.Printf("Auto-tune: %v", err)
+		}
+	}
+}
+
+// scheduledChangesInterval is how often the server looks for scheduled
+// changes that are due
+const scheduledChangesInterval = 30 * time.Second
+
+// scheduledChangesLoop applies scheduled pool changes once they are due
+func scheduledChangesLoop(pm *manager.PoolManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		ran, err := pm.RunDueChanges(time.Now())
+		for _, change := range ran {
+			if change.Error != "" {
+				log.Printf("Scheduled change %d for %s failed: %s", change.ID, change.Username, change.Error)
+			} else {
+				log.Printf("Applied scheduled change %d for %s", change.ID, change.Username)
+			}
+		}
+		if err != nil {
+			log.Printf("Scheduled changes: %v", err)
+		}
+	}
+}
+
+// recurringTasksInterval is how often the server looks for recurring
+// tasks whose cron expression matched
+const recurringTasksInterval = 20 * time.Second
+
+// recurringTasksLoop starts recurring tasks when their schedule matches.
+// The tasks run in the background and record their own outcome.
+func recurringTasksLoop(s *manager.Scheduler, interval time.Duration) {
+	if _, err := s.RunDue(time.Now()); err != nil {
+		log.Printf("Recurring tasks: %v", err)
+	}
+	for now := range time.Tick(interval) {
+		started, err := s.RunDue(now)
+		for _, name := range started {
+			log.Printf("Started recurring task %s", name)
+		}
+		if err != nil {
+			log.Printf("Recurring tasks: %v", err)
+		}
+	}
+}
+
+// quotaLoop checks disk usage against quota.alert_threshold every interval
+func quotaLoop(pm *manager.PoolManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		alerts, err := pm.CheckQuotas()
+		for _, a := range alerts {
+			log.Printf("Pool %s uses %.1f%% of its disk quota", a.Username, a.Usage.Percent)
+			if a.WebhookError != "" {
+				log.Printf("Quota alert for %s: %s", a.Username, a.WebhookError)
+			}
+		}
+		if err != nil {
+			log.Printf("Quota check: %v", err)
+		}
+	}
+}
+
+// inactivityLoop applies the inactivity policy every interval
+func inactivityLoop(pm *manager.PoolManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := pm.ApplyInactivityPolicy()
+		if err != nil {
+			log.Printf("Inactivity check: %v", err)
+			continue
+		}
+		for _, p := range report.Pools {
+			switch {
+			case p.Action == "ondemand":
+				log.Printf("Pool %s had no requests for %.1f days; switched to pm = ondemand", p.Username, p.IdleDays)
+			case p.Action == "alert":
+				log.Printf("Pool %s had no requests for %.1f days", p.Username, p.IdleDays)
+			}
+			if p.Action != "" && p.Error != "" {
+				log.Printf("Inactivity alert for %s: %s", p.Username, p.Error)
+			}
+		}
+	}
+}
+
+// burstLoop raises and lowers max_children of pools with the
+// burst_max_children setting every interval
+func burstLoop(pm *manager.PoolManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		changes, err := pm.CheckBursts()
+		for _, c := range changes {
+			if c.Action == "raise" {
+				log.Printf("Burst: %d connections queued for pool %s; max_children %d -> %d", c.ListenQueue, c.Username, c.Previous, c.MaxChildren)
+			} else {
+				log.Printf("Burst: pool %s is calm again; max_children %d -> %d", c.Username, c.Previous, c.MaxChildren)
+			}
+		}
+		if err != nil {
+			log.Printf("Burst check: %v", err)
+		}
+	}
+}
+
+// certificateLoop renews site certificates nearing expiry every interval
+func certificateLoop(sm *manager.SiteManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		renewed, err := sm.RenewCertificates(time.Now())
+		for _, c := range renewed {
+			if c.LastError != "" {
+				log.Printf("Renewing the certificate of %s failed: %s", c.Domain, c.LastError)
+			} else {
+				log.Printf("Renewed the certificate of %s, valid until %s", c.Domain, c.NotAfter.Format("2006-01-02"))
+			}
+		}
+		if err != nil {
+			log.Printf("Certificate renewal: %v", err)
+		}
+	}
+}
+
+// usageLoop meters pool usage for billing every interval and exports the
+// previous month once it is over
+func usageLoop(pm *manager.PoolManager, interval time.Duration) {
+	sampler := pm.NewUsageSampler()
+	if err := sampler.Sample(time.Now()); err != nil {
+		log.Printf("Usage sample: %v", err)
+	}
+	for now := range time.Tick(interval) {
+		if err := sampler.Sample(now); err != nil {
+			log.Printf("Usage sample: %v", err)
+		}
+		period, err := pm.ExportDueUsage(now)
+		if period != "" {
+			log.Printf("Exported the usage of %s", period)
+		}
+		if err != nil {
+			log.Printf("Usage export: %v", err)
+		}
+	}
+}
+
+// reconcileLoop compares the database with the hosts every interval and
+// heals the kinds of discrepancies in reconcile.heal
+func reconcileLoop(pm *manager.PoolManager, interval time.Duration) {
+	for range time.Tick(interval) {
+		report, err := pm.Reconcile(config.Get().Reconcile.Heal)
+		if report != nil {
+			for _, d := range report.Healed {
+				log.Printf("Reconcile: healed %s %s: %s", d.Kind, d.Subject, d.Message)
+			}
+			for _, d := range report.Discrepancies {
+				if d.FirstSeenAt.Equal(report.CheckedAt) {
+					log.Printf("Reconcile: %s %s: %s", d.Kind, d.Subject, d.Message)
+				}
+				if d.HealError != "" {
+					log.Printf("Reconcile: healing %s %s failed: %s", d.Kind, d.Subject, d.HealError)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Reconcile: %v", err)
+		}
+	}
+}
+
+// backupLoop backs up every account to store every cfg.Interval, starting
+// each run in a maintenance window
+func backupLoop(pm *manager.PoolManager, store objstore.Store, cfg config.BackupConfig) {
+	interval, _ := time.ParseDuration(cfg.Interval)
+	log.Printf("Backing up accounts to %s every %s", store, interval)
+	for range time.Tick(interval) {
+		maintenance.Wait(true, func(next time.Time) {
+			log.Printf("Backup waits for the maintenance window at %s", next.Format("15:04"))
+		})
+		uploaded, err := pm.RunBackups(store, !cfg.SkipFiles, cfg.RetentionDays)
+		log.Printf("Uploaded %d account backups to %s", len(uploaded), store)
+		if err != nil {
+			log.Printf("Backup: %v", err)
+		}
+	}
+}
+
 func init() {
-	serverCmd.Flags().StringVarP(&serverHost, "host", "H", "0.0.0.0", "Server host")
+	serverCmd.Flags().StringSliceVarP(&serverHosts, "host", "H", nil, "Bind address(es), comma-separated; IPv6 literals allowed (default: all IPv4 and IPv6 addresses)")
 	serverCmd.Flags().IntVarP(&serverPort, "port", "p", 8080, "Server port")
+	serverCmd.Flags().StringSlice("listen", nil, "Listen on unix:PATH or HOST:PORT instead of --host and --port; repeatable (default server.listen)")
+	serverCmd.Flags().String("socket-mode", "0660", "Permissions of unix sockets in --listen (default server.socket_mode)")
+	serverCmd.Flags().String("socket-group", "", "Group owning unix sockets in --listen, whose members may connect (default server.socket_group)")
+	serverCmd.Flags().Bool("relaxed-validation", false, "Ignore unknown request fields instead of rejecting them (for older clients)")
+	serverCmd.Flags().String("log-format", "text", "Server log format: text, or json for one object per line (default api.log_format)")
+	serverCmd.Flags().Duration("auto-tune-interval", 0, "Re-tune pools with the auto_tune setting this often, e.g. 15m (0 disables)")
 }
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var servicesCmd = &cobra.Command{
+	Use:   "services",
+	Short: "Inspect the services managed by lightweight-php",
+}
+
+var servicesGraphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Report which pools and sites depend on which units",
+	Long: "Report which pools depend on which PHP-FPM units and which sites depend on which pools " +
+		"and on nginx, to judge the blast radius of restarting a unit. " +
+		"Render the dot format with: lightweight-php services graph --format dot | dot -Tsvg > services.svg",
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		unit, _ := cmd.Flags().GetString("unit")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		graph, err := pm.ServiceGraph()
+		if err != nil {
+			fatalf("Error building service graph: %v", err)
+		}
+
+		if unit != "" {
+			if !strings.Contains(unit, ".") {
+				unit += ".service"
+			}
+			dependents := graph.Dependents(manager.NodeUnit + ":" + unit)
+			fmt.Printf("%s: %d dependents\n", unit, len(dependents))
+			for _, id := range dependents {
+				fmt.Printf("  %s\n", strings.Replace(id, ":", " ", 1))
+			}
+			return
+		}
+
+		switch format {
+		case "dot":
+			fmt.Print(graph.Dot())
+		case "json":
+			encoded, _ := json.MarshalIndent(graph, "", "  ")
+			fmt.Println(string(encoded))
+		case "text":
+			fmt.Print(graph.Text())
+		default:
+			usagef("Error: unknown format %q (text, dot, json)", format)
+		}
+	},
+}
+
+func init() {
+	servicesCmd.AddCommand(servicesGraphCmd)
+	servicesGraphCmd.Flags().String("format", "text", "Output format: text, dot or json")
+	servicesGraphCmd.Flags().String("unit", "", "Only list what depends on this unit, e.g. php82-php-fpm")
+}
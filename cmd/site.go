@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var siteCmd = &cobra.Command{
+	Use:   "site",
+	Short: "Manage sites and their pool bindings",
+	Long:  "Manage sites served by one or more pools of the same user, with per-path PHP version routing",
+}
+
+var siteCreateCmd = &cobra.Command{
+	Use:   "create [domain]",
+	Short: "Create a site served by a user's pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		domain := args[0]
+		username, _ := cmd.Flags().GetString("user")
+		docroot, _ := cmd.Flags().GetString("docroot")
+		phpVersion, _ := cmd.Flags().GetString("php-version")
+
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		site, err := sm.CreateSite(domain, username, docroot, phpVersion)
+		if err != nil {
+			fatalf("Error creating site: %v", err)
+		}
+		printSiteCreated(site)
+
+		checkDNS, _ := cmd.Flags().GetBool("check-dns")
+		updateDNS, _ := cmd.Flags().GetBool("update-dns")
+		if checkDNS || updateDNS {
+			siteCreateDNS(sm, site.Domain, updateDNS)
+		}
+
+		if issue, _ := cmd.Flags().GetBool("certificate"); issue {
+			cert, err := sm.IssueCertificate(site.Domain)
+			if err != nil {
+				fatalf("Site created, but issuing its certificate failed: %v\nRetry with: lightweight-php site cert issue %s", err, site.Domain)
+			}
+			fmt.Printf("Certificate for %s issued, valid until %s\n", cert.Domain, cert.NotAfter.Local().Format("2006-01-02"))
+		}
+	},
+}
+
+func printSiteCreated(site *manager.Site) {
+	fmt.Printf("Site %s created; include %s in its nginx server block\n", site.Domain, site.SnippetPath)
+}
+
+// siteCreateDNS checks the DNS of a new site and points its records at this
+// server on request; problems are warnings, the site exists either way
+func siteCreateDNS(sm *manager.SiteManager, domain string, updateDNS bool) {
+	status, err := sm.CheckDNS(domain)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: DNS check failed: %v\n", err)
+		return
+	}
+	printSiteDNS(status)
+	if status.PointsHere {
+		return
+	}
+	if !updateDNS && status.Provider != "" && isInteractive() {
+		answer := newPrompter().ask(fmt.Sprintf("Point the records at this server through %s? [y/N]", status.Provider), "")
+		updateDNS = strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+	}
+	if updateDNS {
+		updated, err := sm.UpdateDNS(domain)
+		printDNSChanges(updated)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+}
+
+var siteDNSCmd = &cobra.Command{
+	Use:   "dns [domain]",
+	Short: "Check that a site's domain resolves to this server",
+	Long: `Check that a site's A/AAAA records point at this server; the command fails
+when they do not. With --update the records are pointed at this server
+through the configured DNS provider (dns.provider).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		update, _ := cmd.Flags().GetBool("update")
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+
+		var status *manager.SiteDNS
+		if update {
+			status, err = sm.UpdateDNS(args[0])
+		} else {
+			status, err = sm.CheckDNS(args[0])
+		}
+		printSiteDNSResult(cmd, args[0], status, err)
+	},
+}
+
+// printSiteDNSResult prints the outcome of site dns, failing when the
+// check or update failed or, without --update, the domain points elsewhere
+func printSiteDNSResult(cmd *cobra.Command, domain string, status *manager.SiteDNS, err error) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON && err == nil {
+		encoded, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Println(string(encoded))
+	} else if status != nil {
+		printSiteDNS(status)
+		printDNSChanges(status)
+	}
+	if err != nil {
+		fatalf("Error: %v", err)
+	}
+	if update, _ := cmd.Flags().GetBool("update"); !update && !status.PointsHere {
+		exitf(exitFailure, "Error: %s does not point at this server", domain)
+	}
+}
+
+var siteCertCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage ACME (Let's Encrypt) certificates of sites",
+	Long: `Issue certificates with HTTP-01 challenges answered from the site's
+document root, so the domain must already resolve to this server and reach
+nginx on port 80. The API server renews certificates acme.renew_before their
+expiry.`,
+}
+
+var siteCertIssueCmd = &cobra.Command{
+	Use:   "issue [domain]",
+	Short: "Obtain or renew the certificate of a site now",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		cert, err := sm.IssueCertificate(args[0])
+		if err != nil {
+			fatalf("Error issuing certificate: %v", err)
+		}
+		printCertificateIssued(cert)
+	},
+}
+
+func printCertificateIssued(cert *manager.Certificate) {
+	fmt.Printf("Certificate for %s issued by %s, valid until %s\n", cert.Domain, cert.Issuer, cert.NotAfter.Local().Format("2006-01-02"))
+	fmt.Printf("  %s\n  %s\n", cert.CertPath, cert.KeyPath)
+}
+
+var siteCertListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List site certificates and their expiry",
+	Run: func(cmd *cobra.Command, args []string) {
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		certificates, err := sm.ListCertificates()
+		if err != nil {
+			fatalf("Error listing certificates: %v", err)
+		}
+		printCertificates(cmd, certificates)
+	},
+}
+
+func printCertificates(cmd *cobra.Command, certificates []manager.Certificate) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(certificates, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	if len(certificates) == 0 {
+		fmt.Println("No certificates")
+		return
+	}
+	for _, c := range certificates {
+		expiry := "-"
+		if c.NotAfter != nil {
+			expiry = fmt.Sprintf("%s (%d days)", c.NotAfter.Local().Format("2006-01-02"), *c.DaysLeft)
+		}
+		fmt.Printf("%-32s %-7s %s\n", c.Domain, c.Status, expiry)
+		if c.LastError != "" {
+			fmt.Printf("  last error: %s\n", c.LastError)
+		}
+	}
+}
+
+var siteCertRenewCmd = &cobra.Command{
+	Use:   "renew",
+	Short: "Renew the certificates that expire within acme.renew_before",
+	Run: func(cmd *cobra.Command, args []string) {
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		renewed, err := sm.RenewCertificates(time.Now())
+		if err != nil {
+			fatalf("Error renewing certificates: %v", err)
+		}
+		printRenewals(renewed)
+	},
+}
+
+// printRenewals prints the result of site cert renew, failing when any
+// renewal failed
+func printRenewals(renewed []manager.Certificate) {
+	failed := 0
+	for _, c := range renewed {
+		if c.LastError != "" {
+			failed++
+			fmt.Printf("Renewing %s failed: %s\n", c.Domain, c.LastError)
+		} else {
+			fmt.Printf("Renewed %s, valid until %s\n", c.Domain, c.NotAfter.Local().Format("2006-01-02"))
+		}
+	}
+	if failed > 0 {
+		exitf(exitFailure, "Error: %d of %d renewals failed", failed, len(renewed))
+	}
+	if len(renewed) == 0 {
+		fmt.Println("No certificates are due for renewal")
+	}
+}
+
+func printSiteDNS(status *manager.SiteDNS) {
+	records := append(append([]string{}, status.A...), status.AAAA...)
+	if len(records) == 0 {
+		records = []string{"-"}
+	}
+	fmt.Printf("DNS %s: %s (server: %s)\n", status.Domain, strings.Join(records, ", "), strings.Join(status.ServerAddresses, ", "))
+	for _, warning := range status.Warnings {
+		fmt.Printf("  warning: %s\n", warning)
+	}
+	if status.PointsHere {
+		fmt.Println("  points at this server")
+	}
+}
+
+func printDNSChanges(status *manager.SiteDNS) {
+	if status == nil {
+		return
+	}
+	for _, change := range status.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+	if len(status.Changes) > 0 {
+		fmt.Println("  resolvers see the new records once cached answers expire")
+	}
+}
+
+var siteBindCmd = &cobra.Command{
+	Use:   "bind [domain]",
+	Short: "Route a path of a site to another PHP version",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		domain := args[0]
+		path, _ := cmd.Flags().GetString("path")
+		phpVersion, _ := cmd.Flags().GetString("php-version")
+
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		if _, err := sm.BindPath(domain, path, phpVersion); err != nil {
+			fatalf("Error binding path: %v", err)
+		}
+		fmt.Printf("Site %s: %s now served by PHP %s\n", domain, path, phpVersion)
+	},
+}
+
+var siteUnbindCmd = &cobra.Command{
+	Use:   "unbind [domain]",
+	Short: "Remove a path binding from a site",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		domain := args[0]
+		path, _ := cmd.Flags().GetString("path")
+
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		if _, err := sm.UnbindPath(domain, path); err != nil {
+			fatalf("Error removing binding: %v", err)
+		}
+		fmt.Printf("Site %s: binding for %s removed\n", domain, path)
+	},
+}
+
+var siteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List sites and their bindings",
+	Run: func(cmd *cobra.Command, args []string) {
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		sites, err := sm.ListSites()
+		if err != nil {
+			fatalf("Error listing sites: %v", err)
+		}
+		printSites(sites)
+	},
+}
+
+func printSites(sites []manager.Site) {
+	for _, site := range sites {
+		fmt.Printf("Site: %s, User: %s, Root: %s\n", site.Domain, site.Username, site.DocumentRoot)
+		for _, b := range site.Bindings {
+			fmt.Printf("  %s -> PHP %s (%s)\n", b.PathPrefix, b.PHPVersion, b.SocketPath)
+		}
+	}
+}
+
+var siteShowCmd = &cobra.Command{
+	Use:   "show [domain]",
+	Short: "Print the generated nginx snippet for a site",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		snippet, err := sm.RenderSnippet(args[0])
+		if err != nil {
+			fatalf("Error rendering site: %v", err)
+		}
+		fmt.Println(snippet)
+	},
+}
+
+var siteDeleteCmd = &cobra.Command{
+	Use:   "delete [domain]",
+	Short: "Delete a site and its nginx snippet",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		domain := args[0]
+		sm, err := newSiteManager()
+		if err != nil {
+			fatalf("Error initializing site manager: %v", err)
+		}
+		if err := sm.DeleteSite(domain); err != nil {
+			fatalf("Error deleting site: %v", err)
+		}
+		fmt.Printf("Site deleted: %s\n", domain)
+	},
+}
+
+func init() {
+	siteCmd.AddCommand(siteCreateCmd)
+	siteCmd.AddCommand(siteBindCmd)
+	siteCmd.AddCommand(siteUnbindCmd)
+	siteCmd.AddCommand(siteListCmd)
+	siteCmd.AddCommand(siteShowCmd)
+	siteCmd.AddCommand(siteDeleteCmd)
+	siteCmd.AddCommand(siteDNSCmd)
+	siteCmd.AddCommand(siteCertCmd)
+	siteCertCmd.AddCommand(siteCertIssueCmd)
+	siteCertCmd.AddCommand(siteCertListCmd)
+	siteCertCmd.AddCommand(siteCertRenewCmd)
+
+	siteCreateCmd.Flags().String("user", "", "Pool user serving the site")
+	siteCreateCmd.Flags().String("docroot", "", "Document root")
+	siteCreateCmd.Flags().String("php-version", "", "PHP version for / (default: the user's most recent pool)")
+	siteCreateCmd.Flags().Bool("check-dns", false, "Warn when the domain does not resolve to this server, and offer to fix the records")
+	siteCreateCmd.Flags().Bool("update-dns", false, "Point the domain's records at this server through the configured DNS provider")
+	siteCreateCmd.Flags().Bool("certificate", false, "Obtain an ACME (Let's Encrypt) certificate for the domain")
+	siteCreateCmd.MarkFlagRequired("user")
+	siteCreateCmd.MarkFlagRequired("docroot")
+
+	siteBindCmd.Flags().String("path", "", "Path prefix, e.g. /old/")
+	siteBindCmd.Flags().String("php-version", "", "PHP version of the user's pool to route to")
+	siteBindCmd.MarkFlagRequired("path")
+	siteBindCmd.MarkFlagRequired("php-version")
+
+	siteUnbindCmd.Flags().String("path", "", "Path prefix to remove")
+	siteUnbindCmd.MarkFlagRequired("path")
+
+	siteDNSCmd.Flags().Bool("update", false, "Point the records at this server through the configured DNS provider")
+	siteDNSCmd.Flags().Bool("json", false, "Print the result as JSON")
+	siteCertListCmd.Flags().Bool("json", false, "Print the certificates as JSON")
+}
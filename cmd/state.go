@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "Inspect and compare this host's pools, PHP versions and settings",
+}
+
+var stateShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print this host's state as JSON",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		snapshot, err := pm.Snapshot()
+		if err != nil {
+			fatalf("Error reading state: %v", err)
+		}
+		encoded, _ := json.MarshalIndent(snapshot, "", "  ")
+		fmt.Println(string(encoded))
+	},
+}
+
+var stateDiffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare this host's state with another instance",
+	Long: "Compare pools, PHP versions, pool settings and profiles with another instance " +
+		"through its API. Exits 0 when both are identical, 1 when they differ and 2 on errors, " +
+		"like diff(1).",
+	Run: func(cmd *cobra.Command, args []string) {
+		against := stateDiffAgainst(cmd)
+		pm, err := newPoolManager()
+		if err != nil {
+			exitf(2, "Error initializing pool manager: %v", err)
+		}
+		local, err := pm.Snapshot()
+		if err != nil {
+			exitf(2, "Error reading local state: %v", err)
+		}
+		printStateDiff(cmd, against, local)
+	},
+}
+
+func stateDiffAgainst(cmd *cobra.Command) string {
+	against, _ := cmd.Flags().GetString("against")
+	if against == "" {
+		exitf(2, "Error: --against is required")
+	}
+	return against
+}
+
+// printStateDiff compares local with the state of against, exiting 1 when
+// they differ and 2 when against cannot be read
+func printStateDiff(cmd *cobra.Command, against string, local *manager.StateSnapshot) {
+	remote, err := manager.FetchSnapshot(against)
+	if err != nil {
+		exitf(2, "Error reading remote state: %v", err)
+	}
+
+	diffs := manager.DiffSnapshots(local, remote)
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(diffs, "", "  ")
+		fmt.Println(string(encoded))
+	} else if len(diffs) == 0 {
+		fmt.Printf("No differences with %s\n", against)
+	} else {
+		for _, d := range diffs {
+			fmt.Printf("%s\n  local:  %s\n  remote: %s\n", d.Path, formatStateValue(d.Local), formatStateValue(d.Remote))
+		}
+		fmt.Printf("%d differences with %s\n", len(diffs), against)
+	}
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func formatStateValue(v interface{}) string {
+	if v == nil {
+		return "(absent)"
+	}
+	encoded, _ := json.Marshal(v)
+	return string(encoded)
+}
+
+func init() {
+	stateCmd.AddCommand(stateShowCmd)
+	stateCmd.AddCommand(stateDiffCmd)
+	stateDiffCmd.Flags().String("against", "", "API URL of the other instance, e.g. http://other-host:8080 or unix:///run/lightweight-php.sock")
+	stateDiffCmd.Flags().Bool("json", false, "Print the differences as JSON")
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var poolSuspendCmd = &cobra.Command{
+	Use:   "suspend [username]",
+	Short: "Stop serving PHP for a user until the pool is unsuspended",
+	Long: "Set the pool's configuration aside and replace it with a placeholder pool on the same " +
+		"socket that answers every PHP request with 503 Service Unavailable. The pool is marked " +
+		"suspended and its settings are kept; 'pool unsuspend' restores it.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		reason, _ := cmd.Flags().GetString("reason")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		if err := pm.SuspendPool(username, reason); err != nil {
+			fatalf("Error suspending pool: %v", err)
+		}
+		fmt.Printf("Pool suspended for user: %s\n", username)
+	},
+}
+
+var poolUnsuspendCmd = &cobra.Command{
+	Use:   "unsuspend [username]",
+	Short: "Restore a suspended pool",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+		if err := pm.UnsuspendPool(username); err != nil {
+			fatalf("Error unsuspending pool: %v", err)
+		}
+		fmt.Printf("Pool unsuspended for user: %s\n", username)
+	},
+}
+
+func init() {
+	poolCmd.AddCommand(poolSuspendCmd)
+	poolCmd.AddCommand(poolUnsuspendCmd)
+	poolSuspendCmd.Flags().String("reason", "", "Why the account is suspended, stored with the suspension")
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"lightweight-php/templates"
+
+	"github.com/spf13/cobra"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:     "templates",
+	Aliases: []string{"template"},
+	Short:   "Inspect configuration templates and administrator overrides",
+	Long:    fmt.Sprintf("Templates in %s override the embedded versions of the same name", templates.OverrideDir),
+}
+
+var templatesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List templates and whether an override is active",
+	Run: func(cmd *cobra.Command, args []string) {
+		infos, err := templates.ListTemplates()
+		if err != nil {
+			fatalf("Error listing templates: %v", err)
+		}
+		for _, info := range infos {
+			if info.Path != "" {
+				fmt.Printf("%-22s %-9s %s\n", info.Name, info.Source, info.Path)
+			} else {
+				fmt.Printf("%-22s %s\n", info.Name, info.Source)
+			}
+		}
+	},
+}
+
+var templatesShowCmd = &cobra.Command{
+	Use:   "show [name]",
+	Short: "Print the effective template",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		embedded, _ := cmd.Flags().GetBool("embedded")
+
+		var content string
+		var err error
+		if embedded {
+			content, err = templates.EmbeddedTemplate(args[0])
+		} else {
+			content, err = templates.LoadTemplate(args[0])
+		}
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		fmt.Print(content)
+	},
+}
+
+var templatesValidateCmd = &cobra.Command{
+	Use:   "validate [name]",
+	Short: "Validate overrides, or a candidate file with --file",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		if file != "" {
+			if len(args) == 0 {
+				usagef("Error: template name is required with --file")
+			}
+			content, err := os.ReadFile(file)
+			if err != nil {
+				fatalf("Error reading %s: %v", file, err)
+			}
+			if err := templates.ValidateTemplate(args[0], string(content)); err != nil {
+				fatalf("%s: invalid: %v", file, err)
+			}
+			fmt.Printf("%s: valid %s\n", file, args[0])
+			return
+		}
+
+		infos, err := templates.ListTemplates()
+		if err != nil {
+			fatalf("Error listing templates: %v", err)
+		}
+
+		failed := false
+		for _, info := range infos {
+			if len(args) == 1 && info.Name != args[0] {
+				continue
+			}
+			if _, err := templates.LoadTemplate(info.Name); err != nil {
+				fmt.Printf("%s: %v\n", info.Name, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%s: ok (%s)\n", info.Name, info.Source)
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+var templatesDebugCmd = &cobra.Command{
+	Use:   "debug [username]",
+	Short: "Render a pool's configuration locally and explain failures",
+	Long: `Render the pool template with the pool's stored settings, exactly as a
+settings change would, without writing or reloading anything. On failure the
+template, line, field and the surrounding template lines are shown. --file
+renders a candidate template instead of the effective one.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var content string
+		if file, _ := cmd.Flags().GetString("file"); file != "" {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				fatalf("Error reading %s: %v", file, err)
+			}
+			content = string(b)
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		result, err := pm.DebugPoolRender(args[0], content)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(result, "", "  ")
+			fmt.Println(string(encoded))
+		} else if result.Error == nil {
+			fmt.Print(result.Output)
+		} else {
+			re := result.Error
+			fmt.Printf("Template: %s (%s)\n", re.Template, re.Source)
+			if re.Line > 0 {
+				fmt.Printf("Line:     %d\n", re.Line)
+			}
+			if re.Field != "" {
+				fmt.Printf("Field:    %s\n", re.Field)
+			}
+			fmt.Printf("Error:    %s\n", re.Message)
+			if result.Excerpt != "" {
+				fmt.Printf("\n%s", result.Excerpt)
+			}
+		}
+		if result.Error != nil {
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+var templatesFailuresCmd = &cobra.Command{
+	Use:   "failures",
+	Short: "List recorded template render failures",
+	Run: func(cmd *cobra.Command, args []string) {
+		username, _ := cmd.Flags().GetString("user")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		failures, err := pm.ListRenderFailures(username, limit)
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			encoded, _ := json.MarshalIndent(failures, "", "  ")
+			fmt.Println(string(encoded))
+			return
+		}
+		if len(failures) == 0 {
+			fmt.Println("No render failures recorded")
+			return
+		}
+		for _, f := range failures {
+			user := f.Username
+			if user == "" {
+				user = "-"
+			}
+			fmt.Printf("%-5d %s  %-16s %s\n", f.ID, f.CreatedAt.Local().Format("2006-01-02 15:04:05"), user, f.Error)
+		}
+	},
+}
+
+func init() {
+	templatesCmd.AddCommand(templatesListCmd)
+	templatesCmd.AddCommand(templatesShowCmd)
+	templatesCmd.AddCommand(templatesValidateCmd)
+	templatesCmd.AddCommand(templatesDebugCmd)
+	templatesCmd.AddCommand(templatesFailuresCmd)
+	templatesDebugCmd.Flags().String("file", "", "Render this candidate template instead of the effective one")
+	templatesDebugCmd.Flags().Bool("json", false, "Print the result, data snapshot included, as JSON")
+	templatesFailuresCmd.Flags().String("user", "", "Only failures of this pool user")
+	templatesFailuresCmd.Flags().Int("limit", 20, "Maximum number of failures to show")
+	templatesFailuresCmd.Flags().Bool("json", false, "Print the failures as JSON")
+	templatesShowCmd.Flags().Bool("embedded", false, "Show the built-in version even if an override exists")
+	templatesValidateCmd.Flags().String("file", "", "Validate this file as the named template before installing it")
+}
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var tenantCmd = &cobra.Command{
+	Use:   "tenant",
+	Short: "Group pools and PHP versions into tenants",
+	Long: `Tenants are logical environments such as shared-1, shared-2 or reseller-x
+sharing one host and database. Pools and PHP versions can belong to a tenant;
+new pools of a tenant start from its default settings, and API keys can be
+limited to tenants (api.keys in config.json).`,
+}
+
+var tenantListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tenants",
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		tenants, err := pm.ListTenants()
+		if err != nil {
+			fatalf("Error listing tenants: %v", err)
+		}
+		printTenants(cmd, tenants)
+	},
+}
+
+func printTenants(cmd *cobra.Command, tenants []manager.Tenant) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(tenants, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	for _, t := range tenants {
+		fmt.Printf("%-20s %4d pools %3d PHP versions  %s\n", t.Name, t.Pools, t.PHPVersions, t.Description)
+	}
+}
+
+var tenantShowCmd = &cobra.Command{
+	Use:   "show [name]",
+	Short: "Show a tenant and its default pool settings",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		t, err := pm.GetTenant(args[0])
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		printTenant(cmd, t)
+	},
+}
+
+func printTenant(cmd *cobra.Command, t *manager.Tenant) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(t, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	fmt.Printf("Tenant:       %s\n", t.Name)
+	if t.Description != "" {
+		fmt.Printf("Description:  %s\n", t.Description)
+	}
+	fmt.Printf("Pools:        %d\n", t.Pools)
+	fmt.Printf("PHP versions: %d\n", t.PHPVersions)
+	if len(t.Settings) == 0 {
+		return
+	}
+	fmt.Println("Default settings:")
+	keys := make([]string, 0, len(t.Settings))
+	for key := range t.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("  %s = %v\n", key, t.Settings[key])
+	}
+}
+
+var tenantCreateCmd = &cobra.Command{
+	Use:   "create [name] [key=value...]",
+	Short: "Create a tenant with default settings for its new pools",
+	Long: `Create a tenant. Settings given as key=value are the defaults new pools
+of the tenant start from; a profile given at pool creation overrides them.
+
+  lightweight-php tenant create reseller-x --description "Reseller X" max_children=4 memory_limit=128M`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		settings, err := parseSettingArgs(args[1:])
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+		description, _ := cmd.Flags().GetString("description")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if err := pm.CreateTenant(&manager.Tenant{Name: args[0], Description: description, Settings: settings}); err != nil {
+			fatalf("Error creating tenant: %v", err)
+		}
+		fmt.Printf("Tenant created: %s\n", args[0])
+	},
+}
+
+var tenantSetCmd = &cobra.Command{
+	Use:   "set [name] key=value...",
+	Short: "Change the default settings or description of a tenant",
+	Long: `Merge default settings into a tenant. An empty value (key=) removes a
+setting. Existing pools of the tenant keep their settings.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		patch, err := parseSettingArgs(args[1:])
+		if err != nil {
+			usagef("Error: %v", err)
+		}
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		t, err := pm.GetTenant(args[0])
+		if err != nil {
+			fatalf("Error: %v", err)
+		}
+		patchTenant(cmd, t, patch)
+		if err := pm.UpdateTenant(t); err != nil {
+			fatalf("Error updating tenant: %v", err)
+		}
+		fmt.Printf("Tenant updated: %s\n", t.Name)
+	},
+}
+
+// patchTenant applies tenant set's --description and key=value arguments
+// to t; an empty value removes the setting
+func patchTenant(cmd *cobra.Command, t *manager.Tenant, patch map[string]interface{}) {
+	if cmd.Flags().Changed("description") {
+		t.Description, _ = cmd.Flags().GetString("description")
+	}
+	if t.Settings == nil {
+		t.Settings = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		if value == "" {
+			delete(t.Settings, key)
+		} else {
+			t.Settings[key] = value
+		}
+	}
+}
+
+var tenantDeleteCmd = &cobra.Command{
+	Use:   "delete [name]",
+	Short: "Delete a tenant without pools or PHP versions",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if err := pm.DeleteTenant(args[0]); err != nil {
+			fatalf("Error deleting tenant: %v", err)
+		}
+		fmt.Printf("Tenant deleted: %s\n", args[0])
+	},
+}
+
+var tenantAssignCmd = &cobra.Command{
+	Use:   "assign [name]",
+	Short: "Move pools and PHP versions into a tenant",
+	Long: `Move pools (--pool) into a tenant and reserve PHP versions (--php-version)
+for it. A reserved version cannot get pools of other tenants. Pass "-" as the
+name to take them out of any tenant, which shares a PHP version again.
+
+  lightweight-php tenant assign shared-2 --pool alice --pool bob
+  lightweight-php tenant assign reseller-x --php-version 8.3
+  lightweight-php tenant assign - --pool alice`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tenant, pools, versions := tenantAssignment(cmd, args)
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		// Versions first, so pools can move onto a version just reserved
+		for _, version := range versions {
+			if err := pm.SetPHPVersionTenant(version, tenant); err != nil {
+				fatalf("Error assigning PHP %s: %v", version, err)
+			}
+		}
+		for _, username := range pools {
+			if err := pm.SetPoolTenant(username, tenant); err != nil {
+				fatalf("Error assigning pool %s: %v", username, err)
+			}
+		}
+		printTenantAssigned(tenant, pools, versions)
+	},
+}
+
+// tenantAssignment reads the tenant, with "" for "-", and the pools and
+// PHP versions tenant assign moves
+func tenantAssignment(cmd *cobra.Command, args []string) (tenant string, pools, versions []string) {
+	tenant = args[0]
+	if tenant == "-" {
+		tenant = ""
+	}
+	pools, _ = cmd.Flags().GetStringArray("pool")
+	versions, _ = cmd.Flags().GetStringArray("php-version")
+	if len(pools) == 0 && len(versions) == 0 {
+		usagef("Error: give --pool or --php-version")
+	}
+	return tenant, pools, versions
+}
+
+func printTenantAssigned(tenant string, pools, versions []string) {
+	moved := append(append([]string(nil), pools...), versions...)
+	if tenant == "" {
+		fmt.Printf("Removed from their tenant: %s\n", strings.Join(moved, ", "))
+		return
+	}
+	fmt.Printf("Assigned to %s: %s\n", tenant, strings.Join(moved, ", "))
+}
+
+func init() {
+	tenantCmd.AddCommand(tenantListCmd)
+	tenantCmd.AddCommand(tenantShowCmd)
+	tenantCmd.AddCommand(tenantCreateCmd)
+	tenantCmd.AddCommand(tenantSetCmd)
+	tenantCmd.AddCommand(tenantDeleteCmd)
+	tenantCmd.AddCommand(tenantAssignCmd)
+	tenantListCmd.Flags().Bool("json", false, "Print the tenants as JSON")
+	tenantShowCmd.Flags().Bool("json", false, "Print the tenant as JSON")
+	tenantCreateCmd.Flags().String("description", "", "Description of the tenant")
+	tenantSetCmd.Flags().String("description", "", "New description of the tenant")
+	tenantAssignCmd.Flags().StringArray("pool", nil, "Pool user to move (repeatable)")
+	tenantAssignCmd.Flags().StringArray("php-version", nil, "PHP version to reserve (repeatable)")
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"lightweight-php/manager"
+
+	"github.com/spf13/cobra"
+)
+
+var poolTuneCmd = &cobra.Command{
+	Use:   "tune [username]",
+	Short: "Size pm.max_children from worker memory and server RAM",
+	Long: "Measure the average memory of the pool's running workers and the server's RAM, " +
+		"and write the recommended pm.max_children, start_servers and spare server settings. " +
+		"Pools with the auto_tune setting are re-tuned periodically by `server --auto-tune-interval`.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		username := args[0]
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		pm, err := newPoolManager()
+		if err != nil {
+			fatalf("Error initializing pool manager: %v", err)
+		}
+		if noWait {
+			pm = pm.WithNoWait()
+		}
+
+		rec, err := pm.TunePool(username, !dryRun)
+		if err != nil {
+			fatalf("Error tuning pool: %v", err)
+		}
+		printTuning(cmd, rec)
+	},
+}
+
+func printTuning(cmd *cobra.Command, rec *manager.TuneRecommendation) {
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		encoded, _ := json.MarshalIndent(rec, "", "  ")
+		fmt.Println(string(encoded))
+		return
+	}
+	fmt.Printf("Workers measured: %d, average %dM\n", rec.Workers, rec.AvgWorkerMemory>>20)
+	fmt.Printf("Memory: %dM total, %dM available, %dM budget for this pool\n", rec.MemTotal>>20, rec.MemAvailable>>20, rec.Budget>>20)
+	for _, key := range []string{"max_children", "start_servers", "min_spare_servers", "max_spare_servers"} {
+		previous := "default"
+		if v, ok := rec.Previous[key]; ok {
+			previous = fmt.Sprint(v)
+		}
+		fmt.Printf("  %-18s %s -> %v\n", key, previous, rec.Settings[key])
+	}
+	if rec.Applied {
+		fmt.Printf("Applied (revision %d)\n", rec.Revision)
+	} else {
+		fmt.Println("Not applied (dry run)")
+	}
+}
+
+func init() {
+	poolCmd.AddCommand(poolTuneCmd)
+	poolTuneCmd.Flags().Bool("dry-run", false, "Only print the recommendation")
+	poolTuneCmd.Flags().Bool("json", false, "Print the recommendation as JSON")
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"lightweight-php/target"
+
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// isInteractive reports whether stdin is a terminal a wizard can prompt on
+func isInteractive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
+
+// prompter asks questions on stdin
+type prompter struct {
+	in *bufio.Reader
+}
+
+func newPrompter() *prompter {
+	return &prompter{in: bufio.NewReader(os.Stdin)}
+}
+
+// ask reads one answer, returning def for an empty line
+func (p *prompter) ask(label, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", label, def)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Println()
+		os.Exit(1)
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// choose asks until the answer is one of choices or its 1-based number
+func (p *prompter) choose(label string, choices []string, def string) string {
+	for i, c := range choices {
+		fmt.Printf("  %d) %s\n", i+1, c)
+	}
+	for {
+		answer := p.ask(label, def)
+		for i, c := range choices {
+			if answer == c || answer == fmt.Sprint(i+1) {
+				return c
+			}
+		}
+		fmt.Printf("Please choose one of: %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// poolCreateWizard asks for the pool create arguments the user did not
+// pass on the command line. It returns false when the user cancels.
+func poolCreateWizard(cmd *cobra.Command, username, phpVersion, providerName, profile *string) bool {
+	p := newPrompter()
+	fmt.Println("Create a PHP-FPM pool (Ctrl-D to cancel)")
+
+	targetSpec, _ := cmd.Flags().GetString("target")
+	t, _ := target.Parse(targetSpec)
+	for *username == "" {
+		answer := p.ask("System user", "")
+		if _, err := t.LookupUser(answer); err != nil {
+			fmt.Printf("User %q does not exist\n", answer)
+			continue
+		}
+		*username = answer
+	}
+
+	if !cmd.Flags().Changed("php-version") {
+		if versions := installedVersions(""); len(versions) > 0 {
+			def := *phpVersion
+			if !containsString(versions, def) {
+				def = versions[len(versions)-1]
+			}
+			*phpVersion = p.choose("PHP version", versions, def)
+		} else {
+			*phpVersion = p.ask("PHP version", *phpVersion)
+		}
+	}
+
+	if !cmd.Flags().Changed("provider") {
+		*providerName = p.choose("Provider", providerChoices, *providerName)
+	}
+
+	if !cmd.Flags().Changed("profile") {
+		pm, err := newPoolManager()
+		if err == nil {
+			if profiles, err := pm.ListProfiles(); err == nil && len(profiles) > 0 {
+				names := []string{"none"}
+				for _, pr := range profiles {
+					names = append(names, pr.Name)
+				}
+				if choice := p.choose("Profile", names, "none"); choice != "none" {
+					*profile = choice
+				}
+			}
+		}
+	}
+
+	summary := fmt.Sprintf("Create pool for %s with PHP %s (provider: %s", *username, *phpVersion, *providerName)
+	if *profile != "" {
+		summary += ", profile: " + *profile
+	}
+	answer := p.ask(summary+")? [Y/n]", "")
+	return answer == "" || strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolActivity tracks the request counters of a pool's PHP-FPM status page
+// between checks
+type PoolActivity struct {
+	PoolID int64
+	// StartTime and AcceptedConn are the pool's "start time" (Unix seconds)
+	// and "accepted conn" at the last check; the counter restarts with the
+	// pool
+	StartTime    int64
+	AcceptedConn int64
+	// LastRequestAt is when a request was last seen, or when the pool was
+	// first checked
+	LastRequestAt time.Time
+	CheckedAt     time.Time
+	// ConvertedAt is set when the inactivity policy switched the pool to
+	// pm = ondemand
+	ConvertedAt *time.Time
+}
+
+// GetPoolActivity returns the activity record of a pool, or nil
+func (db *Database) GetPoolActivity(poolID int64) (*PoolActivity, error) {
+	var a PoolActivity
+	var converted sql.NullTime
+	err := db.QueryRow(
+		"SELECT pool_id, start_time, accepted_conn, last_request_at, checked_at, converted_at FROM pool_activity WHERE pool_id = ?",
+		poolID,
+	).Scan(&a.PoolID, &a.StartTime, &a.AcceptedConn, &a.LastRequestAt, &a.CheckedAt, &converted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if converted.Valid {
+		a.ConvertedAt = &converted.Time
+	}
+	return &a, nil
+}
+
+// SavePoolActivity stores the counters of a check, keeping ConvertedAt
+func (db *Database) SavePoolActivity(a *PoolActivity) error {
+	_, err := db.Exec(`
+		INSERT INTO pool_activity (pool_id, start_time, accepted_conn, last_request_at, checked_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(pool_id) DO UPDATE SET
+			start_time = excluded.start_time,
+			accepted_conn = excluded.accepted_conn,
+			last_request_at = excluded.last_request_at,
+			checked_at = excluded.checked_at`,
+		a.PoolID, a.StartTime, a.AcceptedConn, a.LastRequestAt.UTC(), a.CheckedAt.UTC(),
+	)
+	return err
+}
+
+// SetPoolConverted records when a pool was switched to pm = ondemand; nil
+// clears it
+func (db *Database) SetPoolConverted(poolID int64, at *time.Time) error {
+	var value interface{}
+	if at != nil {
+		value = at.UTC()
+	}
+	_, err := db.Exec("UPDATE pool_activity SET converted_at = ? WHERE pool_id = ?", value, poolID)
+	return err
+}
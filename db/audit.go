@@ -0,0 +1,92 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AuditEntry is one operation recorded in the audit log
+type AuditEntry struct {
+	ID        int64
+	CreatedAt time.Time
+	RequestID string
+	Actor     string
+	Remote    string
+	Action    string
+	Target    string
+	// Error is empty when the operation succeeded
+	Error string
+}
+
+// AuditFilter narrows ListAuditEntries; empty fields match everything
+type AuditFilter struct {
+	RequestID string
+	Target    string
+	Actor     string
+	Limit     int
+}
+
+// CreateAuditEntry records an operation
+func (db *Database) CreateAuditEntry(e *AuditEntry) (int64, error) {
+	return db.insert(
+		"INSERT INTO audit_log (created_at, request_id, actor, remote, action, target, error) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.CreatedAt, e.RequestID, e.Actor, e.Remote, e.Action, e.Target, e.Error,
+	)
+}
+
+// ListAuditEntries returns recorded operations, newest first
+func (db *Database) ListAuditEntries(f AuditFilter) ([]AuditEntry, error) {
+	query := "SELECT id, created_at, request_id, actor, remote, action, target, error FROM audit_log WHERE 1 = 1"
+	var args []interface{}
+	if f.RequestID != "" {
+		query += " AND request_id = ?"
+		args = append(args, f.RequestID)
+	}
+	if f.Target != "" {
+		query += " AND target = ?"
+		args = append(args, f.Target)
+	}
+	if f.Actor != "" {
+		query += " AND actor = ?"
+		args = append(args, f.Actor)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		var createdAt sql.NullTime
+		if err := rows.Scan(&e.ID, &createdAt, &e.RequestID, &e.Actor, &e.Remote, &e.Action, &e.Target, &e.Error); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			e.CreatedAt = createdAt.Time
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PruneAuditEntries removes entries recorded before cutoff
+func (db *Database) PruneAuditEntries(cutoff time.Time) error {
+	_, err := db.Exec("DELETE FROM audit_log WHERE created_at < ?", cutoff)
+	return err
+}
+
+// PseudonymizeAuditEntries replaces targets naming an erased account or
+// its sites with the account's pseudonymous subject
+func (db *Database) PseudonymizeAuditEntries(targets []string, subject string) error {
+	for _, target := range targets {
+		if _, err := db.Exec("UPDATE audit_log SET target = ? WHERE target = ?", subject, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolBurst records that burst protection raised a pool's max_children
+// above the value the pool had before, so it can be lowered back, also
+// after the server restarted
+type PoolBurst struct {
+	PoolID int64
+	// BaseMaxChildren is max_children before the first raise
+	BaseMaxChildren int64
+	// MaxChildren is the value burst protection set last
+	MaxChildren int64
+	RaisedAt    time.Time
+	UpdatedAt   time.Time
+}
+
+// GetPoolBurst returns the burst record of a pool, or nil if burst
+// protection has not raised it
+func (db *Database) GetPoolBurst(poolID int64) (*PoolBurst, error) {
+	var b PoolBurst
+	err := db.QueryRow(
+		"SELECT pool_id, base_max_children, max_children, raised_at, updated_at FROM pool_bursts WHERE pool_id = ?",
+		poolID,
+	).Scan(&b.PoolID, &b.BaseMaxChildren, &b.MaxChildren, &b.RaisedAt, &b.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// SavePoolBurst stores a raise, keeping the base and time of the first one
+func (db *Database) SavePoolBurst(b *PoolBurst) error {
+	_, err := db.Exec(`
+		INSERT INTO pool_bursts (pool_id, base_max_children, max_children, raised_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(pool_id) DO UPDATE SET
+			max_children = excluded.max_children,
+			updated_at = excluded.updated_at`,
+		b.PoolID, b.BaseMaxChildren, b.MaxChildren, b.RaisedAt.UTC(), b.UpdatedAt.UTC(),
+	)
+	return err
+}
+
+// DeletePoolBurst forgets a pool's burst record once it is lowered back or
+// its max_children was changed by someone else
+func (db *Database) DeletePoolBurst(poolID int64) error {
+	_, err := db.Exec("DELETE FROM pool_bursts WHERE pool_id = ?", poolID)
+	return err
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Certificate statuses
+const (
+	CertificateValid  = "valid"
+	CertificateFailed = "failed"
+)
+
+// Certificate is the TLS certificate of a site. A failed renewal keeps the
+// previous certificate's paths and dates and records the error.
+type Certificate struct {
+	ID        int64
+	SiteID    int64
+	Domain    string
+	Status    string
+	CertPath  string
+	KeyPath   string
+	Issuer    string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const certificateColumns = `c.id, c.site_id, s.domain, c.status, c.cert_path, c.key_path, c.issuer, c.serial,
+	c.not_before, c.not_after, c.last_error, c.created_at, c.updated_at`
+
+// SaveCertificate creates or replaces the certificate record of a site
+func (db *Database) SaveCertificate(c *Certificate) error {
+	_, err := db.Exec(`
+		INSERT INTO certificates (site_id, status, cert_path, key_path, issuer, serial, not_before, not_after, last_error, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(site_id) DO UPDATE SET
+			status = excluded.status, cert_path = excluded.cert_path, key_path = excluded.key_path,
+			issuer = excluded.issuer, serial = excluded.serial, not_before = excluded.not_before,
+			not_after = excluded.not_after, last_error = excluded.last_error, updated_at = CURRENT_TIMESTAMP`,
+		c.SiteID, c.Status, c.CertPath, c.KeyPath, c.Issuer, c.Serial, nullTime(c.NotBefore), nullTime(c.NotAfter), c.LastError,
+	)
+	return err
+}
+
+// GetCertificate returns the certificate record of a site, or nil
+func (db *Database) GetCertificate(siteID int64) (*Certificate, error) {
+	row := db.QueryRow("SELECT "+certificateColumns+" FROM certificates c JOIN sites s ON s.id = c.site_id WHERE c.site_id = ?", siteID)
+	c, err := scanCertificate(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// ListCertificates returns all certificate records, soonest expiry first
+func (db *Database) ListCertificates() ([]Certificate, error) {
+	rows, err := db.Query("SELECT " + certificateColumns + " FROM certificates c JOIN sites s ON s.id = c.site_id ORDER BY c.not_after IS NULL, c.not_after, s.domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certificates := make([]Certificate, 0)
+	for rows.Next() {
+		c, err := scanCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, *c)
+	}
+	return certificates, rows.Err()
+}
+
+func scanCertificate(row interface{ Scan(...interface{}) error }) (*Certificate, error) {
+	var c Certificate
+	var notBefore, notAfter, createdAt, updatedAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.SiteID, &c.Domain, &c.Status, &c.CertPath, &c.KeyPath, &c.Issuer, &c.Serial,
+		&notBefore, &notAfter, &c.LastError, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	c.NotBefore = notBefore.Time
+	c.NotAfter = notAfter.Time
+	c.CreatedAt = createdAt.Time
+	c.UpdatedAt = updatedAt.Time
+	return &c, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolConfigVersion is a pool's configuration as it was at a settings
+// revision, saved before the change that replaced it
+type PoolConfigVersion struct {
+	ID       int64
+	PoolID   int64
+	Revision int64
+	// Settings is the settings JSON and Config the rendered pool file
+	Settings  string
+	Config    string
+	Actor     string
+	RequestID string
+	CreatedAt time.Time
+}
+
+const poolConfigVersionColumns = "id, pool_id, revision, settings, config, actor, request_id, created_at"
+
+func scanPoolConfigVersion(row interface{ Scan(...interface{}) error }) (*PoolConfigVersion, error) {
+	var v PoolConfigVersion
+	if err := row.Scan(&v.ID, &v.PoolID, &v.Revision, &v.Settings, &v.Config, &v.Actor, &v.RequestID, &v.CreatedAt); err != nil {
+		return nil, err
+	}
+	var err error
+	if v.Settings, err = openSettings(v.Settings); err != nil {
+		return nil, err
+	}
+	if v.Config, err = openValue(v.Config); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// SavePoolConfigVersion records the configuration of a pool at a revision.
+// A revision already recorded is replaced. Env values in the settings and
+// pool files that set any are stored sealed.
+func (db *Database) SavePoolConfigVersion(v *PoolConfigVersion) error {
+	settings, err := sealSettings(v.Settings)
+	if err != nil {
+		return err
+	}
+	config, err := sealConfig(v.Config)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM pool_config_versions WHERE pool_id = ? AND revision = ?", v.PoolID, v.Revision); err != nil {
+		return err
+	}
+	_, err = db.insert(
+		"INSERT INTO pool_config_versions (pool_id, revision, settings, config, actor, request_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		v.PoolID, v.Revision, settings, config, v.Actor, v.RequestID, v.CreatedAt,
+	)
+	return err
+}
+
+// GetPoolConfigVersion returns a recorded revision, or nil if there is none
+func (db *Database) GetPoolConfigVersion(poolID, revision int64) (*PoolConfigVersion, error) {
+	v, err := scanPoolConfigVersion(db.QueryRow(
+		"SELECT "+poolConfigVersionColumns+" FROM pool_config_versions WHERE pool_id = ? AND revision = ?",
+		poolID, revision,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return v, err
+}
+
+// ListPoolConfigVersions returns the recorded revisions of a pool, newest
+// first
+func (db *Database) ListPoolConfigVersions(poolID int64) ([]PoolConfigVersion, error) {
+	rows, err := db.Query(
+		"SELECT "+poolConfigVersionColumns+" FROM pool_config_versions WHERE pool_id = ? ORDER BY revision DESC",
+		poolID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]PoolConfigVersion, 0)
+	for rows.Next() {
+		v, err := scanPoolConfigVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+	return versions, rows.Err()
+}
+
+// PrunePoolConfigVersions removes the revisions of a pool before revision
+func (db *Database) PrunePoolConfigVersions(poolID, revision int64) error {
+	_, err := db.Exec("DELETE FROM pool_config_versions WHERE pool_id = ? AND revision < ?", poolID, revision)
+	return err
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolCron is a cron job run as a pool's user
+type PoolCron struct {
+	ID        int64
+	Pool      string
+	Schedule  string
+	Command   string
+	CreatedAt time.Time
+}
+
+const poolCronColumns = "id, pool, schedule, command, created_at"
+
+func scanPoolCron(row interface{ Scan(...interface{}) error }) (*PoolCron, error) {
+	var c PoolCron
+	if err := row.Scan(&c.ID, &c.Pool, &c.Schedule, &c.Command, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// CreatePoolCron stores a cron job and returns its id
+func (db *Database) CreatePoolCron(c PoolCron) (int64, error) {
+	return db.insert(
+		"INSERT INTO pool_crons (pool, schedule, command, created_at) VALUES (?, ?, ?, ?)",
+		c.Pool, c.Schedule, c.Command, c.CreatedAt,
+	)
+}
+
+// GetPoolCron returns a cron job of a pool, or nil if it does not exist
+func (db *Database) GetPoolCron(pool string, id int64) (*PoolCron, error) {
+	c, err := scanPoolCron(db.QueryRow("SELECT "+poolCronColumns+" FROM pool_crons WHERE pool = ? AND id = ?", pool, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// ListPoolCrons returns the cron jobs of a pool in the order they were added
+func (db *Database) ListPoolCrons(pool string) ([]PoolCron, error) {
+	rows, err := db.Query("SELECT "+poolCronColumns+" FROM pool_crons WHERE pool = ? ORDER BY id", pool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var crons []PoolCron
+	for rows.Next() {
+		c, err := scanPoolCron(rows)
+		if err != nil {
+			return nil, err
+		}
+		crons = append(crons, *c)
+	}
+	return crons, rows.Err()
+}
+
+// DeletePoolCron removes a cron job
+func (db *Database) DeletePoolCron(pool string, id int64) error {
+	_, err := db.Exec("DELETE FROM pool_crons WHERE pool = ? AND id = ?", pool, id)
+	return err
+}
+
+// DeletePoolCrons removes every cron job of a pool
+func (db *Database) DeletePoolCrons(pool string) error {
+	_, err := db.Exec("DELETE FROM pool_crons WHERE pool = ?", pool)
+	return err
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"lightweight-php/config"
 
 	_ "modernc.org/sqlite"
 )
@@ -13,11 +16,56 @@ const (
 	DefaultDBPath = "/var/lib/lightweight-php/lightweight-php.db"
 )
 
+// Database is the state database. Queries of this package go through its
+// Exec, Query, QueryRow and Begin, which adapt them to the configured
+// database; the embedded *sql.DB's context variants do not.
 type Database struct {
 	*sql.DB
+	dialect dialect
+}
+
+// Tx is a transaction whose queries are adapted like the Database's
+type Tx struct {
+	*sql.Tx
+	dialect dialect
 }
 
+// NewDatabase opens the database and applies pending schema migrations
 func NewDatabase(dbPath string) (*Database, error) {
+	database, err := OpenDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := database.Migrate(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return database, nil
+}
+
+// OpenDatabase opens the database configured in database.driver without
+// touching the schema. For SQLite, dbPath ("" for DefaultDBPath) wins over
+// database.dsn so that development mode keeps its sandbox.
+func OpenDatabase(dbPath string) (*Database, error) {
+	cfg := config.Get().Database
+	d, err := dialectFor(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+	if d.name() == DriverSQLite {
+		if dbPath == "" {
+			dbPath = cfg.DSN
+		}
+		return OpenSQLite(dbPath)
+	}
+	return openServer(d, cfg.DSN)
+}
+
+// OpenSQLite opens a SQLite database file whatever the configured driver,
+// such as a snapshot being restored
+func OpenSQLite(dbPath string) (*Database, error) {
 	if dbPath == "" {
 		dbPath = DefaultDBPath
 	}
@@ -33,48 +81,115 @@ func NewDatabase(dbPath string) (*Database, error) {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	database := &Database{DB: db}
-	if err := database.initSchema(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	return &Database{DB: db, dialect: sqliteDialect{}}, nil
+}
+
+// openServer connects to a PostgreSQL or MySQL server
+func openServer(d dialect, dsn string) (*Database, error) {
+	if err := checkDriverRegistered(d); err != nil {
+		return nil, err
+	}
+	if d.name() == DriverMySQL && !strings.Contains(dsn, "parseTime=") {
+		// Times are scanned into time.Time like with the other drivers
+		if strings.Contains(dsn, "?") {
+			dsn += "&parseTime=true"
+		} else {
+			dsn += "?parseTime=true"
+		}
 	}
 
-	return database, nil
+	db, err := sql.Open(d.sqlDriver(), dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to the %s database: %w", d.name(), err)
+	}
+	return &Database{DB: db, dialect: d}, nil
 }
 
-func (db *Database) initSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS php_versions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		version TEXT NOT NULL UNIQUE,
-		installed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		status TEXT DEFAULT 'active',
-		package_manager TEXT,
-		os_family TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS pools (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT NOT NULL,
-		php_version TEXT NOT NULL,
-		provider TEXT NOT NULL DEFAULT 'remi',
-		socket_path TEXT NOT NULL,
-		config_path TEXT NOT NULL,
-		status TEXT DEFAULT 'active',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (php_version) REFERENCES php_versions(version),
-		UNIQUE(username, php_version, provider)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_pools_username ON pools(username);
-	CREATE INDEX IF NOT EXISTS idx_pools_php_version ON pools(php_version);
-	`
-
-	_, err := db.Exec(schema)
-	return err
+// Driver returns the database.driver value of the open database
+func (db *Database) Driver() string {
+	return db.dialect.name()
 }
 
 func (db *Database) Close() error {
 	return db.DB.Close()
 }
+
+func (db *Database) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.dialect.rebind(query), args...)
+}
+
+func (db *Database) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *Database) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *Database) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.dialect}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// insert runs an INSERT into a table with an id column and returns the new
+// row's id
+func (db *Database) insert(query string, args ...interface{}) (int64, error) {
+	if db.dialect.returningID() {
+		var id int64
+		err := db.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// SnapshotTo writes a consistent copy of the database to path, which must
+// not exist. Writers are not blocked while the copy is made. Only SQLite
+// databases can be snapshot; servers are backed up with their own tools.
+func (db *Database) SnapshotTo(path string) error {
+	if db.Driver() != DriverSQLite {
+		return fmt.Errorf("snapshots need a SQLite database; back up the %s database with its own tools", db.Driver())
+	}
+	if _, err := db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	return nil
+}
+
+// CheckIntegrity runs SQLite's integrity check. Database servers check
+// themselves, so it only verifies the connection there.
+func (db *Database) CheckIntegrity() error {
+	if db.Driver() != DriverSQLite {
+		return db.Ping()
+	}
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check database integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("database is corrupt: %s", result)
+	}
+	return nil
+}
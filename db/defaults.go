@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// poolDefaultsID is the id of the single row of pool_defaults
+const poolDefaultsID = 1
+
+// PoolDefaults are the settings every new pool starts from, before the
+// settings of its tenant and profile
+type PoolDefaults struct {
+	// Settings is the JSON of the pool settings
+	Settings  string
+	UpdatedAt time.Time
+}
+
+// GetPoolDefaults returns the default pool settings, or nil if none were
+// ever set
+func (db *Database) GetPoolDefaults() (*PoolDefaults, error) {
+	var d PoolDefaults
+	err := db.QueryRow("SELECT settings, updated_at FROM pool_defaults WHERE id = ?", poolDefaultsID).Scan(&d.Settings, &d.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Settings, err = openSettings(d.Settings); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// SavePoolDefaults replaces the default pool settings
+func (db *Database) SavePoolDefaults(settings string) error {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO pool_defaults (id, settings, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET settings = excluded.settings, updated_at = excluded.updated_at`,
+		poolDefaultsID, settings, time.Now().UTC(),
+	)
+	return err
+}
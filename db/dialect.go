@@ -0,0 +1,155 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Drivers accepted in database.driver
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMySQL    = "mysql"
+)
+
+// dialect adapts the queries of this package, which are written in the
+// SQL that SQLite, PostgreSQL and MySQL share, to one database. Queries use
+// ? placeholders, "..." to quote identifiers and SQLite's ON CONFLICT
+// upserts; rebind rewrites what a database does not understand.
+type dialect interface {
+	// name is the database.driver value
+	name() string
+	// sqlDriver is the database/sql driver the dialect connects with
+	sqlDriver() string
+	rebind(query string) string
+	// returningID reports whether an insert must ask for the new row's id
+	// with RETURNING, since the driver has no LastInsertId
+	returningID() bool
+	// baseline is the schema of baselineVersion for a new database; ""
+	// means the database is built by running every migration
+	baseline() string
+	// migrationSQL returns a migration's SQL for the dialect
+	migrationSQL(m Migration) string
+	// timestampType is the column type of times
+	timestampType() string
+}
+
+// dialectFor returns the dialect of a database.driver value
+func dialectFor(driver string) (dialect, error) {
+	switch driver {
+	case "", DriverSQLite:
+		return sqliteDialect{}, nil
+	case DriverPostgres:
+		return postgresDialect{}, nil
+	case DriverMySQL:
+		return mysqlDialect{}, nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q; use %s, %s or %s", driver, DriverSQLite, DriverPostgres, DriverMySQL)
+}
+
+// checkDriverRegistered returns an error unless the binary was built with
+// the dialect's database/sql driver
+func checkDriverRegistered(d dialect) error {
+	for _, name := range sql.Drivers() {
+		if name == d.sqlDriver() {
+			return nil
+		}
+	}
+	return fmt.Errorf("this binary was built without the %s driver; rebuild it with -tags %s", d.name(), d.name())
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string                    { return DriverSQLite }
+func (sqliteDialect) sqlDriver() string               { return "sqlite" }
+func (sqliteDialect) rebind(query string) string      { return query }
+func (sqliteDialect) returningID() bool               { return false }
+func (sqliteDialect) baseline() string                { return "" }
+func (sqliteDialect) migrationSQL(m Migration) string { return m.SQL }
+func (sqliteDialect) timestampType() string           { return "DATETIME" }
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string      { return DriverPostgres }
+func (postgresDialect) sqlDriver() string { return "pgx" }
+func (postgresDialect) returningID() bool { return true }
+func (postgresDialect) baseline() string  { return postgresBaseline }
+
+func (postgresDialect) migrationSQL(m Migration) string { return m.Postgres }
+func (postgresDialect) timestampType() string           { return "TIMESTAMP" }
+
+// rebind numbers the placeholders: PostgreSQL takes $1, $2, ... instead
+// of ?. ON CONFLICT and "..." are PostgreSQL syntax already.
+func (postgresDialect) rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) name() string      { return DriverMySQL }
+func (mysqlDialect) sqlDriver() string { return "mysql" }
+func (mysqlDialect) returningID() bool { return false }
+func (mysqlDialect) baseline() string  { return mysqlBaseline }
+
+func (mysqlDialect) migrationSQL(m Migration) string { return m.MySQL }
+func (mysqlDialect) timestampType() string           { return "DATETIME" }
+
+var (
+	onConflictPattern = regexp.MustCompile(`ON CONFLICT\s*\([^)]*\)\s*DO UPDATE SET`)
+	excludedPattern   = regexp.MustCompile(`excluded\.(\w+)`)
+)
+
+// rebind turns upserts into ON DUPLICATE KEY UPDATE, which applies to the
+// same unique keys here since each table has one besides its primary key,
+// and quotes identifiers with backticks
+func (mysqlDialect) rebind(query string) string {
+	query = onConflictPattern.ReplaceAllString(query, "ON DUPLICATE KEY UPDATE")
+	query = excludedPattern.ReplaceAllString(query, "VALUES($1)")
+	if !strings.Contains(query, `"`) {
+		return query
+	}
+	var b strings.Builder
+	inString := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inString = !inString
+		case c == '"' && !inString:
+			c = '`'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// splitStatements splits a schema into its statements for drivers that
+// run one statement per call
+func splitStatements(schema string) []string {
+	var statements []string
+	for _, s := range strings.Split(schema, ";\n") {
+		if s = strings.TrimSpace(s); s != "" {
+			statements = append(statements, s)
+		}
+	}
+	return statements
+}
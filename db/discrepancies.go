@@ -0,0 +1,60 @@
+package db
+
+import "time"
+
+// Discrepancy is a difference between the database and the host found by
+// reconciliation. It is kept while it lasts.
+type Discrepancy struct {
+	Kind    string
+	Subject string
+	// Username is the pool the discrepancy concerns, if it concerns one
+	Username string
+	Message  string
+	// HealError is why the last attempt to heal it failed
+	HealError   string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
+}
+
+// SaveDiscrepancy records a discrepancy, or updates the message, heal
+// error and last sighting of a known one
+func (db *Database) SaveDiscrepancy(d Discrepancy) error {
+	_, err := db.Exec(`
+		INSERT INTO discrepancies (kind, subject, username, message, heal_error, first_seen_at, last_seen_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, subject) DO UPDATE SET
+			username = excluded.username,
+			message = excluded.message,
+			heal_error = excluded.heal_error,
+			last_seen_at = excluded.last_seen_at`,
+		d.Kind, d.Subject, d.Username, d.Message, d.HealError, d.FirstSeenAt.UTC(), d.LastSeenAt.UTC(),
+	)
+	return err
+}
+
+// DeleteDiscrepancy forgets a discrepancy that was resolved or healed
+func (db *Database) DeleteDiscrepancy(kind, subject string) error {
+	_, err := db.Exec("DELETE FROM discrepancies WHERE kind = ? AND subject = ?", kind, subject)
+	return err
+}
+
+// ListDiscrepancies returns the open discrepancies, oldest first
+func (db *Database) ListDiscrepancies() ([]Discrepancy, error) {
+	rows, err := db.Query(`
+		SELECT kind, subject, username, message, heal_error, first_seen_at, last_seen_at
+		FROM discrepancies ORDER BY first_seen_at, kind, subject`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var discrepancies []Discrepancy
+	for rows.Next() {
+		var d Discrepancy
+		if err := rows.Scan(&d.Kind, &d.Subject, &d.Username, &d.Message, &d.HealError, &d.FirstSeenAt, &d.LastSeenAt); err != nil {
+			return nil, err
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	return discrepancies, rows.Err()
+}
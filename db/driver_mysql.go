@@ -0,0 +1,7 @@
+//go:build mysql
+
+package db
+
+// The MySQL driver is only compiled in with -tags mysql, after adding it to
+// go.mod with: go get github.com/go-sql-driver/mysql
+import _ "github.com/go-sql-driver/mysql"
@@ -0,0 +1,7 @@
+//go:build postgres
+
+package db
+
+// The PostgreSQL driver is only compiled in with -tags postgres, after
+// adding it to go.mod with: go get github.com/jackc/pgx/v5
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AccountErasure records that an account's data was erased. The account is
+// identified only by a hash of its username; Report holds the pseudonymous
+// summary as JSON.
+type AccountErasure struct {
+	ID          int64
+	SubjectHash string
+	Report      string
+	CreatedAt   time.Time
+}
+
+const erasureColumns = "id, subject_hash, report, created_at"
+
+func scanErasure(row interface{ Scan(...interface{}) error }) (*AccountErasure, error) {
+	var e AccountErasure
+	var createdAt sql.NullTime
+	if err := row.Scan(&e.ID, &e.SubjectHash, &e.Report, &createdAt); err != nil {
+		return nil, err
+	}
+	if createdAt.Valid {
+		e.CreatedAt = createdAt.Time
+	}
+	return &e, nil
+}
+
+func (db *Database) CreateErasure(subjectHash, report string) (int64, error) {
+	return db.insert(
+		"INSERT INTO account_erasures (subject_hash, report) VALUES (?, ?)",
+		subjectHash, report,
+	)
+}
+
+// UpdateErasureReport replaces the report of an erasure record
+func (db *Database) UpdateErasureReport(id int64, report string) error {
+	_, err := db.Exec("UPDATE account_erasures SET report = ? WHERE id = ?", report, id)
+	return err
+}
+
+// ListErasures returns erasure records, oldest first; subjectHash "" lists all
+func (db *Database) ListErasures(subjectHash string) ([]AccountErasure, error) {
+	query := "SELECT " + erasureColumns + " FROM account_erasures"
+	var args []interface{}
+	if subjectHash != "" {
+		query += " WHERE subject_hash = ?"
+		args = append(args, subjectHash)
+	}
+	rows, err := db.Query(query+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	erasures := make([]AccountErasure, 0)
+	for rows.Next() {
+		e, err := scanErasure(rows)
+		if err != nil {
+			return nil, err
+		}
+		erasures = append(erasures, *e)
+	}
+	return erasures, rows.Err()
+}
+
+func (db *Database) DeleteErasure(id int64) error {
+	_, err := db.Exec("DELETE FROM account_erasures WHERE id = ?", id)
+	return err
+}
+
+// Vacuum rebuilds the database file so deleted rows do not linger in free
+// pages. MySQL rebuilds the tables erasures delete from instead.
+func (db *Database) Vacuum() error {
+	if db.Driver() == DriverMySQL {
+		_, err := db.Exec("OPTIMIZE TABLE pools, sites, site_pools, managed_users, audit_log, render_failures, scheduled_changes")
+		return err
+	}
+	_, err := db.Exec("VACUUM")
+	return err
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Install log statuses
+const (
+	InstallRunning = "running"
+	InstallSuccess = "success"
+	InstallFailed  = "failed"
+)
+
+// InstallLog is the recorded output of one repository setup and package
+// install run by a provider
+type InstallLog struct {
+	ID        int64
+	Provider  string
+	Version   string
+	Operation string
+	Target    string
+	Status    string
+	Output    string
+	Error     string
+	// Rollback is the JSON list of changes undone after a failure, or ""
+	Rollback   string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+const installLogColumns = "id, provider, version, operation, target, status, output, error, rollback, started_at, finished_at"
+
+func scanInstallLog(row interface{ Scan(...interface{}) error }) (*InstallLog, error) {
+	var l InstallLog
+	var startedAt, finishedAt sql.NullTime
+	if err := row.Scan(&l.ID, &l.Provider, &l.Version, &l.Operation, &l.Target, &l.Status, &l.Output, &l.Error, &l.Rollback, &startedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		l.StartedAt = startedAt.Time
+	}
+	if finishedAt.Valid {
+		l.FinishedAt = &finishedAt.Time
+	}
+	return &l, nil
+}
+
+// CreateInstallLog records the start of an install
+func (db *Database) CreateInstallLog(provider, version, operation, target string, startedAt time.Time) (int64, error) {
+	return db.insert(
+		"INSERT INTO install_logs (provider, version, operation, target, status, started_at) VALUES (?, ?, ?, ?, ?, ?)",
+		provider, version, operation, target, InstallRunning, startedAt,
+	)
+}
+
+// UpdateInstallLogOutput stores the output of a running install so far
+func (db *Database) UpdateInstallLogOutput(id int64, output string) error {
+	_, err := db.Exec("UPDATE install_logs SET output = ? WHERE id = ? AND status = ?", output, id, InstallRunning)
+	return err
+}
+
+// FinishInstallLog stores the outcome and output of an install, and the
+// changes undone if it was rolled back
+func (db *Database) FinishInstallLog(id int64, status, output, errMsg, rollback string, finishedAt time.Time) error {
+	_, err := db.Exec(
+		"UPDATE install_logs SET status = ?, output = ?, error = ?, rollback = ?, finished_at = ? WHERE id = ?",
+		status, output, errMsg, rollback, finishedAt, id,
+	)
+	return err
+}
+
+// GetInstallLog returns an install log, or nil if it does not exist
+func (db *Database) GetInstallLog(id int64) (*InstallLog, error) {
+	l, err := scanInstallLog(db.QueryRow("SELECT "+installLogColumns+" FROM install_logs WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return l, err
+}
+
+// ListInstallLogs returns a provider's install logs, newest first. Output
+// is left empty; limit 0 returns all of them.
+func (db *Database) ListInstallLogs(provider string, limit int) ([]InstallLog, error) {
+	query := "SELECT id, provider, version, operation, target, status, '', error, rollback, started_at, finished_at FROM install_logs WHERE provider = ? ORDER BY id DESC"
+	args := []interface{}{provider}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := make([]InstallLog, 0)
+	for rows.Next() {
+		l, err := scanInstallLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, *l)
+	}
+	return logs, rows.Err()
+}
+
+// PruneInstallLogs keeps the newest keep logs of a provider
+func (db *Database) PruneInstallLogs(provider string, keep int) error {
+	_, err := db.Exec(
+		`DELETE FROM install_logs WHERE provider = ? AND id NOT IN
+		 (SELECT id FROM install_logs WHERE provider = ? ORDER BY id DESC LIMIT ?)`,
+		provider, provider, keep,
+	)
+	return err
+}
@@ -0,0 +1,67 @@
+package db
+
+// GetPoolLabels returns the labels of a pool
+func (db *Database) GetPoolLabels(poolID int64) (map[string]string, error) {
+	rows, err := db.Query(`SELECT "key", value FROM pool_labels WHERE pool_id = ?`, poolID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		labels[key] = value
+	}
+	return labels, rows.Err()
+}
+
+// ListPoolLabels returns the labels of every labelled pool by pool ID
+func (db *Database) ListPoolLabels() (map[int64]map[string]string, error) {
+	rows, err := db.Query(`SELECT pool_id, "key", value FROM pool_labels`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	labels := make(map[int64]map[string]string)
+	for rows.Next() {
+		var poolID int64
+		var key, value string
+		if err := rows.Scan(&poolID, &key, &value); err != nil {
+			return nil, err
+		}
+		if labels[poolID] == nil {
+			labels[poolID] = make(map[string]string)
+		}
+		labels[poolID][key] = value
+	}
+	return labels, rows.Err()
+}
+
+// UpdatePoolLabels sets and removes labels of a pool in one transaction
+func (db *Database) UpdatePoolLabels(poolID int64, set map[string]string, remove []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range remove {
+		if _, err := tx.Exec(`DELETE FROM pool_labels WHERE pool_id = ? AND "key" = ?`, poolID, key); err != nil {
+			return err
+		}
+	}
+	for key, value := range set {
+		if _, err := tx.Exec(
+			`INSERT INTO pool_labels (pool_id, "key", value) VALUES (?, ?, ?) ON CONFLICT(pool_id, "key") DO UPDATE SET value = excluded.value`,
+			poolID, key, value,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,976 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"lightweight-php/lock"
+)
+
+// Migration is one numbered schema change. Versions are applied in order and
+// must never be edited once released; add a new migration instead.
+type Migration struct {
+	Version     int
+	Description string
+	// SQL is the change for SQLite
+	SQL string
+	// Postgres and MySQL are the change for those databases. Migrations up
+	// to baselineVersion have none, since those databases start from a
+	// baseline schema.
+	Postgres string
+	MySQL    string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations is the ordered schema history. The first two use IF NOT EXISTS
+// so databases created before versioning are adopted without changes.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "php versions and pools",
+		SQL: `
+		CREATE TABLE IF NOT EXISTS php_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			version TEXT NOT NULL UNIQUE,
+			installed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			status TEXT DEFAULT 'active',
+			package_manager TEXT,
+			os_family TEXT
+		);
+
+		CREATE TABLE IF NOT EXISTS pools (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			php_version TEXT NOT NULL,
+			provider TEXT NOT NULL DEFAULT 'remi',
+			socket_path TEXT NOT NULL,
+			config_path TEXT NOT NULL,
+			status TEXT DEFAULT 'active',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (php_version) REFERENCES php_versions(version),
+			UNIQUE(username, php_version, provider)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_pools_username ON pools(username);
+		CREATE INDEX IF NOT EXISTS idx_pools_php_version ON pools(php_version);
+		`,
+	},
+	{
+		Version:     2,
+		Description: "sites and path bindings",
+		SQL: `
+		CREATE TABLE IF NOT EXISTS sites (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			domain TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			document_root TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS site_pools (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			site_id INTEGER NOT NULL,
+			path_prefix TEXT NOT NULL DEFAULT '/',
+			pool_id INTEGER NOT NULL,
+			FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE,
+			UNIQUE(site_id, path_prefix)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_sites_username ON sites(username);
+		`,
+	},
+	{
+		Version:     3,
+		Description: "pool settings with revision",
+		SQL: `
+		ALTER TABLE pools ADD COLUMN settings TEXT NOT NULL DEFAULT '{}';
+		ALTER TABLE pools ADD COLUMN settings_revision INTEGER NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		Version:     4,
+		Description: "pool profiles",
+		SQL: `
+		CREATE TABLE pool_profiles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			settings TEXT NOT NULL DEFAULT '{}',
+			builtin INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		INSERT INTO pool_profiles (name, description, settings, builtin) VALUES
+			('wordpress', 'WordPress and similar CMS sites',
+			 '{"max_children":20,"start_servers":4,"min_spare_servers":2,"max_spare_servers":6,"max_requests":500,"memory_limit":"256M","max_execution_time":120,"upload_max_filesize":"64M","post_max_size":"64M","opcache_memory_consumption":128,"opcache_max_accelerated_files":10000}', 1),
+			('laravel', 'Laravel and Symfony applications',
+			 '{"max_children":30,"start_servers":5,"min_spare_servers":3,"max_spare_servers":10,"max_requests":1000,"memory_limit":"512M","max_execution_time":60,"opcache_memory_consumption":256,"opcache_max_accelerated_files":20000}', 1),
+			('highmem', 'Memory-hungry workloads such as imports and reporting',
+			 '{"max_children":10,"start_servers":2,"min_spare_servers":1,"max_spare_servers":4,"max_requests":200,"memory_limit":"1024M","max_execution_time":300,"upload_max_filesize":"256M","post_max_size":"256M","opcache_memory_consumption":256}', 1);
+		`,
+	},
+	{
+		Version:     5,
+		Description: "pool execution target",
+		SQL: `
+		ALTER TABLE pools ADD COLUMN target TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		Version:     6,
+		Description: "account erasure records",
+		SQL: `
+		CREATE TABLE account_erasures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			subject_hash TEXT NOT NULL,
+			report TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX idx_account_erasures_subject ON account_erasures(subject_hash);
+		`,
+	},
+	{
+		Version:     7,
+		Description: "TCP listener port allocations",
+		SQL: `
+		CREATE TABLE pool_ports (
+			port INTEGER PRIMARY KEY,
+			pool_id INTEGER NOT NULL UNIQUE,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     8,
+		Description: "provider install logs",
+		SQL: `
+		CREATE TABLE install_logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			provider TEXT NOT NULL,
+			version TEXT NOT NULL,
+			operation TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME
+		);
+		CREATE INDEX idx_install_logs_provider ON install_logs(provider, id);
+		`,
+	},
+	{
+		Version:     9,
+		Description: "pool labels",
+		SQL: `
+		CREATE TABLE pool_labels (
+			pool_id INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (pool_id, key),
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_pool_labels_key ON pool_labels(key, value);
+		`,
+	},
+	{
+		Version:     10,
+		Description: "scheduled pool changes",
+		SQL: `
+		CREATE TABLE scheduled_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			settings TEXT NOT NULL DEFAULT '',
+			php_version TEXT NOT NULL DEFAULT '',
+			run_at DATETIME NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			finished_at DATETIME
+		);
+		CREATE INDEX idx_scheduled_changes_status ON scheduled_changes(status, run_at);
+		`,
+	},
+	{
+		Version:     11,
+		Description: "system users created with pools",
+		SQL: `
+		CREATE TABLE managed_users (
+			username TEXT PRIMARY KEY,
+			target TEXT NOT NULL DEFAULT '',
+			uid INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		`,
+	},
+	{
+		Version:     12,
+		Description: "register PHP versions of orphaned pools",
+		// Pools created while foreign keys were not enforced may reference a
+		// version without a php_versions row, which would let the version be
+		// removed from under them
+		SQL: `
+		INSERT OR IGNORE INTO php_versions (version, package_manager, status)
+			SELECT php_version, MIN(provider), 'active' FROM pools
+			WHERE php_version NOT IN (SELECT version FROM php_versions)
+			GROUP BY php_version;
+		`,
+	},
+	{
+		Version:     13,
+		Description: "template render failures",
+		SQL: `
+		CREATE TABLE render_failures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			template TEXT NOT NULL,
+			source TEXT NOT NULL,
+			username TEXT NOT NULL DEFAULT '',
+			line INTEGER NOT NULL DEFAULT 0,
+			field TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL,
+			data TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX idx_render_failures_username ON render_failures(username);
+		`,
+	},
+	{
+		Version:     14,
+		Description: "site certificates",
+		SQL: `
+		CREATE TABLE certificates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			site_id INTEGER NOT NULL UNIQUE,
+			status TEXT NOT NULL,
+			cert_path TEXT NOT NULL DEFAULT '',
+			key_path TEXT NOT NULL DEFAULT '',
+			issuer TEXT NOT NULL DEFAULT '',
+			serial TEXT NOT NULL DEFAULT '',
+			not_before DATETIME,
+			not_after DATETIME,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     15,
+		Description: "pool suspensions",
+		SQL: `
+		CREATE TABLE pool_suspensions (
+			pool_id INTEGER PRIMARY KEY,
+			reason TEXT NOT NULL DEFAULT '',
+			previous_status TEXT NOT NULL,
+			suspended_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     16,
+		Description: "audit log",
+		SQL: `
+		CREATE TABLE audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME NOT NULL,
+			request_id TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL,
+			remote TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX idx_audit_log_request_id ON audit_log(request_id);
+		CREATE INDEX idx_audit_log_target ON audit_log(target);
+		`,
+	},
+	{
+		Version:     17,
+		Description: "install rollbacks",
+		SQL: `
+		ALTER TABLE install_logs ADD COLUMN rollback TEXT NOT NULL DEFAULT '';
+		`,
+	},
+	{
+		Version:     18,
+		Description: "pool activity",
+		SQL: `
+		CREATE TABLE pool_activity (
+			pool_id INTEGER PRIMARY KEY,
+			start_time INTEGER NOT NULL,
+			accepted_conn INTEGER NOT NULL,
+			last_request_at DATETIME NOT NULL,
+			checked_at DATETIME NOT NULL,
+			converted_at DATETIME,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     19,
+		Description: "tenants",
+		SQL: `
+		CREATE TABLE tenants (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			settings TEXT NOT NULL DEFAULT '{}',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		ALTER TABLE pools ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+		ALTER TABLE php_versions ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+		CREATE INDEX idx_pools_tenant ON pools(tenant);
+		`,
+	},
+	{
+		Version:     20,
+		Description: "pool config history",
+		SQL: `
+		CREATE TABLE pool_config_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pool_id INTEGER NOT NULL,
+			revision INTEGER NOT NULL,
+			settings TEXT NOT NULL,
+			config TEXT NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE,
+			UNIQUE(pool_id, revision)
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_config_versions (
+			id BIGSERIAL PRIMARY KEY,
+			pool_id BIGINT NOT NULL REFERENCES pools(id) ON DELETE CASCADE,
+			revision BIGINT NOT NULL,
+			settings TEXT NOT NULL,
+			config TEXT NOT NULL,
+			actor TEXT NOT NULL DEFAULT '',
+			request_id TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE(pool_id, revision)
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_config_versions (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			pool_id BIGINT NOT NULL,
+			revision BIGINT NOT NULL,
+			settings TEXT NOT NULL,
+			config TEXT NOT NULL,
+			actor VARCHAR(255) NOT NULL DEFAULT '',
+			request_id VARCHAR(255) NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE,
+			UNIQUE(pool_id, revision)
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     21,
+		Description: "pool bursts",
+		SQL: `
+		CREATE TABLE pool_bursts (
+			pool_id INTEGER PRIMARY KEY,
+			base_max_children INTEGER NOT NULL,
+			max_children INTEGER NOT NULL,
+			raised_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_bursts (
+			pool_id BIGINT PRIMARY KEY REFERENCES pools(id) ON DELETE CASCADE,
+			base_max_children BIGINT NOT NULL,
+			max_children BIGINT NOT NULL,
+			raised_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_bursts (
+			pool_id BIGINT PRIMARY KEY,
+			base_max_children BIGINT NOT NULL,
+			max_children BIGINT NOT NULL,
+			raised_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     22,
+		Description: "pool defaults",
+		SQL: `
+		CREATE TABLE pool_defaults (
+			id INTEGER PRIMARY KEY,
+			settings TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_defaults (
+			id BIGINT PRIMARY KEY,
+			settings TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_defaults (
+			id BIGINT PRIMARY KEY,
+			settings TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     23,
+		Description: "recurring schedules",
+		SQL: `
+		CREATE TABLE schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			task TEXT NOT NULL,
+			cron TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE schedule_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			schedule TEXT NOT NULL,
+			task TEXT NOT NULL,
+			status TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME
+		);
+		CREATE INDEX idx_schedule_runs_schedule ON schedule_runs(schedule, id);
+		`,
+		Postgres: `
+		CREATE TABLE schedules (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			task TEXT NOT NULL,
+			cron TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE schedule_runs (
+			id BIGSERIAL PRIMARY KEY,
+			schedule TEXT NOT NULL,
+			task TEXT NOT NULL,
+			status TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			started_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP
+		);
+		CREATE INDEX idx_schedule_runs_schedule ON schedule_runs(schedule, id);
+		`,
+		MySQL: `
+		CREATE TABLE schedules (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(64) NOT NULL UNIQUE,
+			task VARCHAR(64) NOT NULL,
+			cron VARCHAR(255) NOT NULL,
+			created_at DATETIME NOT NULL
+		) ENGINE=InnoDB;
+		CREATE TABLE schedule_runs (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			schedule VARCHAR(64) NOT NULL,
+			task VARCHAR(64) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			output TEXT NOT NULL DEFAULT (''),
+			error TEXT NOT NULL DEFAULT (''),
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_schedule_runs_schedule ON schedule_runs(schedule, id);
+		`,
+	},
+	{
+		Version:     24,
+		Description: "pool databases",
+		SQL: `
+		CREATE TABLE pool_databases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pool TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			username TEXT NOT NULL,
+			user_host TEXT NOT NULL,
+			password TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_databases (
+			id BIGSERIAL PRIMARY KEY,
+			pool TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			username TEXT NOT NULL,
+			user_host TEXT NOT NULL,
+			password TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_databases (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			pool VARCHAR(64) NOT NULL UNIQUE,
+			name VARCHAR(64) NOT NULL,
+			username VARCHAR(64) NOT NULL,
+			user_host VARCHAR(255) NOT NULL,
+			password TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     25,
+		Description: "pool workers",
+		SQL: `
+		CREATE TABLE pool_workers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pool TEXT NOT NULL,
+			name TEXT NOT NULL,
+			command TEXT NOT NULL,
+			directory TEXT NOT NULL,
+			processes INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE(pool, name)
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_workers (
+			id BIGSERIAL PRIMARY KEY,
+			pool TEXT NOT NULL,
+			name TEXT NOT NULL,
+			command TEXT NOT NULL,
+			directory TEXT NOT NULL,
+			processes INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE(pool, name)
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_workers (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			pool VARCHAR(64) NOT NULL,
+			name VARCHAR(64) NOT NULL,
+			command TEXT NOT NULL,
+			directory VARCHAR(1024) NOT NULL,
+			processes INT NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE(pool, name)
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     26,
+		Description: "pool cron jobs",
+		SQL: `
+		CREATE TABLE pool_crons (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pool TEXT NOT NULL,
+			schedule TEXT NOT NULL,
+			command TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX idx_pool_crons_pool ON pool_crons(pool);
+		`,
+		Postgres: `
+		CREATE TABLE pool_crons (
+			id BIGSERIAL PRIMARY KEY,
+			pool TEXT NOT NULL,
+			schedule TEXT NOT NULL,
+			command TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX idx_pool_crons_pool ON pool_crons(pool);
+		`,
+		MySQL: `
+		CREATE TABLE pool_crons (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			pool VARCHAR(64) NOT NULL,
+			schedule VARCHAR(255) NOT NULL,
+			command TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			INDEX idx_pool_crons_pool (pool)
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     27,
+		Description: "site php values",
+		SQL: `
+		CREATE TABLE site_php_values (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			site_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+			UNIQUE(site_id, name)
+		);
+		`,
+		Postgres: `
+		CREATE TABLE site_php_values (
+			id BIGSERIAL PRIMARY KEY,
+			site_id BIGINT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			UNIQUE(site_id, name)
+		);
+		`,
+		MySQL: `
+		CREATE TABLE site_php_values (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			site_id BIGINT NOT NULL,
+			name VARCHAR(64) NOT NULL,
+			value TEXT NOT NULL,
+			UNIQUE(site_id, name),
+			FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     28,
+		Description: "pool usage",
+		SQL: `
+		CREATE TABLE pool_usage (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			pool TEXT NOT NULL,
+			period TEXT NOT NULL,
+			cpu_seconds REAL NOT NULL DEFAULT 0,
+			peak_workers INTEGER NOT NULL DEFAULT 0,
+			bandwidth_bytes INTEGER NOT NULL DEFAULT 0,
+			disk_bytes_total INTEGER NOT NULL DEFAULT 0,
+			disk_samples INTEGER NOT NULL DEFAULT 0,
+			peak_disk_bytes INTEGER NOT NULL DEFAULT 0,
+			samples INTEGER NOT NULL DEFAULT 0,
+			first_sample_at DATETIME NOT NULL,
+			last_sample_at DATETIME NOT NULL,
+			UNIQUE(pool, period)
+		);
+		CREATE TABLE billing_exports (
+			period TEXT PRIMARY KEY,
+			exporter TEXT NOT NULL,
+			exported_at DATETIME NOT NULL
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_usage (
+			id BIGSERIAL PRIMARY KEY,
+			pool TEXT NOT NULL,
+			period TEXT NOT NULL,
+			cpu_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+			peak_workers BIGINT NOT NULL DEFAULT 0,
+			bandwidth_bytes BIGINT NOT NULL DEFAULT 0,
+			disk_bytes_total BIGINT NOT NULL DEFAULT 0,
+			disk_samples BIGINT NOT NULL DEFAULT 0,
+			peak_disk_bytes BIGINT NOT NULL DEFAULT 0,
+			samples BIGINT NOT NULL DEFAULT 0,
+			first_sample_at TIMESTAMP NOT NULL,
+			last_sample_at TIMESTAMP NOT NULL,
+			UNIQUE(pool, period)
+		);
+		CREATE TABLE billing_exports (
+			period TEXT PRIMARY KEY,
+			exporter TEXT NOT NULL,
+			exported_at TIMESTAMP NOT NULL
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_usage (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			pool VARCHAR(64) NOT NULL,
+			period VARCHAR(7) NOT NULL,
+			cpu_seconds DOUBLE NOT NULL DEFAULT 0,
+			peak_workers BIGINT NOT NULL DEFAULT 0,
+			bandwidth_bytes BIGINT NOT NULL DEFAULT 0,
+			disk_bytes_total BIGINT NOT NULL DEFAULT 0,
+			disk_samples BIGINT NOT NULL DEFAULT 0,
+			peak_disk_bytes BIGINT NOT NULL DEFAULT 0,
+			samples BIGINT NOT NULL DEFAULT 0,
+			first_sample_at DATETIME NOT NULL,
+			last_sample_at DATETIME NOT NULL,
+			UNIQUE(pool, period)
+		) ENGINE=InnoDB;
+		CREATE TABLE billing_exports (
+			period VARCHAR(7) PRIMARY KEY,
+			exporter VARCHAR(64) NOT NULL,
+			exported_at DATETIME NOT NULL
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     29,
+		Description: "provisioning packages and idempotency keys",
+		SQL: `
+		CREATE TABLE pool_packages (
+			pool_id INTEGER PRIMARY KEY,
+			package TEXT NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		);
+		CREATE TABLE idempotency_keys (
+			idempotency_key TEXT PRIMARY KEY,
+			request_hash TEXT NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			response TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+		`,
+		Postgres: `
+		CREATE TABLE pool_packages (
+			pool_id BIGINT PRIMARY KEY REFERENCES pools(id) ON DELETE CASCADE,
+			package TEXT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE idempotency_keys (
+			idempotency_key TEXT PRIMARY KEY,
+			request_hash TEXT NOT NULL,
+			status INTEGER NOT NULL DEFAULT 0,
+			response TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		);
+		`,
+		MySQL: `
+		CREATE TABLE pool_packages (
+			pool_id BIGINT PRIMARY KEY,
+			package VARCHAR(64) NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+		CREATE TABLE idempotency_keys (
+			idempotency_key VARCHAR(255) PRIMARY KEY,
+			request_hash VARCHAR(64) NOT NULL,
+			status INT NOT NULL DEFAULT 0,
+			response MEDIUMTEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		) ENGINE=InnoDB;
+		`,
+	},
+	{
+		Version:     30,
+		Description: "reconciliation discrepancies",
+		SQL: `
+		CREATE TABLE discrepancies (
+			kind TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			username TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL,
+			heal_error TEXT NOT NULL DEFAULT '',
+			first_seen_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			PRIMARY KEY (kind, subject)
+		);
+		`,
+		Postgres: `
+		CREATE TABLE discrepancies (
+			kind TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			username TEXT NOT NULL DEFAULT '',
+			message TEXT NOT NULL,
+			heal_error TEXT NOT NULL DEFAULT '',
+			first_seen_at TIMESTAMP NOT NULL,
+			last_seen_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (kind, subject)
+		);
+		`,
+		MySQL: `
+		CREATE TABLE discrepancies (
+			kind VARCHAR(32) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			username VARCHAR(64) NOT NULL DEFAULT '',
+			message TEXT NOT NULL,
+			heal_error TEXT NOT NULL,
+			first_seen_at DATETIME NOT NULL,
+			last_seen_at DATETIME NOT NULL,
+			PRIMARY KEY (kind, subject)
+		) ENGINE=InnoDB;
+		`,
+	},
+}
+
+// schemaVersionTable is formatted with the dialect's timestamp type
+const schemaVersionTable = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL,
+	applied_at %s DEFAULT CURRENT_TIMESTAMP
+)`
+
+// LatestSchemaVersion is the schema version this binary expects
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the highest applied migration, or 0 for a new database
+func (db *Database) SchemaVersion() (int, error) {
+	if _, err := db.Exec(fmt.Sprintf(schemaVersionTable, db.dialect.timestampType())); err != nil {
+		return 0, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Migrate applies pending migrations in order, each in its own transaction,
+// and returns the ones applied. A database newer than this binary is refused.
+func (db *Database) Migrate() ([]Migration, error) {
+	current, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than this binary supports (%d); upgrade lightweight-php", current, LatestSchemaVersion())
+	}
+	if current == LatestSchemaVersion() {
+		return nil, nil
+	}
+
+	// The CLI and API server may start at the same time
+	l, err := lock.Default.Acquire("db-migrate", "migrate database schema", true, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	if current, err = db.SchemaVersion(); err != nil {
+		return nil, err
+	}
+
+	applied := make([]Migration, 0)
+	if current == 0 && db.dialect.baseline() != "" {
+		if err := db.applyBaseline(); err != nil {
+			return applied, fmt.Errorf("failed to create the %s schema: %w", db.Driver(), err)
+		}
+		for _, m := range migrations {
+			if m.Version <= baselineVersion {
+				applied = append(applied, m)
+			}
+		}
+		current = baselineVersion
+	}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := db.applyMigration(m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+func (db *Database) applyMigration(m Migration) error {
+	statement := db.dialect.migrationSQL(m)
+	if statement == "" {
+		return fmt.Errorf("no SQL for %s", db.Driver())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if db.Driver() == DriverSQLite {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	} else {
+		for _, s := range splitStatements(statement) {
+			if _, err := tx.Exec(s); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.Version, m.Description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyBaseline creates the schema of baselineVersion on a new PostgreSQL
+// or MySQL database and records the migrations it stands for
+func (db *Database) applyBaseline() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range splitStatements(db.dialect.baseline()) {
+		if _, err := tx.Exec(s); err != nil {
+			return err
+		}
+	}
+	for _, m := range migrations {
+		if m.Version > baselineVersion {
+			break
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.Version, m.Description); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (db *Database) MigrationStatus() ([]MigrationStatus, error) {
+	if _, err := db.SchemaVersion(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema versions: %w", err)
+	}
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at sql.NullTime
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at.Time
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		at, ok := appliedAt[m.Version]
+		statuses = append(statuses, MigrationStatus{
+			Migration: m,
+			Applied:   ok,
+			AppliedAt: at,
+		})
+	}
+	return statuses, nil
+}
@@ -12,6 +12,8 @@ type PHPVersion struct {
 	Status         string
 	PackageManager string
 	OSFamily       string
+	// Tenant owns the version; "" shares it between all tenants
+	Tenant string
 }
 
 type Pool struct {
@@ -22,6 +24,8 @@ type Pool struct {
 	SocketPath string
 	ConfigPath string
 	Status     string
+	Target     string // "" for the host, see target.Parse
+	Tenant     string // "" when the pool belongs to no tenant
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 }
@@ -38,9 +42,9 @@ func (db *Database) GetPHPVersion(version string) (*PHPVersion, error) {
 	var pv PHPVersion
 	var installedAt sql.NullTime
 	err := db.QueryRow(
-		"SELECT id, version, installed_at, status, package_manager, os_family FROM php_versions WHERE version = ?",
+		"SELECT id, version, installed_at, status, package_manager, os_family, tenant FROM php_versions WHERE version = ?",
 		version,
-	).Scan(&pv.ID, &pv.Version, &installedAt, &pv.Status, &pv.PackageManager, &pv.OSFamily)
+	).Scan(&pv.ID, &pv.Version, &installedAt, &pv.Status, &pv.PackageManager, &pv.OSFamily, &pv.Tenant)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -57,7 +61,7 @@ func (db *Database) GetPHPVersion(version string) (*PHPVersion, error) {
 }
 
 func (db *Database) ListPHPVersions() ([]PHPVersion, error) {
-	rows, err := db.Query("SELECT id, version, installed_at, status, package_manager, os_family FROM php_versions ORDER BY version DESC")
+	rows, err := db.Query("SELECT id, version, installed_at, status, package_manager, os_family, tenant FROM php_versions ORDER BY version DESC")
 	if err != nil {
 		// Return empty slice instead of nil on error
 		return []PHPVersion{}, err
@@ -68,7 +72,7 @@ func (db *Database) ListPHPVersions() ([]PHPVersion, error) {
 	for rows.Next() {
 		var pv PHPVersion
 		var installedAt sql.NullTime
-		if err := rows.Scan(&pv.ID, &pv.Version, &installedAt, &pv.Status, &pv.PackageManager, &pv.OSFamily); err != nil {
+		if err := rows.Scan(&pv.ID, &pv.Version, &installedAt, &pv.Status, &pv.PackageManager, &pv.OSFamily, &pv.Tenant); err != nil {
 			return []PHPVersion{}, err
 		}
 		if installedAt.Valid {
@@ -88,15 +92,24 @@ func (db *Database) ListPHPVersions() ([]PHPVersion, error) {
 	return versions, nil
 }
 
-func (db *Database) CreatePool(username, phpVersion, provider, socketPath, configPath string) error {
+// DeletePHPVersion removes a PHP version record. The foreign key from pools
+// makes this fail while any pool still references the version.
+func (db *Database) DeletePHPVersion(version string) error {
+	_, err := db.Exec("DELETE FROM php_versions WHERE version = ?", version)
+	return err
+}
+
+func (db *Database) CreatePool(username, phpVersion, provider, socketPath, configPath, target, tenant string) error {
 	_, err := db.Exec(
-		`INSERT INTO pools (username, php_version, provider, socket_path, config_path, status) 
-		 VALUES (?, ?, ?, ?, ?, 'active')
+		`INSERT INTO pools (username, php_version, provider, socket_path, config_path, target, tenant, status) 
+		 VALUES (?, ?, ?, ?, ?, ?, ?, 'active')
 		 ON CONFLICT(username, php_version, provider) DO UPDATE SET
 		 socket_path = excluded.socket_path,
 		 config_path = excluded.config_path,
+		 target = excluded.target,
+		 tenant = excluded.tenant,
 		 updated_at = CURRENT_TIMESTAMP`,
-		username, phpVersion, provider, socketPath, configPath,
+		username, phpVersion, provider, socketPath, configPath, target, tenant,
 	)
 	return err
 }
@@ -105,10 +118,10 @@ func (db *Database) GetPool(username string) (*Pool, error) {
 	var p Pool
 	var createdAt, updatedAt sql.NullTime
 	err := db.QueryRow(
-		`SELECT id, username, php_version, provider, socket_path, config_path, status, created_at, updated_at 
+		`SELECT id, username, php_version, provider, socket_path, config_path, status, target, tenant, created_at, updated_at 
 		 FROM pools WHERE username = ? ORDER BY created_at DESC LIMIT 1`,
 		username,
-	).Scan(&p.ID, &p.Username, &p.PHPVersion, &p.Provider, &p.SocketPath, &p.ConfigPath, &p.Status, &createdAt, &updatedAt)
+	).Scan(&p.ID, &p.Username, &p.PHPVersion, &p.Provider, &p.SocketPath, &p.ConfigPath, &p.Status, &p.Target, &p.Tenant, &createdAt, &updatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -131,10 +144,10 @@ func (db *Database) GetPoolByUsernameAndVersion(username, phpVersion string) (*P
 	var p Pool
 	var createdAt, updatedAt sql.NullTime
 	err := db.QueryRow(
-		`SELECT id, username, php_version, provider, socket_path, config_path, status, created_at, updated_at 
+		`SELECT id, username, php_version, provider, socket_path, config_path, status, target, tenant, created_at, updated_at 
 		 FROM pools WHERE username = ? AND php_version = ?`,
 		username, phpVersion,
-	).Scan(&p.ID, &p.Username, &p.PHPVersion, &p.Provider, &p.SocketPath, &p.ConfigPath, &p.Status, &createdAt, &updatedAt)
+	).Scan(&p.ID, &p.Username, &p.PHPVersion, &p.Provider, &p.SocketPath, &p.ConfigPath, &p.Status, &p.Target, &p.Tenant, &createdAt, &updatedAt)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -154,10 +167,23 @@ func (db *Database) GetPoolByUsernameAndVersion(username, phpVersion string) (*P
 }
 
 func (db *Database) ListPools() ([]Pool, error) {
-	rows, err := db.Query(
-		`SELECT id, username, php_version, provider, socket_path, config_path, status, created_at, updated_at 
+	return db.queryPools(
+		`SELECT id, username, php_version, provider, socket_path, config_path, status, target, tenant, created_at, updated_at 
 		 FROM pools ORDER BY username, created_at DESC`,
 	)
+}
+
+// ListUserPools returns every pool of a user, newest first
+func (db *Database) ListUserPools(username string) ([]Pool, error) {
+	return db.queryPools(
+		`SELECT id, username, php_version, provider, socket_path, config_path, status, target, tenant, created_at, updated_at 
+		 FROM pools WHERE username = ? ORDER BY created_at DESC, id DESC`,
+		username,
+	)
+}
+
+func (db *Database) queryPools(query string, args ...interface{}) ([]Pool, error) {
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -167,7 +193,7 @@ func (db *Database) ListPools() ([]Pool, error) {
 	for rows.Next() {
 		var p Pool
 		var createdAt, updatedAt sql.NullTime
-		if err := rows.Scan(&p.ID, &p.Username, &p.PHPVersion, &p.Provider, &p.SocketPath, &p.ConfigPath, &p.Status, &createdAt, &updatedAt); err != nil {
+		if err := rows.Scan(&p.ID, &p.Username, &p.PHPVersion, &p.Provider, &p.SocketPath, &p.ConfigPath, &p.Status, &p.Target, &p.Tenant, &createdAt, &updatedAt); err != nil {
 			return nil, err
 		}
 		if createdAt.Valid {
@@ -200,6 +226,26 @@ func (db *Database) DeletePool(username string) error {
 	return nil
 }
 
+// DeletePoolByID removes one pool of a user who may have several
+func (db *Database) DeletePoolByID(id int64) error {
+	result, err := db.Exec("DELETE FROM pools WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// PoolMigrated is the status of a pool whose account moved to another
+// server; its sites are proxied there
+const PoolMigrated = "migrated"
+
 func (db *Database) UpdatePoolStatus(username, status string) error {
 	_, err := db.Exec(
 		"UPDATE pools SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE username = ?",
@@ -207,3 +253,53 @@ func (db *Database) UpdatePoolStatus(username, status string) error {
 	)
 	return err
 }
+
+// UpdatePoolVersion moves a pool to another PHP version with its new paths
+func (db *Database) UpdatePoolVersion(poolID int64, phpVersion, socketPath, configPath string) error {
+	_, err := db.Exec(
+		"UPDATE pools SET php_version = ?, socket_path = ?, config_path = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		phpVersion, socketPath, configPath, poolID,
+	)
+	return err
+}
+
+// GetPoolSettings returns the stored settings JSON of a pool and its revision
+func (db *Database) GetPoolSettings(poolID int64) (string, int64, error) {
+	var settings string
+	var revision int64
+	err := db.QueryRow(
+		"SELECT settings, settings_revision FROM pools WHERE id = ?",
+		poolID,
+	).Scan(&settings, &revision)
+	if err != nil {
+		return "", 0, err
+	}
+	settings, err = openSettings(settings)
+	return settings, revision, err
+}
+
+// SavePoolSettings stores settings JSON if the pool is still at
+// expectedRevision and returns the new revision. sql.ErrNoRows means the
+// revision changed in the meantime.
+func (db *Database) SavePoolSettings(poolID int64, settings string, expectedRevision int64) (int64, error) {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return 0, err
+	}
+	result, err := db.Exec(
+		`UPDATE pools SET settings = ?, settings_revision = settings_revision + 1, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND settings_revision = ?`,
+		settings, poolID, expectedRevision,
+	)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if rows == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return expectedRevision + 1, nil
+}
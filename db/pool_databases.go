@@ -0,0 +1,56 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolDatabase is the MySQL database and account provisioned for a pool.
+// The record outlives the pool until its database is dropped, so a pool
+// created again for the user gets the same credentials.
+type PoolDatabase struct {
+	Pool     string
+	Name     string
+	Username string
+	UserHost string
+	// Password is sealed in the database and opened on read
+	Password  string
+	CreatedAt time.Time
+}
+
+// CreatePoolDatabase records a provisioned database
+func (db *Database) CreatePoolDatabase(d PoolDatabase) error {
+	password, err := sealValue(d.Password)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"INSERT INTO pool_databases (pool, name, username, user_host, password, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		d.Pool, d.Name, d.Username, d.UserHost, password, d.CreatedAt,
+	)
+	return err
+}
+
+// GetPoolDatabase returns the database of a pool, or nil if it has none
+func (db *Database) GetPoolDatabase(pool string) (*PoolDatabase, error) {
+	var d PoolDatabase
+	err := db.QueryRow(
+		"SELECT pool, name, username, user_host, password, created_at FROM pool_databases WHERE pool = ?", pool,
+	).Scan(&d.Pool, &d.Name, &d.Username, &d.UserHost, &d.Password, &d.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Password, err = openValue(d.Password); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DeletePoolDatabase forgets the database of a pool after it was dropped
+func (db *Database) DeletePoolDatabase(pool string) error {
+	_, err := db.Exec("DELETE FROM pool_databases WHERE pool = ?", pool)
+	return err
+}
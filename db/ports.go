@@ -0,0 +1,90 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// AllocatePoolPort assigns a pool a port. With port set that port is
+// claimed; otherwise the pool keeps its current port or gets the lowest
+// free port in [min, max] that skip does not reject (e.g. because another
+// program listens on it).
+func (db *Database) AllocatePoolPort(poolID int64, port, min, max int, skip func(int) bool) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	err = tx.QueryRow("SELECT port FROM pool_ports WHERE pool_id = ?", poolID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	if port == 0 && current != 0 {
+		return current, nil
+	}
+	if port != 0 && port == current {
+		return current, nil
+	}
+
+	if port == 0 {
+		used := make(map[int]bool)
+		rows, err := tx.Query("SELECT port FROM pool_ports WHERE port BETWEEN ? AND ?", min, max)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var p int
+			if err := rows.Scan(&p); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			used[p] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+		for p := min; p <= max; p++ {
+			if !used[p] && (skip == nil || !skip(p)) {
+				port = p
+				break
+			}
+		}
+		if port == 0 {
+			return 0, fmt.Errorf("no free port in %d-%d", min, max)
+		}
+	} else {
+		var owner int64
+		err := tx.QueryRow("SELECT pool_id FROM pool_ports WHERE port = ?", port).Scan(&owner)
+		if err == nil {
+			return 0, fmt.Errorf("port %d is already allocated to another pool", port)
+		} else if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM pool_ports WHERE pool_id = ?", poolID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO pool_ports (port, pool_id) VALUES (?, ?)", port, poolID); err != nil {
+		return 0, err
+	}
+	return port, tx.Commit()
+}
+
+// ReleasePoolPort frees the port allocated to a pool
+func (db *Database) ReleasePoolPort(poolID int64) error {
+	_, err := db.Exec("DELETE FROM pool_ports WHERE pool_id = ?", poolID)
+	return err
+}
+
+// UpdatePoolListen changes the listen address stored for a pool
+func (db *Database) UpdatePoolListen(poolID int64, listen string) error {
+	_, err := db.Exec(
+		"UPDATE pools SET socket_path = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		listen, poolID,
+	)
+	return err
+}
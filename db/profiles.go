@@ -0,0 +1,106 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolProfile is a named set of pool settings applied at pool creation
+type PoolProfile struct {
+	ID          int64
+	Name        string
+	Description string
+	Settings    string
+	Builtin     bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+const profileColumns = "id, name, description, settings, builtin, created_at, updated_at"
+
+func scanProfile(row interface{ Scan(...interface{}) error }) (*PoolProfile, error) {
+	var p PoolProfile
+	var createdAt, updatedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Settings, &p.Builtin, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	settings, err := openSettings(p.Settings)
+	if err != nil {
+		return nil, err
+	}
+	p.Settings = settings
+	if createdAt.Valid {
+		p.CreatedAt = createdAt.Time
+	}
+	if updatedAt.Valid {
+		p.UpdatedAt = updatedAt.Time
+	}
+	return &p, nil
+}
+
+func (db *Database) CreateProfile(name, description, settings string) error {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"INSERT INTO pool_profiles (name, description, settings) VALUES (?, ?, ?)",
+		name, description, settings,
+	)
+	return err
+}
+
+func (db *Database) GetProfile(name string) (*PoolProfile, error) {
+	p, err := scanProfile(db.QueryRow("SELECT "+profileColumns+" FROM pool_profiles WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (db *Database) ListProfiles() ([]PoolProfile, error) {
+	rows, err := db.Query("SELECT " + profileColumns + " FROM pool_profiles ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []PoolProfile
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
+	}
+	return profiles, rows.Err()
+}
+
+// UpdateProfile replaces a profile's description and settings; sql.ErrNoRows
+// means it does not exist
+func (db *Database) UpdateProfile(name, description, settings string) error {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(
+		"UPDATE pool_profiles SET description = ?, settings = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?",
+		description, settings, name,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) DeleteProfile(name string) error {
+	_, err := db.Exec("DELETE FROM pool_profiles WHERE name = ?", name)
+	return err
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// GetPoolPackage returns the package (profile) a pool was provisioned
+// with, or "" if it was not provisioned with one
+func (db *Database) GetPoolPackage(poolID int64) (string, error) {
+	var pkg string
+	err := db.QueryRow("SELECT package FROM pool_packages WHERE pool_id = ?", poolID).Scan(&pkg)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return pkg, err
+}
+
+// SetPoolPackage records the package of a pool
+func (db *Database) SetPoolPackage(poolID int64, pkg string) error {
+	_, err := db.Exec(`
+		INSERT INTO pool_packages (pool_id, package, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(pool_id) DO UPDATE SET package = excluded.package, updated_at = excluded.updated_at`,
+		poolID, pkg, time.Now().UTC(),
+	)
+	return err
+}
+
+// IdempotencyKey is a request made with an Idempotency-Key header and,
+// once it finished, its response
+type IdempotencyKey struct {
+	Key         string
+	RequestHash string
+	// Status is 0 while the request runs
+	Status    int
+	Response  string
+	CreatedAt time.Time
+}
+
+// CreateIdempotencyKey claims a key for a running request. It fails when
+// the key exists.
+func (db *Database) CreateIdempotencyKey(key, requestHash string, at time.Time) error {
+	_, err := db.Exec(
+		"INSERT INTO idempotency_keys (idempotency_key, request_hash, status, response, created_at) VALUES (?, ?, 0, '', ?)",
+		key, requestHash, at.UTC(),
+	)
+	return err
+}
+
+// GetIdempotencyKey returns a key, or nil if it does not exist
+func (db *Database) GetIdempotencyKey(key string) (*IdempotencyKey, error) {
+	var k IdempotencyKey
+	err := db.QueryRow(
+		"SELECT idempotency_key, request_hash, status, response, created_at FROM idempotency_keys WHERE idempotency_key = ?",
+		key,
+	).Scan(&k.Key, &k.RequestHash, &k.Status, &k.Response, &k.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// FinishIdempotencyKey stores the response of the request holding a key
+func (db *Database) FinishIdempotencyKey(key string, status int, response string) error {
+	_, err := db.Exec("UPDATE idempotency_keys SET status = ?, response = ? WHERE idempotency_key = ?", status, response, key)
+	return err
+}
+
+// DeleteIdempotencyKey releases a key, so the request may be made again
+func (db *Database) DeleteIdempotencyKey(key string) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE idempotency_key = ?", key)
+	return err
+}
+
+// PruneIdempotencyKeys deletes keys created before cutoff
+func (db *Database) PruneIdempotencyKeys(cutoff time.Time) error {
+	_, err := db.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", cutoff.UTC())
+	return err
+}
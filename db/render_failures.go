@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RenderFailure is a template that failed to render for a pool, site or
+// PHP version, with a redacted snapshot of the data it was given
+type RenderFailure struct {
+	ID        int64
+	Template  string
+	Source    string
+	Username  string
+	Line      int
+	Field     string
+	Error     string
+	Data      string
+	CreatedAt time.Time
+}
+
+// CreateRenderFailure records a render failure
+func (db *Database) CreateRenderFailure(f *RenderFailure) (int64, error) {
+	return db.insert(
+		"INSERT INTO render_failures (template, source, username, line, field, error, data, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		f.Template, f.Source, f.Username, f.Line, f.Field, f.Error, f.Data, f.CreatedAt,
+	)
+}
+
+// ListRenderFailures returns recorded failures, newest first, optionally
+// only those of one user
+func (db *Database) ListRenderFailures(username string, limit int) ([]RenderFailure, error) {
+	query := "SELECT id, template, source, username, line, field, error, data, created_at FROM render_failures"
+	var args []interface{}
+	if username != "" {
+		query += " WHERE username = ?"
+		args = append(args, username)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := make([]RenderFailure, 0)
+	for rows.Next() {
+		var f RenderFailure
+		var createdAt sql.NullTime
+		if err := rows.Scan(&f.ID, &f.Template, &f.Source, &f.Username, &f.Line, &f.Field, &f.Error, &f.Data, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			f.CreatedAt = createdAt.Time
+		}
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+// PruneRenderFailures keeps the newest keep failures
+func (db *Database) PruneRenderFailures(keep int) error {
+	_, err := db.Exec(
+		"DELETE FROM render_failures WHERE id NOT IN (SELECT id FROM render_failures ORDER BY id DESC LIMIT ?)",
+		keep,
+	)
+	return err
+}
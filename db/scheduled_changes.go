@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Scheduled change statuses
+const (
+	ChangePending   = "pending"
+	ChangeRunning   = "running"
+	ChangeApplied   = "applied"
+	ChangeFailed    = "failed"
+	ChangeCancelled = "cancelled"
+)
+
+// ScheduledChange is a pool change queued to run at RunAt. Settings is a
+// JSON settings patch; PHPVersion, if set, is a version switch.
+type ScheduledChange struct {
+	ID         int64
+	Username   string
+	Settings   string
+	PHPVersion string
+	RunAt      time.Time
+	Status     string
+	Error      string
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+const scheduledChangeColumns = "id, username, settings, php_version, run_at, status, error, created_at, finished_at"
+
+func scanScheduledChange(row interface{ Scan(...interface{}) error }) (*ScheduledChange, error) {
+	var c ScheduledChange
+	var finishedAt sql.NullTime
+	if err := row.Scan(&c.ID, &c.Username, &c.Settings, &c.PHPVersion, &c.RunAt, &c.Status, &c.Error, &c.CreatedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	settings, err := openSettings(c.Settings)
+	if err != nil {
+		return nil, err
+	}
+	c.Settings = settings
+	if finishedAt.Valid {
+		c.FinishedAt = &finishedAt.Time
+	}
+	return &c, nil
+}
+
+// CreateScheduledChange queues a pending change
+func (db *Database) CreateScheduledChange(username, settings, phpVersion string, runAt, createdAt time.Time) (int64, error) {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return 0, err
+	}
+	return db.insert(
+		"INSERT INTO scheduled_changes (username, settings, php_version, run_at, status, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		username, settings, phpVersion, runAt, ChangePending, createdAt,
+	)
+}
+
+// GetScheduledChange returns a scheduled change, or nil if it does not exist
+func (db *Database) GetScheduledChange(id int64) (*ScheduledChange, error) {
+	c, err := scanScheduledChange(db.QueryRow("SELECT "+scheduledChangeColumns+" FROM scheduled_changes WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return c, err
+}
+
+// ListScheduledChanges returns scheduled changes by run time, optionally
+// only those of one user and/or one status
+func (db *Database) ListScheduledChanges(username, status string) ([]ScheduledChange, error) {
+	rows, err := db.Query(
+		"SELECT "+scheduledChangeColumns+" FROM scheduled_changes WHERE (? = '' OR username = ?) AND (? = '' OR status = ?) ORDER BY run_at, id",
+		username, username, status, status,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []ScheduledChange
+	for rows.Next() {
+		c, err := scanScheduledChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *c)
+	}
+	return changes, rows.Err()
+}
+
+// UpdatePendingScheduledChange modifies a change that has not started. It
+// reports false if the change is no longer pending.
+func (db *Database) UpdatePendingScheduledChange(id int64, settings, phpVersion string, runAt time.Time) (bool, error) {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec(
+		"UPDATE scheduled_changes SET settings = ?, php_version = ?, run_at = ? WHERE id = ? AND status = ?",
+		settings, phpVersion, runAt, id, ChangePending,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// TransitionScheduledChange moves a change from one status to another and
+// reports false if it was not in the expected status, so two schedulers
+// never run the same change
+func (db *Database) TransitionScheduledChange(id int64, from, to string) (bool, error) {
+	result, err := db.Exec("UPDATE scheduled_changes SET status = ? WHERE id = ? AND status = ?", to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// FinishScheduledChange records the outcome of a change
+func (db *Database) FinishScheduledChange(id int64, status, errMsg string, finishedAt time.Time) error {
+	_, err := db.Exec(
+		"UPDATE scheduled_changes SET status = ?, error = ?, finished_at = ? WHERE id = ?",
+		status, errMsg, finishedAt, id,
+	)
+	return err
+}
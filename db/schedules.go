@@ -0,0 +1,169 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Schedule run statuses
+const (
+	RunRunning = "running"
+	RunSuccess = "success"
+	RunFailed  = "failed"
+)
+
+// Schedule is a recurring task created through the API. Tasks of the
+// config file are not stored.
+type Schedule struct {
+	ID        int64
+	Name      string
+	Task      string
+	Cron      string
+	CreatedAt time.Time
+}
+
+// ScheduleRun is the outcome of one run of a schedule's task
+type ScheduleRun struct {
+	ID         int64
+	Schedule   string
+	Task       string
+	Status     string
+	Output     string
+	Error      string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+const scheduleRunColumns = "id, schedule, task, status, output, error, started_at, finished_at"
+
+func scanScheduleRun(row interface{ Scan(...interface{}) error }) (*ScheduleRun, error) {
+	var r ScheduleRun
+	var finishedAt sql.NullTime
+	if err := row.Scan(&r.ID, &r.Schedule, &r.Task, &r.Status, &r.Output, &r.Error, &r.StartedAt, &finishedAt); err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+	return &r, nil
+}
+
+// CreateSchedule stores a schedule
+func (db *Database) CreateSchedule(name, task, cron string, createdAt time.Time) (int64, error) {
+	return db.insert(
+		"INSERT INTO schedules (name, task, cron, created_at) VALUES (?, ?, ?, ?)",
+		name, task, cron, createdAt,
+	)
+}
+
+// GetSchedule returns a schedule, or nil if it does not exist
+func (db *Database) GetSchedule(name string) (*Schedule, error) {
+	var s Schedule
+	err := db.QueryRow("SELECT id, name, task, cron, created_at FROM schedules WHERE name = ?", name).
+		Scan(&s.ID, &s.Name, &s.Task, &s.Cron, &s.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListSchedules returns the stored schedules by name
+func (db *Database) ListSchedules() ([]Schedule, error) {
+	rows, err := db.Query("SELECT id, name, task, cron, created_at FROM schedules ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []Schedule
+	for rows.Next() {
+		var s Schedule
+		if err := rows.Scan(&s.ID, &s.Name, &s.Task, &s.Cron, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// DeleteSchedule removes a schedule, keeping its run history, and reports
+// whether it existed
+func (db *Database) DeleteSchedule(name string) (bool, error) {
+	result, err := db.Exec("DELETE FROM schedules WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CreateScheduleRun records the start of a run
+func (db *Database) CreateScheduleRun(schedule, task string, startedAt time.Time) (int64, error) {
+	return db.insert(
+		"INSERT INTO schedule_runs (schedule, task, status, started_at) VALUES (?, ?, ?, ?)",
+		schedule, task, RunRunning, startedAt,
+	)
+}
+
+// FinishScheduleRun stores the outcome and output of a run
+func (db *Database) FinishScheduleRun(id int64, status, output, errMsg string, finishedAt time.Time) error {
+	_, err := db.Exec(
+		"UPDATE schedule_runs SET status = ?, output = ?, error = ?, finished_at = ? WHERE id = ?",
+		status, output, errMsg, finishedAt, id,
+	)
+	return err
+}
+
+// GetScheduleRun returns a run, or nil if it does not exist
+func (db *Database) GetScheduleRun(id int64) (*ScheduleRun, error) {
+	r, err := scanScheduleRun(db.QueryRow("SELECT "+scheduleRunColumns+" FROM schedule_runs WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// ListScheduleRuns returns a schedule's runs, newest first; limit 0
+// returns all of them
+func (db *Database) ListScheduleRuns(schedule string, limit int) ([]ScheduleRun, error) {
+	query := "SELECT " + scheduleRunColumns + " FROM schedule_runs WHERE schedule = ? ORDER BY id DESC"
+	args := []interface{}{schedule}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []ScheduleRun
+	for rows.Next() {
+		r, err := scanScheduleRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *r)
+	}
+	return runs, rows.Err()
+}
+
+// FailRunningScheduleRuns marks runs left running by a stopped server as
+// failed
+func (db *Database) FailRunningScheduleRuns(errMsg string, finishedAt time.Time) error {
+	_, err := db.Exec(
+		"UPDATE schedule_runs SET status = ?, error = ?, finished_at = ? WHERE status = ?",
+		RunFailed, errMsg, finishedAt, RunRunning,
+	)
+	return err
+}
+
+// PruneScheduleRuns removes runs started before cutoff
+func (db *Database) PruneScheduleRuns(cutoff time.Time) error {
+	_, err := db.Exec("DELETE FROM schedule_runs WHERE started_at < ?", cutoff)
+	return err
+}
@@ -0,0 +1,400 @@
+package db
+
+// baselineVersion is the migration PostgreSQL and MySQL databases start
+// from. Their schema is created in one step from postgresBaseline or
+// mysqlBaseline; migrations after it carry SQL for each database.
+const baselineVersion = 19
+
+// postgresBaseline is the schema of migration 19 for PostgreSQL
+const postgresBaseline = `
+		CREATE TABLE php_versions (
+			id BIGSERIAL PRIMARY KEY,
+			version TEXT NOT NULL UNIQUE,
+			installed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			status TEXT DEFAULT 'active',
+			package_manager TEXT,
+			os_family TEXT,
+			tenant TEXT NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE pools (
+			id BIGSERIAL PRIMARY KEY,
+			username TEXT NOT NULL,
+			php_version TEXT NOT NULL REFERENCES php_versions(version),
+			provider TEXT NOT NULL DEFAULT 'remi',
+			socket_path TEXT NOT NULL,
+			config_path TEXT NOT NULL,
+			status TEXT DEFAULT 'active',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			settings TEXT NOT NULL DEFAULT '{}',
+			settings_revision BIGINT NOT NULL DEFAULT 0,
+			target TEXT NOT NULL DEFAULT '',
+			tenant TEXT NOT NULL DEFAULT '',
+			UNIQUE (username, php_version, provider)
+		);
+		CREATE INDEX idx_pools_username ON pools(username);
+		CREATE INDEX idx_pools_php_version ON pools(php_version);
+		CREATE INDEX idx_pools_tenant ON pools(tenant);
+
+		CREATE TABLE sites (
+			id BIGSERIAL PRIMARY KEY,
+			domain TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL,
+			document_root TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX idx_sites_username ON sites(username);
+
+		CREATE TABLE site_pools (
+			id BIGSERIAL PRIMARY KEY,
+			site_id BIGINT NOT NULL REFERENCES sites(id) ON DELETE CASCADE,
+			path_prefix TEXT NOT NULL DEFAULT '/',
+			pool_id BIGINT NOT NULL REFERENCES pools(id) ON DELETE CASCADE,
+			UNIQUE (site_id, path_prefix)
+		);
+
+		CREATE TABLE pool_profiles (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			settings TEXT NOT NULL DEFAULT '{}',
+			builtin INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		INSERT INTO pool_profiles (name, description, settings, builtin) VALUES
+			('wordpress', 'WordPress and similar CMS sites',
+			 '{"max_children":20,"start_servers":4,"min_spare_servers":2,"max_spare_servers":6,"max_requests":500,"memory_limit":"256M","max_execution_time":120,"upload_max_filesize":"64M","post_max_size":"64M","opcache_memory_consumption":128,"opcache_max_accelerated_files":10000}', 1),
+			('laravel', 'Laravel and Symfony applications',
+			 '{"max_children":30,"start_servers":5,"min_spare_servers":3,"max_spare_servers":10,"max_requests":1000,"memory_limit":"512M","max_execution_time":60,"opcache_memory_consumption":256,"opcache_max_accelerated_files":20000}', 1),
+			('highmem', 'Memory-hungry workloads such as imports and reporting',
+			 '{"max_children":10,"start_servers":2,"min_spare_servers":1,"max_spare_servers":4,"max_requests":200,"memory_limit":"1024M","max_execution_time":300,"upload_max_filesize":"256M","post_max_size":"256M","opcache_memory_consumption":256}', 1);
+
+		CREATE TABLE account_erasures (
+			id BIGSERIAL PRIMARY KEY,
+			subject_hash TEXT NOT NULL,
+			report TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX idx_account_erasures_subject ON account_erasures(subject_hash);
+
+		CREATE TABLE pool_ports (
+			port INTEGER PRIMARY KEY,
+			pool_id BIGINT NOT NULL UNIQUE REFERENCES pools(id) ON DELETE CASCADE
+		);
+
+		CREATE TABLE install_logs (
+			id BIGSERIAL PRIMARY KEY,
+			provider TEXT NOT NULL,
+			version TEXT NOT NULL,
+			operation TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			output TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP,
+			rollback TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX idx_install_logs_provider ON install_logs(provider, id);
+
+		CREATE TABLE pool_labels (
+			pool_id BIGINT NOT NULL REFERENCES pools(id) ON DELETE CASCADE,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (pool_id, key)
+		);
+		CREATE INDEX idx_pool_labels_key ON pool_labels(key, value);
+
+		CREATE TABLE scheduled_changes (
+			id BIGSERIAL PRIMARY KEY,
+			username TEXT NOT NULL,
+			settings TEXT NOT NULL DEFAULT '',
+			php_version TEXT NOT NULL DEFAULT '',
+			run_at TIMESTAMP NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP
+		);
+		CREATE INDEX idx_scheduled_changes_status ON scheduled_changes(status, run_at);
+
+		CREATE TABLE managed_users (
+			username TEXT PRIMARY KEY,
+			target TEXT NOT NULL DEFAULT '',
+			uid BIGINT NOT NULL,
+			status TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE render_failures (
+			id BIGSERIAL PRIMARY KEY,
+			template TEXT NOT NULL,
+			source TEXT NOT NULL,
+			username TEXT NOT NULL DEFAULT '',
+			line INTEGER NOT NULL DEFAULT 0,
+			field TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL,
+			data TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX idx_render_failures_username ON render_failures(username);
+
+		CREATE TABLE certificates (
+			id BIGSERIAL PRIMARY KEY,
+			site_id BIGINT NOT NULL UNIQUE REFERENCES sites(id) ON DELETE CASCADE,
+			status TEXT NOT NULL,
+			cert_path TEXT NOT NULL DEFAULT '',
+			key_path TEXT NOT NULL DEFAULT '',
+			issuer TEXT NOT NULL DEFAULT '',
+			serial TEXT NOT NULL DEFAULT '',
+			not_before TIMESTAMP,
+			not_after TIMESTAMP,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE pool_suspensions (
+			pool_id BIGINT PRIMARY KEY REFERENCES pools(id) ON DELETE CASCADE,
+			reason TEXT NOT NULL DEFAULT '',
+			previous_status TEXT NOT NULL,
+			suspended_at TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE audit_log (
+			id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMP NOT NULL,
+			request_id TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL,
+			remote TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX idx_audit_log_request_id ON audit_log(request_id);
+		CREATE INDEX idx_audit_log_target ON audit_log(target);
+
+		CREATE TABLE pool_activity (
+			pool_id BIGINT PRIMARY KEY REFERENCES pools(id) ON DELETE CASCADE,
+			start_time BIGINT NOT NULL,
+			accepted_conn BIGINT NOT NULL,
+			last_request_at TIMESTAMP NOT NULL,
+			checked_at TIMESTAMP NOT NULL,
+			converted_at TIMESTAMP
+		);
+
+		CREATE TABLE tenants (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT '',
+			settings TEXT NOT NULL DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+`
+
+// mysqlBaseline is the schema of migration 19 for MySQL 8.0.13 or newer,
+// which allows defaults on TEXT columns. Names and keys are VARCHAR so they
+// can be indexed.
+const mysqlBaseline = `
+		CREATE TABLE php_versions (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			version VARCHAR(255) NOT NULL UNIQUE,
+			installed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			status VARCHAR(255) DEFAULT 'active',
+			package_manager VARCHAR(255),
+			os_family VARCHAR(255),
+			tenant VARCHAR(255) NOT NULL DEFAULT ''
+		) ENGINE=InnoDB;
+
+		CREATE TABLE pools (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			username VARCHAR(255) NOT NULL,
+			php_version VARCHAR(255) NOT NULL,
+			provider VARCHAR(255) NOT NULL DEFAULT 'remi',
+			socket_path VARCHAR(1024) NOT NULL,
+			config_path VARCHAR(1024) NOT NULL,
+			status VARCHAR(255) DEFAULT 'active',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			settings TEXT NOT NULL DEFAULT ('{}'),
+			settings_revision BIGINT NOT NULL DEFAULT 0,
+			target VARCHAR(255) NOT NULL DEFAULT '',
+			tenant VARCHAR(255) NOT NULL DEFAULT '',
+			UNIQUE (username, php_version, provider),
+			FOREIGN KEY (php_version) REFERENCES php_versions(version)
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_pools_username ON pools(username);
+		CREATE INDEX idx_pools_php_version ON pools(php_version);
+		CREATE INDEX idx_pools_tenant ON pools(tenant);
+
+		CREATE TABLE sites (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			domain VARCHAR(255) NOT NULL UNIQUE,
+			username VARCHAR(255) NOT NULL,
+			document_root VARCHAR(1024) NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_sites_username ON sites(username);
+
+		CREATE TABLE site_pools (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			site_id BIGINT NOT NULL,
+			path_prefix VARCHAR(255) NOT NULL DEFAULT '/',
+			pool_id BIGINT NOT NULL,
+			UNIQUE (site_id, path_prefix),
+			FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+
+		CREATE TABLE pool_profiles (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT (''),
+			settings TEXT NOT NULL DEFAULT ('{}'),
+			builtin INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB;
+
+		INSERT INTO pool_profiles (name, description, settings, builtin) VALUES
+			('wordpress', 'WordPress and similar CMS sites',
+			 '{"max_children":20,"start_servers":4,"min_spare_servers":2,"max_spare_servers":6,"max_requests":500,"memory_limit":"256M","max_execution_time":120,"upload_max_filesize":"64M","post_max_size":"64M","opcache_memory_consumption":128,"opcache_max_accelerated_files":10000}', 1),
+			('laravel', 'Laravel and Symfony applications',
+			 '{"max_children":30,"start_servers":5,"min_spare_servers":3,"max_spare_servers":10,"max_requests":1000,"memory_limit":"512M","max_execution_time":60,"opcache_memory_consumption":256,"opcache_max_accelerated_files":20000}', 1),
+			('highmem', 'Memory-hungry workloads such as imports and reporting',
+			 '{"max_children":10,"start_servers":2,"min_spare_servers":1,"max_spare_servers":4,"max_requests":200,"memory_limit":"1024M","max_execution_time":300,"upload_max_filesize":"256M","post_max_size":"256M","opcache_memory_consumption":256}', 1);
+
+		CREATE TABLE account_erasures (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			subject_hash VARCHAR(255) NOT NULL,
+			report TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_account_erasures_subject ON account_erasures(subject_hash);
+
+		CREATE TABLE pool_ports (
+			port INTEGER PRIMARY KEY,
+			pool_id BIGINT NOT NULL UNIQUE,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+
+		CREATE TABLE install_logs (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			provider VARCHAR(255) NOT NULL,
+			version VARCHAR(255) NOT NULL,
+			operation VARCHAR(255) NOT NULL,
+			target VARCHAR(255) NOT NULL DEFAULT '',
+			status VARCHAR(255) NOT NULL,
+			output TEXT NOT NULL DEFAULT (''),
+			error TEXT NOT NULL DEFAULT (''),
+			started_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			finished_at DATETIME,
+			rollback TEXT NOT NULL DEFAULT ('')
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_install_logs_provider ON install_logs(provider, id);
+
+		CREATE TABLE pool_labels (
+			pool_id BIGINT NOT NULL,
+			"key" VARCHAR(255) NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			PRIMARY KEY (pool_id, "key"),
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_pool_labels_key ON pool_labels("key", value);
+
+		CREATE TABLE scheduled_changes (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			username VARCHAR(255) NOT NULL,
+			settings TEXT NOT NULL DEFAULT (''),
+			php_version VARCHAR(255) NOT NULL DEFAULT '',
+			run_at DATETIME NOT NULL,
+			status VARCHAR(255) NOT NULL,
+			error TEXT NOT NULL DEFAULT (''),
+			created_at DATETIME NOT NULL,
+			finished_at DATETIME
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_scheduled_changes_status ON scheduled_changes(status, run_at);
+
+		CREATE TABLE managed_users (
+			username VARCHAR(255) PRIMARY KEY,
+			target VARCHAR(255) NOT NULL DEFAULT '',
+			uid BIGINT NOT NULL,
+			status VARCHAR(255) NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB;
+
+		CREATE TABLE render_failures (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			template VARCHAR(255) NOT NULL,
+			source VARCHAR(255) NOT NULL,
+			username VARCHAR(255) NOT NULL DEFAULT '',
+			line INTEGER NOT NULL DEFAULT 0,
+			field VARCHAR(255) NOT NULL DEFAULT '',
+			error TEXT NOT NULL,
+			data TEXT NOT NULL DEFAULT (''),
+			created_at DATETIME NOT NULL
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_render_failures_username ON render_failures(username);
+
+		CREATE TABLE certificates (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			site_id BIGINT NOT NULL UNIQUE,
+			status VARCHAR(255) NOT NULL,
+			cert_path VARCHAR(1024) NOT NULL DEFAULT '',
+			key_path VARCHAR(1024) NOT NULL DEFAULT '',
+			issuer VARCHAR(255) NOT NULL DEFAULT '',
+			serial VARCHAR(255) NOT NULL DEFAULT '',
+			not_before DATETIME,
+			not_after DATETIME,
+			last_error TEXT NOT NULL DEFAULT (''),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (site_id) REFERENCES sites(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+
+		CREATE TABLE pool_suspensions (
+			pool_id BIGINT PRIMARY KEY,
+			reason VARCHAR(255) NOT NULL DEFAULT '',
+			previous_status VARCHAR(255) NOT NULL,
+			suspended_at DATETIME NOT NULL,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+
+		CREATE TABLE audit_log (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			created_at DATETIME NOT NULL,
+			request_id VARCHAR(255) NOT NULL DEFAULT '',
+			actor VARCHAR(255) NOT NULL,
+			remote VARCHAR(255) NOT NULL DEFAULT '',
+			action VARCHAR(255) NOT NULL,
+			target VARCHAR(255) NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT ('')
+		) ENGINE=InnoDB;
+		CREATE INDEX idx_audit_log_request_id ON audit_log(request_id);
+		CREATE INDEX idx_audit_log_target ON audit_log(target);
+
+		CREATE TABLE pool_activity (
+			pool_id BIGINT PRIMARY KEY,
+			start_time BIGINT NOT NULL,
+			accepted_conn BIGINT NOT NULL,
+			last_request_at DATETIME NOT NULL,
+			checked_at DATETIME NOT NULL,
+			converted_at DATETIME,
+			FOREIGN KEY (pool_id) REFERENCES pools(id) ON DELETE CASCADE
+		) ENGINE=InnoDB;
+
+		CREATE TABLE tenants (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL UNIQUE,
+			description TEXT NOT NULL DEFAULT (''),
+			settings TEXT NOT NULL DEFAULT ('{}'),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB;
+`
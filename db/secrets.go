@@ -0,0 +1,541 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"lightweight-php/config"
+	"lightweight-php/lock"
+	"lightweight-php/target"
+)
+
+// SecretKeyPath holds the key that seals secret values in the database
+// unless secrets.key_file or SecretKeyEnv says otherwise. It is created on
+// first use and must be copied along with the database to another server.
+const SecretKeyPath = "/etc/lightweight-php/secret.key"
+
+// SecretKeyEnv and PreviousSecretKeyEnv give the key, and the key it
+// replaced while values are being rotated, as base64 instead of a file
+const (
+	SecretKeyEnv         = "LIGHTWEIGHT_PHP_SECRET_KEY"
+	PreviousSecretKeyEnv = "LIGHTWEIGHT_PHP_SECRET_KEY_PREVIOUS"
+)
+
+// sealedPrefix marks a sealed value: AES-256-GCM, nonce first, base64
+const sealedPrefix = "enc:v1:"
+
+// envSetting is the settings key whose values are sealed
+const envSetting = "env"
+
+// sealedColumns are the columns holding sealed values. Settings columns
+// have the values of their env object sealed; the others are sealed whole,
+// Secret ones always and the rest when they set env values.
+var sealedColumns = []struct {
+	Table, Column    string
+	Settings, Secret bool
+}{
+	{"pools", "settings", true, false},
+	{"pool_profiles", "settings", true, false},
+	{"tenants", "settings", true, false},
+	{"pool_defaults", "settings", true, false},
+	{"scheduled_changes", "settings", true, false},
+	{"pool_config_versions", "settings", true, false},
+	{"pool_config_versions", "config", false, false},
+	{"pool_databases", "password", false, true},
+}
+
+// keyring is the key values are sealed with and the keys that may have
+// sealed older values
+type keyring struct {
+	primary []byte
+	others  [][]byte
+	// source describes where the primary key came from
+	source string
+	// stamp identifies the key files the keyring was read from, so that a
+	// rotation by another process is noticed
+	stamp string
+}
+
+var (
+	keyringMu sync.Mutex
+	keys      *keyring
+)
+
+// secretKeyFile returns the host path of the key file
+func secretKeyFile() (string, error) {
+	path := config.Get().Secrets.KeyFile
+	if path == "" {
+		path = SecretKeyPath
+	}
+	return target.Host.Path(path)
+}
+
+// keyFileStamp describes the key file and its rotation siblings
+func keyFileStamp(path string) string {
+	var b strings.Builder
+	for _, p := range []string{path, path + ".new", path + ".previous"} {
+		if info, err := os.Stat(p); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", p, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// loadKeyring returns the keys, creating the key file if create is set and
+// no key is configured
+func loadKeyring(create bool) (*keyring, error) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+
+	if encoded := os.Getenv(SecretKeyEnv); encoded != "" {
+		if keys != nil && keys.source == SecretKeyEnv {
+			return keys, nil
+		}
+		primary, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", SecretKeyEnv, err)
+		}
+		k := &keyring{primary: primary, source: SecretKeyEnv}
+		if encoded := os.Getenv(PreviousSecretKeyEnv); encoded != "" {
+			previous, err := decodeKey(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", PreviousSecretKeyEnv, err)
+			}
+			k.others = append(k.others, previous)
+		}
+		keys = k
+		return keys, nil
+	}
+
+	path, err := secretKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	stamp := keyFileStamp(path)
+	if keys != nil && keys.stamp == stamp && stamp != "" {
+		return keys, nil
+	}
+	primary, err := readKeyFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if !create {
+			return nil, fmt.Errorf("no secret key: %s does not exist and %s is not set", path, SecretKeyEnv)
+		}
+		if primary, err = createKeyFile(path); err != nil {
+			return nil, err
+		}
+		stamp = keyFileStamp(path)
+	} else if err != nil {
+		return nil, err
+	}
+	k := &keyring{primary: primary, source: path, stamp: stamp}
+	// A rotation in progress has sealed some values with .new already;
+	// .previous opens values in snapshots taken before the last rotation
+	for _, p := range []string{path + ".new", path + ".previous"} {
+		if key, err := readKeyFile(p); err == nil {
+			k.others = append(k.others, key)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	keys = k
+	return keys, nil
+}
+
+// decodeKey accepts a key as 32 raw bytes, base64 or hex
+func decodeKey(s string) ([]byte, error) {
+	if len(s) == 32 {
+		return []byte(s), nil
+	}
+	s = strings.TrimSpace(s)
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("must be 32 bytes, raw, base64 or hex")
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read secret key %s: %w", path, err)
+	}
+	key, err := decodeKey(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// createKeyFile writes a new random key, or returns the key another process
+// created first
+func createKeyFile(path string) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create secret key: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return readKeyFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret key: %w", err)
+	}
+	_, err = f.Write(key)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write secret key: %w", err)
+	}
+	return key, nil
+}
+
+// keyID is a short fingerprint of a key for status output
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealWith(key []byte, plain string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a sealed value with whichever key of the keyring sealed it
+func (k *keyring) open(stored string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed value: %w", err)
+	}
+	for _, key := range append([][]byte{k.primary}, k.others...) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", errors.New("invalid sealed value")
+		}
+		if plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil); err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", fmt.Errorf("failed to decrypt a sealed value: it was sealed with a key other than %s", k.source)
+}
+
+// sealValue encrypts a value for storage
+func sealValue(plain string) (string, error) {
+	k, err := loadKeyring(true)
+	if err != nil {
+		return "", err
+	}
+	return sealWith(k.primary, plain)
+}
+
+// openValue decrypts a value written by sealValue; other values are
+// returned unchanged
+func openValue(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	k, err := loadKeyring(false)
+	if err != nil {
+		return "", err
+	}
+	return k.open(stored)
+}
+
+// sealSettings seals the env values of a settings JSON document. Documents
+// without env are returned unchanged, so no key is needed for them.
+func sealSettings(settings string) (string, error) {
+	return mapEnv(settings, sealValue)
+}
+
+// openSettings reverses sealSettings
+func openSettings(settings string) (string, error) {
+	return mapEnv(settings, openValue)
+}
+
+func mapEnv(settings string, fn func(string) (string, error)) (string, error) {
+	if !strings.Contains(settings, `"`+envSetting+`"`) {
+		return settings, nil
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(settings))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		// Not ours to judge; the caller decodes it and reports
+		return settings, nil
+	}
+	env, ok := doc[envSetting].(map[string]interface{})
+	if !ok || len(env) == 0 {
+		return settings, nil
+	}
+	for name, value := range env {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		mapped, err := fn(s)
+		if err != nil {
+			return "", fmt.Errorf("env %s: %w", name, err)
+		}
+		env[name] = mapped
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// sealConfig seals a rendered pool file if it sets env values
+func sealConfig(config string) (string, error) {
+	if !needsSealing(config) {
+		return config, nil
+	}
+	return sealValue(config)
+}
+
+func needsSealing(config string) bool {
+	return strings.Contains(config, "\nenv[")
+}
+
+// SecretColumnStatus counts the values of a sealed column
+type SecretColumnStatus struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// Sealed values are encrypted; Plaintext values should be but were
+	// written before encryption or without a key
+	Sealed    int `json:"sealed"`
+	Plaintext int `json:"plaintext"`
+}
+
+// SecretsStatus describes the key and the sealed columns
+type SecretsStatus struct {
+	// KeySource is the key file or SecretKeyEnv
+	KeySource string `json:"key_source"`
+	KeyID     string `json:"key_id,omitempty"`
+	// OtherKeys are the fingerprints of keys that still open values
+	OtherKeys []string             `json:"other_keys,omitempty"`
+	Columns   []SecretColumnStatus `json:"columns"`
+}
+
+// SecretsStatus reports the key in use and how many values of each sealed
+// column are encrypted
+func (db *Database) SecretsStatus() (*SecretsStatus, error) {
+	status := &SecretsStatus{Columns: make([]SecretColumnStatus, 0, len(sealedColumns))}
+	if k, err := loadKeyring(false); err == nil {
+		status.KeySource = k.source
+		status.KeyID = keyID(k.primary)
+		for _, other := range k.others {
+			status.OtherKeys = append(status.OtherKeys, keyID(other))
+		}
+	} else {
+		status.KeySource = "none"
+	}
+
+	for _, c := range sealedColumns {
+		cs := SecretColumnStatus{Table: c.Table, Column: c.Column}
+		rows, err := db.Query(fmt.Sprintf("SELECT %s FROM %s", c.Column, c.Table))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var value string
+			if err := rows.Scan(&value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			sealed, plain := countSealed(value, c.Settings, c.Secret)
+			cs.Sealed += sealed
+			cs.Plaintext += plain
+		}
+		if err := rows.Close(); err != nil {
+			return nil, err
+		}
+		status.Columns = append(status.Columns, cs)
+	}
+	return status, nil
+}
+
+// countSealed counts the sealed and plaintext secrets in a column value
+func countSealed(value string, settings, secret bool) (int, int) {
+	if !settings {
+		switch {
+		case strings.HasPrefix(value, sealedPrefix):
+			return 1, 0
+		case needsSealing(value) || (secret && value != ""):
+			return 0, 1
+		}
+		return 0, 0
+	}
+	var sealed, plain int
+	mapEnv(value, func(s string) (string, error) {
+		if strings.HasPrefix(s, sealedPrefix) {
+			sealed++
+		} else {
+			plain++
+		}
+		return s, nil
+	})
+	return sealed, plain
+}
+
+// RotateSecrets seals every secret with a new key: a new key file is
+// written next to the current one as .new, all sealed columns are
+// re-encrypted in one transaction, and the new key then replaces the
+// current one, which is kept as .previous for restoring older snapshots.
+// When the key comes from SecretKeyEnv, the values are re-encrypted with
+// it instead, opening old ones with PreviousSecretKeyEnv. Values written
+// in plaintext before encryption are sealed as well. It returns the
+// number of rows rewritten.
+func (db *Database) RotateSecrets() (int, error) {
+	l, err := lock.Default.Acquire("secrets-rotate", "rotate the secret key", false, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Release()
+
+	k, err := loadKeyring(true)
+	if err != nil {
+		return 0, err
+	}
+	next := k.primary
+	path := ""
+	if k.source != SecretKeyEnv {
+		path = k.source
+		// A rotation that failed after writing .new may have committed
+		// values sealed with it, so that key is reused
+		if key, err := readKeyFile(path + ".new"); err == nil {
+			next = key
+		} else if errors.Is(err, os.ErrNotExist) {
+			if next, err = createKeyFile(path + ".new"); err != nil {
+				return 0, err
+			}
+		} else {
+			return 0, err
+		}
+		if k, err = loadKeyring(false); err != nil {
+			return 0, err
+		}
+	}
+
+	reseal := func(s string) (string, error) {
+		if strings.HasPrefix(s, sealedPrefix) {
+			plain, err := k.open(s)
+			if err != nil {
+				return "", err
+			}
+			s = plain
+		}
+		return sealWith(next, s)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	written := 0
+	for _, c := range sealedColumns {
+		type row struct {
+			id    int64
+			value string
+		}
+		rows, err := tx.Query(fmt.Sprintf("SELECT id, %s FROM %s", c.Column, c.Table))
+		if err != nil {
+			return 0, err
+		}
+		var pending []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.value); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			pending = append(pending, r)
+		}
+		if err := rows.Close(); err != nil {
+			return 0, err
+		}
+
+		for _, r := range pending {
+			var updated string
+			if c.Settings {
+				updated, err = mapEnv(r.value, reseal)
+			} else if strings.HasPrefix(r.value, sealedPrefix) || needsSealing(r.value) || (c.Secret && r.value != "") {
+				updated, err = reseal(r.value)
+			} else {
+				continue
+			}
+			if err != nil {
+				return 0, fmt.Errorf("%s.%s of row %d: %w", c.Table, c.Column, r.id, err)
+			}
+			if updated == r.value {
+				continue
+			}
+			if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", c.Table, c.Column), updated, r.id); err != nil {
+				return 0, err
+			}
+			written++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if path != "" {
+		if err := os.Rename(path, path+".previous"); err != nil {
+			return written, fmt.Errorf("values were re-encrypted with %s.new but it could not replace the key: %w", path, err)
+		}
+		if err := os.Rename(path+".new", path); err != nil {
+			return written, fmt.Errorf("values were re-encrypted with %s.new but it could not replace the key: %w", path, err)
+		}
+	}
+	keyringMu.Lock()
+	keys = nil
+	keyringMu.Unlock()
+	return written, nil
+}
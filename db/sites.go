@@ -0,0 +1,207 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Site struct {
+	ID           int64
+	Domain       string
+	Username     string
+	DocumentRoot string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// SiteBinding routes requests under PathPrefix of a site to a pool
+type SiteBinding struct {
+	ID         int64
+	SiteID     int64
+	PathPrefix string
+	PoolID     int64
+	PHPVersion string
+	SocketPath string
+}
+
+func (db *Database) CreateSite(domain, username, documentRoot string) (int64, error) {
+	return db.insert(
+		"INSERT INTO sites (domain, username, document_root) VALUES (?, ?, ?)",
+		domain, username, documentRoot,
+	)
+}
+
+func (db *Database) GetSite(domain string) (*Site, error) {
+	var s Site
+	var createdAt, updatedAt sql.NullTime
+	err := db.QueryRow(
+		"SELECT id, domain, username, document_root, created_at, updated_at FROM sites WHERE domain = ?",
+		domain,
+	).Scan(&s.ID, &s.Domain, &s.Username, &s.DocumentRoot, &createdAt, &updatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if createdAt.Valid {
+		s.CreatedAt = createdAt.Time
+	}
+	if updatedAt.Valid {
+		s.UpdatedAt = updatedAt.Time
+	}
+
+	return &s, nil
+}
+
+func (db *Database) ListSites() ([]Site, error) {
+	rows, err := db.Query("SELECT id, domain, username, document_root, created_at, updated_at FROM sites ORDER BY domain")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := make([]Site, 0)
+	for rows.Next() {
+		var s Site
+		var createdAt, updatedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &s.Domain, &s.Username, &s.DocumentRoot, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			s.CreatedAt = createdAt.Time
+		}
+		if updatedAt.Valid {
+			s.UpdatedAt = updatedAt.Time
+		}
+		sites = append(sites, s)
+	}
+
+	return sites, rows.Err()
+}
+
+func (db *Database) DeleteSite(domain string) error {
+	result, err := db.Exec("DELETE FROM sites WHERE domain = ?", domain)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetSiteBinding binds a path prefix of a site to a pool, replacing any
+// existing binding for the same prefix
+func (db *Database) SetSiteBinding(siteID int64, pathPrefix string, poolID int64) error {
+	_, err := db.Exec(
+		`INSERT INTO site_pools (site_id, path_prefix, pool_id) VALUES (?, ?, ?)
+		 ON CONFLICT(site_id, path_prefix) DO UPDATE SET pool_id = excluded.pool_id`,
+		siteID, pathPrefix, poolID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE sites SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", siteID)
+	return err
+}
+
+func (db *Database) DeleteSiteBinding(siteID int64, pathPrefix string) error {
+	result, err := db.Exec("DELETE FROM site_pools WHERE site_id = ? AND path_prefix = ?", siteID, pathPrefix)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListSiteBindings returns the bindings of a site with the bound pool's
+// version and socket, longest path prefix first
+func (db *Database) ListSiteBindings(siteID int64) ([]SiteBinding, error) {
+	rows, err := db.Query(
+		`SELECT sp.id, sp.site_id, sp.path_prefix, sp.pool_id, p.php_version, p.socket_path
+		 FROM site_pools sp JOIN pools p ON p.id = sp.pool_id
+		 WHERE sp.site_id = ? ORDER BY length(sp.path_prefix) DESC, sp.path_prefix`,
+		siteID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bindings := make([]SiteBinding, 0)
+	for rows.Next() {
+		var b SiteBinding
+		if err := rows.Scan(&b.ID, &b.SiteID, &b.PathPrefix, &b.PoolID, &b.PHPVersion, &b.SocketPath); err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, b)
+	}
+
+	return bindings, rows.Err()
+}
+
+// UpdateSiteDocumentRoot moves a site to another document root
+func (db *Database) UpdateSiteDocumentRoot(domain, documentRoot string) error {
+	_, err := db.Exec("UPDATE sites SET document_root = ?, updated_at = CURRENT_TIMESTAMP WHERE domain = ?", documentRoot, domain)
+	return err
+}
+
+// GetSitePHPValues returns the php.ini overrides of a site's document root
+func (db *Database) GetSitePHPValues(siteID int64) (map[string]string, error) {
+	rows, err := db.Query("SELECT name, value FROM site_php_values WHERE site_id = ? ORDER BY name", siteID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+	return values, rows.Err()
+}
+
+// SetSitePHPValues replaces the php.ini overrides of a site
+func (db *Database) SetSitePHPValues(siteID int64, values map[string]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM site_php_values WHERE site_id = ?", siteID); err != nil {
+		return err
+	}
+	for name, value := range values {
+		if _, err := tx.Exec("INSERT INTO site_php_values (site_id, name, value) VALUES (?, ?, ?)", siteID, name, value); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE sites SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", siteID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolSuspended is the status of a pool whose account is suspended
+const PoolSuspended = "suspended"
+
+// PoolSuspension records why and since when a pool is suspended
+type PoolSuspension struct {
+	PoolID int64
+	Reason string
+	// PreviousStatus is restored when the pool is unsuspended
+	PreviousStatus string
+	SuspendedAt    time.Time
+}
+
+// SuspendPool marks a pool suspended and records the suspension in one
+// transaction
+func (db *Database) SuspendPool(poolID int64, reason string, at time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	if err := tx.QueryRow("SELECT status FROM pools WHERE id = ?", poolID).Scan(&status); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO pool_suspensions (pool_id, reason, previous_status, suspended_at) VALUES (?, ?, ?, ?)",
+		poolID, reason, status, at.UTC(),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE pools SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", PoolSuspended, poolID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UnsuspendPool restores the status a pool had before its suspension and
+// forgets the suspension
+func (db *Database) UnsuspendPool(poolID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT previous_status FROM pool_suspensions WHERE pool_id = ?", poolID).Scan(&previous)
+	if err == sql.ErrNoRows {
+		previous = "active"
+	} else if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM pool_suspensions WHERE pool_id = ?", poolID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE pools SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", previous, poolID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPoolSuspension returns the suspension of a pool, or nil
+func (db *Database) GetPoolSuspension(poolID int64) (*PoolSuspension, error) {
+	var s PoolSuspension
+	err := db.QueryRow(
+		"SELECT pool_id, reason, previous_status, suspended_at FROM pool_suspensions WHERE pool_id = ?",
+		poolID,
+	).Scan(&s.PoolID, &s.Reason, &s.PreviousStatus, &s.SuspendedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListPoolSuspensions returns every suspension by pool ID
+func (db *Database) ListPoolSuspensions() (map[int64]PoolSuspension, error) {
+	rows, err := db.Query("SELECT pool_id, reason, previous_status, suspended_at FROM pool_suspensions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suspensions := make(map[int64]PoolSuspension)
+	for rows.Next() {
+		var s PoolSuspension
+		if err := rows.Scan(&s.PoolID, &s.Reason, &s.PreviousStatus, &s.SuspendedAt); err != nil {
+			return nil, err
+		}
+		suspensions[s.PoolID] = s
+	}
+	return suspensions, rows.Err()
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Tenant is a logical environment, such as a shared server or a reseller,
+// that pools and PHP versions belong to
+type Tenant struct {
+	ID          int64
+	Name        string
+	Description string
+	// Settings is the JSON of the pool settings new pools of the tenant
+	// start from
+	Settings  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+const tenantColumns = "id, name, description, settings, created_at, updated_at"
+
+func scanTenant(row interface{ Scan(...interface{}) error }) (*Tenant, error) {
+	var t Tenant
+	var createdAt, updatedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.Description, &t.Settings, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	settings, err := openSettings(t.Settings)
+	if err != nil {
+		return nil, err
+	}
+	t.Settings = settings
+	if createdAt.Valid {
+		t.CreatedAt = createdAt.Time
+	}
+	if updatedAt.Valid {
+		t.UpdatedAt = updatedAt.Time
+	}
+	return &t, nil
+}
+
+func (db *Database) CreateTenant(name, description, settings string) error {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(
+		"INSERT INTO tenants (name, description, settings) VALUES (?, ?, ?)",
+		name, description, settings,
+	)
+	return err
+}
+
+func (db *Database) GetTenant(name string) (*Tenant, error) {
+	t, err := scanTenant(db.QueryRow("SELECT "+tenantColumns+" FROM tenants WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (db *Database) ListTenants() ([]Tenant, error) {
+	rows, err := db.Query("SELECT " + tenantColumns + " FROM tenants ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// UpdateTenant replaces a tenant's description and settings; sql.ErrNoRows
+// means it does not exist
+func (db *Database) UpdateTenant(name, description, settings string) error {
+	settings, err := sealSettings(settings)
+	if err != nil {
+		return err
+	}
+	result, err := db.Exec(
+		"UPDATE tenants SET description = ?, settings = ?, updated_at = CURRENT_TIMESTAMP WHERE name = ?",
+		description, settings, name,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (db *Database) DeleteTenant(name string) error {
+	_, err := db.Exec("DELETE FROM tenants WHERE name = ?", name)
+	return err
+}
+
+// CountTenantMembers returns how many pools and PHP versions belong to a
+// tenant
+func (db *Database) CountTenantMembers(name string) (pools, versions int, err error) {
+	err = db.QueryRow(
+		"SELECT (SELECT COUNT(*) FROM pools WHERE tenant = ?), (SELECT COUNT(*) FROM php_versions WHERE tenant = ?)",
+		name, name,
+	).Scan(&pools, &versions)
+	return pools, versions, err
+}
+
+// SetPoolTenant moves a pool to a tenant; "" leaves it unassigned
+func (db *Database) SetPoolTenant(poolID int64, tenant string) error {
+	_, err := db.Exec(
+		"UPDATE pools SET tenant = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		tenant, poolID,
+	)
+	return err
+}
+
+// SetPHPVersionTenant assigns an installed PHP version to a tenant; ""
+// shares it between all tenants. sql.ErrNoRows means it is not installed.
+func (db *Database) SetPHPVersionTenant(version, tenant string) error {
+	result, err := db.Exec("UPDATE php_versions SET tenant = ? WHERE version = ?", tenant, version)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolUsage is the metered usage of a pool in a billing period, a month
+// written as YYYY-MM
+type PoolUsage struct {
+	Pool        string
+	Period      string
+	CPUSeconds  float64
+	PeakWorkers int64
+	// BandwidthBytes is the response bytes of the pool's sites, read from
+	// their access logs
+	BandwidthBytes int64
+	// DiskBytesTotal and DiskSamples give the average disk usage; samples
+	// that could not read the usage are not counted
+	DiskBytesTotal int64
+	DiskSamples    int64
+	PeakDiskBytes  int64
+	Samples        int64
+	FirstSampleAt  time.Time
+	LastSampleAt   time.Time
+}
+
+// UsageSample is the usage of a pool measured by one sample
+type UsageSample struct {
+	Pool           string
+	Period         string
+	CPUSeconds     float64
+	Workers        int64
+	BandwidthBytes int64
+	// DiskBytes is -1 when the usage could not be read
+	DiskBytes int64
+	SampledAt time.Time
+}
+
+const poolUsageColumns = "pool, period, cpu_seconds, peak_workers, bandwidth_bytes, disk_bytes_total, disk_samples, peak_disk_bytes, samples, first_sample_at, last_sample_at"
+
+// AddUsageSample adds a sample to the usage of its pool and period
+func (db *Database) AddUsageSample(s UsageSample) error {
+	var diskBytes, diskSamples int64
+	if s.DiskBytes >= 0 {
+		diskBytes, diskSamples = s.DiskBytes, 1
+	}
+	_, err := db.Exec(`
+		INSERT INTO pool_usage (`+poolUsageColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT(pool, period) DO UPDATE SET
+			cpu_seconds = pool_usage.cpu_seconds + excluded.cpu_seconds,
+			peak_workers = CASE WHEN excluded.peak_workers > pool_usage.peak_workers THEN excluded.peak_workers ELSE pool_usage.peak_workers END,
+			bandwidth_bytes = pool_usage.bandwidth_bytes + excluded.bandwidth_bytes,
+			disk_bytes_total = pool_usage.disk_bytes_total + excluded.disk_bytes_total,
+			disk_samples = pool_usage.disk_samples + excluded.disk_samples,
+			peak_disk_bytes = CASE WHEN excluded.peak_disk_bytes > pool_usage.peak_disk_bytes THEN excluded.peak_disk_bytes ELSE pool_usage.peak_disk_bytes END,
+			samples = pool_usage.samples + 1,
+			last_sample_at = excluded.last_sample_at`,
+		s.Pool, s.Period, s.CPUSeconds, s.Workers, s.BandwidthBytes, diskBytes, diskSamples, diskBytes,
+		s.SampledAt.UTC(), s.SampledAt.UTC(),
+	)
+	return err
+}
+
+// ListPoolUsage returns the usage of every pool in a period, by pool
+func (db *Database) ListPoolUsage(period string) ([]PoolUsage, error) {
+	rows, err := db.Query("SELECT "+poolUsageColumns+" FROM pool_usage WHERE period = ? ORDER BY pool", period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []PoolUsage
+	for rows.Next() {
+		var u PoolUsage
+		if err := rows.Scan(&u.Pool, &u.Period, &u.CPUSeconds, &u.PeakWorkers, &u.BandwidthBytes, &u.DiskBytesTotal,
+			&u.DiskSamples, &u.PeakDiskBytes, &u.Samples, &u.FirstSampleAt, &u.LastSampleAt); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// PrunePoolUsage deletes the usage of periods before period
+func (db *Database) PrunePoolUsage(period string) error {
+	_, err := db.Exec("DELETE FROM pool_usage WHERE period < ?", period)
+	return err
+}
+
+// PseudonymizePoolUsage replaces the name of an erased pool in its usage
+// with the account's pseudonymous subject
+func (db *Database) PseudonymizePoolUsage(pool, subject string) error {
+	_, err := db.Exec("UPDATE pool_usage SET pool = ? WHERE pool = ?", subject, pool)
+	return err
+}
+
+// GetBillingExport returns when a period's usage was exported and by
+// which exporter, or a zero time if it was not
+func (db *Database) GetBillingExport(period string) (string, time.Time, error) {
+	var exporter string
+	var at time.Time
+	err := db.QueryRow("SELECT exporter, exported_at FROM billing_exports WHERE period = ?", period).Scan(&exporter, &at)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	return exporter, at, err
+}
+
+// RecordBillingExport records that a period's usage was exported
+func (db *Database) RecordBillingExport(period, exporter string, at time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO billing_exports (period, exporter, exported_at) VALUES (?, ?, ?)
+		ON CONFLICT(period) DO UPDATE SET exporter = excluded.exporter, exported_at = excluded.exported_at`,
+		period, exporter, at.UTC(),
+	)
+	return err
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Managed user statuses
+const (
+	UserActive = "active"
+	UserLocked = "locked"
+)
+
+// ManagedUser is a system user lightweight-php created for a pool. Only
+// these users are ever locked or deleted with their pool.
+type ManagedUser struct {
+	Username  string
+	Target    string
+	UID       int
+	Status    string
+	CreatedAt time.Time
+}
+
+// CreateManagedUser records a user created for a pool
+func (db *Database) CreateManagedUser(username, target string, uid int) error {
+	_, err := db.Exec(
+		"INSERT INTO managed_users (username, target, uid, status) VALUES (?, ?, ?, ?) ON CONFLICT(username) DO UPDATE SET target = excluded.target, uid = excluded.uid, status = excluded.status",
+		username, target, uid, UserActive,
+	)
+	return err
+}
+
+// GetManagedUser returns a managed user, or nil if lightweight-php did not
+// create the user
+func (db *Database) GetManagedUser(username string) (*ManagedUser, error) {
+	var u ManagedUser
+	var createdAt sql.NullTime
+	err := db.QueryRow(
+		"SELECT username, target, uid, status, created_at FROM managed_users WHERE username = ?", username,
+	).Scan(&u.Username, &u.Target, &u.UID, &u.Status, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.CreatedAt = createdAt.Time
+	return &u, nil
+}
+
+// UpdateManagedUserStatus changes the status of a managed user
+func (db *Database) UpdateManagedUserStatus(username, status string) error {
+	_, err := db.Exec("UPDATE managed_users SET status = ? WHERE username = ?", status, username)
+	return err
+}
+
+// DeleteManagedUser forgets a managed user after it was deleted
+func (db *Database) DeleteManagedUser(username string) error {
+	_, err := db.Exec("DELETE FROM managed_users WHERE username = ?", username)
+	return err
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PoolWorker is a long-running PHP command kept running next to a pool,
+// such as a queue worker
+type PoolWorker struct {
+	Pool      string
+	Name      string
+	Command   string
+	Directory string
+	Processes int
+	CreatedAt time.Time
+}
+
+const poolWorkerColumns = "pool, name, command, directory, processes, created_at"
+
+func scanPoolWorker(row interface{ Scan(...interface{}) error }) (*PoolWorker, error) {
+	var w PoolWorker
+	if err := row.Scan(&w.Pool, &w.Name, &w.Command, &w.Directory, &w.Processes, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// CreatePoolWorker stores a worker
+func (db *Database) CreatePoolWorker(w PoolWorker) error {
+	_, err := db.Exec(
+		"INSERT INTO pool_workers ("+poolWorkerColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		w.Pool, w.Name, w.Command, w.Directory, w.Processes, w.CreatedAt,
+	)
+	return err
+}
+
+// GetPoolWorker returns a worker of a pool, or nil if it does not exist
+func (db *Database) GetPoolWorker(pool, name string) (*PoolWorker, error) {
+	w, err := scanPoolWorker(db.QueryRow("SELECT "+poolWorkerColumns+" FROM pool_workers WHERE pool = ? AND name = ?", pool, name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return w, err
+}
+
+// ListPoolWorkers returns the workers of a pool by name
+func (db *Database) ListPoolWorkers(pool string) ([]PoolWorker, error) {
+	rows, err := db.Query("SELECT "+poolWorkerColumns+" FROM pool_workers WHERE pool = ? ORDER BY name", pool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workers []PoolWorker
+	for rows.Next() {
+		w, err := scanPoolWorker(rows)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, *w)
+	}
+	return workers, rows.Err()
+}
+
+// DeletePoolWorker removes a worker
+func (db *Database) DeletePoolWorker(pool, name string) error {
+	_, err := db.Exec("DELETE FROM pool_workers WHERE pool = ? AND name = ?", pool, name)
+	return err
+}
@@ -0,0 +1,162 @@
+// Package dbprovision creates and drops the MySQL or MariaDB databases and
+// accounts of pools through an administrative account. It uses the MySQL
+// driver of the state database, which is compiled in with -tags mysql.
+package dbprovision
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// connectTimeout bounds connecting to the server and each statement
+const connectTimeout = 10 * time.Second
+
+var (
+	namePattern     = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+	userHostPattern = regexp.MustCompile(`^[A-Za-z0-9._%:-]{1,255}$`)
+	addressPattern  = regexp.MustCompile(`@(tcp|unix)\(([^)]*)\)`)
+)
+
+const passwordChars = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz23456789"
+
+// Admin is a connection with an account allowed to create databases and
+// users
+type Admin struct {
+	db *sql.DB
+}
+
+// Open connects to the server of a DSN such as
+// root:pass@tcp(127.0.0.1:3306)/
+func Open(ctx context.Context, dsn string) (*Admin, error) {
+	registered := false
+	for _, name := range sql.Drivers() {
+		registered = registered || name == "mysql"
+	}
+	if !registered {
+		return nil, fmt.Errorf("this binary was built without the mysql driver; rebuild it with -tags mysql")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the database server: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to the database server: %w", err)
+	}
+	return &Admin{db: db}, nil
+}
+
+// Close closes the connection
+func (a *Admin) Close() error {
+	return a.db.Close()
+}
+
+// Create creates a database and an account with all privileges on it.
+// Both may exist already, so an interrupted run can be repeated.
+func (a *Admin) Create(ctx context.Context, name, user, userHost, password string) error {
+	if err := checkNames(name, user, userHost); err != nil {
+		return err
+	}
+	if strings.ContainsAny(password, `'\`) {
+		return fmt.Errorf("password must not contain quotes or backslashes")
+	}
+	account := fmt.Sprintf("'%s'@'%s'", user, userHost)
+	return a.exec(ctx,
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s` CHARACTER SET utf8mb4 COLLATE utf8mb4_unicode_ci", name),
+		fmt.Sprintf("CREATE USER IF NOT EXISTS %s IDENTIFIED BY '%s'", account, password),
+		fmt.Sprintf("GRANT ALL PRIVILEGES ON `%s`.* TO %s", name, account),
+	)
+}
+
+// Drop drops a database and its account; either may be gone already
+func (a *Admin) Drop(ctx context.Context, name, user, userHost string) error {
+	if err := checkNames(name, user, userHost); err != nil {
+		return err
+	}
+	return a.exec(ctx,
+		fmt.Sprintf("DROP DATABASE IF EXISTS `%s`", name),
+		fmt.Sprintf("DROP USER IF EXISTS '%s'@'%s'", user, userHost),
+	)
+}
+
+func (a *Admin) exec(ctx context.Context, statements ...string) error {
+	for _, statement := range statements {
+		ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+		_, err := a.db.ExecContext(ctx, statement)
+		cancel()
+		if err != nil {
+			// The first words name the statement without the password
+			words := strings.Fields(statement)
+			return fmt.Errorf("%s %s failed: %w", words[0], words[1], err)
+		}
+	}
+	return nil
+}
+
+// checkNames keeps names from needing quoting, since statements creating
+// databases and users take no placeholders
+func checkNames(name, user, userHost string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid database name %q: must be up to 32 letters, digits and underscores", name)
+	}
+	if !namePattern.MatchString(user) {
+		return fmt.Errorf("invalid database user %q: must be up to 32 letters, digits and underscores", user)
+	}
+	if !userHostPattern.MatchString(userHost) {
+		return fmt.Errorf("invalid user host %q: must be a host name, address or pattern such as 10.0.0.%%", userHost)
+	}
+	return nil
+}
+
+// Name returns the database and account name of a pool's user: the
+// prefix and the user with dashes turned into underscores
+func Name(prefix, username string) (string, error) {
+	name := prefix + strings.ReplaceAll(username, "-", "_")
+	if !namePattern.MatchString(name) {
+		return "", fmt.Errorf("database name %q of %s must be up to 32 letters, digits and underscores", name, username)
+	}
+	return name, nil
+}
+
+// Password returns a random password of 24 letters and digits
+func Password() (string, error) {
+	b := make([]byte, 24)
+	max := big.NewInt(int64(len(passwordChars)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordChars[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// Address returns the host and port pools reach the server of a DSN at:
+// the tcp(...) address, or localhost:3306 for unix sockets and DSNs
+// without an address
+func Address(dsn string) (string, int, error) {
+	host, port := "localhost", 3306
+	m := addressPattern.FindStringSubmatch(dsn)
+	if m == nil || m[1] == "unix" || m[2] == "" {
+		return host, port, nil
+	}
+	addr := m[2]
+	if i := strings.LastIndex(addr, ":"); i >= 0 && !strings.HasSuffix(addr, "]") {
+		p, err := strconv.Atoi(addr[i+1:])
+		if err != nil || p < 1 || p > 65535 {
+			return "", 0, fmt.Errorf("invalid port in %s", addr)
+		}
+		addr, port = addr[:i], p
+	}
+	return strings.Trim(addr, "[]"), port, nil
+}
@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const cloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare manages records through the Cloudflare API with a token that
+// has the Zone:Read and DNS:Edit permissions
+type Cloudflare struct {
+	token  string
+	ttl    int
+	client *http.Client
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+// NewCloudflare returns a Cloudflare provider writing records with ttl
+func NewCloudflare(token string, ttl int) *Cloudflare {
+	return &Cloudflare{token: token, ttl: ttl, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *Cloudflare) String() string {
+	return "cloudflare"
+}
+
+func (c *Cloudflare) SetRecords(name, recordType string, values []string) ([]string, error) {
+	zone, err := c.zoneID(name)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"type": {recordType}, "name": {name}}
+	var existing []cloudflareRecord
+	if err := c.request("GET", "/zones/"+zone+"/dns_records?"+query.Encode(), nil, &existing); err != nil {
+		return nil, err
+	}
+
+	// Keep matching records, reuse the others for missing values and
+	// delete what is left
+	var stale []cloudflareRecord
+	missing := append([]string{}, values...)
+	for _, record := range existing {
+		if i := indexOf(missing, record.Content); i >= 0 {
+			missing = append(missing[:i], missing[i+1:]...)
+		} else {
+			stale = append(stale, record)
+		}
+	}
+
+	var changes []string
+	for _, value := range missing {
+		record := cloudflareRecord{Type: recordType, Name: name, Content: value, TTL: c.ttl}
+		if len(stale) > 0 {
+			old := stale[0]
+			stale = stale[1:]
+			if err := c.request("PUT", "/zones/"+zone+"/dns_records/"+old.ID, record, nil); err != nil {
+				return changes, err
+			}
+			changes = append(changes, fmt.Sprintf("%s %s: %s -> %s", recordType, name, old.Content, value))
+			continue
+		}
+		if err := c.request("POST", "/zones/"+zone+"/dns_records", record, nil); err != nil {
+			return changes, err
+		}
+		changes = append(changes, fmt.Sprintf("%s %s: created %s", recordType, name, value))
+	}
+	for _, old := range stale {
+		if err := c.request("DELETE", "/zones/"+zone+"/dns_records/"+old.ID, nil, nil); err != nil {
+			return changes, err
+		}
+		changes = append(changes, fmt.Sprintf("%s %s: deleted %s", recordType, name, old.Content))
+	}
+	return changes, nil
+}
+
+// zoneID finds the zone of a name by trying its parent domains, longest
+// first
+func (c *Cloudflare) zoneID(name string) (string, error) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		query := url.Values{"name": {strings.Join(labels[i:], ".")}}
+		if err := c.request("GET", "/zones?"+query.Encode(), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("no Cloudflare zone accessible with the API token contains %s", name)
+}
+
+// request calls the API and decodes the "result" of its response envelope
+// into out
+func (c *Cloudflare) request(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, cloudflareAPI+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloudflare request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare returned %s with an unreadable body: %w", resp.Status, err)
+	}
+	if !envelope.Success {
+		var messages []string
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%s (%d)", e.Message, e.Code))
+		}
+		return fmt.Errorf("cloudflare returned %s: %s", resp.Status, strings.Join(messages, "; "))
+	}
+	if out != nil {
+		if err := json.Unmarshal(envelope.Result, out); err != nil {
+			return fmt.Errorf("failed to decode cloudflare response: %w", err)
+		}
+	}
+	return nil
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
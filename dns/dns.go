@@ -0,0 +1,191 @@
+// Package dns checks whether a domain resolves to this server and points
+// its A/AAAA records at it through a DNS provider's API.
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+)
+
+// ErrNoProvider is returned when records should be changed but no DNS
+// provider is configured
+var ErrNoProvider = errors.New("no DNS provider configured (dns.provider)")
+
+// lookupTimeout bounds the resolver queries of a check
+const lookupTimeout = 5 * time.Second
+
+// Check is the result of resolving a domain and comparing it with the
+// server's addresses
+type Check struct {
+	Domain          string   `json:"domain"`
+	ServerAddresses []string `json:"server_addresses"`
+	A               []string `json:"a"`
+	AAAA            []string `json:"aaaa"`
+	// PointsHere is true when the domain resolves and every address it
+	// resolves to belongs to this server
+	PointsHere bool     `json:"points_here"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// Provider changes records at a DNS hosting service
+type Provider interface {
+	// SetRecords makes the records of a type ("A" or "AAAA") for name
+	// exactly values and describes each change it made
+	SetRecords(name, recordType string, values []string) ([]string, error)
+	// String returns the provider's name
+	String() string
+}
+
+// NewProvider returns the provider configured in cfg, or ErrNoProvider
+func NewProvider(cfg config.DNSConfig) (Provider, error) {
+	switch cfg.Provider {
+	case "cloudflare":
+		token := cfg.APIToken
+		if token == "" {
+			token = os.Getenv("CLOUDFLARE_API_TOKEN")
+		}
+		if token == "" {
+			return nil, fmt.Errorf("no Cloudflare API token: set dns.api_token in the config or CLOUDFLARE_API_TOKEN")
+		}
+		return NewCloudflare(token, cfg.TTL), nil
+	case "":
+		return nil, ErrNoProvider
+	}
+	return nil, fmt.Errorf("unsupported DNS provider %q", cfg.Provider)
+}
+
+// ServerAddresses returns the configured addresses, or the public
+// addresses of the host's interfaces when none are configured
+func ServerAddresses(configured []string) ([]string, error) {
+	if len(configured) > 0 {
+		return normalize(configured), nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	var public []string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+			continue
+		}
+		public = append(public, ipNet.IP.String())
+	}
+	return normalize(public), nil
+}
+
+// CheckDomain resolves the A and AAAA records of a domain and warns about
+// addresses that are not among server
+func CheckDomain(domain string, server []string) *Check {
+	check := &Check{Domain: domain, ServerAddresses: server, A: []string{}, AAAA: []string{}}
+	if len(server) == 0 {
+		check.Warnings = append(check.Warnings, "could not determine this server's public addresses; set dns.server_addresses")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("%s has no A or AAAA records", domain))
+		} else {
+			check.Warnings = append(check.Warnings, fmt.Sprintf("failed to resolve %s: %v", domain, err))
+		}
+		return check
+	}
+
+	var resolved []string
+	for _, addr := range addrs {
+		resolved = append(resolved, addr.IP.String())
+	}
+	for _, ip := range normalize(resolved) {
+		if strings.Contains(ip, ":") {
+			check.AAAA = append(check.AAAA, ip)
+		} else {
+			check.A = append(check.A, ip)
+		}
+	}
+
+	check.PointsHere = len(server) > 0
+	for _, ip := range append(append([]string{}, check.A...), check.AAAA...) {
+		if !contains(server, ip) {
+			check.PointsHere = false
+			if len(server) > 0 {
+				check.Warnings = append(check.Warnings, fmt.Sprintf("%s resolves to %s, which is not this server (%s)", domain, ip, strings.Join(server, ", ")))
+			}
+		}
+	}
+	return check
+}
+
+// Update points the A and AAAA records of a domain at the server's
+// addresses. Only the record types the server has addresses for are
+// touched.
+func Update(p Provider, domain string, server []string) ([]string, error) {
+	var v4, v6 []string
+	for _, ip := range server {
+		if strings.Contains(ip, ":") {
+			v6 = append(v6, ip)
+		} else {
+			v4 = append(v4, ip)
+		}
+	}
+	if len(v4) == 0 && len(v6) == 0 {
+		return nil, fmt.Errorf("could not determine this server's public addresses; set dns.server_addresses")
+	}
+
+	var changes []string
+	for _, set := range []struct {
+		recordType string
+		values     []string
+	}{{"A", v4}, {"AAAA", v6}} {
+		if len(set.values) == 0 {
+			continue
+		}
+		changed, err := p.SetRecords(domain, set.recordType, set.values)
+		changes = append(changes, changed...)
+		if err != nil {
+			return changes, fmt.Errorf("failed to update %s records at %s: %w", set.recordType, p, err)
+		}
+	}
+	return changes, nil
+}
+
+// normalize returns the canonical forms of IP addresses, sorted and
+// without duplicates
+func normalize(addrs []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		s := ip.String()
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by the managers and the API
+const (
+	PoolCreated      = "pool.created"
+	PoolDeleted      = "pool.deleted"
+	PoolStatus       = "pool.status"
+	PoolUpdated      = "pool.updated"
+	PoolInactive     = "pool.inactive"
+	PoolBurst        = "pool.burst"
+	BatchProgress    = "batch.progress"
+	PHPInstall       = "php.install"
+	PHPInstallOutput = "php.install.output"
+	ChangeRun        = "change.run"
+	ScheduleRun      = "schedule.run"
+	RenderFailed     = "template.render_failed"
+	QuotaThreshold   = "quota.threshold"
+	CertIssued       = "certificate.issued"
+	CertFailed       = "certificate.failed"
+	Discrepancy      = "reconcile.discrepancy"
+)
+
+// Event is a change pushed to dashboard clients. Username is set for events
+// about a pool so subscribers can be limited to their own tenants' pools.
+type Event struct {
+	Type     string                 `json:"type"`
+	Username string                 `json:"username,omitempty"`
+	Time     time.Time              `json:"time"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: a subscriber
+// that falls behind misses events rather than stalling the operation.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+// Default is the process-wide bus; it only reaches subscribers in the same
+// process, i.e. the API server
+var Default = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]bool)}
+}
+
+// Subscription receives events on C until Close is called
+type Subscription struct {
+	C   <-chan Event
+	c   chan Event
+	bus *Bus
+}
+
+// Subscribe returns a subscription buffering up to buffer events
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	c := make(chan Event, buffer)
+	s := &Subscription{C: c, c: c, bus: b}
+	b.mu.Lock()
+	b.subs[s] = true
+	b.mu.Unlock()
+	return s
+}
+
+// Close stops delivery and closes C
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if s.bus.subs[s] {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
+
+// Subscribers returns the number of open subscriptions
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Publish delivers an event to every subscriber with room in its buffer
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+		}
+	}
+}
+
+// Publish sends an event on the Default bus
+func Publish(eventType, username string, data map[string]interface{}) {
+	Default.Publish(Event{Type: eventType, Username: username, Data: data})
+}
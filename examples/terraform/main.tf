@@ -0,0 +1,70 @@
+# Manage lightweight-php pools declaratively with the generic REST provider.
+# PUT /api/v1/pools/{username} is idempotent and GET .../spec returns the
+# same document, so plan/apply only touches pools whose spec changed.
+
+terraform {
+  required_providers {
+    restapi = {
+      source  = "Mastercard/restapi"
+      version = ">= 1.19"
+    }
+  }
+}
+
+variable "api_url" {
+  type    = string
+  default = "http://127.0.0.1:8080"
+}
+
+variable "pools" {
+  description = "Pools by username"
+  type = map(object({
+    php_version = string
+    provider    = optional(string, "remi")
+    settings    = optional(map(any), {})
+  }))
+  default = {
+    alice = {
+      php_version = "8.2"
+      settings = {
+        memory_limit       = "256M"
+        max_execution_time = 60
+        max_children       = 20
+      }
+    }
+  }
+}
+
+provider "restapi" {
+  uri                  = var.api_url
+  id_attribute         = "username"
+  create_method        = "PUT"
+  update_method        = "PUT"
+  write_returns_object = true
+}
+
+resource "restapi_object" "pool" {
+  for_each = var.pools
+
+  path         = "/api/v1/pools"
+  create_path  = "/api/v1/pools/${each.key}"
+  read_path    = "/api/v1/pools/{id}/spec"
+  update_path  = "/api/v1/pools/{id}"
+  destroy_path = "/api/v1/pools/{id}"
+
+  # php_version and provider cannot change in place (409); replace the pool
+  force_new = ["php_version", "provider"]
+
+  # Settings must be written in canonical form ("256M", seconds as numbers),
+  # as returned by the API, or every plan shows a diff
+  data = jsonencode({
+    username    = each.key
+    php_version = each.value.php_version
+    provider    = each.value.provider
+    settings    = each.value.settings
+  })
+}
+
+output "pool_ids" {
+  value = { for name, pool in restapi_object.pool : name => jsondecode(pool.api_response).id }
+}
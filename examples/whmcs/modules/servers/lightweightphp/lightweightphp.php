@@ -0,0 +1,211 @@
+<?php
+/**
+ * WHMCS server module for lightweight-php.
+ *
+ * Drives the provisioning API (/api/v1/provisioning/accounts): a WHMCS
+ * service is a pool named after the service's username, and the product's
+ * package is a lightweight-php profile. Every call carries an
+ * Idempotency-Key and is retried on timeouts, so a retry never creates,
+ * suspends or terminates an account twice.
+ *
+ * Server settings in WHMCS:
+ *   Hostname   host of the API server, e.g. web1.example.com
+ *   Port       API port (default 8080)
+ *   Secure     use https
+ *   Password   a key from api.keys in the config file
+ */
+
+if (!defined('WHMCS')) {
+    die('This file cannot be accessed directly');
+}
+
+function lightweightphp_MetaData()
+{
+    return array(
+        'DisplayName' => 'lightweight-php',
+        'APIVersion' => '1.1',
+        'RequiresServer' => true,
+        'DefaultNonSSLPort' => '8080',
+        'DefaultSSLPort' => '8443',
+    );
+}
+
+function lightweightphp_ConfigOptions()
+{
+    return array(
+        'Package' => array(
+            'Type' => 'text',
+            'Size' => '32',
+            'Description' => 'lightweight-php profile, e.g. wordpress',
+        ),
+        'PHP Version' => array(
+            'Type' => 'text',
+            'Size' => '8',
+            'Default' => '8.2',
+        ),
+        'Provider' => array(
+            'Type' => 'dropdown',
+            'Options' => 'remi,lsphp,alt-php,docker,system',
+            'Default' => 'remi',
+        ),
+        'Tenant' => array(
+            'Type' => 'text',
+            'Size' => '32',
+            'Description' => 'Optional tenant the accounts belong to',
+        ),
+        'Create System User' => array(
+            'Type' => 'yesno',
+            'Description' => 'Create the Unix user if it does not exist',
+        ),
+        'Remove User On Terminate' => array(
+            'Type' => 'yesno',
+            'Description' => 'Lock or delete the Unix user when the service is terminated',
+        ),
+    );
+}
+
+function lightweightphp_CreateAccount(array $params)
+{
+    $body = array(
+        'username' => $params['username'],
+        'package' => $params['configoption1'],
+        'php_version' => $params['configoption2'],
+        'provider' => $params['configoption3'],
+        'tenant' => $params['configoption4'],
+        'create_user' => $params['configoption5'] === 'on',
+    );
+    return lightweightphp_result(lightweightphp_call($params, 'CreateAccount', 'POST', '', $body));
+}
+
+function lightweightphp_SuspendAccount(array $params)
+{
+    $body = array('reason' => $params['suspendreason']);
+    return lightweightphp_result(lightweightphp_call($params, 'SuspendAccount', 'POST', '/suspend', $body));
+}
+
+function lightweightphp_UnsuspendAccount(array $params)
+{
+    return lightweightphp_result(lightweightphp_call($params, 'UnsuspendAccount', 'POST', '/unsuspend', null));
+}
+
+function lightweightphp_TerminateAccount(array $params)
+{
+    $body = array('remove_user' => $params['configoption6'] === 'on');
+    $result = lightweightphp_call($params, 'TerminateAccount', 'POST', '/terminate', $body);
+    // An account that is already gone counts as terminated
+    if (isset($result['response']['code']) && $result['response']['code'] === 'pool_not_found') {
+        return 'success';
+    }
+    return lightweightphp_result($result);
+}
+
+function lightweightphp_ChangePackage(array $params)
+{
+    $body = array('package' => $params['configoption1']);
+    return lightweightphp_result(lightweightphp_call($params, 'ChangePackage', 'POST', '/package', $body));
+}
+
+function lightweightphp_TestConnection(array $params)
+{
+    $result = lightweightphp_request($params, 'GET', '/api/v1/php/versions', null, null);
+    if ($result['error'] !== '') {
+        return array('success' => false, 'error' => $result['error']);
+    }
+    if ($result['status'] !== 200) {
+        return array('success' => false, 'error' => lightweightphp_message($result));
+    }
+    return array('success' => true, 'error' => '');
+}
+
+/**
+ * Calls the provisioning API for the service's account. One Idempotency-Key
+ * is used for all attempts of a call, so a request that reached the server
+ * before a timeout is answered from its first response.
+ */
+function lightweightphp_call(array $params, $action, $method, $suffix, $body)
+{
+    $path = '/api/v1/provisioning/accounts';
+    if ($action !== 'CreateAccount') {
+        $path .= '/' . rawurlencode($params['username']);
+    }
+    $path .= $suffix;
+    $key = 'whmcs-' . $params['serviceid'] . '-' . strtolower($action) . '-' . bin2hex(random_bytes(8));
+
+    for ($attempt = 1; ; $attempt++) {
+        $result = lightweightphp_request($params, $method, $path, $body, $key);
+        $retry = $result['error'] !== '' || $result['status'] >= 500
+            || ($result['status'] === 409 && !empty($result['response']['retryable']));
+        if (!$retry || $attempt >= 3) {
+            break;
+        }
+        sleep(2 * $attempt);
+    }
+    logModuleCall('lightweightphp', $action, array('path' => $path, 'body' => $body), $result['raw'], $result['response'], array($params['serverpassword']));
+    return $result;
+}
+
+function lightweightphp_request(array $params, $method, $path, $body, $idempotencyKey)
+{
+    $scheme = $params['serversecure'] ? 'https' : 'http';
+    $port = $params['serverport'] ?: ($params['serversecure'] ? '8443' : '8080');
+    $url = $scheme . '://' . $params['serverhostname'] . ':' . $port . $path;
+
+    $headers = array(
+        'Authorization: Bearer ' . $params['serverpassword'],
+        'Accept: application/json',
+    );
+    if ($idempotencyKey !== null) {
+        $headers[] = 'Idempotency-Key: ' . $idempotencyKey;
+    }
+
+    $ch = curl_init($url);
+    curl_setopt($ch, CURLOPT_CUSTOMREQUEST, $method);
+    curl_setopt($ch, CURLOPT_RETURNTRANSFER, true);
+    curl_setopt($ch, CURLOPT_CONNECTTIMEOUT, 10);
+    curl_setopt($ch, CURLOPT_TIMEOUT, 120);
+    if ($body !== null) {
+        curl_setopt($ch, CURLOPT_POSTFIELDS, json_encode($body));
+        $headers[] = 'Content-Type: application/json';
+    }
+    curl_setopt($ch, CURLOPT_HTTPHEADER, $headers);
+
+    $raw = curl_exec($ch);
+    $error = $raw === false ? curl_error($ch) : '';
+    $status = (int) curl_getinfo($ch, CURLINFO_HTTP_CODE);
+    curl_close($ch);
+
+    return array(
+        'status' => $status,
+        'error' => $error,
+        'raw' => $raw === false ? '' : $raw,
+        'response' => $raw === false ? null : json_decode($raw, true),
+    );
+}
+
+function lightweightphp_result(array $result)
+{
+    if ($result['error'] !== '') {
+        return 'Connection failed: ' . $result['error'];
+    }
+    if ($result['status'] >= 200 && $result['status'] < 300) {
+        return 'success';
+    }
+    return lightweightphp_message($result);
+}
+
+function lightweightphp_message(array $result)
+{
+    $response = $result['response'];
+    if (!is_array($response) || !isset($response['message'])) {
+        return 'HTTP ' . $result['status'];
+    }
+    $message = $response['message'];
+    if (!empty($response['fields'])) {
+        $fields = array();
+        foreach ($response['fields'] as $field) {
+            $fields[] = $field['field'] . ' ' . $field['message'];
+        }
+        $message .= ': ' . implode('; ', $fields);
+    }
+    return $message;
+}
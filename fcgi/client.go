@@ -0,0 +1,188 @@
+package fcgi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FastCGI record types and roles (see the FastCGI 1.0 specification)
+const (
+	fcgiVersion1     = 1
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+	roleResponder    = 1
+	maxRecordContent = 65535
+	requestID        = 1
+)
+
+// Response is the parsed result of a FastCGI request
+type Response struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	Stderr   []byte
+	Duration time.Duration
+}
+
+// Do sends a single responder request to a FastCGI server (e.g. a PHP-FPM
+// pool socket) and returns the parsed response. network is "unix" or "tcp".
+func Do(network, address string, params map[string]string, stdin []byte, timeout time.Duration) (*Response, error) {
+	start := time.Now()
+
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	w := bufio.NewWriter(conn)
+
+	// BEGIN_REQUEST: role (2 bytes), flags (1 byte), reserved (5 bytes)
+	begin := []byte{0, roleResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(w, typeBeginRequest, begin); err != nil {
+		return nil, err
+	}
+
+	if err := writeStream(w, typeParams, encodeParams(params)); err != nil {
+		return nil, err
+	}
+	if err := writeStream(w, typeStdin, stdin); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	for {
+		recType, content, err := readRecord(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %w", err)
+		}
+		switch recType {
+		case typeStdout:
+			stdout.Write(content)
+		case typeStderr:
+			stderr.Write(content)
+		case typeEndRequest:
+			resp, err := parseResponse(stdout.Bytes())
+			if err != nil {
+				return nil, err
+			}
+			resp.Stderr = stderr.Bytes()
+			resp.Duration = time.Since(start)
+			return resp, nil
+		}
+	}
+}
+
+func writeRecord(w io.Writer, recType byte, content []byte) error {
+	padding := byte((8 - len(content)%8) % 8)
+	header := []byte{fcgiVersion1, recType, 0, requestID, 0, 0, padding, 0}
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if _, err := w.Write(content); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	if _, err := w.Write(make([]byte, padding)); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+// writeStream writes data as a sequence of records terminated by an empty one
+func writeStream(w io.Writer, recType byte, data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxRecordContent {
+			n = maxRecordContent
+		}
+		if err := writeRecord(w, recType, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return writeRecord(w, recType, nil)
+}
+
+func readRecord(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint16(header[4:6])
+	padding := header[6]
+	content := make([]byte, int(length)+int(padding))
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return header[1], content[:length], nil
+}
+
+func encodeParams(params map[string]string) []byte {
+	var buf bytes.Buffer
+	for name, value := range params {
+		writeParamLength(&buf, len(name))
+		writeParamLength(&buf, len(value))
+		buf.WriteString(name)
+		buf.WriteString(value)
+	}
+	return buf.Bytes()
+}
+
+func writeParamLength(buf *bytes.Buffer, n int) {
+	if n < 128 {
+		buf.WriteByte(byte(n))
+		return
+	}
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(n)|1<<31)
+	buf.Write(b)
+}
+
+// parseResponse splits CGI-style headers from the body
+func parseResponse(stdout []byte) (*Response, error) {
+	resp := &Response{Status: http.StatusOK, Header: http.Header{}}
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(stdout)))
+	mime, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse response headers: %w", err)
+	}
+	resp.Header = http.Header(mime)
+
+	if status := resp.Header.Get("Status"); status != "" {
+		code, err := strconv.Atoi(strings.Fields(status)[0])
+		if err == nil {
+			resp.Status = code
+		}
+	}
+
+	if idx := bytes.Index(stdout, []byte("\r\n\r\n")); idx >= 0 {
+		resp.Body = stdout[idx+4:]
+	} else if idx := bytes.Index(stdout, []byte("\n\n")); idx >= 0 {
+		resp.Body = stdout[idx+2:]
+	}
+
+	return resp, nil
+}
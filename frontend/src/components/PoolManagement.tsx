@@ -14,6 +14,7 @@ export default function PoolManagement() {
   const [deleting, setDeleting] = useState<string | null>(null)
   const [showConfigEditor, setShowConfigEditor] = useState(false)
   const [poolConfig, setPoolConfig] = useState<PoolConfig>({})
+  const [configRevision, setConfigRevision] = useState(0)
   const [updatingConfig, setUpdatingConfig] = useState(false)
   const [expandedPools, setExpandedPools] = useState<Set<string>>(new Set())
   
@@ -182,13 +183,16 @@ export default function PoolManagement() {
 
   const handleEditPool = async (username: string) => {
     const result = await apiService.getPool(username)
-    if (result.data) {
+    const config = await apiService.getPoolConfig(username)
+    if (result.data && config.data) {
       setSelectedPool(result.data)
+      setPoolConfig(config.data.settings)
+      setConfigRevision(config.data.revision)
       setShowConfigEditor(true)
       setError(null)
       setSuccess(null)
     } else {
-      setError(result.error || 'Failed to load pool details')
+      setError(result.error || config.error || 'Failed to load pool details')
     }
   }
 
@@ -200,7 +204,7 @@ export default function PoolManagement() {
     setError(null)
     setSuccess(null)
 
-    const result = await apiService.updatePoolConfig(selectedPool.User, poolConfig)
+    const result = await apiService.updatePoolConfig(selectedPool.User, poolConfig, configRevision)
     
     if (result.data) {
       setSuccess(`Pool configuration updated successfully for ${selectedPool.User}!`)
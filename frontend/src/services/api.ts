@@ -4,12 +4,64 @@ const API_BASE_URL = import.meta.env.PROD
   : '' // Empty string means use relative URLs (goes through Vite proxy)
 
 export interface Pool {
+  ID: number
   User: string
   PHPVersion: string
   Provider?: string
   Status: string
   ConfigPath: string
   SocketPath: string
+  Labels?: Record<string, string>
+  DiskUsage?: DiskUsage
+  Suspension?: Suspension
+}
+
+export interface Suspension {
+  reason?: string
+  suspended_at: string
+}
+
+export interface DiskUsage {
+  filesystem: string
+  used_bytes: number
+  limit_bytes: number
+  percent: number
+  error?: string
+}
+
+export interface PoolSelector {
+  labels?: Record<string, string>
+  php_version?: string
+  provider?: string
+  all?: boolean
+}
+
+export interface BatchResult {
+  index: number
+  op: string
+  username: string
+  status: 'applied' | 'rolled_back' | 'failed' | 'skipped'
+  error?: string
+}
+
+export interface PoolSpec {
+  php_version: string
+  provider?: string
+  target?: string
+  settings?: PoolConfig
+}
+
+export interface PoolResource {
+  id: number
+  username: string
+  php_version: string
+  provider: string
+  target: string
+  status: string
+  socket_path: string
+  settings: PoolConfig
+  revision: number
+  result?: 'created' | 'updated' | 'no-change'
 }
 
 export interface Provider {
@@ -19,12 +71,42 @@ export interface Provider {
   status: string
 }
 
+export interface InstallLog {
+  id: number
+  provider: string
+  version: string
+  operation: string
+  target?: string
+  status: 'running' | 'success' | 'failed'
+  error?: string
+  started_at: string
+  finished_at?: string
+  duration_ms?: number
+  output?: string
+}
+
 export interface PhpVersion {
   version: string
   provider: string
   status: string
 }
 
+export interface FpmConfig {
+  version: string
+  provider: string
+  path: string
+  settings: Record<string, string>
+  effective: Record<string, string>
+}
+
+export interface FpmLog {
+  version: string
+  source: 'file' | 'journal'
+  path?: string
+  unit?: string
+  lines: string[]
+}
+
 export interface PoolConfig {
   max_children?: number
   start_servers?: number
@@ -42,6 +124,55 @@ export interface PoolConfig {
   sendmail_path?: string
   process_idle_timeout?: string | number
   listen_mode?: string
+  listen_type?: 'socket' | 'tcp'
+  listen_address?: string
+  listen_port?: number
+  allowed_clients?: string[] | string
+  security_level?: 'relaxed' | 'standard' | 'hardened'
+  disable_functions_allow?: string[] | string
+  disable_functions_extra?: string[] | string
+  allow_url_fopen?: string | boolean
+  auto_tune?: boolean
+  disk_quota?: string | number
+}
+
+export interface PoolTestResult {
+  username: string
+  script?: string
+  ok: boolean
+  status?: number
+  response_time_ms: number
+  php_version?: string
+  expected_version: string
+  sapi?: string
+  stderr?: string
+  problems: string[]
+}
+
+export interface Profile {
+  name: string
+  description: string
+  settings: PoolConfig
+  builtin: boolean
+}
+
+export interface ScheduledChange {
+  id: number
+  username: string
+  settings?: Partial<PoolConfig>
+  php_version?: string
+  run_at: string
+  status: 'pending' | 'running' | 'applied' | 'failed' | 'cancelled'
+  error?: string
+  created_at: string
+  finished_at?: string
+}
+
+export interface ServerEvent {
+  type: 'pool.created' | 'pool.deleted' | 'pool.status' | 'pool.updated' | 'batch.progress' | 'php.install' | 'change.run' | 'template.render_failed' | 'quota.threshold' | 'certificate.issued' | 'certificate.failed' | 'ping'
+  username?: string
+  time: string
+  data?: Record<string, unknown>
 }
 
 export interface ApiResponse<T> {
@@ -56,11 +187,11 @@ class ApiService {
   ): Promise<ApiResponse<T>> {
     try {
       const response = await fetch(`${API_BASE_URL}${endpoint}`, {
+        ...options,
         headers: {
           'Content-Type': 'application/json',
           ...options.headers,
         },
-        ...options,
       })
 
       const data = await response.json()
@@ -93,6 +224,36 @@ class ApiService {
     )
   }
 
+  async uninstallPhpVersion(version: string, provider?: string, migrateTo?: string): Promise<ApiResponse<{ message: string; version: string; provider: string; migrated?: string[]; migrated_to?: string }>> {
+    const params = new URLSearchParams()
+    if (provider) params.set('provider', provider)
+    if (migrateTo) params.set('migrate_to', migrateTo)
+    const query = params.toString()
+    return this.request<{ message: string; version: string; provider: string; migrated?: string[]; migrated_to?: string }>(
+      `/api/v1/php/${version}${query ? `?${query}` : ''}`,
+      { method: 'DELETE' }
+    )
+  }
+
+  async getFpmConfig(version: string, provider?: string): Promise<ApiResponse<FpmConfig>> {
+    const query = provider ? `?provider=${encodeURIComponent(provider)}` : ''
+    return this.request<FpmConfig>(`/api/v1/php/${version}/fpm-config${query}`)
+  }
+
+  async updateFpmConfig(version: string, settings: Record<string, string | boolean | null>, provider?: string): Promise<ApiResponse<FpmConfig>> {
+    const query = provider ? `?provider=${encodeURIComponent(provider)}` : ''
+    return this.request<FpmConfig>(`/api/v1/php/${version}/fpm-config${query}`, {
+      method: 'PATCH',
+      body: JSON.stringify(settings),
+    })
+  }
+
+  async getFpmLog(version: string, lines = 100, provider?: string): Promise<ApiResponse<FpmLog>> {
+    const params = new URLSearchParams({ lines: String(lines) })
+    if (provider) params.set('provider', provider)
+    return this.request<FpmLog>(`/api/v1/php/${version}/fpm-log?${params}`)
+  }
+
   async getProviders(): Promise<ApiResponse<{ providers: Provider[] }>> {
     return this.request<{ providers: Provider[] }>('/api/v1/providers')
   }
@@ -112,6 +273,15 @@ class ApiService {
     return this.request<{ provider: string; versions: string[] }>(`/api/v1/providers/${provider}/available`)
   }
 
+  async getInstallLogs(provider: string, limit?: number): Promise<ApiResponse<InstallLog[]>> {
+    const query = limit ? `?limit=${limit}` : ''
+    return this.request<InstallLog[]>(`/api/v1/providers/${provider}/installs${query}`)
+  }
+
+  async getInstallLog(provider: string, id: number): Promise<ApiResponse<InstallLog>> {
+    return this.request<InstallLog>(`/api/v1/providers/${provider}/installs/${id}`)
+  }
+
   async getPhpVersions(): Promise<ApiResponse<{ versions: PhpVersion[] }>> {
     return this.request<{ versions: PhpVersion[] }>('/api/v1/php/versions')
   }
@@ -128,32 +298,155 @@ class ApiService {
     return this.request<Pool>(`/api/v1/pools/${username}`)
   }
 
-  async createPool(username: string, phpVersion: string = '8.2', provider: string = 'remi'): Promise<ApiResponse<{ message: string; username: string }>> {
-    return this.request<{ message: string; username: string }>(
+  async getPoolSpec(username: string): Promise<ApiResponse<PoolResource>> {
+    return this.request<PoolResource>(`/api/v1/pools/${username}/spec`)
+  }
+
+  async applyPoolSpec(username: string, spec: PoolSpec): Promise<ApiResponse<PoolResource>> {
+    return this.request<PoolResource>(`/api/v1/pools/${username}`, {
+      method: 'PUT',
+      body: JSON.stringify(spec),
+    })
+  }
+
+  async createPool(username: string, phpVersion: string = '8.2', provider: string = 'remi', profile?: string, user?: { shell?: string; ssh_key?: string }): Promise<ApiResponse<{ message: string; username: string; profile?: string }>> {
+    return this.request<{ message: string; username: string; profile?: string }>(
       '/api/v1/pools',
       {
         method: 'POST',
-        body: JSON.stringify({ username, php_version: phpVersion, provider }),
+        body: JSON.stringify({ username, php_version: phpVersion, provider, ...(profile ? { profile } : {}), ...(user ? { create_user: true, ...user } : {}) }),
       }
     )
   }
 
-  async deletePool(username: string): Promise<ApiResponse<{ message: string; username: string }>> {
-    return this.request<{ message: string; username: string }>(
-      `/api/v1/pools/${username}`,
+  async getProfiles(): Promise<ApiResponse<Profile[]>> {
+    return this.request<Profile[]>('/api/v1/profiles')
+  }
+
+  async deletePool(username: string, removeUser: boolean = false): Promise<ApiResponse<{ message: string; username: string; user?: 'locked' | 'deleted' }>> {
+    return this.request<{ message: string; username: string; user?: 'locked' | 'deleted' }>(
+      `/api/v1/pools/${username}${removeUser ? '?remove_user=true' : ''}`,
       { method: 'DELETE' }
     )
   }
 
-  async updatePoolConfig(username: string, config: PoolConfig): Promise<ApiResponse<{ message: string; username: string; settings: Partial<PoolConfig> }>> {
-    return this.request<{ message: string; username: string; settings: Partial<PoolConfig> }>(
+  async suspendPool(username: string, reason?: string): Promise<ApiResponse<{ message: string; username: string; status: string }>> {
+    return this.request<{ message: string; username: string; status: string }>(
+      `/api/v1/pools/${username}/suspend`,
+      { method: 'POST', body: JSON.stringify({ reason: reason ?? '' }) }
+    )
+  }
+
+  async unsuspendPool(username: string): Promise<ApiResponse<{ message: string; username: string; status: string }>> {
+    return this.request<{ message: string; username: string; status: string }>(
+      `/api/v1/pools/${username}/unsuspend`,
+      { method: 'POST' }
+    )
+  }
+
+  async getPoolConfig(username: string): Promise<ApiResponse<{ username: string; settings: PoolConfig; revision: number }>> {
+    return this.request<{ username: string; settings: PoolConfig; revision: number }>(
+      `/api/v1/pools/${username}/config`
+    )
+  }
+
+  // revision comes from getPoolConfig; the server rejects the update with 412
+  // if someone else changed the pool in the meantime
+  async updatePoolConfig(username: string, config: PoolConfig, revision: number): Promise<ApiResponse<{ message: string; username: string; settings: Partial<PoolConfig>; revision: number }>> {
+    return this.request<{ message: string; username: string; settings: Partial<PoolConfig>; revision: number }>(
       `/api/v1/pools/${username}/config`,
       {
         method: 'PUT',
+        headers: { 'If-Match': `"${revision}"` },
         body: JSON.stringify(config),
       }
     )
   }
+
+  // Merges settings into every pool matching the selector in one batch
+  async patchPoolsConfig(selector: PoolSelector, settings: Partial<PoolConfig>): Promise<ApiResponse<{ message: string; results: BatchResult[] }>> {
+    return this.request<{ message: string; results: BatchResult[] }>(
+      '/api/v1/pools/config',
+      {
+        method: 'PATCH',
+        body: JSON.stringify({ selector, settings }),
+      }
+    )
+  }
+
+  // A null value removes the label
+  async updatePoolLabels(username: string, labels: Record<string, string | null>): Promise<ApiResponse<Record<string, string>>> {
+    return this.request<Record<string, string>>(
+      `/api/v1/pools/${username}/labels`,
+      {
+        method: 'PATCH',
+        body: JSON.stringify(labels),
+      }
+    )
+  }
+
+  async getScheduledChanges(username?: string): Promise<ApiResponse<ScheduledChange[]>> {
+    const query = username ? `?username=${encodeURIComponent(username)}` : ''
+    return this.request<ScheduledChange[]>(`/api/v1/scheduled-changes${query}`)
+  }
+
+  // Without runAt the change runs in the next maintenance window
+  async scheduleChange(username: string, change: { settings?: Partial<PoolConfig>; php_version?: string; run_at?: string }): Promise<ApiResponse<ScheduledChange>> {
+    return this.request<ScheduledChange>(
+      '/api/v1/scheduled-changes',
+      {
+        method: 'POST',
+        body: JSON.stringify({ username, ...change }),
+      }
+    )
+  }
+
+  async updateScheduledChange(id: number, change: { settings?: Partial<PoolConfig>; php_version?: string; run_at?: string }): Promise<ApiResponse<ScheduledChange>> {
+    return this.request<ScheduledChange>(
+      `/api/v1/scheduled-changes/${id}`,
+      {
+        method: 'PATCH',
+        body: JSON.stringify(change),
+      }
+    )
+  }
+
+  async cancelScheduledChange(id: number): Promise<ApiResponse<ScheduledChange>> {
+    return this.request<ScheduledChange>(
+      `/api/v1/scheduled-changes/${id}`,
+      { method: 'DELETE' }
+    )
+  }
+
+  async testPool(username: string, script?: string): Promise<ApiResponse<PoolTestResult>> {
+    return this.request<PoolTestResult>(
+      `/api/v1/pools/${username}/test`,
+      {
+        method: 'POST',
+        body: script ? JSON.stringify({ script }) : undefined,
+      }
+    )
+  }
+
+  // subscribeEvents opens the live update stream; call the returned function
+  // to close it. token is only needed when the server has stream tokens.
+  subscribeEvents(onEvent: (event: ServerEvent) => void, token?: string): () => void {
+    const base = API_BASE_URL || window.location.origin
+    const url = new URL('/api/v1/ws', base)
+    url.protocol = url.protocol === 'https:' ? 'wss:' : 'ws:'
+    if (token) {
+      url.searchParams.set('token', token)
+    }
+
+    const socket = new WebSocket(url)
+    socket.onmessage = (message) => {
+      const event = JSON.parse(message.data) as ServerEvent
+      if (event.type !== 'ping') {
+        onEvent(event)
+      }
+    }
+    return () => socket.close()
+  }
 }
 
 export const apiService = new ApiService()
@@ -10,6 +10,7 @@ export default defineConfig({
         target: 'http://95.217.213.18:8981',
         changeOrigin: true,
         secure: false,
+        ws: true,
         rewrite: (path) => path,
       },
       '/health': {
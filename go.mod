@@ -4,11 +4,19 @@ go 1.21
 
 require (
 	github.com/gorilla/mux v1.8.1
+	github.com/mattn/go-isatty v0.0.16
 	github.com/spf13/cobra v1.8.0
+	github.com/spf13/pflag v1.0.5
 	modernc.org/sqlite v1.28.0
 )
 
 require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.3.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/sys v0.9.0 // indirect
+	modernc.org/libc v1.29.0 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.7.2 // indirect
 )
@@ -0,0 +1,44 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Run asks the helper listening on socket to run the CLI with args,
+// copying the operation's output to stdout and stderr, and returns its
+// exit code
+func Run(socket string, args []string, stdout, stderr io.Writer) (int, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach the helper at %s: %w", socket, err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(Request{Args: args}); err != nil {
+		return 0, fmt.Errorf("failed to send the request to the helper: %w", err)
+	}
+	dec := json.NewDecoder(conn)
+	for {
+		var f Frame
+		if err := dec.Decode(&f); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, fmt.Errorf("the helper closed the connection before the operation finished")
+			}
+			return 0, fmt.Errorf("failed to read the helper's answer: %w", err)
+		}
+		switch {
+		case f.Error != "":
+			return 0, errors.New(f.Error)
+		case f.Exit != nil:
+			return *f.Exit, nil
+		case f.Stream == "stdout":
+			stdout.Write(f.Data)
+		case f.Stream == "stderr":
+			stderr.Write(f.Data)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package helper separates privileges: a small daemon running as root
+// listens on a unix socket and runs lightweight-php operations for
+// unprivileged callers, after checking who they are (from the socket's
+// peer credentials, not anything they send) against the policy in the
+// helper section of the config file.
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"path"
+	"strconv"
+
+	"lightweight-php/config"
+)
+
+// ErrDenied is returned when the policy does not allow an operation
+var ErrDenied = errors.New("not allowed by the helper policy")
+
+// ScopeEnv tells an operation the helper runs which pools it may act on:
+// ScopeOwn when the deciding rule has own_pools, else ScopeAny. The
+// operation checks it once its arguments are parsed, together with CallerEnv
+// of the audit package.
+const ScopeEnv = "LIGHTWEIGHT_PHP_HELPER_SCOPE"
+
+// Scopes of ScopeEnv
+const (
+	ScopeAny = "any"
+	ScopeOwn = "own"
+)
+
+// Request asks the helper to run the CLI with Args
+type Request struct {
+	Args []string `json:"args"`
+}
+
+// Frame is one message of the helper's answer: output of the operation
+// on Stream ("stdout" or "stderr"), and finally its exit code, or Error
+// when it could not be run at all. Data is bytes, so binary output such as
+// a bundle written to standard output arrives intact.
+type Frame struct {
+	Stream string `json:"stream,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+	Exit   *int   `json:"exit,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Peer is the process on the other end of the socket
+type Peer struct {
+	UID int
+	GID int
+	PID int
+}
+
+// Caller is a peer resolved to its user and groups
+type Caller struct {
+	UID      int
+	Username string
+	Groups   []string
+}
+
+// resolveCaller looks up the user and groups of a peer
+func resolveCaller(p Peer) (*Caller, error) {
+	u, err := user.LookupId(strconv.Itoa(p.UID))
+	if err != nil {
+		return nil, fmt.Errorf("unknown uid %d: %w", p.UID, err)
+	}
+	c := &Caller{UID: p.UID, Username: u.Username}
+	gids, err := u.GroupIds()
+	if err != nil {
+		gids = []string{u.Gid}
+	}
+	for _, gid := range gids {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			c.Groups = append(c.Groups, g.Name)
+		}
+	}
+	return c, nil
+}
+
+// Allowed reports whether the rules let a caller run an operation: the
+// first rule matching both decides, and root may run everything. ownPools
+// reports that the deciding rule only allows it on the caller's own pool.
+func Allowed(rules []config.HelperRule, c *Caller, operation string) (allowed, ownPools bool) {
+	if c.UID == 0 {
+		return true, false
+	}
+	for _, rule := range rules {
+		if matchesOperation(rule.Operations, operation) && matchesCaller(rule, c) {
+			return rule.Action == "allow", rule.OwnPools
+		}
+	}
+	return false, false
+}
+
+func matchesOperation(patterns []string, operation string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, operation); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesCaller(rule config.HelperRule, c *Caller) bool {
+	if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+	for _, name := range rule.Users {
+		if name == c.Username {
+			return true
+		}
+	}
+	for _, name := range rule.Groups {
+		for _, g := range c.Groups {
+			if name == g {
+				return true
+			}
+		}
+	}
+	return false
+}
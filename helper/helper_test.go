@@ -0,0 +1,100 @@
+package helper
+
+import (
+	"bytes"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lightweight-php/audit"
+	"lightweight-php/config"
+)
+
+func TestAllowed(t *testing.T) {
+	rules := []config.HelperRule{
+		{Action: "deny", Operations: []string{"pool.delete"}, Groups: []string{"deploy"}},
+		{Action: "allow", Operations: []string{"pool.*"}, Groups: []string{"deploy"}},
+		{Action: "allow", Operations: []string{"pool.*", "backup.create"}, OwnPools: true},
+	}
+	deploy := &Caller{UID: 1001, Username: "ci", Groups: []string{"deploy"}}
+	tenant := &Caller{UID: 1002, Username: "bob", Groups: []string{"bob"}}
+	root := &Caller{UID: 0, Username: "root"}
+
+	tests := []struct {
+		caller    *Caller
+		operation string
+		allowed   bool
+		ownPools  bool
+	}{
+		{deploy, "pool.delete", false, false},
+		{deploy, "pool.set", true, false},
+		{tenant, "pool.set", true, true},
+		{tenant, "backup.create", true, true},
+		{tenant, "php.install", false, false},
+		{root, "php.install", true, false},
+	}
+	for _, tt := range tests {
+		allowed, ownPools := Allowed(rules, tt.caller, tt.operation)
+		if allowed != tt.allowed || ownPools != tt.ownPools {
+			t.Errorf("Allowed(%s, %s) = %v, %v, want %v, %v", tt.caller.Username, tt.operation, allowed, ownPools, tt.allowed, tt.ownPools)
+		}
+	}
+}
+
+// serve starts a helper running executable for every request on a socket
+// in a temporary directory and returns the socket
+func serve(t *testing.T, executable string, rules []config.HelperRule) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "helper.sock")
+	l, err := Listen(socket, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &Server{
+		Executable: executable,
+		Operation:  func(args []string) (string, error) { return "pool.export-bundle", nil },
+		Rules:      rules,
+	}
+	go s.Serve(l)
+	return socket
+}
+
+func TestServerPassesScope(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := serve(t, "/usr/bin/env", []config.HelperRule{{Action: "allow", Operations: []string{"pool.*"}, OwnPools: true}})
+
+	var stdout, stderr bytes.Buffer
+	code, err := Run(socket, nil, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("Run = %d, %v; stderr %q", code, err, stderr.String())
+	}
+	env := stdout.String()
+	// Root's own rules do not apply to it
+	want := ScopeEnv + "=" + ScopeAny
+	if current.Uid != "0" {
+		want = ScopeEnv + "=" + ScopeOwn
+	}
+	for _, line := range []string{want, audit.CallerEnv + "=" + current.Username, "PATH=" + safePath} {
+		if !strings.Contains(env, line+"\n") {
+			t.Errorf("operation environment %q lacks %s", env, line)
+		}
+	}
+}
+
+func TestServerStreamsBinaryOutput(t *testing.T) {
+	socket := serve(t, "/usr/bin/printf", nil)
+
+	var stdout, stderr bytes.Buffer
+	code, err := Run(socket, []string{`\037\213\000\377\376`}, &stdout, &stderr)
+	if err != nil || code != 0 {
+		t.Fatalf("Run = %d, %v; stderr %q", code, err, stderr.String())
+	}
+	if want := []byte{0x1f, 0x8b, 0x00, 0xff, 0xfe}; !bytes.Equal(stdout.Bytes(), want) {
+		t.Errorf("stdout = %x, want %x", stdout.Bytes(), want)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package helper
+
+import (
+	"errors"
+	"net"
+)
+
+func peerOf(conn *net.UnixConn) (Peer, error) {
+	return Peer{}, errors.New("peer credentials are only supported on Linux")
+}
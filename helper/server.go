@@ -0,0 +1,138 @@
+package helper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"lightweight-php/audit"
+	"lightweight-php/config"
+	"lightweight-php/unixsock"
+)
+
+// requestTimeout bounds reading a request, so idle connections do not
+// pile up
+const requestTimeout = 30 * time.Second
+
+// safePath is the PATH operations run with; the caller's is not used
+const safePath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Server runs operations for the callers the policy allows
+type Server struct {
+	// Executable is the lightweight-php binary operations are run with
+	Executable string
+	// Operation names the operation a request's arguments would run, or
+	// fails when they name none the helper runs
+	Operation func(args []string) (string, error)
+	// Rules is the policy, see Allowed
+	Rules []config.HelperRule
+}
+
+// Listen creates the helper's socket, replacing a stale one. Only root can
+// connect, or also group when it is set.
+func Listen(socket, group string) (*net.UnixListener, error) {
+	mode := os.FileMode(0600)
+	if group != "" {
+		mode = 0660
+	}
+	return unixsock.Listen(socket, mode, group)
+}
+
+// Serve answers requests until the listener is closed
+func (s *Server) Serve(l *net.UnixListener) error {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn *net.UnixConn) {
+	defer conn.Close()
+	out := &frameEncoder{enc: json.NewEncoder(conn)}
+
+	peer, err := peerOf(conn)
+	if err != nil {
+		out.send(Frame{Error: err.Error()})
+		return
+	}
+	caller, err := resolveCaller(peer)
+	if err != nil {
+		out.send(Frame{Error: err.Error()})
+		return
+	}
+
+	var req Request
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		out.send(Frame{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+	operation, err := s.Operation(req.Args)
+	if err != nil {
+		out.send(Frame{Error: err.Error()})
+		return
+	}
+	allowed, ownPools := Allowed(s.Rules, caller, operation)
+	if !allowed {
+		log.Printf("helper: denied %s to %s (pid %d)", operation, caller.Username, peer.PID)
+		out.send(Frame{Error: fmt.Sprintf("%s may not run %s: %v", caller.Username, operation, ErrDenied)})
+		return
+	}
+	log.Printf("helper: running %s for %s (pid %d)", operation, caller.Username, peer.PID)
+
+	scope := ScopeAny
+	if ownPools {
+		scope = ScopeOwn
+	}
+	cmd := exec.Command(s.Executable, req.Args...)
+	cmd.Env = []string{"PATH=" + safePath, "HOME=/root", audit.CallerEnv + "=" + caller.Username, ScopeEnv + "=" + scope}
+	cmd.Dir = "/"
+	cmd.Stdout = &streamWriter{out: out, stream: "stdout"}
+	cmd.Stderr = &streamWriter{out: out, stream: "stderr"}
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			out.send(Frame{Error: fmt.Sprintf("failed to run %s: %v", operation, err)})
+			return
+		}
+		code = exitErr.ExitCode()
+	}
+	out.send(Frame{Exit: &code})
+}
+
+// frameEncoder serializes the frames of both output streams
+type frameEncoder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (e *frameEncoder) send(f Frame) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(f)
+}
+
+// streamWriter turns an operation's output into frames
+type streamWriter struct {
+	out    *frameEncoder
+	stream string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	// A caller that went away does not stop the operation
+	w.out.send(Frame{Stream: w.stream, Data: append([]byte(nil), p...)})
+	return len(p), nil
+}
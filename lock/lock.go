@@ -0,0 +1,188 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLockDir holds one lock file per key so separate processes (CLI and
+// API server) exclude each other
+const DefaultLockDir = "/run/lightweight-php/locks"
+
+// Well-known lock keys
+const (
+	// KeyPackageManager serializes apt/dnf/yum runs, which cannot run in parallel
+	KeyPackageManager = "package-manager"
+)
+
+// ErrBusy is returned when a conflicting operation holds the lock
+var ErrBusy = errors.New("a conflicting operation is in progress")
+
+// BusyError is the ErrBusy of a lock, telling which one was held
+type BusyError struct {
+	Key string
+	msg string
+}
+
+func (e *BusyError) Error() string {
+	return ErrBusy.Error() + ": " + e.msg
+}
+
+// Is makes errors.Is(err, ErrBusy) hold
+func (e *BusyError) Is(target error) bool {
+	return target == ErrBusy
+}
+
+// PoolKey returns the lock key for a user's pool files
+func PoolKey(username string) string {
+	return "pool:" + username
+}
+
+// ServiceKey returns the lock key for reloading a service
+func ServiceKey(service string) string {
+	return "service:" + service
+}
+
+// FPMConfigKey returns the lock key for editing a php-fpm.conf
+func FPMConfigKey(path string) string {
+	return "fpm-config:" + path
+}
+
+// Manager hands out locks keyed by name
+type Manager struct {
+	dir   string
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// Default is the process-wide lock manager shared by all managers
+var Default = NewManager(DefaultLockDir)
+
+func NewManager(dir string) *Manager {
+	return &Manager{
+		dir:   dir,
+		slots: make(map[string]chan struct{}),
+	}
+}
+
+// Lock is a held lock; call Release when done
+type Lock struct {
+	key  string
+	slot chan struct{}
+	file *os.File
+}
+
+// Acquire takes the lock for key. With wait set it blocks up to timeout;
+// otherwise it fails immediately. In both cases a lock that cannot be
+// obtained returns an error wrapping ErrBusy. operation is recorded in the
+// lock file to tell waiters who holds it.
+func (m *Manager) Acquire(key, operation string, wait bool, timeout time.Duration) (*Lock, error) {
+	deadline := time.Now().Add(timeout)
+
+	// In-process exclusion first so goroutines don't poll the file lock
+	slot := m.slot(key)
+	if wait {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case slot <- struct{}{}:
+		case <-timer.C:
+			return nil, m.busyError(key, true)
+		}
+	} else {
+		select {
+		case slot <- struct{}{}:
+		default:
+			return nil, m.busyError(key, false)
+		}
+	}
+
+	file, err := m.lockFile(key, wait, deadline)
+	if err != nil {
+		<-slot
+		return nil, err
+	}
+
+	file.Truncate(0)
+	file.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), operation)), 0)
+
+	return &Lock{key: key, slot: slot, file: file}, nil
+}
+
+// Release frees the lock
+func (l *Lock) Release() {
+	if l == nil {
+		return
+	}
+	if l.file != nil {
+		unlockFile(l.file)
+		l.file.Close()
+	}
+	<-l.slot
+}
+
+func (m *Manager) slot(key string) chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	slot, ok := m.slots[key]
+	if !ok {
+		slot = make(chan struct{}, 1)
+		m.slots[key] = slot
+	}
+	return slot
+}
+
+func (m *Manager) lockFile(key string, wait bool, deadline time.Time) (*os.File, error) {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	file, err := os.OpenFile(m.path(key), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	for {
+		locked, err := tryLockFile(file)
+		if locked {
+			return file, nil
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", key, err)
+		}
+		if !wait || time.Now().After(deadline) {
+			file.Close()
+			return nil, m.busyError(key, wait)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// busyError describes the current holder of a lock when known
+func (m *Manager) busyError(key string, waited bool) error {
+	holder := ""
+	if content, err := os.ReadFile(m.path(key)); err == nil {
+		holder = strings.TrimSpace(string(content))
+	}
+
+	msg := key
+	if holder != "" {
+		msg = fmt.Sprintf("%s (held by pid %s)", key, holder)
+	}
+	if waited {
+		msg = "timed out waiting for " + msg
+	}
+	return &BusyError{Key: key, msg: msg}
+}
+
+func (m *Manager) path(key string) string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(key)
+	return filepath.Join(m.dir, name+".lock")
+}
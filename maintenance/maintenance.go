@@ -0,0 +1,126 @@
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/target"
+)
+
+// ErrOutsideWindow is returned when a heavy operation may not start now
+var ErrOutsideWindow = errors.New("outside the configured maintenance windows")
+
+// Command returns a command that runs at the configured CPU and I/O
+// priority. nice and ionice are skipped when not installed.
+func Command(name string, args ...string) *exec.Cmd {
+	cfg := config.Get().Maintenance
+
+	argv := append([]string{name}, args...)
+	if ioniceArgs := ioniceClassArgs(cfg.IOClass); ioniceArgs != nil && hasCommand("ionice") {
+		argv = append(append([]string{"ionice"}, ioniceArgs...), argv...)
+	}
+	if cfg.Nice > 0 && hasCommand("nice") {
+		argv = append([]string{"nice", "-n", strconv.Itoa(cfg.Nice)}, argv...)
+	}
+	return target.Host.Command(argv[0], argv[1:]...)
+}
+
+// LowerPriority applies the configured CPU and I/O priority to the current
+// process, so in-process work and every child it starts inherit them. Use it
+// only in short-lived CLI commands, never in the API server.
+func LowerPriority() error {
+	cfg := config.Get().Maintenance
+
+	if cfg.Nice > 0 {
+		if err := setNice(cfg.Nice); err != nil {
+			return fmt.Errorf("failed to set niceness: %w", err)
+		}
+	}
+	if ioniceArgs := ioniceClassArgs(cfg.IOClass); ioniceArgs != nil && hasCommand("ionice") {
+		args := append(ioniceArgs, "-p", strconv.Itoa(os.Getpid()))
+		if output, err := exec.Command("ionice", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to set I/O class: %s", output)
+		}
+	}
+	return nil
+}
+
+// NextWindow returns the start of the next maintenance window, or now when
+// a window is open or none is configured
+func NextWindow(now time.Time) time.Time {
+	windows := config.Get().Maintenance.Windows
+	if len(windows) == 0 {
+		return now
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var next time.Time
+	for _, w := range windows {
+		start, end, err := config.ParseWindow(w)
+		if err != nil {
+			continue
+		}
+		if inWindow(minute, start, end) {
+			return now
+		}
+		candidate := midnight.Add(time.Duration(start) * time.Minute)
+		if !candidate.After(now) {
+			candidate = candidate.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || candidate.Before(next) {
+			next = candidate
+		}
+	}
+	if next.IsZero() {
+		return now
+	}
+	return next
+}
+
+// Wait returns once a heavy operation may start. Outside the windows it
+// either sleeps until the next one opens, calling notify with its start, or
+// fails with ErrOutsideWindow when wait is false.
+func Wait(wait bool, notify func(time.Time)) error {
+	now := time.Now()
+	next := NextWindow(now)
+	if !next.After(now) {
+		return nil
+	}
+	if !wait {
+		return fmt.Errorf("%w; next window opens at %s", ErrOutsideWindow, next.Format("15:04"))
+	}
+	if notify != nil {
+		notify(next)
+	}
+	time.Sleep(time.Until(next))
+	return nil
+}
+
+func inWindow(minute, start, end int) bool {
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// The window spans midnight
+	return minute >= start || minute < end
+}
+
+func ioniceClassArgs(class string) []string {
+	switch class {
+	case "idle":
+		return []string{"-c", "3"}
+	case "best-effort":
+		return []string{"-c", "2", "-n", "7"}
+	}
+	return nil
+}
+
+func hasCommand(cmd string) bool {
+	_, err := exec.LookPath(cmd)
+	return err == nil
+}
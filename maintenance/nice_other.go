@@ -0,0 +1,12 @@
+//go:build !unix
+
+package maintenance
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setNice(nice int) error {
+	return fmt.Errorf("niceness cannot be set on %s", runtime.GOOS)
+}
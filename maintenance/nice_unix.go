@@ -0,0 +1,9 @@
+//go:build unix
+
+package maintenance
+
+import "syscall"
+
+func setNice(nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
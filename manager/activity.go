@@ -0,0 +1,261 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/templates"
+)
+
+// PoolActivity is how long a pool has gone without requests
+type PoolActivity struct {
+	Username       string `json:"username"`
+	ProcessManager string `json:"process_manager,omitempty"`
+	// LastRequestAt is when a request was last seen, or when the pool was
+	// first checked if none was seen since
+	LastRequestAt *time.Time `json:"last_request_at,omitempty"`
+	IdleDays      float64    `json:"idle_days"`
+	Inactive      bool       `json:"inactive"`
+	// ConvertedAt is when the inactivity policy switched the pool to
+	// pm = ondemand
+	ConvertedAt *time.Time `json:"converted_at,omitempty"`
+	// Action is what the policy did in this check: "alert" or "ondemand"
+	Action string `json:"action,omitempty"`
+	// Error is set instead of the figures when the status page cannot be
+	// read
+	Error string `json:"error,omitempty"`
+}
+
+// InactivityReport lists the activity of every pool, most idle first
+type InactivityReport struct {
+	Days      int            `json:"days"`
+	Action    string         `json:"action"`
+	CheckedAt time.Time      `json:"checked_at"`
+	Pools     []PoolActivity `json:"pools"`
+}
+
+// fpmPoolStatus is the part of PHP-FPM's JSON status page that is used
+type fpmPoolStatus struct {
+	ProcessManager string `json:"process manager"`
+	StartTime      int64  `json:"start time"`
+	AcceptedConn   int64  `json:"accepted conn"`
+	// ListenQueue is the number of connections waiting for a free worker
+	ListenQueue     int64 `json:"listen queue"`
+	ActiveProcesses int64 `json:"active processes"`
+	IdleProcesses   int64 `json:"idle processes"`
+}
+
+// inactiveAlerted remembers which users are inactive, so a pool becoming
+// inactive is reported once and again only after it saw requests
+var inactiveAlerted = struct {
+	sync.Mutex
+	users map[string]bool
+}{users: make(map[string]bool)}
+
+// CheckActivity reads the request counters of every pool from its PHP-FPM
+// status page and reports which pools had no requests for days (0 uses
+// inactivity.days). Suspended pools are skipped. Nothing is changed; see
+// ApplyInactivityPolicy.
+func (pm *PoolManager) CheckActivity(days int) (*InactivityReport, error) {
+	cfg := config.Get().Inactivity
+	if days <= 0 {
+		days = cfg.Days
+	}
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+
+	now := time.Now().UTC()
+	report := &InactivityReport{Days: days, Action: cfg.Action, CheckedAt: now, Pools: []PoolActivity{}}
+	for i := range pools {
+		if pools[i].Status == db.PoolSuspended {
+			continue
+		}
+		activity := PoolActivity{Username: pools[i].Username}
+		if err := pm.checkPoolActivity(&pools[i], days, now, &activity); err != nil {
+			activity.Error = err.Error()
+		}
+		report.Pools = append(report.Pools, activity)
+	}
+	sort.SliceStable(report.Pools, func(i, j int) bool {
+		return report.Pools[i].IdleDays > report.Pools[j].IdleDays
+	})
+	return report, nil
+}
+
+// checkPoolActivity compares a pool's counters with the previous check.
+// Every check is a request of its own, so only further accepted
+// connections count as activity.
+func (pm *PoolManager) checkPoolActivity(dbPool *db.Pool, days int, now time.Time, activity *PoolActivity) error {
+	status, err := pm.fpmStatus(dbPool)
+	if err != nil {
+		return err
+	}
+	activity.ProcessManager = status.ProcessManager
+
+	previous, err := pm.db.GetPoolActivity(dbPool.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read pool activity: %w", err)
+	}
+	record := &db.PoolActivity{
+		PoolID:        dbPool.ID,
+		StartTime:     status.StartTime,
+		AcceptedConn:  status.AcceptedConn,
+		LastRequestAt: now,
+		CheckedAt:     now,
+	}
+	if previous != nil {
+		record.LastRequestAt = previous.LastRequestAt
+		// The counter restarts with the pool, counting this check
+		seen := previous.AcceptedConn + 1
+		if status.StartTime != previous.StartTime {
+			seen = 1
+		}
+		if status.AcceptedConn > seen {
+			record.LastRequestAt = now
+		}
+		activity.ConvertedAt = previous.ConvertedAt
+	}
+	if err := pm.db.SavePoolActivity(record); err != nil {
+		return fmt.Errorf("failed to save pool activity: %w", err)
+	}
+
+	lastRequest := record.LastRequestAt.UTC()
+	activity.LastRequestAt = &lastRequest
+	activity.IdleDays = float64(int(now.Sub(lastRequest).Hours()/24*10)) / 10
+	activity.Inactive = now.Sub(lastRequest) >= time.Duration(days)*24*time.Hour
+	return nil
+}
+
+// ApplyInactivityPolicy checks every pool and applies inactivity.action to
+// pools that became inactive: "alert" publishes a pool.inactive event and
+// posts inactivity.webhook_url, "ondemand" also switches the pool to
+// pm = ondemand with inactivity.idle_timeout so idle workers exit.
+func (pm *PoolManager) ApplyInactivityPolicy() (*InactivityReport, error) {
+	cfg := config.Get().Inactivity
+	report, err := pm.CheckActivity(cfg.Days)
+	if err != nil {
+		return nil, err
+	}
+	for i := range report.Pools {
+		activity := &report.Pools[i]
+		if activity.Error != "" {
+			continue
+		}
+		inactiveAlerted.Lock()
+		became := activity.Inactive && !inactiveAlerted.users[activity.Username]
+		inactiveAlerted.users[activity.Username] = activity.Inactive
+		inactiveAlerted.Unlock()
+		if cfg.Action == "report" {
+			continue
+		}
+
+		if cfg.Action == "ondemand" && activity.Inactive && activity.ProcessManager != "ondemand" {
+			if err := pm.convertToOndemand(activity.Username, cfg.IdleTimeout); err != nil {
+				activity.Error = fmt.Sprintf("failed to switch to ondemand: %v", err)
+				continue
+			}
+			now := time.Now().UTC()
+			activity.ProcessManager = "ondemand"
+			activity.ConvertedAt = &now
+			activity.Action = "ondemand"
+			became = true
+		} else if became {
+			activity.Action = "alert"
+		}
+		if !became {
+			continue
+		}
+
+		events.Publish(events.PoolInactive, activity.Username, map[string]interface{}{
+			"idle_days": activity.IdleDays, "last_request_at": activity.LastRequestAt, "action": activity.Action,
+		})
+		if cfg.WebhookURL != "" {
+			if err := postInactivityWebhook(cfg.WebhookURL, activity); err != nil {
+				activity.Error = err.Error()
+			}
+		}
+	}
+	return report, nil
+}
+
+// convertToOndemand switches a pool to pm = ondemand and records it
+func (pm *PoolManager) convertToOndemand(username, idleTimeout string) error {
+	if err := pm.PatchPoolConfig(username, map[string]interface{}{
+		"process_manager":      "ondemand",
+		"process_idle_timeout": idleTimeout,
+	}); err != nil {
+		return err
+	}
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil || dbPool == nil {
+		return err
+	}
+	now := time.Now()
+	return pm.db.SetPoolConverted(dbPool.ID, &now)
+}
+
+// fpmStatus reads a pool's PHP-FPM status page through its listener
+func (pm *PoolManager) fpmStatus(dbPool *db.Pool) (*fpmPoolStatus, error) {
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	listen, err := poolListen(t, dbPool)
+	if err != nil {
+		return nil, err
+	}
+	params := fastCGIParams(templates.PoolStatusPath)
+	params["QUERY_STRING"] = "json"
+	params["REQUEST_URI"] = templates.PoolStatusPath + "?json"
+	resp, err := dialPool(listen, params, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the status page: %w", err)
+	}
+	if resp.Status != http.StatusOK {
+		// Pools written before the status page existed get it with their
+		// next config change
+		return nil, fmt.Errorf("status page not enabled (status %d); update the pool's config to add pm.status_path", resp.Status)
+	}
+	var status fpmPoolStatus
+	if err := json.Unmarshal(resp.Body, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse the status page: %w", err)
+	}
+	return &status, nil
+}
+
+func postInactivityWebhook(url string, activity *PoolActivity) error {
+	hostname, _ := os.Hostname()
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":           events.PoolInactive,
+		"host":            hostname,
+		"username":        activity.Username,
+		"idle_days":       activity.IdleDays,
+		"last_request_at": activity.LastRequestAt,
+		"action":          activity.Action,
+		"time":            time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post inactivity alert: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("inactivity webhook returned %s", resp.Status)
+	}
+	return nil
+}
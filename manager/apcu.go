@@ -0,0 +1,392 @@
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+)
+
+// APCuINIFile is the managed drop-in that loads APCu settings for a version.
+// APCu allocates one segment per FPM master, so apc.shm_size is the sum of
+// the allocations requested by the version's pools.
+const APCuINIFile = "99-lightweight-php-apcu.ini"
+
+const apcuStatusScript = ".lightweight-php-apcu-status.php"
+
+const (
+	defaultAPCuShmSize    int64 = 32 << 20
+	defaultOpcacheMemory  int64 = 128 << 20
+	sharedMemoryWarnRatio       = 0.8
+)
+
+// APCuUsage is the state of the APCu segment serving a pool. The segment is
+// shared by every pool of the same PHP version and provider.
+type APCuUsage struct {
+	Loaded      bool  `json:"loaded"`
+	Enabled     bool  `json:"enabled"`
+	SegmentSize int64 `json:"segment_size"`
+	Available   int64 `json:"available"`
+	Used        int64 `json:"used"`
+	Entries     int64 `json:"entries"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+}
+
+// SharedMemoryReport compares the shared memory configured for OPcache and
+// APCu across all pools with the kernel limits
+type SharedMemoryReport struct {
+	Allocated   int64    `json:"allocated"`
+	Limit       int64    `json:"limit"`
+	LimitSource string   `json:"limit_source"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// PoolStatus is a pool with runtime information gathered from its listener
+type PoolStatus struct {
+	Pool         Pool                `json:"pool"`
+	APCu         *APCuUsage          `json:"apcu,omitempty"`
+	APCuError    string              `json:"apcu_error,omitempty"`
+	SharedMemory *SharedMemoryReport `json:"shared_memory,omitempty"`
+}
+
+// GetPoolStatus returns a pool with its APCu usage and the host's shared
+// memory report. APCu errors are reported in the status rather than failing.
+func (pm *PoolManager) GetPoolStatus(username string) (*PoolStatus, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+
+	status := &PoolStatus{Pool: Pool{
+		User:       dbPool.Username,
+		PHPVersion: dbPool.PHPVersion,
+		Provider:   dbPool.Provider,
+		Status:     dbPool.Status,
+		ConfigPath: dbPool.ConfigPath,
+		SocketPath: dbPool.SocketPath,
+	}}
+
+	if usage, err := pm.APCuUsage(username); err != nil {
+		status.APCuError = err.Error()
+	} else {
+		status.APCu = usage
+	}
+
+	if report, err := pm.CheckSharedMemory(); err == nil {
+		status.SharedMemory = report
+	}
+	return status, nil
+}
+
+// APCuUsage queries the APCu segment of a pool through its FastCGI listener
+func (pm *PoolManager) APCuUsage(username string) (*APCuUsage, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+
+	resp, err := pm.runPoolScript(dbPool, apcuStatusScript, `<?php
+if (!function_exists('apcu_sma_info')) {
+    echo json_encode(['loaded' => false]);
+    return;
+}
+$sma = apcu_enabled() ? apcu_sma_info(true) : false;
+$cache = apcu_enabled() ? apcu_cache_info(true) : false;
+echo json_encode([
+    'loaded' => true,
+    'enabled' => apcu_enabled(),
+    'segment_size' => $sma ? $sma['num_seg'] * $sma['seg_size'] : 0,
+    'available' => $sma ? (int) $sma['avail_mem'] : 0,
+    'entries' => $cache ? $cache['num_entries'] : 0,
+    'hits' => $cache ? $cache['num_hits'] : 0,
+    'misses' => $cache ? $cache['num_misses'] : 0,
+]);
+`)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != 200 {
+		return nil, fmt.Errorf("APCu status request failed (status %d): %s", resp.Status, strings.TrimSpace(string(resp.Body)))
+	}
+
+	usage := &APCuUsage{}
+	if err := json.Unmarshal(resp.Body, usage); err != nil {
+		return nil, fmt.Errorf("failed to decode APCu status: %w", err)
+	}
+	if usage.SegmentSize > usage.Available {
+		usage.Used = usage.SegmentSize - usage.Available
+	}
+	return usage, nil
+}
+
+// syncAPCu installs APCu when a pool of the version enables it and writes
+// the version's segment size as the sum of the enabled pools' allocations.
+// The ini is removed once no pool of the version uses APCu.
+func (pm *PoolManager) syncAPCu(phpProvider provider.PHPProvider, dbPool *db.Pool) error {
+	pools, err := pm.poolSettingsFor(dbPool.PHPVersion, dbPool.Provider)
+	if err != nil {
+		return err
+	}
+	master := poolMaster(dbPool)
+
+	var total int64
+	enabled := 0
+	for _, p := range pools {
+		if p.master != master || !apcuEnabled(p.settings) {
+			continue
+		}
+		size, err := apcuShmSize(p.settings)
+		if err != nil {
+			return err
+		}
+		total += size
+		enabled++
+	}
+
+	t, err := target.Parse(dbPool.Target)
+	if err != nil {
+		return err
+	}
+	confDir, err := t.Path(phpProvider.GetConfDir(dbPool.PHPVersion))
+	if err != nil {
+		return err
+	}
+	iniPath := filepath.Join(confDir, APCuINIFile)
+	if enabled == 0 {
+		if err := os.Remove(iniPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove APCu config: %w", err)
+		}
+		return nil
+	}
+
+	if !extensionConfigured(confDir, "apcu") {
+		l, err := pm.acquire(lock.KeyPackageManager, "install APCu for PHP "+dbPool.PHPVersion)
+		if err != nil {
+			return err
+		}
+		_, factory, err := pm.poolTarget(dbPool)
+		if err == nil {
+			err = recordInstall(pm.db, factory, provider.ProviderType(phpProvider.GetProviderType()), dbPool.PHPVersion, "extension apcu", InstallProgress{}, func(p provider.PHPProvider) error {
+				return p.InstallExtension(dbPool.PHPVersion, "apcu")
+			})
+		}
+		l.Release()
+		if err != nil {
+			return fmt.Errorf("failed to install APCu: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return fmt.Errorf("failed to create conf directory: %w", err)
+	}
+	ini := fmt.Sprintf("; Managed by lightweight-php: %d pool(s) with APCu enabled\napc.shm_size = %dM\n", enabled, total>>20)
+	if err := os.WriteFile(iniPath, []byte(ini), 0644); err != nil {
+		return fmt.Errorf("failed to write APCu config: %w", err)
+	}
+	return nil
+}
+
+// extensionConfigured reports whether a package already dropped an ini for
+// the extension into the conf dir
+func extensionConfigured(confDir, extension string) bool {
+	matches, _ := filepath.Glob(filepath.Join(confDir, "*"+extension+"*.ini"))
+	for _, m := range matches {
+		if filepath.Base(m) != APCuINIFile {
+			return true
+		}
+	}
+	return false
+}
+
+// storedPoolSettings is a pool's settings keyed by the FPM master serving it
+type storedPoolSettings struct {
+	master   string
+	settings map[string]interface{}
+}
+
+// poolMaster identifies the FPM master serving a pool: one per provider,
+// version and execution target
+func poolMaster(p *db.Pool) string {
+	master := p.Provider + "/" + p.PHPVersion
+	if p.Target != "" {
+		master = p.Target + "/" + master
+	}
+	return master
+}
+
+// poolSettingsFor returns the stored settings of every pool of a version and
+// provider; empty filters match all pools
+func (pm *PoolManager) poolSettingsFor(phpVersion, providerName string) ([]storedPoolSettings, error) {
+	dbPools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+
+	all := make([]storedPoolSettings, 0, len(dbPools))
+	for _, p := range dbPools {
+		if (phpVersion != "" && p.PHPVersion != phpVersion) || (providerName != "" && p.Provider != providerName) {
+			continue
+		}
+		raw, _, err := pm.db.GetPoolSettings(p.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get settings of pool %s: %w", p.Username, err)
+		}
+		settings := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+			return nil, fmt.Errorf("failed to decode settings of pool %s: %w", p.Username, err)
+		}
+		all = append(all, storedPoolSettings{master: poolMaster(&p), settings: settings})
+	}
+	return all, nil
+}
+
+// CheckSharedMemory sums the OPcache and APCu segments configured across all
+// pools and warns when the total approaches the kernel's shared memory
+// limits or the installed memory. Each FPM master allocates one OPcache
+// segment, sized by the largest request among its pools, and one APCu
+// segment holding the sum of its pools' allocations.
+func (pm *PoolManager) CheckSharedMemory() (*SharedMemoryReport, error) {
+	pools, err := pm.poolSettingsFor("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	opcache := make(map[string]int64)
+	var apcu int64
+	for _, p := range pools {
+		size := defaultOpcacheMemory
+		if value, ok := p.settings["opcache_memory_consumption"]; ok {
+			if s, ok := settingString(value); ok {
+				if parsed, err := parseMegabytes(s); err == nil {
+					size = parsed
+				}
+			}
+		}
+		if size > opcache[p.master] {
+			opcache[p.master] = size
+		}
+
+		if apcuEnabled(p.settings) {
+			if size, err := apcuShmSize(p.settings); err == nil {
+				apcu += size
+			}
+		}
+	}
+
+	report := &SharedMemoryReport{Allocated: apcu}
+	for _, size := range opcache {
+		report.Allocated += size
+	}
+
+	report.Limit, report.LimitSource = sharedMemoryLimit()
+	if report.Limit > 0 {
+		ratio := float64(report.Allocated) / float64(report.Limit)
+		if ratio >= 1 {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("shared memory allocations (%dM) exceed %s (%dM)", report.Allocated>>20, report.LimitSource, report.Limit>>20))
+		} else if ratio >= sharedMemoryWarnRatio {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("shared memory allocations (%dM) are at %.0f%% of %s (%dM)", report.Allocated>>20, ratio*100, report.LimitSource, report.Limit>>20))
+		}
+	}
+	return report, nil
+}
+
+// sharedMemoryLimit returns the smallest of kernel.shmmax, kernel.shmall and
+// MemTotal, with the name of the limit that applies
+func sharedMemoryLimit() (int64, string) {
+	var limit int64
+	var source string
+	consider := func(value int64, name string) {
+		if value > 0 && (limit == 0 || value < limit) {
+			limit, source = value, name
+		}
+	}
+
+	if v, err := readProcInt("/proc/sys/kernel/shmmax"); err == nil {
+		consider(v, "kernel.shmmax")
+	}
+	if v, err := readProcInt("/proc/sys/kernel/shmall"); err == nil && v < (1<<62)/int64(os.Getpagesize()) {
+		consider(v*int64(os.Getpagesize()), "kernel.shmall")
+	}
+	if f, err := os.Open("/proc/meminfo"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "MemTotal:" {
+				if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					consider(kb<<10, "MemTotal")
+				}
+				break
+			}
+		}
+		f.Close()
+	}
+	return limit, source
+}
+
+func readProcInt(path string) (int64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+}
+
+func apcuEnabled(settings map[string]interface{}) bool {
+	flag, ok := settingFlag(settings["apcu_enabled"])
+	return ok && flag == "1"
+}
+
+// apcuShmSize returns a pool's requested APCu allocation in bytes
+func apcuShmSize(settings map[string]interface{}) (int64, error) {
+	value, ok := settings["apcu_shm_size"]
+	if !ok {
+		return defaultAPCuShmSize, nil
+	}
+	s, ok := settingString(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid value for apcu_shm_size")
+	}
+	return parseMegabytes(s)
+}
+
+// parseMegabytes parses an ini size such as "64M" or "1G"; like apc.shm_size
+// and opcache.memory_consumption, a bare number is megabytes
+func parseMegabytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	shift := uint(20)
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		shift = 10
+		s = s[:len(s)-1]
+	case 'M', 'm':
+		s = s[:len(s)-1]
+	case 'G', 'g':
+		shift = 30
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"context"
+	"time"
+
+	"lightweight-php/audit"
+	"lightweight-php/config"
+	"lightweight-php/db"
+)
+
+// AuditEntry is an operation recorded in the audit log
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Actor     string    `json:"actor"`
+	Remote    string    `json:"remote,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditFilter selects audit log entries; empty fields match all
+type AuditFilter struct {
+	RequestID string
+	Target    string
+	Actor     string
+	Limit     int
+}
+
+// recordAudit stores an operation with the caller and request ID carried
+// by ctx and the error in *errp, for use as "defer recordAudit(..., &err)"
+// in functions with a named error result. Recording never fails the caller.
+func recordAudit(ctx context.Context, database *db.Database, action, target string, errp *error) {
+	caller := audit.FromContext(ctx)
+	entry := &db.AuditEntry{
+		CreatedAt: time.Now().UTC(),
+		RequestID: caller.RequestID,
+		Actor:     caller.Actor,
+		Remote:    caller.Remote,
+		Action:    action,
+		Target:    target,
+	}
+	if errp != nil && *errp != nil {
+		entry.Error = (*errp).Error()
+	}
+	if _, dbErr := database.CreateAuditEntry(entry); dbErr != nil {
+		return
+	}
+	if days := config.Get().Retention.AuditDays; days > 0 {
+		database.PruneAuditEntries(time.Now().UTC().AddDate(0, 0, -days))
+	}
+}
+
+// ListAudit returns audit log entries, newest first
+func (pm *PoolManager) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	records, err := pm.db.ListAuditEntries(db.AuditFilter{
+		RequestID: f.RequestID,
+		Target:    f.Target,
+		Actor:     f.Actor,
+		Limit:     f.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, AuditEntry{
+			ID:        r.ID,
+			Time:      r.CreatedAt,
+			RequestID: r.RequestID,
+			Actor:     r.Actor,
+			Remote:    r.Remote,
+			Action:    r.Action,
+			Target:    r.Target,
+			Error:     r.Error,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,672 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/objstore"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+const (
+	backupFormatVersion = 1
+	backupMetadataFile  = "backup.json"
+	backupPoolDir       = "pools/"
+	backupFilesDir      = "files/"
+	// backupPrefix holds backups in a store as backups/<username>/<time>.tar.gz
+	backupPrefix     = "backups/"
+	backupTimeLayout = "20060102T150405Z"
+)
+
+// BackupMetadata describes an account backup
+type BackupMetadata struct {
+	FormatVersion int          `json:"format_version"`
+	Username      string       `json:"username"`
+	Host          string       `json:"host"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Pools         []string     `json:"pools"`
+	Sites         []BackupSite `json:"sites"`
+	// Files is set when the sites' document roots are included
+	Files bool `json:"files"`
+}
+
+// BackupSite is a site of the account with its path bindings
+type BackupSite struct {
+	Domain       string            `json:"domain"`
+	DocumentRoot string            `json:"document_root"`
+	Bindings     map[string]string `json:"bindings"`
+}
+
+// BackupObject is an account backup in an object store
+type BackupObject struct {
+	Key       string    `json:"key"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+}
+
+// RestoreReport describes what RestoreBackup or ImportManifest recreated.
+// Pools and sites that already exist are left alone.
+type RestoreReport struct {
+	Username     string    `json:"username"`
+	CreatedAt    time.Time `json:"created_at"`
+	PoolsCreated []string  `json:"pools_created"`
+	PoolsSkipped []string  `json:"pools_skipped"`
+	SitesCreated []string  `json:"sites_created"`
+	SitesSkipped []string  `json:"sites_skipped"`
+	FilesWritten int       `json:"files_written"`
+}
+
+// WriteBackup writes a gzipped tar archive of an account to w: the export
+// bundle of each of its pools, its sites with their bindings and, with
+// files set, the contents of the sites' document roots
+func (pm *PoolManager) WriteBackup(username string, files bool, w io.Writer) (*BackupMetadata, error) {
+	pools, err := pm.userPools(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+
+	host, _ := os.Hostname()
+	metadata := &BackupMetadata{
+		FormatVersion: backupFormatVersion,
+		Username:      username,
+		Host:          host,
+		CreatedAt:     time.Now().UTC(),
+		Pools:         make([]string, 0, len(pools)),
+		Files:         files,
+	}
+	for _, p := range pools {
+		metadata.Pools = append(metadata.Pools, p.PHPVersion)
+	}
+	if metadata.Sites, err = pm.userSites(username); err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	encoded, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup metadata: %w", err)
+	}
+	if err := writeTarFile(tw, backupMetadataFile, encoded); err != nil {
+		return nil, err
+	}
+	for i := range pools {
+		var bundle bytes.Buffer
+		if err := pm.writeBundle(&pools[i], &bundle); err != nil {
+			return nil, err
+		}
+		if err := writeTarFile(tw, backupPoolDir+pools[i].PHPVersion+".tar.gz", bundle.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if files {
+		for _, root := range documentRoots(metadata.Sites) {
+			if err := addTree(tw, root); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize backup: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finalize backup: %w", err)
+	}
+	return metadata, nil
+}
+
+// RestoreBackup recreates an account from a backup written by WriteBackup:
+// missing pools are imported from their bundles (the PHP versions must be
+// installed), missing sites are created and, with files set, the document
+// roots are extracted over the existing files and owned by the account.
+func (pm *PoolManager) RestoreBackup(r io.Reader, files bool) (*RestoreReport, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	var metadata *BackupMetadata
+	var report *RestoreReport
+	var roots []string
+	var owner *user.User
+	var links []*tar.Header
+	sitesDone := false
+
+	restoreSites := func() error {
+		if sitesDone {
+			return nil
+		}
+		sitesDone = true
+		return pm.restoreSites(metadata.Username, metadata.Sites, report)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("failed to read backup: %w", err)
+		}
+
+		if metadata == nil {
+			if hdr.Name != backupMetadataFile {
+				return nil, fmt.Errorf("not a backup: %s is missing", backupMetadataFile)
+			}
+			metadata = &BackupMetadata{}
+			if err := json.NewDecoder(tr).Decode(metadata); err != nil {
+				return nil, fmt.Errorf("failed to decode backup metadata: %w", err)
+			}
+			if metadata.FormatVersion != backupFormatVersion {
+				return nil, fmt.Errorf("unsupported backup format version %d", metadata.FormatVersion)
+			}
+			if err := validation.Field("username", metadata.Username, validation.Username); err != nil {
+				return nil, fmt.Errorf("invalid backup metadata: %w", err)
+			}
+			report = &RestoreReport{
+				Username:     metadata.Username,
+				CreatedAt:    metadata.CreatedAt,
+				PoolsCreated: make([]string, 0),
+				PoolsSkipped: make([]string, 0),
+				SitesCreated: make([]string, 0),
+				SitesSkipped: make([]string, 0),
+			}
+			roots = documentRoots(metadata.Sites)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(hdr.Name, backupPoolDir):
+			version := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, backupPoolDir), ".tar.gz")
+			if err := validation.Field("php_version", version, validation.PHPVersion); err != nil {
+				return report, fmt.Errorf("invalid backup entry %s: %w", hdr.Name, err)
+			}
+			existing, err := pm.db.GetPoolByUsernameAndVersion(metadata.Username, version)
+			if err != nil {
+				return report, fmt.Errorf("failed to check pool: %w", err)
+			}
+			if existing != nil {
+				report.PoolsSkipped = append(report.PoolsSkipped, version)
+				continue
+			}
+			bundle, err := ReadBundle(tr)
+			if err != nil {
+				return report, fmt.Errorf("failed to restore pool %s: %w", version, err)
+			}
+			// Sites come from the backup's metadata, once all pools exist
+			if err := pm.importBundlePool(bundle, version); err != nil {
+				return report, fmt.Errorf("failed to restore pool %s: %w", version, err)
+			}
+			if err := pm.restoreCronJobs(metadata.Username, bundle.Crons); err != nil {
+				return report, err
+			}
+			report.PoolsCreated = append(report.PoolsCreated, version)
+
+		case strings.HasPrefix(hdr.Name, backupFilesDir):
+			if err := restoreSites(); err != nil {
+				return report, err
+			}
+			if !files {
+				continue
+			}
+			if owner == nil {
+				if owner, err = target.Host.LookupUser(metadata.Username); err != nil {
+					return report, fmt.Errorf("failed to lookup user: %w", err)
+				}
+			}
+			dest := "/" + strings.TrimPrefix(hdr.Name, backupFilesDir)
+			if hdr.Typeflag == tar.TypeSymlink {
+				// Links are created last so no file is written through one
+				links = append(links, hdr)
+				continue
+			}
+			written, err := extractEntry(tr, hdr, dest, roots, owner)
+			if err != nil {
+				return report, err
+			}
+			if written {
+				report.FilesWritten++
+			}
+		}
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("not a backup: archive is empty")
+	}
+	if err := restoreSites(); err != nil {
+		return report, err
+	}
+	for _, hdr := range links {
+		dest := "/" + strings.TrimPrefix(hdr.Name, backupFilesDir)
+		file, err := restorePath(dest, roots)
+		if err != nil {
+			return report, err
+		}
+		os.Remove(file)
+		if err := os.Symlink(hdr.Linkname, file); err != nil {
+			return report, fmt.Errorf("failed to restore link %s: %w", dest, err)
+		}
+		chownTo(file, owner)
+	}
+	return report, nil
+}
+
+// restoreSites creates the user's sites that do not exist
+func (pm *PoolManager) restoreSites(username string, sites []BackupSite, report *RestoreReport) error {
+	sm := NewSiteManagerWithDeps(pm.db)
+	for _, s := range sites {
+		existing, err := pm.db.GetSite(s.Domain)
+		if err != nil {
+			return fmt.Errorf("failed to check site: %w", err)
+		}
+		if existing != nil {
+			report.SitesSkipped = append(report.SitesSkipped, s.Domain)
+			continue
+		}
+		if _, err := sm.CreateSite(s.Domain, username, s.DocumentRoot, s.Bindings["/"]); err != nil {
+			return fmt.Errorf("failed to restore site %s: %w", s.Domain, err)
+		}
+		for prefix, version := range s.Bindings {
+			if prefix == "/" {
+				continue
+			}
+			if _, err := sm.BindPath(s.Domain, prefix, version); err != nil {
+				return fmt.Errorf("failed to restore binding %s%s: %w", s.Domain, prefix, err)
+			}
+		}
+		report.SitesCreated = append(report.SitesCreated, s.Domain)
+	}
+	return nil
+}
+
+// UploadBackup writes a backup of an account to a store
+func (pm *PoolManager) UploadBackup(store objstore.Store, username string, files bool) (*BackupObject, error) {
+	tmp, err := os.CreateTemp("", "lightweight-php-backup-*.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	metadata, err := pm.WriteBackup(username, files, tmp)
+	if err != nil {
+		return nil, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	object := &BackupObject{
+		Key:       backupPrefix + username + "/" + metadata.CreatedAt.Format(backupTimeLayout) + ".tar.gz",
+		Username:  username,
+		CreatedAt: metadata.CreatedAt.Truncate(time.Second),
+		Size:      size,
+	}
+	if err := store.Put(object.Key, tmp, size); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// RunBackups uploads a backup of every account with a pool and prunes
+// backups older than retentionDays (0 keeps all). A failing account does not
+// stop the others.
+func (pm *PoolManager) RunBackups(store objstore.Store, files bool, retentionDays int) ([]BackupObject, error) {
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	usernames := make([]string, 0)
+	for _, p := range pools {
+		if !containsString(usernames, p.Username) {
+			usernames = append(usernames, p.Username)
+		}
+	}
+
+	uploaded := make([]BackupObject, 0, len(usernames))
+	var errs []string
+	for _, username := range usernames {
+		object, err := pm.UploadBackup(store, username, files)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", username, err))
+			continue
+		}
+		uploaded = append(uploaded, *object)
+	}
+	if retentionDays > 0 {
+		if _, err := PruneBackups(store, time.Now().AddDate(0, 0, -retentionDays)); err != nil {
+			errs = append(errs, fmt.Sprintf("prune: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return uploaded, fmt.Errorf("backup failed: %s", strings.Join(errs, "; "))
+	}
+	return uploaded, nil
+}
+
+// ListBackups returns the backups in a store, oldest first, optionally of
+// one account only
+func ListBackups(store objstore.Store, username string) ([]BackupObject, error) {
+	prefix := backupPrefix
+	if username != "" {
+		prefix += username + "/"
+	}
+	objects, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupObject, 0, len(objects))
+	for _, o := range objects {
+		user, name, ok := strings.Cut(strings.TrimPrefix(o.Key, backupPrefix), "/")
+		if !ok || !strings.HasSuffix(name, ".tar.gz") {
+			continue
+		}
+		created, err := time.Parse(backupTimeLayout, strings.TrimSuffix(name, ".tar.gz"))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupObject{Key: o.Key, Username: user, CreatedAt: created, Size: o.Size})
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].CreatedAt.Before(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// PruneBackups deletes backups created before cutoff, keeping the newest
+// backup of every account however old it is
+func PruneBackups(store objstore.Store, cutoff time.Time) (int, error) {
+	backups, err := ListBackups(store, "")
+	if err != nil {
+		return 0, err
+	}
+	newest := make(map[string]string)
+	for _, b := range backups {
+		newest[b.Username] = b.Key
+	}
+
+	deleted := 0
+	for _, b := range backups {
+		if !b.CreatedAt.Before(cutoff) || newest[b.Username] == b.Key {
+			continue
+		}
+		if err := store.Delete(b.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// OpenBackup opens a backup given as a local file or as an object URL such
+// as s3://bucket/backups/alice/20260301T020000Z.tar.gz
+func OpenBackup(location string, s3 config.S3Config) (io.ReadCloser, error) {
+	if !strings.Contains(location, "://") {
+		return os.Open(location)
+	}
+	i := strings.LastIndex(location, "/")
+	store, err := objstore.Open(location[:i], s3)
+	if err != nil {
+		return nil, err
+	}
+	return store.Get(location[i+1:])
+}
+
+// userPools returns every pool of a user
+func (pm *PoolManager) userPools(username string) ([]db.Pool, error) {
+	all, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	var pools []db.Pool
+	for _, p := range all {
+		if p.Username == username {
+			pools = append(pools, p)
+		}
+	}
+	return pools, nil
+}
+
+// userSites returns the user's sites with their bindings
+func (pm *PoolManager) userSites(username string) ([]BackupSite, error) {
+	sites, err := NewSiteManagerWithDeps(pm.db).ListSites()
+	if err != nil {
+		return nil, err
+	}
+	userSites := make([]BackupSite, 0)
+	for _, s := range sites {
+		if s.Username != username {
+			continue
+		}
+		site := BackupSite{Domain: s.Domain, DocumentRoot: s.DocumentRoot, Bindings: make(map[string]string)}
+		for _, b := range s.Bindings {
+			site.Bindings[b.PathPrefix] = b.PHPVersion
+		}
+		userSites = append(userSites, site)
+	}
+	return userSites, nil
+}
+
+// documentRoots returns the sites' document roots, leaving out roots that
+// lie inside another one
+func documentRoots(sites []BackupSite) []string {
+	var roots []string
+	for _, s := range sites {
+		roots = append(roots, filepath.Clean(s.DocumentRoot))
+	}
+	sort.Strings(roots)
+
+	var outer []string
+	for _, root := range roots {
+		if len(outer) > 0 {
+			if _, err := insideRoots(root, outer); err == nil {
+				continue
+			}
+		}
+		outer = append(outer, root)
+	}
+	return outer
+}
+
+// insideRoots checks that p is one of roots or below one, and returns it
+func insideRoots(p string, roots []string) (string, error) {
+	clean := filepath.Clean(p)
+	for _, root := range roots {
+		if clean == root || strings.HasPrefix(clean, strings.TrimSuffix(root, "/")+"/") {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("backup entry %s is outside the sites' document roots", p)
+}
+
+// addTree adds a directory tree to the archive under files/
+func addTree(tw *tar.Writer, root string) error {
+	hostRoot := hostPath(root)
+	if _, err := os.Lstat(hostRoot); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.WalkDir(hostRoot, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(hostRoot, p)
+		if err != nil {
+			return err
+		}
+		name := backupFilesDir + strings.TrimPrefix(path.Join(filepath.ToSlash(root), filepath.ToSlash(rel)), "/")
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			// Sockets, FIFOs and devices are not site content
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Ownership is set to the account on restore
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write backup entry %s: %w", name, err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return fmt.Errorf("failed to write backup entry %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// extractEntry writes a directory or regular file of a backup to dest and
+// reports whether a file was written
+func extractEntry(r io.Reader, hdr *tar.Header, dest string, roots []string, owner *user.User) (bool, error) {
+	mode := fs.FileMode(hdr.Mode).Perm()
+	if hdr.Typeflag == tar.TypeDir {
+		dir, err := restorePath(dest, roots)
+		if err != nil {
+			return false, err
+		}
+		if info, err := os.Lstat(dir); err == nil && !info.IsDir() {
+			os.Remove(dir)
+		}
+		if err := os.Mkdir(dir, mode); err != nil && !os.IsExist(err) {
+			return false, fmt.Errorf("failed to create %s: %w", dest, err)
+		}
+		os.Chmod(dir, mode)
+		chownTo(dir, owner)
+		return false, nil
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return false, nil
+	}
+
+	file, err := restorePath(dest, roots)
+	if err != nil {
+		return false, err
+	}
+	os.Remove(file)
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return false, fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if err := f.Close(); err != nil {
+		return false, err
+	}
+	os.Chtimes(file, hdr.ModTime, hdr.ModTime)
+	chownTo(file, owner)
+	return true, nil
+}
+
+// restorePath returns the host path to restore dest at, creating its parent
+// directories. The parent must resolve inside a document root, so a symlink
+// planted in a document root cannot redirect the write elsewhere.
+func restorePath(dest string, roots []string) (string, error) {
+	root, err := insideRoots(dest, roots)
+	if err != nil {
+		return "", err
+	}
+	hostDest := hostPath(dest)
+	hostRoot := hostPath(root)
+	parent := filepath.Dir(hostDest)
+	if hostDest != hostRoot {
+		// Check the deepest existing ancestor before creating anything
+		existing := parent
+		for existing != hostRoot && len(existing) > len(hostRoot) {
+			if _, err := os.Lstat(existing); err == nil {
+				break
+			}
+			existing = filepath.Dir(existing)
+		}
+		if _, err := os.Lstat(existing); err == nil {
+			resolved, err := filepath.EvalSymlinks(existing)
+			if err != nil {
+				return "", err
+			}
+			resolvedRoot, err := filepath.EvalSymlinks(hostRoot)
+			if err != nil {
+				return "", err
+			}
+			if _, err := insideRoots(resolved, []string{resolvedRoot}); err != nil {
+				return "", fmt.Errorf("refusing to restore %s through a symlink", dest)
+			}
+		}
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", path.Dir(dest), err)
+	}
+	return hostDest, nil
+}
+
+func chownTo(p string, owner *user.User) {
+	if owner == nil {
+		return
+	}
+	uid, _ := strconv.Atoi(owner.Uid)
+	gid, _ := strconv.Atoi(owner.Gid)
+	os.Lchown(p, uid, gid)
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write backup entry %s: %w", name, err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("failed to write backup entry %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+)
+
+// Batch operation kinds
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchOperation is one pool change in a batch. Create applies the profile
+// and then Settings on top; update merges Settings like PatchPoolConfig.
+type BatchOperation struct {
+	Op         string                 `json:"op"`
+	Username   string                 `json:"username"`
+	PHPVersion string                 `json:"php_version,omitempty"`
+	Provider   string                 `json:"provider,omitempty"`
+	Profile    string                 `json:"profile,omitempty"`
+	Settings   map[string]interface{} `json:"settings,omitempty"`
+	PurgeData  bool                   `json:"purge_data,omitempty"`
+}
+
+// BatchResult reports the outcome of one operation: "applied", "rolled_back",
+// "failed" or "skipped"
+type BatchResult struct {
+	Index    int    `json:"index"`
+	Op       string `json:"op"`
+	Username string `json:"username"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+var (
+	// ErrBatchRejected is returned when a batch fails validation; nothing was changed
+	ErrBatchRejected = errors.New("batch rejected")
+	// ErrBatchFailed is returned when an operation failed and the batch was rolled back
+	ErrBatchFailed = errors.New("batch failed and was rolled back")
+)
+
+// batchUndo restores the state before an applied operation
+type batchUndo func() error
+
+// ApplyBatch validates all operations, applies them in order and reloads
+// each affected FPM service once at the end. If an operation fails, the
+// operations applied before it are undone in reverse order. Data of deleted
+// pools is purged only after the whole batch succeeded.
+func (pm *PoolManager) ApplyBatch(ops []BatchOperation) (_ []BatchResult, err error) {
+	usernames := make([]string, 0, len(ops))
+	for _, op := range ops {
+		usernames = append(usernames, op.Username)
+	}
+	defer recordAudit(pm.context(), pm.db, "pool.batch", strings.Join(usernames, ","), &err)
+	results := make([]BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = BatchResult{Index: i, Op: op.Op, Username: op.Username, Status: "skipped"}
+	}
+
+	invalid := false
+	profiles := make(map[int]map[string]interface{})
+	seen := make(map[string]bool)
+	for i := range ops {
+		settings, err := pm.checkBatchOperation(&ops[i], seen)
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+			invalid = true
+			continue
+		}
+		profiles[i] = settings
+	}
+	if invalid {
+		return results, ErrBatchRejected
+	}
+
+	batch := *pm
+	batch.pendingReloads = make(map[fpmService]bool)
+
+	undo := make([]batchUndo, 0, len(ops))
+	var purge []*db.Pool
+	for i, op := range ops {
+		var deleted *db.Pool
+		if op.Op == BatchDelete {
+			// The target is needed to purge data after the record is gone
+			deleted, _ = pm.db.GetPool(op.Username)
+		}
+		u, err := batch.applyBatchOperation(op, profiles[i])
+		if err != nil {
+			results[i].Status = "failed"
+			results[i].Error = err.Error()
+
+			for j := len(undo) - 1; j >= 0; j-- {
+				if uerr := undo[j](); uerr != nil {
+					results[j].Error = "rollback failed: " + uerr.Error()
+					continue
+				}
+				results[j].Status = "rolled_back"
+			}
+			batch.reloadPending()
+			return results, fmt.Errorf("%w: operation %d (%s %s): %v", ErrBatchFailed, i, op.Op, op.Username, err)
+		}
+		undo = append(undo, u)
+		results[i].Status = "applied"
+		events.Publish(events.BatchProgress, op.Username, map[string]interface{}{
+			"index": i, "total": len(ops), "op": op.Op, "status": results[i].Status,
+		})
+		if op.Op == BatchDelete && op.PurgeData && deleted != nil {
+			purge = append(purge, deleted)
+		}
+	}
+
+	if err := batch.reloadPending(); err != nil {
+		return results, err
+	}
+	for _, dbPool := range purge {
+		t, err := target.Parse(dbPool.Target)
+		if err != nil {
+			return results, err
+		}
+		if err := removePoolDirs(t, dbPool.Username); err != nil {
+			return results, fmt.Errorf("failed to purge data of %s: %w", dbPool.Username, err)
+		}
+		if err := pm.dropDatabase(dbPool.Username); err != nil {
+			return results, fmt.Errorf("failed to drop the database of %s: %w", dbPool.Username, err)
+		}
+	}
+	return results, nil
+}
+
+// checkBatchOperation validates an operation against the current state and
+// returns the settings a create starts from
+func (pm *PoolManager) checkBatchOperation(op *BatchOperation, seen map[string]bool) (map[string]interface{}, error) {
+	if op.Username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if seen[op.Username] {
+		return nil, fmt.Errorf("pool %s appears more than once in the batch", op.Username)
+	}
+	seen[op.Username] = true
+
+	existing, err := pm.db.GetPool(op.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+
+	var settings map[string]interface{}
+	switch op.Op {
+	case BatchCreate:
+		if existing != nil {
+			return nil, fmt.Errorf("%w: %s", ErrPoolExists, op.Username)
+		}
+		if _, err := pm.target.LookupUser(op.Username); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, op.Username, err)
+		}
+		if op.PHPVersion == "" {
+			op.PHPVersion = "8.2"
+		}
+		if op.Provider == "" {
+			op.Provider = "remi"
+		}
+		if providerTypeFor(op.Provider) == "" {
+			return nil, fmt.Errorf("invalid provider type: %s", op.Provider)
+		}
+		settings = make(map[string]interface{})
+		if op.Profile != "" {
+			profile, err := pm.GetProfile(op.Profile)
+			if err != nil {
+				return nil, err
+			}
+			settings = profile.Settings
+		}
+		settings = mergeSettings(settings, op.Settings)
+	case BatchUpdate:
+		if existing == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, op.Username)
+		}
+		if len(op.Settings) == 0 {
+			return nil, fmt.Errorf("no settings provided")
+		}
+		settings = op.Settings
+	case BatchDelete:
+		if existing == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, op.Username)
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q; expected create, update or delete", op.Op)
+	}
+
+	data := templates.DefaultPoolConfigData(op.Username, op.Username, "/run/php-fpm/batch.sock")
+	if err := applyPoolSettings(data, withoutNullSettings(settings)); err != nil {
+		return nil, fmt.Errorf("invalid settings: %w", err)
+	}
+	return settings, nil
+}
+
+// applyBatchOperation applies one validated operation and returns how to undo it
+func (pm *PoolManager) applyBatchOperation(op BatchOperation, settings map[string]interface{}) (batchUndo, error) {
+	switch op.Op {
+	case BatchCreate:
+		if err := pm.CreatePool(op.Username, op.PHPVersion, op.Provider); err != nil {
+			return nil, err
+		}
+		undo := func() error { return pm.DeletePool(op.Username, false) }
+		if len(settings) > 0 {
+			if err := pm.initPoolSettings(op.Username, settings); err != nil {
+				undo()
+				return nil, err
+			}
+		}
+		return undo, nil
+
+	case BatchUpdate:
+		previous, err := pm.GetPoolConfig(op.Username)
+		if err != nil {
+			return nil, err
+		}
+		if err := pm.PatchPoolConfig(op.Username, settings); err != nil {
+			return nil, err
+		}
+		return func() error { return pm.UpdatePoolConfig(op.Username, previous.Settings) }, nil
+
+	case BatchDelete:
+		dbPool, err := pm.db.GetPool(op.Username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pool from database: %w", err)
+		}
+		previous, err := pm.GetPoolConfig(op.Username)
+		if err != nil {
+			return nil, err
+		}
+		if err := pm.DeletePool(op.Username, false); err != nil {
+			return nil, err
+		}
+		return func() error {
+			t, err := target.Parse(dbPool.Target)
+			if err != nil {
+				return err
+			}
+			if err := pm.WithTarget(t).WithTenant(dbPool.Tenant).CreatePool(dbPool.Username, dbPool.PHPVersion, dbPool.Provider); err != nil {
+				return err
+			}
+			if len(previous.Settings) == 0 {
+				return nil
+			}
+			return pm.initPoolSettings(dbPool.Username, previous.Settings)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// reloadPending reloads every service collected during a batch once
+func (pm *PoolManager) reloadPending() error {
+	var failed []string
+	for service := range pm.pendingReloads {
+		if err := reloadServiceWith(pm.context(), service.target, service.name, service.strategy); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", service.name, err))
+		}
+	}
+	pm.pendingReloads = make(map[fpmService]bool)
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to reload PHP-FPM: %v", failed)
+	}
+	return nil
+}
+
+// providerTypeFor maps a stored provider name to its type, or "" if unknown
+func providerTypeFor(name string) provider.ProviderType {
+	switch provider.ProviderType(name) {
+	case provider.ProviderRemi, provider.ProviderLiteSpeed, provider.ProviderAltPHP, provider.ProviderDocker, provider.ProviderSystem:
+		return provider.ProviderType(name)
+	}
+	return ""
+}
+
+// withoutNullSettings drops null entries, which a merge uses to remove keys
+func withoutNullSettings(settings map[string]interface{}) map[string]interface{} {
+	filtered := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		if value != nil {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
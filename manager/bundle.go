@@ -0,0 +1,400 @@
+package manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/provider"
+	"lightweight-php/validation"
+)
+
+const (
+	bundleFormatVersion = 2
+	bundleMetadataFile  = "metadata.json"
+	bundleSettingsFile  = "settings.json"
+	bundleSitesFile     = "sites.json"
+	bundleCronsFile     = "crons.json"
+	// bundleINIDir holds the php.ini overrides of each site's document
+	// root as DOMAIN.ini
+	bundleINIDir = "ini/"
+)
+
+// BundleMetadata describes a pool inside an export bundle
+type BundleMetadata struct {
+	FormatVersion int    `json:"format_version"`
+	Username      string `json:"username"`
+	PHPVersion    string `json:"php_version"`
+	Provider      string `json:"provider"`
+	SocketPath    string `json:"socket_path"`
+	ConfigPath    string `json:"config_path"`
+	Status        string `json:"status"`
+	// Home is the user's home directory on the source server; document
+	// roots below it move to the user's home on the target
+	Home       string    `json:"home,omitempty"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Bundle is the content of an export bundle: the pool's stored settings,
+// the user's sites with their php.ini overrides, and the pool's cron jobs.
+// Pool files are not carried; the target renders its own from the
+// settings, so they go through the same checks as any other change.
+type Bundle struct {
+	Metadata BundleMetadata
+	Settings map[string]interface{}
+	Sites    []BackupSite
+	Crons    []CronJob
+	// PHPValues are the php.ini overrides of the sites' document roots, by
+	// domain
+	PHPValues map[string]map[string]string
+}
+
+// ExportBundle writes a gzipped tar archive with the pool's metadata,
+// settings, sites, php.ini overrides and cron jobs (no site files) to w
+func (pm *PoolManager) ExportBundle(username string, w io.Writer) error {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	return pm.writeBundle(dbPool, w)
+}
+
+// writeBundle writes the export bundle of one pool to w
+func (pm *PoolManager) writeBundle(dbPool *db.Pool, w io.Writer) error {
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return err
+	}
+	raw, _, err := pm.db.GetPoolSettings(dbPool.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get pool settings: %w", err)
+	}
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+		return fmt.Errorf("failed to decode pool settings: %w", err)
+	}
+	sites, err := pm.userSites(dbPool.Username)
+	if err != nil {
+		return err
+	}
+	crons, err := pm.poolCronJobs(dbPool.Username)
+	if err != nil {
+		return err
+	}
+	metadata := BundleMetadata{
+		FormatVersion: bundleFormatVersion,
+		Username:      dbPool.Username,
+		PHPVersion:    dbPool.PHPVersion,
+		Provider:      dbPool.Provider,
+		SocketPath:    dbPool.SocketPath,
+		ConfigPath:    dbPool.ConfigPath,
+		Status:        dbPool.Status,
+		ExportedAt:    time.Now().UTC(),
+	}
+	if u, err := t.LookupUser(dbPool.Username); err == nil {
+		metadata.Home = u.HomeDir
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, entry := range []struct {
+		name  string
+		value interface{}
+	}{
+		{bundleMetadataFile, metadata},
+		{bundleSettingsFile, settings},
+		{bundleSitesFile, sites},
+		{bundleCronsFile, crons},
+	} {
+		encoded, err := json.MarshalIndent(entry.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode bundle entry %s: %w", entry.name, err)
+		}
+		if err := writeTarFile(tw, entry.name, encoded); err != nil {
+			return err
+		}
+	}
+	for _, s := range sites {
+		record, err := pm.db.GetSite(s.Domain)
+		if err != nil {
+			return fmt.Errorf("failed to get site: %w", err)
+		}
+		if record == nil {
+			continue
+		}
+		values, err := pm.db.GetSitePHPValues(record.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get PHP values: %w", err)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		if err := writeTarFile(tw, bundleINIDir+s.Domain+".ini", formatBundleINI(values)); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize bundle: %w", err)
+	}
+	return gz.Close()
+}
+
+// formatBundleINI writes php.ini overrides as sorted "name = value" lines
+func formatBundleINI(values map[string]string) []byte {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = %s\n", name, values[name])
+	}
+	return b.Bytes()
+}
+
+// parseBundleINI reads the "name = value" lines of formatBundleINI; blank
+// lines and ; comments are skipped
+func parseBundleINI(content []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected name = value", i+1)
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values, ValidatePHPValues(values)
+}
+
+// ReadBundle extracts and checks the content of a bundle. Bundles of
+// format 1 carried only the rendered pool file, which is not trusted:
+// their pools get the default settings.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer gz.Close()
+
+	var metadata *BundleMetadata
+	b := &Bundle{Settings: map[string]interface{}{}, PHPValues: map[string]map[string]string{}}
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		var dest interface{}
+		switch {
+		case hdr.Name == bundleMetadataFile:
+			metadata = &BundleMetadata{}
+			dest = metadata
+		case hdr.Name == bundleSettingsFile:
+			dest = &b.Settings
+		case hdr.Name == bundleSitesFile:
+			dest = &b.Sites
+		case hdr.Name == bundleCronsFile:
+			dest = &b.Crons
+		case strings.HasPrefix(hdr.Name, bundleINIDir) && strings.HasSuffix(hdr.Name, ".ini"):
+			domain := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, bundleINIDir), ".ini")
+			if err := validation.Field("domain", domain, validation.Domain); err != nil {
+				return nil, fmt.Errorf("invalid bundle entry %s: %w", hdr.Name, err)
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read bundle entry %s: %w", hdr.Name, err)
+			}
+			values, err := parseBundleINI(content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bundle entry %s: %w", hdr.Name, err)
+			}
+			b.PHPValues[domain] = values
+			continue
+		default:
+			continue
+		}
+		if err := json.NewDecoder(tr).Decode(dest); err != nil {
+			return nil, fmt.Errorf("failed to decode bundle entry %s: %w", hdr.Name, err)
+		}
+	}
+
+	if metadata == nil {
+		return nil, fmt.Errorf("bundle is missing %s", bundleMetadataFile)
+	}
+	if metadata.FormatVersion > bundleFormatVersion {
+		return nil, fmt.Errorf("unsupported bundle format version %d", metadata.FormatVersion)
+	}
+	if err := validation.Field("username", metadata.Username, validation.Username); err != nil {
+		return nil, fmt.Errorf("invalid bundle metadata: %w", err)
+	}
+	if err := validation.Field("php_version", metadata.PHPVersion, validation.PHPVersion); err != nil {
+		return nil, fmt.Errorf("invalid bundle metadata: %w", err)
+	}
+	if b.Settings == nil {
+		b.Settings = map[string]interface{}{}
+	}
+	if err := normalizeSettings(mergeSettings(b.Settings, nil)); err != nil {
+		return nil, fmt.Errorf("invalid bundle settings: %w", err)
+	}
+	for _, s := range b.Sites {
+		if err := validation.Field("domain", s.Domain, validation.Domain); err != nil {
+			return nil, fmt.Errorf("invalid bundle site: %w", err)
+		}
+	}
+	for _, job := range b.Crons {
+		if err := job.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid bundle cron job: %w", err)
+		}
+	}
+	b.Metadata = *metadata
+	return b, nil
+}
+
+// ImportBundle recreates a pool and the user's sites, php.ini overrides and
+// cron jobs from an export bundle. The pool is created under phpVersion
+// when set, otherwise under the bundled version, which must be installed
+// locally, and gets the bundled settings through UpdatePoolConfig, so they
+// are checked and rendered like any other change. Site bindings to the
+// bundled version follow the pool to phpVersion, and document roots in the
+// source home move to the local one. Sites that exist are left alone.
+func (pm *PoolManager) ImportBundle(b *Bundle, phpVersion string) (*RestoreReport, error) {
+	if phpVersion == "" {
+		phpVersion = b.Metadata.PHPVersion
+	}
+	report := &RestoreReport{
+		Username:     b.Metadata.Username,
+		CreatedAt:    b.Metadata.ExportedAt,
+		PoolsCreated: make([]string, 0),
+		PoolsSkipped: make([]string, 0),
+		SitesCreated: make([]string, 0),
+		SitesSkipped: make([]string, 0),
+	}
+	if err := pm.importBundlePool(b, phpVersion); err != nil {
+		return nil, err
+	}
+	report.PoolsCreated = append(report.PoolsCreated, phpVersion)
+
+	username := b.Metadata.Username
+	localHome := ""
+	if u, err := pm.target.LookupUser(username); err == nil {
+		localHome = u.HomeDir
+	}
+	sites := make([]BackupSite, 0, len(b.Sites))
+	for _, s := range b.Sites {
+		site := BackupSite{Domain: s.Domain, DocumentRoot: remapHome(s.DocumentRoot, b.Metadata.Home, localHome), Bindings: make(map[string]string, len(s.Bindings))}
+		for prefix, version := range s.Bindings {
+			if version == b.Metadata.PHPVersion {
+				version = phpVersion
+			}
+			site.Bindings[prefix] = version
+		}
+		sites = append(sites, site)
+	}
+	if err := pm.restoreSites(username, sites, report); err != nil {
+		return report, err
+	}
+	sm := NewSiteManagerWithDeps(pm.db).WithContext(pm.context())
+	for _, domain := range report.SitesCreated {
+		values := b.PHPValues[domain]
+		if len(values) == 0 {
+			continue
+		}
+		if _, err := pm.UpdateDomain(sm, username, domain, DomainUpdate{PHPValues: values}); err != nil {
+			return report, fmt.Errorf("failed to restore the php.ini overrides of %s: %w", domain, err)
+		}
+	}
+	if err := pm.restoreCronJobs(username, b.Crons); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// importBundlePool creates the bundle's pool under phpVersion with its
+// settings, removing it again when they do not apply
+func (pm *PoolManager) importBundlePool(b *Bundle, phpVersion string) error {
+	phpProvider, err := pm.resolveProvider(b.Metadata.Provider)
+	if err != nil {
+		return err
+	}
+	installed, err := phpProvider.ListInstalledPHP()
+	if err != nil {
+		return fmt.Errorf("failed to list installed PHP versions: %w", err)
+	}
+	if !containsString(installed, phpVersion) {
+		return fmt.Errorf("PHP %s is not installed for provider %s; install it or pass a different version", phpVersion, b.Metadata.Provider)
+	}
+
+	username := b.Metadata.Username
+	if err := pm.CreatePool(username, phpVersion, b.Metadata.Provider); err != nil {
+		return err
+	}
+	if len(b.Settings) > 0 {
+		if err := pm.UpdatePoolConfig(username, b.Settings); err != nil {
+			// A pool without its settings is not what the bundle describes
+			if delErr := pm.DeletePool(username, false); delErr != nil {
+				return fmt.Errorf("applying the bundled settings failed: %w; removing the pool failed: %v", err, delErr)
+			}
+			return fmt.Errorf("applying the bundled settings failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// remapHome moves a path below the source home to the same place below
+// the local home
+func remapHome(p, sourceHome, localHome string) string {
+	if sourceHome == "" || localHome == "" || sourceHome == localHome {
+		return p
+	}
+	rel, err := filepath.Rel(sourceHome, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return p
+	}
+	return path.Join(localHome, rel)
+}
+
+// resolveProvider maps a provider name stored on a pool to a provider
+// instance on the manager's target
+func (pm *PoolManager) resolveProvider(providerType string) (provider.PHPProvider, error) {
+	factory, err := pm.providerFactory.ForTarget(pm.target)
+	if err != nil {
+		return nil, err
+	}
+	phpProvider, err := factory.CreateProvider(provider.ProviderType(providerType))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+	return phpProvider, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"lightweight-php/audit"
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/templates"
+)
+
+// BurstActor is the audit log actor of the changes burst protection makes
+const BurstActor = "burst"
+
+// BurstChange is a change of a pool's max_children made by burst
+// protection: Action is "raise" while requests queue up, or "lower" when
+// the pool is back at its own max_children
+type BurstChange struct {
+	Username    string `json:"username"`
+	Action      string `json:"action"`
+	ListenQueue int64  `json:"listen_queue"`
+	Previous    int64  `json:"previous"`
+	MaxChildren int64  `json:"max_children"`
+	Revision    int64  `json:"revision,omitempty"`
+}
+
+// burstCalm counts the checks in a row that found a raised pool's queue
+// empty; restarting the server only delays lowering
+var burstCalm = struct {
+	sync.Mutex
+	checks map[string]int
+}{checks: make(map[string]int)}
+
+// CheckBursts reads the listen queue of every pool with the
+// burst_max_children setting from its PHP-FPM status page. A pool with
+// burst.queue_threshold or more queued connections gets burst.step more
+// children, up to burst_max_children; after burst.calm_checks checks with
+// an empty queue and no more active workers than the pool's own
+// max_children, it is lowered back to that value. Each change is recorded
+// in the audit log under BurstActor and published as a pool.burst event.
+// A max_children changed by anyone else since the last raise is kept.
+func (pm *PoolManager) CheckBursts() ([]BurstChange, error) {
+	cfg := config.Get().Burst
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	pm = pm.WithContext(audit.WithCaller(pm.context(), audit.Caller{Actor: BurstActor}))
+
+	var changes []BurstChange
+	var errs []string
+	for i := range pools {
+		if pools[i].Status == db.PoolSuspended {
+			continue
+		}
+		change, err := pm.checkBurst(&pools[i], cfg)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", pools[i].Username, err))
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	if len(errs) > 0 {
+		return changes, fmt.Errorf("burst check failed: %s", strings.Join(errs, "; "))
+	}
+	return changes, nil
+}
+
+func (pm *PoolManager) checkBurst(dbPool *db.Pool, cfg config.BurstConfig) (*BurstChange, error) {
+	username := dbPool.Username
+	current, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return nil, err
+	}
+	ceiling, _ := current.Settings["burst_max_children"].(float64)
+	state, err := pm.db.GetPoolBurst(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read burst state: %w", err)
+	}
+	maxChildren, own := poolMaxChildren(current.Settings)
+
+	if state != nil && maxChildren != state.MaxChildren {
+		// Changed by hand or by auto-tune since the last raise
+		resetBurstCalm(username)
+		return nil, pm.db.DeletePoolBurst(dbPool.ID)
+	}
+	if ceiling < 1 {
+		if state == nil {
+			return nil, nil
+		}
+		// Burst protection was turned off while the pool was raised
+		return pm.lowerBurst(dbPool, state, current.Revision, 0)
+	}
+
+	status, err := pm.fpmStatus(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	if status.ListenQueue >= int64(cfg.QueueThreshold) {
+		resetBurstCalm(username)
+		if maxChildren >= int64(ceiling) {
+			return nil, nil
+		}
+		next := maxChildren + int64(cfg.Step)
+		if next > int64(ceiling) {
+			next = int64(ceiling)
+		}
+		if state == nil {
+			now := time.Now().UTC()
+			state = &db.PoolBurst{PoolID: dbPool.ID, RaisedAt: now}
+			// 0 records that the pool had no max_children setting of its own
+			if own {
+				state.BaseMaxChildren = maxChildren
+			}
+		}
+		return pm.raiseBurst(dbPool, state, current.Revision, status.ListenQueue, maxChildren, next)
+	}
+	if state == nil {
+		return nil, nil
+	}
+
+	base := state.BaseMaxChildren
+	if base == 0 {
+		base = defaultMaxChildren()
+	}
+	burstCalm.Lock()
+	if status.ListenQueue == 0 && status.ActiveProcesses <= base {
+		burstCalm.checks[username]++
+	} else {
+		burstCalm.checks[username] = 0
+	}
+	calm := burstCalm.checks[username]
+	burstCalm.Unlock()
+	if calm < cfg.CalmChecks {
+		return nil, nil
+	}
+	return pm.lowerBurst(dbPool, state, current.Revision, status.ListenQueue)
+}
+
+// raiseBurst sets max_children to next and remembers the pool's own value
+func (pm *PoolManager) raiseBurst(dbPool *db.Pool, state *db.PoolBurst, revision, queue, previous, next int64) (_ *BurstChange, err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.burst_raise", dbPool.Username, &err)
+	newRevision, err := pm.PatchPoolConfigIfMatch(dbPool.Username, map[string]interface{}{"max_children": float64(next)}, revision)
+	if err != nil {
+		return nil, err
+	}
+	state.MaxChildren = next
+	state.UpdatedAt = time.Now().UTC()
+	if err := pm.db.SavePoolBurst(state); err != nil {
+		return nil, fmt.Errorf("failed to save burst state: %w", err)
+	}
+	return publishBurst(&BurstChange{
+		Username:    dbPool.Username,
+		Action:      "raise",
+		ListenQueue: queue,
+		Previous:    previous,
+		MaxChildren: next,
+		Revision:    newRevision,
+	}), nil
+}
+
+// lowerBurst restores the max_children the pool had before it was raised
+func (pm *PoolManager) lowerBurst(dbPool *db.Pool, state *db.PoolBurst, revision, queue int64) (_ *BurstChange, err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.burst_lower", dbPool.Username, &err)
+	var value interface{}
+	if state.BaseMaxChildren > 0 {
+		value = float64(state.BaseMaxChildren)
+	}
+	newRevision, err := pm.PatchPoolConfigIfMatch(dbPool.Username, map[string]interface{}{"max_children": value}, revision)
+	if err != nil {
+		return nil, err
+	}
+	resetBurstCalm(dbPool.Username)
+	if err := pm.db.DeletePoolBurst(dbPool.ID); err != nil {
+		return nil, fmt.Errorf("failed to clear burst state: %w", err)
+	}
+	lowered := state.BaseMaxChildren
+	if lowered == 0 {
+		lowered = defaultMaxChildren()
+	}
+	return publishBurst(&BurstChange{
+		Username:    dbPool.Username,
+		Action:      "lower",
+		ListenQueue: queue,
+		Previous:    state.MaxChildren,
+		MaxChildren: lowered,
+		Revision:    newRevision,
+	}), nil
+}
+
+func publishBurst(change *BurstChange) *BurstChange {
+	events.Publish(events.PoolBurst, change.Username, map[string]interface{}{
+		"action":       change.Action,
+		"listen_queue": change.ListenQueue,
+		"previous":     change.Previous,
+		"max_children": change.MaxChildren,
+	})
+	return change
+}
+
+func resetBurstCalm(username string) {
+	burstCalm.Lock()
+	delete(burstCalm.checks, username)
+	burstCalm.Unlock()
+}
+
+// poolMaxChildren returns the max_children a pool is rendered with and
+// whether it comes from the pool's settings rather than the template
+func poolMaxChildren(settings map[string]interface{}) (int64, bool) {
+	if v, ok := settings["max_children"].(float64); ok {
+		return int64(v), true
+	}
+	return defaultMaxChildren(), false
+}
+
+// defaultMaxChildren is the template's max_children
+func defaultMaxChildren() int64 {
+	return int64(templates.DefaultPoolConfigData("", "", "").MaxChildren)
+}
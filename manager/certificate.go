@@ -0,0 +1,297 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lightweight-php/acme"
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/tracing"
+)
+
+// issueTimeout bounds one certificate order, including validation
+const issueTimeout = 5 * time.Minute
+
+// Certificate is the TLS certificate of a site
+type Certificate struct {
+	Domain    string     `json:"domain"`
+	Status    string     `json:"status"`
+	CertPath  string     `json:"cert_path,omitempty"`
+	KeyPath   string     `json:"key_path,omitempty"`
+	Issuer    string     `json:"issuer,omitempty"`
+	Serial    string     `json:"serial,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// DaysLeft until expiry; unset while no certificate was issued
+	DaysLeft *int `json:"days_left,omitempty"`
+	// LastError is the error of the last failed issuance or renewal
+	LastError string    `json:"last_error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// IssueCertificate obtains a certificate for a site from the ACME server
+// (acme.directory_url), answering the HTTP-01 challenge from the site's
+// document root, and rewrites the site's snippet to use it. A failure is
+// recorded on the certificate; a previous certificate stays in use.
+func (sm *SiteManager) IssueCertificate(domain string) (_ *Certificate, err error) {
+	ctx, span := tracing.Start(sm.context(), "certificate.issue")
+	span.SetAttr("site.domain", domain)
+	defer span.Finish(&err)
+	defer recordAudit(sm.context(), sm.db, "certificate.issue", domain, &err)
+	sm = sm.WithContext(ctx)
+
+	site, err := sm.getSiteRecord(domain)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := sm.db.GetCertificate(site.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+
+	record, err := obtainCertificate(site)
+	if err != nil {
+		failed := &db.Certificate{SiteID: site.ID, Status: db.CertificateFailed, LastError: err.Error()}
+		if previous != nil && !previous.NotAfter.IsZero() {
+			failed = previous
+			failed.LastError = err.Error()
+		}
+		if saveErr := sm.db.SaveCertificate(failed); saveErr != nil {
+			return nil, fmt.Errorf("%v (and recording the failure failed: %v)", err, saveErr)
+		}
+		events.Publish(events.CertFailed, site.Username, map[string]interface{}{"domain": domain, "error": err.Error()})
+		return nil, err
+	}
+
+	if err := sm.db.SaveCertificate(record); err != nil {
+		return nil, fmt.Errorf("failed to save certificate: %w", err)
+	}
+	if _, err := sm.writeSnippet(domain); err != nil {
+		return nil, fmt.Errorf("certificate issued but updating the site failed: %w", err)
+	}
+	events.Publish(events.CertIssued, site.Username, map[string]interface{}{"domain": domain, "not_after": record.NotAfter})
+	return sm.GetCertificate(domain)
+}
+
+// GetCertificate returns the certificate of a site, or nil if it has none
+func (sm *SiteManager) GetCertificate(domain string) (*Certificate, error) {
+	site, err := sm.getSiteRecord(domain)
+	if err != nil {
+		return nil, err
+	}
+	c, err := sm.db.GetCertificate(site.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get certificate: %w", err)
+	}
+	if c == nil {
+		return nil, nil
+	}
+	return toCertificate(c), nil
+}
+
+// ListCertificates returns the certificates of all sites, soonest expiry
+// first
+func (sm *SiteManager) ListCertificates() ([]Certificate, error) {
+	records, err := sm.db.ListCertificates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	certificates := make([]Certificate, 0, len(records))
+	for i := range records {
+		certificates = append(certificates, *toCertificate(&records[i]))
+	}
+	return certificates, nil
+}
+
+// RenewCertificates renews the issued certificates that expire within
+// acme.renew_before of now and returns the result of each renewal
+func (sm *SiteManager) RenewCertificates(now time.Time) ([]Certificate, error) {
+	renewBefore, err := time.ParseDuration(config.Get().ACME.RenewBefore)
+	if err != nil {
+		return nil, fmt.Errorf("invalid acme.renew_before: %w", err)
+	}
+	records, err := sm.db.ListCertificates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+
+	var renewed []Certificate
+	for _, c := range records {
+		// A site whose first issuance failed is retried by hand, not every
+		// check, to stay clear of the ACME server's failed-validation limits
+		if c.NotAfter.IsZero() || now.Before(c.NotAfter.Add(-renewBefore)) {
+			continue
+		}
+		cert, err := sm.IssueCertificate(c.Domain)
+		if err != nil {
+			failed := toCertificate(&c)
+			failed.LastError = err.Error()
+			renewed = append(renewed, *failed)
+			continue
+		}
+		renewed = append(renewed, *cert)
+	}
+	return renewed, nil
+}
+
+// obtainCertificate runs an ACME order for a site and stores the key and
+// chain in acme.cert_dir
+func obtainCertificate(site *db.Site) (*db.Certificate, error) {
+	cfg := config.Get().ACME
+	accountKey, err := loadOrCreateKey(hostPath(filepath.Join(cfg.CertDir, "account.key")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ACME account key: %w", err)
+	}
+	client, err := acme.NewClient(cfg.DirectoryURL, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), issueTimeout)
+	defer cancel()
+	if err := client.Register(ctx, cfg.Email); err != nil {
+		return nil, err
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: site.Domain},
+		DNSNames: []string{site.Domain},
+	}, certKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	chain, err := client.Obtain(ctx, []string{site.Domain}, csr, webrootSolver(site.DocumentRoot))
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return nil, fmt.Errorf("ACME server returned no PEM certificate")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("ACME server returned an invalid certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cfg.CertDir, site.Domain)
+	record := &db.Certificate{
+		SiteID:    site.ID,
+		Status:    db.CertificateValid,
+		CertPath:  filepath.Join(dir, "fullchain.pem"),
+		KeyPath:   filepath.Join(dir, "privkey.pem"),
+		Issuer:    leaf.Issuer.CommonName,
+		Serial:    leaf.SerialNumber.Text(16),
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+	}
+	if err := os.MkdirAll(hostPath(dir), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	// The key goes first, so nginx never pairs the new chain with the old key
+	if err := writeFileAtomic(hostPath(record.KeyPath), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write certificate key: %w", err)
+	}
+	if err := writeFileAtomic(hostPath(record.CertPath), chain, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write certificate: %w", err)
+	}
+	return record, nil
+}
+
+// webrootSolver answers HTTP-01 challenges with files below the document
+// root, which the site's snippet serves as static files
+func webrootSolver(documentRoot string) acme.Solver {
+	return func(domain, token, keyAuthorization string) (func(), error) {
+		dir := hostPath(filepath.Join(documentRoot, ".well-known", "acme-challenge"))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, filepath.Base(token))
+		if err := os.WriteFile(path, []byte(keyAuthorization), 0644); err != nil {
+			return nil, err
+		}
+		return func() { os.Remove(path) }, nil
+	}
+}
+
+// loadOrCreateKey reads a PEM EC private key, generating and saving a new
+// P-256 key when the file does not exist
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// writeFileAtomic replaces a file through a temporary file and a rename
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func toCertificate(c *db.Certificate) *Certificate {
+	cert := &Certificate{
+		Domain:    c.Domain,
+		Status:    c.Status,
+		CertPath:  c.CertPath,
+		KeyPath:   c.KeyPath,
+		Issuer:    c.Issuer,
+		Serial:    c.Serial,
+		LastError: c.LastError,
+		UpdatedAt: c.UpdatedAt,
+	}
+	if !c.NotAfter.IsZero() {
+		notBefore, notAfter := c.NotBefore, c.NotAfter
+		days := int(time.Until(notAfter).Hours() / 24)
+		cert.NotBefore, cert.NotAfter, cert.DaysLeft = &notBefore, &notAfter, &days
+	}
+	return cert
+}
@@ -0,0 +1,185 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"lightweight-php/provider"
+	"lightweight-php/target"
+)
+
+const (
+	// CLIWrapperDir holds the php-<user> scripts that run the PHP CLI of a
+	// user's pool with the pool's ini settings, for cron jobs and shells
+	CLIWrapperDir = "/usr/local/bin"
+	// cliBinDir holds a directory per user with a "php" link to the user's
+	// wrapper, put first on PATH by pool shell
+	cliBinDir = "/var/lib/lightweight-php/cli"
+)
+
+// CLIWrapperPath returns the wrapper script of a user's pool
+func CLIWrapperPath(username string) string {
+	return filepath.Join(CLIWrapperDir, "php-"+username)
+}
+
+// writeCLIWrapper writes the user's wrapper script: the CLI binary of the
+// pool's PHP version with every php_value and php_admin_value of the
+// rendered pool configuration passed as -d, so that scripts run from cron
+// see the same PHP as the web pool. Providers without a CLI binary on the
+// target, such as docker, get no wrapper.
+func writeCLIWrapper(t target.Target, phpProvider provider.PHPProvider, username, phpVersion, poolConfig string) error {
+	binary := phpProvider.GetBinaryPath(phpVersion)
+	if binary == "" {
+		removeCLIWrapper(t, username)
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# Generated by lightweight-php for the pool of %s (PHP %s, %s).\n", username, phpVersion, phpProvider.GetProviderType())
+	b.WriteString("# Changes are overwritten when the pool changes.\n")
+	b.WriteString("exec " + binary)
+	for _, line := range splitLines(poolConfig) {
+		key, value, ok := fpmDirective(strings.TrimSpace(line))
+		if !ok {
+			continue
+		}
+		if m := fpmINIPattern.FindStringSubmatch(key); m != nil {
+			b.WriteString(" \\\n  -d " + shellQuote(m[1]+"="+value))
+		}
+	}
+	b.WriteString(" \"$@\"\n")
+
+	hostWrapper, err := t.Path(CLIWrapperPath(username))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(hostWrapper), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", CLIWrapperDir, err)
+	}
+	tmp := hostWrapper + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0755); err != nil {
+		return fmt.Errorf("failed to write CLI wrapper: %w", err)
+	}
+	if err := os.Rename(tmp, hostWrapper); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write CLI wrapper: %w", err)
+	}
+
+	hostBin, err := t.Path(filepath.Join(cliBinDir, username))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(hostBin, 0755); err != nil {
+		return fmt.Errorf("failed to create CLI directory: %w", err)
+	}
+	link := filepath.Join(hostBin, "php")
+	os.Remove(link)
+	if err := os.Symlink(CLIWrapperPath(username), link); err != nil {
+		return fmt.Errorf("failed to link CLI wrapper: %w", err)
+	}
+	return nil
+}
+
+// removeCLIWrapper removes the user's wrapper script and its PATH directory
+func removeCLIWrapper(t target.Target, username string) {
+	if hostWrapper, err := t.Path(CLIWrapperPath(username)); err == nil {
+		os.Remove(hostWrapper)
+	}
+	if hostBin, err := t.Path(filepath.Join(cliBinDir, username)); err == nil {
+		os.RemoveAll(hostBin)
+	}
+}
+
+// syncCLIWrapper points the user's wrapper at the pool the user still has
+// after one of the user's pools was removed, or removes it
+func (pm *PoolManager) syncCLIWrapper(t target.Target, username string) error {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil || dbPool.Target != t.String() {
+		removeCLIWrapper(t, username)
+		return nil
+	}
+	_, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return err
+	}
+	phpProvider, err := factory.CreateProvider(providerTypeFor(dbPool.Provider))
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(hostConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read pool config: %w", err)
+	}
+	return writeCLIWrapper(t, phpProvider, username, dbPool.PHPVersion, string(content))
+}
+
+// PoolShell returns a command that runs as the pool's user with the user's
+// wrapper first on PATH as php, so php, composer and the like use the
+// pool's PHP. Without command it is an interactive shell.
+func (pm *PoolManager) PoolShell(username string, command []string) (*exec.Cmd, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.LookupUser(username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+	}
+	// Pools created before wrappers existed get theirs here
+	if err := pm.syncCLIWrapper(t, username); err != nil {
+		return nil, err
+	}
+	hostWrapper, err := t.Path(CLIWrapperPath(username))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(hostWrapper); err != nil {
+		return nil, fmt.Errorf("pool of %s has no PHP CLI wrapper; providers without a CLI binary on the host have none", username)
+	}
+
+	if len(command) == 0 {
+		shell := "/bin/sh"
+		if hostBash, err := t.Path("/bin/bash"); err == nil {
+			if _, err := os.Stat(hostBash); err == nil {
+				shell = "/bin/bash"
+			}
+		}
+		command = []string{shell, "-i"}
+	}
+	args := []string{"-u", username, "--", "env",
+		"HOME=" + u.HomeDir,
+		"USER=" + username,
+		"LOGNAME=" + username,
+		"PATH=" + filepath.Join(cliBinDir, username) + ":/usr/local/bin:/usr/bin:/bin",
+	}
+	cmd := t.Command("runuser", append(args, command...)...)
+	cmd.Dir = "/"
+	if hostHome, err := t.Path(u.HomeDir); err == nil && t.IsHost() {
+		if info, err := os.Stat(hostHome); err == nil && info.IsDir() {
+			cmd.Dir = hostHome
+		}
+	}
+	return cmd, nil
+}
+
+// shellQuote quotes a word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"lightweight-php/templates"
+)
+
+// composePort is where PHP-FPM listens inside an exported container
+const composePort = "9000"
+
+// ComposeOptions adjust an exported docker-compose.yml
+type ComposeOptions struct {
+	// Image is the PHP-FPM image, by default the official php:VERSION-fpm
+	Image string
+}
+
+// ExportCompose returns a docker-compose.yml that runs a pool in a
+// PHP-FPM container: the pool's configuration with its settings, the
+// user's home directory, the pool user's uid and gid, and memory and
+// process limits derived from memory_limit and max_children. PHP-FPM
+// listens on port 9000, published on loopback, instead of the pool's
+// socket. Sessions and temporary files live on tmpfs.
+func (pm *PoolManager) ExportCompose(username string, opts ComposeOptions) (string, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return "", fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return "", fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return "", err
+	}
+	settings, err := pm.poolSettings(dbPool)
+	if err != nil {
+		return "", err
+	}
+	u, err := t.LookupUser(username)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+	}
+
+	data, err := poolRenderData(t, username, composePort, settings)
+	if err != nil {
+		return "", err
+	}
+	// The container's network is the boundary; the master runs as the
+	// pool user, logs to the container's stderr and has no sendmail
+	data.ListenAllowedClients = ""
+	data.ErrorLog = "/proc/self/fd/2"
+	data.SendmailPath = ""
+	templateContent, err := templates.LoadTemplate("pool.conf.tmpl")
+	if err != nil {
+		return "", fmt.Errorf("failed to load template: %w", err)
+	}
+	pool, err := templates.RenderPoolConfig(templateContent, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	fpmConfig := "[global]\nerror_log = /proc/self/fd/2\ndaemonize = no\n\n" + pool
+
+	image := opts.Image
+	if image == "" {
+		image = "php:" + dbPool.PHPVersion + "-fpm"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# PHP %s pool of %s, exported by lightweight-php\n", dbPool.PHPVersion, username)
+	fmt.Fprintf(&b, "# Start with 'docker compose up -d'; PHP-FPM listens on 127.0.0.1:${PHP_FPM_PORT:-%s}.\n", composePort)
+	fmt.Fprintf(&b, "# Set APP_ROOT to where the files of %s live on this machine.\n", u.HomeDir)
+	fmt.Fprintf(&b, "name: lwp-%s\n\n", username)
+	b.WriteString("services:\n")
+	b.WriteString("  php-fpm:\n")
+	fmt.Fprintf(&b, "    image: %s\n", image)
+	b.WriteString("    restart: unless-stopped\n")
+	fmt.Fprintf(&b, "    user: \"%s:%s\"\n", u.Uid, u.Gid)
+	b.WriteString("    command: [\"php-fpm\", \"-F\", \"-y\", \"/usr/local/etc/lightweight-php/php-fpm.conf\"]\n")
+	b.WriteString("    configs:\n")
+	b.WriteString("      - source: php-fpm\n")
+	b.WriteString("        target: /usr/local/etc/lightweight-php/php-fpm.conf\n")
+	b.WriteString("    volumes:\n")
+	fmt.Fprintf(&b, "      - ${APP_ROOT:-%s}:%s\n", u.HomeDir, u.HomeDir)
+	for _, dir := range poolDirs(username) {
+		b.WriteString("      - type: tmpfs\n")
+		fmt.Fprintf(&b, "        target: %s\n", dir)
+		b.WriteString("        tmpfs:\n")
+		b.WriteString("          mode: 01777\n")
+	}
+	b.WriteString("    ports:\n")
+	fmt.Fprintf(&b, "      - \"127.0.0.1:${PHP_FPM_PORT:-%s}:%s\"\n", composePort, composePort)
+	b.WriteString("    networks:\n")
+	b.WriteString("      - php\n")
+	if limits := composeLimits(data); len(limits) > 0 {
+		b.WriteString("    deploy:\n")
+		b.WriteString("      resources:\n")
+		b.WriteString("        limits:\n")
+		for _, limit := range limits {
+			fmt.Fprintf(&b, "          %s\n", limit)
+		}
+	}
+	b.WriteString("\nnetworks:\n")
+	b.WriteString("  php: {}\n")
+	b.WriteString("\nconfigs:\n")
+	b.WriteString("  php-fpm:\n")
+	b.WriteString("    content: |\n")
+	for _, line := range strings.Split(strings.TrimRight(fpmConfig, "\n"), "\n") {
+		if line == "" {
+			b.WriteString("\n")
+			continue
+		}
+		// Compose interpolates $ in the whole file
+		fmt.Fprintf(&b, "      %s\n", strings.ReplaceAll(line, "$", "$$"))
+	}
+	return b.String(), nil
+}
+
+// composeLimits returns the resource limits of a pool's container: room
+// for every worker at memory_limit, and processes for the workers, the
+// master and what they start
+func composeLimits(data *templates.PoolConfigData) []string {
+	var limits []string
+	if limit, err := ParseSize(data.MemoryLimit); err == nil && limit > 0 {
+		limits = append(limits, fmt.Sprintf("memory: %dM", limit*int64(data.MaxChildren)>>20))
+	}
+	if data.MaxChildren > 0 {
+		limits = append(limits, fmt.Sprintf("pids: %d", data.MaxChildren*2+10))
+	}
+	return limits
+}
@@ -0,0 +1,25 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"lightweight-php/config"
+)
+
+// ErrConfigRejected is returned when the config file cannot be reloaded
+// because it is unreadable or invalid
+var ErrConfigRejected = errors.New("config file rejected; the previous configuration stays")
+
+// ReloadConfig reads the config file again, audited as config.reload. Pool
+// operations and tasks that are running finish with the settings they
+// started with. It returns the changed settings that need a restart of
+// the API server to take effect.
+func (pm *PoolManager) ReloadConfig() (restart []string, err error) {
+	defer recordAudit(pm.context(), pm.db, "config.reload", config.DefaultConfigPath, &err)
+	restart, err = config.Reload()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConfigRejected, err)
+	}
+	return restart, nil
+}
@@ -0,0 +1,305 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/cron"
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+// ErrCronNotFound is returned for an unknown cron job of a pool
+var ErrCronNotFound = errors.New("cron job not found")
+
+// maxCronCommand bounds a cron job's command line
+const maxCronCommand = 1024
+
+// The jobs of a pool live between these lines of the user's crontab; lines
+// outside them are the user's own and are kept
+const (
+	cronBlockBegin = "# BEGIN lightweight-php (generated; changes are overwritten)"
+	cronBlockEnd   = "# END lightweight-php"
+)
+
+// cronPHPPattern matches a command's PHP binary, which is replaced with the
+// pool's CLI wrapper: php, php8.2 or a path to either
+var cronPHPPattern = regexp.MustCompile(`^(/[A-Za-z0-9_./-]*/)?php[0-9.]*$`)
+
+// CronJob is a cron job as given, as in manifests
+type CronJob struct {
+	// Schedule is a five-field crontab(5) expression or a macro such as
+	// @hourly
+	Schedule string `json:"schedule"`
+	// Command runs through the user's shell; a leading php runs the pool's
+	// PHP
+	Command string `json:"command"`
+}
+
+// Validate checks a cron job
+func (j CronJob) Validate() error {
+	var errs validation.Errors
+	errs.Check("schedule", j.Schedule, func(expr string) error {
+		if strings.TrimSpace(expr) == "" {
+			return fmt.Errorf("is required")
+		}
+		_, err := cron.Parse(expr)
+		return err
+	})
+	errs.Check("command", j.Command, func(command string) error {
+		if strings.TrimSpace(command) == "" {
+			return fmt.Errorf("is required")
+		}
+		if len(command) > maxCronCommand {
+			return fmt.Errorf("must be at most %d characters", maxCronCommand)
+		}
+		if strings.IndexFunc(command, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+			return fmt.Errorf("must be a single line without control characters")
+		}
+		return nil
+	})
+	return errs.Err()
+}
+
+// Cron is a cron job of a pool
+type Cron struct {
+	ID       int64  `json:"id"`
+	Schedule string `json:"schedule"`
+	Command  string `json:"command"`
+	// Line is the job in the user's crontab, with the pool's PHP
+	// substituted
+	Line      string    `json:"line"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// cronLine renders a job for the crontab. A leading PHP binary becomes
+// wrapper when there is one, and % is escaped since cron turns it into a
+// newline.
+func cronLine(c db.PoolCron, wrapper string) string {
+	command := strings.TrimSpace(c.Command)
+	if wrapper != "" {
+		first, rest, _ := strings.Cut(command, " ")
+		if cronPHPPattern.MatchString(first) {
+			command = strings.TrimSpace(wrapper + " " + rest)
+		}
+	}
+	return strings.Join(strings.Fields(c.Schedule), " ") + " " + strings.ReplaceAll(command, "%", `\%`)
+}
+
+// cronWrapper returns the CLI wrapper of a user's pool, or "" for pools
+// without one, whose commands run as given
+func (pm *PoolManager) cronWrapper(t target.Target, username string) string {
+	hostWrapper, err := t.Path(CLIWrapperPath(username))
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(hostWrapper); err != nil {
+		return ""
+	}
+	return CLIWrapperPath(username)
+}
+
+func toCron(c db.PoolCron, wrapper string) Cron {
+	return Cron{ID: c.ID, Schedule: c.Schedule, Command: c.Command, Line: cronLine(c, wrapper), CreatedAt: c.CreatedAt}
+}
+
+// readCrontab returns the user's crontab; a user without one has none
+func readCrontab(t target.Target, username string) (string, error) {
+	output, err := t.Command("crontab", "-u", username, "-l").CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "no crontab") {
+			return "", nil
+		}
+		return "", fmt.Errorf("crontab -l failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// syncCrontab rewrites the lightweight-php block of the user's crontab
+// from the pool's recorded jobs
+func (pm *PoolManager) syncCrontab(t target.Target, username string) error {
+	records, err := pm.db.ListPoolCrons(username)
+	if err != nil {
+		return fmt.Errorf("failed to list cron jobs: %w", err)
+	}
+	current, err := readCrontab(t, username)
+	if err != nil {
+		return err
+	}
+
+	var kept []string
+	inBlock := false
+	for _, line := range splitLines(current) {
+		switch {
+		case line == cronBlockBegin:
+			inBlock = true
+		case line == cronBlockEnd:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+	if len(records) == 0 && current == "" {
+		return nil
+	}
+
+	var b strings.Builder
+	for _, line := range kept {
+		b.WriteString(line + "\n")
+	}
+	if len(records) > 0 {
+		wrapper := pm.cronWrapper(t, username)
+		b.WriteString(cronBlockBegin + "\n")
+		for _, c := range records {
+			fmt.Fprintf(&b, "# cron %d\n%s\n", c.ID, cronLine(c, wrapper))
+		}
+		b.WriteString(cronBlockEnd + "\n")
+	}
+
+	cmd := t.Command("crontab", "-u", username, "-")
+	cmd.Stdin = strings.NewReader(b.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to install crontab of %s: %v: %s", username, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// ListCrons returns the cron jobs of a pool
+func (pm *PoolManager) ListCrons(username string) ([]Cron, error) {
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	records, err := pm.db.ListPoolCrons(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cron jobs: %w", err)
+	}
+	wrapper := pm.cronWrapper(t, username)
+	crons := make([]Cron, 0, len(records))
+	for _, c := range records {
+		crons = append(crons, toCron(c, wrapper))
+	}
+	return crons, nil
+}
+
+// AddCron adds a cron job to a pool and installs it in the user's crontab
+func (pm *PoolManager) AddCron(username string, job CronJob) (_ *Cron, err error) {
+	defer recordAudit(pm.context(), pm.db, "cron.add", username, &err)
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+	l, err := pm.acquire(lock.PoolKey(username), "add cron job to "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	// Pools created before wrappers existed get theirs here
+	if err := pm.syncCLIWrapper(t, username); err != nil {
+		return nil, err
+	}
+	record := db.PoolCron{
+		Pool:      username,
+		Schedule:  strings.Join(strings.Fields(job.Schedule), " "),
+		Command:   strings.TrimSpace(job.Command),
+		CreatedAt: time.Now(),
+	}
+	if record.ID, err = pm.db.CreatePoolCron(record); err != nil {
+		return nil, fmt.Errorf("failed to save cron job to database: %w", err)
+	}
+	if err := pm.syncCrontab(t, username); err != nil {
+		pm.db.DeletePoolCron(username, record.ID)
+		return nil, err
+	}
+	c := toCron(record, pm.cronWrapper(t, username))
+	return &c, nil
+}
+
+// RemoveCron removes a cron job of a pool from the user's crontab
+func (pm *PoolManager) RemoveCron(username string, id int64) (err error) {
+	defer recordAudit(pm.context(), pm.db, "cron.remove", username+"/"+strconv.FormatInt(id, 10), &err)
+	l, err := pm.acquire(lock.PoolKey(username), "remove cron job of "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return err
+	}
+	record, err := pm.db.GetPoolCron(username, id)
+	if err != nil {
+		return fmt.Errorf("failed to get cron job from database: %w", err)
+	}
+	if record == nil {
+		return fmt.Errorf("%w: %d of %s", ErrCronNotFound, id, username)
+	}
+	if err := pm.db.DeletePoolCron(username, id); err != nil {
+		return fmt.Errorf("failed to delete cron job from database: %w", err)
+	}
+	return pm.syncCrontab(t, username)
+}
+
+// removePoolCrons forgets the cron jobs of a pool being deleted and takes
+// them out of the user's crontab
+func (pm *PoolManager) removePoolCrons(t target.Target, username string) error {
+	records, err := pm.db.ListPoolCrons(username)
+	if err != nil {
+		return fmt.Errorf("failed to list cron jobs: %w", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := pm.db.DeletePoolCrons(username); err != nil {
+		return fmt.Errorf("failed to delete cron jobs from database: %w", err)
+	}
+	return pm.syncCrontab(t, username)
+}
+
+// poolCronJobs returns the jobs of a pool for its manifest
+func (pm *PoolManager) poolCronJobs(username string) ([]CronJob, error) {
+	records, err := pm.db.ListPoolCrons(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cron jobs: %w", err)
+	}
+	jobs := make([]CronJob, 0, len(records))
+	for _, c := range records {
+		jobs = append(jobs, CronJob{Schedule: c.Schedule, Command: c.Command})
+	}
+	return jobs, nil
+}
+
+// restoreCronJobs adds the jobs of a manifest the pool does not have yet
+func (pm *PoolManager) restoreCronJobs(username string, jobs []CronJob) error {
+	existing, err := pm.poolCronJobs(username)
+	if err != nil {
+		return err
+	}
+	have := make(map[CronJob]bool, len(existing))
+	for _, j := range existing {
+		have[j] = true
+	}
+	for _, j := range jobs {
+		j = CronJob{Schedule: strings.Join(strings.Fields(j.Schedule), " "), Command: strings.TrimSpace(j.Command)}
+		if have[j] {
+			continue
+		}
+		if _, err := pm.AddCron(username, j); err != nil {
+			return fmt.Errorf("failed to add cron job %q: %w", j.Schedule+" "+j.Command, err)
+		}
+		have[j] = true
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+	"lightweight-php/validation"
+)
+
+// customConfigCheckSuffix names the copy of a pool that php-fpm tests a
+// custom_config with; like stagedPoolSuffix it cannot collide with a username
+const customConfigCheckSuffix = ".check"
+
+var customConfigKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+(\[[A-Za-z0-9_.-]*\])?$`)
+
+// ValidateCustomConfig checks the custom_config setting: a string of lines
+// or an array of them. Blank lines and ; comments are kept; every other
+// line must be a "key = value" directive of the pool, as a section or an
+// include would escape it.
+func ValidateCustomConfig(value interface{}) error {
+	_, err := poolCustomConfig(value)
+	return err
+}
+
+// poolCustomConfig returns the lines of the custom_config setting
+func poolCustomConfig(value interface{}) ([]string, error) {
+	var lines []string
+	switch v := value.(type) {
+	case string:
+		lines = strings.Split(strings.ReplaceAll(v, "\r\n", "\n"), "\n")
+	case []interface{}:
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid custom_config: line %d must be a string", i+1)
+			}
+			if strings.ContainsAny(s, "\r\n") {
+				return nil, fmt.Errorf("invalid custom_config: line %d must not contain line breaks", i+1)
+			}
+			lines = append(lines, s)
+		}
+	default:
+		return nil, fmt.Errorf("invalid custom_config: must be a string or an array of lines")
+	}
+
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+		if lines[i] == "" || strings.HasPrefix(lines[i], ";") {
+			continue
+		}
+		key, _, ok := strings.Cut(lines[i], "=")
+		key = strings.TrimSpace(key)
+		switch {
+		case strings.HasPrefix(lines[i], "["):
+			return nil, fmt.Errorf("invalid custom_config line %d: sections are not allowed", i+1)
+		case !ok:
+			return nil, fmt.Errorf("invalid custom_config line %d: expected key = value", i+1)
+		case !customConfigKeyPattern.MatchString(key):
+			return nil, fmt.Errorf("invalid custom_config line %d: invalid key %q", i+1, key)
+		case key == "include":
+			return nil, fmt.Errorf("invalid custom_config line %d: include is not allowed", i+1)
+		}
+	}
+	// Blank lines around the block are dropped
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	return lines, nil
+}
+
+// checkCustomConfig rejects custom_config directives that the pool's other
+// settings already render, so the structured settings stay authoritative,
+// and runs php-fpm's configuration test on a copy of the pool with its
+// custom_config before anything is written or reloaded
+func (pm *PoolManager) checkCustomConfig(t target.Target, phpProvider provider.PHPProvider, dbPool *db.Pool, templateContent string, data *templates.PoolConfigData) error {
+	rendered := *data
+	rendered.CustomConfig = nil
+	config, err := templates.RenderPoolConfig(templateContent, &rendered)
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	managed := make(map[string]bool)
+	for _, line := range strings.Split(config, "\n") {
+		if key, _, ok := strings.Cut(line, "="); ok && !strings.HasPrefix(strings.TrimSpace(line), ";") {
+			managed[strings.TrimSpace(key)] = true
+		}
+	}
+	var errs validation.Errors
+	for _, line := range data.CustomConfig {
+		key, _, ok := strings.Cut(line, "=")
+		if key = strings.TrimSpace(key); ok && !strings.HasPrefix(line, ";") && managed[key] {
+			errs.Add("custom_config", "%s is already set by the pool's settings or template", key)
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	fpmConfig := phpProvider.GetFPMConfigPath(dbPool.PHPVersion)
+	if fpmConfig == "" {
+		return nil
+	}
+	check := *data
+	check.PoolName = dbPool.Username + customConfigCheckSuffix
+	content, err := templates.RenderPoolConfig(templateContent, &check)
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return err
+	}
+	checkPath := strings.TrimSuffix(hostConfigPath, ".conf") + customConfigCheckSuffix + ".conf"
+	if err := writePoolFile(checkPath, content); err != nil {
+		return fmt.Errorf("failed to write pool config for testing: %w", err)
+	}
+	defer os.Remove(checkPath)
+	if err := fpmConfigTest(pm.context(), t, phpProvider, dbPool.PHPVersion, fpmConfig); err != nil {
+		return fmt.Errorf("custom_config was rejected: %w", err)
+	}
+	return nil
+}
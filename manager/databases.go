@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/dbprovision"
+)
+
+// provisionDatabase creates the MySQL database and account of a pool, or
+// reuses those kept from an earlier pool of the user, and returns the env
+// variables that hand them to the pool. It returns nil when
+// databases.admin_dsn is not set.
+func (pm *PoolManager) provisionDatabase(username string) (map[string]interface{}, error) {
+	cfg := config.Get().Databases
+	if cfg.AdminDSN == "" {
+		return nil, nil
+	}
+	record, err := pm.db.GetPoolDatabase(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the pool's database: %w", err)
+	}
+	if record == nil {
+		name, err := dbprovision.Name(cfg.Prefix, username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := dbprovision.Password()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate a password: %w", err)
+		}
+		record = &db.PoolDatabase{
+			Pool:      username,
+			Name:      name,
+			Username:  name,
+			UserHost:  cfg.UserHost,
+			Password:  password,
+			CreatedAt: time.Now(),
+		}
+
+		admin, err := dbprovision.Open(pm.context(), cfg.AdminDSN)
+		if err != nil {
+			return nil, err
+		}
+		defer admin.Close()
+		if err := admin.Create(pm.context(), record.Name, record.Username, record.UserHost, record.Password); err != nil {
+			return nil, err
+		}
+		if err := pm.db.CreatePoolDatabase(*record); err != nil {
+			admin.Drop(pm.context(), record.Name, record.Username, record.UserHost)
+			return nil, fmt.Errorf("failed to record the pool's database: %w", err)
+		}
+	}
+
+	host, port, err := databaseAddress(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"DB_HOST":     host,
+		"DB_PORT":     strconv.Itoa(port),
+		"DB_DATABASE": record.Name,
+		"DB_USERNAME": record.Username,
+		"DB_PASSWORD": record.Password,
+	}, nil
+}
+
+// dropDatabase drops the database and account of a deleted pool, if it
+// has them
+func (pm *PoolManager) dropDatabase(username string) error {
+	record, err := pm.db.GetPoolDatabase(username)
+	if err != nil {
+		return fmt.Errorf("failed to get the pool's database: %w", err)
+	}
+	if record == nil {
+		return nil
+	}
+	dsn := config.Get().Databases.AdminDSN
+	if dsn == "" {
+		return fmt.Errorf("database %s is left in place: databases.admin_dsn is not set", record.Name)
+	}
+	admin, err := dbprovision.Open(pm.context(), dsn)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	if err := admin.Drop(pm.context(), record.Name, record.Username, record.UserHost); err != nil {
+		return err
+	}
+	if err := pm.db.DeletePoolDatabase(username); err != nil {
+		return fmt.Errorf("failed to forget the dropped database: %w", err)
+	}
+	return nil
+}
+
+// databaseAddress returns where pools reach the database server:
+// databases.host, or the address of the admin DSN
+func databaseAddress(cfg config.DatabasesConfig) (string, int, error) {
+	if cfg.Host == "" {
+		return dbprovision.Address(cfg.AdminDSN)
+	}
+	host, p, err := net.SplitHostPort(cfg.Host)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid databases.host: %w", err)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in databases.host: %s", p)
+	}
+	return host, port, nil
+}
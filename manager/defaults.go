@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"lightweight-php/templates"
+	"lightweight-php/validation"
+)
+
+// PoolDefaults are the settings every new pool starts from. A pool created
+// in a tenant or from a profile gets their settings on top; pools that
+// exist already keep theirs.
+type PoolDefaults struct {
+	Settings  map[string]interface{} `json:"settings"`
+	UpdatedAt *time.Time             `json:"updated_at,omitempty"`
+}
+
+// SettingName returns the pool setting a PHP-FPM directive such as
+// pm.max_children stands for, or name itself
+func SettingName(name string) string {
+	if setting, ok := fpmPoolSettings[name]; ok {
+		return setting
+	}
+	return name
+}
+
+// GetPoolDefaults returns the default pool settings, empty when none were set
+func (pm *PoolManager) GetPoolDefaults() (*PoolDefaults, error) {
+	stored, err := pm.db.GetPoolDefaults()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool defaults from database: %w", err)
+	}
+	defaults := &PoolDefaults{Settings: map[string]interface{}{}}
+	if stored == nil {
+		return defaults, nil
+	}
+	if err := json.Unmarshal([]byte(stored.Settings), &defaults.Settings); err != nil {
+		return nil, fmt.Errorf("failed to decode pool defaults: %w", err)
+	}
+	updatedAt := stored.UpdatedAt
+	defaults.UpdatedAt = &updatedAt
+	return defaults, nil
+}
+
+// SetPoolDefaults replaces the default pool settings after checking that
+// they render. Sizes and durations are stored in canonical form.
+func (pm *PoolManager) SetPoolDefaults(settings map[string]interface{}) (err error) {
+	defer recordAudit(pm.context(), pm.db, "defaults.update", "", &err)
+	settings = mergeSettings(settings, nil)
+	if err := normalizeSettings(settings); err != nil {
+		return fmt.Errorf("invalid default settings: %w", err)
+	}
+	encoded, err := encodeProfileSettings(settings)
+	if err != nil {
+		return fmt.Errorf("invalid default settings: %w", errors.Unwrap(err))
+	}
+	// Every new pool would fail to render otherwise
+	data := templates.DefaultPoolConfigData("defaults", "defaults", "/run/php-fpm/defaults.sock")
+	applyPoolSettings(data, settings)
+	if err := validation.ProcessManager(data.ProcessManager, data.MaxChildren, data.StartServers, data.MinSpareServers, data.MaxSpareServers).Err(); err != nil {
+		return err
+	}
+	if err := pm.db.SavePoolDefaults(encoded); err != nil {
+		return fmt.Errorf("failed to save pool defaults: %w", err)
+	}
+	return nil
+}
+
+// newPoolSettings returns the settings a pool created through
+// CreatePoolWithProfile starts from: the defaults, then the tenant's
+func (pm *PoolManager) newPoolSettings() (map[string]interface{}, error) {
+	defaults, err := pm.GetPoolDefaults()
+	if err != nil {
+		return nil, err
+	}
+	tenant, err := pm.tenantDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return mergeSettings(defaults.Settings, tenant), nil
+}
@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"lightweight-php/config"
+	"lightweight-php/dns"
+)
+
+// SiteDNS is the DNS state of a site after a check and, optionally, an
+// update of its records
+type SiteDNS struct {
+	*dns.Check
+	// Provider is the configured DNS provider, empty when none is
+	Provider string `json:"provider,omitempty"`
+	// Changes lists the records changed by an update
+	Changes []string `json:"changes,omitempty"`
+}
+
+// CheckDNS reports whether a site's domain resolves to this server
+func (sm *SiteManager) CheckDNS(domain string) (*SiteDNS, error) {
+	if _, err := sm.getSiteRecord(domain); err != nil {
+		return nil, err
+	}
+	cfg := config.Get().DNS
+	server, err := dns.ServerAddresses(cfg.ServerAddresses)
+	if err != nil {
+		return nil, err
+	}
+	return &SiteDNS{Check: dns.CheckDomain(domain, server), Provider: cfg.Provider}, nil
+}
+
+// UpdateDNS points a site's A/AAAA records at this server through the
+// configured DNS provider. The returned check reflects the records before
+// the update; resolvers see the change once cached answers expire.
+func (sm *SiteManager) UpdateDNS(domain string) (*SiteDNS, error) {
+	status, err := sm.CheckDNS(domain)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := dns.NewProvider(config.Get().DNS)
+	if err != nil {
+		return status, err
+	}
+	status.Changes, err = dns.Update(provider, domain, status.ServerAddresses)
+	return status, err
+}
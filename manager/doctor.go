@@ -0,0 +1,328 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/provider"
+	"lightweight-php/servicemgr"
+	"lightweight-php/target"
+)
+
+// Severities of a Finding
+const (
+	FindingOK      = "ok"
+	FindingWarning = "warning"
+	FindingError   = "error"
+)
+
+// Finding is the result of one diagnostic check. Fix says what to do
+// about a warning or error.
+type Finding struct {
+	Check   string `json:"check"`
+	Subject string `json:"subject"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// Diagnostics is the outcome of Doctor. OK is false when any finding is an
+// error.
+type Diagnostics struct {
+	Username  string    `json:"username,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	OK        bool      `json:"ok"`
+	Errors    int       `json:"errors"`
+	Warnings  int       `json:"warnings"`
+	Findings  []Finding `json:"findings"`
+}
+
+func (d *Diagnostics) add(check, subject, status, message, fix string) {
+	d.Findings = append(d.Findings, Finding{Check: check, Subject: subject, Status: status, Message: message, Fix: fix})
+	switch status {
+	case FindingError:
+		d.Errors++
+	case FindingWarning:
+		d.Warnings++
+	}
+}
+
+// diskCheckPaths are the filesystems checked for free space: the state
+// database, logs and the pool users' homes
+var diskCheckPaths = []string{"/", "/var/lib/lightweight-php", "/var/log", "/home"}
+
+// Doctor checks the state database, free disk space, the package
+// repositories and PHP-FPM services the pools use, and each pool's file,
+// permissions, user, socket and SELinux labels. With username only that
+// user's pools and their versions are checked. Problems are reported as
+// findings rather than errors.
+func (pm *PoolManager) Doctor(username string) (*Diagnostics, error) {
+	var pools []db.Pool
+	if username != "" {
+		dbPool, err := pm.db.GetPool(username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pool from database: %w", err)
+		}
+		if dbPool == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+		}
+		pools = []db.Pool{*dbPool}
+	} else {
+		var err error
+		if pools, err = pm.db.ListPools(); err != nil {
+			return nil, fmt.Errorf("failed to list pools from database: %w", err)
+		}
+	}
+
+	d := &Diagnostics{Username: username, CheckedAt: time.Now().UTC(), Findings: []Finding{}}
+	if err := pm.db.CheckIntegrity(); err != nil {
+		d.add("database", "state database", FindingError, err.Error(),
+			"Restore a replicated snapshot with 'db restore', or recover what is readable with sqlite3's .recover")
+	} else {
+		d.add("database", "state database", FindingOK, "integrity check passed", "")
+	}
+	checkDiskSpace(d)
+
+	// Services, configuration and repositories are shared by the pools of
+	// a version and a provider
+	type versionKey struct{ target, provider, version string }
+	checked := make(map[versionKey]bool)
+	repos := make(map[string]bool)
+	for i := range pools {
+		p := &pools[i]
+		t, factory, err := pm.poolTarget(p)
+		if err != nil {
+			d.add("pool", p.Username, FindingError, err.Error(), "")
+			continue
+		}
+		phpProvider, err := factory.CreateProvider(providerTypeFor(p.Provider))
+		if err != nil {
+			d.add("pool", p.Username, FindingError, fmt.Sprintf("failed to create provider %s: %v", p.Provider, err), "")
+			continue
+		}
+		if repoKey := t.String() + "|" + p.Provider; !repos[repoKey] {
+			repos[repoKey] = true
+			pm.checkRepository(d, t, phpProvider)
+		}
+		if key := (versionKey{t.String(), p.Provider, p.PHPVersion}); !checked[key] {
+			checked[key] = true
+			pm.checkVersion(d, t, phpProvider, p.PHPVersion)
+		}
+		pm.checkPool(d, t, phpProvider, p)
+	}
+
+	d.OK = d.Errors == 0
+	return d, nil
+}
+
+// checkDiskSpace reports filesystems short of free space or inodes, each
+// filesystem once
+func checkDiskSpace(d *Diagnostics) {
+	seen := make(map[uint64]bool)
+	for _, path := range diskCheckPaths {
+		hostPath, err := target.Host.Path(path)
+		if err != nil {
+			continue
+		}
+		usage, err := diskUsage(hostPath)
+		if err != nil {
+			// Paths that do not exist live on a filesystem already checked
+			continue
+		}
+		if seen[usage.device] {
+			continue
+		}
+		seen[usage.device] = true
+
+		freePercent := 100 * float64(usage.free) / float64(usage.total)
+		message := fmt.Sprintf("%s free of %s (%.0f%%)", formatBytes(usage.free), formatBytes(usage.total), freePercent)
+		fix := "Free space on the filesystem of " + path + ", e.g. old logs and backups, or grow it"
+		switch {
+		case usage.free < 256<<20 || freePercent < 2:
+			d.add("disk", path, FindingError, message, fix)
+		case usage.free < 1<<30 || freePercent < 10:
+			d.add("disk", path, FindingWarning, message, fix)
+		default:
+			d.add("disk", path, FindingOK, message, "")
+		}
+		if usage.files > 0 && float64(usage.freeFiles) < 0.05*float64(usage.files) {
+			d.add("disk", path, FindingWarning, fmt.Sprintf("only %d of %d inodes free", usage.freeFiles, usage.files),
+				"Remove small files such as stale PHP sessions or cache entries under "+path)
+		}
+	}
+}
+
+// checkRepository reports a provider's package repository when it has one
+func (pm *PoolManager) checkRepository(d *Diagnostics, t target.Target, phpProvider provider.PHPProvider) {
+	checker, ok := phpProvider.(provider.RepositoryChecker)
+	// Development mode only logs the package manager commands
+	if !ok || target.DevRoot() != "" {
+		return
+	}
+	subject := phpProvider.GetProviderType()
+	if !t.IsHost() {
+		subject += " in " + t.String()
+	}
+	if err := checker.CheckRepository(); err != nil {
+		d.add("repository", subject, FindingError, err.Error(),
+			"Install a PHP version with this provider again to restore the repository, e.g. 'php install VERSION --provider "+phpProvider.GetProviderType()+"'")
+		return
+	}
+	d.add("repository", subject, FindingOK, "repository is set up", "")
+}
+
+// checkVersion reports the PHP-FPM service of a version and tests its
+// configuration, which includes every pool file of the version
+func (pm *PoolManager) checkVersion(d *Diagnostics, t target.Target, phpProvider provider.PHPProvider, version string) {
+	serviceName := phpProvider.GetServiceName(version)
+	subject := serviceName
+	if !t.IsHost() {
+		subject += " in " + t.String()
+	}
+	status, err := servicemgr.ForTarget(t, servicemgr.TargetRunner(pm.context(), t)).Status(serviceName)
+	switch {
+	case err != nil:
+		d.add("service", subject, FindingWarning, fmt.Sprintf("failed to read the service status: %v", err), "")
+	case status == servicemgr.StatusActive:
+		d.add("service", subject, FindingOK, "active", "")
+	case status == servicemgr.StatusUnknown:
+		d.add("service", subject, FindingOK, "not managed by a service manager on this target", "")
+	default:
+		d.add("service", subject, FindingError, string(status),
+			fmt.Sprintf("Read 'php fpm-log %s' and start the service again", version))
+	}
+
+	if fpmConfig := phpProvider.GetFPMConfigPath(version); fpmConfig != "" {
+		if err := fpmConfigTest(pm.context(), t, phpProvider, version, fpmConfig); err != nil {
+			d.add("config", fpmConfig, FindingError, err.Error(),
+				"Fix the reported line; pool files can be restored with 'pool rollback USER REVISION'")
+		} else {
+			d.add("config", fpmConfig, FindingOK, "configuration test passed", "")
+		}
+	}
+}
+
+// checkPool reports a pool's file, user, socket and SELinux labels
+func (pm *PoolManager) checkPool(d *Diagnostics, t target.Target, phpProvider provider.PHPProvider, p *db.Pool) {
+	if p.Status == db.PoolSuspended {
+		d.add("pool", p.Username, FindingOK, "suspended; not checked", "")
+		return
+	}
+	// Ownership is only meaningful outside the development sandbox
+	checkOwner := target.DevRoot() == ""
+
+	hostConfig, err := t.Path(p.ConfigPath)
+	if err != nil {
+		d.add("pool", p.Username, FindingError, err.Error(), "")
+		return
+	}
+	info, err := os.Stat(hostConfig)
+	switch {
+	case err != nil:
+		d.add("pool", p.Username, FindingError, fmt.Sprintf("pool file %s is missing", p.ConfigPath),
+			"Render it again with 'pool set "+p.Username+"' or restore a backup")
+	case info.Mode().Perm()&0022 != 0:
+		d.add("permissions", p.ConfigPath, FindingError, fmt.Sprintf("pool file is writable by group or others (%04o)", info.Mode().Perm()),
+			"chmod go-w "+p.ConfigPath)
+	case checkOwner && fileOwner(info) != 0:
+		d.add("permissions", p.ConfigPath, FindingError, fmt.Sprintf("pool file is owned by uid %d instead of root", fileOwner(info)),
+			"chown root: "+p.ConfigPath)
+	default:
+		d.add("pool", p.Username, FindingOK, "pool file "+p.ConfigPath+" is in place", "")
+	}
+
+	u, err := t.LookupUser(p.Username)
+	if err != nil {
+		d.add("user", p.Username, FindingError, fmt.Sprintf("user does not exist: %v", err),
+			"Create the user again with 'pool create' after deleting the pool, or with useradd")
+	} else if hostHome, err := t.Path(u.HomeDir); err == nil {
+		if home, err := os.Stat(hostHome); err != nil || !home.IsDir() {
+			d.add("permissions", u.HomeDir, FindingWarning, "home directory is missing",
+				fmt.Sprintf("mkdir -p %s && chown %s: %s", u.HomeDir, p.Username, u.HomeDir))
+		} else if checkOwner && fmt.Sprint(fileOwner(home)) != u.Uid {
+			d.add("permissions", u.HomeDir, FindingWarning, fmt.Sprintf("home directory is owned by uid %d, not %s", fileOwner(home), p.Username),
+				fmt.Sprintf("chown %s: %s", p.Username, u.HomeDir))
+		}
+	}
+
+	pm.checkSocket(d, t, phpProvider, p)
+	checkSELinux(d, t, p.ConfigPath, p.SocketPath)
+}
+
+// checkSocket reports whether a pool's listener exists and accepts
+// connections
+func (pm *PoolManager) checkSocket(d *Diagnostics, t target.Target, phpProvider provider.PHPProvider, p *db.Pool) {
+	listen, err := poolListen(t, p)
+	if err != nil {
+		d.add("socket", p.Username, FindingError, err.Error(), "")
+		return
+	}
+	service := phpProvider.GetServiceName(p.PHPVersion)
+	restart := fmt.Sprintf("Restart %s and read 'php fpm-log %s'", service, p.PHPVersion)
+	network := "tcp"
+	if addr, err := ParseListen(p.SocketPath); err == nil && addr.IsUnix() {
+		network = "unix"
+		info, err := os.Stat(listen)
+		if err != nil {
+			d.add("socket", p.SocketPath, FindingError, "socket does not exist", restart)
+			return
+		}
+		if info.Mode()&os.ModeSocket == 0 {
+			d.add("socket", p.SocketPath, FindingError, "path exists but is not a socket",
+				fmt.Sprintf("Remove %s and restart %s", p.SocketPath, service))
+			return
+		}
+	}
+	conn, err := net.DialTimeout(network, listen, 2*time.Second)
+	if err != nil {
+		d.add("socket", p.SocketPath, FindingError, fmt.Sprintf("not accepting connections: %v", err), restart)
+		return
+	}
+	conn.Close()
+	d.add("socket", p.SocketPath, FindingOK, "accepting connections", "")
+}
+
+// checkSELinux compares the labels of paths with the policy when SELinux
+// is enabled on the target
+func checkSELinux(d *Diagnostics, t target.Target, paths ...string) {
+	if enforce, err := t.Path("/sys/fs/selinux/enforce"); err != nil {
+		return
+	} else if _, err := os.Stat(enforce); err != nil {
+		return
+	}
+	if t.LookPath("matchpathcon") != nil {
+		return
+	}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			continue
+		}
+		output, err := t.Command("matchpathcon", "-V", path).CombinedOutput()
+		message := strings.TrimSpace(string(output))
+		if err != nil {
+			d.add("selinux", path, FindingError, message, "restorecon -v "+path)
+			continue
+		}
+		d.add("selinux", path, FindingOK, "label matches the policy", "")
+	}
+}
+
+// formatBytes formats a size with one decimal and a binary unit
+func formatBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d%s", n, units[0])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[unit])
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin
+
+package manager
+
+import (
+	"errors"
+	"os"
+)
+
+type filesystemUsage struct {
+	device           uint64
+	total, free      uint64
+	files, freeFiles uint64
+}
+
+func diskUsage(path string) (*filesystemUsage, error) {
+	return nil, errors.New("disk usage is not supported on this platform")
+}
+
+func fileOwner(info os.FileInfo) int {
+	return 0
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin
+
+package manager
+
+import (
+	"os"
+	"syscall"
+)
+
+// filesystemUsage is the free space and inodes of a filesystem
+type filesystemUsage struct {
+	device           uint64
+	total, free      uint64
+	files, freeFiles uint64
+}
+
+func diskUsage(path string) (*filesystemUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return nil, err
+	}
+	return &filesystemUsage{
+		device:    uint64(stat.Dev),
+		total:     st.Blocks * uint64(st.Bsize),
+		free:      st.Bavail * uint64(st.Bsize),
+		files:     st.Files,
+		freeFiles: st.Ffree,
+	}, nil
+}
+
+// fileOwner returns the uid owning a file
+func fileOwner(info os.FileInfo) int {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid)
+	}
+	return 0
+}
@@ -0,0 +1,542 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+// userININame is the per-directory ini file PHP-FPM reads next to scripts
+const userININame = ".user.ini"
+
+// userINIHeader starts the .user.ini files lightweight-php writes; files
+// without it belong to the user and are never overwritten
+const userINIHeader = "; Generated by lightweight-php"
+
+var (
+	phpValueNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.]{1,64}$`)
+	fpmAdminINIPattern  = regexp.MustCompile(`^php_admin_(?:value|flag)\[([^\]]+)\]$`)
+)
+
+// maxPHPValue bounds a php.ini override's value
+const maxPHPValue = 1024
+
+// Domain is a site of a pool's user with the php.ini overrides of its
+// document root
+type Domain struct {
+	Domain       string `json:"domain"`
+	DocumentRoot string `json:"document_root"`
+	// PHPVersion is the version serving "/"
+	PHPVersion string `json:"php_version"`
+	// PHPValues are written to .user.ini in the document root
+	PHPValues   map[string]string `json:"php_values"`
+	Bindings    []SiteBinding     `json:"bindings"`
+	SnippetPath string            `json:"snippet_path"`
+}
+
+// DomainSpec describes a domain to add to a pool
+type DomainSpec struct {
+	Domain string `json:"domain"`
+	// DocumentRoot defaults to public_html in the user's home and must be
+	// inside it
+	DocumentRoot string `json:"document_root,omitempty"`
+	// PHPVersion defaults to the version of the user's pool
+	PHPVersion string            `json:"php_version,omitempty"`
+	PHPValues  map[string]string `json:"php_values,omitempty"`
+}
+
+// DomainUpdate changes a domain; empty fields are kept
+type DomainUpdate struct {
+	DocumentRoot string `json:"document_root,omitempty"`
+	// PHPValues replaces the overrides when set; an empty map removes them
+	PHPValues map[string]string `json:"php_values,omitempty"`
+}
+
+// ValidatePHPValues checks php.ini overrides for a .user.ini file
+func ValidatePHPValues(values map[string]string) error {
+	var errs validation.Errors
+	for name, value := range values {
+		field := "php_values." + name
+		if !phpValueNamePattern.MatchString(name) {
+			errs = append(errs, validation.FieldError{Field: field, Message: "must be a php.ini directive of letters, digits, _ and ."})
+			continue
+		}
+		if len(value) > maxPHPValue {
+			errs = append(errs, validation.FieldError{Field: field, Message: fmt.Sprintf("must be at most %d characters", maxPHPValue)})
+		} else if strings.ContainsAny(value, "\"$") || strings.IndexFunc(value, func(r rune) bool { return r < ' ' || r == 0x7f }) >= 0 {
+			errs = append(errs, validation.FieldError{Field: field, Message: "must be a single line without quotes or $"})
+		}
+	}
+	return errs.Err()
+}
+
+// ListDomains returns the sites of a pool's user with their overrides
+func (pm *PoolManager) ListDomains(sm *SiteManager, username string) ([]Domain, error) {
+	if _, _, err := pm.targetPool(username); err != nil {
+		return nil, err
+	}
+	sites, err := pm.db.ListSites()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sites from database: %w", err)
+	}
+	domains := make([]Domain, 0)
+	for i := range sites {
+		if sites[i].Username != username {
+			continue
+		}
+		d, err := pm.toDomain(sm, &sites[i])
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, *d)
+	}
+	return domains, nil
+}
+
+// GetDomain returns a site of a pool's user
+func (pm *PoolManager) GetDomain(sm *SiteManager, username, domain string) (*Domain, error) {
+	s, err := pm.userSite(username, domain)
+	if err != nil {
+		return nil, err
+	}
+	return pm.toDomain(sm, s)
+}
+
+// AddDomain creates a site for a pool's user, creating its document root
+// if needed, and writes the .user.ini of its overrides
+func (pm *PoolManager) AddDomain(sm *SiteManager, username string, spec DomainSpec) (_ *Domain, err error) {
+	defer recordAudit(pm.context(), pm.db, "domain.add", username+"/"+spec.Domain, &err)
+	var invalid validation.Errors
+	invalid.Check("domain", spec.Domain, validation.Domain)
+	if spec.PHPVersion != "" {
+		invalid.Check("php_version", spec.PHPVersion, validation.PHPVersion)
+	}
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+	if err := ValidatePHPValues(spec.PHPValues); err != nil {
+		return nil, err
+	}
+	l, err := pm.acquire(lock.PoolKey(username), "add domain "+spec.Domain+" to "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.LookupUser(username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+	}
+	docroot := spec.DocumentRoot
+	if docroot == "" {
+		docroot = filepath.Join(u.HomeDir, "public_html")
+	}
+	if err := checkUserDocroot(t, u, docroot); err != nil {
+		return nil, err
+	}
+	if len(spec.PHPValues) > 0 {
+		pool, err := sm.lookupPool(username, spec.PHPVersion)
+		if err != nil {
+			return nil, err
+		}
+		if err := pm.checkPHPValues(t, 0, docroot, []int64{pool.ID}, spec.PHPValues); err != nil {
+			return nil, err
+		}
+	}
+	if err := ensureDocroot(t, u, docroot); err != nil {
+		return nil, err
+	}
+
+	site, err := sm.CreateSite(spec.Domain, username, docroot, spec.PHPVersion)
+	if err != nil {
+		return nil, err
+	}
+	record, err := pm.userSite(username, site.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(spec.PHPValues) > 0 {
+		if err := pm.applyPHPValues(t, record, spec.PHPValues); err != nil {
+			return nil, fmt.Errorf("domain added but its PHP values were not applied: %w", err)
+		}
+	}
+	return pm.toDomain(sm, record)
+}
+
+// UpdateDomain moves a domain to another document root or replaces its
+// php.ini overrides
+func (pm *PoolManager) UpdateDomain(sm *SiteManager, username, domain string, update DomainUpdate) (_ *Domain, err error) {
+	defer recordAudit(pm.context(), pm.db, "domain.update", username+"/"+domain, &err)
+	if err := ValidatePHPValues(update.PHPValues); err != nil {
+		return nil, err
+	}
+	l, err := pm.acquire(lock.PoolKey(username), "update domain "+domain+" of "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	record, err := pm.userSite(username, domain)
+	if err != nil {
+		return nil, err
+	}
+	values, err := pm.db.GetSitePHPValues(record.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PHP values: %w", err)
+	}
+	if update.PHPValues != nil {
+		values = update.PHPValues
+	}
+
+	if update.DocumentRoot != "" && update.DocumentRoot != record.DocumentRoot {
+		u, err := t.LookupUser(username)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+		}
+		if err := checkUserDocroot(t, u, update.DocumentRoot); err != nil {
+			return nil, err
+		}
+		poolIDs, err := pm.sitePoolIDs(record.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := pm.checkPHPValues(t, record.ID, update.DocumentRoot, poolIDs, values); err != nil {
+			return nil, err
+		}
+		if err := ensureDocroot(t, u, update.DocumentRoot); err != nil {
+			return nil, err
+		}
+		// The overrides follow the domain to its new document root
+		if err := pm.applyPHPValues(t, record, nil); err != nil {
+			return nil, err
+		}
+		if err := pm.db.UpdateSiteDocumentRoot(domain, update.DocumentRoot); err != nil {
+			return nil, fmt.Errorf("failed to update site: %w", err)
+		}
+		if _, err := sm.writeSnippet(domain); err != nil {
+			return nil, err
+		}
+		record.DocumentRoot = update.DocumentRoot
+	}
+	if err := pm.applyPHPValues(t, record, values); err != nil {
+		return nil, err
+	}
+	return pm.toDomain(sm, record)
+}
+
+// RemoveDomain deletes a site of a pool's user and the .user.ini written
+// for it; the document root and its files stay
+func (pm *PoolManager) RemoveDomain(sm *SiteManager, username, domain string) (err error) {
+	defer recordAudit(pm.context(), pm.db, "domain.remove", username+"/"+domain, &err)
+	l, err := pm.acquire(lock.PoolKey(username), "remove domain "+domain+" of "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return err
+	}
+	record, err := pm.userSite(username, domain)
+	if err != nil {
+		return err
+	}
+	if err := pm.applyPHPValues(t, record, nil); err != nil {
+		return err
+	}
+	return sm.DeleteSite(domain)
+}
+
+// userSite returns a site of the user; sites of other users are not found,
+// so pool-scoped API keys cannot tell them apart from missing ones
+func (pm *PoolManager) userSite(username, domain string) (*db.Site, error) {
+	s, err := pm.db.GetSite(domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get site from database: %w", err)
+	}
+	if s == nil || s.Username != username {
+		return nil, fmt.Errorf("%w: %s", ErrSiteNotFound, domain)
+	}
+	return s, nil
+}
+
+func (pm *PoolManager) toDomain(sm *SiteManager, s *db.Site) (*Domain, error) {
+	site, err := sm.toSite(s)
+	if err != nil {
+		return nil, err
+	}
+	values, err := pm.db.GetSitePHPValues(s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PHP values: %w", err)
+	}
+	d := &Domain{
+		Domain:       site.Domain,
+		DocumentRoot: site.DocumentRoot,
+		PHPValues:    values,
+		Bindings:     site.Bindings,
+		SnippetPath:  site.SnippetPath,
+	}
+	for _, b := range site.Bindings {
+		if b.PathPrefix == "/" {
+			d.PHPVersion = b.PHPVersion
+		}
+	}
+	return d, nil
+}
+
+// checkPHPValues checks overrides for a site (0 for one being created) in
+// docroot served by the given pools. The pools must not pin the directives
+// with php_admin_value, which .user.ini cannot override, and a document
+// root shared with another site holds the overrides of one of them only.
+func (pm *PoolManager) checkPHPValues(t target.Target, siteID int64, docroot string, poolIDs []int64, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	pinned, err := pm.adminINIKeys(t, poolIDs)
+	if err != nil {
+		return err
+	}
+	var errs validation.Errors
+	for name := range values {
+		if pinned[name] {
+			errs = append(errs, validation.FieldError{Field: "php_values." + name, Message: "is set by the pool with php_admin_value and cannot be overridden per document root; change the pool setting instead"})
+		}
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+
+	sites, err := pm.db.ListSites()
+	if err != nil {
+		return fmt.Errorf("failed to list sites from database: %w", err)
+	}
+	for _, other := range sites {
+		if other.ID == siteID || filepath.Clean(other.DocumentRoot) != filepath.Clean(docroot) {
+			continue
+		}
+		if otherValues, err := pm.db.GetSitePHPValues(other.ID); err != nil {
+			return fmt.Errorf("failed to get PHP values: %w", err)
+		} else if len(otherValues) > 0 {
+			return fmt.Errorf("document root %s is shared with %s, which has PHP values already", docroot, other.Domain)
+		}
+	}
+	return nil
+}
+
+// applyPHPValues checks and records a site's overrides and writes them to
+// .user.ini in its document root, or removes the file for none
+func (pm *PoolManager) applyPHPValues(t target.Target, s *db.Site, values map[string]string) error {
+	if len(values) > 0 {
+		poolIDs, err := pm.sitePoolIDs(s.ID)
+		if err != nil {
+			return err
+		}
+		if err := pm.checkPHPValues(t, s.ID, s.DocumentRoot, poolIDs, values); err != nil {
+			return err
+		}
+	}
+
+	previous, err := pm.db.GetSitePHPValues(s.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get PHP values: %w", err)
+	}
+	if len(values) > 0 || len(previous) > 0 {
+		if err := writeUserINI(t, s.Domain, s.DocumentRoot, values); err != nil {
+			return err
+		}
+	}
+	if err := pm.db.SetSitePHPValues(s.ID, values); err != nil {
+		return fmt.Errorf("failed to save PHP values: %w", err)
+	}
+	return nil
+}
+
+// sitePoolIDs returns the pools a site's bindings point at
+func (pm *PoolManager) sitePoolIDs(siteID int64) ([]int64, error) {
+	bindings, err := pm.db.ListSiteBindings(siteID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site bindings: %w", err)
+	}
+	poolIDs := make([]int64, 0, len(bindings))
+	for _, b := range bindings {
+		poolIDs = append(poolIDs, b.PoolID)
+	}
+	return poolIDs, nil
+}
+
+// adminINIKeys returns the directives that pools set with php_admin_value
+// or php_admin_flag
+func (pm *PoolManager) adminINIKeys(t target.Target, poolIDs []int64) (map[string]bool, error) {
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools: %w", err)
+	}
+	keys := make(map[string]bool)
+	for _, id := range poolIDs {
+		for _, p := range pools {
+			if p.ID != id {
+				continue
+			}
+			hostConfig, err := t.Path(p.ConfigPath)
+			if err != nil {
+				return nil, err
+			}
+			content, err := os.ReadFile(hostConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read pool config: %w", err)
+			}
+			for _, line := range splitLines(string(content)) {
+				key, _, ok := fpmDirective(strings.TrimSpace(line))
+				if !ok {
+					continue
+				}
+				if m := fpmAdminINIPattern.FindStringSubmatch(key); m != nil {
+					keys[m[1]] = true
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+// writeUserINI writes the .user.ini of a document root, or removes it for
+// no values. It runs as root in a directory the user owns, so the file is
+// written beside and renamed over, which replaces a planted symlink rather
+// than following it, and a file the user wrote is left alone.
+func writeUserINI(t target.Target, domain, docroot string, values map[string]string) error {
+	hostDocroot, err := t.Path(docroot)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(hostDocroot, userININame)
+	if info, err := os.Lstat(path); err == nil {
+		content, _ := os.ReadFile(path)
+		if !info.Mode().IsRegular() || !strings.HasPrefix(string(content), userINIHeader) {
+			return fmt.Errorf("%s has a %s of its own; move its settings into php_values first", docroot, userININame)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to check %s: %w", userININame, err)
+	}
+
+	if len(values) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", userININame, err)
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s for %s; changes are overwritten.\n", userINIHeader, domain)
+	b.WriteString("; PHP reads it again after user_ini.cache_ttl (300 seconds by default).\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s = \"%s\"\n", name, values[name])
+	}
+
+	tmp, err := os.CreateTemp(hostDocroot, ".user.ini.*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", userININame, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", userININame, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", userININame, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", userININame, err)
+	}
+	return nil
+}
+
+// checkUserDocroot requires a document root to be an absolute path inside
+// the user's home, also after resolving symlinks, since root writes files
+// there
+func checkUserDocroot(t target.Target, u *user.User, docroot string) error {
+	if !filepath.IsAbs(docroot) || filepath.Clean(docroot) != docroot {
+		return validation.Errors{{Field: "document_root", Message: "must be a clean absolute path"}}
+	}
+	if docroot != u.HomeDir && !strings.HasPrefix(docroot, u.HomeDir+"/") {
+		return validation.Errors{{Field: "document_root", Message: fmt.Sprintf("must be inside the home directory %s", u.HomeDir)}}
+	}
+	hostHome, err := t.Path(u.HomeDir)
+	if err != nil {
+		return err
+	}
+	hostDocroot, err := t.Path(docroot)
+	if err != nil {
+		return err
+	}
+	home, err := filepath.EvalSymlinks(hostHome)
+	if err != nil {
+		return fmt.Errorf("failed to resolve home directory: %w", err)
+	}
+	// Resolve the deepest existing parent; the rest is created as the user
+	existing := hostDocroot
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == hostHome {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return fmt.Errorf("failed to resolve document root: %w", err)
+	}
+	if resolved != home && !strings.HasPrefix(resolved, home+"/") {
+		return validation.Errors{{Field: "document_root", Message: fmt.Sprintf("must be inside the home directory %s; it leads to %s", u.HomeDir, resolved)}}
+	}
+	return nil
+}
+
+// ensureDocroot creates a document root owned by the user if it is missing
+func ensureDocroot(t target.Target, u *user.User, docroot string) error {
+	hostDocroot, err := t.Path(docroot)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(hostDocroot); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(hostDocroot, 0755); err != nil {
+		return fmt.Errorf("failed to create document root: %w", err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	hostUID, hostGID, err := t.HostIDs(uid, gid)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(hostDocroot, hostUID, hostGID); err != nil {
+		return fmt.Errorf("failed to chown document root: %w", err)
+	}
+	return nil
+}
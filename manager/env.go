@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"lightweight-php/templates"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnv checks an environment variable of a pool. Values are written
+// in double quotes, so they must not contain quotes, backslashes or control
+// characters.
+func ValidateEnv(name, value string) error {
+	if !envNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid env name %q: must be letters, digits and underscores, not starting with a digit", name)
+	}
+	if strings.ContainsAny(value, "\"\\") || strings.IndexFunc(value, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return fmt.Errorf("invalid value of env %s: must not contain quotes, backslashes or control characters", name)
+	}
+	return nil
+}
+
+// writePoolFile writes a pool file. Files setting env variables, which
+// often carry credentials, are readable by root only.
+func writePoolFile(path, config string) error {
+	mode := os.FileMode(0644)
+	if strings.Contains(config, "\nenv[") {
+		mode = 0600
+	}
+	if err := os.WriteFile(path, []byte(config), mode); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
+// poolEnv returns the variables of the env setting sorted by name
+func poolEnv(value interface{}) ([]templates.EnvVar, error) {
+	env, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid env: must be an object of names and values")
+	}
+	vars := make([]templates.EnvVar, 0, len(env))
+	for name, v := range env {
+		s, ok := settingString(v)
+		if !ok {
+			return nil, fmt.Errorf("invalid value of env %s: must be a string", name)
+		}
+		if err := ValidateEnv(name, s); err != nil {
+			return nil, err
+		}
+		vars = append(vars, templates.EnvVar{Name: name, Value: s})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars, nil
+}
@@ -0,0 +1,378 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+// ErasedLogDir holds error logs of erased accounts kept for
+// retention.log_days, one directory per erasure named after its subject
+const ErasedLogDir = "/var/log/lightweight-php/erased"
+
+// ErasureReport describes what EraseAccount removed and what it kept.
+// Username, Sites and Removed identify the person and are only returned to
+// the caller; the stored record keeps the rest.
+type ErasureReport struct {
+	ID       int64     `json:"id"`
+	Subject  string    `json:"subject"`
+	Username string    `json:"username,omitempty"`
+	ErasedAt time.Time `json:"erased_at"`
+	// Pools lists the erased pools as "8.2 (remi)"
+	Pools     []string       `json:"pools"`
+	Sites     []string       `json:"sites,omitempty"`
+	SiteCount int            `json:"site_count"`
+	Removed   []string       `json:"removed,omitempty"`
+	FileCount int            `json:"file_count"`
+	Retained  []RetainedData `json:"retained"`
+	Notes     []string       `json:"notes"`
+}
+
+// RetainedData is data of an erased account kept under a retention policy
+// and deleted by PurgeExpiredRetention once Until has passed
+type RetainedData struct {
+	Target string    `json:"target,omitempty"`
+	Path   string    `json:"path"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// RetentionSweep reports what PurgeExpiredRetention deleted
+type RetentionSweep struct {
+	RemovedPaths   int `json:"removed_paths"`
+	DeletedRecords int `json:"deleted_records"`
+}
+
+// ErasureSubject is the pseudonym erasure records use for a username. It
+// lets an operator check whether an account was erased without the record
+// naming it.
+func ErasureSubject(username string) string {
+	sum := sha256.Sum256([]byte(username))
+	return hex.EncodeToString(sum[:])
+}
+
+// EraseAccount removes everything lightweight-php stores about an account:
+// its sites and nginx snippets, its pools with their configs, session and
+// tmp directories, PHP error logs and migration state. Logs are moved aside
+// instead when retention.log_days is set. A pseudonymous record of the
+// erasure is kept and the database is vacuumed so deleted rows do not
+// linger on disk.
+func (pm *PoolManager) EraseAccount(username string) (_ *ErasureReport, err error) {
+	defer recordAudit(pm.context(), pm.db, "account.erase", ErasureSubject(username), &err)
+	// The name is joined to the session, tmp and log paths being removed
+	if err := validation.Field("username", username, validation.Username); err != nil {
+		return nil, err
+	}
+	l, err := pm.acquire(lock.PoolKey(username), "erase account "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	retention := config.Get().Retention
+	now := time.Now().UTC()
+	report := &ErasureReport{
+		Subject:  ErasureSubject(username),
+		Username: username,
+		ErasedAt: now,
+		Pools:    make([]string, 0),
+		Retained: make([]RetainedData, 0),
+		Notes:    make([]string, 0),
+	}
+
+	// Sites first: their bindings reference the pools
+	sites, err := pm.db.ListSites()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sites from database: %w", err)
+	}
+	sm := NewSiteManagerWithDeps(pm.db).WithContext(pm.context())
+	for _, s := range sites {
+		if s.Username != username {
+			continue
+		}
+		if err := sm.DeleteSite(s.Domain); err != nil {
+			return nil, fmt.Errorf("failed to delete site %s: %w", s.Domain, err)
+		}
+		report.Sites = append(report.Sites, s.Domain)
+	}
+	report.SiteCount = len(report.Sites)
+
+	allPools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	var pools []db.Pool
+	targets := []target.Target{target.Host}
+	for _, p := range allPools {
+		if p.Username != username {
+			continue
+		}
+		pools = append(pools, p)
+		if t, err := target.Parse(p.Target); err == nil && !containsTarget(targets, t) {
+			targets = append(targets, t)
+		}
+	}
+
+	for i := range pools {
+		p := &pools[i]
+		t, factory, err := pm.poolTarget(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := pm.removeErasedFile(report, t, p.ConfigPath); err != nil {
+			return nil, err
+		}
+		if err := pm.removeErasedFile(report, t, p.ConfigPath+suspendedSuffix); err != nil {
+			return nil, err
+		}
+		if phpProvider, err := factory.CreateProvider(providerTypeFor(p.Provider)); err == nil {
+			if err := pm.reloadFPMService(t, phpProvider, p.PHPVersion); err != nil {
+				report.Notes = append(report.Notes, fmt.Sprintf("PHP-FPM %s could not be reloaded: %v", p.PHPVersion, err))
+			}
+		}
+		report.Pools = append(report.Pools, fmt.Sprintf("%s (%s)", p.PHPVersion, p.Provider))
+	}
+	if len(pools) > 0 {
+		if err := pm.db.DeletePool(username); err != nil {
+			return nil, fmt.Errorf("failed to delete pools from database: %w", err)
+		}
+		for i := range pools {
+			_, factory, err := pm.poolTarget(&pools[i])
+			if err != nil {
+				continue
+			}
+			if phpProvider, err := factory.CreateProvider(providerTypeFor(pools[i].Provider)); err == nil {
+				pm.syncAPCu(phpProvider, &pools[i])
+			}
+		}
+	}
+
+	for _, t := range targets {
+		for _, dir := range append(poolDirs(username), CLIWrapperPath(username), filepath.Join(cliBinDir, username)) {
+			if err := pm.removeErasedFile(report, t, dir); err != nil {
+				return nil, err
+			}
+		}
+		if err := pm.eraseLogs(report, t, username, retention.LogDays); err != nil {
+			return nil, err
+		}
+	}
+	if err := pm.removeErasedFile(report, target.Host, filepath.Join(MigrationStateDir, username+".json")); err != nil {
+		return nil, err
+	}
+	report.FileCount = len(report.Removed)
+
+	if len(pools) == 0 && report.SiteCount == 0 && report.FileCount == 0 && len(report.Retained) == 0 {
+		previous, err := pm.db.ListErasures(report.Subject)
+		if err == nil && len(previous) > 0 {
+			return nil, fmt.Errorf("account %s was already erased on %s", username, previous[len(previous)-1].CreatedAt.Format(time.RFC3339))
+		}
+		return nil, fmt.Errorf("no data stored for account %s", username)
+	}
+
+	report.Notes = append(report.Notes,
+		"Home directories and site document roots belong to the system account and were not removed",
+		"Pool bundles exported with `pool export` and off-host backups are not tracked; delete copies of them separately")
+	if retention.ErasureRecordDays > 0 {
+		report.Notes = append(report.Notes, fmt.Sprintf("This record is kept until %s", now.AddDate(0, 0, retention.ErasureRecordDays).Format("2006-01-02")))
+	}
+
+	// The audit log keeps what was done, but no longer to whom
+	if err := pm.db.PseudonymizeAuditEntries(append([]string{username}, report.Sites...), report.Subject); err != nil {
+		return nil, fmt.Errorf("failed to pseudonymize audit log: %w", err)
+	}
+	// Billing usage keeps its figures for months not yet invoiced
+	if err := pm.db.PseudonymizePoolUsage(username, report.Subject); err != nil {
+		return nil, fmt.Errorf("failed to pseudonymize billing usage: %w", err)
+	}
+
+	record := *report
+	record.Username = ""
+	record.Sites = nil
+	record.Removed = nil
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode erasure record: %w", err)
+	}
+	if report.ID, err = pm.db.CreateErasure(report.Subject, string(encoded)); err != nil {
+		return nil, fmt.Errorf("failed to store erasure record: %w", err)
+	}
+
+	if err := pm.db.Vacuum(); err != nil {
+		report.Notes = append(report.Notes, fmt.Sprintf("The database could not be vacuumed, deleted rows may remain in free pages: %v", err))
+	}
+	return report, nil
+}
+
+// ListErasures returns the stored erasure records, oldest first. With a
+// username only the records of that account are returned.
+func (pm *PoolManager) ListErasures(username string) ([]ErasureReport, error) {
+	subject := ""
+	if username != "" {
+		subject = ErasureSubject(username)
+	}
+	records, err := pm.db.ListErasures(subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list erasure records: %w", err)
+	}
+
+	reports := make([]ErasureReport, 0, len(records))
+	for _, r := range records {
+		var report ErasureReport
+		if err := json.Unmarshal([]byte(r.Report), &report); err != nil {
+			return nil, fmt.Errorf("failed to decode erasure record %d: %w", r.ID, err)
+		}
+		report.ID = r.ID
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// PurgeExpiredRetention deletes retained data whose retention has passed
+// and erasure records older than retention.erasure_record_days. A record is
+// kept while it still tracks retained data.
+func (pm *PoolManager) PurgeExpiredRetention() (*RetentionSweep, error) {
+	reports, err := pm.ListErasures("")
+	if err != nil {
+		return nil, err
+	}
+
+	recordDays := config.Get().Retention.ErasureRecordDays
+	now := time.Now().UTC()
+	sweep := &RetentionSweep{}
+	for _, report := range reports {
+		kept := make([]RetainedData, 0, len(report.Retained))
+		for _, r := range report.Retained {
+			if now.Before(r.Until) {
+				kept = append(kept, r)
+				continue
+			}
+			t, err := target.Parse(r.Target)
+			if err != nil {
+				return sweep, err
+			}
+			p, err := t.Path(r.Path)
+			if err != nil {
+				// A stopped container is retried on the next sweep
+				kept = append(kept, r)
+				continue
+			}
+			if err := os.RemoveAll(p); err != nil {
+				return sweep, fmt.Errorf("failed to remove %s: %w", p, err)
+			}
+			sweep.RemovedPaths++
+		}
+
+		if len(kept) == 0 && recordDays > 0 && now.After(report.ErasedAt.AddDate(0, 0, recordDays)) {
+			if err := pm.db.DeleteErasure(report.ID); err != nil {
+				return sweep, fmt.Errorf("failed to delete erasure record %d: %w", report.ID, err)
+			}
+			sweep.DeletedRecords++
+			continue
+		}
+		if len(kept) != len(report.Retained) {
+			report.Retained = kept
+			id := report.ID
+			report.ID = 0
+			encoded, err := json.Marshal(report)
+			if err != nil {
+				return sweep, err
+			}
+			if err := pm.db.UpdateErasureReport(id, string(encoded)); err != nil {
+				return sweep, fmt.Errorf("failed to update erasure record %d: %w", id, err)
+			}
+		}
+	}
+	return sweep, nil
+}
+
+// eraseLogs deletes the account's PHP error logs in t, including rotated
+// ones, or moves them to ErasedLogDir when logDays is set
+func (pm *PoolManager) eraseLogs(report *ErasureReport, t target.Target, username string, logDays int) error {
+	pattern, err := t.Path(fmt.Sprintf("/var/log/fpm-php.%s.log*", username))
+	if err != nil {
+		return err
+	}
+	logs, _ := filepath.Glob(pattern)
+	if len(logs) == 0 {
+		return nil
+	}
+
+	if logDays == 0 {
+		for _, log := range logs {
+			if err := os.Remove(log); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", log, err)
+			}
+			report.Removed = append(report.Removed, displayPath(t, "/var/log/"+filepath.Base(log)))
+		}
+		return nil
+	}
+
+	// The retained copy is named after the subject, not the username
+	dir := path.Join(ErasedLogDir, report.Subject[:12]+"-"+report.ErasedAt.Format("20060102"))
+	hostDir, err := t.Path(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(hostDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", hostDir, err)
+	}
+	for _, log := range logs {
+		name := "fpm-php" + strings.TrimPrefix(filepath.Base(log), "fpm-php."+username)
+		if err := os.Rename(log, filepath.Join(hostDir, name)); err != nil {
+			return fmt.Errorf("failed to move %s: %w", log, err)
+		}
+	}
+	report.Retained = append(report.Retained, RetainedData{
+		Target: t.String(),
+		Path:   dir,
+		Until:  report.ErasedAt.AddDate(0, 0, logDays),
+		Reason: fmt.Sprintf("PHP error logs kept for retention.log_days (%d)", logDays),
+	})
+	return nil
+}
+
+// removeErasedFile removes a file or directory inside t, if present
+func (pm *PoolManager) removeErasedFile(report *ErasureReport, t target.Target, p string) error {
+	hostP, err := t.Path(p)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(hostP); os.IsNotExist(err) {
+		return nil
+	}
+	if err := os.RemoveAll(hostP); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", hostP, err)
+	}
+	report.Removed = append(report.Removed, displayPath(t, p))
+	return nil
+}
+
+// displayPath prefixes a path with its target unless it is on the host
+func displayPath(t target.Target, p string) string {
+	if t.IsHost() {
+		return p
+	}
+	return t.String() + ":" + p
+}
+
+func containsTarget(list []target.Target, t target.Target) bool {
+	for _, item := range list {
+		if item == t {
+			return true
+		}
+	}
+	return false
+}
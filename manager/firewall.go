@@ -0,0 +1,195 @@
+package manager
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/target"
+)
+
+// FirewallPort is a TCP port opened in the host firewall
+type FirewallPort struct {
+	Port    int      `json:"port"`
+	Sources []string `json:"sources"`
+	Comment string   `json:"comment"`
+}
+
+// firewallTool returns the firewall managed on t: firewall.tool, or with
+// "auto" the one that is running; "" leaves the firewall alone
+func firewallTool(t target.Target) string {
+	switch tool := config.Get().Firewall.Tool; tool {
+	case "auto":
+		if t.Command("firewall-cmd", "--state").Run() == nil {
+			return "firewalld"
+		}
+		if output, err := t.Command("ufw", "status").Output(); err == nil && strings.Contains(string(output), "Status: active") {
+			return "ufw"
+		}
+		return ""
+	case "none":
+		return ""
+	default:
+		return tool
+	}
+}
+
+// changeFirewall opens or closes a TCP port for sources, or for any source
+// when there are none. firewalld's runtime and permanent configuration are
+// changed alike, so no reload is needed.
+func changeFirewall(t target.Target, open bool, p FirewallPort) error {
+	tool := firewallTool(t)
+	if tool == "" {
+		return nil
+	}
+
+	var commands [][]string
+	switch tool {
+	case "firewalld":
+		op := "--add-"
+		if !open {
+			op = "--remove-"
+		}
+		var rules []string
+		if len(p.Sources) == 0 {
+			rules = append(rules, fmt.Sprintf("%sport=%d/tcp", op, p.Port))
+		}
+		for _, source := range p.Sources {
+			family := "ipv4"
+			if strings.Contains(source, ":") {
+				family = "ipv6"
+			}
+			rules = append(rules, fmt.Sprintf(`%srich-rule=rule family="%s" source address="%s" port port="%d" protocol="tcp" accept`, op, family, source, p.Port))
+		}
+		for _, rule := range rules {
+			commands = append(commands, []string{"firewall-cmd", rule}, []string{"firewall-cmd", "--permanent", rule})
+		}
+	case "ufw":
+		sources := p.Sources
+		if len(sources) == 0 {
+			sources = []string{"any"}
+		}
+		for _, source := range sources {
+			rule := []string{"allow", "proto", "tcp", "from", source, "to", "any", "port", strconv.Itoa(p.Port)}
+			if open {
+				rule = append(rule, "comment", p.Comment)
+			} else {
+				rule = append([]string{"delete"}, rule...)
+			}
+			commands = append(commands, append([]string{"ufw"}, rule...))
+		}
+	}
+
+	verb := "open"
+	if !open {
+		verb = "close"
+	}
+	for _, c := range commands {
+		if output, err := t.Command(c[0], c[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to %s port %d with %s: %v: %s", verb, p.Port, tool, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// firewallPort returns the port a listener needs opened: 0 for sockets and
+// loopback addresses, which the firewall does not filter
+func firewallPort(listen string) int {
+	addr, err := ParseListen(listen)
+	if err != nil || addr.IsUnix() {
+		return 0
+	}
+	if ip := net.ParseIP(addr.Host); (ip != nil && ip.IsLoopback()) || addr.Host == "localhost" {
+		return 0
+	}
+	return addr.Port
+}
+
+// poolFirewallPort describes the firewall rule of a pool listening on
+// listen, or returns nil when it needs none
+func poolFirewallPort(username, listen string) *FirewallPort {
+	port := firewallPort(listen)
+	if port == 0 {
+		return nil
+	}
+	return &FirewallPort{
+		Port:    port,
+		Sources: config.Get().Firewall.PoolSources,
+		Comment: "lightweight-php pool " + username,
+	}
+}
+
+// syncPoolFirewall closes the port of a pool's previous listener and opens
+// the port of its new one; "" stands for no listener. Pools in other
+// targets are left to the target's own network.
+func syncPoolFirewall(t target.Target, username, previous, listen string) error {
+	if !t.IsHost() || firewallPort(previous) == firewallPort(listen) {
+		return nil
+	}
+	if p := poolFirewallPort(username, previous); p != nil {
+		if err := changeFirewall(t, false, *p); err != nil {
+			return err
+		}
+	}
+	if p := poolFirewallPort(username, listen); p != nil {
+		return changeFirewall(t, true, *p)
+	}
+	return nil
+}
+
+// SyncFirewall opens the ports of every TCP pool on the host that listens
+// on more than loopback, for pools that existed before firewall.tool was
+// set. Rules of earlier firewall.pool_sources are not removed.
+func (pm *PoolManager) SyncFirewall() ([]FirewallPort, error) {
+	if firewallTool(target.Host) == "" {
+		return nil, fmt.Errorf("no firewall to manage; set firewall.tool to firewalld, ufw or auto")
+	}
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	opened := []FirewallPort{}
+	for _, p := range pools {
+		if !isHostPool(&p) {
+			continue
+		}
+		port := poolFirewallPort(p.Username, p.SocketPath)
+		if port == nil {
+			continue
+		}
+		if err := changeFirewall(target.Host, true, *port); err != nil {
+			return opened, err
+		}
+		opened = append(opened, *port)
+	}
+	return opened, nil
+}
+
+// OpenAPIFirewall opens the API server's port for firewall.api_sources
+// when it listens on more than loopback
+func OpenAPIFirewall(addrs []string) error {
+	seen := make(map[int]bool)
+	for _, addr := range addrs {
+		port := firewallPort(addr)
+		if port == 0 || seen[port] {
+			continue
+		}
+		seen[port] = true
+		if err := changeFirewall(target.Host, true, FirewallPort{
+			Port:    port,
+			Sources: config.Get().Firewall.APISources,
+			Comment: "lightweight-php API",
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isHostPool(p *db.Pool) bool {
+	t, err := target.Parse(p.Target)
+	return err == nil && t.IsHost()
+}
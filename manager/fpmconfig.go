@@ -0,0 +1,457 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+	"lightweight-php/tracing"
+)
+
+// The managed settings live in a marked block right after [global] in the
+// version's php-fpm.conf. Distro lines for the same keys are commented out
+// with fpmDisabledPrefix so the block wins, and restored when a setting is
+// removed again.
+const (
+	fpmBlockBegin     = "; BEGIN lightweight-php managed settings"
+	fpmBlockEnd       = "; END lightweight-php managed settings"
+	fpmDisabledPrefix = ";lightweight-php: "
+)
+
+// FPMLogLevels are the values php-fpm accepts for log_level
+var FPMLogLevels = []string{"alert", "error", "warning", "notice", "debug"}
+
+// FPMConfigKeys are the [global] settings managed through fpm-config
+var FPMConfigKeys = []string{
+	"error_log", "log_level", "daemonize",
+	"emergency_restart_threshold", "emergency_restart_interval",
+	"process_control_timeout", "process.max",
+}
+
+// ErrFPMConfigInvalid is returned when php-fpm rejects a changed
+// php-fpm.conf; the previous file was put back
+var ErrFPMConfigInvalid = errors.New("PHP-FPM configuration test failed")
+
+// maxFPMLogLines caps how much of the master log is returned at once
+const maxFPMLogLines = 1000
+
+// FPMConfig is the master ([global]) configuration of a PHP version
+type FPMConfig struct {
+	Version  string `json:"version"`
+	Provider string `json:"provider"`
+	Path     string `json:"path"`
+	// Settings are the values managed by lightweight-php
+	Settings map[string]string `json:"settings"`
+	// Effective are the values php-fpm uses for FPMConfigKeys, whether
+	// managed or from the distro's file; unset keys use php-fpm's defaults
+	Effective map[string]string `json:"effective"`
+}
+
+// FPMLog is the tail of a version's FPM master log
+type FPMLog struct {
+	Version string `json:"version"`
+	// Source is "file" or "journal" (error_log = syslog or unset)
+	Source string   `json:"source"`
+	Path   string   `json:"path,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	Lines  []string `json:"lines"`
+}
+
+// NormalizeFPMSetting validates a master setting and returns it in the form
+// written to php-fpm.conf. nil, which removes a setting, is valid for every
+// known key.
+func NormalizeFPMSetting(key string, value interface{}) (string, error) {
+	if value == nil && containsString(FPMConfigKeys, key) {
+		return "", nil
+	}
+	switch key {
+	case "error_log":
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("must be a string")
+		}
+		if s == "syslog" {
+			return s, nil
+		}
+		if !filepath.IsAbs(s) || filepath.Clean(s) != s || strings.ContainsAny(s, " \t\r\n;\"'") {
+			return "", fmt.Errorf("must be syslog or a clean absolute path without spaces")
+		}
+		return s, nil
+	case "log_level":
+		s, ok := value.(string)
+		if !ok || !containsString(FPMLogLevels, s) {
+			return "", fmt.Errorf("must be one of: %s", strings.Join(FPMLogLevels, ", "))
+		}
+		return s, nil
+	case "daemonize":
+		flag, ok := settingFlag(value)
+		if !ok {
+			return "", fmt.Errorf("must be a boolean or one of yes/no")
+		}
+		if flag == "1" {
+			return "yes", nil
+		}
+		return "no", nil
+	case "emergency_restart_threshold", "process.max":
+		// 0 disables both
+		s, _ := settingString(value)
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return "", fmt.Errorf("must be a whole number of at least 0")
+		}
+		return strconv.Itoa(n), nil
+	case "emergency_restart_interval", "process_control_timeout":
+		s, _ := settingString(value)
+		seconds, err := ParseDuration(s)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(seconds, 10), nil
+	}
+	return "", fmt.Errorf("unknown FPM master setting; known: %s", strings.Join(FPMConfigKeys, ", "))
+}
+
+// GetFPMConfig reads the master configuration of a PHP version
+func (pm *PackageManager) GetFPMConfig(version string, providerType provider.ProviderType) (*FPMConfig, error) {
+	_, path, content, err := pm.readFPMConfig(version, providerType)
+	if err != nil {
+		return nil, err
+	}
+	return parseFPMConfig(version, providerType, path, content), nil
+}
+
+// UpdateFPMConfig changes master settings of a PHP version and reloads its
+// FPM service. A nil value removes a managed setting, which restores the
+// distro's own line. The new file is tested with php-fpm -t before the
+// reload; the previous file is put back if the test or the reload fails.
+func (pm *PackageManager) UpdateFPMConfig(version string, providerType provider.ProviderType, changes map[string]interface{}) (*FPMConfig, error) {
+	phpProvider, path, content, err := pm.readFPMConfig(version, providerType)
+	if err != nil {
+		return nil, err
+	}
+	l, err := pm.locks.Acquire(lock.FPMConfigKey(path), fmt.Sprintf("configure php-fpm %s (%s)", version, providerType), !pm.noWait, poolLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	// Re-read under the lock
+	if _, _, content, err = pm.readFPMConfig(version, providerType); err != nil {
+		return nil, err
+	}
+
+	settings := parseFPMConfig(version, providerType, path, content).Settings
+	for key, value := range changes {
+		normalized, err := NormalizeFPMSetting(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FPM master setting %s: %w", key, err)
+		}
+		if value == nil {
+			delete(settings, key)
+		} else {
+			settings[key] = normalized
+		}
+	}
+
+	updated, err := rewriteFPMGlobal(content, settings)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	t := pm.providerFactory.Target()
+	hostPath, err := t.Path(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(hostPath, []byte(updated), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write FPM config: %w", err)
+	}
+
+	if err := pm.testFPMConfig(phpProvider, version, path); err != nil {
+		if werr := os.WriteFile(hostPath, []byte(content), 0644); werr != nil {
+			return nil, fmt.Errorf("%w; restoring the previous config failed: %v", err, werr)
+		}
+		return nil, fmt.Errorf("%w; previous config restored", err)
+	}
+
+	if err := reloadFPMIn(pm.context(), t, phpProvider, version); err != nil {
+		if werr := os.WriteFile(hostPath, []byte(content), 0644); werr == nil {
+			reloadFPMIn(pm.context(), t, phpProvider, version)
+		}
+		return nil, fmt.Errorf("failed to reload PHP-FPM, previous config restored: %w", err)
+	}
+	return parseFPMConfig(version, providerType, path, updated), nil
+}
+
+// FPMMasterLog returns the last lines of a PHP version's FPM master log. The
+// log is read from error_log, or from the service's journal when php-fpm
+// logs to syslog or the path is relative to its compile-time prefix.
+func (pm *PackageManager) FPMMasterLog(version string, providerType provider.ProviderType, lines int) (*FPMLog, error) {
+	if lines <= 0 || lines > maxFPMLogLines {
+		return nil, fmt.Errorf("lines must be between 1 and %d", maxFPMLogLines)
+	}
+	phpProvider, path, content, err := pm.readFPMConfig(version, providerType)
+	if err != nil {
+		return nil, err
+	}
+	t := pm.providerFactory.Target()
+
+	errorLog := parseFPMConfig(version, providerType, path, content).Effective["error_log"]
+	if filepath.IsAbs(errorLog) {
+		hostPath, err := t.Path(errorLog)
+		if err != nil {
+			return nil, err
+		}
+		tail, err := tailFile(hostPath, lines)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FPM master log: %w", err)
+		}
+		return &FPMLog{Version: version, Source: "file", Path: errorLog, Lines: tail}, nil
+	}
+
+	unit := phpProvider.GetServiceName(version)
+	output, err := t.Command("journalctl", "--unit", unit, "--lines", strconv.Itoa(lines), "--no-pager", "--output", "short-iso").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the journal of %s: %w", unit, err)
+	}
+	return &FPMLog{Version: version, Source: "journal", Unit: unit, Lines: splitLines(string(output))}, nil
+}
+
+// testFPMConfig runs php-fpm's configuration test on a version's
+// php-fpm.conf, which also checks every pool it includes. Providers without
+// an FPM binary on the host, such as docker, are not tested.
+func (pm *PackageManager) testFPMConfig(phpProvider provider.PHPProvider, version, path string) error {
+	return fpmConfigTest(pm.context(), pm.providerFactory.Target(), phpProvider, version, path)
+}
+
+func fpmConfigTest(ctx context.Context, t target.Target, phpProvider provider.PHPProvider, version, path string) error {
+	binary := phpProvider.GetFPMBinaryPath(version)
+	if binary == "" || t.LookPath(binary) != nil {
+		return nil
+	}
+	cmd := t.Command(binary, "-t", "-y", path)
+	span := tracing.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrFPMConfigInvalid, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readFPMConfig returns the provider, the path inside the target and the
+// content of a version's php-fpm.conf
+func (pm *PackageManager) readFPMConfig(version string, providerType provider.ProviderType) (provider.PHPProvider, string, string, error) {
+	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to create provider: %w", err)
+	}
+	path := phpProvider.GetFPMConfigPath(version)
+	if path == "" {
+		return nil, "", "", fmt.Errorf("provider %s has no PHP-FPM master process", providerType)
+	}
+	hostPath, err := pm.providerFactory.Target().Path(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+	content, err := os.ReadFile(hostPath)
+	if os.IsNotExist(err) {
+		return nil, "", "", fmt.Errorf("%s not found; is PHP %s (%s) installed?", path, version, providerType)
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read FPM config: %w", err)
+	}
+	return phpProvider, path, string(content), nil
+}
+
+// parseFPMConfig reads the managed block and the effective [global] values
+func parseFPMConfig(version string, providerType provider.ProviderType, path, content string) *FPMConfig {
+	cfg := &FPMConfig{
+		Version:   version,
+		Provider:  string(providerType),
+		Path:      path,
+		Settings:  make(map[string]string),
+		Effective: make(map[string]string),
+	}
+	inGlobal, inBlock := false, false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == fpmBlockBegin:
+			inBlock = true
+			continue
+		case trimmed == fpmBlockEnd:
+			inBlock = false
+			continue
+		case strings.HasPrefix(trimmed, "["):
+			inGlobal = trimmed == "[global]"
+			continue
+		}
+		key, value, ok := fpmDirective(trimmed)
+		if !ok || !inGlobal || !containsString(FPMConfigKeys, key) {
+			continue
+		}
+		if inBlock {
+			cfg.Settings[key] = value
+		}
+		// Later lines win, as they do for php-fpm
+		cfg.Effective[key] = value
+	}
+	return cfg
+}
+
+// renderFPMGlobal renders the managed block's settings from
+// fpm-global.conf.tmpl
+func renderFPMGlobal(settings map[string]string) ([]string, error) {
+	templateContent, err := templates.LoadTemplate("fpm-global.conf.tmpl")
+	if err != nil {
+		return nil, err
+	}
+	rendered, err := templates.RenderFPMGlobalConfig(templateContent, &templates.FPMGlobalConfigData{
+		ErrorLog:                  settings["error_log"],
+		LogLevel:                  settings["log_level"],
+		Daemonize:                 settings["daemonize"],
+		EmergencyRestartThreshold: settings["emergency_restart_threshold"],
+		EmergencyRestartInterval:  settings["emergency_restart_interval"],
+		ProcessControlTimeout:     settings["process_control_timeout"],
+		ProcessMax:                settings["process.max"],
+	})
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, line := range strings.Split(rendered, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// rewriteFPMGlobal replaces the managed block of a php-fpm.conf with
+// settings, disabling distro lines for managed keys and restoring those of
+// keys no longer managed
+func rewriteFPMGlobal(content string, settings map[string]string) (string, error) {
+	var lines []string
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == fpmBlockBegin {
+			inBlock = true
+			continue
+		}
+		if inBlock {
+			inBlock = trimmed != fpmBlockEnd
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	global := -1
+	inGlobal := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inGlobal = trimmed == "[global]"
+			if inGlobal && global < 0 {
+				global = i
+			}
+			continue
+		}
+		if !inGlobal {
+			continue
+		}
+		if strings.HasPrefix(trimmed, fpmDisabledPrefix) {
+			line = strings.TrimPrefix(trimmed, fpmDisabledPrefix)
+			trimmed = line
+			lines[i] = line
+		}
+		if key, _, ok := fpmDirective(trimmed); ok {
+			if _, managed := settings[key]; managed {
+				lines[i] = fpmDisabledPrefix + trimmed
+			}
+		}
+	}
+	if global < 0 {
+		return "", fmt.Errorf("no [global] section")
+	}
+	if len(settings) == 0 {
+		return strings.Join(lines, "\n"), nil
+	}
+
+	rendered, err := renderFPMGlobal(settings)
+	if err != nil {
+		return "", err
+	}
+	block := append([]string{fpmBlockBegin}, rendered...)
+	block = append(block, fpmBlockEnd)
+
+	result := append([]string{}, lines[:global+1]...)
+	result = append(result, block...)
+	result = append(result, lines[global+1:]...)
+	return strings.Join(result, "\n"), nil
+}
+
+// fpmDirective parses an active "key = value" line of php-fpm.conf
+func fpmDirective(line string) (string, string, bool) {
+	if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"`), true
+}
+
+// tailFile returns the last n lines of a file, reading at most its last MiB
+func tailFile(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := info.Size() - 1<<20
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(string(data))
+	if offset > 0 && len(lines) > 0 {
+		// The first line is most likely cut off
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimRight(s, "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
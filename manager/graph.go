@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Node kinds of a ServiceGraph
+const (
+	NodeUnit = "unit"
+	NodePool = "pool"
+	NodeSite = "site"
+)
+
+// GraphNode is a systemd unit, pool or site
+type GraphNode struct {
+	ID    string `json:"id"`
+	Kind  string `json:"kind"`
+	Label string `json:"label"`
+}
+
+// GraphEdge records that From depends on To
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// ServiceGraph is the dependency graph of the services this tool manages:
+// sites depend on pools and on nginx, pools on their FPM unit
+type ServiceGraph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// ServiceGraph builds the dependency graph from the database. TLS
+// certificates are not managed yet and so do not appear.
+func (pm *PoolManager) ServiceGraph() (*ServiceGraph, error) {
+	g := &ServiceGraph{}
+	seen := make(map[string]bool)
+	addNode := func(kind, name string) string {
+		id := kind + ":" + name
+		if !seen[id] {
+			seen[id] = true
+			g.Nodes = append(g.Nodes, GraphNode{ID: id, Kind: kind, Label: name})
+		}
+		return id
+	}
+
+	dbPools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	poolNodes := make(map[int64]string, len(dbPools))
+	for _, p := range dbPools {
+		unit := p.Provider + "/" + p.PHPVersion
+		if phpProvider, err := pm.providerFactory.CreateProvider(providerTypeFor(p.Provider)); err == nil {
+			unit = phpProvider.GetServiceName(p.PHPVersion) + ".service"
+		}
+		if p.Target != "" {
+			// The same unit name in another container is a different service
+			unit = p.Target + "/" + unit
+		}
+		poolID := addNode(NodePool, p.Username+" (PHP "+p.PHPVersion+")")
+		poolNodes[p.ID] = poolID
+		g.Edges = append(g.Edges, GraphEdge{From: poolID, To: addNode(NodeUnit, unit)})
+	}
+
+	sites, err := pm.db.ListSites()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sites from database: %w", err)
+	}
+	for _, s := range sites {
+		siteID := addNode(NodeSite, s.Domain)
+		g.Edges = append(g.Edges, GraphEdge{From: siteID, To: addNode(NodeUnit, "nginx.service")})
+
+		bindings, err := pm.db.ListSiteBindings(s.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list bindings of %s: %w", s.Domain, err)
+		}
+		for _, b := range bindings {
+			if poolID, ok := poolNodes[b.PoolID]; ok {
+				g.Edges = append(g.Edges, GraphEdge{From: siteID, To: poolID})
+			}
+		}
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return g, nil
+}
+
+// Dependents returns the nodes that directly or indirectly depend on id,
+// i.e. what stops working when id is stopped
+func (g *ServiceGraph) Dependents(id string) []string {
+	var result []string
+	visited := map[string]bool{id: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, e := range g.Edges {
+			if e.To == current && !visited[e.From] {
+				visited[e.From] = true
+				result = append(result, e.From)
+				queue = append(queue, e.From)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Text renders the graph as a blast-radius report: every unit followed by
+// the tree of pools and sites that depend on it
+func (g *ServiceGraph) Text() string {
+	labels := make(map[string]string, len(g.Nodes))
+	for _, n := range g.Nodes {
+		labels[n.ID] = n.Label
+	}
+
+	var b strings.Builder
+	var walk func(id, indent string, visited map[string]bool)
+	walk = func(id, indent string, visited map[string]bool) {
+		for _, e := range g.Edges {
+			if e.To != id || visited[e.From] {
+				continue
+			}
+			visited[e.From] = true
+			fmt.Fprintf(&b, "%s└─ %s %s\n", indent, strings.SplitN(e.From, ":", 2)[0], labels[e.From])
+			walk(e.From, indent+"   ", visited)
+		}
+	}
+
+	for _, n := range g.Nodes {
+		if n.Kind != NodeUnit {
+			continue
+		}
+		fmt.Fprintf(&b, "%s (%d dependents)\n", n.Label, len(g.Dependents(n.ID)))
+		walk(n.ID, "  ", map[string]bool{n.ID: true})
+	}
+	return b.String()
+}
+
+// Dot renders the graph in Graphviz format with edges pointing from a node
+// to what it depends on
+func (g *ServiceGraph) Dot() string {
+	shapes := map[string]string{NodeUnit: "box", NodePool: "ellipse", NodeSite: "note"}
+
+	var b strings.Builder
+	b.WriteString("digraph services {\n\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&b, "\t%q [label=%q, shape=%s];\n", n.ID, n.Label, shapes[n.Kind])
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "\t%q -> %q;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
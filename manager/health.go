@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"time"
+
+	"lightweight-php/provider"
+)
+
+// ProviderHealth is the outcome of checking a provider's package
+// repositories. OK is false when any check is an error.
+type ProviderHealth struct {
+	Provider  string                 `json:"provider"`
+	CheckedAt time.Time              `json:"checked_at"`
+	OK        bool                   `json:"ok"`
+	Errors    int                    `json:"errors"`
+	Warnings  int                    `json:"warnings"`
+	Checks    []provider.HealthCheck `json:"checks"`
+}
+
+// ProviderHealth checks that the repositories a provider installs from
+// can be reached, that their signing keys are valid and that the package
+// manager's cache of them is recent. Providers that use no third-party
+// repository have nothing to check.
+func (pm *PackageManager) ProviderHealth(providerType provider.ProviderType) (*ProviderHealth, error) {
+	phpProvider, err := pm.GetProviderByType(providerType)
+	if err != nil {
+		return nil, err
+	}
+	h := &ProviderHealth{Provider: string(providerType), CheckedAt: time.Now().UTC(), Checks: []provider.HealthCheck{}}
+	if checker, ok := phpProvider.(provider.HealthChecker); ok {
+		h.Checks = checker.CheckHealth()
+	}
+	for _, c := range h.Checks {
+		switch c.Status {
+		case provider.HealthError:
+			h.Errors++
+		case provider.HealthWarning:
+			h.Warnings++
+		}
+	}
+	h.OK = h.Errors == 0
+	return h, nil
+}
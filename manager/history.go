@@ -0,0 +1,269 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"lightweight-php/audit"
+	"lightweight-php/db"
+)
+
+// configHistoryKeep is how many earlier revisions are kept per pool
+const configHistoryKeep = 50
+
+// ErrRevisionNotFound is returned for a revision the history does not hold
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ConfigRevision is one revision of a pool's configuration. ChangedAt and
+// Actor tell who made the change that produced it; they are empty for the
+// settings the pool was created with.
+type ConfigRevision struct {
+	Revision  int64                  `json:"revision"`
+	Current   bool                   `json:"current"`
+	ChangedAt *time.Time             `json:"changed_at,omitempty"`
+	Actor     string                 `json:"actor,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Settings  map[string]interface{} `json:"settings"`
+}
+
+// recordConfigVersion saves the pool file and settings that a change at
+// revision is about to replace, with the caller making the change
+func (pm *PoolManager) recordConfigVersion(dbPool *db.Pool, revision int64, settings map[string]interface{}, config []byte) error {
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	caller := audit.FromContext(pm.context())
+	if err := pm.db.SavePoolConfigVersion(&db.PoolConfigVersion{
+		PoolID:    dbPool.ID,
+		Revision:  revision,
+		Settings:  string(encoded),
+		Config:    string(config),
+		Actor:     caller.Actor,
+		RequestID: caller.RequestID,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return err
+	}
+	return pm.db.PrunePoolConfigVersions(dbPool.ID, revision-configHistoryKeep+1)
+}
+
+// PoolHistory returns the revisions of a user's pool configuration, newest
+// first, starting with the current one
+func (pm *PoolManager) PoolHistory(username string) ([]ConfigRevision, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	current, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := pm.db.ListPoolConfigVersions(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config history: %w", err)
+	}
+	// The version saved at revision N records the change to N+1
+	changes := make(map[int64]db.PoolConfigVersion, len(versions))
+	for _, v := range versions {
+		changes[v.Revision+1] = v
+	}
+	describe := func(r *ConfigRevision) {
+		if v, ok := changes[r.Revision]; ok {
+			changedAt := v.CreatedAt
+			r.ChangedAt = &changedAt
+			r.Actor = v.Actor
+			r.RequestID = v.RequestID
+		}
+	}
+
+	history := make([]ConfigRevision, 0, len(versions)+1)
+	head := ConfigRevision{Revision: current.Revision, Current: true, Settings: current.Settings}
+	describe(&head)
+	history = append(history, head)
+	for _, v := range versions {
+		if v.Revision >= current.Revision {
+			continue
+		}
+		r := ConfigRevision{Revision: v.Revision, Settings: make(map[string]interface{})}
+		if err := json.Unmarshal([]byte(v.Settings), &r.Settings); err != nil {
+			return nil, fmt.Errorf("failed to decode settings of revision %d: %w", v.Revision, err)
+		}
+		describe(&r)
+		history = append(history, r)
+	}
+	return history, nil
+}
+
+// DiffPoolConfig returns a unified diff from the pool file at revision to
+// the pool file as it is now
+func (pm *PoolManager) DiffPoolConfig(username string, revision int64) (string, error) {
+	dbPool, version, err := pm.configVersion(username, revision)
+	if err != nil {
+		return "", err
+	}
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return "", err
+	}
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(hostConfigPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pool config: %w", err)
+	}
+	return unifiedDiff(
+		fmt.Sprintf("%s (revision %d)", dbPool.ConfigPath, revision), version.Config,
+		fmt.Sprintf("%s (current)", dbPool.ConfigPath), string(content),
+	), nil
+}
+
+// RollbackPoolConfig restores the settings a pool had at revision. The
+// pool file is rendered from them again, so the rollback is itself a new
+// revision that can be rolled back. expected is checked like
+// UpdatePoolConfigIfMatch's revision.
+func (pm *PoolManager) RollbackPoolConfig(username string, revision, expected int64) (int64, error) {
+	_, version, err := pm.configVersion(username, revision)
+	if err != nil {
+		return 0, err
+	}
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(version.Settings), &settings); err != nil {
+		return 0, fmt.Errorf("failed to decode settings of revision %d: %w", revision, err)
+	}
+	return pm.updatePoolConfig(username, expected, func(map[string]interface{}) map[string]interface{} {
+		return settings
+	})
+}
+
+func (pm *PoolManager) configVersion(username string, revision int64) (*db.Pool, *db.PoolConfigVersion, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	version, err := pm.db.GetPoolConfigVersion(dbPool.ID, revision)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get config history: %w", err)
+	}
+	if version == nil {
+		return nil, nil, fmt.Errorf("%w: %s has no earlier revision %d", ErrRevisionNotFound, username, revision)
+	}
+	return dbPool, version, nil
+}
+
+// diffContext is the number of unchanged lines shown around a change
+const diffContext = 3
+
+// unifiedDiff returns the differences between two texts in unified diff
+// format, or "" if they are equal
+func unifiedDiff(fromName, from, toName, to string) string {
+	a, b := splitLines(from), splitLines(to)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type edit struct {
+		op   byte
+		line string
+		// ai and bi are the line indexes before the edit
+		ai, bi int
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of the hunk around it
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		// Changes closer than twice the context share a hunk
+		last := first
+		for k := first; k < len(edits) && k-last <= 2*diffContext; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+		end := last + 1 + diffContext
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		var aCount, bCount int
+		for _, e := range edits[from:end] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		aStart, bStart := edits[from].ai+1, edits[from].bi+1
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, e := range edits[from:end] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			out.WriteByte('\n')
+		}
+		start = end
+	}
+	return out.String()
+}
@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+)
+
+// DrainedMarkerPath exists while the host is drained; new pools are refused
+const DrainedMarkerPath = "/var/lib/lightweight-php/drained"
+
+// HostDrainState is stored in the drained marker file
+type HostDrainState struct {
+	DrainedAt time.Time `json:"drained_at"`
+	Targets   []string  `json:"targets"`
+	Migrated  int       `json:"migrated"`
+}
+
+// EvacuationOptions configures a host evacuation
+type EvacuationOptions struct {
+	Targets      []string
+	SSHUser      string
+	APIKey       string
+	Throttle     time.Duration
+	BandwidthKBs int
+}
+
+// EvacuationProgress is reported before each account step
+type EvacuationProgress struct {
+	Index    int
+	Total    int
+	Username string
+	Target   string
+	Step     string
+}
+
+// EvacuateHost migrates every active account to the given targets
+// (round-robin), pausing between accounts, and marks the host as drained
+// once all accounts have moved. Already migrated pools are skipped and
+// interrupted migrations resume towards their target, so an interrupted
+// evacuation can be re-run.
+func (pm *PoolManager) EvacuateHost(opts EvacuationOptions, progress func(EvacuationProgress)) error {
+	if len(opts.Targets) == 0 {
+		return fmt.Errorf("at least one target host is required")
+	}
+
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return fmt.Errorf("failed to list pools from database: %w", err)
+	}
+
+	pending := make([]string, 0, len(pools))
+	for _, p := range pools {
+		if p.Status != db.PoolMigrated && !containsString(pending, p.Username) {
+			pending = append(pending, p.Username)
+		}
+	}
+
+	targets, err := evacuationTargets(pending, opts.Targets)
+	if err != nil {
+		return err
+	}
+
+	for i, username := range pending {
+		target := targets[i]
+		migrateOpts := MigrationOptions{
+			TargetHost:   target,
+			SSHUser:      opts.SSHUser,
+			APIKey:       opts.APIKey,
+			BandwidthKBs: opts.BandwidthKBs,
+		}
+
+		err := pm.MigrateAccount(username, migrateOpts, func(step string) {
+			if progress != nil {
+				progress(EvacuationProgress{Index: i + 1, Total: len(pending), Username: username, Target: target, Step: step})
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to migrate %s to %s: %w", username, target, err)
+		}
+
+		if opts.Throttle > 0 && i < len(pending)-1 {
+			time.Sleep(opts.Throttle)
+		}
+	}
+
+	return MarkHostDrained(&HostDrainState{
+		DrainedAt: time.Now().UTC(),
+		Targets:   opts.Targets,
+		Migrated:  len(pending),
+	})
+}
+
+// evacuationTargets picks the target of each pending account: the one its
+// interrupted migration was heading to, if still among targets, so the
+// completed steps carry over; else the next target round-robin
+func evacuationTargets(pending, targets []string) ([]string, error) {
+	hosts := make([]string, len(targets))
+	for i, target := range targets {
+		host, err := config.NormalizeHost(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target host %s: %w", target, err)
+		}
+		hosts[i] = host
+	}
+
+	assigned := make([]string, len(pending))
+	next := 0
+	for i, username := range pending {
+		saved, err := readMigrationState(username)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil && containsString(hosts, saved.TargetHost) {
+			assigned[i] = saved.TargetHost
+			continue
+		}
+		assigned[i] = hosts[next%len(hosts)]
+		next++
+	}
+	return assigned, nil
+}
+
+// MarkHostDrained writes the drained marker
+func MarkHostDrained(state *HostDrainState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode drain state: %w", err)
+	}
+	if err := os.WriteFile(hostPath(DrainedMarkerPath), content, 0644); err != nil {
+		return fmt.Errorf("failed to write drained marker: %w", err)
+	}
+	return nil
+}
+
+// GetHostDrainState returns the drain state, or nil if the host is in service
+func GetHostDrainState() (*HostDrainState, error) {
+	content, err := os.ReadFile(hostPath(DrainedMarkerPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read drained marker: %w", err)
+	}
+
+	var state HostDrainState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode drained marker: %w", err)
+	}
+	return &state, nil
+}
+
+// UndrainHost puts the host back into service
+func UndrainHost() error {
+	if err := os.Remove(hostPath(DrainedMarkerPath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove drained marker: %w", err)
+	}
+	return nil
+}
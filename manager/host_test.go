@@ -0,0 +1,64 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+
+	"lightweight-php/db"
+)
+
+func TestEvacuationResumesInterruptedMigrations(t *testing.T) {
+	pm, _ := newTestPoolManager(t)
+	pending := []string{"anna", "bob", "carol", "dave"}
+	for _, username := range pending {
+		if err := pm.CreatePool(username, "8.3", "remi"); err != nil {
+			t.Fatalf("CreatePool %s: %v", username, err)
+		}
+	}
+	targets := []string{"web1", "web2"}
+
+	got, err := evacuationTargets(pending, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web1", "web2", "web1", "web2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first run: targets %v, want %v", got, want)
+	}
+
+	// The run stops after anna moved and while bob was being copied
+	if err := saveMigrationState(&MigrationState{Username: "anna", TargetHost: "web1", CompletedSteps: migrationSteps}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.db.UpdatePoolStatus("anna", db.PoolMigrated); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveMigrationState(&MigrationState{Username: "bob", TargetHost: "web2", CompletedSteps: []string{MigrationStepRsync}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The re-run skips anna, resumes bob towards web2 and spreads the others
+	// as if bob had not been assigned yet
+	got, err = evacuationTargets(pending[1:], targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web2", "web1", "web2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("re-run: targets %v, want %v", got, want)
+	}
+	state, err := loadMigrationState("bob", got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.done(MigrationStepRsync) {
+		t.Errorf("bob's copy to %s starts over", got[0])
+	}
+
+	// A target dropped from the list is not resumed
+	got, err = evacuationTargets([]string{"bob"}, []string{"web3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"web3"}) {
+		t.Errorf("with web2 dropped: targets %v, want [web3]", got)
+	}
+}
@@ -0,0 +1,309 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+)
+
+// ErrNotDockerPool is returned for image operations on pools that do not
+// run in a container
+var ErrNotDockerPool = errors.New("pool does not use the docker provider")
+
+// imageExtensionPattern matches the names accepted in image_extensions
+var imageExtensionPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// builtinExtensions are compiled into the official php images
+var builtinExtensions = map[string]bool{
+	"ctype": true, "curl": true, "date": true, "dom": true, "fileinfo": true,
+	"filter": true, "hash": true, "iconv": true, "json": true, "libxml": true,
+	"mbstring": true, "mysqlnd": true, "openssl": true, "pcre": true, "pdo": true,
+	"pdo_sqlite": true, "phar": true, "posix": true, "readline": true,
+	"reflection": true, "session": true, "simplexml": true, "sodium": true,
+	"spl": true, "sqlite3": true, "standard": true, "tokenizer": true, "xml": true,
+	"xmlreader": true, "xmlwriter": true, "zlib": true,
+}
+
+// coreExtensions are built from the PHP source in the image, mapped to the
+// Debian packages they need
+var coreExtensions = map[string][]string{
+	"bcmath": nil, "bz2": {"libbz2-dev"}, "calendar": nil, "exif": nil,
+	"ffi": {"libffi-dev"}, "gd": {"libpng-dev", "libjpeg62-turbo-dev", "libfreetype6-dev"},
+	"gettext": nil, "gmp": {"libgmp-dev"}, "intl": {"libicu-dev"},
+	"ldap": {"libldap2-dev"}, "mysqli": nil, "opcache": nil, "pcntl": nil,
+	"pdo_mysql": nil, "pdo_pgsql": {"libpq-dev"}, "pgsql": {"libpq-dev"},
+	"shmop": nil, "soap": {"libxml2-dev"}, "sockets": nil, "sysvmsg": nil,
+	"sysvsem": nil, "sysvshm": nil, "tidy": {"libtidy-dev"}, "xsl": {"libxslt1-dev"},
+	"zip": {"libzip-dev"},
+}
+
+// coreExtensionConfigure holds docker-php-ext-configure arguments
+var coreExtensionConfigure = map[string]string{
+	"gd": "--with-freetype --with-jpeg",
+}
+
+// peclPackages are the Debian packages of PECL extensions that need more
+// than the image's build tools; other names are installed from PECL as is
+var peclPackages = map[string][]string{
+	"imagick":   {"libmagickwand-dev"},
+	"memcached": {"libmemcached-dev", "zlib1g-dev", "libssl-dev"},
+	"mongodb":   {"libssl-dev"},
+}
+
+// imageIniSettings are the pool settings written to the image's php.ini,
+// so that PHP run inside the container outside the pool has them too
+var imageIniSettings = []struct{ setting, directive string }{
+	{"memory_limit", "memory_limit"},
+	{"upload_max_filesize", "upload_max_filesize"},
+	{"post_max_size", "post_max_size"},
+	{"max_execution_time", "max_execution_time"},
+	{"date_timezone", "date.timezone"},
+}
+
+// ImageBuild is the outcome of rebuilding a Docker pool's image
+type ImageBuild struct {
+	Username string `json:"username"`
+	Image    string `json:"image"`
+	// Platform is the target's Docker platform, e.g. linux/arm64
+	Platform string `json:"platform"`
+	// Cached is set when an image with the same Dockerfile existed
+	Cached    bool   `json:"cached"`
+	Container string `json:"container"`
+}
+
+// imageExtensions returns the image_extensions setting of a pool
+func imageExtensions(settings map[string]interface{}) ([]string, error) {
+	value, ok := settings["image_extensions"]
+	if !ok {
+		return nil, nil
+	}
+
+	var names []string
+	switch v := value.(type) {
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("image_extensions must list extension names")
+			}
+			names = append(names, strings.TrimSpace(name))
+		}
+	default:
+		return nil, fmt.Errorf("image_extensions must be a list of extension names")
+	}
+
+	for i, name := range names {
+		names[i] = strings.ToLower(name)
+		if !imageExtensionPattern.MatchString(names[i]) {
+			return nil, fmt.Errorf("image_extensions: invalid extension name %q", name)
+		}
+	}
+	return names, nil
+}
+
+// poolContainer is the name of a Docker pool's container
+func poolContainer(username string) string {
+	return "lwp-" + username
+}
+
+// RebuildPool builds the image of a Docker pool from its settings and
+// replaces the pool's container with one running it. Images are tagged
+// with a hash of their Dockerfile, so an unchanged pool reuses its image
+// and only the container is recreated.
+func (pm *PoolManager) RebuildPool(username string) (_ *ImageBuild, err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.rebuild", username, &err)
+	l, err := pm.acquire(lock.PoolKey(username), "rebuild pool "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if dbPool.Provider != string(provider.ProviderDocker) {
+		return nil, fmt.Errorf("%w: %s uses %s", ErrNotDockerPool, username, dbPool.Provider)
+	}
+	if dbPool.Status == db.PoolSuspended {
+		return nil, fmt.Errorf("%w: %s", ErrPoolSuspended, username)
+	}
+	t, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	phpProvider, err := factory.CreateProvider(provider.ProviderDocker)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+	settings, err := pm.poolSettings(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	return deployPoolImage(t, phpProvider, dbPool, settings)
+}
+
+// deployPoolImage builds the image of a Docker pool with the given
+// settings, unless it exists, and recreates the pool's container
+func deployPoolImage(t target.Target, phpProvider provider.PHPProvider, dbPool *db.Pool, settings map[string]interface{}) (*ImageBuild, error) {
+	u, err := t.LookupUser(dbPool.Username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, dbPool.Username, err)
+	}
+	data, err := dockerfileData(t, dbPool, settings)
+	if err != nil {
+		return nil, err
+	}
+	templateContent, err := templates.LoadTemplate("Dockerfile.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+	dockerfile, err := templates.RenderDockerfile(templateContent, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+
+	arch, err := targetArch(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// The platform is part of the hash, as the same Dockerfile builds
+	// different images on each
+	sum := sha256.Sum256([]byte(arch.Platform() + "\n" + dockerfile))
+	build := &ImageBuild{
+		Username:  dbPool.Username,
+		Image:     "lightweight-php/pool-" + dbPool.Username + ":" + hex.EncodeToString(sum[:])[:12],
+		Platform:  arch.Platform(),
+		Container: poolContainer(dbPool.Username),
+	}
+	if err := t.Command("docker", "image", "inspect", build.Image).Run(); err == nil {
+		build.Cached = true
+	} else {
+		cmd := t.Command("docker", "build", "--platform", build.Platform, "--label", "lightweight-php.pool="+dbPool.Username, "-t", build.Image, "-")
+		cmd.Stdin = strings.NewReader(dockerfile)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to build image %s: %w\n%s", build.Image, err, lastLines(string(output), 20))
+		}
+	}
+
+	args := poolContainerArgs(t, phpProvider, dbPool, u.HomeDir, build)
+	// A missing container is not an error; docker rm -f only reports it
+	t.Command("docker", "rm", "-f", build.Container).Run()
+	if output, err := t.Command("docker", args...).CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to start container %s: %w\n%s", build.Container, err, lastLines(string(output), 20))
+	}
+	return build, nil
+}
+
+// dockerfileData builds the Dockerfile template data of a pool
+func dockerfileData(t target.Target, dbPool *db.Pool, settings map[string]interface{}) (*templates.DockerfileData, error) {
+	u, err := t.LookupUser(dbPool.Username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, dbPool.Username, err)
+	}
+	group := dbPool.Username
+	if name, err := t.LookupGroupName(u.Gid); err == nil {
+		group = name
+	}
+	names, err := imageExtensions(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &templates.DockerfileData{
+		BaseImage:  "php:" + dbPool.PHPVersion + "-fpm",
+		Username:   dbPool.Username,
+		Group:      group,
+		UID:        u.Uid,
+		GID:        u.Gid,
+		HomeDir:    u.HomeDir,
+		PHPVersion: dbPool.PHPVersion,
+	}
+	packages := map[string]bool{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if builtinExtensions[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if deps, ok := coreExtensions[name]; ok {
+			data.Extensions = append(data.Extensions, templates.ImageExtension{Name: name, Configure: coreExtensionConfigure[name]})
+			for _, p := range deps {
+				packages[p] = true
+			}
+			continue
+		}
+		data.PECLExtensions = append(data.PECLExtensions, name)
+		for _, p := range peclPackages[name] {
+			packages[p] = true
+		}
+	}
+	// The Dockerfile, and so the image's tag, must not depend on the
+	// order of the setting
+	sort.Slice(data.Extensions, func(i, j int) bool { return data.Extensions[i].Name < data.Extensions[j].Name })
+	sort.Strings(data.PECLExtensions)
+	for p := range packages {
+		data.Packages = append(data.Packages, p)
+	}
+	sort.Strings(data.Packages)
+
+	for _, s := range imageIniSettings {
+		value, ok := settingString(settings[s.setting])
+		// Values are written in single quotes
+		if !ok || value == "" || strings.ContainsAny(value, "'\n") {
+			continue
+		}
+		data.Ini = append(data.Ini, templates.IniSetting{Name: s.directive, Value: value})
+	}
+	return data, nil
+}
+
+// poolContainerArgs returns the docker run arguments of a pool's
+// container. The pool's file, its socket directory, the user's home and
+// the pool's session and tmp directories are mounted at the paths the
+// pool file uses, and the version's conf.d, when there is one, is
+// scanned after the image's. Docker runs inside the target, so paths are
+// the target's.
+func poolContainerArgs(t target.Target, phpProvider provider.PHPProvider, dbPool *db.Pool, homeDir string, build *ImageBuild) []string {
+	args := []string{"run", "-d", "--name", build.Container, "--platform", build.Platform, "--restart", "unless-stopped",
+		"--label", "lightweight-php.pool=" + dbPool.Username,
+		"-v", dbPool.ConfigPath + ":/usr/local/etc/php-fpm.d/zz-" + dbPool.Username + ".conf:ro"}
+	if addr, err := ParseListen(dbPool.SocketPath); err == nil && addr.IsUnix() {
+		socketDir := filepath.Dir(addr.Path)
+		args = append(args, "-v", socketDir+":"+socketDir)
+	}
+	args = append(args, "-v", homeDir+":"+homeDir)
+	for _, dir := range poolDirs(dbPool.Username) {
+		args = append(args, "-v", dir+":"+dir)
+	}
+	confDir := phpProvider.GetConfDir(dbPool.PHPVersion)
+	if hostConfDir, err := t.Path(confDir); err == nil {
+		if _, err := os.Stat(hostConfDir); err == nil {
+			args = append(args, "-v", confDir+":/usr/local/etc/php/conf.d/host:ro",
+				"-e", "PHP_INI_SCAN_DIR=/usr/local/etc/php/conf.d:/usr/local/etc/php/conf.d/host")
+		}
+	}
+	return append(args, build.Image)
+}
@@ -0,0 +1,231 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/provider"
+)
+
+const (
+	// installLogKeep is how many install logs are kept per provider
+	installLogKeep = 50
+	// installLogMaxOutput bounds a stored transcript; the end is kept since
+	// that is where package managers report what went wrong
+	installLogMaxOutput = 1 << 20
+	// installLogFlushInterval is how often the output of a running install
+	// is saved, so its log can be followed while it runs
+	installLogFlushInterval = 2 * time.Second
+)
+
+// ErrInstallLogNotFound is returned for an unknown install log
+var ErrInstallLogNotFound = errors.New("install log not found")
+
+// InstallLog is the recorded transcript of a provider install: repository
+// setup, package installation and service start
+type InstallLog struct {
+	ID         int64      `json:"id"`
+	Provider   string     `json:"provider"`
+	Version    string     `json:"version"`
+	Operation  string     `json:"operation"`
+	Target     string     `json:"target,omitempty"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+	Output     string     `json:"output,omitempty"`
+	// Rollback lists the changes undone after the install failed
+	Rollback []provider.UndoResult `json:"rollback,omitempty"`
+}
+
+// InstallProgress receives the progress of an install as it runs. Both
+// functions are optional.
+type InstallProgress struct {
+	// Started is called with the install log id once the install is
+	// recorded
+	Started func(id int64)
+	// Line is called with every line the install's commands print
+	Line func(line string)
+}
+
+// recordInstall runs install on a provider whose commands are copied to a
+// transcript, and stores the transcript and outcome in the install history.
+// Output lines are published as they arrive and saved to the running log
+// every installLogFlushInterval. Failing to record never fails the install
+// itself.
+func recordInstall(database *db.Database, factory *provider.ProviderFactory, providerType provider.ProviderType, version, operation string, progress InstallProgress, install func(provider.PHPProvider) error) error {
+	targetName := ""
+	if t := factory.Target(); !t.IsHost() {
+		targetName = t.String()
+	}
+	id, logErr := database.CreateInstallLog(string(providerType), version, operation, targetName, time.Now().UTC())
+	if logErr == nil && progress.Started != nil {
+		progress.Started(id)
+	}
+
+	transcript := &lineWriter{}
+	lastFlush := time.Now()
+	transcript.line = func(line string) {
+		data := map[string]interface{}{"version": version, "provider": string(providerType), "operation": operation, "line": line}
+		if logErr == nil {
+			data["install_id"] = id
+		}
+		events.Publish(events.PHPInstallOutput, "", data)
+		if progress.Line != nil {
+			progress.Line(line)
+		}
+		if logErr == nil && time.Since(lastFlush) >= installLogFlushInterval {
+			lastFlush = time.Now()
+			database.UpdateInstallLogOutput(id, truncateInstallOutput(transcript.bytes()))
+		}
+	}
+
+	phpProvider, err := factory.WithTranscript(transcript).CreateProvider(providerType)
+	if err != nil {
+		err = fmt.Errorf("failed to create provider: %w", err)
+	} else {
+		err = install(phpProvider)
+		// Also after a failure, which may have left packages behind
+		provider.InvalidateInstalled()
+	}
+	transcript.flush()
+
+	if logErr == nil {
+		status, errMsg, rollback := db.InstallSuccess, "", ""
+		if err != nil {
+			status, errMsg = db.InstallFailed, err.Error()
+		}
+		var installErr *InstallError
+		if errors.As(err, &installErr) {
+			if encoded, jerr := json.Marshal(installErr.RolledBack); jerr == nil {
+				rollback = string(encoded)
+			}
+		}
+		if database.FinishInstallLog(id, status, truncateInstallOutput(transcript.bytes()), errMsg, rollback, time.Now().UTC()) == nil {
+			database.PruneInstallLogs(string(providerType), installLogKeep)
+		}
+	}
+	return err
+}
+
+// truncateInstallOutput bounds a transcript to installLogMaxOutput
+func truncateInstallOutput(output []byte) string {
+	if len(output) > installLogMaxOutput {
+		output = append([]byte("[output truncated]\n"), output[len(output)-installLogMaxOutput:]...)
+	}
+	return string(output)
+}
+
+// lineWriter keeps everything written to it and calls line for each
+// complete line. A command's stdout and stderr are copied concurrently, and
+// package managers redraw progress with \r, which also ends a line here.
+type lineWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	partial []byte
+	// emitMu runs line calls one at a time, outside mu so they may read
+	// the transcript
+	emitMu sync.Mutex
+	line   func(string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.buf.Write(p)
+	w.partial = append(w.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	w.mu.Unlock()
+	w.emit(lines)
+	return len(p), nil
+}
+
+// flush reports a last line that was not terminated
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	last := string(w.partial)
+	w.partial = nil
+	w.mu.Unlock()
+	w.emit([]string{last})
+}
+
+func (w *lineWriter) emit(lines []string) {
+	w.emitMu.Lock()
+	defer w.emitMu.Unlock()
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" && w.line != nil {
+			w.line(line)
+		}
+	}
+}
+
+// bytes returns a copy of everything written so far
+func (w *lineWriter) bytes() []byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]byte(nil), w.buf.Bytes()...)
+}
+
+// ListInstallLogs returns a provider's install history, newest first,
+// without the transcripts
+func (pm *PackageManager) ListInstallLogs(providerType provider.ProviderType, limit int) ([]InstallLog, error) {
+	records, err := pm.db.ListInstallLogs(string(providerType), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list install logs: %w", err)
+	}
+	logs := make([]InstallLog, 0, len(records))
+	for i := range records {
+		logs = append(logs, toInstallLog(&records[i]))
+	}
+	return logs, nil
+}
+
+// GetInstallLog returns one install of a provider with its transcript
+func (pm *PackageManager) GetInstallLog(providerType provider.ProviderType, id int64) (*InstallLog, error) {
+	record, err := pm.db.GetInstallLog(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read install log: %w", err)
+	}
+	if record == nil || record.Provider != string(providerType) {
+		return nil, fmt.Errorf("%w: %s #%d", ErrInstallLogNotFound, providerType, id)
+	}
+	l := toInstallLog(record)
+	return &l, nil
+}
+
+func toInstallLog(r *db.InstallLog) InstallLog {
+	l := InstallLog{
+		ID:         r.ID,
+		Provider:   r.Provider,
+		Version:    r.Version,
+		Operation:  r.Operation,
+		Target:     r.Target,
+		Status:     r.Status,
+		Error:      r.Error,
+		StartedAt:  r.StartedAt,
+		FinishedAt: r.FinishedAt,
+		Output:     r.Output,
+	}
+	if r.FinishedAt != nil {
+		l.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	}
+	if r.Rollback != "" {
+		json.Unmarshal([]byte(r.Rollback), &l.Rollback)
+	}
+	return l
+}
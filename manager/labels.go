@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// labelKeyPattern allows keys such as "env", "team" or "example.com/tier"
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]{0,61}[a-z0-9])?$`)
+
+const maxLabelValueLength = 255
+
+// PoolSelector picks pools by labels, PHP version, provider and tenant; all given
+// criteria must match. An empty selector matches nothing unless All is set,
+// so a missing field cannot turn into a fleet-wide change.
+type PoolSelector struct {
+	Labels     map[string]string `json:"labels,omitempty"`
+	PHPVersion string            `json:"php_version,omitempty"`
+	Provider   string            `json:"provider,omitempty"`
+	Tenant     string            `json:"tenant,omitempty"`
+	All        bool              `json:"all,omitempty"`
+}
+
+// IsEmpty reports whether the selector has no criteria
+func (s PoolSelector) IsEmpty() bool {
+	return len(s.Labels) == 0 && s.PHPVersion == "" && s.Provider == "" && s.Tenant == ""
+}
+
+// Matches reports whether a pool satisfies every criterion of the selector
+func (s PoolSelector) Matches(pool Pool) bool {
+	if s.IsEmpty() {
+		return s.All
+	}
+	if s.PHPVersion != "" && pool.PHPVersion != s.PHPVersion {
+		return false
+	}
+	if s.Provider != "" && pool.Provider != s.Provider {
+		return false
+	}
+	if s.Tenant != "" && pool.Tenant != s.Tenant {
+		return false
+	}
+	for key, value := range s.Labels {
+		if current, ok := pool.Labels[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateLabel checks a label key and value
+func ValidateLabel(key, value string) error {
+	if !labelKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid label key %q: use lowercase letters, digits, '.', '_', '-' and '/'", key)
+	}
+	if len(value) > maxLabelValueLength {
+		return fmt.Errorf("label %s: value is longer than %d characters", key, maxLabelValueLength)
+	}
+	return nil
+}
+
+// GetPoolLabels returns the labels of a user's pool
+func (pm *PoolManager) GetPoolLabels(username string) (map[string]string, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	return pm.db.GetPoolLabels(dbPool.ID)
+}
+
+// UpdatePoolLabels sets and removes labels of a user's pool and returns the
+// resulting labels. Labels do not touch the pool configuration.
+func (pm *PoolManager) UpdatePoolLabels(username string, set map[string]string, remove []string) (map[string]string, error) {
+	for key, value := range set {
+		if err := ValidateLabel(key, value); err != nil {
+			return nil, err
+		}
+	}
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if err := pm.db.UpdatePoolLabels(dbPool.ID, set, remove); err != nil {
+		return nil, fmt.Errorf("failed to update labels: %w", err)
+	}
+	return pm.db.GetPoolLabels(dbPool.ID)
+}
+
+// SelectPools returns the pools matching a selector, ordered by username
+func (pm *PoolManager) SelectPools(selector PoolSelector) ([]Pool, error) {
+	pools, err := pm.ListPools()
+	if err != nil {
+		return nil, err
+	}
+	var selected []Pool
+	for _, pool := range pools {
+		if selector.Matches(pool) {
+			selected = append(selected, pool)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].User < selected[j].User })
+	return selected, nil
+}
+
+// PatchPoolsConfig merges settings into every pool matching the selector as
+// one batch: each affected FPM service is reloaded once, and if a pool fails
+// the pools updated before it are restored. No matching pool is not an error.
+func (pm *PoolManager) PatchPoolsConfig(selector PoolSelector, settings map[string]interface{}) ([]BatchResult, error) {
+	if selector.IsEmpty() && !selector.All {
+		return nil, fmt.Errorf("%w: the selector is empty; select pools by label, php_version, provider or tenant, or set all", ErrBatchRejected)
+	}
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("%w: no settings provided", ErrBatchRejected)
+	}
+	pools, err := pm.SelectPools(selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(pools) == 0 {
+		return []BatchResult{}, nil
+	}
+
+	ops := make([]BatchOperation, 0, len(pools))
+	for _, pool := range pools {
+		ops = append(ops, BatchOperation{Op: BatchUpdate, Username: pool.User, Settings: settings})
+	}
+	return pm.ApplyBatch(ops)
+}
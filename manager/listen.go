@@ -0,0 +1,259 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/fcgi"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+)
+
+// Values of the listen_type pool setting
+const (
+	ListenSocket = "socket"
+	ListenTCP    = "tcp"
+)
+
+// ListenAddress is a parsed PHP-FPM listen value: a unix socket path, a
+// bare port (all addresses), "ip:port" or "[ipv6]:port"
+type ListenAddress struct {
+	Path string
+	Host string
+	Port int
+}
+
+// ParseListen parses a PHP-FPM listen value. Unbracketed IPv6 literals are
+// rejected because FPM cannot tell the port apart from the address.
+func ParseListen(listen string) (*ListenAddress, error) {
+	listen = strings.TrimSpace(listen)
+	if listen == "" {
+		return nil, fmt.Errorf("listen address is empty")
+	}
+	if strings.HasPrefix(listen, "/") {
+		return &ListenAddress{Path: listen}, nil
+	}
+
+	host, portStr := "", listen
+	if strings.Contains(listen, ":") {
+		var err error
+		host, portStr, err = net.SplitHostPort(listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen address %s (IPv6 addresses must be written as [addr]:port): %w", listen, err)
+		}
+		if strings.Contains(host, ":") && net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("invalid IPv6 address in listen %s", listen)
+		}
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port in listen address %s", listen)
+	}
+	return &ListenAddress{Host: host, Port: port}, nil
+}
+
+func (l *ListenAddress) IsUnix() bool {
+	return l.Path != ""
+}
+
+// String formats the address the way PHP-FPM expects it
+func (l *ListenAddress) String() string {
+	if l.IsUnix() {
+		return l.Path
+	}
+	if l.Host == "" {
+		return strconv.Itoa(l.Port)
+	}
+	return net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+}
+
+// wildcard reports whether FPM accepts connections on every address
+func (l *ListenAddress) wildcard() bool {
+	if l.Host == "" {
+		return true
+	}
+	ip := net.ParseIP(l.Host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// DialAddresses returns the addresses to try when connecting locally. Pools
+// listening on all addresses are reached through the configured loopbacks.
+func (l *ListenAddress) DialAddresses() []string {
+	if l.IsUnix() {
+		return []string{l.Path}
+	}
+	if !l.wildcard() {
+		return []string{net.JoinHostPort(l.Host, strconv.Itoa(l.Port))}
+	}
+
+	addrs := make([]string, 0)
+	for _, lo := range config.Get().Network.LoopbackAddresses {
+		addrs = append(addrs, net.JoinHostPort(strings.Trim(lo, "[]"), strconv.Itoa(l.Port)))
+	}
+	return addrs
+}
+
+func (l *ListenAddress) network() string {
+	if l.IsUnix() {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// fastCGIPass formats a pool listen value for nginx's fastcgi_pass
+func fastCGIPass(listen string) string {
+	addr, err := ParseListen(listen)
+	if err != nil {
+		return listen
+	}
+	if addr.IsUnix() {
+		return "unix:" + addr.Path
+	}
+	return addr.DialAddresses()[0]
+}
+
+// dialPool sends a FastCGI request to a pool, trying each local address in
+// turn so dual-stack pools stay reachable when one address family is down
+func dialPool(listen string, params map[string]string, timeout time.Duration) (*fcgi.Response, error) {
+	addr, err := ParseListen(listen)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, target := range addr.DialAddresses() {
+		resp, err := fcgi.Do(addr.network(), target, params, nil, timeout)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) || opErr.Op != "dial" {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// localClientAddress is the loopback address used as REMOTE_ADDR for
+// requests this tool makes to pools
+func localClientAddress() string {
+	return strings.Trim(config.Get().Network.LoopbackAddresses[0], "[]")
+}
+
+// poolAllowedClients returns the listen.allowed_clients value for a pool:
+// the allowed_clients setting, or network.pool_allowed_clients. Unix socket
+// pools rely on file permissions instead.
+func poolAllowedClients(listen string, settings map[string]interface{}) (string, error) {
+	addr, err := ParseListen(listen)
+	if err != nil || addr.IsUnix() {
+		return "", nil
+	}
+	clients, err := settingStringList(settings, "allowed_clients")
+	if err != nil {
+		return "", err
+	}
+	if clients == nil {
+		return strings.Join(config.Get().Network.PoolAllowedClients, ","), nil
+	}
+	for i, client := range clients {
+		client = strings.Trim(client, "[]")
+		if net.ParseIP(client) == nil {
+			return "", fmt.Errorf("allowed_clients: invalid IP address %q", client)
+		}
+		clients[i] = client
+	}
+	return strings.Join(clients, ","), nil
+}
+
+// resolveListen returns the listen address for a pool's settings. With
+// listen_type "tcp" the pool listens on listen_address (default the first
+// of network.loopback_addresses) and listen_port, or a port allocated from
+// network.pool_port_min-pool_port_max that stays with the pool. Otherwise it
+// uses the provider's unix socket and gives up any allocated port.
+func (pm *PoolManager) resolveListen(t target.Target, dbPool *db.Pool, phpProvider provider.PHPProvider, settings map[string]interface{}) (string, error) {
+	listenType, _ := settings["listen_type"].(string)
+	switch listenType {
+	case "", ListenSocket:
+		if err := pm.db.ReleasePoolPort(dbPool.ID); err != nil {
+			return "", fmt.Errorf("failed to release pool port: %w", err)
+		}
+		return phpProvider.GetSocketPath(dbPool.Username, dbPool.PHPVersion), nil
+	case ListenTCP:
+	default:
+		return "", fmt.Errorf("invalid listen_type %q; expected %s or %s", listenType, ListenSocket, ListenTCP)
+	}
+
+	network := config.Get().Network
+	host := strings.Trim(network.LoopbackAddresses[0], "[]")
+	if v, ok := settings["listen_address"].(string); ok && v != "" {
+		host = strings.Trim(v, "[]")
+		if net.ParseIP(host) == nil {
+			return "", fmt.Errorf("invalid listen_address %q; expected an IP address such as 127.0.0.1 or 0.0.0.0", v)
+		}
+	}
+
+	requested := 0
+	if v, ok := settings["listen_port"].(float64); ok {
+		requested = int(v)
+		if requested < 1 || requested > 65535 || float64(requested) != v {
+			return "", fmt.Errorf("invalid listen_port %v", v)
+		}
+	}
+
+	port, err := pm.db.AllocatePoolPort(dbPool.ID, requested, network.PoolPortMin, network.PoolPortMax, func(port int) bool {
+		// Only the host's ports can be probed; targets have their own
+		return t.IsHost() && !portFree(host, port)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate a port for pool %s: %w", dbPool.Username, err)
+	}
+	return (&ListenAddress{Host: host, Port: port}).String(), nil
+}
+
+// portFree reports whether nothing listens on host:port
+func portFree(host string, port int) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	return true
+}
+
+// settingStringList reads a setting given as an array of strings or a
+// comma-separated string; nil means it is not set
+func settingStringList(settings map[string]interface{}, key string) ([]string, error) {
+	value, ok := settings[key]
+	if !ok {
+		return nil, nil
+	}
+	items := make([]string, 0)
+	switch v := value.(type) {
+	case string:
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings", key)
+			}
+			items = append(items, strings.TrimSpace(s))
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a list of strings", key)
+	}
+	return items, nil
+}
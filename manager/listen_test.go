@@ -0,0 +1,39 @@
+package manager
+
+import (
+	"testing"
+
+	"lightweight-php/config"
+)
+
+func TestTCPPoolListensOnTheFirstLoopback(t *testing.T) {
+	pm, _ := newTestPoolManager(t)
+	if err := pm.CreatePool("bob", "8.3", "remi"); err != nil {
+		t.Fatal(err)
+	}
+	network := &config.Get().Network
+	loopbacks := network.LoopbackAddresses
+	t.Cleanup(func() { network.LoopbackAddresses = loopbacks })
+
+	for _, tt := range []struct {
+		loopbacks []string
+		settings  map[string]interface{}
+		want      string
+	}{
+		{[]string{"127.0.0.1", "::1"}, map[string]interface{}{"listen_type": "tcp", "listen_port": float64(9101)}, "127.0.0.1:9101"},
+		{[]string{"[::1]", "127.0.0.1"}, map[string]interface{}{"listen_type": "tcp", "listen_port": float64(9102)}, "[::1]:9102"},
+		{[]string{"::1"}, map[string]interface{}{"listen_type": "tcp", "listen_port": float64(9103), "listen_address": "10.0.0.5"}, "10.0.0.5:9103"},
+	} {
+		network.LoopbackAddresses = tt.loopbacks
+		if err := pm.UpdatePoolConfig("bob", tt.settings); err != nil {
+			t.Fatalf("loopbacks %v: %v", tt.loopbacks, err)
+		}
+		pool, err := pm.db.GetPool("bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pool.SocketPath != tt.want {
+			t.Errorf("loopbacks %v, settings %v: listens on %s, want %s", tt.loopbacks, tt.settings, pool.SocketPath, tt.want)
+		}
+	}
+}
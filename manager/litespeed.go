@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+)
+
+const (
+	// liteSpeedConfig is OpenLiteSpeed's server configuration, in the plain
+	// format; LiteSpeed Enterprise's XML configuration is not edited
+	liteSpeedConfig = "/usr/local/lsws/conf/httpd_config.conf"
+	// liteSpeedAppDir holds one external app definition per lsphp pool,
+	// included from liteSpeedConfig
+	liteSpeedAppDir = "/usr/local/lsws/conf/lightweight-php"
+	// liteSpeedVhostDir holds the virtual hosts, one directory with a
+	// vhconf.conf each
+	liteSpeedVhostDir = "/usr/local/lsws/conf/vhosts"
+)
+
+// liteSpeedAppName is the external app of a pool; it matches the name of
+// the pool's socket
+func liteSpeedAppName(username, version string) string {
+	return "lsphp" + strings.ReplaceAll(version, ".", "") + "-" + username
+}
+
+// liteSpeedVhostMarkers delimit the block mapping PHP in a user's virtual
+// host to the user's pool
+func liteSpeedVhostMarkers(username string) (string, string) {
+	return "# BEGIN lightweight-php pool " + username, "# END lightweight-php pool " + username
+}
+
+// syncLiteSpeedApp defines the external app that serves an lsphp pool:
+// lsphp started by OpenLiteSpeed as the pool user on the pool's listener.
+// The app directory is included from the server configuration, and PHP
+// in the virtual host named after the user, when there is one, is mapped
+// to the app. OpenLiteSpeed picks the changes up on its next graceful
+// restart, which the pool's reload of the lsws service does.
+func syncLiteSpeedApp(t target.Target, phpProvider provider.PHPProvider, version string, data *templates.PoolConfigData) error {
+	root, err := t.Root()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(root, liteSpeedConfig)); err != nil {
+		return fmt.Errorf("OpenLiteSpeed configuration %s not found: %w", liteSpeedConfig, err)
+	}
+
+	address := data.SocketPath
+	if addr, err := ParseListen(data.SocketPath); err == nil && addr.IsUnix() {
+		address = "uds:/" + addr.Path
+	}
+	name := liteSpeedAppName(data.Username, version)
+	templateContent, err := templates.LoadTemplate("lsws-app.conf.tmpl")
+	if err != nil {
+		return fmt.Errorf("failed to load template: %w", err)
+	}
+	content, err := templates.RenderLiteSpeedApp(templateContent, &templates.LiteSpeedAppData{
+		Name:       name,
+		Username:   data.Username,
+		Group:      data.Group,
+		PHPVersion: version,
+		Address:    address,
+		Binary:     filepath.Join(filepath.Dir(phpProvider.GetBinaryPath(version)), "lsphp"),
+		MaxConns:   data.MaxChildren,
+		Env:        data.Env,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+
+	appDir := filepath.Join(root, liteSpeedAppDir)
+	if err := os.MkdirAll(appDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", liteSpeedAppDir, err)
+	}
+	// The app's env may carry secrets like the pool file's
+	mode := os.FileMode(0644)
+	if len(data.Env) > 0 {
+		mode = 0600
+	}
+	appPath := filepath.Join(appDir, data.Username+".conf")
+	if err := os.WriteFile(appPath, []byte(content), mode); err != nil {
+		return fmt.Errorf("failed to write external app: %w", err)
+	}
+	if err := os.Chmod(appPath, mode); err != nil {
+		return fmt.Errorf("failed to write external app: %w", err)
+	}
+	if err := includeLiteSpeedApps(filepath.Join(root, liteSpeedConfig)); err != nil {
+		return err
+	}
+
+	vhostConfig := filepath.Join(root, liteSpeedVhostDir, data.Username, "vhconf.conf")
+	if _, err := os.Stat(vhostConfig); err != nil {
+		// Without a virtual host of the same name the app is mapped by hand
+		return nil
+	}
+	begin, end := liteSpeedVhostMarkers(data.Username)
+	block := fmt.Sprintf("%s\nscripthandler  {\n  add                     lsapi:%s php\n}\n%s\n", begin, name, end)
+	return replaceManagedBlock(vhostConfig, begin, end, block)
+}
+
+// removeLiteSpeedApp removes the external app of a pool and its mapping in
+// the user's virtual host
+func removeLiteSpeedApp(t target.Target, username string) error {
+	root, err := t.Root()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(root, liteSpeedAppDir, username+".conf")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove external app: %w", err)
+	}
+	vhostConfig := filepath.Join(root, liteSpeedVhostDir, username, "vhconf.conf")
+	if _, err := os.Stat(vhostConfig); err != nil {
+		return nil
+	}
+	begin, end := liteSpeedVhostMarkers(username)
+	return replaceManagedBlock(vhostConfig, begin, end, "")
+}
+
+// includeLiteSpeedApps adds the include of the app directory to the server
+// configuration at path once
+func includeLiteSpeedApps(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", liteSpeedConfig, err)
+	}
+	include := "include " + liteSpeedAppDir + "/*.conf"
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == include {
+			return nil
+		}
+	}
+	updated := strings.TrimRight(string(content), "\n") + "\n\n# lightweight-php pools\n" + include + "\n"
+	if err := rewriteFile(path, updated); err != nil {
+		return fmt.Errorf("failed to update %s: %w", liteSpeedConfig, err)
+	}
+	return nil
+}
+
+// replaceManagedBlock replaces the lines from begin to end in a file with
+// block, appending block when the file has none; an empty block removes it
+func replaceManagedBlock(path, begin, end, block string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	text := string(content)
+	if start := strings.Index(text, begin+"\n"); start >= 0 {
+		if stop := strings.Index(text[start:], end+"\n"); stop >= 0 {
+			text = text[:start] + text[start+stop+len(end)+1:]
+		}
+	}
+	text = strings.TrimRight(text, "\n") + "\n"
+	if block != "" {
+		text += "\n" + block
+	}
+	if text == string(content) {
+		return nil
+	}
+	if err := rewriteFile(path, text); err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return nil
+}
+
+// rewriteFile replaces the content of a file, keeping its mode
+func rewriteFile(path, content string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), info.Mode().Perm())
+}
@@ -0,0 +1,265 @@
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lightweight-php/provider"
+	"lightweight-php/system"
+	"lightweight-php/target"
+)
+
+// Encoder loaders that can be installed per PHP version
+const (
+	LoaderIonCube        = "ioncube"
+	LoaderSourceGuardian = "sourceguardian"
+)
+
+// Loaders lists the supported loader names
+var Loaders = []string{LoaderIonCube, LoaderSourceGuardian}
+
+// loaderSpec describes where a loader is published and how it is loaded
+type loaderSpec struct {
+	// archive URL per architecture
+	urls map[system.Arch]string
+	// member returns the archive member for a PHP version and thread safety
+	member func(version string, zts bool) string
+	// iniFile is written into the conf dir; ionCube must be the first
+	// zend_extension, so it sorts before everything else
+	iniFile       string
+	zendExtension bool
+	// banner appears in `php -v` once the loader is active
+	banner string
+}
+
+var loaderSpecs = map[string]loaderSpec{
+	LoaderIonCube: {
+		urls: map[system.Arch]string{
+			system.ArchX86_64:  "https://downloads.ioncube.com/loader_downloads/ioncube_loaders_lin_x86-64.tar.gz",
+			system.ArchAArch64: "https://downloads.ioncube.com/loader_downloads/ioncube_loaders_lin_aarch64.tar.gz",
+		},
+		member: func(version string, zts bool) string {
+			if zts {
+				return fmt.Sprintf("ioncube/ioncube_loader_lin_%s_ts.so", version)
+			}
+			return fmt.Sprintf("ioncube/ioncube_loader_lin_%s.so", version)
+		},
+		iniFile:       "00-lightweight-php-ioncube.ini",
+		zendExtension: true,
+		banner:        "ionCube PHP Loader",
+	},
+	LoaderSourceGuardian: {
+		urls: map[system.Arch]string{
+			system.ArchX86_64:  "https://www.sourceguardian.com/loaders/download/loaders.linux-x86_64.tar.gz",
+			system.ArchAArch64: "https://www.sourceguardian.com/loaders/download/loaders.linux-aarch64.tar.gz",
+		},
+		member: func(version string, zts bool) string {
+			if zts {
+				return fmt.Sprintf("ixed.%sts.lin", version)
+			}
+			return fmt.Sprintf("ixed.%s.lin", version)
+		},
+		iniFile: "01-lightweight-php-sourceguardian.ini",
+		banner:  "SourceGuardian",
+	},
+}
+
+// LoaderStatus reports whether a loader is installed for a PHP version
+type LoaderStatus struct {
+	Loader    string `json:"loader"`
+	Installed bool   `json:"installed"`
+	Active    bool   `json:"active"`
+	Path      string `json:"path,omitempty"`
+	INIPath   string `json:"ini_path,omitempty"`
+}
+
+// InstallLoader downloads a loader for the PHP version and host
+// architecture, places it in the version's extension dir and enables it
+// with an ini snippet. If `php -v` does not report the loader afterwards,
+// the ini is removed again so the FPM service keeps starting.
+func (pm *PackageManager) InstallLoader(version string, providerType provider.ProviderType, loader string) (*LoaderStatus, error) {
+	spec, ok := loaderSpecs[loader]
+	if !ok {
+		return nil, fmt.Errorf("unknown loader %q; expected one of %s", loader, strings.Join(Loaders, ", "))
+	}
+
+	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+	binary := phpProvider.GetBinaryPath(version)
+	if binary == "" {
+		return nil, fmt.Errorf("loaders for the %s provider must be built into the PHP %s image", providerType, version)
+	}
+
+	t := pm.providerFactory.Target()
+	arch, err := targetArch(t)
+	if err != nil {
+		return nil, err
+	}
+	url, ok := spec.urls[arch]
+	if !ok {
+		return nil, fmt.Errorf("%s has no loader for %s", loader, arch)
+	}
+	extensionDir, err := phpIniValue(t, binary, "extension_dir")
+	if err != nil {
+		return nil, err
+	}
+	zts, err := phpIniValue(t, binary, "PHP_ZTS")
+	if err != nil {
+		return nil, err
+	}
+
+	member := spec.member(version, zts == "1")
+	soPath := filepath.Join(extensionDir, path.Base(member))
+	hostSOPath, err := t.Path(soPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := downloadArchiveMember(url, member, hostSOPath); err != nil {
+		return nil, err
+	}
+
+	directive := "extension"
+	if spec.zendExtension {
+		directive = "zend_extension"
+	}
+	iniPath := filepath.Join(phpProvider.GetConfDir(version), spec.iniFile)
+	hostINIPath, err := t.Path(iniPath)
+	if err != nil {
+		return nil, err
+	}
+	ini := fmt.Sprintf("; Managed by lightweight-php\n%s=%s\n", directive, soPath)
+	if err := os.MkdirAll(filepath.Dir(hostINIPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create conf directory: %w", err)
+	}
+	if err := os.WriteFile(hostINIPath, []byte(ini), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write loader config: %w", err)
+	}
+
+	output, err := t.Command(binary, "-v").CombinedOutput()
+	if err != nil || !strings.Contains(string(output), spec.banner) {
+		os.Remove(hostINIPath)
+		return nil, fmt.Errorf("%s loader did not load in PHP %s: %s", loader, version, strings.TrimSpace(string(output)))
+	}
+
+	status := &LoaderStatus{Loader: loader, Installed: true, Active: true, Path: soPath, INIPath: iniPath}
+	if err := reloadFPMIn(pm.context(), t, phpProvider, version); err != nil {
+		return status, fmt.Errorf("failed to reload PHP-FPM: %w", err)
+	}
+	return status, nil
+}
+
+// ListLoaders reports the state of every supported loader for a PHP version
+func (pm *PackageManager) ListLoaders(version string, providerType provider.ProviderType) ([]LoaderStatus, error) {
+	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	t := pm.providerFactory.Target()
+	var phpVersion string
+	binary := phpProvider.GetBinaryPath(version)
+	if binary != "" {
+		if output, err := t.Command(binary, "-v").CombinedOutput(); err == nil {
+			phpVersion = string(output)
+		}
+	}
+
+	statuses := make([]LoaderStatus, 0, len(Loaders))
+	for _, loader := range Loaders {
+		spec := loaderSpecs[loader]
+		status := LoaderStatus{Loader: loader}
+		iniPath := filepath.Join(phpProvider.GetConfDir(version), spec.iniFile)
+		hostINIPath, err := t.Path(iniPath)
+		if err != nil {
+			return nil, err
+		}
+		if content, err := os.ReadFile(hostINIPath); err == nil {
+			status.Installed = true
+			status.INIPath = iniPath
+			if _, value, ok := strings.Cut(strings.TrimSpace(string(content)), "="); ok {
+				status.Path = value
+			}
+		}
+		status.Active = strings.Contains(phpVersion, spec.banner)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// phpIniValue returns an ini setting, or a constant for names in capitals,
+// as reported by the PHP CLI binary on the target
+func phpIniValue(t target.Target, binary, name string) (string, error) {
+	code := fmt.Sprintf("echo ini_get(%q);", name)
+	if strings.ToUpper(name) == name {
+		code = fmt.Sprintf("echo (int) %s;", name)
+	}
+	output, err := t.Command(binary, "-n", "-r", code).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", binary, err)
+	}
+	value := strings.TrimSpace(string(output))
+	if value == "" {
+		return "", fmt.Errorf("%s reported no %s", binary, name)
+	}
+	return value, nil
+}
+
+// downloadArchiveMember fetches a .tar.gz and writes one of its files to dest
+func downloadArchiveMember(url, member, dest string) error {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s does not contain %s; this PHP version may not be supported yet", url, member)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", url, err)
+		}
+		if strings.TrimPrefix(header.Name, "./") != member {
+			continue
+		}
+
+		tmp := dest + ".tmp"
+		f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", tmp, err)
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return fmt.Errorf("failed to write %s: %w", dest, err)
+		}
+		f.Close()
+		if err := os.Rename(tmp, dest); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to install %s: %w", dest, err)
+		}
+		return nil
+	}
+}
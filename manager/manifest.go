@@ -0,0 +1,173 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"lightweight-php/validation"
+)
+
+const manifestFormatVersion = 1
+
+// PoolManifest is a self-contained description of a user's pool and the
+// sites it serves, for moving an account to another server without the
+// files of a backup. It is JSON, which YAML 1.2 parsers read as well.
+type PoolManifest struct {
+	FormatVersion int       `json:"format_version"`
+	Username      string    `json:"username"`
+	PHPVersion    string    `json:"php_version"`
+	Provider      string    `json:"provider"`
+	Tenant        string    `json:"tenant,omitempty"`
+	Host          string    `json:"host"`
+	ExportedAt    time.Time `json:"exported_at"`
+	// Settings are the stored pool settings, including the php.ini
+	// overrides such as memory_limit; keys left out use the template
+	// defaults
+	Settings map[string]interface{} `json:"settings"`
+	Labels   map[string]string      `json:"labels,omitempty"`
+	// Sites are the nginx vhosts of the user with their path bindings
+	Sites []BackupSite `json:"sites"`
+	// Crons are the pool's cron jobs
+	Crons []CronJob `json:"crons,omitempty"`
+}
+
+// ExportManifest returns the manifest of a user's pool
+func (pm *PoolManager) ExportManifest(username string) (*PoolManifest, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	cfg, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := pm.db.GetPoolLabels(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool labels: %w", err)
+	}
+	sites, err := pm.userSites(username)
+	if err != nil {
+		return nil, err
+	}
+	crons, err := pm.poolCronJobs(username)
+	if err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	return &PoolManifest{
+		FormatVersion: manifestFormatVersion,
+		Username:      dbPool.Username,
+		PHPVersion:    dbPool.PHPVersion,
+		Provider:      dbPool.Provider,
+		Tenant:        dbPool.Tenant,
+		Host:          host,
+		ExportedAt:    time.Now().UTC(),
+		Settings:      cfg.Settings,
+		Labels:        labels,
+		Sites:         sites,
+		Crons:         crons,
+	}, nil
+}
+
+// ReadManifest decodes and checks a pool manifest
+func ReadManifest(r io.Reader) (*PoolManifest, error) {
+	var manifest PoolManifest
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if manifest.FormatVersion != manifestFormatVersion {
+		return nil, fmt.Errorf("unsupported manifest format version %d", manifest.FormatVersion)
+	}
+	if err := validation.Field("username", manifest.Username, validation.Username); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := validation.Field("php_version", manifest.PHPVersion, validation.PHPVersion); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	for _, job := range manifest.Crons {
+		if err := job.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid manifest cron job: %w", err)
+		}
+	}
+	return &manifest, nil
+}
+
+// ImportManifest recreates a pool from its manifest: the pool is created
+// under phpVersion when set, otherwise under the manifest's version, which
+// must be installed, and gets the manifest's settings and labels. Sites
+// that do not exist are created; bindings to the manifest's PHP version
+// follow the pool to phpVersion, and cron jobs the pool lacks are added.
+// The manifest's tenant must exist.
+func (pm *PoolManager) ImportManifest(manifest *PoolManifest, phpVersion string) (*RestoreReport, error) {
+	if phpVersion == "" {
+		phpVersion = manifest.PHPVersion
+	}
+	settings := mergeSettings(manifest.Settings, nil)
+	if err := normalizeSettings(settings); err != nil {
+		return nil, fmt.Errorf("invalid manifest settings: %w", err)
+	}
+	for key, value := range manifest.Labels {
+		if err := ValidateLabel(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	existing, err := pm.db.GetPoolByUsernameAndVersion(manifest.Username, phpVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check pool: %w", err)
+	}
+	report := &RestoreReport{
+		Username:     manifest.Username,
+		CreatedAt:    manifest.ExportedAt,
+		PoolsCreated: make([]string, 0),
+		PoolsSkipped: make([]string, 0),
+		SitesCreated: make([]string, 0),
+		SitesSkipped: make([]string, 0),
+	}
+	if existing != nil {
+		report.PoolsSkipped = append(report.PoolsSkipped, phpVersion)
+	} else {
+		if err := pm.WithTenant(manifest.Tenant).CreatePool(manifest.Username, phpVersion, manifest.Provider); err != nil {
+			return nil, err
+		}
+		report.PoolsCreated = append(report.PoolsCreated, phpVersion)
+		if len(settings) > 0 {
+			if err := pm.initPoolSettings(manifest.Username, settings); err != nil {
+				return report, fmt.Errorf("pool created but applying its settings failed: %w", err)
+			}
+		}
+		if len(manifest.Labels) > 0 {
+			if _, err := pm.UpdatePoolLabels(manifest.Username, manifest.Labels, nil); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	sites := make([]BackupSite, 0, len(manifest.Sites))
+	for _, s := range manifest.Sites {
+		site := BackupSite{Domain: s.Domain, DocumentRoot: s.DocumentRoot, Bindings: make(map[string]string, len(s.Bindings))}
+		for prefix, version := range s.Bindings {
+			if version == manifest.PHPVersion {
+				version = phpVersion
+			}
+			site.Bindings[prefix] = version
+		}
+		sites = append(sites, site)
+	}
+	if err := pm.restoreSites(manifest.Username, sites, report); err != nil {
+		return report, err
+	}
+	if err := pm.restoreCronJobs(manifest.Username, manifest.Crons); err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,305 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lightweight-php/apiclient"
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/maintenance"
+)
+
+// MigrationStateDir holds one resumable state file per account migration
+const MigrationStateDir = "/var/lib/lightweight-php/migrations"
+
+// Migration steps, executed in order. The sites are switched over last so
+// the source keeps serving until the target is complete.
+const (
+	MigrationStepRsync  = "rsync"
+	MigrationStepCreate = "create"
+	MigrationStepSwitch = "switch"
+)
+
+var migrationSteps = []string{MigrationStepRsync, MigrationStepCreate, MigrationStepSwitch}
+
+// MigrationOptions configures an account migration
+type MigrationOptions struct {
+	TargetHost string
+	SSHUser    string
+	APIURL     string
+	// APIKey authenticates the calls to the target's API
+	APIKey       string
+	PHPVersion   string
+	Restart      bool
+	BandwidthKBs int
+}
+
+// MigrationState records progress so an interrupted migration can resume
+type MigrationState struct {
+	Username       string    `json:"username"`
+	TargetHost     string    `json:"target_host"`
+	CompletedSteps []string  `json:"completed_steps"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (s *MigrationState) done(step string) bool {
+	return containsString(s.CompletedSteps, step)
+}
+
+// MigrateAccount moves an account to another server: the home directory is
+// copied with rsync over SSH, the pool is recreated on the target through
+// its API from an export bundle, and finally the sites are switched over:
+// the local snippets proxy to the target and the DNS records are pointed
+// at it. Completed steps are skipped when the command is re-run.
+func (pm *PoolManager) MigrateAccount(username string, opts MigrationOptions, progress func(step string)) error {
+	if opts.TargetHost == "" {
+		return fmt.Errorf("target host is required")
+	}
+	targetHost, err := config.NormalizeHost(opts.TargetHost)
+	if err != nil {
+		return fmt.Errorf("invalid target host: %w", err)
+	}
+	opts.TargetHost = targetHost
+	if opts.SSHUser == "" {
+		opts.SSHUser = "root"
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = "http://" + net.JoinHostPort(opts.TargetHost, "8080")
+	}
+	client, err := apiclient.New(opts.APIURL, opts.APIKey)
+	if err != nil {
+		return fmt.Errorf("invalid target API URL: %w", err)
+	}
+
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+
+	state, err := loadMigrationState(username, opts.TargetHost)
+	if err != nil {
+		return err
+	}
+	if opts.Restart {
+		state.CompletedSteps = nil
+	}
+
+	for _, step := range migrationSteps {
+		if state.done(step) {
+			if progress != nil {
+				progress(step + " (already done)")
+			}
+			continue
+		}
+		if progress != nil {
+			progress(step)
+		}
+
+		switch step {
+		case MigrationStepRsync:
+			err = rsyncHome(username, opts)
+		case MigrationStepCreate:
+			err = pm.createOnTarget(username, client, opts)
+		case MigrationStepSwitch:
+			err = pm.switchToTarget(dbPool, client, opts, progress)
+		}
+		if err != nil {
+			return fmt.Errorf("migration step %s failed: %w", step, err)
+		}
+
+		state.CompletedSteps = append(state.CompletedSteps, step)
+		if err := saveMigrationState(state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rsyncHome copies the user's home directory to the same path on the target
+func rsyncHome(username string, opts MigrationOptions) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("failed to lookup user: %w", err)
+	}
+
+	groupName := username
+	if g, err := user.LookupGroupId(u.Gid); err == nil {
+		groupName = g.Name
+	}
+
+	home := strings.TrimSuffix(u.HomeDir, "/") + "/"
+	host := opts.TargetHost
+	if strings.Contains(host, ":") {
+		// rsync needs IPv6 literals in brackets to find the path separator
+		host = "[" + host + "]"
+	}
+	dest := fmt.Sprintf("%s@%s:%s", opts.SSHUser, host, home)
+
+	rsyncArgs := []string{"-aH", "--partial", "--mkpath",
+		fmt.Sprintf("--chown=%s:%s", username, groupName),
+		"-e", "ssh -o BatchMode=yes"}
+	if opts.BandwidthKBs > 0 {
+		rsyncArgs = append(rsyncArgs, fmt.Sprintf("--bwlimit=%d", opts.BandwidthKBs))
+	}
+	rsyncArgs = append(rsyncArgs, home, dest)
+
+	var stderr bytes.Buffer
+	cmd := maintenance.Command("rsync", rsyncArgs...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errorMsg := strings.TrimSpace(stderr.String())
+		if errorMsg == "" {
+			errorMsg = err.Error()
+		}
+		return fmt.Errorf("rsync failed: %s", errorMsg)
+	}
+	return nil
+}
+
+// createOnTarget uploads an export bundle to the target's import endpoint
+func (pm *PoolManager) createOnTarget(username string, client *apiclient.Client, opts MigrationOptions) error {
+	var bundle bytes.Buffer
+	if err := pm.ExportBundle(username, &bundle); err != nil {
+		return err
+	}
+
+	path := "/api/v1/pools/import"
+	if opts.PHPVersion != "" {
+		path += "?php_version=" + url.QueryEscape(opts.PHPVersion)
+	}
+	if err := client.WithTimeout(5*time.Minute).Upload(path, "application/gzip", &bundle, nil); err != nil {
+		return fmt.Errorf("target API: %w", err)
+	}
+	return nil
+}
+
+// switchToTarget moves the account's traffic to the target. The pool is
+// marked migrated first, which makes the site snippets proxy every request
+// to the target, so visitors reach it while DNS still points here; then
+// each site's records are pointed at the target through its API. A target
+// without a DNS provider leaves the records to be changed by hand.
+func (pm *PoolManager) switchToTarget(dbPool *db.Pool, client *apiclient.Client, opts MigrationOptions, progress func(step string)) error {
+	sites, err := pm.userSites(dbPool.Username)
+	if err != nil {
+		return err
+	}
+
+	if err := pm.db.UpdatePoolStatus(dbPool.Username, db.PoolMigrated); err != nil {
+		return fmt.Errorf("failed to update pool status: %w", err)
+	}
+	sm := NewSiteManagerWithDeps(pm.db).WithContext(pm.context())
+	for i, s := range sites {
+		if _, err := sm.writeSnippet(s.Domain); err != nil {
+			// Serve the account locally again until the step is re-run
+			pm.db.UpdatePoolStatus(dbPool.Username, dbPool.Status)
+			for _, switched := range sites[:i] {
+				sm.writeSnippet(switched.Domain)
+			}
+			return fmt.Errorf("failed to switch site %s: %w", s.Domain, err)
+		}
+	}
+
+	for _, s := range sites {
+		err := client.Do(http.MethodPut, "/api/v1/sites/"+url.PathEscape(s.Domain)+"/dns", nil, nil)
+		var apiErr *apiclient.Error
+		if errors.As(err, &apiErr) && apiErr.Code == "dns_provider_missing" {
+			if progress != nil {
+				progress(fmt.Sprintf("%s: the target has no DNS provider, point the records at %s by hand", s.Domain, opts.TargetHost))
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to point the DNS records of %s at the target: %w", s.Domain, err)
+		}
+	}
+	return nil
+}
+
+// migrationProxy returns the URL the sites of a migrated account are
+// proxied to, or "" while the account is served locally
+func migrationProxy(database *db.Database, username string) (string, error) {
+	dbPool, err := database.GetPool(username)
+	if err != nil {
+		return "", fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil || dbPool.Status != db.PoolMigrated {
+		return "", nil
+	}
+
+	state, err := readMigrationState(username)
+	if err != nil {
+		return "", err
+	}
+	if state == nil {
+		return "", fmt.Errorf("pool %s was migrated but its migration state is missing", username)
+	}
+	host := state.TargetHost
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return "http://" + host, nil
+}
+
+func migrationStatePath(username string) string {
+	return hostPath(filepath.Join(MigrationStateDir, username+".json"))
+}
+
+func loadMigrationState(username, targetHost string) (*MigrationState, error) {
+	saved, err := readMigrationState(username)
+	if err != nil {
+		return nil, err
+	}
+	// Progress towards a different target does not carry over
+	if saved == nil || saved.TargetHost != targetHost {
+		return &MigrationState{Username: username, TargetHost: targetHost}, nil
+	}
+	return saved, nil
+}
+
+// readMigrationState returns the saved state of a user's migration, or nil
+func readMigrationState(username string) (*MigrationState, error) {
+	content, err := os.ReadFile(migrationStatePath(username))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration state: %w", err)
+	}
+
+	var saved MigrationState
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return nil, fmt.Errorf("failed to decode migration state: %w", err)
+	}
+	return &saved, nil
+}
+
+func saveMigrationState(state *MigrationState) error {
+	if err := os.MkdirAll(hostPath(MigrationStateDir), 0700); err != nil {
+		return fmt.Errorf("failed to create migration state directory: %w", err)
+	}
+
+	state.UpdatedAt = time.Now().UTC()
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode migration state: %w", err)
+	}
+	if err := os.WriteFile(migrationStatePath(state.Username), content, 0600); err != nil {
+		return fmt.Errorf("failed to write migration state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/fcgi"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+	"lightweight-php/validation"
+)
+
+// OpcacheINIFile is the managed drop-in written into a version's conf dir.
+// The 99- prefix makes it load after the distro's own opcache ini.
+const OpcacheINIFile = "99-lightweight-php-opcache.ini"
+
+const opcacheResetScript = ".lightweight-php-opcache-reset.php"
+
+// ConfigureOpcache writes the global opcache settings for a PHP version and
+// reloads its FPM service. It returns the path of the written ini file.
+func (pm *PackageManager) ConfigureOpcache(version string, providerType provider.ProviderType, settings map[string]interface{}) (string, error) {
+	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
+	if err != nil {
+		return "", fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	data := &templates.OpcacheConfigData{}
+	if err := applyOpcacheSettings(data, version, settings); err != nil {
+		return "", err
+	}
+
+	templateContent, err := templates.LoadTemplate("opcache.ini.tmpl")
+	if err != nil {
+		return "", fmt.Errorf("failed to load template: %w", recordRenderFailure(pm.db, "", nil, err))
+	}
+
+	config, err := templates.RenderOpcacheConfig(templateContent, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template: %w", recordRenderFailure(pm.db, "", data, err))
+	}
+
+	t := pm.providerFactory.Target()
+	confDir, err := t.Path(phpProvider.GetConfDir(version))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(confDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create conf directory: %w", err)
+	}
+
+	iniPath := filepath.Join(confDir, OpcacheINIFile)
+	if err := os.WriteFile(iniPath, []byte(config), 0644); err != nil {
+		return "", fmt.Errorf("failed to write opcache config: %w", err)
+	}
+
+	if err := reloadFPMIn(pm.context(), t, phpProvider, version); err != nil {
+		return iniPath, fmt.Errorf("failed to reload PHP-FPM: %w", err)
+	}
+
+	return iniPath, nil
+}
+
+func applyOpcacheSettings(data *templates.OpcacheConfigData, version string, settings map[string]interface{}) error {
+	for key, value := range settings {
+		var ok bool
+		switch key {
+		case "memory_consumption":
+			data.MemoryConsumption, ok = settingString(value)
+		case "max_accelerated_files":
+			data.MaxAcceleratedFiles, ok = settingString(value)
+		case "validate_timestamps":
+			data.ValidateTimestamps, ok = settingFlag(value)
+		case "jit":
+			data.JIT, ok = settingString(value)
+		case "jit_buffer_size":
+			data.JITBufferSize, ok = settingString(value)
+		default:
+			return fmt.Errorf("unknown opcache setting: %s", key)
+		}
+		if !ok {
+			return fmt.Errorf("invalid value for opcache setting %s", key)
+		}
+	}
+
+	if data.JIT != "" && data.JIT != "off" && data.JIT != "disable" {
+		if ok, err := validation.PHPVersionAtLeast(version, "8.0"); err != nil || !ok {
+			return fmt.Errorf("opcache JIT requires PHP 8.0 or newer")
+		}
+		// JIT stays inactive without a buffer
+		if data.JITBufferSize == "" {
+			data.JITBufferSize = "64M"
+		}
+	}
+
+	return nil
+}
+
+// ResetOpcache clears the opcache of a pool by executing opcache_reset()
+// inside the pool through a direct FastCGI request to its socket
+func (pm *PoolManager) ResetOpcache(username string) error {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+
+	resp, err := pm.runPoolScript(dbPool, opcacheResetScript,
+		"<?php\necho function_exists('opcache_reset') && opcache_reset() ? 'OK' : 'UNAVAILABLE';\n")
+	if err != nil {
+		return err
+	}
+
+	body := strings.TrimSpace(string(resp.Body))
+	if resp.Status != 200 || body != "OK" {
+		return fmt.Errorf("opcache reset failed (status %d): %s", resp.Status, body)
+	}
+	return nil
+}
+
+// runPoolScript writes a short-lived PHP script into the pool user's tmp
+// directory and executes it through the pool's FastCGI listener
+func (pm *PoolManager) runPoolScript(dbPool *db.Pool, name, source string) (*fcgi.Response, error) {
+	t, err := target.Parse(dbPool.Target)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.LookupUser(dbPool.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup user: %w", err)
+	}
+
+	// FPM sees the script at its path inside the target
+	scriptDir := filepath.Join(templates.TmpBaseDir, dbPool.Username)
+	scriptPath := filepath.Join(scriptDir, name)
+	hostScriptDir, err := t.Path(scriptDir)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	if uid, gid, err = t.HostIDs(uid, gid); err != nil {
+		return nil, err
+	}
+	if err := writePoolScript(hostScriptDir, name, source, uid, gid); err != nil {
+		return nil, err
+	}
+	defer os.Remove(filepath.Join(hostScriptDir, name))
+
+	listen, err := poolListen(t, dbPool)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dialPool(listen, fastCGIParams(scriptPath), 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("FastCGI request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// writePoolScript writes a helper script owned by the pool user into dir.
+// It runs as root in a directory the user owns, so like writeUserINI the
+// script is created under a fresh name, chowned through its descriptor and
+// renamed over name, which replaces a planted symlink rather than following
+// it.
+func writePoolScript(dir, name, source string, uid, gid int) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("refusing to write helper script: %s is not a directory", dir)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write helper script: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chown(uid, gid); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set ownership on helper script: %w", err)
+	}
+	if _, err := tmp.WriteString(source); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write helper script: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write helper script: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("failed to write helper script: %w", err)
+	}
+	return nil
+}
+
+// poolListen returns the pool's listen address as reachable from the host;
+// sockets inside a target are addressed through its root
+func poolListen(t target.Target, dbPool *db.Pool) (string, error) {
+	listen := dbPool.SocketPath
+	if addr, err := ParseListen(listen); err == nil && addr.IsUnix() {
+		return t.Path(listen)
+	}
+	return listen, nil
+}
+
+// fastCGIParams returns the minimal CGI environment for running a script
+func fastCGIParams(scriptPath string) map[string]string {
+	return map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "lightweight-php",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"REQUEST_METHOD":    "GET",
+		"SCRIPT_FILENAME":   scriptPath,
+		"SCRIPT_NAME":       "/" + filepath.Base(scriptPath),
+		"REQUEST_URI":       "/" + filepath.Base(scriptPath),
+		"QUERY_STRING":      "",
+		"CONTENT_LENGTH":    "0",
+		"REMOTE_ADDR":       localClientAddress(),
+	}
+}
@@ -0,0 +1,52 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritePoolScriptReplacesPlantedSymlink(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	victim := filepath.Join(outside, "shadow")
+	if err := os.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(dir, opcacheResetScript)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writePoolScript(dir, opcacheResetScript, "<?php echo 'OK';\n", os.Getuid(), os.Getgid()); err != nil {
+		t.Fatalf("writePoolScript: %v", err)
+	}
+
+	if data, _ := os.ReadFile(victim); string(data) != "keep" {
+		t.Errorf("writePoolScript wrote through the symlink: %q", data)
+	}
+	info, err := os.Lstat(filepath.Join(dir, opcacheResetScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != 0600 {
+		t.Errorf("script is %v, want a regular file with mode 0600", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("writePoolScript left %d entries behind, want 1", len(entries))
+	}
+}
+
+func TestWritePoolScriptRejectsSymlinkedDirectory(t *testing.T) {
+	outside := t.TempDir()
+	dir := filepath.Join(t.TempDir(), "bob")
+	if err := os.Symlink(outside, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writePoolScript(dir, opcacheResetScript, "<?php\n", os.Getuid(), os.Getgid()); err == nil {
+		t.Error("writePoolScript into a symlinked directory = nil, want an error")
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("writePoolScript wrote %d files outside the directory", len(entries))
+	}
+}
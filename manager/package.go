@@ -1,53 +1,249 @@
 package manager
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/lock"
 	"lightweight-php/provider"
+	"lightweight-php/system"
+	"lightweight-php/target"
+	"lightweight-php/tracing"
+	"lightweight-php/validation"
+	"lightweight-php/version"
 )
 
+// installLockTimeout bounds how long an install waits for another package
+// manager run to finish
+const installLockTimeout = 30 * time.Minute
+
 type PackageManager struct {
+	db              *db.Database
 	providerFactory *provider.ProviderFactory
 	defaultProvider provider.PHPProvider
+	locks           *lock.Manager
+	noWait          bool
+	// progress receives the output of installs as they run
+	progress InstallProgress
+	// rollback undoes the changes of failed installs, see WithRollback
+	rollback bool
+	// ctx carries the span that operations are traced under
+	ctx context.Context
 }
 
 func NewPackageManager() (*PackageManager, error) {
-	factory, err := provider.NewProviderFactory()
+	database, err := db.NewDatabase("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	osFamily, err := system.NewOSDetector().Detect()
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize provider factory: %w", err)
+		database.Close()
+		return nil, err
 	}
 
+	return NewPackageManagerWithDeps(database, provider.NewProviderFactoryWithDeps(database, osFamily))
+}
+
+// NewPackageManagerWithDeps creates a package manager on a shared database
+// handle and provider factory
+func NewPackageManagerWithDeps(database *db.Database, factory *provider.ProviderFactory) (*PackageManager, error) {
 	defaultProvider, err := factory.GetDefaultProvider()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get default provider: %w", err)
 	}
 
 	return &PackageManager{
+		db:              database,
 		providerFactory: factory,
 		defaultProvider: defaultProvider,
+		locks:           lock.Default,
 	}, nil
 }
 
+// WithNoWait returns a copy of the manager whose installs fail with
+// lock.ErrBusy instead of waiting for a running apt/dnf operation
+func (pm *PackageManager) WithNoWait() *PackageManager {
+	c := *pm
+	c.noWait = true
+	return &c
+}
+
+// WithProgress returns a copy of the manager that reports the progress of
+// its installs and uninstalls to p
+func (pm *PackageManager) WithProgress(p InstallProgress) *PackageManager {
+	c := *pm
+	c.progress = p
+	return &c
+}
+
+// WithTarget returns a copy of the manager whose providers install and
+// query PHP inside t
+func (pm *PackageManager) WithTarget(t target.Target) (*PackageManager, error) {
+	factory, err := pm.providerFactory.ForTarget(t)
+	if err != nil {
+		return nil, fmt.Errorf("target %s is not available: %w", t, err)
+	}
+	defaultProvider, err := factory.GetDefaultProvider()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default provider: %w", err)
+	}
+	c := *pm
+	c.providerFactory = factory
+	c.defaultProvider = defaultProvider
+	return &c, nil
+}
+
+// WithContext returns a copy of the manager whose operations are traced as
+// children of the span in ctx
+func (pm *PackageManager) WithContext(ctx context.Context) *PackageManager {
+	c := *pm
+	c.ctx = ctx
+	c.providerFactory = pm.providerFactory.WithContext(ctx)
+	return &c
+}
+
+func (pm *PackageManager) context() context.Context {
+	if pm.ctx == nil {
+		return context.Background()
+	}
+	return pm.ctx
+}
+
 // InstallPHP installs PHP using the default provider (remi)
-func (pm *PackageManager) InstallPHP(version string) error {
-	return pm.defaultProvider.InstallPHP(version)
+func (pm *PackageManager) InstallPHP(version string) (err error) {
+	ctx, span := tracing.Start(pm.context(), "php.install")
+	span.SetAttr("php.version", version)
+	span.SetAttr("php.provider", pm.defaultProvider.GetProviderType())
+	defer span.Finish(&err)
+	defer recordAudit(pm.context(), pm.db, "php.install", version, &err)
+	pm = pm.WithContext(ctx)
+
+	if err := validation.Field("version", version, validation.PHPVersion); err != nil {
+		return err
+	}
+	l, err := pm.locks.Acquire(lock.KeyPackageManager, "install php "+version, !pm.noWait, installLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	providerType := provider.ProviderType(pm.defaultProvider.GetProviderType())
+	return publishInstall(version, string(providerType), func() error {
+		return recordInstall(pm.db, pm.providerFactory, providerType, version, "install", pm.progress, func(p provider.PHPProvider) error {
+			return pm.installTransaction(p, version)
+		})
+	})
 }
 
 // InstallPHPWithProvider installs PHP using a specific provider
-func (pm *PackageManager) InstallPHPWithProvider(version string, providerType provider.ProviderType) error {
-	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
-	if err != nil {
+func (pm *PackageManager) InstallPHPWithProvider(version string, providerType provider.ProviderType) (err error) {
+	ctx, span := tracing.Start(pm.context(), "php.install")
+	span.SetAttr("php.version", version)
+	span.SetAttr("php.provider", string(providerType))
+	defer span.Finish(&err)
+	defer recordAudit(pm.context(), pm.db, "php.install", version, &err)
+	pm = pm.WithContext(ctx)
+
+	if err := validation.Field("version", version, validation.PHPVersion); err != nil {
+		return err
+	}
+	if _, err := pm.providerFactory.CreateProvider(providerType); err != nil {
 		return fmt.Errorf("failed to create provider: %w", err)
 	}
-	return phpProvider.InstallPHP(version)
+
+	l, err := pm.locks.Acquire(lock.KeyPackageManager, fmt.Sprintf("install php %s (%s)", version, providerType), !pm.noWait, installLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	return publishInstall(version, string(providerType), func() error {
+		return recordInstall(pm.db, pm.providerFactory, providerType, version, "install", pm.progress, func(p provider.PHPProvider) error {
+			return pm.installTransaction(p, version)
+		})
+	})
+}
+
+// publishInstall runs an installation, announcing its start and outcome
+func publishInstall(version, providerName string, install func() error) error {
+	data := map[string]interface{}{"version": version, "provider": providerName, "status": "started"}
+	events.Publish(events.PHPInstall, "", data)
+
+	err := install()
+	data = map[string]interface{}{"version": version, "provider": providerName, "status": "finished"}
+	if err != nil {
+		data["status"] = "failed"
+		data["error"] = err.Error()
+	}
+	events.Publish(events.PHPInstall, "", data)
+	return err
 }
 
+// ListInstalledPHP lists the default provider's installed versions, newest
+// first. Providers cache them for provider.InstalledCacheTTL; see
+// RefreshInstalledPHP.
 func (pm *PackageManager) ListInstalledPHP() ([]string, error) {
-	return pm.defaultProvider.ListInstalledPHP()
+	versions, err := pm.defaultProvider.ListInstalledPHP()
+	version.Sort(versions)
+	return versions, err
 }
 
+// RefreshInstalledPHP drops the cached installed versions of all
+// providers, so they are detected again on the next listing
+func (pm *PackageManager) RefreshInstalledPHP() {
+	provider.InvalidateInstalled()
+}
+
+// ListAvailablePHP lists the versions the default provider can install,
+// newest first
 func (pm *PackageManager) ListAvailablePHP() ([]string, error) {
-	return pm.defaultProvider.ListAvailablePHP()
+	versions, err := pm.defaultProvider.ListAvailablePHP()
+	version.Sort(versions)
+	return versions, err
+}
+
+// ResolveVersion turns a version to install into a major.minor version. A
+// major.minor version is returned as is; a constraint such as 8 or
+// ">=7.4 <8.4" resolves to the newest version the provider offers that
+// satisfies it. An empty providerType means the default provider.
+func (pm *PackageManager) ResolveVersion(spec string, providerType provider.ProviderType) (string, error) {
+	if validation.PHPVersion(spec) == nil {
+		return spec, nil
+	}
+	if err := validation.Field("version", spec, validation.PHPVersionSpec); err != nil {
+		return "", err
+	}
+	constraint, _ := version.ParseConstraint(spec)
+
+	p := pm.defaultProvider
+	if providerType != "" {
+		var err error
+		if p, err = pm.providerFactory.CreateProvider(providerType); err != nil {
+			return "", fmt.Errorf("failed to create provider: %w", err)
+		}
+	}
+	available, err := p.ListAvailablePHP()
+	if err != nil {
+		return "", fmt.Errorf("failed to list available PHP versions: %w", err)
+	}
+	// Only major.minor versions can be installed side by side
+	var candidates []string
+	for _, v := range available {
+		if validation.PHPVersion(v) == nil {
+			candidates = append(candidates, v)
+		}
+	}
+	resolved, ok := version.Latest(candidates, constraint)
+	if !ok {
+		var invalid validation.Errors
+		invalid.Add("version", "no PHP version available from %s matches %s", p.GetProviderType(), spec)
+		return "", invalid
+	}
+	return resolved, nil
 }
 
 // GetProvider returns the default provider
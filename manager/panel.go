@@ -0,0 +1,496 @@
+package manager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lightweight-php/validation"
+)
+
+// Hosting panels ScanPanel reads
+const (
+	PanelCPanel = "cpanel"
+	PanelPlesk  = "plesk"
+)
+
+// Outcomes of the pools and sites of a panel import. A scan reports what
+// would be created; ImportPanel reports what was.
+const (
+	PanelCreate  = "create"
+	PanelCreated = "created"
+	PanelExists  = "exists"
+	PanelFailed  = "failed"
+)
+
+// Where the panels keep their configuration
+const (
+	cpanelUserdataDir = "/var/cpanel/userdata"
+	cpanelEA4Config   = "/etc/cpanel/ea4/php.conf"
+	// cpanelFPMPools holds ea-php pools as <domain>.conf, %s is e.g. ea-php81
+	cpanelFPMPools  = "/opt/cpanel/%s/root/etc/php-fpm.d"
+	pleskSystemDir  = "/var/www/vhosts/system"
+	pleskVhostsDir  = "/var/www/vhosts"
+	pleskPHPDir     = "/opt/plesk/php"
+	pleskDocrootDir = "httpdocs"
+)
+
+// PanelImport is what a hosting panel serves with PHP-FPM, as pools and
+// sites to create
+type PanelImport struct {
+	Panel    string         `json:"panel"`
+	Accounts []PanelAccount `json:"accounts"`
+	// Skipped lists what cannot be carried over, with the reason
+	Skipped []string `json:"skipped"`
+}
+
+// PanelAccount is a panel user with one pool per PHP version its domains use
+type PanelAccount struct {
+	Username string      `json:"username"`
+	Pools    []PanelPool `json:"pools"`
+	Sites    []PanelSite `json:"sites"`
+}
+
+// PanelPool is a pool to create with the settings read from the panel's
+// PHP-FPM pool
+type PanelPool struct {
+	PHPVersion string                 `json:"php_version"`
+	Settings   map[string]interface{} `json:"settings"`
+	// Source is the panel's pool file the settings come from
+	Source string `json:"source,omitempty"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PanelSite is a domain of the account served by the pool of its version
+type PanelSite struct {
+	Domain       string `json:"domain"`
+	DocumentRoot string `json:"document_root"`
+	PHPVersion   string `json:"php_version"`
+	Result       string `json:"result,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// fpmPoolSettings maps PHP-FPM pool directives to pool settings
+var fpmPoolSettings = map[string]string{
+	"pm":                      "process_manager",
+	"pm.max_children":         "max_children",
+	"pm.start_servers":        "start_servers",
+	"pm.min_spare_servers":    "min_spare_servers",
+	"pm.max_spare_servers":    "max_spare_servers",
+	"pm.max_requests":         "max_requests",
+	"pm.process_idle_timeout": "process_idle_timeout",
+}
+
+// fpmINISettings maps php.ini directives set in a pool file with
+// php_value, php_admin_value or their _flag variants to pool settings
+var fpmINISettings = map[string]string{
+	"memory_limit":        "memory_limit",
+	"max_execution_time":  "max_execution_time",
+	"upload_max_filesize": "upload_max_filesize",
+	"post_max_size":       "post_max_size",
+	"display_errors":      "display_errors",
+	"log_errors":          "log_errors",
+	"date.timezone":       "date_timezone",
+	"sendmail_path":       "sendmail_path",
+	"allow_url_fopen":     "allow_url_fopen",
+}
+
+// fpmNumericSettings are stored as numbers
+var fpmNumericSettings = map[string]bool{
+	"max_children": true, "start_servers": true, "min_spare_servers": true, "max_spare_servers": true, "max_requests": true,
+}
+
+var fpmINIPattern = regexp.MustCompile(`^php(?:_admin)?_(?:value|flag)\[([^\]]+)\]$`)
+
+// ScanPanel reads the PHP handlers and domains of a cPanel (EasyApache 4
+// MultiPHP) or Plesk server. Nothing is changed; see ImportPanel.
+func ScanPanel(panel string) (*PanelImport, error) {
+	plan := &PanelImport{Panel: panel, Accounts: make([]PanelAccount, 0), Skipped: make([]string, 0)}
+	var err error
+	switch panel {
+	case PanelCPanel:
+		err = plan.scanCPanel()
+	case PanelPlesk:
+		err = plan.scanPlesk()
+	default:
+		return nil, fmt.Errorf("unknown panel %q; supported: %s, %s", panel, PanelCPanel, PanelPlesk)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(plan.Accounts, func(i, j int) bool { return plan.Accounts[i].Username < plan.Accounts[j].Username })
+	return plan, nil
+}
+
+// scanCPanel reads /var/cpanel/userdata/<user>/<domain>, whose phpversion
+// names the ea-php package serving the domain, and takes the pool settings
+// from the domain's ea-php PHP-FPM pool
+func (plan *PanelImport) scanCPanel() error {
+	users, err := os.ReadDir(hostPath(cpanelUserdataDir))
+	if err != nil {
+		return fmt.Errorf("failed to read cPanel user data (is this a cPanel server?): %w", err)
+	}
+	defaultPackage := readYAMLFields(hostPath(cpanelEA4Config))["default"]
+
+	for _, u := range users {
+		if !u.IsDir() || u.Name() == "nobody" {
+			continue
+		}
+		username := u.Name()
+		if err := validation.Username(username); err != nil {
+			plan.skip("cPanel user %s: %v", username, err)
+			continue
+		}
+		dir := filepath.Join(hostPath(cpanelUserdataDir), username)
+		addons := cpanelAddonDomains(filepath.Join(dir, "main"))
+
+		account := PanelAccount{Username: username, Pools: make([]PanelPool, 0), Sites: make([]PanelSite, 0)}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			plan.skip("cPanel user %s: %v", username, err)
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || name == "main" || strings.Contains(name, ".cache") || strings.HasSuffix(name, "_SSL") || strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".yaml") {
+				continue
+			}
+			fields := readYAMLFields(filepath.Join(dir, name))
+			servername := fields["servername"]
+			if servername == "" || fields["documentroot"] == "" {
+				continue
+			}
+			domain := servername
+			if addon, ok := addons[servername]; ok {
+				domain = addon
+			}
+			phpPackage := fields["phpversion"]
+			if phpPackage == "" || phpPackage == "inherit" {
+				phpPackage = defaultPackage
+			}
+			version, ok := cpanelPHPVersion(phpPackage)
+			if !ok {
+				plan.skip("%s: PHP handler %q is not an ea-php or alt-php package", domain, phpPackage)
+				continue
+			}
+			poolFile := filepath.Join(fmt.Sprintf(cpanelFPMPools, phpPackage), servername+".conf")
+			plan.addSite(&account, domain, fields["documentroot"], version, poolFile)
+		}
+		plan.addAccount(account)
+	}
+	return nil
+}
+
+// scanPlesk reads the domains under /var/www/vhosts/system. A domain served
+// by PHP-FPM has a pool named after it in the pool directory of a Plesk PHP
+// version, whose user is the subscription's system user.
+func (plan *PanelImport) scanPlesk() error {
+	domains, err := os.ReadDir(hostPath(pleskSystemDir))
+	if err != nil {
+		return fmt.Errorf("failed to read Plesk domains (is this a Plesk server?): %w", err)
+	}
+	versions, _ := os.ReadDir(hostPath(pleskPHPDir))
+
+	accounts := make(map[string]*PanelAccount)
+	var order []string
+	for _, d := range domains {
+		if !d.IsDir() {
+			continue
+		}
+		domain := d.Name()
+		var poolFile, version string
+		for _, v := range versions {
+			candidate := filepath.Join(pleskPHPDir, v.Name(), "etc/php-fpm.d", domain+".conf")
+			if _, err := os.Stat(hostPath(candidate)); err == nil {
+				poolFile, version = candidate, v.Name()
+				break
+			}
+		}
+		if poolFile == "" {
+			plan.skip("%s: not served by a Plesk PHP-FPM version", domain)
+			continue
+		}
+		username := readFPMPoolFile(hostPath(poolFile))["user"]
+		if username == "" {
+			plan.skip("%s: %s names no user", domain, poolFile)
+			continue
+		}
+		if err := validation.Username(username); err != nil {
+			plan.skip("%s: system user %s: %v", domain, username, err)
+			continue
+		}
+
+		account, ok := accounts[username]
+		if !ok {
+			account = &PanelAccount{Username: username, Pools: make([]PanelPool, 0), Sites: make([]PanelSite, 0)}
+			accounts[username] = account
+			order = append(order, username)
+		}
+		plan.addSite(account, domain, pleskDocumentRoot(domain), version, poolFile)
+	}
+	for _, username := range order {
+		plan.addAccount(*accounts[username])
+	}
+	return nil
+}
+
+// addSite adds a domain to an account, and the pool of its PHP version with
+// the settings of the panel's pool file if the account has none yet
+func (plan *PanelImport) addSite(account *PanelAccount, domain, documentRoot, version, poolFile string) {
+	if err := validation.Domain(domain); err != nil {
+		plan.skip("%s: %v", domain, err)
+		return
+	}
+	if !filepath.IsAbs(documentRoot) {
+		plan.skip("%s: document root %q is not an absolute path", domain, documentRoot)
+		return
+	}
+	account.Sites = append(account.Sites, PanelSite{Domain: domain, DocumentRoot: documentRoot, PHPVersion: version})
+	for _, p := range account.Pools {
+		if p.PHPVersion == version {
+			return
+		}
+	}
+	pool := PanelPool{PHPVersion: version, Settings: make(map[string]interface{})}
+	if directives := readFPMPoolFile(hostPath(poolFile)); directives != nil {
+		pool.Source = poolFile
+		pool.Settings = plan.poolSettings(domain, directives)
+	}
+	account.Pools = append(account.Pools, pool)
+}
+
+func (plan *PanelImport) addAccount(account PanelAccount) {
+	if len(account.Sites) == 0 {
+		return
+	}
+	sort.Slice(account.Pools, func(i, j int) bool { return account.Pools[i].PHPVersion < account.Pools[j].PHPVersion })
+	sort.Slice(account.Sites, func(i, j int) bool { return account.Sites[i].Domain < account.Sites[j].Domain })
+	plan.Accounts = append(plan.Accounts, account)
+}
+
+func (plan *PanelImport) skip(format string, args ...interface{}) {
+	plan.Skipped = append(plan.Skipped, fmt.Sprintf(format, args...))
+}
+
+// poolSettings converts the directives of a panel's pool file to pool
+// settings. Values the pool template does not accept are left out and
+// reported.
+func (plan *PanelImport) poolSettings(domain string, directives map[string]string) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for directive, value := range directives {
+		key, ok := fpmPoolSettings[directive]
+		if !ok {
+			m := fpmINIPattern.FindStringSubmatch(directive)
+			if m == nil {
+				continue
+			}
+			if key, ok = fpmINISettings[m[1]]; !ok {
+				continue
+			}
+		}
+		var setting interface{} = value
+		if fpmNumericSettings[key] {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				plan.skip("%s: %s = %s is not a number", domain, directive, value)
+				continue
+			}
+			setting = float64(n)
+		}
+		single := map[string]interface{}{key: setting}
+		if err := normalizeSettings(single); err != nil {
+			plan.skip("%s: %s = %s: %v", domain, directive, value, err)
+			continue
+		}
+		settings[key] = single[key]
+	}
+	return settings
+}
+
+// ImportPanel creates the pools and sites of a scan that do not exist yet,
+// under the provider's layout, and records the outcome of each in plan.
+// With apply unset it only checks what would happen. Failures are recorded
+// and the import carries on with the next pool or site; sites whose pool
+// failed are not created.
+func (pm *PoolManager) ImportPanel(plan *PanelImport, providerType string, apply bool) error {
+	phpProvider, err := pm.resolveProvider(providerType)
+	if err != nil {
+		return err
+	}
+	installed, err := phpProvider.ListInstalledPHP()
+	if err != nil {
+		return fmt.Errorf("failed to list installed PHP versions: %w", err)
+	}
+	sm := NewSiteManagerWithDeps(pm.db).WithContext(pm.context())
+
+	for i := range plan.Accounts {
+		account := &plan.Accounts[i]
+		failed := make(map[string]bool)
+		for j := range account.Pools {
+			pool := &account.Pools[j]
+			if err := pm.importPanelPool(account.Username, pool, providerType, installed, apply); err != nil {
+				pool.Result, pool.Error = PanelFailed, err.Error()
+				failed[pool.PHPVersion] = true
+			}
+		}
+		for j := range account.Sites {
+			site := &account.Sites[j]
+			existing, err := pm.db.GetSite(site.Domain)
+			switch {
+			case err != nil:
+				site.Result, site.Error = PanelFailed, fmt.Sprintf("failed to check site: %v", err)
+			case existing != nil:
+				site.Result = PanelExists
+			case failed[site.PHPVersion]:
+				site.Result, site.Error = PanelFailed, fmt.Sprintf("pool for PHP %s was not created", site.PHPVersion)
+			case !apply:
+				site.Result = PanelCreate
+			default:
+				if _, err := sm.CreateSite(site.Domain, account.Username, site.DocumentRoot, site.PHPVersion); err != nil {
+					site.Result, site.Error = PanelFailed, err.Error()
+				} else {
+					site.Result = PanelCreated
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// importPanelPool creates one pool of a panel import and sets pool.Result
+// unless it fails
+func (pm *PoolManager) importPanelPool(username string, pool *PanelPool, providerType string, installed []string, apply bool) error {
+	existing, err := pm.db.GetPoolByUsernameAndVersion(username, pool.PHPVersion)
+	if err != nil {
+		return fmt.Errorf("failed to check pool: %w", err)
+	}
+	if existing != nil {
+		pool.Result = PanelExists
+		return nil
+	}
+	if !containsString(installed, pool.PHPVersion) {
+		return fmt.Errorf("PHP %s is not installed for provider %s; install it and import again", pool.PHPVersion, providerType)
+	}
+	if _, err := pm.target.LookupUser(username); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+	}
+	if !apply {
+		pool.Result = PanelCreate
+		return nil
+	}
+
+	if err := pm.CreatePool(username, pool.PHPVersion, providerType); err != nil {
+		return err
+	}
+	pool.Result = PanelCreated
+	if len(pool.Settings) > 0 {
+		if err := pm.initPoolSettings(username, pool.Settings); err != nil {
+			return fmt.Errorf("pool created but applying its settings failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// cpanelPHPVersion maps an EasyApache 4 package such as ea-php81, or a
+// CloudLinux alt-php81, to its PHP version
+func cpanelPHPVersion(pkg string) (string, bool) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(pkg, "ea-php"), "alt-php")
+	if digits == pkg || len(digits) < 2 {
+		return "", false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	return digits[:1] + "." + digits[1:], true
+}
+
+// cpanelAddonDomains maps the subdomain cPanel serves each addon domain
+// under to the addon domain, from the addon_domains map of a user's main
+// file
+func cpanelAddonDomains(path string) map[string]string {
+	addons := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return addons
+	}
+	defer f.Close()
+	inAddons := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") {
+			inAddons = strings.TrimSpace(line) == "addon_domains:"
+			continue
+		}
+		if !inAddons {
+			continue
+		}
+		if addon, sub, ok := strings.Cut(strings.TrimSpace(line), ":"); ok {
+			addons[strings.TrimSpace(sub)] = strings.TrimSpace(addon)
+		}
+	}
+	return addons
+}
+
+// readYAMLFields reads the top-level "key: value" scalars of the simple
+// YAML files cPanel writes; nested values are ignored
+func readYAMLFields(path string) map[string]string {
+	fields := make(map[string]string)
+	f, err := os.Open(path)
+	if err != nil {
+		return fields
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '-' || line[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `'"`)
+	}
+	return fields
+}
+
+// readFPMPoolFile returns the directives of a PHP-FPM pool file, or nil if
+// it cannot be read
+func readFPMPoolFile(path string) map[string]string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	directives := make(map[string]string)
+	for _, line := range splitLines(string(content)) {
+		if key, value, ok := fpmDirective(strings.TrimSpace(line)); ok {
+			directives[key] = value
+		}
+	}
+	return directives
+}
+
+// pleskDocumentRoot reads the document root from the nginx configuration
+// Plesk generates for a domain, falling back to its default httpdocs
+func pleskDocumentRoot(domain string) string {
+	content, err := os.ReadFile(hostPath(filepath.Join(pleskSystemDir, domain, "conf/nginx.conf")))
+	if err == nil {
+		for _, line := range splitLines(string(content)) {
+			fields := strings.Fields(strings.TrimSpace(line))
+			if len(fields) == 2 && fields[0] == "root" {
+				return strings.Trim(strings.TrimSuffix(fields[1], ";"), `"`)
+			}
+		}
+	}
+	return filepath.Join(pleskVhostsDir, domain, pleskDocrootDir)
+}
@@ -0,0 +1,373 @@
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+)
+
+// Ways InstallExtension installed an extension
+const (
+	ExtensionFromPackage = "package"
+	ExtensionFromSource  = "source"
+)
+
+const (
+	// peclDownloadURL serves the source of a PECL release as NAME or
+	// NAME-VERSION
+	peclDownloadURL = "https://pecl.php.net/get/"
+	// extensionBuildDir holds the build directories of PECL extensions,
+	// one per build, removed afterwards
+	extensionBuildDir = "/var/tmp/lightweight-php-build"
+	// extensionBuildUser compiles the source, so that a build script cannot
+	// change the system
+	extensionBuildUser = "nobody"
+	// maxExtensionSource caps the unpacked size of a source archive
+	maxExtensionSource = 256 << 20
+)
+
+// zendExtensions are loaded with zend_extension instead of extension
+var zendExtensions = map[string]bool{"xdebug": true, "opcache": true}
+
+var extensionSpecPattern = regexp.MustCompile(`^([a-z][a-z0-9_]*)(?:-([0-9][0-9A-Za-z.]*))?$`)
+
+// ValidateExtensionSpec checks a PECL extension name, which may pin a
+// release only when it is built from source
+func ValidateExtensionSpec(spec string, buildFromSource bool) error {
+	_, _, err := parseExtensionSpec(spec, buildFromSource)
+	return err
+}
+
+func parseExtensionSpec(spec string, buildFromSource bool) (name, release string, err error) {
+	m := extensionSpecPattern.FindStringSubmatch(spec)
+	if m == nil {
+		return "", "", fmt.Errorf("invalid extension %q; expected a PECL name such as swoole, optionally with a release (swoole-5.1.2)", spec)
+	}
+	if m[2] != "" && !buildFromSource {
+		return "", "", fmt.Errorf("release %s of %s can only be built from source", m[2], m[1])
+	}
+	return m[1], m[2], nil
+}
+
+// ExtensionStatus reports how InstallExtension installed an extension
+type ExtensionStatus struct {
+	Extension string `json:"extension"`
+	// Method is "package" or "source"
+	Method string `json:"method"`
+	// Release is the PECL release built from source
+	Release string `json:"release,omitempty"`
+	Path    string `json:"path,omitempty"`
+	INIPath string `json:"ini_path,omitempty"`
+}
+
+// InstallExtension installs a PECL extension such as "swoole" for a PHP
+// version from the provider's binary package. If there is none and
+// buildFromSource is set, it is built from the PECL source instead (spec may
+// then pin a release, as in "swoole-5.1.2"): the provider's development
+// package and a compiler are installed, the source is compiled by an
+// unprivileged user in a scratch directory, and the module is copied to the
+// extension dir and enabled with an ini snippet.
+func (pm *PackageManager) InstallExtension(version string, providerType provider.ProviderType, spec string, buildFromSource bool) (_ *ExtensionStatus, err error) {
+	defer recordAudit(pm.context(), pm.db, "php.extension", version, &err)
+	name, release, err := parseExtensionSpec(spec, buildFromSource)
+	if err != nil {
+		return nil, err
+	}
+	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	l, err := pm.locks.Acquire(lock.KeyPackageManager, fmt.Sprintf("install extension %s for php %s (%s)", name, version, providerType), !pm.noWait, installLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	t := pm.providerFactory.Target()
+	var packageErr error
+	if release == "" {
+		packageErr = recordInstall(pm.db, pm.providerFactory, providerType, version, "extension "+name, pm.progress, func(p provider.PHPProvider) error {
+			return p.InstallExtension(version, name)
+		})
+		if packageErr == nil {
+			status := &ExtensionStatus{Extension: name, Method: ExtensionFromPackage}
+			if err := reloadFPMIn(pm.context(), t, phpProvider, version); err != nil {
+				return status, fmt.Errorf("failed to reload PHP-FPM: %w", err)
+			}
+			return status, nil
+		}
+	}
+	if !buildFromSource {
+		return nil, fmt.Errorf("no %s package for %s installs: %w; it can be built from the PECL source instead (--build-from-source)", providerType, name, packageErr)
+	}
+
+	if err := recordInstall(pm.db, pm.providerFactory, providerType, version, "build tools", pm.progress, func(p provider.PHPProvider) error {
+		return p.InstallBuildTools(version)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to install build tools: %w", err)
+	}
+	status, err := buildExtension(t, phpProvider, version, name, release)
+	if err != nil {
+		return nil, err
+	}
+	if err := reloadFPMIn(pm.context(), t, phpProvider, version); err != nil {
+		return status, fmt.Errorf("failed to reload PHP-FPM: %w", err)
+	}
+	return status, nil
+}
+
+// buildExtension compiles a PECL release against a PHP version, installs
+// the module and enables it. The ini is removed again if PHP does not load
+// the module.
+func buildExtension(t target.Target, phpProvider provider.PHPProvider, version, name, release string) (*ExtensionStatus, error) {
+	binary := phpProvider.GetBinaryPath(version)
+	if binary == "" {
+		return nil, fmt.Errorf("extensions for the %s provider must be built into the PHP %s image", phpProvider.GetProviderType(), version)
+	}
+	phpize, phpConfig := siblingTool(binary, "phpize"), siblingTool(binary, "php-config")
+	extensionDir, err := phpIniValue(t, binary, "extension_dir")
+	if err != nil {
+		return nil, err
+	}
+	builder, err := t.LookupUser(extensionBuildUser)
+	if err != nil {
+		return nil, fmt.Errorf("build user %s does not exist: %w", extensionBuildUser, err)
+	}
+	uid, _ := strconv.Atoi(builder.Uid)
+	gid, _ := strconv.Atoi(builder.Gid)
+	hostUID, hostGID, err := t.HostIDs(uid, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	hostBuildRoot, err := t.Path(extensionBuildDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(hostBuildRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create build directory: %w", err)
+	}
+	hostDir, err := os.MkdirTemp(hostBuildRoot, name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build directory: %w", err)
+	}
+	defer os.RemoveAll(hostDir)
+	dir := filepath.Join(extensionBuildDir, filepath.Base(hostDir))
+
+	source := name
+	if release != "" {
+		source += "-" + release
+	}
+	srcName, err := downloadExtensionSource(peclDownloadURL+source, hostDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := chownTree(hostDir, hostUID, hostGID); err != nil {
+		return nil, err
+	}
+
+	srcDir := filepath.Join(dir, srcName)
+	steps := [][]string{
+		{phpize},
+		{"./configure", "--with-php-config=" + phpConfig},
+		{"make", "-j" + strconv.Itoa(runtime.NumCPU())},
+	}
+	for _, step := range steps {
+		args := append([]string{"-u", extensionBuildUser, "--", "env", "-C", srcDir}, step...)
+		if output, err := t.Command("runuser", args...).CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%s failed: %w\n%s", filepath.Base(step[0]), err, lastLines(string(output), 20))
+		}
+	}
+
+	modules, _ := filepath.Glob(filepath.Join(hostDir, srcName, "modules", "*.so"))
+	if len(modules) != 1 {
+		return nil, fmt.Errorf("the build of %s produced %d modules instead of one", srcName, len(modules))
+	}
+	module := filepath.Base(modules[0])
+	soPath := filepath.Join(extensionDir, module)
+	hostSOPath, err := t.Path(soPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := copyFile(modules[0], hostSOPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to install %s: %w", module, err)
+	}
+
+	extension := strings.TrimSuffix(module, ".so")
+	directive := "extension"
+	if zendExtensions[extension] {
+		directive = "zend_extension"
+	}
+	iniPath := filepath.Join(phpProvider.GetConfDir(version), "50-lightweight-php-"+extension+".ini")
+	hostINIPath, err := t.Path(iniPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(hostINIPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create conf directory: %w", err)
+	}
+	ini := fmt.Sprintf("; Managed by lightweight-php: built from PECL %s\n%s=%s\n", srcName, directive, module)
+	if err := os.WriteFile(hostINIPath, []byte(ini), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write extension config: %w", err)
+	}
+
+	output, err := t.Command(binary, "-m").CombinedOutput()
+	if err != nil || !containsFold(splitLines(string(output)), extension) {
+		os.Remove(hostINIPath)
+		return nil, fmt.Errorf("%s did not load in PHP %s: %s", extension, version, strings.TrimSpace(string(output)))
+	}
+
+	return &ExtensionStatus{
+		Extension: extension,
+		Method:    ExtensionFromSource,
+		Release:   strings.TrimPrefix(srcName, name+"-"),
+		Path:      soPath,
+		INIPath:   iniPath,
+	}, nil
+}
+
+// downloadExtensionSource unpacks a PECL source archive into dir and
+// returns the directory holding config.m4. Entries leaving dir, links and
+// devices are refused.
+func downloadExtensionSource(url, dir string) (string, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	defer gz.Close()
+
+	var written int64
+	srcName := ""
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", url, err)
+		}
+		name := filepath.Clean(hdr.Name)
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return "", fmt.Errorf("%s contains an entry outside its directory: %s", url, hdr.Name)
+		}
+		dest := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return "", err
+			}
+		case tar.TypeReg:
+			if written += hdr.Size; written > maxExtensionSource {
+				return "", fmt.Errorf("%s unpacks to more than %d MiB", url, maxExtensionSource>>20)
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return "", err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0755|0600)
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(f, io.LimitReader(tr, hdr.Size))
+			f.Close()
+			if err != nil {
+				return "", fmt.Errorf("failed to unpack %s: %w", hdr.Name, err)
+			}
+			if filepath.Base(name) == "config.m4" && strings.Count(name, string(filepath.Separator)) == 1 {
+				srcName = filepath.Dir(name)
+			}
+		}
+	}
+	if srcName == "" {
+		return "", fmt.Errorf("%s is not the source of a PHP extension: no config.m4", url)
+	}
+	return srcName, nil
+}
+
+// siblingTool returns the path of phpize or php-config next to a PHP CLI
+// binary, keeping a version suffix as in /usr/bin/php8.2 -> phpize8.2
+func siblingTool(binary, tool string) string {
+	base := filepath.Base(binary)
+	return filepath.Join(filepath.Dir(binary), strings.Replace(base, "php", tool, 1))
+}
+
+func chownTree(root string, uid, gid int) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(p, uid, gid); err != nil {
+			return fmt.Errorf("failed to hand the build directory to %s: %w", extensionBuildUser, err)
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dest + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// containsFold reports whether list holds value, ignoring case and spaces
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastLines returns at most the last n lines of s
+func lastLines(s string, n int) string {
+	lines := splitLines(strings.TrimSpace(s))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,25 +1,50 @@
 package manager
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"lightweight-php/config"
 	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/lock"
 	"lightweight-php/provider"
+	"lightweight-php/servicemgr"
 	"lightweight-php/system"
+	"lightweight-php/target"
 	"lightweight-php/templates"
+	"lightweight-php/tracing"
+	"lightweight-php/validation"
 )
 
 type Pool struct {
+	// ID is stable for the life of the pool
+	ID         int64
 	User       string
 	PHPVersion string
 	Provider   string
 	Status     string
 	ConfigPath string
 	SocketPath string
+	Target     string
+	// Tenant is the logical environment the pool belongs to, if any
+	Tenant string
+	// Labels are free-form key/value tags used to select pools
+	Labels map[string]string
+	// DiskUsage is only filled in for a single pool with a disk_quota
+	DiskUsage *DiskUsage `json:",omitempty"`
+	// Suspension is set while the account is suspended
+	Suspension *Suspension `json:",omitempty"`
+	// UpdatedAt is when the pool's row last changed
+	UpdatedAt time.Time
 }
 
 type PoolManager struct {
@@ -27,6 +52,86 @@ type PoolManager struct {
 	fpmDir         string
 	db             *db.Database
 	providerFactory *provider.ProviderFactory
+	locks          *lock.Manager
+	noWait         bool
+	// pendingReloads collects services to reload once at the end of a batch
+	pendingReloads map[fpmService]bool
+	// target is where new pools are created; existing pools keep theirs
+	target target.Target
+	// tenant is the tenant new pools are created in
+	tenant string
+	// ctx carries the span that operations are traced under
+	ctx context.Context
+}
+
+// fpmService is a PHP-FPM service on an execution target
+type fpmService struct {
+	target target.Target
+	name   string
+	// strategy is the reload strategy of the service's provider
+	strategy string
+}
+
+const (
+	// poolLockTimeout bounds how long pool operations wait for each other
+	poolLockTimeout = 2 * time.Minute
+	// serviceLockTimeout bounds how long a reload waits for another reload
+	serviceLockTimeout = time.Minute
+)
+
+// WithNoWait returns a copy of the manager whose operations fail with
+// lock.ErrBusy instead of waiting when a conflicting operation is running
+func (pm *PoolManager) WithNoWait() *PoolManager {
+	c := *pm
+	c.noWait = true
+	return &c
+}
+
+// WithTarget returns a copy of the manager that creates pools inside t
+// instead of on the host
+func (pm *PoolManager) WithTarget(t target.Target) *PoolManager {
+	c := *pm
+	c.target = t
+	return &c
+}
+
+// WithContext returns a copy of the manager whose operations are traced as
+// children of the span in ctx, such as the span of an API request
+func (pm *PoolManager) WithContext(ctx context.Context) *PoolManager {
+	c := *pm
+	c.ctx = ctx
+	c.providerFactory = pm.providerFactory.WithContext(ctx)
+	return &c
+}
+
+func (pm *PoolManager) context() context.Context {
+	if pm.ctx == nil {
+		return context.Background()
+	}
+	return pm.ctx
+}
+
+// poolTarget returns the execution target recorded for a pool and a
+// provider factory operating inside it
+func (pm *PoolManager) poolTarget(dbPool *db.Pool) (target.Target, *provider.ProviderFactory, error) {
+	t, err := target.Parse(dbPool.Target)
+	if err != nil {
+		return t, nil, err
+	}
+	factory, err := pm.providerFactory.ForTarget(t)
+	if err != nil {
+		return t, nil, fmt.Errorf("target %s is not available: %w", dbPool.Target, err)
+	}
+	return t, factory, nil
+}
+
+func (pm *PoolManager) acquire(key, operation string) (*lock.Lock, error) {
+	_, span := tracing.Start(pm.context(), "lock.acquire")
+	span.SetAttr("lock.key", key)
+	l, err := pm.locks.Acquire(key, operation, !pm.noWait, poolLockTimeout)
+	span.SetError(err)
+	span.End()
+	return l, err
 }
 
 // GetDatabase returns the database instance (for API access)
@@ -41,8 +146,17 @@ func NewPoolManager() (*PoolManager, error) {
 	}
 
 	detector := system.NewOSDetector()
-	osFamily, _ := detector.Detect()
+	osFamily, err := detector.Detect()
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return NewPoolManagerWithDeps(database, osFamily, provider.NewProviderFactoryWithDeps(database, osFamily)), nil
+}
 
+// NewPoolManagerWithDeps creates a pool manager on shared dependencies
+func NewPoolManagerWithDeps(database *db.Database, osFamily system.OSFamily, providerFactory *provider.ProviderFactory) *PoolManager {
 	var fpmDir string
 	if osFamily == system.OSRHEL {
 		fpmDir = "/etc/php-fpm.d"
@@ -50,24 +164,60 @@ func NewPoolManager() (*PoolManager, error) {
 		fpmDir = "/etc/php/*/fpm/pool.d"
 	}
 
-	providerFactory, err := provider.NewProviderFactory()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize provider factory: %w", err)
-	}
-
 	return &PoolManager{
 		osFamily:        osFamily,
 		fpmDir:          fpmDir,
 		db:              database,
 		providerFactory: providerFactory,
-	}, nil
+		locks:           lock.Default,
+	}
 }
 
-func (pm *PoolManager) CreatePool(username, phpVersion, providerType string) error {
+func (pm *PoolManager) CreatePool(username, phpVersion, providerType string) (err error) {
+	ctx, span := tracing.Start(pm.context(), "pool.create")
+	span.SetAttr("pool.user", username)
+	span.SetAttr("php.version", phpVersion)
+	span.SetAttr("php.provider", providerType)
+	if pm.tenant != "" {
+		span.SetAttr("pool.tenant", pm.tenant)
+	}
+	defer span.Finish(&err)
+	defer recordAudit(pm.context(), pm.db, "pool.create", username, &err)
+	pm = pm.WithContext(ctx)
+
+	var invalid validation.Errors
+	invalid.Check("username", username, validation.Username)
+	invalid.Check("php_version", phpVersion, validation.PHPVersion)
+	if err := invalid.Err(); err != nil {
+		return err
+	}
+	if err := pm.checkTenant(pm.tenant, phpVersion); err != nil {
+		return err
+	}
+
+	// Drained hosts do not accept new pools
+	if state, err := GetHostDrainState(); err != nil {
+		return err
+	} else if state != nil {
+		return fmt.Errorf("host is drained since %s; run 'host undrain' to accept new pools", state.DrainedAt.Format("2006-01-02 15:04:05"))
+	}
+
+	l, err := pm.acquire(lock.PoolKey(username), "create pool "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	t := pm.target
+	factory, err := pm.providerFactory.ForTarget(t)
+	if err != nil {
+		return fmt.Errorf("target %s is not available: %w", t, err)
+	}
+
 	// Verify user exists
-	_, err := user.Lookup(username)
+	u, err := t.LookupUser(username)
 	if err != nil {
-		return fmt.Errorf("user %s does not exist: %w", username, err)
+		return fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
 	}
 
 	// Validate provider type
@@ -81,22 +231,33 @@ func (pm *PoolManager) CreatePool(username, phpVersion, providerType string) err
 		providerTypeEnum = provider.ProviderAltPHP
 	case "docker":
 		providerTypeEnum = provider.ProviderDocker
+	case "system":
+		providerTypeEnum = provider.ProviderSystem
 	default:
-		return fmt.Errorf("invalid provider type: %s. Supported: remi, lsphp, alt-php, docker", providerType)
+		return fmt.Errorf("invalid provider type: %s. Supported: remi, lsphp, alt-php, docker, system", providerType)
 	}
 
 	// Get provider instance
-	phpProvider, err := pm.providerFactory.CreateProvider(providerTypeEnum)
+	phpProvider, err := factory.CreateProvider(providerTypeEnum)
 	if err != nil {
 		return fmt.Errorf("failed to create provider: %w", err)
 	}
 
-	// Get paths from provider
+	// Get paths from provider, as seen inside the target
 	socketPath := phpProvider.GetSocketPath(username, phpVersion)
 	configPath := phpProvider.GetConfigPath(username, phpVersion)
 
+	hostConfigPath, err := t.Path(configPath)
+	if err != nil {
+		return err
+	}
+	hostSocketPath, err := t.Path(socketPath)
+	if err != nil {
+		return err
+	}
+
 	// Get pool directory from config path
-	poolDir := filepath.Dir(configPath)
+	poolDir := filepath.Dir(hostConfigPath)
 
 	// Create pool directory if it doesn't exist
 	if err := os.MkdirAll(poolDir, 0755); err != nil {
@@ -104,87 +265,214 @@ func (pm *PoolManager) CreatePool(username, phpVersion, providerType string) err
 	}
 
 	// Check if pool already exists
-	if _, err := os.Stat(configPath); err == nil {
-		return fmt.Errorf("pool for user %s with PHP %s and provider %s already exists", username, phpVersion, providerType)
+	if _, err := os.Stat(hostConfigPath); err == nil {
+		return fmt.Errorf("%w: user %s with PHP %s and provider %s", ErrPoolExists, username, phpVersion, providerType)
 	}
 
-	// Get user info
-	u, err := user.Lookup(username)
-	if err != nil {
-		return fmt.Errorf("failed to lookup user: %w", err)
-	}
 	uid := u.Uid
 	gid := u.Gid
 
 	// Create pool configuration using template
-	config, err := pm.generatePoolConfig(username, uid, gid, socketPath, phpVersion)
+	_, renderSpan := tracing.Start(ctx, "pool.render_config")
+	config, err := pm.generatePoolConfig(t, username, gid, socketPath)
+	renderSpan.SetError(err)
+	renderSpan.End()
 	if err != nil {
 		return fmt.Errorf("failed to generate pool config: %w", err)
 	}
 
 	// Write pool configuration
-	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+	if err := os.WriteFile(hostConfigPath, []byte(config), 0644); err != nil {
 		return fmt.Errorf("failed to write pool config: %w", err)
 	}
 
 	// Create socket directory
-	socketDir := filepath.Dir(socketPath)
+	socketDir := filepath.Dir(hostSocketPath)
 	if err := os.MkdirAll(socketDir, 0755); err != nil {
 		return fmt.Errorf("failed to create socket directory: %w", err)
 	}
 
-	// Ensure PHP version exists in database (required for foreign key constraint)
-	phpVersionRecord, err := pm.db.GetPHPVersion(phpVersion)
+	// Create per-user session and tmp directories
+	_, dirSpan := tracing.Start(ctx, "pool.provision_dirs")
+	err = provisionPoolDirs(t, username, uid, gid)
+	dirSpan.SetError(err)
+	dirSpan.End()
 	if err != nil {
-		return fmt.Errorf("failed to check PHP version: %w", err)
+		os.Remove(hostConfigPath)
+		return fmt.Errorf("failed to provision pool directories: %w", err)
 	}
-	if phpVersionRecord == nil {
-		// PHP version not registered, create it
-		detector := system.NewOSDetector()
-		osFamily, _ := detector.Detect()
-		var osFamilyStr string
-		if osFamily == system.OSRHEL {
-			osFamilyStr = "rhel"
-		} else {
-			osFamilyStr = "debian"
-		}
-		if err := pm.db.CreatePHPVersion(phpVersion, providerType, osFamilyStr); err != nil {
-			return fmt.Errorf("failed to register PHP version: %w", err)
-		}
+
+	if err := pm.ensurePHPVersion(phpVersion, providerType); err != nil {
+		os.Remove(hostConfigPath)
+		return err
 	}
 
 	// Save to database
-	if err := pm.db.CreatePool(username, phpVersion, providerType, socketPath, configPath); err != nil {
+	if err := pm.db.CreatePool(username, phpVersion, providerType, socketPath, configPath, t.String(), pm.tenant); err != nil {
 		// Rollback: remove config file if database save fails
-		os.Remove(configPath)
+		os.Remove(hostConfigPath)
 		return fmt.Errorf("failed to save pool to database: %w", err)
 	}
 
+	if err := writeCLIWrapper(t, phpProvider, username, phpVersion, config); err != nil {
+		fmt.Printf("Warning: failed to write PHP CLI wrapper: %v\n", err)
+	}
+
+	if err := pm.provisionSFTP(t, username); err != nil {
+		fmt.Printf("Warning: failed to set up the SFTP login: %v\n", err)
+	}
+
+	// lsphp is started by OpenLiteSpeed, which needs an external app for it
+	if providerTypeEnum == provider.ProviderLiteSpeed {
+		data, err := poolRenderData(t, username, socketPath, nil)
+		if err == nil {
+			err = syncLiteSpeedApp(t, phpProvider, phpVersion, data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to configure OpenLiteSpeed: %w", err)
+		}
+	}
+
+	// Docker pools run in a container of their own instead of a service
+	if providerTypeEnum == provider.ProviderDocker {
+		dbPool, err := pm.db.GetPool(username)
+		if err != nil {
+			return fmt.Errorf("failed to get pool from database: %w", err)
+		}
+		if _, err := deployPoolImage(t, phpProvider, dbPool, nil); err != nil {
+			return fmt.Errorf("failed to start the pool's container: %w", err)
+		}
+		return nil
+	}
+
 	// Reload PHP-FPM using provider's service name
-	serviceName := phpProvider.GetServiceName(phpVersion)
-	if err := pm.reloadFPMService(serviceName); err != nil {
+	if err := pm.reloadFPMService(t, phpProvider, phpVersion); err != nil {
 		return fmt.Errorf("failed to reload PHP-FPM: %w", err)
 	}
 
 	return nil
 }
 
-func (pm *PoolManager) DeletePool(username string) error {
-	// Get pool from database to find config file
-	dbPool, err := pm.db.GetPool(username)
+// DeletePool removes the pools of a user: every one, since a user may have
+// one per PHP version and provider. When purgeData is set, the per-user
+// session and tmp directories and the pool's MySQL database are removed as
+// well.
+func (pm *PoolManager) DeletePool(username string, purgeData bool) error {
+	return pm.DeletePoolIfMatch(username, purgeData, nil)
+}
+
+// DeletePoolIfMatch is DeletePool when match accepts the pool as FindPool
+// returns it (nil when there is none), and fails with ErrRevisionMismatch
+// otherwise. match runs under the pool's lock, so the pool cannot change
+// between the check and the delete.
+func (pm *PoolManager) DeletePoolIfMatch(username string, purgeData bool, match func(*Pool) bool) (err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.delete", username, &err)
+	l, err := pm.acquire(lock.PoolKey(username), "delete pool "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	if match != nil {
+		pool, err := pm.FindPool(username)
+		if err != nil {
+			return err
+		}
+		if !match(pool) {
+			return fmt.Errorf("%w: %s", ErrRevisionMismatch, username)
+		}
+	}
+
+	dbPools, err := pm.db.ListUserPools(username)
 	if err != nil {
 		return fmt.Errorf("failed to get pool from database: %w", err)
 	}
-	if dbPool == nil {
-		return fmt.Errorf("pool for user %s not found", username)
+	if len(dbPools) == 0 {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+
+	// The user-wide cleanup below works in the newest pool's target and
+	// lifts the quota any of the pools set
+	t, _, err := pm.poolTarget(&dbPools[0])
+	if err != nil {
+		return err
+	}
+	var quota int64
+	for i := range dbPools {
+		settings, err := pm.poolSettings(&dbPools[i])
+		if err != nil {
+			return err
+		}
+		if q, _ := diskQuotaBytes(settings); q > quota {
+			quota = q
+		}
+	}
+
+	for i := range dbPools {
+		if err := pm.removePool(&dbPools[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := pm.syncCLIWrapper(t, username); err != nil {
+		fmt.Printf("Warning: failed to update PHP CLI wrapper: %v\n", err)
+	}
+
+	if err := pm.removeSFTP(t, username); err != nil {
+		fmt.Printf("Warning: failed to remove the SFTP login: %v\n", err)
+	}
+
+	if err := pm.removePoolWorkers(t, username); err != nil {
+		fmt.Printf("Warning: failed to remove workers: %v\n", err)
+	}
+
+	if err := pm.removePoolCrons(t, username); err != nil {
+		fmt.Printf("Warning: failed to remove cron jobs: %v\n", err)
+	}
+
+	// The user outlives the pool; lift the quota that came with it
+	if quota > 0 {
+		if err := setDiskQuota(t, username, 0); err != nil {
+			fmt.Printf("Warning: failed to remove disk quota: %v\n", err)
+		}
+	}
+
+	if purgeData {
+		if err := removePoolDirs(t, username); err != nil {
+			return fmt.Errorf("failed to purge pool data: %w", err)
+		}
+		if err := pm.dropDatabase(username); err != nil {
+			return fmt.Errorf("failed to drop the pool's database: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// removePool removes one pool of a user: its config file, sockets,
+// container or OpenLiteSpeed app and database row, and reloads its PHP-FPM
+func (pm *PoolManager) removePool(dbPool *db.Pool) error {
+	username := dbPool.Username
+	t, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return err
 	}
 
 	// Remove config file
-	if _, err := os.Stat(dbPool.ConfigPath); err == nil {
-		if err := os.Remove(dbPool.ConfigPath); err != nil {
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(hostConfigPath); err == nil {
+		if err := os.Remove(hostConfigPath); err != nil {
 			return fmt.Errorf("failed to remove pool file: %w", err)
 		}
 	}
+	// A suspended pool's own configuration is set aside next to it
+	os.Remove(hostConfigPath + suspendedSuffix)
+	if addr, err := ParseListen(dbPool.SocketPath); err == nil && addr.IsUnix() {
+		removeSwitchSockets(t, dbPool.SocketPath)
+	}
 
 	// Get provider to reload service
 	var providerTypeEnum provider.ProviderType
@@ -197,97 +485,281 @@ func (pm *PoolManager) DeletePool(username string) error {
 		providerTypeEnum = provider.ProviderAltPHP
 	case "docker":
 		providerTypeEnum = provider.ProviderDocker
+	case "system":
+		providerTypeEnum = provider.ProviderSystem
 	default:
 		// If provider is unknown, skip reload
 		providerTypeEnum = provider.ProviderRemi
 	}
 
-	phpProvider, err := pm.providerFactory.CreateProvider(providerTypeEnum)
-	if err == nil {
-		serviceName := phpProvider.GetServiceName(dbPool.PHPVersion)
-		pm.reloadFPMService(serviceName)
+	if providerTypeEnum == provider.ProviderLiteSpeed {
+		if err := removeLiteSpeedApp(t, username); err != nil {
+			fmt.Printf("Warning: failed to remove the OpenLiteSpeed external app: %v\n", err)
+		}
+	}
+
+	if providerTypeEnum == provider.ProviderDocker {
+		if output, err := t.Command("docker", "rm", "-f", poolContainer(username)).CombinedOutput(); err != nil {
+			fmt.Printf("Warning: failed to remove the pool's container: %v: %s\n", err, strings.TrimSpace(string(output)))
+		}
+	}
+
+	phpProvider, err := factory.CreateProvider(providerTypeEnum)
+	if err == nil && providerTypeEnum != provider.ProviderDocker {
+		pm.reloadFPMService(t, phpProvider, dbPool.PHPVersion)
 	}
 
 	// Remove from database
-	if err := pm.db.DeletePool(username); err != nil {
+	if err := pm.db.DeletePoolByID(dbPool.ID); err != nil {
 		return fmt.Errorf("failed to delete pool from database: %w", err)
 	}
 
+	// Shrink or drop the version's APCu segment; it takes effect on the next reload
+	if phpProvider != nil {
+		if err := pm.syncAPCu(phpProvider, dbPool); err != nil {
+			fmt.Printf("Warning: failed to update APCu config: %v\n", err)
+		}
+	}
+
+	if err := syncPoolFirewall(t, username, dbPool.SocketPath, ""); err != nil {
+		fmt.Printf("Warning: failed to update the firewall: %v\n", err)
+	}
 	return nil
 }
 
+// ensurePHPVersion registers a PHP version in the database if needed; pools
+// reference it by a foreign key
+func (pm *PoolManager) ensurePHPVersion(phpVersion, providerType string) error {
+	phpVersionRecord, err := pm.db.GetPHPVersion(phpVersion)
+	if err != nil {
+		return fmt.Errorf("failed to check PHP version: %w", err)
+	}
+	if phpVersionRecord != nil {
+		return nil
+	}
+	if err := pm.db.CreatePHPVersion(phpVersion, providerType, string(pm.osFamily)); err != nil {
+		return fmt.Errorf("failed to register PHP version: %w", err)
+	}
+	provider.InvalidateInstalled()
+	return nil
+}
+
+// FindPool returns a user's pool as ListPools shows it, the newest of
+// several, or nil when there is none
+func (pm *PoolManager) FindPool(username string) (*Pool, error) {
+	pools, err := pm.ListPools()
+	if err != nil {
+		return nil, err
+	}
+	for i := range pools {
+		if pools[i].User == username {
+			return &pools[i], nil
+		}
+	}
+	return nil, nil
+}
+
 func (pm *PoolManager) ListPools() ([]Pool, error) {
 	dbPools, err := pm.db.ListPools()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list pools from database: %w", err)
 	}
 
+	labels, err := pm.db.ListPoolLabels()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pool labels: %w", err)
+	}
+
+	suspensions, err := pm.db.ListPoolSuspensions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pool suspensions: %w", err)
+	}
+
 	pools := make([]Pool, 0, len(dbPools))
 	for _, dbPool := range dbPools {
 		pools = append(pools, Pool{
+			ID:         dbPool.ID,
 			User:       dbPool.Username,
 			PHPVersion: dbPool.PHPVersion,
 			Provider:   dbPool.Provider,
 			Status:     dbPool.Status,
 			ConfigPath: dbPool.ConfigPath,
 			SocketPath: dbPool.SocketPath,
+			Target:     dbPool.Target,
+			Tenant:     dbPool.Tenant,
+			Labels:     labels[dbPool.ID],
+			UpdatedAt:  dbPool.UpdatedAt,
 		})
+		if s, ok := suspensions[dbPool.ID]; ok {
+			pools[len(pools)-1].Suspension = poolSuspension(&s)
+		}
 	}
 
 	return pools, nil
 }
 
-func (pm *PoolManager) UpdatePoolConfig(username string, settings map[string]interface{}) error {
-	// Get pool from database
+// PoolConfig is the settings last applied to a pool and their revision,
+// which increases on every update
+type PoolConfig struct {
+	Username string
+	Settings map[string]interface{}
+	Revision int64
+}
+
+// AnyRevision makes UpdatePoolConfigIfMatch skip the revision check
+const AnyRevision int64 = -1
+
+// ErrRevisionMismatch is returned when a pool's settings changed since the
+// revision the caller based its update on
+var ErrRevisionMismatch = errors.New("pool configuration was modified by another update")
+
+// ErrPoolNotFound is returned for a user without a pool
+var ErrPoolNotFound = errors.New("pool not found")
+
+// ErrPoolExists is returned when creating a pool the user already has
+var ErrPoolExists = errors.New("pool already exists")
+
+// ErrUserMissing is returned when a pool's system user does not exist
+var ErrUserMissing = errors.New("user does not exist")
+
+// GetPoolConfig returns the stored settings of a pool
+func (pm *PoolManager) GetPoolConfig(username string) (*PoolConfig, error) {
 	dbPool, err := pm.db.GetPool(username)
 	if err != nil {
-		return fmt.Errorf("failed to get pool from database: %w", err)
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
 	}
 	if dbPool == nil {
-		return fmt.Errorf("pool for user %s not found", username)
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
 	}
 
-	// Get user info for group name
-	u, err := user.Lookup(username)
+	raw, revision, err := pm.db.GetPoolSettings(dbPool.ID)
 	if err != nil {
-		return fmt.Errorf("failed to lookup user: %w", err)
+		return nil, fmt.Errorf("failed to get pool settings: %w", err)
 	}
-
-	// Get group name
-	groupName := username
-	if u.Gid != "" {
-		g, err := user.LookupGroupId(u.Gid)
-		if err == nil {
-			groupName = g.Name
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(raw), &settings); err != nil {
+		return nil, fmt.Errorf("failed to decode pool settings: %w", err)
+	}
+	// Settings stored before sizes and durations were normalized are shown
+	// in canonical form too; values that do not parse are left as stored
+	for _, key := range SizeSettings {
+		if v, err := NormalizeSize(key, settings[key]); err == nil {
+			settings[key] = v
+		}
+	}
+	for _, key := range DurationSettings {
+		if v, err := NormalizeDuration(key, settings[key]); err == nil {
+			settings[key] = v
 		}
 	}
 
-	// Load template
-	templateContent, err := templates.LoadTemplate("pool.conf.tmpl")
+	return &PoolConfig{Username: username, Settings: settings, Revision: revision}, nil
+}
+
+// UpdatePoolConfig replaces the pool's settings; keys not in settings return
+// to the template defaults
+func (pm *PoolManager) UpdatePoolConfig(username string, settings map[string]interface{}) error {
+	_, err := pm.UpdatePoolConfigIfMatch(username, settings, AnyRevision)
+	return err
+}
+
+// UpdatePoolConfigIfMatch replaces the settings only if the pool is still at
+// revision (or revision is AnyRevision) and returns the new revision
+func (pm *PoolManager) UpdatePoolConfigIfMatch(username string, settings map[string]interface{}, revision int64) (int64, error) {
+	return pm.updatePoolConfig(username, revision, func(map[string]interface{}) map[string]interface{} {
+		return settings
+	})
+}
+
+// PatchPoolConfig merges patch onto the stored settings. A nil value removes
+// the key so it returns to the template default.
+func (pm *PoolManager) PatchPoolConfig(username string, patch map[string]interface{}) error {
+	_, err := pm.PatchPoolConfigIfMatch(username, patch, AnyRevision)
+	return err
+}
+
+// PatchPoolConfigIfMatch is PatchPoolConfig with a revision check
+func (pm *PoolManager) PatchPoolConfigIfMatch(username string, patch map[string]interface{}, revision int64) (int64, error) {
+	return pm.updatePoolConfig(username, revision, func(current map[string]interface{}) map[string]interface{} {
+		return mergeSettings(current, patch)
+	})
+}
+
+// updatePoolConfig computes the new settings from the stored ones under the
+// pool lock and applies them
+func (pm *PoolManager) updatePoolConfig(username string, revision int64, next func(current map[string]interface{}) map[string]interface{}) (_ int64, err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.update", username, &err)
+	l, err := pm.acquire(lock.PoolKey(username), "update pool "+username)
 	if err != nil {
-		return fmt.Errorf("failed to load template: %w", err)
+		return 0, err
 	}
+	defer l.Release()
 
-	// Create template data with defaults
-	data := templates.DefaultPoolConfigData(username, groupName, dbPool.SocketPath)
+	current, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return 0, err
+	}
+	if revision != AnyRevision && revision != current.Revision {
+		return 0, fmt.Errorf("%w (expected revision %d, current %d)", ErrRevisionMismatch, revision, current.Revision)
+	}
 
-	// Apply custom settings
-	if err := applyPoolSettings(data, settings); err != nil {
-		return fmt.Errorf("failed to apply settings: %w", err)
+	return pm.applyPoolConfig(username, next(current.Settings), current.Revision)
+}
+
+// mergeSettings returns current with patch applied; nil values delete keys.
+// Objects such as env are merged the same way, as in a JSON merge patch.
+func mergeSettings(current, patch map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(current)+len(patch))
+	for key, value := range current {
+		if m, ok := value.(map[string]interface{}); ok {
+			value = mergeSettings(m, nil)
+		}
+		merged[key] = value
+	}
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(merged, key)
+		case map[string]interface{}:
+			base, _ := merged[key].(map[string]interface{})
+			merged[key] = mergeSettings(base, v)
+		default:
+			merged[key] = value
+		}
 	}
+	return merged
+}
 
-	// Render template
-	config, err := templates.RenderPoolConfig(templateContent, data)
+func (pm *PoolManager) applyPoolConfig(username string, settings map[string]interface{}, revision int64) (int64, error) {
+	// Sizes and durations are stored and rendered in canonical form
+	settings = mergeSettings(settings, nil)
+	if err := normalizeSettings(settings); err != nil {
+		return 0, fmt.Errorf("failed to apply settings: %w", err)
+	}
+
+	// Get pool from database
+	dbPool, err := pm.db.GetPool(username)
 	if err != nil {
-		return fmt.Errorf("failed to render template: %w", err)
+		return 0, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return 0, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if dbPool.Status == db.PoolSuspended {
+		return 0, fmt.Errorf("%w: %s", ErrPoolSuspended, username)
 	}
 
-	// Write updated configuration
-	if err := os.WriteFile(dbPool.ConfigPath, []byte(config), 0644); err != nil {
-		return fmt.Errorf("failed to write pool config: %w", err)
+	t, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return 0, err
+	}
+
+	// Load template
+	templateContent, err := templates.LoadTemplate("pool.conf.tmpl")
+	if err != nil {
+		return 0, fmt.Errorf("failed to load template: %w", recordRenderFailure(pm.db, username, nil, err))
 	}
 
-	// Reload PHP-FPM
 	var providerTypeEnum provider.ProviderType
 	switch dbPool.Provider {
 	case "remi":
@@ -298,19 +770,138 @@ func (pm *PoolManager) UpdatePoolConfig(username string, settings map[string]int
 		providerTypeEnum = provider.ProviderAltPHP
 	case "docker":
 		providerTypeEnum = provider.ProviderDocker
+	case "system":
+		providerTypeEnum = provider.ProviderSystem
 	default:
 		providerTypeEnum = provider.ProviderRemi
 	}
 
-	phpProvider, err := pm.providerFactory.CreateProvider(providerTypeEnum)
-	if err == nil {
-		serviceName := phpProvider.GetServiceName(dbPool.PHPVersion)
-		if err := pm.reloadFPMService(serviceName); err != nil {
-			return fmt.Errorf("failed to reload PHP-FPM: %w", err)
+	phpProvider, providerErr := factory.CreateProvider(providerTypeEnum)
+
+	// listen_type may move the pool between a socket and a TCP port
+	listen := dbPool.SocketPath
+	if providerErr == nil {
+		if listen, err = pm.resolveListen(t, dbPool, phpProvider, settings); err != nil {
+			return 0, err
+		}
+	}
+	data, err := poolRenderData(t, username, listen, settings)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data.CustomConfig) > 0 && providerErr == nil {
+		if err := pm.checkCustomConfig(t, phpProvider, dbPool, templateContent, data); err != nil {
+			return 0, err
 		}
 	}
 
-	return nil
+	// drain-and-switch starts the changed pool next to the running one
+	// first; the pool's own file then takes over the new socket
+	var sw *poolSwitch
+	if providerErr == nil && pm.usesDrainAndSwitch(dbPool, listen) {
+		if sw, err = pm.stagePool(t, phpProvider, dbPool, templateContent, data); err != nil {
+			return 0, err
+		}
+		data.SocketPath = sw.next
+	}
+
+	// Render template
+	config, err := templates.RenderPoolConfig(templateContent, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to render template: %w", recordRenderFailure(pm.db, username, data, err))
+	}
+
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode pool settings: %w", err)
+	}
+
+	// The quota is set first so a filesystem without quota support fails
+	// the change before anything is written
+	previous, err := pm.poolSettings(dbPool)
+	if err != nil {
+		return 0, err
+	}
+	if err := syncQuota(t, username, previous, settings); err != nil {
+		return 0, err
+	}
+
+	// Write updated configuration, keeping the file it replaces for the
+	// config history
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return 0, err
+	}
+	previousConfig, previousErr := os.ReadFile(hostConfigPath)
+	if err := writePoolFile(hostConfigPath, config); err != nil {
+		return 0, fmt.Errorf("failed to write pool config: %w", err)
+	}
+
+	newRevision, err := pm.db.SavePoolSettings(dbPool.ID, string(encoded), revision)
+	if err == sql.ErrNoRows {
+		return 0, ErrRevisionMismatch
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to save pool settings: %w", err)
+	}
+	if previousErr == nil {
+		if err := pm.recordConfigVersion(dbPool, newRevision-1, previous, previousConfig); err != nil {
+			fmt.Printf("Warning: failed to record config history: %v\n", err)
+		}
+	}
+	listenChanged := listen != dbPool.SocketPath
+	if listenChanged {
+		if err := pm.db.UpdatePoolListen(dbPool.ID, listen); err != nil {
+			return 0, fmt.Errorf("failed to save pool listen address: %w", err)
+		}
+		if err := syncPoolFirewall(t, username, dbPool.SocketPath, listen); err != nil {
+			fmt.Printf("Warning: failed to update the firewall: %v\n", err)
+		}
+		dbPool.SocketPath = listen
+	}
+
+	// Reload PHP-FPM
+	if providerErr == nil {
+		if err := writeCLIWrapper(t, phpProvider, username, dbPool.PHPVersion, config); err != nil {
+			fmt.Printf("Warning: failed to write PHP CLI wrapper: %v\n", err)
+		}
+		if err := pm.syncAPCu(phpProvider, dbPool); err != nil {
+			return 0, fmt.Errorf("failed to configure APCu: %w", err)
+		}
+		if providerTypeEnum == provider.ProviderLiteSpeed {
+			if err := syncLiteSpeedApp(t, phpProvider, dbPool.PHPVersion, data); err != nil {
+				return 0, fmt.Errorf("failed to configure OpenLiteSpeed: %w", err)
+			}
+		}
+		reload := func() error {
+			return pm.reloadFPMService(t, phpProvider, dbPool.PHPVersion)
+		}
+		if providerTypeEnum == provider.ProviderDocker {
+			// The image may change with the settings; the container is
+			// recreated either way to pick up the new pool file
+			reload = func() error {
+				_, err := deployPoolImage(t, phpProvider, dbPool, settings)
+				return err
+			}
+		}
+		if sw != nil {
+			err = sw.switchOver(reload)
+		} else {
+			err = reload()
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to reload PHP-FPM: %w", err)
+		}
+	}
+	if listenChanged {
+		// nginx must follow the pool to its new address
+		if err := NewSiteManagerWithDeps(pm.db).refreshPoolSites(dbPool.ID); err != nil {
+			return newRevision, err
+		}
+	}
+
+	events.Publish(events.PoolUpdated, username, map[string]interface{}{"revision": newRevision})
+	return newRevision, nil
 }
 
 func applyPoolSettings(data *templates.PoolConfigData, settings map[string]interface{}) error {
@@ -396,50 +987,300 @@ func applyPoolSettings(data *templates.PoolConfigData, settings map[string]inter
 			if v, ok := value.(string); ok {
 				data.ListenMode = v
 			}
+		case "opcache_memory_consumption":
+			if v, ok := settingString(value); ok {
+				data.OpcacheMemoryConsumption = v
+			}
+		case "opcache_max_accelerated_files":
+			if v, ok := settingString(value); ok {
+				data.OpcacheMaxAcceleratedFiles = v
+			}
+		case "opcache_validate_timestamps":
+			if v, ok := settingFlag(value); ok {
+				data.OpcacheValidateTimestamps = v
+			}
+		case "opcache_jit":
+			if v, ok := settingString(value); ok {
+				data.OpcacheJIT = v
+			}
+		case "apcu_enabled":
+			if v, ok := settingFlag(value); ok {
+				data.APCuEnabled = v
+			}
+		case "disk_quota":
+			// Set on the filesystem by syncQuota; not part of the pool file
+		case "image_extensions":
+			// Built into Docker pools' images by RebuildPool; only validated here
+			if _, err := imageExtensions(settings); err != nil {
+				return err
+			}
+		case "apcu_shm_size":
+			// Sized per version in APCuINIFile; only validated here
+			v, _ := settingString(value)
+			if _, err := parseMegabytes(v); err != nil {
+				return fmt.Errorf("invalid apcu_shm_size: %w", err)
+			}
+		case "allow_url_fopen":
+			if v, ok := settingFlag(value); ok {
+				data.AllowURLFopen = v
+			}
+		case "clear_env":
+			if v, ok := settingFlag(value); ok {
+				data.ClearEnv = "yes"
+				if v == "0" {
+					data.ClearEnv = "no"
+				}
+			}
+		case "env":
+			env, err := poolEnv(value)
+			if err != nil {
+				return err
+			}
+			data.Env = env
+		case "custom_config":
+			lines, err := poolCustomConfig(value)
+			if err != nil {
+				return err
+			}
+			data.CustomConfig = lines
 		}
 	}
-	return nil
+	return applySecuritySettings(data, settings)
 }
 
-func (pm *PoolManager) generatePoolConfig(username, uid, gid, socketPath, phpVersion string) (string, error) {
-	// Get user group name
-	u, err := user.LookupId(uid)
+// settingString converts a JSON setting value (string or number) to its ini form
+func settingString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// settingFlag converts a JSON setting value (bool, number or string) to "1"/"0"
+func settingFlag(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "1", true
+		}
+		return "0", true
+	case float64:
+		if v != 0 {
+			return "1", true
+		}
+		return "0", true
+	case string:
+		switch strings.ToLower(v) {
+		case "1", "on", "true", "yes":
+			return "1", true
+		case "0", "off", "false", "no":
+			return "0", true
+		}
+	}
+	return "", false
+}
+
+// poolRenderData builds the pool template data for a pool listening on
+// listen with the given settings
+func poolRenderData(t target.Target, username, listen string, settings map[string]interface{}) (*templates.PoolConfigData, error) {
+	// Get user info for group name
+	u, err := t.LookupUser(username)
 	if err != nil {
-		return "", fmt.Errorf("failed to lookup user: %w", err)
+		return nil, fmt.Errorf("failed to lookup user: %w", err)
 	}
-	
+
+	// Get group name
 	groupName := username
 	if u.Gid != "" {
-		g, err := user.LookupGroupId(u.Gid)
-		if err == nil {
-			groupName = g.Name
+		if name, err := t.LookupGroupName(u.Gid); err == nil {
+			groupName = name
+		}
+	}
+
+	allowedClients, err := poolAllowedClients(listen, settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply settings: %w", err)
+	}
+
+	// Create template data with defaults
+	data := templates.DefaultPoolConfigData(username, groupName, listen)
+	data.ListenAllowedClients = allowedClients
+
+	// Apply custom settings
+	if err := applyPoolSettings(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to apply settings: %w", err)
+	}
+	if err := validation.ProcessManager(data.ProcessManager, data.MaxChildren, data.StartServers, data.MinSpareServers, data.MaxSpareServers).Err(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (pm *PoolManager) generatePoolConfig(t target.Target, username, gid, socketPath string) (string, error) {
+	// Get user group name
+	groupName := username
+	if gid != "" {
+		if name, err := t.LookupGroupName(gid); err == nil {
+			groupName = name
 		}
 	}
 
 	// Load template
 	templateContent, err := templates.LoadTemplate("pool.conf.tmpl")
 	if err != nil {
-		return "", fmt.Errorf("failed to load template: %w", err)
+		return "", fmt.Errorf("failed to load template: %w", recordRenderFailure(pm.db, username, nil, err))
 	}
 
 	// Create template data with defaults
 	data := templates.DefaultPoolConfigData(username, groupName, socketPath)
+	data.ListenAllowedClients, _ = poolAllowedClients(socketPath, nil)
 
 	// Render template
 	config, err := templates.RenderPoolConfig(templateContent, data)
 	if err != nil {
-		return "", fmt.Errorf("failed to render template: %w", err)
+		return "", fmt.Errorf("failed to render template: %w", recordRenderFailure(pm.db, username, data, err))
 	}
 
 	return config, nil
 }
 
-func (pm *PoolManager) reloadFPMService(serviceName string) error {
-	cmd := exec.Command("systemctl", "reload", serviceName)
-	if err := cmd.Run(); err != nil {
-		// Try alternative method
-		cmd = exec.Command("systemctl", "reload-or-restart", serviceName)
-		return cmd.Run()
+// poolDirs returns the per-user session and tmp directories
+func poolDirs(username string) []string {
+	return []string{
+		filepath.Join(templates.SessionBaseDir, username),
+		filepath.Join(templates.TmpBaseDir, username),
+	}
+}
+
+// provisionPoolDirs creates the per-user session and tmp directories owned
+// by the pool user inside the target
+func provisionPoolDirs(t target.Target, username, uid, gid string) error {
+	uidNum, err := strconv.Atoi(uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s: %w", uid, err)
+	}
+	gidNum, err := strconv.Atoi(gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s: %w", gid, err)
+	}
+	if uidNum, gidNum, err = t.HostIDs(uidNum, gidNum); err != nil {
+		return err
+	}
+
+	for _, dir := range poolDirs(username) {
+		if dir, err = t.Path(dir); err != nil {
+			return err
+		}
+		// Missing parents such as /var/lib/php/sessions are shared by all
+		// pools: users may pass through them but not list them
+		if err := os.MkdirAll(filepath.Dir(dir), 0711); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(dir), err)
+		}
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to set permissions on %s: %w", dir, err)
+		}
+		if err := os.Chown(dir, uidNum, gidNum); err != nil {
+			return fmt.Errorf("failed to set ownership on %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// removePoolDirs deletes the per-user session and tmp directories
+func removePoolDirs(t target.Target, username string) error {
+	for _, dir := range poolDirs(username) {
+		dir, err := t.Path(dir)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
 	}
 	return nil
 }
+
+// reloadFPMService reloads the PHP-FPM service of a version with its
+// provider's reload strategy, or collects it while a batch runs
+func (pm *PoolManager) reloadFPMService(t target.Target, phpProvider provider.PHPProvider, version string) error {
+	service := fpmService{
+		target:   t,
+		name:     phpProvider.GetServiceName(version),
+		strategy: reloadStrategy(phpProvider.GetProviderType()),
+	}
+	if pm.pendingReloads != nil {
+		pm.pendingReloads[service] = true
+		return nil
+	}
+	return reloadServiceWith(pm.context(), t, service.name, service.strategy)
+}
+
+// reloadService reloads a PHP-FPM service on the host, falling back to
+// reload-or-restart. Reloads of the same service are serialized.
+func reloadService(ctx context.Context, serviceName string) error {
+	return reloadServiceIn(ctx, target.Host, serviceName)
+}
+
+// hostPath returns where a host path lives, which is inside the sandbox in
+// development mode
+func hostPath(path string) string {
+	p, _ := target.Host.Path(path)
+	return p
+}
+
+// targetArch returns the architecture of an execution target's OS
+func targetArch(t target.Target) (system.Arch, error) {
+	root, err := t.Root()
+	if err != nil {
+		return "", err
+	}
+	return system.NewOSDetectorAt(root).DetectArch()
+}
+
+// reloadServiceIn reloads a service inside an execution target, traced
+// under the span in ctx
+func reloadServiceIn(ctx context.Context, t target.Target, serviceName string) error {
+	return reloadServiceWith(ctx, t, serviceName, config.ReloadService)
+}
+
+// reloadServiceWith reloads a service with a reload strategy. Strategies
+// that work on single pools (drain-and-switch) reload the whole service
+// here.
+func reloadServiceWith(ctx context.Context, t target.Target, serviceName, strategy string) (err error) {
+	ctx, span := tracing.Start(ctx, "service.reload")
+	span.SetAttr("systemd.unit", serviceName)
+	span.SetAttr("target", t.String())
+	span.SetAttr("reload.strategy", strategy)
+	defer span.Finish(&err)
+
+	key := serviceName
+	if !t.IsHost() {
+		key = t.String() + "/" + serviceName
+	}
+	l, err := lock.Default.Acquire(lock.ServiceKey(key), "reload "+serviceName, true, serviceLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	services := servicemgr.ForTarget(t, servicemgr.TargetRunner(ctx, t))
+	span.SetAttr("service.manager", services.Kind())
+	switch strategy {
+	case config.ReloadGraceful:
+		// USR2 makes the FPM master re-execute with the new configuration
+		// and let running requests finish
+		err := services.Signal(serviceName, "USR2")
+		if !errors.Is(err, servicemgr.ErrUnsupported) {
+			return err
+		}
+	case config.ReloadOrRestart:
+		return services.ReloadOrRestart(serviceName)
+	}
+	return services.Reload(serviceName)
+}
@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/system"
+	"lightweight-php/target"
+)
+
+// newTestPoolManager returns a pool manager on a development sandbox, where
+// every external command succeeds without running, and the sandbox directory
+func newTestPoolManager(t *testing.T) (*PoolManager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"etc", "run", "var/lib/lightweight-php"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	osRelease := "ID=\"lightweight-php-dev\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"9\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "etc/os-release"), []byte(osRelease), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target.EnableDev(dir, "true")
+	t.Cleanup(func() { target.EnableDev("") })
+	defaultLocks := lock.Default
+	lock.Default = lock.NewManager(filepath.Join(dir, lock.DefaultLockDir))
+	t.Cleanup(func() { lock.Default = defaultLocks })
+
+	osFamily, err := system.NewOSDetector().Detect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := db.NewDatabase(filepath.Join(dir, "var/lib/lightweight-php/test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	return NewPoolManagerWithDeps(database, osFamily, provider.NewProviderFactoryWithDeps(database, osFamily)), dir
+}
+
+func TestDeletePoolRemovesEveryPoolOfTheUser(t *testing.T) {
+	pm, dir := newTestPoolManager(t)
+	for _, version := range []string{"8.2", "8.3"} {
+		if err := pm.CreatePool("bob", version, "remi"); err != nil {
+			t.Fatalf("CreatePool %s: %v", version, err)
+		}
+	}
+	if err := pm.CreatePool("alice", "8.3", "remi"); err != nil {
+		t.Fatal(err)
+	}
+	pools, err := pm.db.ListUserPools("bob")
+	if err != nil || len(pools) != 2 {
+		t.Fatalf("bob has %d pools (%v), want 2", len(pools), err)
+	}
+
+	if err := pm.DeletePool("bob", false); err != nil {
+		t.Fatalf("DeletePool: %v", err)
+	}
+
+	for _, p := range pools {
+		if _, err := os.Stat(filepath.Join(dir, p.ConfigPath)); !os.IsNotExist(err) {
+			t.Errorf("the PHP %s pool's config %s is left behind", p.PHPVersion, p.ConfigPath)
+		}
+	}
+	if left, _ := pm.db.ListUserPools("bob"); len(left) != 0 {
+		t.Errorf("bob still has %d pools", len(left))
+	}
+	alice, err := pm.db.GetPool("alice")
+	if err != nil || alice == nil {
+		t.Fatalf("alice's pool went too: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, alice.ConfigPath)); err != nil {
+		t.Errorf("alice's pool config: %v", err)
+	}
+}
+
+func TestMergeSettings(t *testing.T) {
+	current := map[string]interface{}{
+		"memory_limit":       "256M",
+		"max_execution_time": "60s",
+		"env":                map[string]interface{}{"APP_ENV": "prod", "APP_DEBUG": "0"},
+	}
+	patch := map[string]interface{}{
+		"max_execution_time": nil,
+		"upload_max_size":    "64M",
+		"env":                map[string]interface{}{"APP_DEBUG": nil, "APP_URL": "https://example.com"},
+		"missing":            nil,
+	}
+
+	got := mergeSettings(current, patch)
+	want := map[string]interface{}{
+		"memory_limit":    "256M",
+		"upload_max_size": "64M",
+		"env":             map[string]interface{}{"APP_ENV": "prod", "APP_URL": "https://example.com"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSettings = %v, want %v", got, want)
+	}
+
+	// The stored settings are left alone
+	if current["max_execution_time"] != "60s" || len(current["env"].(map[string]interface{})) != 2 {
+		t.Errorf("mergeSettings modified current: %v", current)
+	}
+}
+
+func TestMergeSettingsReplacesNonObjects(t *testing.T) {
+	got := mergeSettings(
+		map[string]interface{}{"env": "not an object"},
+		map[string]interface{}{"env": map[string]interface{}{"A": "1", "B": nil}},
+	)
+	want := map[string]interface{}{"env": map[string]interface{}{"A": "1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSettings = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"lightweight-php/target"
+	"lightweight-php/version"
+)
+
+const probeScript = ".lightweight-php-probe.php"
+
+// PoolTestResult is the outcome of a FastCGI test request to a pool
+type PoolTestResult struct {
+	Username string `json:"username"`
+	// Script is the requested script, empty for the built-in probe
+	Script         string  `json:"script,omitempty"`
+	OK             bool    `json:"ok"`
+	Status         int     `json:"status,omitempty"`
+	ResponseTimeMs float64 `json:"response_time_ms"`
+	// PHPVersion is the version that served the probe, which must belong
+	// to ExpectedVersion
+	PHPVersion      string   `json:"php_version,omitempty"`
+	ExpectedVersion string   `json:"expected_version"`
+	SAPI            string   `json:"sapi,omitempty"`
+	Stderr          string   `json:"stderr,omitempty"`
+	Problems        []string `json:"problems"`
+}
+
+// TestPool sends a FastCGI request straight to the pool's listener and
+// reports whether the pool answers, how fast, and which PHP version served
+// it. A built-in probe script is always run to read the version; with
+// script set, that script (a path inside the pool's target) is requested
+// as well and its status and response time are reported. Failures of the
+// pool itself are returned in the result, not as an error.
+func (pm *PoolManager) TestPool(username, script string) (*PoolTestResult, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if script != "" && !path.IsAbs(script) {
+		return nil, fmt.Errorf("script must be an absolute path, got %q", script)
+	}
+
+	result := &PoolTestResult{
+		Username:        username,
+		Script:          script,
+		ExpectedVersion: dbPool.PHPVersion,
+		Problems:        make([]string, 0),
+	}
+
+	resp, err := pm.runPoolScript(dbPool, probeScript,
+		"<?php\necho json_encode(['version' => PHP_VERSION, 'sapi' => PHP_SAPI]);\n")
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result, nil
+	}
+	result.Status = resp.Status
+	result.ResponseTimeMs = milliseconds(resp.Duration)
+	result.Stderr = strings.TrimSpace(string(resp.Stderr))
+
+	var probe struct {
+		Version string `json:"version"`
+		SAPI    string `json:"sapi"`
+	}
+	if resp.Status != 200 {
+		result.Problems = append(result.Problems, fmt.Sprintf("probe returned status %d: %s", resp.Status, truncate(strings.TrimSpace(string(resp.Body)), 200)))
+	} else if err := json.Unmarshal(resp.Body, &probe); err != nil {
+		result.Problems = append(result.Problems, fmt.Sprintf("probe returned unexpected output: %s", truncate(strings.TrimSpace(string(resp.Body)), 200)))
+	} else {
+		result.PHPVersion = probe.Version
+		result.SAPI = probe.SAPI
+		if served, err := version.ParseRelease(probe.Version); err != nil || !sameMinor(served, dbPool.PHPVersion) {
+			result.Problems = append(result.Problems, fmt.Sprintf("pool is served by PHP %s, expected %s", probe.Version, dbPool.PHPVersion))
+		}
+		if probe.SAPI != "fpm-fcgi" {
+			result.Problems = append(result.Problems, fmt.Sprintf("unexpected SAPI %s", probe.SAPI))
+		}
+	}
+
+	if script != "" {
+		t, err := target.Parse(dbPool.Target)
+		if err != nil {
+			return nil, err
+		}
+		listen, err := poolListen(t, dbPool)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := dialPool(listen, fastCGIParams(script), 10*time.Second)
+		if err != nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("FastCGI request for %s failed: %v", script, err))
+			return result, nil
+		}
+		result.Status = resp.Status
+		result.ResponseTimeMs = milliseconds(resp.Duration)
+		result.Stderr = strings.TrimSpace(string(resp.Stderr))
+		if resp.Status >= 400 {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s returned status %d", script, resp.Status))
+		}
+	}
+
+	result.OK = len(result.Problems) == 0
+	return result, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// sameMinor reports whether a release belongs to a major.minor version
+func sameMinor(release version.Version, phpVersion string) bool {
+	c, err := version.ParseConstraint(phpVersion)
+	return err == nil && c.Check(release)
+}
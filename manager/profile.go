@@ -0,0 +1,203 @@
+package manager
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/templates"
+	"lightweight-php/validation"
+)
+
+// Profile is a named preset of pool settings, such as "wordpress", applied
+// when a pool is created with it
+type Profile struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Settings    map[string]interface{} `json:"settings"`
+	Builtin     bool                   `json:"builtin"`
+}
+
+var (
+	// ErrProfileNotFound is returned for an unknown profile name
+	ErrProfileNotFound = errors.New("profile not found")
+	// ErrProfileExists is returned when creating a profile whose name is taken
+	ErrProfileExists = errors.New("profile already exists")
+)
+
+// ListProfiles returns all profiles ordered by name
+func (pm *PoolManager) ListProfiles() ([]Profile, error) {
+	dbProfiles, err := pm.db.ListProfiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles from database: %w", err)
+	}
+
+	profiles := make([]Profile, 0, len(dbProfiles))
+	for i := range dbProfiles {
+		p, err := profileFromDB(&dbProfiles[i])
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *p)
+	}
+	return profiles, nil
+}
+
+// GetProfile returns a profile by name
+func (pm *PoolManager) GetProfile(name string) (*Profile, error) {
+	dbProfile, err := pm.db.GetProfile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile from database: %w", err)
+	}
+	if dbProfile == nil {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+	}
+	return profileFromDB(dbProfile)
+}
+
+// CreateProfile stores a new profile after checking its settings render
+func (pm *PoolManager) CreateProfile(p *Profile) error {
+	if err := validation.Field("name", p.Name, validation.ProfileName); err != nil {
+		return err
+	}
+	encoded, err := encodeProfileSettings(p.Settings)
+	if err != nil {
+		return err
+	}
+
+	existing, err := pm.db.GetProfile(p.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check profile: %w", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("%w: %s", ErrProfileExists, p.Name)
+	}
+
+	if err := pm.db.CreateProfile(p.Name, p.Description, encoded); err != nil {
+		return fmt.Errorf("failed to save profile to database: %w", err)
+	}
+	return nil
+}
+
+// UpdateProfile replaces the description and settings of a profile. Pools
+// created from it earlier keep their settings.
+func (pm *PoolManager) UpdateProfile(p *Profile) error {
+	encoded, err := encodeProfileSettings(p.Settings)
+	if err != nil {
+		return err
+	}
+
+	err = pm.db.UpdateProfile(p.Name, p.Description, encoded)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, p.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	return nil
+}
+
+// DeleteProfile removes a profile. Built-in profiles can be edited but not
+// deleted so panels can rely on their names.
+func (pm *PoolManager) DeleteProfile(name string) error {
+	p, err := pm.GetProfile(name)
+	if err != nil {
+		return err
+	}
+	if p.Builtin {
+		return fmt.Errorf("profile %s is built in and cannot be deleted", name)
+	}
+	if err := pm.db.DeleteProfile(name); err != nil {
+		return fmt.Errorf("failed to delete profile: %w", err)
+	}
+	return nil
+}
+
+// CreatePoolWithProfile creates a pool and applies the settings of the named
+// profile as its initial configuration, on top of the default pool settings
+// and those of the manager's tenant. Without any the pool gets the template
+// defaults.
+func (pm *PoolManager) CreatePoolWithProfile(username, phpVersion, providerType, profileName string) error {
+	// Resolve the tenant and profile first so an unknown name leaves no
+	// pool behind
+	settings, err := pm.newPoolSettings()
+	if err != nil {
+		return err
+	}
+	if profileName != "" {
+		profile, err := pm.GetProfile(profileName)
+		if err != nil {
+			return err
+		}
+		settings = mergeSettings(settings, profile.Settings)
+	}
+
+	if err := pm.CreatePool(username, phpVersion, providerType); err != nil {
+		return err
+	}
+	dbEnv, err := pm.provisionDatabase(username)
+	if err != nil {
+		return fmt.Errorf("pool created but provisioning its database failed: %w", err)
+	}
+	if dbEnv != nil {
+		settings = mergeSettings(settings, map[string]interface{}{"env": dbEnv})
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	if err := pm.initPoolSettings(username, settings); err != nil {
+		if profileName == "" && pm.tenant != "" {
+			return fmt.Errorf("pool created but applying the settings of tenant %s failed: %w", pm.tenant, err)
+		}
+		if profileName == "" {
+			return fmt.Errorf("pool created but applying the default settings failed: %w", err)
+		}
+		return fmt.Errorf("pool created but applying profile %s failed: %w", profileName, err)
+	}
+	return nil
+}
+
+// initPoolSettings applies the first settings of a freshly created pool
+func (pm *PoolManager) initPoolSettings(username string, settings map[string]interface{}) error {
+	l, err := pm.acquire(lock.PoolKey(username), "configure pool "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	_, err = pm.applyPoolConfig(username, settings, 0)
+	return err
+}
+
+func profileFromDB(p *db.PoolProfile) (*Profile, error) {
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(p.Settings), &settings); err != nil {
+		return nil, fmt.Errorf("failed to decode settings of profile %s: %w", p.Name, err)
+	}
+	return &Profile{
+		Name:        p.Name,
+		Description: p.Description,
+		Settings:    settings,
+		Builtin:     p.Builtin,
+	}, nil
+}
+
+// encodeProfileSettings checks that settings apply to the pool template and
+// returns them as JSON
+func encodeProfileSettings(settings map[string]interface{}) (string, error) {
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	data := templates.DefaultPoolConfigData("profile", "profile", "/run/php-fpm/profile.sock")
+	if err := applyPoolSettings(data, settings); err != nil {
+		return "", fmt.Errorf("invalid profile settings: %w", err)
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode profile settings: %w", err)
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"lightweight-php/validation"
+)
+
+// IdempotencyTTL is how long the API replays the response to a request
+// with an Idempotency-Key; the prune task deletes older keys
+const IdempotencyTTL = 24 * time.Hour
+
+// Account is a pool as billing systems see it: a hosting account with a
+// package, which is the name of the profile its settings come from
+type Account struct {
+	Username   string      `json:"username"`
+	Status     string      `json:"status"`
+	PHPVersion string      `json:"php_version"`
+	Provider   string      `json:"provider"`
+	Package    string      `json:"package"`
+	Tenant     string      `json:"tenant,omitempty"`
+	Suspension *Suspension `json:"suspension,omitempty"`
+}
+
+// AccountSpec is the body of a provisioning request creating an account
+type AccountSpec struct {
+	Username   string `json:"username"`
+	PHPVersion string `json:"php_version"`
+	Provider   string `json:"provider"`
+	// Package is the profile whose settings the pool gets
+	Package    string `json:"package"`
+	Tenant     string `json:"tenant"`
+	CreateUser bool   `json:"create_user"`
+	Shell      string `json:"shell"`
+	SSHKey     string `json:"ssh_key"`
+}
+
+// Validate checks an account spec
+func (s AccountSpec) Validate() error {
+	var errs validation.Errors
+	if s.Username == "" {
+		errs.Add("username", "is required")
+	}
+	errs.Check("username", s.Username, validation.Username)
+	if s.PHPVersion != "" {
+		errs.Check("php_version", s.PHPVersion, validation.PHPVersion)
+	}
+	if s.Package != "" {
+		errs.Check("package", s.Package, validation.ProfileName)
+	}
+	if s.Tenant != "" {
+		errs.Check("tenant", s.Tenant, validation.TenantName)
+	}
+	if !s.CreateUser && (s.Shell != "" || s.SSHKey != "") {
+		errs.Add("create_user", "must be true when shell or ssh_key is given")
+	}
+	errs.Check("shell", s.Shell, func(shell string) error {
+		return UserOptions{Shell: shell}.Validate()
+	})
+	errs.Check("ssh_key", s.SSHKey, func(key string) error {
+		return UserOptions{SSHKey: key}.Validate()
+	})
+	return errs.Err()
+}
+
+// GetAccount returns the account of a user's pool
+func (pm *PoolManager) GetAccount(username string) (*Account, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	pkg, err := pm.db.GetPoolPackage(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package: %w", err)
+	}
+	account := &Account{
+		Username:   dbPool.Username,
+		Status:     dbPool.Status,
+		PHPVersion: dbPool.PHPVersion,
+		Provider:   dbPool.Provider,
+		Package:    pkg,
+		Tenant:     dbPool.Tenant,
+	}
+	suspension, err := pm.db.GetPoolSuspension(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get suspension: %w", err)
+	}
+	if suspension != nil {
+		account.Suspension = poolSuspension(suspension)
+	}
+	return account, nil
+}
+
+// ProvisionAccount creates a pool with the settings of its package, and
+// its system user with CreateUser, and records the package
+func (pm *PoolManager) ProvisionAccount(spec AccountSpec) (*Account, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if spec.PHPVersion == "" {
+		spec.PHPVersion = "8.2"
+	}
+	if spec.Provider == "" {
+		spec.Provider = "remi"
+	}
+
+	pm = pm.WithTenant(spec.Tenant)
+	var err error
+	if spec.CreateUser {
+		err = pm.CreatePoolWithUser(spec.Username, spec.PHPVersion, spec.Provider, spec.Package, UserOptions{Shell: spec.Shell, SSHKey: spec.SSHKey})
+	} else {
+		err = pm.CreatePoolWithProfile(spec.Username, spec.PHPVersion, spec.Provider, spec.Package)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if spec.Package != "" {
+		dbPool, err := pm.db.GetPool(spec.Username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pool from database: %w", err)
+		}
+		if dbPool == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, spec.Username)
+		}
+		if err := pm.db.SetPoolPackage(dbPool.ID, spec.Package); err != nil {
+			return nil, fmt.Errorf("pool created but recording its package failed: %w", err)
+		}
+	}
+	return pm.GetAccount(spec.Username)
+}
+
+// ChangePackage moves an account to another package. Settings the old
+// package set and the new one does not return to the defaults, those of
+// the new package are applied, and settings of neither are kept.
+func (pm *PoolManager) ChangePackage(username, pkg string) (_ *Account, err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.change_package", username, &err)
+	if err := validation.Field("package", pkg, validation.ProfileName); err != nil {
+		return nil, err
+	}
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	profile, err := pm.GetProfile(pkg)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := pm.db.GetPoolPackage(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package: %w", err)
+	}
+	var previousSettings map[string]interface{}
+	if previous != "" && previous != pkg {
+		old, err := pm.GetProfile(previous)
+		if err != nil && !errors.Is(err, ErrProfileNotFound) {
+			return nil, err
+		}
+		// A deleted package no longer says which settings were its own
+		if old != nil {
+			previousSettings = old.Settings
+		}
+	}
+	base, err := pm.WithTenant(dbPool.Tenant).newPoolSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = pm.updatePoolConfig(username, AnyRevision, func(current map[string]interface{}) map[string]interface{} {
+		next := mergeSettings(current, nil)
+		for key := range previousSettings {
+			if _, ok := profile.Settings[key]; ok {
+				continue
+			}
+			if value, ok := base[key]; ok {
+				next[key] = value
+			} else {
+				delete(next, key)
+			}
+		}
+		return mergeSettings(next, profile.Settings)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := pm.db.SetPoolPackage(dbPool.ID, pkg); err != nil {
+		return nil, fmt.Errorf("failed to record package: %w", err)
+	}
+	return pm.GetAccount(username)
+}
+
+// TerminateAccount deletes an account's pool with its data and, with
+// removeUser, locks or deletes its system user as RemovePoolUser does. It
+// returns what happened to the user.
+func (pm *PoolManager) TerminateAccount(username string, removeUser bool) (string, error) {
+	if err := pm.DeletePool(username, true); err != nil {
+		return "", err
+	}
+	if !removeUser {
+		return "", nil
+	}
+	action, err := pm.RemovePoolUser(username)
+	if err != nil {
+		return "", fmt.Errorf("pool deleted but removing the user failed: %w", err)
+	}
+	return action, nil
+}
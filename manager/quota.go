@@ -0,0 +1,278 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+// DiskUsage is a pool user's usage of its disk quota
+type DiskUsage struct {
+	Filesystem string  `json:"filesystem"`
+	UsedBytes  int64   `json:"used_bytes"`
+	LimitBytes int64   `json:"limit_bytes"`
+	Percent    float64 `json:"percent"`
+	// Error is set instead of the figures when usage cannot be read
+	Error string `json:"error,omitempty"`
+}
+
+// QuotaAlert is a pool whose usage crossed quota.alert_threshold
+type QuotaAlert struct {
+	Username  string    `json:"username"`
+	Usage     DiskUsage `json:"usage"`
+	Threshold int       `json:"threshold"`
+	// WebhookError is set when the webhook could not be delivered
+	WebhookError string `json:"webhook_error,omitempty"`
+}
+
+// quotaAlerted remembers which users are above the threshold, so a crossing
+// is reported once and again only after usage fell below it
+var quotaAlerted = struct {
+	sync.Mutex
+	users map[string]bool
+}{users: make(map[string]bool)}
+
+// diskQuotaBytes returns the disk_quota setting in bytes; 0 means none
+func diskQuotaBytes(settings map[string]interface{}) (int64, error) {
+	value, ok := settings["disk_quota"]
+	if !ok || value == nil {
+		return 0, nil
+	}
+	s, ok := settingString(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid disk_quota: must be a size such as 10G")
+	}
+	return ParseSize(s)
+}
+
+// syncQuota sets the user's quota when disk_quota differs between the
+// previous and the new settings; removing the setting lifts the quota
+func syncQuota(t target.Target, username string, previous, settings map[string]interface{}) error {
+	before, _ := diskQuotaBytes(previous)
+	after, err := diskQuotaBytes(settings)
+	if err != nil {
+		return err
+	}
+	if before == after {
+		return nil
+	}
+	return setDiskQuota(t, username, after)
+}
+
+// setDiskQuota sets the block limit of a user on quota.filesystem; 0 removes it
+func setDiskQuota(t target.Target, username string, limit int64) error {
+	if err := validation.Field("username", username, validation.Username); err != nil {
+		return err
+	}
+	fs := config.Get().Quota.Filesystem
+	kb := strconv.FormatInt((limit+1023)/1024, 10)
+
+	var cmd string
+	var args []string
+	if quotaTool(t, fs) == "xfs_quota" {
+		cmd, args = "xfs_quota", []string{"-x", "-c", fmt.Sprintf("limit -u bsoft=%sk bhard=%sk %s", kb, kb, username), fs}
+	} else {
+		cmd, args = "setquota", []string{"-u", username, kb, kb, "0", "0", fs}
+	}
+	if output, err := t.Command(cmd, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to set disk quota with %s: %v: %s", cmd, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// readDiskUsage returns the used and allowed bytes of a user on
+// quota.filesystem
+func readDiskUsage(t target.Target, username string) (int64, int64, error) {
+	fs := config.Get().Quota.Filesystem
+	var cmd string
+	var args []string
+	if quotaTool(t, fs) == "xfs_quota" {
+		cmd, args = "xfs_quota", []string{"-x", "-c", "quota -u -b -N " + username, fs}
+	} else {
+		cmd, args = "quota", []string{"-u", "-v", "-w", "-p", "-f", fs, username}
+	}
+	output, err := t.Command(cmd, args...).Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read disk usage with %s: %w", cmd, err)
+	}
+
+	// Both tools print "FILESYSTEM USED SOFT HARD ..." in 1K blocks; a "*"
+	// marks usage over the soft limit
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		var blocks [3]int64
+		valid := true
+		for i := range blocks {
+			n, err := strconv.ParseInt(strings.TrimSuffix(fields[i+1], "*"), 10, 64)
+			if err != nil {
+				valid = false
+				break
+			}
+			blocks[i] = n
+		}
+		if !valid {
+			continue
+		}
+		limit := blocks[2]
+		if limit == 0 {
+			limit = blocks[1]
+		}
+		return blocks[0] * 1024, limit * 1024, nil
+	}
+	return 0, 0, fmt.Errorf("no quota reported for %s on %s", username, fs)
+}
+
+// quotaTool returns the configured quota tool, picking xfs_quota for XFS
+// filesystems when it is "auto"
+func quotaTool(t target.Target, fs string) string {
+	tool := config.Get().Quota.Tool
+	if tool != "auto" {
+		return tool
+	}
+	output, err := t.Command("stat", "-f", "-c", "%T", fs).Output()
+	if err == nil && strings.TrimSpace(string(output)) == "xfs" {
+		return "xfs_quota"
+	}
+	return "setquota"
+}
+
+// DiskUsage returns a pool user's disk usage against its disk_quota, or nil
+// when the pool has no quota. A failure to read the usage is reported in
+// DiskUsage.Error.
+func (pm *PoolManager) DiskUsage(username string) (*DiskUsage, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	return pm.poolDiskUsage(dbPool)
+}
+
+func (pm *PoolManager) poolDiskUsage(dbPool *db.Pool) (*DiskUsage, error) {
+	settings, err := pm.poolSettings(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	quota, err := diskQuotaBytes(settings)
+	if err != nil || quota == 0 {
+		return nil, err
+	}
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &DiskUsage{Filesystem: config.Get().Quota.Filesystem, LimitBytes: quota}
+	used, limit, err := readDiskUsage(t, dbPool.Username)
+	if err != nil {
+		usage.Error = err.Error()
+		return usage, nil
+	}
+	usage.UsedBytes = used
+	if limit > 0 {
+		usage.LimitBytes = limit
+	}
+	usage.Percent = float64(int64(float64(used)/float64(usage.LimitBytes)*1000)) / 10
+	return usage, nil
+}
+
+// poolSettings returns the stored settings of a pool
+func (pm *PoolManager) poolSettings(dbPool *db.Pool) (map[string]interface{}, error) {
+	encoded, _, err := pm.db.GetPoolSettings(dbPool.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool settings: %w", err)
+	}
+	settings := make(map[string]interface{})
+	if encoded != "" {
+		if err := json.Unmarshal([]byte(encoded), &settings); err != nil {
+			return nil, fmt.Errorf("failed to decode pool settings: %w", err)
+		}
+	}
+	return settings, nil
+}
+
+// CheckQuotas reads the usage of every pool with a disk_quota and returns
+// the pools that crossed quota.alert_threshold since the last check. Each
+// alert is published and, with quota.webhook_url, posted as JSON.
+func (pm *PoolManager) CheckQuotas() ([]QuotaAlert, error) {
+	cfg := config.Get().Quota
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+
+	var alerts []QuotaAlert
+	for i := range pools {
+		usage, err := pm.poolDiskUsage(&pools[i])
+		if err != nil || usage == nil || usage.Error != "" {
+			continue
+		}
+		username := pools[i].Username
+		above := usage.Percent >= float64(cfg.AlertThreshold)
+
+		quotaAlerted.Lock()
+		crossed := above && !quotaAlerted.users[username]
+		quotaAlerted.users[username] = above
+		quotaAlerted.Unlock()
+		if !crossed {
+			continue
+		}
+
+		alert := QuotaAlert{Username: username, Usage: *usage, Threshold: cfg.AlertThreshold}
+		events.Publish(events.QuotaThreshold, username, map[string]interface{}{
+			"used_bytes": usage.UsedBytes, "limit_bytes": usage.LimitBytes, "percent": usage.Percent, "threshold": cfg.AlertThreshold,
+		})
+		if cfg.WebhookURL != "" {
+			if err := postQuotaWebhook(cfg.WebhookURL, alert); err != nil {
+				alert.WebhookError = err.Error()
+			}
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+func postQuotaWebhook(url string, alert QuotaAlert) error {
+	hostname, _ := os.Hostname()
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":       events.QuotaThreshold,
+		"host":        hostname,
+		"username":    alert.Username,
+		"filesystem":  alert.Usage.Filesystem,
+		"used_bytes":  alert.Usage.UsedBytes,
+		"limit_bytes": alert.Usage.LimitBytes,
+		"percent":     alert.Usage.Percent,
+		"threshold":   alert.Threshold,
+		"time":        time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post quota alert: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("quota webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/servicemgr"
+	"lightweight-php/target"
+)
+
+// Kinds of Discrepancy
+const (
+	// DiscrepancyConfigMissing is an active pool whose pool file is gone
+	DiscrepancyConfigMissing = "config_missing"
+	// DiscrepancyVersionMissing is a PHP version pools run on whose
+	// php-fpm binary is gone, e.g. after removing its packages by hand
+	DiscrepancyVersionMissing = "version_missing"
+	// DiscrepancyServiceStopped is a PHP-FPM service of active pools that
+	// is not running
+	DiscrepancyServiceStopped = "service_stopped"
+)
+
+// Discrepancy is a difference between the database and a host
+type Discrepancy struct {
+	Kind     string `json:"kind"`
+	Subject  string `json:"subject"`
+	Username string `json:"username,omitempty"`
+	Message  string `json:"message"`
+	// HealError is why healing it failed in the last pass
+	HealError   string    `json:"heal_error,omitempty"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// ReconcileReport is the outcome of a reconciliation pass
+type ReconcileReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// Discrepancies are those still open after the pass
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	// Healed were repaired by the pass
+	Healed []Discrepancy `json:"healed"`
+	// Resolved were open before and are gone without the pass's help
+	Resolved []Discrepancy `json:"resolved"`
+}
+
+// reconcileMu keeps the server's loop and requests from reconciling at
+// the same time
+var reconcileMu sync.Mutex
+
+// ListDiscrepancies returns the discrepancies the last passes found and
+// could not heal
+func (pm *PoolManager) ListDiscrepancies() ([]Discrepancy, error) {
+	rows, err := pm.db.ListDiscrepancies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list discrepancies: %w", err)
+	}
+	discrepancies := make([]Discrepancy, 0, len(rows))
+	for _, d := range rows {
+		discrepancies = append(discrepancies, discrepancyFromDB(d))
+	}
+	return discrepancies, nil
+}
+
+// Reconcile compares the pools in the database with their hosts: missing
+// pool files, PHP versions whose binary is gone and stopped PHP-FPM
+// services. Kinds listed in heal (config.HealConfig, config.HealService)
+// are repaired. Discrepancies are kept in the database while they last and
+// published as events when they appear, are healed or go away. Pools that
+// another operation holds are skipped.
+func (pm *PoolManager) Reconcile(heal []string) (*ReconcileReport, error) {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	known, err := pm.db.ListDiscrepancies()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list discrepancies: %w", err)
+	}
+
+	now := time.Now().UTC()
+	r := &reconciler{
+		pm:      pm,
+		heal:    make(map[string]bool),
+		known:   make(map[string]db.Discrepancy, len(known)),
+		seen:    make(map[string]bool),
+		skipped: make(map[string]bool),
+		report:  &ReconcileReport{CheckedAt: now, Discrepancies: []Discrepancy{}, Healed: []Discrepancy{}, Resolved: []Discrepancy{}},
+		now:     now,
+	}
+	for _, kind := range heal {
+		r.heal[kind] = true
+	}
+	for _, d := range known {
+		r.known[d.Kind+"|"+d.Subject] = d
+	}
+
+	// Services and binaries are shared by the pools of a version
+	type versionKey struct {
+		target   target.Target
+		provider string
+		version  string
+	}
+	var versions []versionKey
+	users := make(map[versionKey][]string)
+	factories := make(map[versionKey]*provider.ProviderFactory)
+	for i := range pools {
+		p := &pools[i]
+		if p.Status == db.PoolSuspended {
+			continue
+		}
+		t, factory, err := pm.poolTarget(p)
+		if err != nil {
+			continue
+		}
+		key := versionKey{t, p.Provider, p.PHPVersion}
+		if users[key] == nil {
+			versions = append(versions, key)
+			factories[key] = factory
+		}
+		users[key] = append(users[key], p.Username)
+		r.checkPool(t, p)
+	}
+	// Pool files are healed first, as that reloads their services
+	for _, key := range versions {
+		r.checkVersion(key.target, factories[key], key.provider, key.version, users[key])
+	}
+
+	for id, d := range r.known {
+		if r.seen[id] || (d.Username != "" && r.skipped[d.Username]) {
+			continue
+		}
+		if err := pm.db.DeleteDiscrepancy(d.Kind, d.Subject); err != nil {
+			return r.report, fmt.Errorf("failed to delete discrepancy: %w", err)
+		}
+		resolved := discrepancyFromDB(d)
+		r.report.Resolved = append(r.report.Resolved, resolved)
+		publishDiscrepancy(resolved, "resolved")
+	}
+	return r.report, r.err
+}
+
+// reconciler is the state of one reconciliation pass
+type reconciler struct {
+	pm   *PoolManager
+	heal map[string]bool
+	// known are the discrepancies open before the pass, by kind|subject
+	known map[string]db.Discrepancy
+	seen  map[string]bool
+	// skipped are pools another operation held, whose discrepancies are
+	// left as they are
+	skipped map[string]bool
+	report  *ReconcileReport
+	now     time.Time
+	// err is the first failure to record a discrepancy
+	err error
+}
+
+// checkPool reports and heals a missing pool file. The pool's lock is held
+// so a pool being created or changed is not mistaken for a broken one.
+func (r *reconciler) checkPool(t target.Target, p *db.Pool) {
+	l, err := r.pm.WithNoWait().acquire(lock.PoolKey(p.Username), "reconcile pool "+p.Username)
+	if err != nil {
+		r.skipped[p.Username] = true
+		return
+	}
+	defer l.Release()
+
+	hostConfig, err := t.Path(p.ConfigPath)
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(hostConfig); !os.IsNotExist(err) {
+		return
+	}
+	d := Discrepancy{
+		Kind:     DiscrepancyConfigMissing,
+		Subject:  p.Username,
+		Username: p.Username,
+		Message:  fmt.Sprintf("pool file %s is missing", p.ConfigPath),
+	}
+	r.found(d, config.HealConfig, func() error {
+		current, err := r.pm.GetPoolConfig(p.Username)
+		if err != nil {
+			return err
+		}
+		_, err = r.pm.applyPoolConfig(p.Username, current.Settings, current.Revision)
+		return err
+	})
+}
+
+// checkVersion reports a version whose php-fpm binary is gone, and
+// otherwise a stopped service, which it starts when healing services
+func (r *reconciler) checkVersion(t target.Target, factory *provider.ProviderFactory, providerName, version string, users []string) {
+	phpProvider, err := factory.CreateProvider(providerTypeFor(providerName))
+	if err != nil {
+		return
+	}
+	where := ""
+	if !t.IsHost() {
+		where = " in " + t.String()
+	}
+
+	// Development mode installs nothing, so binaries are never there
+	if binary := phpProvider.GetFPMBinaryPath(version); binary != "" && target.DevRoot() == "" {
+		if hostBinary, err := t.Path(binary); err == nil {
+			if _, err := os.Stat(hostBinary); os.IsNotExist(err) {
+				r.found(Discrepancy{
+					Kind:    DiscrepancyVersionMissing,
+					Subject: providerName + " " + version + where,
+					Message: fmt.Sprintf("PHP %s (%s) is used by %d pools but %s is missing; install it again or switch the pools to another version", version, providerName, len(users), binary),
+				}, "", nil)
+				// A stopped service follows from the missing version
+				r.seenService(phpProvider.GetServiceName(version) + where)
+				return
+			}
+		}
+	}
+
+	// Docker pools run in containers of their own
+	if providerTypeFor(providerName) == provider.ProviderDocker {
+		return
+	}
+	service := phpProvider.GetServiceName(version)
+	services := servicemgr.ForTarget(t, servicemgr.TargetRunner(r.pm.context(), t))
+	status, err := services.Status(service)
+	if err != nil {
+		r.seenService(service + where)
+		return
+	}
+	if status == servicemgr.StatusActive || status == servicemgr.StatusUnknown {
+		return
+	}
+	r.found(Discrepancy{
+		Kind:    DiscrepancyServiceStopped,
+		Subject: service + where,
+		Message: fmt.Sprintf("%s is %s while %d pools use PHP %s", service, status, len(users), version),
+	}, config.HealService, func() error {
+		return services.Start(service)
+	})
+}
+
+// seenService keeps a service's discrepancy open without checking it
+func (r *reconciler) seenService(subject string) {
+	r.seen[DiscrepancyServiceStopped+"|"+subject] = true
+}
+
+// found records a discrepancy, healing it first with fix when its heal
+// kind is enabled. Versions sharing a service report it once.
+func (r *reconciler) found(d Discrepancy, healKind string, fix func() error) {
+	id := d.Kind + "|" + d.Subject
+	if r.seen[id] {
+		return
+	}
+	previous, known := r.known[id]
+	r.seen[id] = true
+	d.FirstSeenAt, d.LastSeenAt = r.now, r.now
+	if known {
+		d.FirstSeenAt = previous.FirstSeenAt
+	}
+	if fix != nil && r.heal[healKind] {
+		err := r.healDiscrepancy(d, fix)
+		if err == nil {
+			if known {
+				if err := r.pm.db.DeleteDiscrepancy(d.Kind, d.Subject); err != nil && r.err == nil {
+					r.err = fmt.Errorf("failed to delete discrepancy: %w", err)
+				}
+			}
+			r.report.Healed = append(r.report.Healed, d)
+			publishDiscrepancy(d, "healed")
+			return
+		}
+		d.HealError = err.Error()
+	}
+
+	if err := r.pm.db.SaveDiscrepancy(db.Discrepancy{
+		Kind:        d.Kind,
+		Subject:     d.Subject,
+		Username:    d.Username,
+		Message:     d.Message,
+		HealError:   d.HealError,
+		FirstSeenAt: d.FirstSeenAt,
+		LastSeenAt:  d.LastSeenAt,
+	}); err != nil && r.err == nil {
+		r.err = fmt.Errorf("failed to record discrepancy: %w", err)
+	}
+	r.report.Discrepancies = append(r.report.Discrepancies, d)
+	switch {
+	case !known:
+		publishDiscrepancy(d, "detected")
+	case d.HealError != "" && d.HealError != previous.HealError:
+		publishDiscrepancy(d, "heal_failed")
+	}
+}
+
+// healDiscrepancy runs fix, audited as reconcile.heal
+func (r *reconciler) healDiscrepancy(d Discrepancy, fix func() error) (err error) {
+	defer recordAudit(r.pm.context(), r.pm.db, "reconcile.heal", d.Kind+" "+d.Subject, &err)
+	return fix()
+}
+
+func publishDiscrepancy(d Discrepancy, status string) {
+	data := map[string]interface{}{
+		"kind":    d.Kind,
+		"subject": d.Subject,
+		"message": d.Message,
+		"status":  status,
+	}
+	if d.HealError != "" {
+		data["error"] = d.HealError
+	}
+	events.Publish(events.Discrepancy, d.Username, data)
+}
+
+func discrepancyFromDB(d db.Discrepancy) Discrepancy {
+	return Discrepancy{
+		Kind:        d.Kind,
+		Subject:     d.Subject,
+		Username:    d.Username,
+		Message:     d.Message,
+		HealError:   d.HealError,
+		FirstSeenAt: d.FirstSeenAt,
+		LastSeenAt:  d.LastSeenAt,
+	}
+}
@@ -0,0 +1,184 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/provider"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+)
+
+// A drain-and-switch reload alternates a socket pool between two sockets
+// next to its listen path, which becomes a symlink to the live one. nginx
+// and this tool keep using the listen path.
+const (
+	switchSocketBlue  = ".blue"
+	switchSocketGreen = ".green"
+	// stagedPoolSuffix names the pool started next to the running one; it
+	// cannot collide with a username
+	stagedPoolSuffix = ".next"
+)
+
+// switchTimeout is how long a staged pool gets to answer FastCGI
+const switchTimeout = 10 * time.Second
+
+// reloadStrategy returns the configured reload strategy of a provider
+func reloadStrategy(providerType string) string {
+	return config.Get().Reload.StrategyFor(providerType)
+}
+
+// reloadFPMIn reloads the PHP-FPM service of a version with its provider's
+// reload strategy
+func reloadFPMIn(ctx context.Context, t target.Target, phpProvider provider.PHPProvider, version string) error {
+	return reloadServiceWith(ctx, t, phpProvider.GetServiceName(version), reloadStrategy(phpProvider.GetProviderType()))
+}
+
+// poolSwitch is a changed pool started on its next socket while the
+// running pool keeps serving the listen path
+type poolSwitch struct {
+	t      target.Target
+	listen string
+	// current is the socket serving the listen path: the listen path
+	// itself or the socket its symlink points to
+	current string
+	next    string
+	// stagedConfig is the host path of the staged pool's file
+	stagedConfig string
+}
+
+// usesDrainAndSwitch reports whether a pool change is applied by starting
+// the changed pool next to the running one. Only unix socket pools can
+// switch, and batches reload each service once at the end instead.
+func (pm *PoolManager) usesDrainAndSwitch(dbPool *db.Pool, listen string) bool {
+	if pm.pendingReloads != nil || listen != dbPool.SocketPath {
+		return false
+	}
+	if addr, err := ParseListen(listen); err != nil || !addr.IsUnix() {
+		return false
+	}
+	return reloadStrategy(dbPool.Provider) == config.ReloadDrainAndSwitch
+}
+
+// stagePool starts the changed pool under a second name on the socket that
+// is not live, reloads PHP-FPM and waits until the new pool answers
+// FastCGI. The running pool is left alone; if the staged pool does not
+// answer it is removed again.
+func (pm *PoolManager) stagePool(t target.Target, phpProvider provider.PHPProvider, dbPool *db.Pool, templateContent string, data *templates.PoolConfigData) (*poolSwitch, error) {
+	hostListen, err := t.Path(dbPool.SocketPath)
+	if err != nil {
+		return nil, err
+	}
+	sw := &poolSwitch{t: t, listen: dbPool.SocketPath, current: dbPool.SocketPath, next: dbPool.SocketPath + switchSocketBlue}
+	if link, err := os.Readlink(hostListen); err == nil {
+		sw.current = filepath.Join(filepath.Dir(dbPool.SocketPath), link)
+		if sw.current == sw.next {
+			sw.next = dbPool.SocketPath + switchSocketGreen
+		}
+	}
+
+	staged := *data
+	staged.PoolName = dbPool.Username + stagedPoolSuffix
+	staged.SocketPath = sw.next
+	content, err := templates.RenderPoolConfig(templateContent, &staged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	sw.stagedConfig = strings.TrimSuffix(hostConfigPath, ".conf") + stagedPoolSuffix + ".conf"
+	if err := writePoolFile(sw.stagedConfig, content); err != nil {
+		return nil, fmt.Errorf("failed to write staged pool config: %w", err)
+	}
+
+	serviceName := phpProvider.GetServiceName(dbPool.PHPVersion)
+	if err := reloadServiceIn(pm.context(), t, serviceName); err != nil {
+		os.Remove(sw.stagedConfig)
+		return nil, fmt.Errorf("failed to start the changed pool: %w", err)
+	}
+	if err := sw.waitForNext(); err != nil {
+		os.Remove(sw.stagedConfig)
+		reloadServiceIn(pm.context(), t, serviceName)
+		return nil, fmt.Errorf("the changed pool did not answer on %s, the running pool was kept: %w", sw.next, err)
+	}
+	return sw, nil
+}
+
+// waitForNext sends FastCGI requests to the staged pool until one is
+// answered. Any response counts, including PHP-FPM's 404 for the missing
+// script.
+func (sw *poolSwitch) waitForNext() error {
+	hostNext, err := sw.t.Path(sw.next)
+	if err != nil {
+		return err
+	}
+	params := fastCGIParams(filepath.Join(templates.TmpBaseDir, ".lightweight-php-switch-check.php"))
+	deadline := time.Now().Add(switchTimeout)
+	for {
+		_, err := dialPool(hostNext, params, 2*time.Second)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// switchOver points the listen path at the staged pool's socket, replaces
+// the staged pool with the pool's own file, which the caller has rewritten
+// to listen on sw.next, and reloads PHP-FPM. PHP-FPM hands the listening
+// socket of the staged pool to the pool on reload, so the socket nginx
+// connects to stays open throughout.
+func (sw *poolSwitch) switchOver(reload func() error) error {
+	hostListen, err := sw.t.Path(sw.listen)
+	if err != nil {
+		return err
+	}
+	// The link is relative so that it resolves both inside the target and
+	// through its root on the host
+	tmp := hostListen + ".switch"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(sw.next), tmp); err != nil {
+		return fmt.Errorf("failed to link %s: %w", sw.listen, err)
+	}
+	if err := os.Rename(tmp, hostListen); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to switch %s: %w", sw.listen, err)
+	}
+	if err := os.Remove(sw.stagedConfig); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove staged pool config: %w", err)
+	}
+	if err := reload(); err != nil {
+		return err
+	}
+	if sw.current != sw.listen {
+		if hostCurrent, err := sw.t.Path(sw.current); err == nil {
+			os.Remove(hostCurrent)
+		}
+	}
+	return nil
+}
+
+// removeSwitchSockets removes the sockets and the symlink a pool's
+// drain-and-switch reloads left behind
+func removeSwitchSockets(t target.Target, listen string) {
+	hostListen, err := t.Path(listen)
+	if err != nil {
+		return
+	}
+	if info, err := os.Lstat(hostListen); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		os.Remove(hostListen)
+	}
+	os.Remove(hostListen + switchSocketBlue)
+	os.Remove(hostListen + switchSocketGreen)
+}
@@ -0,0 +1,216 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/lock"
+	"lightweight-php/templates"
+)
+
+// renderFailureKeep is how many render failures are kept
+const renderFailureKeep = 200
+
+// secretFieldPattern matches data fields whose values are left out of
+// stored render snapshots
+var secretFieldPattern = regexp.MustCompile(`(?i)pass(word|wd)?|secret|token|credential|private|api_?key|auth`)
+
+// RenderFailure is a recorded template failure
+type RenderFailure struct {
+	ID        int64                  `json:"id"`
+	Template  string                 `json:"template"`
+	Source    string                 `json:"source"`
+	Username  string                 `json:"username,omitempty"`
+	Line      int                    `json:"line,omitempty"`
+	Field     string                 `json:"field,omitempty"`
+	Error     string                 `json:"error"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// RenderDebug is the outcome of rendering a pool's template on demand
+type RenderDebug struct {
+	Template string                 `json:"template"`
+	Source   string                 `json:"source"`
+	Output   string                 `json:"output,omitempty"`
+	Data     map[string]interface{} `json:"data"`
+	// Error and Excerpt are set when rendering fails
+	Error   *templates.RenderError `json:"error,omitempty"`
+	Excerpt string                 `json:"excerpt,omitempty"`
+}
+
+// recordRenderFailure stores a template failure with a redacted snapshot of
+// its data and publishes it. It returns err unchanged; errors that are not
+// template failures are not recorded, and recording never fails the caller.
+func recordRenderFailure(database *db.Database, username string, data interface{}, err error) error {
+	var re *templates.RenderError
+	if !errors.As(err, &re) {
+		return err
+	}
+
+	snapshot := redactSnapshot(data)
+	encoded := ""
+	if snapshot != nil {
+		if b, jerr := json.Marshal(snapshot); jerr == nil {
+			encoded = string(b)
+		}
+	}
+	id, dbErr := database.CreateRenderFailure(&db.RenderFailure{
+		Template:  re.Template,
+		Source:    re.Source,
+		Username:  username,
+		Line:      re.Line,
+		Field:     re.Field,
+		Error:     re.Error(),
+		Data:      encoded,
+		CreatedAt: time.Now().UTC(),
+	})
+	if dbErr == nil {
+		database.PruneRenderFailures(renderFailureKeep)
+	}
+
+	events.Publish(events.RenderFailed, username, map[string]interface{}{
+		"id":       id,
+		"template": re.Template,
+		"source":   re.Source,
+		"line":     re.Line,
+		"field":    re.Field,
+		"error":    re.Message,
+	})
+	return err
+}
+
+// redactSnapshot converts template data to its JSON form with the values of
+// secret-looking fields replaced
+func redactSnapshot(data interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil
+	}
+	redactValue(snapshot)
+	return snapshot
+}
+
+func redactValue(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if secretFieldPattern.MatchString(key) {
+				if value != nil && value != "" {
+					v[key] = "[redacted]"
+				}
+				continue
+			}
+			redactValue(value)
+		}
+	case []interface{}:
+		for _, item := range v {
+			redactValue(item)
+		}
+	}
+}
+
+// ListRenderFailures returns recorded template failures, newest first,
+// optionally only those of one user
+func (pm *PoolManager) ListRenderFailures(username string, limit int) ([]RenderFailure, error) {
+	records, err := pm.db.ListRenderFailures(username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list render failures: %w", err)
+	}
+	failures := make([]RenderFailure, 0, len(records))
+	for _, r := range records {
+		f := RenderFailure{
+			ID:        r.ID,
+			Template:  r.Template,
+			Source:    r.Source,
+			Username:  r.Username,
+			Line:      r.Line,
+			Field:     r.Field,
+			Error:     r.Error,
+			CreatedAt: r.CreatedAt,
+		}
+		if r.Data != "" {
+			json.Unmarshal([]byte(r.Data), &f.Data)
+		}
+		failures = append(failures, f)
+	}
+	return failures, nil
+}
+
+// DebugPoolRender renders a pool's configuration from its stored settings
+// without writing or reloading anything. templateContent replaces the
+// effective pool template when set, to try a fix before installing it.
+// A template failure is reported in the result, not as an error.
+func (pm *PoolManager) DebugPoolRender(username, templateContent string) (*RenderDebug, error) {
+	l, err := pm.acquire(lock.PoolKey(username), "debug template of "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	current, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return nil, err
+	}
+	settings := mergeSettings(current.Settings, nil)
+	if err := normalizeSettings(settings); err != nil {
+		return nil, fmt.Errorf("failed to apply settings: %w", err)
+	}
+	data, err := poolRenderData(t, username, dbPool.SocketPath, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	const name = "pool.conf.tmpl"
+	result := &RenderDebug{Template: name, Data: redactSnapshot(data)}
+	if templateContent == "" {
+		if templateContent, err = templates.LoadTemplate(name); err != nil {
+			var re *templates.RenderError
+			if !errors.As(err, &re) {
+				return nil, err
+			}
+			// The override itself is broken; debug it against this pool
+			if templateContent, err = templates.Override(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	output, err := templates.RenderPoolConfig(templateContent, data)
+	if err != nil {
+		var re *templates.RenderError
+		if !errors.As(err, &re) {
+			return nil, err
+		}
+		result.Source = re.Source
+		result.Error = re
+		result.Excerpt = templates.Excerpt(templateContent, re.Line, 2)
+		return result, nil
+	}
+	result.Source = templates.Source(name, templateContent)
+	result.Output = output
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"lightweight-php/provider"
+)
+
+// InstallError is a failed install that was rolled back, with what the
+// rollback undid
+type InstallError struct {
+	Version    string
+	Err        error
+	RolledBack []provider.UndoResult
+}
+
+func (e *InstallError) Error() string {
+	if len(e.RolledBack) == 0 {
+		return fmt.Sprintf("%v (nothing to roll back)", e.Err)
+	}
+	undone := make([]string, 0, len(e.RolledBack))
+	for _, r := range e.RolledBack {
+		if r.Error != "" {
+			undone = append(undone, fmt.Sprintf("%s (undo failed: %s)", r.Change, r.Error))
+		} else {
+			undone = append(undone, r.Change)
+		}
+	}
+	return fmt.Sprintf("%v; rolled back: %s", e.Err, strings.Join(undone, "; "))
+}
+
+func (e *InstallError) Unwrap() error {
+	return e.Err
+}
+
+// WithRollback returns a copy of the manager that undoes the changes of an
+// install that fails or leaves PHP not working: packages it installed,
+// repositories and module streams it changed, services it enabled and the
+// version's database record
+func (pm *PackageManager) WithRollback() *PackageManager {
+	c := *pm
+	c.rollback = true
+	return &c
+}
+
+// installTransaction installs a version with p and verifies that it works.
+// With rollback enabled, a failure undoes the changes the provider
+// journaled and returns an *InstallError listing them.
+func (pm *PackageManager) installTransaction(p provider.PHPProvider, version string) error {
+	existing, _ := pm.db.GetPHPVersion(version)
+
+	err := p.InstallPHP(version)
+	if err == nil {
+		if checker, ok := p.(provider.InstallChecker); ok {
+			err = checker.CheckInstall(version)
+		}
+	}
+	if err == nil || !pm.rollback {
+		return err
+	}
+
+	installErr := &InstallError{Version: version, Err: err}
+	if tx, ok := p.(provider.Transactional); ok {
+		installErr.RolledBack = tx.Rollback()
+	}
+	if existing == nil {
+		if record, _ := pm.db.GetPHPVersion(version); record != nil {
+			result := provider.UndoResult{Change: "recorded PHP " + version + " in the database"}
+			if err := pm.db.DeletePHPVersion(version); err != nil {
+				result.Error = err.Error()
+			}
+			installErr.RolledBack = append(installErr.RolledBack, result)
+		}
+	}
+	return installErr
+}
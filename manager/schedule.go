@@ -0,0 +1,267 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/maintenance"
+	"lightweight-php/templates"
+)
+
+var (
+	// ErrChangeNotFound is returned for an unknown scheduled change
+	ErrChangeNotFound = errors.New("scheduled change not found")
+	// ErrChangeNotPending is returned when modifying or cancelling a change
+	// that already ran or was cancelled
+	ErrChangeNotPending = errors.New("scheduled change is no longer pending")
+)
+
+// ScheduledChange is a settings patch and/or PHP version switch queued for
+// a pool, applied by the server's scheduler once RunAt has passed
+type ScheduledChange struct {
+	ID         int64                  `json:"id"`
+	Username   string                 `json:"username"`
+	Settings   map[string]interface{} `json:"settings,omitempty"`
+	PHPVersion string                 `json:"php_version,omitempty"`
+	RunAt      time.Time              `json:"run_at"`
+	// Status is pending, running, applied, failed or cancelled
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ChangeUpdate modifies a pending change; nil fields are left as they are
+type ChangeUpdate struct {
+	Settings   *map[string]interface{} `json:"settings,omitempty"`
+	PHPVersion *string                 `json:"php_version,omitempty"`
+	RunAt      *time.Time              `json:"run_at,omitempty"`
+}
+
+// ScheduleChange queues a change for a pool. A zero runAt means the start of
+// the next maintenance window (now when none is configured). The change is
+// validated now and again when it runs.
+func (pm *PoolManager) ScheduleChange(username string, settings map[string]interface{}, phpVersion string, runAt time.Time) (*ScheduledChange, error) {
+	now := time.Now().UTC()
+	if runAt.IsZero() {
+		runAt = maintenance.NextWindow(time.Now()).UTC()
+	}
+	if err := pm.checkScheduledChange(username, settings, phpVersion, runAt, now); err != nil {
+		return nil, err
+	}
+	encoded, err := encodeChangeSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+	id, err := pm.db.CreateScheduledChange(username, encoded, phpVersion, runAt.UTC(), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save scheduled change: %w", err)
+	}
+	return pm.GetScheduledChange(id)
+}
+
+// UpdateScheduledChange modifies a change that has not run yet
+func (pm *PoolManager) UpdateScheduledChange(id int64, update ChangeUpdate) (*ScheduledChange, error) {
+	change, err := pm.GetScheduledChange(id)
+	if err != nil {
+		return nil, err
+	}
+	if change.Status != db.ChangePending {
+		return nil, fmt.Errorf("%w: change %d is %s", ErrChangeNotPending, id, change.Status)
+	}
+	if update.Settings != nil {
+		change.Settings = *update.Settings
+	}
+	if update.PHPVersion != nil {
+		change.PHPVersion = *update.PHPVersion
+	}
+	if update.RunAt != nil {
+		change.RunAt = update.RunAt.UTC()
+	}
+	if err := pm.checkScheduledChange(change.Username, change.Settings, change.PHPVersion, change.RunAt, time.Now().UTC()); err != nil {
+		return nil, err
+	}
+	encoded, err := encodeChangeSettings(change.Settings)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := pm.db.UpdatePendingScheduledChange(id, encoded, change.PHPVersion, change.RunAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update scheduled change: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: change %d started meanwhile", ErrChangeNotPending, id)
+	}
+	return pm.GetScheduledChange(id)
+}
+
+// CancelScheduledChange cancels a change that has not run yet
+func (pm *PoolManager) CancelScheduledChange(id int64) (*ScheduledChange, error) {
+	ok, err := pm.db.TransitionScheduledChange(id, db.ChangePending, db.ChangeCancelled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel scheduled change: %w", err)
+	}
+	change, err := pm.GetScheduledChange(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: change %d is %s", ErrChangeNotPending, id, change.Status)
+	}
+	return change, nil
+}
+
+// GetScheduledChange returns a scheduled change
+func (pm *PoolManager) GetScheduledChange(id int64) (*ScheduledChange, error) {
+	record, err := pm.db.GetScheduledChange(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled change: %w", err)
+	}
+	if record == nil {
+		return nil, fmt.Errorf("%w: %d", ErrChangeNotFound, id)
+	}
+	return scheduledChangeFromRecord(record), nil
+}
+
+// ListScheduledChanges returns the changes of a user (all users if empty)
+// with a status (any if empty), in the order they run
+func (pm *PoolManager) ListScheduledChanges(username, status string) ([]ScheduledChange, error) {
+	records, err := pm.db.ListScheduledChanges(username, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled changes: %w", err)
+	}
+	changes := make([]ScheduledChange, 0, len(records))
+	for i := range records {
+		changes = append(changes, *scheduledChangeFromRecord(&records[i]))
+	}
+	return changes, nil
+}
+
+// RunDueChanges applies every pending change whose time has come, oldest
+// first, and returns them with their outcome. A change is claimed before it
+// runs, so concurrent schedulers never apply it twice.
+func (pm *PoolManager) RunDueChanges(now time.Time) ([]ScheduledChange, error) {
+	pending, err := pm.db.ListScheduledChanges("", db.ChangePending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled changes: %w", err)
+	}
+	var ran []ScheduledChange
+	for i := range pending {
+		if pending[i].RunAt.After(now) {
+			continue
+		}
+		claimed, err := pm.db.TransitionScheduledChange(pending[i].ID, db.ChangePending, db.ChangeRunning)
+		if err != nil {
+			return ran, err
+		}
+		if !claimed {
+			continue
+		}
+
+		change := scheduledChangeFromRecord(&pending[i])
+		status, message := db.ChangeApplied, ""
+		if err := pm.applyScheduledChange(change); err != nil {
+			status, message = db.ChangeFailed, err.Error()
+		}
+		finishedAt := time.Now().UTC()
+		if err := pm.db.FinishScheduledChange(change.ID, status, message, finishedAt); err != nil {
+			return ran, fmt.Errorf("failed to record scheduled change %d: %w", change.ID, err)
+		}
+		change.Status, change.Error, change.FinishedAt = status, message, &finishedAt
+		events.Publish(events.ChangeRun, change.Username, map[string]interface{}{
+			"id": change.ID, "status": status, "error": message,
+		})
+		ran = append(ran, *change)
+	}
+	return ran, nil
+}
+
+// applyScheduledChange switches the PHP version first and then applies the
+// settings; if the settings fail, the pool is switched back
+func (pm *PoolManager) applyScheduledChange(change *ScheduledChange) error {
+	dbPool, err := pm.db.GetPool(change.Username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, change.Username)
+	}
+
+	switched := change.PHPVersion != "" && change.PHPVersion != dbPool.PHPVersion
+	if switched {
+		if err := pm.SwitchPHPVersion(change.Username, change.PHPVersion); err != nil {
+			return err
+		}
+	}
+	if len(change.Settings) > 0 {
+		if err := pm.PatchPoolConfig(change.Username, change.Settings); err != nil {
+			if switched {
+				if rerr := pm.SwitchPHPVersion(change.Username, dbPool.PHPVersion); rerr != nil {
+					return fmt.Errorf("%v; switching back to PHP %s failed: %v", err, dbPool.PHPVersion, rerr)
+				}
+				return fmt.Errorf("%v; switched back to PHP %s", err, dbPool.PHPVersion)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// checkScheduledChange validates a change against the pool as it is now
+func (pm *PoolManager) checkScheduledChange(username string, settings map[string]interface{}, phpVersion string, runAt, now time.Time) error {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if len(settings) == 0 && phpVersion == "" {
+		return fmt.Errorf("a scheduled change needs settings or a php_version")
+	}
+	if runAt.Before(now.Add(-time.Minute)) {
+		return fmt.Errorf("run_at %s is in the past", runAt.Format(time.RFC3339))
+	}
+
+	check := withoutNullSettings(settings)
+	if err := normalizeSettings(check); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	data := templates.DefaultPoolConfigData(username, username, "/run/php-fpm/scheduled.sock")
+	if err := applyPoolSettings(data, check); err != nil {
+		return fmt.Errorf("invalid settings: %w", err)
+	}
+	return nil
+}
+
+func encodeChangeSettings(settings map[string]interface{}) (string, error) {
+	if len(settings) == 0 {
+		return "", nil
+	}
+	encoded, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode settings: %w", err)
+	}
+	return string(encoded), nil
+}
+
+func scheduledChangeFromRecord(record *db.ScheduledChange) *ScheduledChange {
+	change := &ScheduledChange{
+		ID:         record.ID,
+		Username:   record.Username,
+		PHPVersion: record.PHPVersion,
+		RunAt:      record.RunAt.UTC(),
+		Status:     record.Status,
+		Error:      record.Error,
+		CreatedAt:  record.CreatedAt.UTC(),
+		FinishedAt: record.FinishedAt,
+	}
+	if record.Settings != "" {
+		json.Unmarshal([]byte(record.Settings), &change.Settings)
+	}
+	return change
+}
@@ -0,0 +1,467 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"lightweight-php/billing"
+	"lightweight-php/config"
+	"lightweight-php/cron"
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/objstore"
+)
+
+var (
+	// ErrScheduleNotFound is returned for an unknown schedule
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrScheduleExists is returned when creating a schedule under a name
+	// in use
+	ErrScheduleExists = errors.New("schedule already exists")
+	// ErrScheduleReadOnly is returned when deleting a schedule of the
+	// config file
+	ErrScheduleReadOnly = errors.New("schedule is defined in the config file")
+	// ErrScheduleRunning is returned when starting a schedule whose task is
+	// still running
+	ErrScheduleRunning = errors.New("schedule is already running")
+)
+
+// Schedule sources
+const (
+	ScheduleFromConfig = "config"
+	ScheduleFromAPI    = "api"
+)
+
+// Schedule is a task the API server runs whenever Cron matches, in the
+// server's local time
+type Schedule struct {
+	Name string `json:"name"`
+	// Task is verify-config, php-upgrade, prune or backup
+	Task string `json:"task"`
+	Cron string `json:"cron"`
+	// Source is config for schedules.jobs, which only the config file
+	// changes, or api
+	Source    string       `json:"source"`
+	NextRun   *time.Time   `json:"next_run,omitempty"`
+	LastRun   *ScheduleRun `json:"last_run,omitempty"`
+	CreatedAt *time.Time   `json:"created_at,omitempty"`
+}
+
+// ScheduleRun is one run of a schedule's task. Status is running, success
+// or failed; Output summarizes what the task did.
+type ScheduleRun struct {
+	ID         int64      `json:"id"`
+	Schedule   string     `json:"schedule"`
+	Task       string     `json:"task"`
+	Status     string     `json:"status"`
+	Output     string     `json:"output,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Scheduler runs the tasks of schedules.jobs and of the schedules created
+// through the API, and keeps their run history
+type Scheduler struct {
+	pools    *PoolManager
+	packages *PackageManager
+
+	mu sync.Mutex
+	// running holds the schedules whose task is running, which are not
+	// started again until it ends
+	running map[string]bool
+	// checked is when RunDue last looked for due schedules
+	checked time.Time
+}
+
+// NewScheduler creates a scheduler running tasks with the given managers
+func NewScheduler(pools *PoolManager, packages *PackageManager) *Scheduler {
+	return &Scheduler{pools: pools, packages: packages, running: make(map[string]bool)}
+}
+
+// ListSchedules returns the schedules of the config file followed by those
+// of the API, with their next and last runs
+func (s *Scheduler) ListSchedules() ([]Schedule, error) {
+	stored, err := s.pools.db.ListSchedules()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	jobs := config.Get().Schedules.Jobs
+	schedules := make([]Schedule, 0, len(jobs)+len(stored))
+	for _, job := range jobs {
+		schedules = append(schedules, Schedule{Name: job.Name, Task: job.Task, Cron: job.Cron, Source: ScheduleFromConfig})
+	}
+	for _, record := range stored {
+		createdAt := record.CreatedAt
+		schedules = append(schedules, Schedule{Name: record.Name, Task: record.Task, Cron: record.Cron, Source: ScheduleFromAPI, CreatedAt: &createdAt})
+	}
+
+	now := time.Now()
+	for i := range schedules {
+		if err := s.describe(&schedules[i], now); err != nil {
+			return nil, err
+		}
+	}
+	return schedules, nil
+}
+
+// GetSchedule returns a schedule with its next and last runs
+func (s *Scheduler) GetSchedule(name string) (*Schedule, error) {
+	schedule, err := s.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.describe(schedule, time.Now()); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// CreateSchedule stores a schedule that runs task whenever cronExpr
+// matches
+func (s *Scheduler) CreateSchedule(name, task, cronExpr string) (*Schedule, error) {
+	if err := config.ValidateScheduleJob(config.ScheduleJob{Name: name, Task: task, Cron: cronExpr}); err != nil {
+		return nil, err
+	}
+	if existing, err := s.find(name); err == nil {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrScheduleExists, name, existing.Source)
+	} else if !errors.Is(err, ErrScheduleNotFound) {
+		return nil, err
+	}
+	if _, err := s.pools.db.CreateSchedule(name, task, cronExpr, time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("failed to save schedule: %w", err)
+	}
+	return s.GetSchedule(name)
+}
+
+// DeleteSchedule removes a schedule created through the API. Its run
+// history is kept until pruned.
+func (s *Scheduler) DeleteSchedule(name string) error {
+	schedule, err := s.find(name)
+	if err != nil {
+		return err
+	}
+	if schedule.Source == ScheduleFromConfig {
+		return fmt.Errorf("%w: remove %s from schedules.jobs instead", ErrScheduleReadOnly, name)
+	}
+	if _, err := s.pools.db.DeleteSchedule(name); err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+	return nil
+}
+
+// ListRuns returns a schedule's runs, newest first
+func (s *Scheduler) ListRuns(name string, limit int) ([]ScheduleRun, error) {
+	if _, err := s.find(name); err != nil {
+		return nil, err
+	}
+	records, err := s.pools.db.ListScheduleRuns(name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+	runs := make([]ScheduleRun, 0, len(records))
+	for _, r := range records {
+		runs = append(runs, scheduleRunFromRecord(&r))
+	}
+	return runs, nil
+}
+
+// RunNow runs a schedule's task and waits for it to end
+func (s *Scheduler) RunNow(name string) (*ScheduleRun, error) {
+	schedule, err := s.find(name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.begin(schedule)
+	if err != nil {
+		return nil, err
+	}
+	s.execute(id, schedule)
+	return s.getRun(id)
+}
+
+// Start runs a schedule's task in the background and returns its run
+func (s *Scheduler) Start(name string) (*ScheduleRun, error) {
+	schedule, err := s.find(name)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.begin(schedule)
+	if err != nil {
+		return nil, err
+	}
+	run, err := s.getRun(id)
+	go s.execute(id, schedule)
+	return run, err
+}
+
+// RunDue starts the schedules that matched a minute since the previous
+// call and returns their names. The first call only marks the start, so
+// runs missed while the server was stopped are skipped. Runs left running
+// by a stopped server are marked failed then.
+func (s *Scheduler) RunDue(now time.Time) ([]string, error) {
+	s.mu.Lock()
+	since := s.checked
+	s.checked = now
+	s.mu.Unlock()
+	if since.IsZero() {
+		return nil, s.pools.db.FailRunningScheduleRuns("the server stopped during the run", now.UTC())
+	}
+
+	schedules, err := s.ListSchedules()
+	if err != nil {
+		return nil, err
+	}
+	var started []string
+	for i := range schedules {
+		schedule := &schedules[i]
+		parsed, err := cron.Parse(schedule.Cron)
+		if err != nil {
+			continue
+		}
+		if next := parsed.Next(since); next.IsZero() || next.After(now) {
+			continue
+		}
+		id, err := s.begin(schedule)
+		if err != nil {
+			// Still running since the previous match
+			continue
+		}
+		go s.execute(id, schedule)
+		started = append(started, schedule.Name)
+	}
+	return started, nil
+}
+
+// find returns a schedule of the config file or the API
+func (s *Scheduler) find(name string) (*Schedule, error) {
+	for _, job := range config.Get().Schedules.Jobs {
+		if job.Name == name {
+			return &Schedule{Name: job.Name, Task: job.Task, Cron: job.Cron, Source: ScheduleFromConfig}, nil
+		}
+	}
+	record, err := s.pools.db.GetSchedule(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	if record == nil {
+		return nil, fmt.Errorf("%w: %s", ErrScheduleNotFound, name)
+	}
+	createdAt := record.CreatedAt
+	return &Schedule{Name: record.Name, Task: record.Task, Cron: record.Cron, Source: ScheduleFromAPI, CreatedAt: &createdAt}, nil
+}
+
+// describe fills a schedule's next and last runs
+func (s *Scheduler) describe(schedule *Schedule, now time.Time) error {
+	if parsed, err := cron.Parse(schedule.Cron); err == nil {
+		if next := parsed.Next(now); !next.IsZero() {
+			schedule.NextRun = &next
+		}
+	}
+	runs, err := s.pools.db.ListScheduleRuns(schedule.Name, 1)
+	if err != nil {
+		return fmt.Errorf("failed to get the last run of %s: %w", schedule.Name, err)
+	}
+	if len(runs) > 0 {
+		last := scheduleRunFromRecord(&runs[0])
+		schedule.LastRun = &last
+	}
+	return nil
+}
+
+// begin claims a schedule and records the start of its run
+func (s *Scheduler) begin(schedule *Schedule) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[schedule.Name] {
+		return 0, fmt.Errorf("%w: %s", ErrScheduleRunning, schedule.Name)
+	}
+	id, err := s.pools.db.CreateScheduleRun(schedule.Name, schedule.Task, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to record run: %w", err)
+	}
+	s.running[schedule.Name] = true
+	return id, nil
+}
+
+// execute runs a claimed schedule's task, stores the outcome and reports a
+// failure to schedules.webhook_url
+func (s *Scheduler) execute(id int64, schedule *Schedule) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, schedule.Name)
+		s.mu.Unlock()
+	}()
+
+	output, err := s.runTask(schedule.Task)
+	status, errMsg := db.RunSuccess, ""
+	if err != nil {
+		status, errMsg = db.RunFailed, err.Error()
+	}
+	s.pools.db.FinishScheduleRun(id, status, output, errMsg, time.Now().UTC())
+
+	run, getErr := s.getRun(id)
+	if getErr != nil {
+		return
+	}
+	data := map[string]interface{}{"schedule": run.Schedule, "task": run.Task, "run_id": run.ID, "status": run.Status}
+	if run.Error != "" {
+		data["error"] = run.Error
+	}
+	events.Publish(events.ScheduleRun, "", data)
+	if url := config.Get().Schedules.WebhookURL; url != "" && run.Status == db.RunFailed {
+		if err := postScheduleWebhook(url, run); err != nil {
+			fmt.Printf("Warning: schedule %s: %v\n", run.Schedule, err)
+		}
+	}
+}
+
+// runTask runs a task and summarizes what it did
+func (s *Scheduler) runTask(task string) (string, error) {
+	switch task {
+	case "verify-config":
+		diagnostics, err := s.pools.Doctor("")
+		if err != nil {
+			return "", err
+		}
+		var lines []string
+		for _, f := range diagnostics.Findings {
+			if f.Status == FindingError || f.Status == FindingWarning {
+				lines = append(lines, fmt.Sprintf("%s %s %s: %s", f.Status, f.Check, f.Subject, f.Message))
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%d errors, %d warnings", diagnostics.Errors, diagnostics.Warnings))
+		output := strings.Join(lines, "\n")
+		if !diagnostics.OK {
+			return output, fmt.Errorf("configuration check found %d errors", diagnostics.Errors)
+		}
+		return output, nil
+
+	case "php-upgrade":
+		results, err := s.packages.UpgradeAllPHP()
+		lines := make([]string, 0, len(results))
+		for _, r := range results {
+			if r.Error != "" {
+				lines = append(lines, fmt.Sprintf("PHP %s (%s): %s", r.Version, r.Provider, r.Error))
+			} else {
+				lines = append(lines, fmt.Sprintf("PHP %s (%s): upgraded", r.Version, r.Provider))
+			}
+		}
+		if len(results) == 0 && err == nil {
+			lines = append(lines, "No installed version can be upgraded in place")
+		}
+		return strings.Join(lines, "\n"), err
+
+	case "prune":
+		return s.prune()
+
+	case "backup":
+		cfg := config.Get()
+		if cfg.Backup.URL == "" {
+			return "", fmt.Errorf("backup.url is not set")
+		}
+		store, err := objstore.Open(cfg.Backup.URL, cfg.S3)
+		if err != nil {
+			return "", err
+		}
+		uploaded, err := s.pools.RunBackups(store, !cfg.Backup.SkipFiles, cfg.Backup.RetentionDays)
+		return fmt.Sprintf("Uploaded %d account backups to %s", len(uploaded), store), err
+	}
+	return "", fmt.Errorf("unknown task %q", task)
+}
+
+// prune drops history past its retention: audit entries, schedule runs,
+// and the data and records of erased accounts
+func (s *Scheduler) prune() (string, error) {
+	cfg := config.Get()
+	now := time.Now().UTC()
+	var lines []string
+	if days := cfg.Retention.AuditDays; days > 0 {
+		if err := s.pools.db.PruneAuditEntries(now.AddDate(0, 0, -days)); err != nil {
+			return "", fmt.Errorf("failed to prune the audit log: %w", err)
+		}
+		lines = append(lines, fmt.Sprintf("Pruned audit entries older than %d days", days))
+	}
+	if days := cfg.Schedules.HistoryDays; days > 0 {
+		if err := s.pools.db.PruneScheduleRuns(now.AddDate(0, 0, -days)); err != nil {
+			return "", fmt.Errorf("failed to prune schedule runs: %w", err)
+		}
+		lines = append(lines, fmt.Sprintf("Pruned schedule runs older than %d days", days))
+	}
+	if months := cfg.Retention.UsageMonths; months > 0 {
+		if err := s.pools.db.PrunePoolUsage(billing.PeriodOf(time.Date(now.Year(), now.Month()-time.Month(months), 1, 0, 0, 0, 0, time.UTC))); err != nil {
+			return "", fmt.Errorf("failed to prune billing usage: %w", err)
+		}
+		lines = append(lines, fmt.Sprintf("Pruned billing usage older than %d months", months))
+	}
+	if err := s.pools.db.PruneIdempotencyKeys(now.Add(-IdempotencyTTL)); err != nil {
+		return "", fmt.Errorf("failed to prune idempotency keys: %w", err)
+	}
+	sweep, err := s.pools.PurgeExpiredRetention()
+	if sweep != nil {
+		lines = append(lines, fmt.Sprintf("Removed %d retained paths and %d erasure records", sweep.RemovedPaths, sweep.DeletedRecords))
+	}
+	return strings.Join(lines, "\n"), err
+}
+
+func (s *Scheduler) getRun(id int64) (*ScheduleRun, error) {
+	record, err := s.pools.db.GetScheduleRun(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get run: %w", err)
+	}
+	if record == nil {
+		return nil, fmt.Errorf("run %d not found", id)
+	}
+	run := scheduleRunFromRecord(record)
+	return &run, nil
+}
+
+func scheduleRunFromRecord(r *db.ScheduleRun) ScheduleRun {
+	return ScheduleRun{
+		ID:         r.ID,
+		Schedule:   r.Schedule,
+		Task:       r.Task,
+		Status:     r.Status,
+		Output:     r.Output,
+		Error:      r.Error,
+		StartedAt:  r.StartedAt,
+		FinishedAt: r.FinishedAt,
+	}
+}
+
+func postScheduleWebhook(url string, run *ScheduleRun) error {
+	hostname, _ := os.Hostname()
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":       events.ScheduleRun,
+		"host":        hostname,
+		"schedule":    run.Schedule,
+		"task":        run.Task,
+		"run_id":      run.ID,
+		"status":      run.Status,
+		"error":       run.Error,
+		"output":      run.Output,
+		"started_at":  run.StartedAt,
+		"finished_at": run.FinishedAt,
+		"time":        time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post failure: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("schedule webhook returned %s", resp.Status)
+	}
+	return nil
+}
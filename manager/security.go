@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"lightweight-php/templates"
+)
+
+// Security levels accepted by the security_level pool setting
+const (
+	SecurityRelaxed  = "relaxed"
+	SecurityStandard = "standard"
+	SecurityHardened = "hardened"
+)
+
+// SecurityLevels lists the security levels from least to most restrictive
+var SecurityLevels = []string{SecurityRelaxed, SecurityStandard, SecurityHardened}
+
+// securityPreset is the php.ini hardening a security level renders
+type securityPreset struct {
+	disableFunctions []string
+	allowURLFopen    string
+	cookieSecure     string
+	cookieSameSite   string
+	strictMode       string
+}
+
+var (
+	// Functions that run programs or replace the process
+	execFunctions = []string{"exec", "passthru", "pcntl_exec", "popen", "proc_open", "shell_exec", "system"}
+
+	// Functions that leak server details or manipulate processes and links
+	hardenedFunctions = []string{
+		"dl", "highlight_file", "link", "openlog", "pcntl_alarm", "pcntl_fork", "pcntl_signal",
+		"phpinfo", "posix_kill", "posix_mkfifo", "posix_setgid", "posix_setpgid", "posix_setsid",
+		"posix_setuid", "proc_close", "proc_get_status", "proc_nice", "proc_terminate", "putenv",
+		"show_source", "symlink", "syslog",
+	}
+
+	functionNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+var securityPresets = map[string]securityPreset{
+	SecurityRelaxed: {
+		allowURLFopen: "1",
+	},
+	SecurityStandard: {
+		disableFunctions: execFunctions,
+		allowURLFopen:    "1",
+		cookieSameSite:   "Lax",
+		strictMode:       "1",
+	},
+	SecurityHardened: {
+		disableFunctions: append(append([]string{}, execFunctions...), hardenedFunctions...),
+		allowURLFopen:    "0",
+		cookieSecure:     "1",
+		cookieSameSite:   "Strict",
+		strictMode:       "1",
+	},
+}
+
+// applySecuritySettings renders the security_level preset and its
+// overrides: disable_functions_allow re-enables preset functions,
+// disable_functions_extra disables more and an explicit allow_url_fopen
+// wins over the preset's choice. Without security_level nothing is rendered.
+func applySecuritySettings(data *templates.PoolConfigData, settings map[string]interface{}) error {
+	allow, err := settingFunctionList(settings, "disable_functions_allow")
+	if err != nil {
+		return err
+	}
+	extra, err := settingFunctionList(settings, "disable_functions_extra")
+	if err != nil {
+		return err
+	}
+
+	level, _ := settings["security_level"].(string)
+	if level == "" {
+		if len(allow) > 0 || len(extra) > 0 {
+			return fmt.Errorf("disable_functions_allow and disable_functions_extra require security_level")
+		}
+		return nil
+	}
+	preset, ok := securityPresets[level]
+	if !ok {
+		return fmt.Errorf("invalid security_level %q; expected one of %s", level, strings.Join(SecurityLevels, ", "))
+	}
+
+	disabled := make(map[string]bool)
+	for _, fn := range preset.disableFunctions {
+		disabled[fn] = true
+	}
+	for _, fn := range extra {
+		disabled[fn] = true
+	}
+	for _, fn := range allow {
+		delete(disabled, fn)
+	}
+	functions := make([]string, 0, len(disabled))
+	for fn := range disabled {
+		functions = append(functions, fn)
+	}
+	sort.Strings(functions)
+
+	data.DisableFunctions = strings.Join(functions, ",")
+	data.ExposePHP = "0"
+	if _, ok := settings["allow_url_fopen"]; !ok {
+		data.AllowURLFopen = preset.allowURLFopen
+	}
+	data.SessionCookieHTTPOnly = "1"
+	data.SessionCookieSecure = preset.cookieSecure
+	data.SessionCookieSameSite = preset.cookieSameSite
+	data.SessionUseStrictMode = preset.strictMode
+	return nil
+}
+
+// settingFunctionList reads a list of PHP function names given as a JSON
+// array or a comma-separated string
+func settingFunctionList(settings map[string]interface{}, key string) ([]string, error) {
+	value, ok := settings[key]
+	if !ok {
+		return nil, nil
+	}
+
+	var names []string
+	switch v := value.(type) {
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must list function names", key)
+			}
+			names = append(names, strings.TrimSpace(name))
+		}
+	default:
+		return nil, fmt.Errorf("%s must be a list of function names", key)
+	}
+
+	for _, name := range names {
+		if !functionNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid function name %q", key, name)
+		}
+	}
+	return names, nil
+}
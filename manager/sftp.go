@@ -0,0 +1,207 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/servicemgr"
+	"lightweight-php/system"
+	"lightweight-php/target"
+)
+
+// sftpHeader starts every snippet and records the home directory's owner
+// and mode from before it was handed to root for the chroot
+const sftpHeader = "# Managed by lightweight-php: SFTP-only login of pool "
+
+// sftpSnippetPath returns the sshd_config.d file of a user's SFTP login
+func sftpSnippetPath(username string) string {
+	return filepath.Join(config.Get().SFTP.ConfigDir, "lightweight-php-sftp-"+username+".conf")
+}
+
+// sshService returns the name of the OpenSSH server's service
+func (pm *PoolManager) sshService() string {
+	if pm.osFamily == system.OSDebian {
+		return "ssh"
+	}
+	return "sshd"
+}
+
+// provisionSFTP gives a pool's user an SFTP-only login chrooted to their
+// home and starting in sftp.docroot. sshd requires the chroot to be owned
+// by root and writable by nobody else, so the home is handed to root and
+// its previous owner and mode are kept in the snippet for removeSFTP. An
+// existing snippet is left as it is. Pools in other targets are skipped.
+func (pm *PoolManager) provisionSFTP(t target.Target, username string) error {
+	cfg := config.Get().SFTP
+	if !cfg.Enabled || !t.IsHost() {
+		return nil
+	}
+	snippetPath, err := t.Path(sftpSnippetPath(username))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(snippetPath); err == nil {
+		return nil
+	}
+
+	u, err := t.LookupUser(username)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+	}
+	if !filepath.IsAbs(u.HomeDir) || filepath.Clean(u.HomeDir) == "/" {
+		return fmt.Errorf("home directory %q of %s cannot be a chroot", u.HomeDir, username)
+	}
+	home, err := t.Path(u.HomeDir)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(home)
+	if err != nil {
+		return fmt.Errorf("failed to inspect home directory: %w", err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	hostUID, hostGID, err := t.HostIDs(uid, gid)
+	if err != nil {
+		return err
+	}
+
+	docroot := filepath.Join(home, cfg.Docroot)
+	if err := os.MkdirAll(docroot, 0755); err != nil {
+		return fmt.Errorf("failed to create docroot: %w", err)
+	}
+	if err := os.Chown(docroot, hostUID, hostGID); err != nil {
+		return fmt.Errorf("failed to chown docroot: %w", err)
+	}
+
+	snippet := fmt.Sprintf(`%s%s
+# home %s owner %d:%d mode %04o
+Match User %s
+    ChrootDirectory %s
+    ForceCommand internal-sftp -d /%s
+    AllowTcpForwarding no
+    X11Forwarding no
+    PermitTunnel no
+Match all
+`, sftpHeader, username, u.HomeDir, uid, gid, info.Mode().Perm(), username, u.HomeDir, filepath.ToSlash(filepath.Clean(cfg.Docroot)))
+	if err := os.MkdirAll(filepath.Dir(snippetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", cfg.ConfigDir, err)
+	}
+	if err := os.WriteFile(snippetPath, []byte(snippet), 0644); err != nil {
+		return fmt.Errorf("failed to write sshd config: %w", err)
+	}
+	if err := os.Chown(home, 0, 0); err == nil {
+		err = os.Chmod(home, 0755)
+	}
+	if err != nil {
+		os.Remove(snippetPath)
+		restoreSFTPHome(home, hostUID, hostGID, info.Mode().Perm())
+		return fmt.Errorf("failed to hand the home directory to root: %w", err)
+	}
+
+	if err := pm.reloadSSH(t); err != nil {
+		os.Remove(snippetPath)
+		restoreSFTPHome(home, hostUID, hostGID, info.Mode().Perm())
+		return err
+	}
+	return nil
+}
+
+// removeSFTP removes a user's SFTP login and gives the home directory back
+// to its owner
+func (pm *PoolManager) removeSFTP(t target.Target, username string) error {
+	if !t.IsHost() {
+		return nil
+	}
+	snippetPath, err := t.Path(sftpSnippetPath(username))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(snippetPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sshd config: %w", err)
+	}
+	if err := os.Remove(snippetPath); err != nil {
+		return fmt.Errorf("failed to remove sshd config: %w", err)
+	}
+
+	var home string
+	var uid, gid int
+	var mode os.FileMode
+	for _, line := range strings.Split(string(data), "\n") {
+		if _, err := fmt.Sscanf(line, "# home %s owner %d:%d mode %o", &home, &uid, &gid, &mode); err == nil {
+			break
+		}
+		home = ""
+	}
+	if home != "" {
+		hostHome, err := t.Path(home)
+		if err != nil {
+			return err
+		}
+		hostUID, hostGID, err := t.HostIDs(uid, gid)
+		if err != nil {
+			return err
+		}
+		if err := restoreSFTPHome(hostHome, hostUID, hostGID, mode); err != nil {
+			return fmt.Errorf("failed to give the home directory back to %s: %w", username, err)
+		}
+	}
+	return pm.reloadSSH(t)
+}
+
+func restoreSFTPHome(home string, uid, gid int, mode os.FileMode) error {
+	if err := os.Chown(home, uid, gid); err != nil {
+		return err
+	}
+	return os.Chmod(home, mode)
+}
+
+// reloadSSH checks the sshd configuration and reloads the server, so a
+// broken snippet never reaches a running sshd
+func (pm *PoolManager) reloadSSH(t target.Target) error {
+	if output, err := t.Command("sshd", "-t").CombinedOutput(); err != nil {
+		return fmt.Errorf("sshd rejected the configuration: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	if err := servicemgr.ForTarget(t, servicemgr.TargetRunner(pm.context(), t)).Reload(pm.sshService()); err != nil {
+		return fmt.Errorf("failed to reload %s: %w", pm.sshService(), err)
+	}
+	return nil
+}
+
+// SyncSFTP gives the users of pools on the host that existed before
+// sftp.enabled was set their SFTP login and returns their names
+func (pm *PoolManager) SyncSFTP() ([]string, error) {
+	if !config.Get().SFTP.Enabled {
+		return nil, fmt.Errorf("SFTP logins are disabled; set sftp.enabled in the config file")
+	}
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	added := []string{}
+	for _, p := range pools {
+		if !isHostPool(&p) {
+			continue
+		}
+		snippetPath, err := target.Host.Path(sftpSnippetPath(p.Username))
+		if err != nil {
+			return added, err
+		}
+		if _, err := os.Stat(snippetPath); err == nil {
+			continue
+		}
+		if err := pm.provisionSFTP(target.Host, p.Username); err != nil {
+			return added, fmt.Errorf("%s: %w", p.Username, err)
+		}
+		added = append(added, p.Username)
+	}
+	return added, nil
+}
@@ -0,0 +1,398 @@
+package manager
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+	"lightweight-php/tracing"
+	"lightweight-php/validation"
+)
+
+// SiteSnippetDir holds the generated nginx snippets, one per site
+const SiteSnippetDir = "/etc/nginx/lightweight-php"
+
+// ErrSiteNotFound is returned for an unknown domain
+var ErrSiteNotFound = errors.New("site not found")
+
+// Site is a domain served by one or more pools of the same user
+type Site struct {
+	Domain       string
+	Username     string
+	DocumentRoot string
+	Bindings     []SiteBinding
+	SnippetPath  string
+	// Certificate is the site's ACME certificate, if one was requested
+	Certificate *Certificate `json:",omitempty"`
+	// DNS is set when a site is created with a DNS check or update
+	DNS *SiteDNS `json:",omitempty"`
+}
+
+// SiteBinding routes a path prefix of a site to the user's pool for a PHP version
+type SiteBinding struct {
+	PathPrefix string
+	PHPVersion string
+	SocketPath string
+}
+
+type SiteManager struct {
+	db *db.Database
+	// ctx carries the span that operations are traced under
+	ctx context.Context
+}
+
+func NewSiteManager() (*SiteManager, error) {
+	database, err := db.NewDatabase("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return NewSiteManagerWithDeps(database), nil
+}
+
+// NewSiteManagerWithDeps creates a site manager on a shared database handle
+func NewSiteManagerWithDeps(database *db.Database) *SiteManager {
+	return &SiteManager{db: database}
+}
+
+// WithContext returns a copy of the manager whose operations are traced as
+// children of the span in ctx
+func (sm *SiteManager) WithContext(ctx context.Context) *SiteManager {
+	c := *sm
+	c.ctx = ctx
+	return &c
+}
+
+func (sm *SiteManager) context() context.Context {
+	if sm.ctx == nil {
+		return context.Background()
+	}
+	return sm.ctx
+}
+
+// CreateSite registers a site whose "/" binding points at the user's pool
+// for phpVersion and writes its nginx snippet
+func (sm *SiteManager) CreateSite(domain, username, documentRoot, phpVersion string) (_ *Site, err error) {
+	defer recordAudit(sm.context(), sm.db, "site.create", domain, &err)
+	if err := validation.Field("domain", domain, validation.Domain); err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(documentRoot) {
+		return nil, fmt.Errorf("document root must be an absolute path: %s", documentRoot)
+	}
+
+	existing, err := sm.db.GetSite(domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check site: %w", err)
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("site %s already exists", domain)
+	}
+
+	pool, err := sm.lookupPool(username, phpVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	siteID, err := sm.db.CreateSite(domain, username, documentRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save site to database: %w", err)
+	}
+	if err := sm.db.SetSiteBinding(siteID, "/", pool.ID); err != nil {
+		sm.db.DeleteSite(domain)
+		return nil, fmt.Errorf("failed to save site binding: %w", err)
+	}
+
+	return sm.writeSnippet(domain)
+}
+
+// BindPath routes requests under pathPrefix to the site user's pool for phpVersion
+func (sm *SiteManager) BindPath(domain, pathPrefix, phpVersion string) (_ *Site, err error) {
+	defer recordAudit(sm.context(), sm.db, "site.bind", domain, &err)
+	pathPrefix, err = normalizePathPrefix(pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	site, err := sm.getSiteRecord(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := sm.lookupPool(site.Username, phpVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sm.db.SetSiteBinding(site.ID, pathPrefix, pool.ID); err != nil {
+		return nil, fmt.Errorf("failed to save site binding: %w", err)
+	}
+
+	return sm.writeSnippet(domain)
+}
+
+// UnbindPath removes a path binding; the "/" binding cannot be removed
+func (sm *SiteManager) UnbindPath(domain, pathPrefix string) (_ *Site, err error) {
+	defer recordAudit(sm.context(), sm.db, "site.unbind", domain, &err)
+	pathPrefix, err = normalizePathPrefix(pathPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if pathPrefix == "/" {
+		return nil, fmt.Errorf("the default / binding cannot be removed; bind / to another version instead")
+	}
+
+	site, err := sm.getSiteRecord(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sm.db.DeleteSiteBinding(site.ID, pathPrefix); err == sql.ErrNoRows {
+		return nil, fmt.Errorf("site %s has no binding for %s", domain, pathPrefix)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to delete site binding: %w", err)
+	}
+
+	return sm.writeSnippet(domain)
+}
+
+func (sm *SiteManager) GetSite(domain string) (*Site, error) {
+	site, err := sm.getSiteRecord(domain)
+	if err != nil {
+		return nil, err
+	}
+	return sm.toSite(site)
+}
+
+func (sm *SiteManager) ListSites() ([]Site, error) {
+	dbSites, err := sm.db.ListSites()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sites from database: %w", err)
+	}
+
+	sites := make([]Site, 0, len(dbSites))
+	for i := range dbSites {
+		site, err := sm.toSite(&dbSites[i])
+		if err != nil {
+			return nil, err
+		}
+		sites = append(sites, *site)
+	}
+	return sites, nil
+}
+
+// DeleteSite removes the site, its bindings and its nginx snippet
+func (sm *SiteManager) DeleteSite(domain string) (err error) {
+	defer recordAudit(sm.context(), sm.db, "site.delete", domain, &err)
+	if _, err := sm.getSiteRecord(domain); err != nil {
+		return err
+	}
+
+	if err := sm.db.DeleteSite(domain); err != nil {
+		return fmt.Errorf("failed to delete site from database: %w", err)
+	}
+
+	if err := os.Remove(siteSnippetPath(domain)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove site snippet: %w", err)
+	}
+	if err := os.RemoveAll(hostPath(filepath.Join(config.Get().ACME.CertDir, domain))); err != nil {
+		return fmt.Errorf("failed to remove site certificate: %w", err)
+	}
+	return reloadNginx(sm.context())
+}
+
+// RenderSnippet renders the nginx snippet for a site without writing it
+func (sm *SiteManager) RenderSnippet(domain string) (string, error) {
+	site, err := sm.GetSite(domain)
+	if err != nil {
+		return "", err
+	}
+
+	data := &templates.SiteConfigData{
+		Domain:       site.Domain,
+		Username:     site.Username,
+		DocumentRoot: site.DocumentRoot,
+	}
+	if c := site.Certificate; c != nil && c.NotAfter != nil {
+		data.CertificatePath = c.CertPath
+		data.CertificateKeyPath = c.KeyPath
+	}
+	if data.ProxyPass, err = migrationProxy(sm.db, site.Username); err != nil {
+		return "", err
+	}
+	for _, b := range site.Bindings {
+		data.Locations = append(data.Locations, templates.SiteLocation{
+			PathPrefix:  b.PathPrefix,
+			PHPVersion:  b.PHPVersion,
+			FastCGIPass: fastCGIPass(b.SocketPath),
+			Default:     b.PathPrefix == "/",
+		})
+	}
+
+	templateContent, err := templates.LoadTemplate("nginx-site.conf.tmpl")
+	if err != nil {
+		return "", fmt.Errorf("failed to load template: %w", recordRenderFailure(sm.db, site.Username, nil, err))
+	}
+	snippet, err := templates.RenderSiteConfig(templateContent, data)
+	if err != nil {
+		return "", recordRenderFailure(sm.db, site.Username, data, err)
+	}
+	return snippet, nil
+}
+
+func (sm *SiteManager) writeSnippet(domain string) (*Site, error) {
+	snippet, err := sm.RenderSnippet(domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render site snippet: %w", err)
+	}
+
+	if err := os.MkdirAll(hostPath(SiteSnippetDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snippet directory: %w", err)
+	}
+
+	path := siteSnippetPath(domain)
+	previous, readErr := os.ReadFile(path)
+	if err := os.WriteFile(path, []byte(snippet), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write site snippet: %w", err)
+	}
+
+	if err := reloadNginx(sm.context()); err != nil {
+		// Restore the previous snippet so nginx keeps a valid configuration
+		if readErr == nil {
+			os.WriteFile(path, previous, 0644)
+		} else {
+			os.Remove(path)
+		}
+		return nil, err
+	}
+
+	return sm.GetSite(domain)
+}
+
+// refreshPoolSites rewrites the snippets of every site with a binding to
+// the pool, after the pool moved to another listen address
+func (sm *SiteManager) refreshPoolSites(poolID int64) error {
+	sites, err := sm.db.ListSites()
+	if err != nil {
+		return fmt.Errorf("failed to list sites from database: %w", err)
+	}
+	for _, s := range sites {
+		bindings, err := sm.db.ListSiteBindings(s.ID)
+		if err != nil {
+			return fmt.Errorf("failed to list bindings of %s: %w", s.Domain, err)
+		}
+		for _, b := range bindings {
+			if b.PoolID != poolID {
+				continue
+			}
+			if _, err := sm.writeSnippet(s.Domain); err != nil {
+				return fmt.Errorf("failed to update site %s: %w", s.Domain, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (sm *SiteManager) getSiteRecord(domain string) (*db.Site, error) {
+	site, err := sm.db.GetSite(domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get site from database: %w", err)
+	}
+	if site == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSiteNotFound, domain)
+	}
+	return site, nil
+}
+
+func (sm *SiteManager) lookupPool(username, phpVersion string) (*db.Pool, error) {
+	var pool *db.Pool
+	var err error
+	if phpVersion == "" {
+		pool, err = sm.db.GetPool(username)
+	} else {
+		pool, err = sm.db.GetPoolByUsernameAndVersion(username, phpVersion)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if pool == nil {
+		if phpVersion == "" {
+			return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+		}
+		return nil, fmt.Errorf("pool for user %s with PHP %s not found", username, phpVersion)
+	}
+	return pool, nil
+}
+
+func (sm *SiteManager) toSite(s *db.Site) (*Site, error) {
+	dbBindings, err := sm.db.ListSiteBindings(s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list site bindings: %w", err)
+	}
+
+	site := &Site{
+		Domain:       s.Domain,
+		Username:     s.Username,
+		DocumentRoot: s.DocumentRoot,
+		Bindings:     make([]SiteBinding, 0, len(dbBindings)),
+		SnippetPath:  siteSnippetPath(s.Domain),
+	}
+	for _, b := range dbBindings {
+		site.Bindings = append(site.Bindings, SiteBinding{
+			PathPrefix: b.PathPrefix,
+			PHPVersion: b.PHPVersion,
+			SocketPath: b.SocketPath,
+		})
+	}
+
+	cert, err := sm.db.GetCertificate(s.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get site certificate: %w", err)
+	}
+	if cert != nil {
+		site.Certificate = toCertificate(cert)
+	}
+	return site, nil
+}
+
+func siteSnippetPath(domain string) string {
+	return hostPath(filepath.Join(SiteSnippetDir, domain+".conf"))
+}
+
+// normalizePathPrefix ensures prefixes look like "/old/" (or "/")
+func normalizePathPrefix(prefix string) (string, error) {
+	if !strings.HasPrefix(prefix, "/") {
+		return "", fmt.Errorf("path prefix must start with /: %s", prefix)
+	}
+	if strings.ContainsAny(prefix, " \t\n;{}\"'") {
+		return "", fmt.Errorf("invalid path prefix: %s", prefix)
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix, nil
+}
+
+// reloadNginx validates and reloads nginx when it is installed
+func reloadNginx(ctx context.Context) error {
+	if err := target.Host.LookPath("nginx"); err != nil {
+		return nil
+	}
+	cmd := target.Host.Command("nginx", "-t")
+	span := tracing.StartCommand(ctx, cmd)
+	output, err := cmd.CombinedOutput()
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return fmt.Errorf("nginx configuration test failed: %s", strings.TrimSpace(string(output)))
+	}
+	return reloadService(ctx, "nginx")
+}
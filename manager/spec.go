@@ -0,0 +1,165 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"lightweight-php/target"
+)
+
+// Outcomes of ApplyPoolSpec
+const (
+	SpecCreated  = "created"
+	SpecUpdated  = "updated"
+	SpecNoChange = "no-change"
+)
+
+// ErrSpecConflict is returned when a desired pool spec changes a field that
+// cannot be updated in place; declarative clients replace the pool instead
+var ErrSpecConflict = errors.New("pool spec conflicts with the existing pool")
+
+// PoolSpec is the full desired state of a user's pool. Settings replace the
+// stored settings; keys left out return to the template defaults.
+type PoolSpec struct {
+	Username   string                 `json:"username"`
+	PHPVersion string                 `json:"php_version"`
+	Provider   string                 `json:"provider"`
+	Target     string                 `json:"target"`
+	Settings   map[string]interface{} `json:"settings"`
+}
+
+// PoolResource is a pool as seen by declarative clients: its stable ID,
+// spec and settings revision
+type PoolResource struct {
+	ID         int64                  `json:"id"`
+	Username   string                 `json:"username"`
+	PHPVersion string                 `json:"php_version"`
+	Provider   string                 `json:"provider"`
+	Target     string                 `json:"target"`
+	Status     string                 `json:"status"`
+	SocketPath string                 `json:"socket_path"`
+	Settings   map[string]interface{} `json:"settings"`
+	Revision   int64                  `json:"revision"`
+	// Result is created, updated or no-change for ApplyPoolSpec
+	Result string `json:"result,omitempty"`
+}
+
+// ApplyPoolSpec makes the user's pool match spec: it creates the pool if
+// there is none, replaces its settings if they differ and leaves it alone
+// otherwise, so applying the same spec twice changes nothing. PHP version,
+// provider and target are fixed once the pool exists; a spec changing them
+// fails with ErrSpecConflict.
+func (pm *PoolManager) ApplyPoolSpec(spec PoolSpec) (*PoolResource, error) {
+	return pm.ApplyPoolSpecIfMatch(spec, AnyRevision)
+}
+
+// ApplyPoolSpecIfMatch applies spec like ApplyPoolSpec only if the pool
+// exists with settings at revision, or revision is AnyRevision, and fails
+// with ErrRevisionMismatch otherwise
+func (pm *PoolManager) ApplyPoolSpecIfMatch(spec PoolSpec, revision int64) (*PoolResource, error) {
+	if spec.Provider == "" {
+		spec.Provider = "remi"
+	}
+	t, err := target.Parse(spec.Target)
+	if err != nil {
+		return nil, err
+	}
+	// Settings are validated before anything is created
+	desired := mergeSettings(spec.Settings, nil)
+	if err := normalizeSettings(desired); err != nil {
+		return nil, err
+	}
+
+	existing, err := pm.db.GetPool(spec.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if existing == nil {
+		if revision != AnyRevision {
+			return nil, fmt.Errorf("%w: %s has no pool yet", ErrRevisionMismatch, spec.Username)
+		}
+		if err := pm.WithTarget(t).CreatePool(spec.Username, spec.PHPVersion, spec.Provider); err != nil {
+			return nil, err
+		}
+		if len(desired) > 0 {
+			if err := pm.initPoolSettings(spec.Username, desired); err != nil {
+				return nil, fmt.Errorf("pool created but applying its settings failed: %w", err)
+			}
+		}
+		return pm.poolResource(spec.Username, SpecCreated)
+	}
+
+	var changed []string
+	if existing.PHPVersion != spec.PHPVersion {
+		changed = append(changed, fmt.Sprintf("php_version (%s)", existing.PHPVersion))
+	}
+	if existing.Provider != spec.Provider {
+		changed = append(changed, fmt.Sprintf("provider (%s)", existing.Provider))
+	}
+	if existing.Target != t.String() && !(existing.Target == "" && t.IsHost()) {
+		changed = append(changed, fmt.Sprintf("target (%s)", existing.Target))
+	}
+	if len(changed) > 0 {
+		return nil, fmt.Errorf("%w: %s cannot change in place; delete the pool and apply the spec again", ErrSpecConflict, strings.Join(changed, ", "))
+	}
+
+	current, err := pm.GetPoolConfig(spec.Username)
+	if err != nil {
+		return nil, err
+	}
+	if revision != AnyRevision && current.Revision != revision {
+		return nil, fmt.Errorf("%w: %s is at revision %d", ErrRevisionMismatch, spec.Username, current.Revision)
+	}
+	if sameSettings(current.Settings, desired) {
+		return pm.poolResource(spec.Username, SpecNoChange)
+	}
+	if _, err := pm.UpdatePoolConfigIfMatch(spec.Username, desired, current.Revision); err != nil {
+		return nil, err
+	}
+	return pm.poolResource(spec.Username, SpecUpdated)
+}
+
+// GetPoolResource returns a user's pool with its ID, settings and revision
+func (pm *PoolManager) GetPoolResource(username string) (*PoolResource, error) {
+	return pm.poolResource(username, "")
+}
+
+func (pm *PoolManager) poolResource(username, result string) (*PoolResource, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	cfg, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return nil, err
+	}
+	return &PoolResource{
+		ID:         dbPool.ID,
+		Username:   dbPool.Username,
+		PHPVersion: dbPool.PHPVersion,
+		Provider:   dbPool.Provider,
+		Target:     dbPool.Target,
+		Status:     dbPool.Status,
+		SocketPath: dbPool.SocketPath,
+		Settings:   cfg.Settings,
+		Revision:   cfg.Revision,
+		Result:     result,
+	}, nil
+}
+
+// sameSettings compares two settings documents by their JSON encoding, so
+// numbers compare equal whatever their Go type
+func sameSettings(a, b map[string]interface{}) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"lightweight-php/apiclient"
+)
+
+// StateSnapshot is the host state that must match between replicas. Paths
+// derived from the provider layout and settings revisions are left out.
+type StateSnapshot struct {
+	PHPVersions []VersionState `json:"php_versions"`
+	Pools       []PoolState    `json:"pools"`
+	Profiles    []Profile      `json:"profiles"`
+}
+
+// VersionState is an installed PHP version
+type VersionState struct {
+	Version  string `json:"version"`
+	Provider string `json:"provider"`
+	Status   string `json:"status"`
+}
+
+// PoolState is a pool with its stored settings
+type PoolState struct {
+	Username   string                 `json:"username"`
+	PHPVersion string                 `json:"php_version"`
+	Provider   string                 `json:"provider"`
+	Status     string                 `json:"status"`
+	Target     string                 `json:"target,omitempty"`
+	Settings   map[string]interface{} `json:"settings"`
+}
+
+// StateDifference is one value that differs between two snapshots. Local or
+// Remote is nil when the entry exists on one side only.
+type StateDifference struct {
+	Path   string      `json:"path"`
+	Local  interface{} `json:"local"`
+	Remote interface{} `json:"remote"`
+}
+
+// Snapshot returns the current state of this host
+func (pm *PoolManager) Snapshot() (*StateSnapshot, error) {
+	versions, err := pm.db.ListPHPVersions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PHP versions: %w", err)
+	}
+	snapshot := &StateSnapshot{
+		PHPVersions: make([]VersionState, 0, len(versions)),
+		Pools:       make([]PoolState, 0),
+	}
+	for _, v := range versions {
+		snapshot.PHPVersions = append(snapshot.PHPVersions, VersionState{
+			Version:  v.Version,
+			Provider: v.PackageManager,
+			Status:   v.Status,
+		})
+	}
+
+	pools, err := pm.ListPools()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pools {
+		cfg, err := pm.GetPoolConfig(p.User)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Pools = append(snapshot.Pools, PoolState{
+			Username:   p.User,
+			PHPVersion: p.PHPVersion,
+			Provider:   p.Provider,
+			Status:     p.Status,
+			Target:     p.Target,
+			Settings:   cfg.Settings,
+		})
+	}
+
+	if snapshot.Profiles, err = pm.ListProfiles(); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// FetchSnapshot reads the state of another instance through its API, at
+// an http(s):// URL or a unix:/// socket
+func FetchSnapshot(apiURL string) (*StateSnapshot, error) {
+	client, err := apiclient.New(apiURL, "")
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &StateSnapshot{}
+	if err := client.WithTimeout(30*time.Second).Do("GET", "/api/v1/state", nil, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to read the state of %s: %w", apiURL, err)
+	}
+	return snapshot, nil
+}
+
+// DiffSnapshots compares two snapshots and returns the differences sorted
+// by path. An entry present on one side only is reported once under its
+// own path ("pools/alice"); other differences name the field, e.g.
+// "pools/alice/settings/memory_limit".
+func DiffSnapshots(local, remote *StateSnapshot) []StateDifference {
+	lEntries, l := flattenSnapshot(local)
+	rEntries, r := flattenSnapshot(remote)
+
+	paths := make([]string, 0, len(l)+len(r))
+	for path := range l {
+		paths = append(paths, path)
+	}
+	for path := range r {
+		if _, ok := l[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	diffs := make([]StateDifference, 0)
+	reported := make(map[string]bool)
+	for _, path := range paths {
+		parts := strings.SplitN(path, "/", 3)
+		entry := parts[0] + "/" + parts[1]
+		lEntry, inLocal := lEntries[entry]
+		rEntry, inRemote := rEntries[entry]
+		if inLocal != inRemote {
+			if !reported[entry] {
+				reported[entry] = true
+				diffs = append(diffs, StateDifference{Path: entry, Local: lEntry, Remote: rEntry})
+			}
+			continue
+		}
+		if !reflect.DeepEqual(l[path], r[path]) {
+			diffs = append(diffs, StateDifference{Path: path, Local: l[path], Remote: r[path]})
+		}
+	}
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
+
+// flattenSnapshot returns the entries of a snapshot by path ("pools/alice")
+// and every leaf value by path. A snapshot is round-tripped through JSON so
+// local and fetched values compare as the same types.
+func flattenSnapshot(s *StateSnapshot) (map[string]interface{}, map[string]interface{}) {
+	entries := make(map[string]interface{})
+	flat := make(map[string]interface{})
+
+	var doc struct {
+		PHPVersions []map[string]interface{} `json:"php_versions"`
+		Pools       []map[string]interface{} `json:"pools"`
+		Profiles    []map[string]interface{} `json:"profiles"`
+	}
+	encoded, _ := json.Marshal(s)
+	json.Unmarshal(encoded, &doc)
+
+	add := func(section, keyField string, list []map[string]interface{}) {
+		for _, entry := range list {
+			prefix := fmt.Sprintf("%s/%v", section, entry[keyField])
+			entries[prefix] = entry
+			flat[prefix+"/"+keyField] = entry[keyField]
+			for field, value := range entry {
+				if field == keyField {
+					continue
+				}
+				if nested, ok := value.(map[string]interface{}); ok {
+					for key, v := range nested {
+						flat[prefix+"/"+field+"/"+key] = v
+					}
+					continue
+				}
+				flat[prefix+"/"+field] = value
+			}
+		}
+	}
+	add("php_versions", "version", doc.PHPVersions)
+	add("pools", "username", doc.Pools)
+	add("profiles", "name", doc.Profiles)
+	return entries, flat
+}
@@ -0,0 +1,246 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/target"
+	"lightweight-php/templates"
+	"lightweight-php/tracing"
+)
+
+// SuspendedScriptPath is the script a suspended pool prepends to every
+// request, inside the pool's target
+const SuspendedScriptPath = "/etc/lightweight-php/suspended.php"
+
+// suspendedSuffix is appended to a pool's configuration file while the
+// placeholder takes its place; FPM only includes *.conf files
+const suspendedSuffix = ".suspended"
+
+// suspendedScript answers every request of a suspended account before any
+// of the account's code runs
+const suspendedScript = `<?php
+http_response_code(503);
+header('Retry-After: 3600');
+header('Content-Type: text/plain; charset=utf-8');
+echo "This account is suspended.\n";
+exit;
+`
+
+// ErrPoolSuspended is returned for changes to a suspended pool
+var ErrPoolSuspended = errors.New("pool is suspended")
+
+// Suspension is why and since when a pool is suspended
+type Suspension struct {
+	Reason      string    `json:"reason,omitempty"`
+	SuspendedAt time.Time `json:"suspended_at"`
+}
+
+// SuspendPool stops serving PHP for a user: the pool's configuration is
+// set aside and replaced by a placeholder pool on the same listen address
+// that answers every request with 503, and the pool is marked suspended.
+// The pool's settings are kept, so UnsuspendPool restores it as it was.
+// Suspending a suspended pool does nothing.
+func (pm *PoolManager) SuspendPool(username, reason string) (err error) {
+	ctx, span := tracing.Start(pm.context(), "pool.suspend")
+	defer span.Finish(&err)
+	defer recordAudit(pm.context(), pm.db, "pool.suspend", username, &err)
+	span.SetAttr("pool.username", username)
+	pm = pm.WithContext(ctx)
+
+	l, err := pm.acquire(lock.PoolKey(username), "suspend pool "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if dbPool.Status == db.PoolSuspended {
+		return nil
+	}
+	if dbPool.Status == db.PoolMigrated {
+		return fmt.Errorf("pool %s was migrated to another host", username)
+	}
+
+	t, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return err
+	}
+	phpProvider, err := factory.CreateProvider(providerTypeFor(dbPool.Provider))
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	config, err := pm.renderSuspendedPool(t, dbPool)
+	if err != nil {
+		return err
+	}
+	scriptPath, err := t.Path(SuspendedScriptPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(scriptPath), 0755); err != nil {
+		return fmt.Errorf("failed to create placeholder directory: %w", err)
+	}
+	if err := writeFileAtomic(scriptPath, []byte(suspendedScript), 0644); err != nil {
+		return fmt.Errorf("failed to write placeholder script: %w", err)
+	}
+
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return err
+	}
+	stashPath := hostConfigPath + suspendedSuffix
+	if err := os.Rename(hostConfigPath, stashPath); err != nil {
+		return fmt.Errorf("failed to set pool config aside: %w", err)
+	}
+	restore := func() error {
+		if err := os.Rename(stashPath, hostConfigPath); err != nil {
+			return err
+		}
+		return reloadFPMIn(pm.context(), t, phpProvider, dbPool.PHPVersion)
+	}
+	if err := writeFileAtomic(hostConfigPath, []byte(config), 0644); err != nil {
+		os.Rename(stashPath, hostConfigPath)
+		return fmt.Errorf("failed to write placeholder pool config: %w", err)
+	}
+	if err := reloadFPMIn(pm.context(), t, phpProvider, dbPool.PHPVersion); err != nil {
+		if rerr := restore(); rerr != nil {
+			return fmt.Errorf("failed to reload PHP-FPM: %v; restoring the pool also failed: %w", err, rerr)
+		}
+		return fmt.Errorf("failed to reload PHP-FPM: %w", err)
+	}
+
+	if err := pm.db.SuspendPool(dbPool.ID, reason, time.Now()); err != nil {
+		if rerr := restore(); rerr != nil {
+			return fmt.Errorf("failed to save suspension: %v; restoring the pool also failed: %w", err, rerr)
+		}
+		return fmt.Errorf("failed to save suspension: %w", err)
+	}
+	return nil
+}
+
+// UnsuspendPool puts a suspended pool's configuration back in place of the
+// placeholder and restores the status it had before. Unsuspending a pool
+// that is not suspended does nothing.
+func (pm *PoolManager) UnsuspendPool(username string) (err error) {
+	ctx, span := tracing.Start(pm.context(), "pool.unsuspend")
+	defer span.Finish(&err)
+	defer recordAudit(pm.context(), pm.db, "pool.unsuspend", username, &err)
+	span.SetAttr("pool.username", username)
+	pm = pm.WithContext(ctx)
+
+	l, err := pm.acquire(lock.PoolKey(username), "unsuspend pool "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if dbPool.Status != db.PoolSuspended {
+		return nil
+	}
+
+	t, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return err
+	}
+	hostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return err
+	}
+	stashPath := hostConfigPath + suspendedSuffix
+
+	if _, err := os.Stat(stashPath); os.IsNotExist(err) {
+		// The original file is gone; render it again from the stored settings
+		if err := pm.db.UnsuspendPool(dbPool.ID); err != nil {
+			return fmt.Errorf("failed to save pool status: %w", err)
+		}
+		current, err := pm.GetPoolConfig(username)
+		if err != nil {
+			return err
+		}
+		if _, err := pm.applyPoolConfig(username, current.Settings, current.Revision); err != nil {
+			return fmt.Errorf("failed to restore pool config: %w", err)
+		}
+		return nil
+	}
+
+	phpProvider, err := factory.CreateProvider(providerTypeFor(dbPool.Provider))
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	placeholder, err := os.ReadFile(hostConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read placeholder pool config: %w", err)
+	}
+	if err := os.Rename(stashPath, hostConfigPath); err != nil {
+		return fmt.Errorf("failed to restore pool config: %w", err)
+	}
+	if err := reloadFPMIn(pm.context(), t, phpProvider, dbPool.PHPVersion); err != nil {
+		// Keep the account suspended rather than leave it without a pool
+		if rerr := os.Rename(hostConfigPath, stashPath); rerr == nil && placeholder != nil {
+			writeFileAtomic(hostConfigPath, placeholder, 0644)
+		}
+		reloadFPMIn(pm.context(), t, phpProvider, dbPool.PHPVersion)
+		return fmt.Errorf("failed to reload PHP-FPM: %w", err)
+	}
+
+	if err := pm.db.UnsuspendPool(dbPool.ID); err != nil {
+		return fmt.Errorf("failed to save pool status: %w", err)
+	}
+	return nil
+}
+
+// renderSuspendedPool renders the placeholder pool of a suspended account
+func (pm *PoolManager) renderSuspendedPool(t target.Target, dbPool *db.Pool) (string, error) {
+	settings, err := pm.poolSettings(dbPool)
+	if err != nil {
+		return "", err
+	}
+	settings = mergeSettings(settings, nil)
+	if err := normalizeSettings(settings); err != nil {
+		return "", fmt.Errorf("failed to apply settings: %w", err)
+	}
+	data, err := poolRenderData(t, dbPool.Username, dbPool.SocketPath, settings)
+	if err != nil {
+		return "", err
+	}
+
+	suspended := &templates.SuspendedPoolConfigData{PoolConfigData: data, PlaceholderScript: SuspendedScriptPath}
+	templateContent, err := templates.LoadTemplate("pool-suspended.conf.tmpl")
+	if err != nil {
+		return "", fmt.Errorf("failed to load template: %w", recordRenderFailure(pm.db, dbPool.Username, nil, err))
+	}
+	config, err := templates.RenderSuspendedPoolConfig(templateContent, suspended)
+	if err != nil {
+		return "", fmt.Errorf("failed to render template: %w", recordRenderFailure(pm.db, dbPool.Username, suspended, err))
+	}
+	return config, nil
+}
+
+// poolSuspension converts a stored suspension for the API
+func poolSuspension(s *db.PoolSuspension) *Suspension {
+	if s == nil {
+		return nil
+	}
+	return &Suspension{Reason: s.Reason, SuspendedAt: s.SuspendedAt}
+}
@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"lightweight-php/db"
+	"lightweight-php/events"
+	"lightweight-php/lock"
+)
+
+// SwitchPHPVersion moves a user's pool to another PHP version of the same
+// provider. The pool keeps its ID, settings and labels: its configuration is
+// rendered for the new version's FPM service and removed from the old one,
+// and sites bound to the pool follow it to the new socket. If the new
+// version cannot take the pool, the pool is left on the old version.
+func (pm *PoolManager) SwitchPHPVersion(username, phpVersion string) (err error) {
+	defer recordAudit(pm.context(), pm.db, "pool.switch_version", username, &err)
+	l, err := pm.acquire(lock.PoolKey(username), "switch PHP version of "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if dbPool.PHPVersion == phpVersion {
+		return nil
+	}
+	if dbPool.Status == db.PoolSuspended {
+		return fmt.Errorf("%w: %s", ErrPoolSuspended, username)
+	}
+
+	t, factory, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return err
+	}
+	phpProvider, err := factory.CreateProvider(providerTypeFor(dbPool.Provider))
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+	installed, err := phpProvider.ListInstalledPHP()
+	if err != nil {
+		return fmt.Errorf("failed to list installed PHP versions: %w", err)
+	}
+	if !containsString(installed, phpVersion) {
+		return fmt.Errorf("PHP %s is not installed for provider %s", phpVersion, dbPool.Provider)
+	}
+
+	oldSocket := phpProvider.GetSocketPath(username, dbPool.PHPVersion)
+	configPath := phpProvider.GetConfigPath(username, phpVersion)
+	// TCP listeners keep their address; sockets move to the new version's run directory
+	listen := dbPool.SocketPath
+	if listen == oldSocket {
+		listen = phpProvider.GetSocketPath(username, phpVersion)
+	}
+
+	hostConfigPath, err := t.Path(configPath)
+	if err != nil {
+		return err
+	}
+	oldHostConfigPath, err := t.Path(dbPool.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(hostConfigPath); err == nil {
+		return fmt.Errorf("a pool configuration for %s already exists at %s", username, configPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(hostConfigPath), 0755); err != nil {
+		return fmt.Errorf("failed to create pool directory: %w", err)
+	}
+	if addr, err := ParseListen(listen); err == nil && addr.IsUnix() {
+		hostSocketPath, err := t.Path(listen)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(hostSocketPath), 0755); err != nil {
+			return fmt.Errorf("failed to create socket directory: %w", err)
+		}
+	}
+	if err := pm.ensurePHPVersion(phpVersion, dbPool.Provider); err != nil {
+		return err
+	}
+
+	current, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return err
+	}
+	if err := pm.db.UpdatePoolVersion(dbPool.ID, phpVersion, listen, configPath); err != nil {
+		return fmt.Errorf("failed to save pool version: %w", err)
+	}
+	// Rendering through applyPoolConfig writes the new file and reloads the
+	// new version's service with the usual validation
+	if _, err := pm.applyPoolConfig(username, current.Settings, current.Revision); err != nil {
+		os.Remove(hostConfigPath)
+		if rerr := pm.db.UpdatePoolVersion(dbPool.ID, dbPool.PHPVersion, dbPool.SocketPath, dbPool.ConfigPath); rerr != nil {
+			return fmt.Errorf("failed to switch to PHP %s: %v; restoring the pool record also failed: %w", phpVersion, err, rerr)
+		}
+		return fmt.Errorf("failed to switch to PHP %s: %w", phpVersion, err)
+	}
+
+	if err := os.Remove(oldHostConfigPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old pool file: %w", err)
+	}
+	if err := pm.reloadFPMService(t, phpProvider, dbPool.PHPVersion); err != nil {
+		return fmt.Errorf("failed to reload PHP-FPM %s: %w", dbPool.PHPVersion, err)
+	}
+	// The old version's APCu segment shrinks without this pool
+	if err := pm.syncAPCu(phpProvider, dbPool); err != nil {
+		fmt.Printf("Warning: failed to update APCu config: %v\n", err)
+	}
+	if listen != dbPool.SocketPath {
+		if err := NewSiteManagerWithDeps(pm.db).refreshPoolSites(dbPool.ID); err != nil {
+			return err
+		}
+	}
+
+	events.Publish(events.PoolUpdated, username, map[string]interface{}{"php_version": phpVersion, "previous_php_version": dbPool.PHPVersion})
+	return nil
+}
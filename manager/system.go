@@ -0,0 +1,141 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/servicemgr"
+	"lightweight-php/system"
+	"lightweight-php/target"
+)
+
+// SystemInfo describes the OS of an execution target
+type SystemInfo struct {
+	Target string          `json:"target,omitempty"`
+	OS     *system.OSInfo  `json:"os"`
+	Family system.OSFamily `json:"family"`
+	Arch   system.Arch     `json:"arch"`
+}
+
+// SystemInfo reads the os-release and architecture of the manager's
+// target. The family is the one providers install for, which falls back
+// to the release files when os-release names no known family.
+func (pm *PackageManager) SystemInfo() (*SystemInfo, error) {
+	return systemInfo(pm.providerFactory.Target())
+}
+
+func systemInfo(t target.Target) (*SystemInfo, error) {
+	root, err := t.Root()
+	if err != nil {
+		return nil, err
+	}
+	detector := system.NewOSDetectorAt(root)
+	info, err := detector.Info()
+	if err != nil {
+		return nil, err
+	}
+	family, err := detector.Detect()
+	if err != nil {
+		return nil, err
+	}
+	arch, err := detector.DetectArch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect the architecture: %w", err)
+	}
+	return &SystemInfo{Target: t.String(), OS: info, Family: family, Arch: arch}, nil
+}
+
+// SystemStatus is the host's OS, capacity and what runs on it, for server
+// dashboards and as input to pool tuning. Sizes are in bytes; memory and
+// disks are left out where they cannot be read.
+type SystemStatus struct {
+	SystemInfo
+	Hostname       string       `json:"hostname,omitempty"`
+	Kernel         string       `json:"kernel,omitempty"`
+	CPUs           int          `json:"cpus"`
+	MemTotal       int64        `json:"mem_total,omitempty"`
+	MemAvailable   int64        `json:"mem_available,omitempty"`
+	Disks          []DiskStatus `json:"disks"`
+	Systemd        bool         `json:"systemd"`
+	ServiceManager string       `json:"service_manager"`
+	Webservers     []string     `json:"webservers"`
+	PHPVersions    int          `json:"php_versions"`
+	Pools          int          `json:"pools"`
+	ActivePools    int          `json:"active_pools"`
+}
+
+// DiskStatus is the space of the filesystem a path lives on
+type DiskStatus struct {
+	Path       string `json:"path"`
+	Total      uint64 `json:"total"`
+	Free       uint64 `json:"free"`
+	FreeInodes uint64 `json:"free_inodes"`
+}
+
+// webserverBinaries are looked for to tell which webservers are installed
+var webserverBinaries = []struct{ name, binary string }{
+	{"nginx", "nginx"},
+	{"apache", "httpd"},
+	{"apache", "apache2"},
+	{"openlitespeed", "/usr/local/lsws/bin/lswsctrl"},
+	{"caddy", "caddy"},
+}
+
+// SystemStatus reports the host's OS, CPUs, memory, the free space of the
+// filesystems doctor checks, its init system and webservers, and how many
+// PHP versions and pools are managed
+func (pm *PoolManager) SystemStatus() (*SystemStatus, error) {
+	info, err := systemInfo(target.Host)
+	if err != nil {
+		return nil, err
+	}
+	s := &SystemStatus{SystemInfo: *info, CPUs: runtime.NumCPU(), Disks: []DiskStatus{}, Webservers: []string{}}
+	s.Hostname, _ = os.Hostname()
+	if kernel, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		s.Kernel = strings.TrimSpace(string(kernel))
+	}
+	if total, available, err := readMemInfo(); err == nil {
+		s.MemTotal, s.MemAvailable = total, available
+	}
+
+	seen := make(map[uint64]bool)
+	for _, path := range diskCheckPaths {
+		usage, err := diskUsage(hostPath(path))
+		if err != nil || seen[usage.device] {
+			// Paths that do not exist live on a filesystem already listed
+			continue
+		}
+		seen[usage.device] = true
+		s.Disks = append(s.Disks, DiskStatus{Path: path, Total: usage.total, Free: usage.free, FreeInodes: usage.freeFiles})
+	}
+
+	s.ServiceManager = config.Get().Server.ServiceManager
+	if s.ServiceManager == "" {
+		s.ServiceManager = servicemgr.Detect(target.Host)
+	}
+	s.Systemd = s.ServiceManager == servicemgr.KindSystemd
+	for _, w := range webserverBinaries {
+		if target.Host.LookPath(w.binary) == nil && (len(s.Webservers) == 0 || s.Webservers[len(s.Webservers)-1] != w.name) {
+			s.Webservers = append(s.Webservers, w.name)
+		}
+	}
+
+	versions, err := pm.db.ListPHPVersions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PHP versions from database: %w", err)
+	}
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	s.PHPVersions, s.Pools = len(versions), len(pools)
+	for _, p := range pools {
+		if p.Status == "active" {
+			s.ActivePools++
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,228 @@
+package manager
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"lightweight-php/db"
+	"lightweight-php/validation"
+)
+
+// Tenant is a logical environment such as "shared-1" or "reseller-x" that
+// pools and PHP versions can belong to, with the settings its new pools
+// start from
+type Tenant struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Settings    map[string]interface{} `json:"settings"`
+	Pools       int                    `json:"pools"`
+	PHPVersions int                    `json:"php_versions"`
+}
+
+var (
+	// ErrTenantNotFound is returned for an unknown tenant name
+	ErrTenantNotFound = errors.New("tenant not found")
+	// ErrTenantExists is returned when creating a tenant whose name is taken
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrTenantInUse is returned when deleting a tenant that still has pools
+	// or PHP versions
+	ErrTenantInUse = errors.New("tenant is in use")
+	// ErrVersionReserved is returned when a pool would use a PHP version
+	// reserved for another tenant
+	ErrVersionReserved = errors.New("PHP version is reserved for another tenant")
+)
+
+// WithTenant returns a copy of the manager that creates pools in a tenant.
+// Pools created through CreatePoolWithProfile start from the tenant's
+// settings.
+func (pm *PoolManager) WithTenant(tenant string) *PoolManager {
+	c := *pm
+	c.tenant = tenant
+	return &c
+}
+
+// ListTenants returns all tenants ordered by name
+func (pm *PoolManager) ListTenants() ([]Tenant, error) {
+	dbTenants, err := pm.db.ListTenants()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants from database: %w", err)
+	}
+
+	tenants := make([]Tenant, 0, len(dbTenants))
+	for i := range dbTenants {
+		t, err := pm.tenantFromDB(&dbTenants[i])
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, nil
+}
+
+// GetTenant returns a tenant by name
+func (pm *PoolManager) GetTenant(name string) (*Tenant, error) {
+	dbTenant, err := pm.db.GetTenant(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant from database: %w", err)
+	}
+	if dbTenant == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, name)
+	}
+	return pm.tenantFromDB(dbTenant)
+}
+
+// CreateTenant stores a new tenant after checking its settings render
+func (pm *PoolManager) CreateTenant(t *Tenant) error {
+	if err := validation.Field("name", t.Name, validation.TenantName); err != nil {
+		return err
+	}
+	encoded, err := encodeTenantSettings(t.Settings)
+	if err != nil {
+		return err
+	}
+
+	existing, err := pm.db.GetTenant(t.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check tenant: %w", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("%w: %s", ErrTenantExists, t.Name)
+	}
+
+	if err := pm.db.CreateTenant(t.Name, t.Description, encoded); err != nil {
+		return fmt.Errorf("failed to save tenant to database: %w", err)
+	}
+	return nil
+}
+
+// UpdateTenant replaces the description and default settings of a tenant.
+// Existing pools of the tenant keep their settings.
+func (pm *PoolManager) UpdateTenant(t *Tenant) error {
+	encoded, err := encodeTenantSettings(t.Settings)
+	if err != nil {
+		return err
+	}
+
+	err = pm.db.UpdateTenant(t.Name, t.Description, encoded)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", ErrTenantNotFound, t.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update tenant: %w", err)
+	}
+	return nil
+}
+
+// DeleteTenant removes a tenant that no pool or PHP version belongs to
+func (pm *PoolManager) DeleteTenant(name string) error {
+	t, err := pm.GetTenant(name)
+	if err != nil {
+		return err
+	}
+	if t.Pools > 0 || t.PHPVersions > 0 {
+		return fmt.Errorf("%w: %s has %d pools and %d PHP versions; move them first", ErrTenantInUse, name, t.Pools, t.PHPVersions)
+	}
+	if err := pm.db.DeleteTenant(name); err != nil {
+		return fmt.Errorf("failed to delete tenant: %w", err)
+	}
+	return nil
+}
+
+// SetPoolTenant moves a user's pool to a tenant, or out of any tenant with
+// "". The pool configuration is not touched.
+func (pm *PoolManager) SetPoolTenant(username, tenant string) error {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	if err := pm.checkTenant(tenant, dbPool.PHPVersion); err != nil {
+		return err
+	}
+	if err := pm.db.SetPoolTenant(dbPool.ID, tenant); err != nil {
+		return fmt.Errorf("failed to update pool tenant: %w", err)
+	}
+	return nil
+}
+
+// SetPHPVersionTenant reserves an installed PHP version for a tenant, or
+// shares it between all tenants with "". Pools of other tenants that use
+// the version keep running but no new ones can be created.
+func (pm *PoolManager) SetPHPVersionTenant(version, tenant string) error {
+	if tenant != "" {
+		if _, err := pm.GetTenant(tenant); err != nil {
+			return err
+		}
+	}
+	err := pm.db.SetPHPVersionTenant(version, tenant)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("PHP %s is not installed", version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update PHP version tenant: %w", err)
+	}
+	return nil
+}
+
+// checkTenant returns an error unless a pool on phpVersion may belong to
+// tenant: the tenant must exist and the version must be shared or its own
+func (pm *PoolManager) checkTenant(tenant, phpVersion string) error {
+	if tenant != "" {
+		if _, err := pm.GetTenant(tenant); err != nil {
+			return err
+		}
+	}
+	version, err := pm.db.GetPHPVersion(phpVersion)
+	if err != nil {
+		return fmt.Errorf("failed to get PHP version from database: %w", err)
+	}
+	if version != nil && version.Tenant != "" && version.Tenant != tenant {
+		return fmt.Errorf("%w: PHP %s belongs to %s", ErrVersionReserved, phpVersion, version.Tenant)
+	}
+	return nil
+}
+
+// tenantDefaults returns the settings new pools of the manager's tenant
+// start from
+func (pm *PoolManager) tenantDefaults() (map[string]interface{}, error) {
+	if pm.tenant == "" {
+		return nil, nil
+	}
+	t, err := pm.GetTenant(pm.tenant)
+	if err != nil {
+		return nil, err
+	}
+	return t.Settings, nil
+}
+
+func (pm *PoolManager) tenantFromDB(t *db.Tenant) (*Tenant, error) {
+	settings := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(t.Settings), &settings); err != nil {
+		return nil, fmt.Errorf("failed to decode settings of tenant %s: %w", t.Name, err)
+	}
+	pools, versions, err := pm.db.CountTenantMembers(t.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count members of tenant %s: %w", t.Name, err)
+	}
+	return &Tenant{
+		Name:        t.Name,
+		Description: t.Description,
+		Settings:    settings,
+		Pools:       pools,
+		PHPVersions: versions,
+	}, nil
+}
+
+// encodeTenantSettings checks that default settings apply to the pool
+// template and returns them as JSON
+func encodeTenantSettings(settings map[string]interface{}) (string, error) {
+	encoded, err := encodeProfileSettings(settings)
+	if err != nil {
+		return "", fmt.Errorf("invalid tenant settings: %w", errors.Unwrap(err))
+	}
+	return encoded, nil
+}
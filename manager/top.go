@@ -0,0 +1,233 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lightweight-php/db"
+)
+
+// clockTicks is USER_HZ, the unit of the CPU times in /proc/PID/stat. It
+// is 100 on every Linux architecture the tool supports.
+const clockTicks = 100
+
+// TopSorts are the orders a TopReport can be sorted by
+var TopSorts = []string{"cpu", "memory", "workers", "queue"}
+
+// PoolTop is the resource usage of one pool's PHP-FPM workers
+type PoolTop struct {
+	Username   string `json:"username"`
+	PHPVersion string `json:"php_version"`
+	Status     string `json:"status"`
+	// Workers are the worker processes found in /proc
+	Workers int `json:"workers"`
+	// CPUPercent is the CPU time of the workers since the previous sample,
+	// where 100 is one core
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        int64   `json:"rss_bytes"`
+	// Active, Idle, ListenQueue and AcceptedConn come from the status page
+	// and are omitted when it cannot be read
+	Active       *int64 `json:"active,omitempty"`
+	Idle         *int64 `json:"idle,omitempty"`
+	ListenQueue  *int64 `json:"listen_queue,omitempty"`
+	AcceptedConn *int64 `json:"accepted_conn,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// TopReport is the resource usage of every pool, heaviest first
+type TopReport struct {
+	SampledAt time.Time `json:"sampled_at"`
+	// Interval is the time CPU usage was measured over; 0 for a first
+	// sample, which has no CPU figures
+	Interval   float64   `json:"interval_seconds"`
+	Sort       string    `json:"sort"`
+	Workers    int       `json:"workers"`
+	CPUPercent float64   `json:"cpu_percent"`
+	RSS        int64     `json:"rss_bytes"`
+	Pools      []PoolTop `json:"pools"`
+}
+
+// TopSampler samples pool resource usage repeatedly; CPU usage is the
+// difference to the previous sample
+type TopSampler struct {
+	pm       *PoolManager
+	ticks    map[int]uint64
+	sampleAt time.Time
+}
+
+// NewTopSampler returns a sampler whose first Sample has no CPU figures
+func (pm *PoolManager) NewTopSampler() *TopSampler {
+	return &TopSampler{pm: pm}
+}
+
+// Top samples the pools twice, interval apart, so CPU usage is included
+func (pm *PoolManager) Top(interval time.Duration, sortBy string) (*TopReport, error) {
+	s := pm.NewTopSampler()
+	if _, err := s.Sample(sortBy); err != nil {
+		return nil, err
+	}
+	time.Sleep(interval)
+	return s.Sample(sortBy)
+}
+
+// Sample reads the workers of every pool from /proc and the counters of
+// its status page, sorted by sortBy (one of TopSorts, default "cpu")
+func (s *TopSampler) Sample(sortBy string) (*TopReport, error) {
+	if sortBy == "" {
+		sortBy = "cpu"
+	}
+	if !containsString(TopSorts, sortBy) {
+		return nil, fmt.Errorf("invalid sort %q (one of: %s)", sortBy, strings.Join(TopSorts, ", "))
+	}
+	pools, err := s.pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	workers, err := fpmWorkers()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	elapsed := 0.0
+	if !s.sampleAt.IsZero() {
+		elapsed = now.Sub(s.sampleAt).Seconds()
+	}
+	ticks := make(map[int]uint64)
+	report := &TopReport{SampledAt: now.UTC(), Interval: elapsed, Sort: sortBy, Pools: make([]PoolTop, len(pools))}
+
+	var wg sync.WaitGroup
+	for i := range pools {
+		p := &report.Pools[i]
+		p.Username = pools[i].Username
+		p.PHPVersion = pools[i].PHPVersion
+		p.Status = pools[i].Status
+		for _, pid := range workers[p.Username] {
+			dir := filepath.Join("/proc", strconv.Itoa(pid))
+			cpu, err := processCPUTicks(dir)
+			if err != nil {
+				// Exited since the scan
+				continue
+			}
+			p.Workers++
+			ticks[pid] = cpu
+			if previous, ok := s.ticks[pid]; ok && elapsed > 0 && cpu >= previous {
+				p.CPUPercent += float64(cpu-previous) / clockTicks / elapsed * 100
+			}
+			if rss, err := procField(filepath.Join(dir, "status"), "VmRSS:"); err == nil {
+				p.RSS += rss
+			}
+		}
+		if pools[i].Status == db.PoolSuspended {
+			continue
+		}
+		// Status pages are read in parallel so an unresponsive pool does
+		// not hold up the others
+		wg.Add(1)
+		go func(p *PoolTop, dbPool *db.Pool) {
+			defer wg.Done()
+			status, err := s.pm.fpmStatus(dbPool)
+			if err != nil {
+				p.Error = err.Error()
+				return
+			}
+			p.Active, p.Idle = &status.ActiveProcesses, &status.IdleProcesses
+			p.ListenQueue, p.AcceptedConn = &status.ListenQueue, &status.AcceptedConn
+		}(p, &pools[i])
+	}
+	wg.Wait()
+	s.ticks, s.sampleAt = ticks, now
+
+	for _, p := range report.Pools {
+		report.Workers += p.Workers
+		report.CPUPercent += p.CPUPercent
+		report.RSS += p.RSS
+	}
+	sort.SliceStable(report.Pools, func(i, j int) bool {
+		a, b := report.Pools[i], report.Pools[j]
+		switch sortBy {
+		case "memory":
+			return a.RSS > b.RSS
+		case "workers":
+			return a.Workers > b.Workers
+		case "queue":
+			return int64Value(a.ListenQueue) > int64Value(b.ListenQueue)
+		}
+		if a.CPUPercent != b.CPUPercent {
+			return a.CPUPercent > b.CPUPercent
+		}
+		return a.RSS > b.RSS
+	})
+	return report, nil
+}
+
+// fpmWorkers returns the PIDs of the PHP-FPM workers of every pool, by the
+// user of their "php-fpm: pool NAME" process title. Workers in containers
+// are seen too, as the host shares their PID namespace.
+func fpmWorkers() (map[string][]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc: %w", err)
+	}
+	prefix := []byte("php-fpm: pool ")
+	workers := make(map[string][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		cmdline = bytes.TrimRight(bytes.ReplaceAll(cmdline, []byte{0}, []byte(" ")), " ")
+		if !bytes.HasPrefix(cmdline, prefix) {
+			continue
+		}
+		username := string(cmdline[len(prefix):])
+		workers[username] = append(workers[username], pid)
+	}
+	return workers, nil
+}
+
+// processCPUTicks returns the user and system CPU time of a process
+func processCPUTicks(procDir string) (uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name may contain spaces and parentheses; the fields
+	// after it start at the state, field 3
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("invalid %s/stat", procDir)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("invalid %s/stat", procDir)
+	}
+	// utime and stime are fields 14 and 15
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return utime + stime, nil
+}
+
+func int64Value(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
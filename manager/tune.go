@@ -0,0 +1,259 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// tuneReserveRatio of MemTotal is left for the OS, nginx and databases
+	tuneReserveRatio = 0.10
+	// tuneMinReserve is the smallest reserve on small machines
+	tuneMinReserve = 256 << 20
+	// tuneMaxChildren caps recommendations on very large machines
+	tuneMaxChildren = 512
+	// autoTuneThreshold is the relative change of max_children below which
+	// AutoTunePools leaves a pool alone, so it does not reload on noise
+	autoTuneThreshold = 0.10
+)
+
+// ErrNoWorkers is returned when a pool has no running workers to measure
+var ErrNoWorkers = errors.New("no running workers")
+
+// TuneRecommendation is the process manager sizing computed for a pool from
+// its workers' memory and the host's memory
+type TuneRecommendation struct {
+	Username        string                 `json:"username"`
+	Workers         int                    `json:"workers"`
+	AvgWorkerMemory int64                  `json:"avg_worker_memory"`
+	MemTotal        int64                  `json:"mem_total"`
+	MemAvailable    int64                  `json:"mem_available"`
+	Budget          int64                  `json:"budget"`
+	Settings        map[string]interface{} `json:"settings"`
+	Previous        map[string]interface{} `json:"previous"`
+	Applied         bool                   `json:"applied"`
+	Revision        int64                  `json:"revision,omitempty"`
+}
+
+// TunePool measures the average memory (PSS) of the pool's running workers
+// and sizes pm.max_children so the pool fits in the memory available now
+// plus what it already uses, minus a reserve for the rest of the host. The
+// spare server settings are derived from max_children. With apply set the
+// settings are written like PatchPoolConfig.
+func (pm *PoolManager) TunePool(username string, apply bool) (*TuneRecommendation, error) {
+	current, err := pm.GetPoolConfig(username)
+	if err != nil {
+		return nil, err
+	}
+
+	samples, err := poolWorkerMemory(username)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%w for pool %s; tune it after it has served traffic", ErrNoWorkers, username)
+	}
+	var used int64
+	for _, s := range samples {
+		used += s
+	}
+	avg := used / int64(len(samples))
+
+	memTotal, memAvailable, err := readMemInfo()
+	if err != nil {
+		return nil, err
+	}
+	reserve := int64(float64(memTotal) * tuneReserveRatio)
+	if reserve < tuneMinReserve {
+		reserve = tuneMinReserve
+	}
+	budget := memAvailable + used - reserve
+
+	rec := &TuneRecommendation{
+		Username:        username,
+		Workers:         len(samples),
+		AvgWorkerMemory: avg,
+		MemTotal:        memTotal,
+		MemAvailable:    memAvailable,
+		Budget:          budget,
+		Settings:        pmSettingsFor(budget / avg),
+		Previous:        make(map[string]interface{}),
+	}
+	for key := range rec.Settings {
+		if value, ok := current.Settings[key]; ok {
+			rec.Previous[key] = value
+		}
+	}
+
+	if apply {
+		revision, err := pm.PatchPoolConfigIfMatch(username, rec.Settings, current.Revision)
+		if err != nil {
+			return rec, err
+		}
+		rec.Applied = true
+		rec.Revision = revision
+	}
+	return rec, nil
+}
+
+// AutoTunePools re-tunes every pool with auto_tune enabled whose
+// recommended max_children moved by more than autoTuneThreshold. Pools
+// without running workers are skipped.
+func (pm *PoolManager) AutoTunePools() ([]TuneRecommendation, error) {
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+
+	var tuned []TuneRecommendation
+	var errs []string
+	for _, p := range pools {
+		cfg, err := pm.GetPoolConfig(p.Username)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if flag, ok := settingFlag(cfg.Settings["auto_tune"]); !ok || flag != "1" {
+			continue
+		}
+
+		rec, err := pm.TunePool(p.Username, false)
+		if err != nil {
+			continue
+		}
+		if !significantChange(cfg.Settings["max_children"], rec.Settings["max_children"]) {
+			continue
+		}
+		if rec, err = pm.TunePool(p.Username, true); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Username, err))
+			continue
+		}
+		tuned = append(tuned, *rec)
+	}
+	if len(errs) > 0 {
+		return tuned, fmt.Errorf("auto-tune failed: %s", strings.Join(errs, "; "))
+	}
+	return tuned, nil
+}
+
+// pmSettingsFor derives the process manager settings from max_children,
+// keeping min_spare <= start <= max_spare <= max_children as PHP-FPM requires
+func pmSettingsFor(maxChildren int64) map[string]interface{} {
+	if maxChildren < 1 {
+		maxChildren = 1
+	}
+	if maxChildren > tuneMaxChildren {
+		maxChildren = tuneMaxChildren
+	}
+	minSpare := maxChildren / 8
+	if minSpare < 1 {
+		minSpare = 1
+	}
+	maxSpare := maxChildren / 4
+	if maxSpare < minSpare {
+		maxSpare = minSpare
+	}
+	start := minSpare + (maxSpare-minSpare)/2
+
+	// Settings are stored as decoded JSON, so numbers are float64
+	return map[string]interface{}{
+		"max_children":      float64(maxChildren),
+		"start_servers":     float64(start),
+		"min_spare_servers": float64(minSpare),
+		"max_spare_servers": float64(maxSpare),
+	}
+}
+
+func significantChange(current, recommended interface{}) bool {
+	old, ok := current.(float64)
+	next, _ := recommended.(float64)
+	if !ok || old <= 0 {
+		return true
+	}
+	change := (next - old) / old
+	return change > autoTuneThreshold || change < -autoTuneThreshold
+}
+
+// poolWorkerMemory returns the proportional set size of every PHP-FPM
+// worker of a pool, found by its "php-fpm: pool NAME" process title
+func poolWorkerMemory(username string) ([]int64, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc: %w", err)
+	}
+
+	title := []byte("php-fpm: pool " + username)
+	var samples []int64
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", e.Name())
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err != nil {
+			continue
+		}
+		cmdline = bytes.TrimRight(bytes.ReplaceAll(cmdline, []byte{0}, []byte(" ")), " ")
+		if !bytes.Equal(cmdline, title) {
+			continue
+		}
+		if size, err := processMemory(dir); err == nil && size > 0 {
+			samples = append(samples, size)
+		}
+	}
+	return samples, nil
+}
+
+// processMemory returns a process's PSS, which splits shared pages such as
+// OPcache between workers, falling back to RSS on kernels without
+// smaps_rollup
+func processMemory(procDir string) (int64, error) {
+	if size, err := procField(filepath.Join(procDir, "smaps_rollup"), "Pss:"); err == nil {
+		return size, nil
+	}
+	return procField(filepath.Join(procDir, "status"), "VmRSS:")
+}
+
+// readMemInfo returns MemTotal and MemAvailable in bytes
+func readMemInfo() (int64, int64, error) {
+	total, err := procField("/proc/meminfo", "MemTotal:")
+	if err != nil {
+		return 0, 0, err
+	}
+	available, err := procField("/proc/meminfo", "MemAvailable:")
+	if err != nil {
+		return 0, 0, err
+	}
+	return total, available, nil
+}
+
+// procField reads a "Name: <n> kB" line from a /proc file in bytes
+func procField(path, name string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == name {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s in %s", name, path)
+			}
+			return kb << 10, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s not found in %s", name, path)
+}
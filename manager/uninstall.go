@@ -0,0 +1,125 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/validation"
+)
+
+// ErrVersionInUse is returned when a PHP version cannot be uninstalled
+// because pools still run on it
+var ErrVersionInUse = errors.New("PHP version is in use")
+
+// VersionInUseError lists the pools that block uninstalling a PHP version
+type VersionInUseError struct {
+	Version  string
+	Provider string
+	Pools    []string
+}
+
+func (e *VersionInUseError) Error() string {
+	return fmt.Sprintf("PHP %s (%s) is used by %d pool(s): %s; delete them or move them to another version first (--migrate-to)",
+		e.Version, e.Provider, len(e.Pools), strings.Join(e.Pools, ", "))
+}
+
+func (e *VersionInUseError) Unwrap() error {
+	return ErrVersionInUse
+}
+
+// VersionDependents returns the users whose pools run on a PHP version of a
+// provider in the manager's target
+func (pm *PackageManager) VersionDependents(version string, providerType provider.ProviderType) ([]string, error) {
+	return versionDependents(pm.db, version, providerType, pm.providerFactory.Target().String())
+}
+
+// UninstallPHP removes a PHP version of a provider. It refuses with a
+// *VersionInUseError while pools still run on the version. The version's
+// record is dropped once no pool of any provider references it.
+func (pm *PackageManager) UninstallPHP(version string, providerType provider.ProviderType) (err error) {
+	defer recordAudit(pm.context(), pm.db, "php.uninstall", version, &err)
+	if err := validation.Field("version", version, validation.PHPVersion); err != nil {
+		return err
+	}
+	if _, err := pm.providerFactory.CreateProvider(providerType); err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+
+	l, err := pm.locks.Acquire(lock.KeyPackageManager, fmt.Sprintf("uninstall php %s (%s)", version, providerType), !pm.noWait, installLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	dependents, err := pm.VersionDependents(version, providerType)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return &VersionInUseError{Version: version, Provider: string(providerType), Pools: dependents}
+	}
+
+	if err := recordInstall(pm.db, pm.providerFactory, providerType, version, "uninstall", pm.progress, func(p provider.PHPProvider) error {
+		return p.UninstallPHP(version)
+	}); err != nil {
+		return err
+	}
+
+	pools, err := pm.db.ListPools()
+	if err != nil {
+		return fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	for _, p := range pools {
+		if p.PHPVersion == version {
+			// Still installed for another provider or target
+			return nil
+		}
+	}
+	if err := pm.db.DeletePHPVersion(version); err != nil {
+		return fmt.Errorf("failed to remove PHP version record: %w", err)
+	}
+	provider.InvalidateInstalled()
+	return nil
+}
+
+// MigrateVersionPools switches every pool of the manager's target that runs
+// on a PHP version of a provider to another version, and returns the users
+// it moved. It stops at the first pool that cannot be switched; that pool
+// stays on the old version.
+func (pm *PoolManager) MigrateVersionPools(version string, providerType provider.ProviderType, to string) ([]string, error) {
+	if to == version {
+		return nil, fmt.Errorf("cannot migrate pools of PHP %s to the same version", version)
+	}
+	dependents, err := versionDependents(pm.db, version, providerType, pm.target.String())
+	if err != nil {
+		return nil, err
+	}
+	migrated := make([]string, 0, len(dependents))
+	for _, username := range dependents {
+		if err := pm.SwitchPHPVersion(username, to); err != nil {
+			return migrated, fmt.Errorf("failed to move pool %s to PHP %s: %w", username, to, err)
+		}
+		migrated = append(migrated, username)
+	}
+	return migrated, nil
+}
+
+func versionDependents(database *db.Database, version string, providerType provider.ProviderType, targetName string) ([]string, error) {
+	pools, err := database.ListPools()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	var users []string
+	for _, p := range pools {
+		if p.PHPVersion == version && providerTypeFor(p.Provider) == providerType && p.Target == targetName {
+			users = append(users, p.Username)
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
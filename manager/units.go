@@ -0,0 +1,198 @@
+package manager
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Settings holding a byte size or a duration. They accept human values such
+// as "1.5G", "1,5 GB", "90s" or "1m30s" and are stored in canonical form: a
+// size as the ini shorthand with the largest exact unit ("1536M") and a
+// duration as whole seconds.
+var (
+	SizeSettings     = []string{"memory_limit", "upload_max_filesize", "post_max_size", "disk_quota"}
+	DurationSettings = []string{"max_execution_time", "process_idle_timeout"}
+)
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+var durationUnits = map[string]float64{
+	"s": 1, "sec": 1, "secs": 1, "second": 1, "seconds": 1,
+	"m": 60, "min": 60, "mins": 60, "minute": 60, "minutes": 60,
+	"h": 3600, "hr": 3600, "hrs": 3600, "hour": 3600, "hours": 3600,
+	"d": 86400, "day": 86400, "days": 86400,
+}
+
+// ParseSize parses a byte size. Units are binary like PHP's ini shorthand
+// (K, M, G, also KB/KiB, and T); a bare number is bytes. The decimal
+// separator may be a point or a comma, so "1.5G" and "1,5G" are the same.
+func ParseSize(s string) (int64, error) {
+	number, unit := splitUnit(strings.TrimSpace(s))
+	if number == "" {
+		return 0, fmt.Errorf("invalid size %q; expected a value such as 256M or 1.5G", s)
+	}
+	multiplier, ok := sizeUnits[strings.ToLower(unit)]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q; use K, M or G", s, unit)
+	}
+	n, err := parseDecimal(number)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q; expected a value such as 256M or 1.5G", s)
+	}
+	bytes := math.Round(n * float64(multiplier))
+	if bytes > math.MaxInt64/2 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// FormatSize formats bytes as PHP ini shorthand with the largest unit that
+// divides it exactly
+func FormatSize(bytes int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}} {
+		if bytes >= u.size && bytes%u.size == 0 {
+			return strconv.FormatInt(bytes/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// ParseDuration parses a duration into whole seconds. It accepts a bare
+// number of seconds and one or more values with a unit (s, m, h, d, or the
+// words), e.g. "90s", "2m", "1m30s", "1,5h".
+func ParseDuration(s string) (int64, error) {
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return 0, fmt.Errorf("invalid duration %q; expected a value such as 90s or 2m", s)
+	}
+	if n, err := parseDecimal(rest); err == nil {
+		if n < 0 || n != math.Trunc(n) {
+			return 0, fmt.Errorf("invalid duration %q; expected whole seconds", s)
+		}
+		return int64(n), nil
+	}
+
+	var total float64
+	for rest != "" {
+		end := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' })
+		if end <= 0 {
+			return 0, fmt.Errorf("invalid duration %q; expected a value such as 90s or 2m", s)
+		}
+		n, err := parseDecimal(rest[:end])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q; expected a value such as 90s or 2m", s)
+		}
+		rest = strings.TrimLeft(rest[end:], " ")
+		unitEnd := strings.IndexFunc(rest, func(r rune) bool { return !unicode.IsLetter(r) })
+		if unitEnd < 0 {
+			unitEnd = len(rest)
+		}
+		multiplier, ok := durationUnits[strings.ToLower(rest[:unitEnd])]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration %q: unknown unit %q; use s, m, h or d", s, rest[:unitEnd])
+		}
+		total += n * multiplier
+		rest = strings.TrimLeft(rest[unitEnd:], " ")
+	}
+	if total != math.Trunc(total) {
+		return 0, fmt.Errorf("invalid duration %q; expected whole seconds", s)
+	}
+	return int64(total), nil
+}
+
+// normalizeSettings validates the size and duration settings and rewrites
+// them in canonical form. memory_limit also accepts -1 (no limit).
+func normalizeSettings(settings map[string]interface{}) error {
+	for _, key := range SizeSettings {
+		value, ok := settings[key]
+		if !ok {
+			continue
+		}
+		canonical, err := NormalizeSize(key, value)
+		if err != nil {
+			return err
+		}
+		settings[key] = canonical
+	}
+	for _, key := range DurationSettings {
+		value, ok := settings[key]
+		if !ok {
+			continue
+		}
+		seconds, err := NormalizeDuration(key, value)
+		if err != nil {
+			return err
+		}
+		settings[key] = seconds
+	}
+	return nil
+}
+
+// NormalizeSize returns the canonical form of a size setting given as a
+// string or a number of bytes
+func NormalizeSize(key string, value interface{}) (string, error) {
+	s, ok := settingString(value)
+	if !ok {
+		return "", fmt.Errorf("invalid %s: must be a size such as 256M", key)
+	}
+	if key == "memory_limit" && strings.TrimSpace(s) == "-1" {
+		return "-1", nil
+	}
+	bytes, err := ParseSize(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return FormatSize(bytes), nil
+}
+
+// NormalizeDuration returns a duration setting given as a string or a
+// number of seconds in whole seconds. JSON numbers are float64, like the
+// rest of the stored settings.
+func NormalizeDuration(key string, value interface{}) (float64, error) {
+	s, ok := settingString(value)
+	if !ok {
+		return 0, fmt.Errorf("invalid %s: must be a duration such as 90s or 2m", key)
+	}
+	seconds, err := ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return float64(seconds), nil
+}
+
+// splitUnit splits "1.5 GB" into "1.5" and "GB"
+func splitUnit(s string) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' && r != ',' })
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// parseDecimal parses a non-negative decimal number written with a point or
+// a comma as the decimal separator
+func parseDecimal(s string) (float64, error) {
+	if strings.Contains(s, ",") {
+		if strings.Contains(s, ".") || strings.Count(s, ",") > 1 {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	if s == "" || strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' }) >= 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
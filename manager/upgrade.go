@@ -0,0 +1,87 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+
+	"lightweight-php/lock"
+	"lightweight-php/provider"
+	"lightweight-php/validation"
+)
+
+// ErrUpgradeUnsupported is returned for providers that cannot upgrade an
+// installed version in place
+var ErrUpgradeUnsupported = errors.New("provider cannot upgrade PHP in place")
+
+// UpgradeResult is the outcome of upgrading one installed version
+type UpgradeResult struct {
+	Version  string `json:"version"`
+	Provider string `json:"provider"`
+	Error    string `json:"error,omitempty"`
+}
+
+// UpgradePHP upgrades a version of a provider to the newest release of its
+// branch and restarts its FPM service. The run is kept in the install
+// history as an "upgrade".
+func (pm *PackageManager) UpgradePHP(version string, providerType provider.ProviderType) (err error) {
+	defer recordAudit(pm.context(), pm.db, "php.upgrade", version, &err)
+	if err := validation.Field("version", version, validation.PHPVersion); err != nil {
+		return err
+	}
+	phpProvider, err := pm.providerFactory.CreateProvider(providerType)
+	if err != nil {
+		return fmt.Errorf("failed to create provider: %w", err)
+	}
+	if _, ok := phpProvider.(provider.Upgrader); !ok {
+		return fmt.Errorf("%w: %s", ErrUpgradeUnsupported, providerType)
+	}
+
+	l, err := pm.locks.Acquire(lock.KeyPackageManager, fmt.Sprintf("upgrade php %s (%s)", version, providerType), !pm.noWait, installLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	return recordInstall(pm.db, pm.providerFactory, providerType, version, "upgrade", pm.progress, func(p provider.PHPProvider) error {
+		return p.(provider.Upgrader).UpgradePHP(version)
+	})
+}
+
+// UpgradeAllPHP upgrades every installed version whose provider can
+// upgrade in place, going on after failures. The error reports how many
+// failed; the results say which.
+func (pm *PackageManager) UpgradeAllPHP() ([]UpgradeResult, error) {
+	versions, err := pm.db.ListPHPVersions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PHP versions: %w", err)
+	}
+	results := make([]UpgradeResult, 0, len(versions))
+	failed := 0
+	seen := make(map[string]bool)
+	for _, v := range versions {
+		providerType := providerTypeFor(v.PackageManager)
+		if v.PackageManager == "ondrej" {
+			// The Remi provider installs from ondrej/php on Debian
+			providerType = provider.ProviderRemi
+		}
+		key := string(providerType) + "/" + v.Version
+		if providerType == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		err := pm.UpgradePHP(v.Version, providerType)
+		if errors.Is(err, ErrUpgradeUnsupported) {
+			continue
+		}
+		result := UpgradeResult{Version: v.Version, Provider: string(providerType)}
+		if err != nil {
+			result.Error = err.Error()
+			failed++
+		}
+		results = append(results, result)
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d PHP upgrades failed", failed, len(results))
+	}
+	return results, nil
+}
@@ -0,0 +1,298 @@
+package manager
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/billing"
+	"lightweight-php/config"
+	"lightweight-php/db"
+)
+
+// ErrPeriodNotOver is returned for exporting the usage of a billing period
+// that has not ended
+var ErrPeriodNotOver = errors.New("billing period is not over")
+
+// diskSampleInterval is how often a pool's disk usage is read for billing,
+// which asks the quota tool and is not needed as often as CPU figures
+const diskSampleInterval = time.Hour
+
+// UsageSampler meters the CPU time, workers, disk usage and bandwidth of
+// every pool and adds them to the pool's usage in the current period. CPU
+// time is the difference to the previous sample, so the first sample only
+// sets the baseline.
+type UsageSampler struct {
+	pm       *PoolManager
+	ticks    map[int]uint64
+	sampled  bool
+	diskAt   map[string]time.Time
+	logSizes map[string]int64
+}
+
+// NewUsageSampler returns a sampler for the API server's usage loop
+func (pm *PoolManager) NewUsageSampler() *UsageSampler {
+	return &UsageSampler{pm: pm, diskAt: make(map[string]time.Time), logSizes: make(map[string]int64)}
+}
+
+// Sample meters every pool once and records the usage. Workers that
+// exited since the previous sample lose the CPU time they used after it.
+func (s *UsageSampler) Sample(now time.Time) error {
+	pools, err := s.pm.db.ListPools()
+	if err != nil {
+		return fmt.Errorf("failed to list pools from database: %w", err)
+	}
+	workers, err := fpmWorkers()
+	if err != nil {
+		return err
+	}
+	bandwidth := s.readAccessLogs(pools)
+
+	ticks := make(map[int]uint64)
+	var errs []string
+	for i := range pools {
+		username := pools[i].Username
+		sample := db.UsageSample{Pool: username, Period: billing.PeriodOf(now), DiskBytes: -1, SampledAt: now}
+		var used uint64
+		for _, pid := range workers[username] {
+			cpu, err := processCPUTicks(filepath.Join("/proc", strconv.Itoa(pid)))
+			if err != nil {
+				continue
+			}
+			sample.Workers++
+			ticks[pid] = cpu
+			// A worker started since the previous sample used all its
+			// time within the interval
+			if previous, ok := s.ticks[pid]; ok && cpu >= previous {
+				used += cpu - previous
+			} else if !ok {
+				used += cpu
+			}
+		}
+		if s.sampled {
+			sample.CPUSeconds = float64(used) / clockTicks
+		}
+		sample.BandwidthBytes = bandwidth[username]
+		if now.Sub(s.diskAt[username]) >= diskSampleInterval {
+			if t, _, err := s.pm.poolTarget(&pools[i]); err == nil {
+				if used, _, err := readDiskUsage(t, username); err == nil {
+					sample.DiskBytes = used
+				}
+			}
+			s.diskAt[username] = now
+		}
+		if err := s.pm.db.AddUsageSample(sample); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", username, err))
+		}
+	}
+	s.ticks, s.sampled = ticks, true
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to record usage: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// readAccessLogs returns the response bytes logged for each user's sites
+// since the previous sample, when billing.access_log is set. A log seen
+// for the first time is read from its end; a log that shrank was rotated
+// and is read from its start.
+func (s *UsageSampler) readAccessLogs(pools []db.Pool) map[string]int64 {
+	pattern := config.Get().Billing.AccessLog
+	if pattern == "" {
+		return nil
+	}
+	sites, err := s.pm.db.ListSites()
+	if err != nil {
+		return nil
+	}
+	targets := make(map[string]*db.Pool, len(pools))
+	for i := range pools {
+		targets[pools[i].Username] = &pools[i]
+	}
+
+	bandwidth := make(map[string]int64)
+	for _, site := range sites {
+		dbPool := targets[site.Username]
+		if dbPool == nil {
+			continue
+		}
+		t, _, err := s.pm.poolTarget(dbPool)
+		if err != nil {
+			continue
+		}
+		path, err := t.Path(strings.ReplaceAll(pattern, "{domain}", site.Domain))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		offset, seen := s.logSizes[path]
+		if !seen {
+			s.logSizes[path] = info.Size()
+			continue
+		}
+		if info.Size() < offset {
+			offset = 0
+		}
+		n, read, err := accessLogBytes(path, offset)
+		if err != nil {
+			continue
+		}
+		s.logSizes[path] = offset + read
+		bandwidth[site.Username] += n
+	}
+	return bandwidth
+}
+
+// accessLogBytes sums the body_bytes_sent of the complete lines of an
+// access log in the combined format after offset, and returns how many
+// bytes of the log it consumed
+func accessLogBytes(path string, offset int64) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	var total, consumed int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// A partial last line is read again once it is complete
+			if err == io.EOF {
+				return total, consumed, nil
+			}
+			return total, consumed, err
+		}
+		consumed += int64(len(line))
+		total += logLineBytes(line)
+	}
+}
+
+// logLineBytes returns the body_bytes_sent of a combined format line:
+// the field after the status, which follows the quoted request. nginx
+// escapes quotes inside the request, so the first `" ` ends it.
+func logLineBytes(line []byte) int64 {
+	start := bytes.Index(line, []byte(`] "`))
+	if start < 0 {
+		return 0
+	}
+	end := bytes.Index(line[start+3:], []byte(`" `))
+	if end < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(line[start+3+end+2:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	n, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// UsageReport returns the metered usage of every pool in a period, a
+// month as YYYY-MM
+func (pm *PoolManager) UsageReport(period string) (*billing.Report, error) {
+	from, to, err := billing.ParsePeriod(period)
+	if err != nil {
+		return nil, err
+	}
+	records, err := pm.db.ListPoolUsage(period)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage from database: %w", err)
+	}
+
+	now := time.Now().UTC()
+	report := &billing.Report{
+		Period:           period,
+		From:             from,
+		To:               to,
+		Complete:         !now.Before(to),
+		GeneratedAt:      now,
+		BandwidthMetered: config.Get().Billing.AccessLog != "",
+		Pools:            make([]billing.PoolUsage, 0, len(records)),
+	}
+	report.Host, _ = os.Hostname()
+	for _, r := range records {
+		u := billing.PoolUsage{
+			Username:      r.Pool,
+			CPUSeconds:    float64(int64(r.CPUSeconds*100)) / 100,
+			PeakWorkers:   r.PeakWorkers,
+			Samples:       r.Samples,
+			FirstSampleAt: r.FirstSampleAt,
+			LastSampleAt:  r.LastSampleAt,
+		}
+		if report.BandwidthMetered {
+			bandwidth := r.BandwidthBytes
+			u.BandwidthBytes = &bandwidth
+		}
+		if r.DiskSamples > 0 {
+			average, peak := r.DiskBytesTotal/r.DiskSamples, r.PeakDiskBytes
+			u.DiskBytesAverage, u.DiskBytesPeak = &average, &peak
+		}
+		report.Pools = append(report.Pools, u)
+	}
+	return report, nil
+}
+
+// ExportUsage sends the usage of a finished period to the configured
+// billing exporter and returns the exporter's name. A period may be
+// exported again, e.g. after a failure.
+func (pm *PoolManager) ExportUsage(period string) (_ string, err error) {
+	defer recordAudit(pm.context(), pm.db, "billing.export", period, &err)
+	report, err := pm.UsageReport(period)
+	if err != nil {
+		return "", err
+	}
+	if !report.Complete {
+		return "", fmt.Errorf("%w: %s ends at %s", ErrPeriodNotOver, period, report.To.Format(time.RFC3339))
+	}
+	exporter, err := billing.NewExporter(config.Get().Billing)
+	if err != nil {
+		return "", err
+	}
+	if err := exporter.Export(pm.context(), report); err != nil {
+		return "", fmt.Errorf("failed to export usage of %s with %s: %w", period, exporter, err)
+	}
+	if err := pm.db.RecordBillingExport(period, exporter.String(), time.Now()); err != nil {
+		return "", fmt.Errorf("failed to record export: %w", err)
+	}
+	return exporter.String(), nil
+}
+
+// ExportDueUsage exports the previous month once it is over, when an
+// exporter is configured and the month was not exported yet. It returns
+// the exported period, or "" if nothing was due.
+func (pm *PoolManager) ExportDueUsage(now time.Time) (string, error) {
+	if config.Get().Billing.Exporter == "" {
+		return "", nil
+	}
+	now = now.UTC()
+	period := billing.PeriodOf(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0))
+	_, exportedAt, err := pm.db.GetBillingExport(period)
+	if err != nil {
+		return "", fmt.Errorf("failed to get billing export: %w", err)
+	}
+	if !exportedAt.IsZero() {
+		return "", nil
+	}
+	if _, err := pm.ExportUsage(period); err != nil {
+		return "", err
+	}
+	return period, nil
+}
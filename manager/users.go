@@ -0,0 +1,209 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+var sshKeyPattern = regexp.MustCompile(`^(ssh-(rsa|ed25519|dss)|ecdsa-sha2-nistp(256|384|521)|sk-(ssh-ed25519|ecdsa-sha2-nistp256)@openssh\.com) [A-Za-z0-9+/=]+( [^\r\n]*)?$`)
+
+// UserOptions describe the system user created with a pool (create_user)
+type UserOptions struct {
+	// Shell overrides users.shell; it must be one of users.allowed_shells
+	Shell string `json:"shell,omitempty"`
+	// SSHKey is written to ~/.ssh/authorized_keys
+	SSHKey string `json:"ssh_key,omitempty"`
+}
+
+// Validate checks the options against the users policy
+func (o UserOptions) Validate() error {
+	policy := config.Get().Users
+	if o.Shell != "" && !containsString(policy.AllowedShells, o.Shell) {
+		return fmt.Errorf("shell %s is not allowed; allowed: %s", o.Shell, strings.Join(policy.AllowedShells, ", "))
+	}
+	if o.SSHKey != "" && !sshKeyPattern.MatchString(strings.TrimSpace(o.SSHKey)) {
+		return fmt.Errorf("ssh_key must be one OpenSSH public key such as \"ssh-ed25519 AAAA... user@host\"")
+	}
+	return nil
+}
+
+// CreatePoolWithUser creates the system user if it does not exist yet and
+// then the pool, like CreatePoolWithProfile. A user created here is removed
+// again if the pool cannot be created. An existing user is used as it is.
+func (pm *PoolManager) CreatePoolWithUser(username, phpVersion, providerType, profileName string, opts UserOptions) error {
+	created, err := pm.provisionUser(pm.target, username, opts)
+	if err != nil {
+		return err
+	}
+	if err := pm.CreatePoolWithProfile(username, phpVersion, providerType, profileName); err != nil {
+		if dbPool, _ := pm.db.GetPool(username); dbPool != nil {
+			// The pool exists, only its profile failed; keep its user
+			return err
+		}
+		if created {
+			if rerr := deleteSystemUser(pm.target, username, true); rerr == nil {
+				pm.db.DeleteManagedUser(username)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// RemovePoolUser locks or deletes the system user of a deleted pool as
+// users.remove_mode says and returns "locked" or "deleted". Only users
+// created with create_user are touched.
+func (pm *PoolManager) RemovePoolUser(username string) (string, error) {
+	if dbPool, err := pm.db.GetPool(username); err != nil {
+		return "", fmt.Errorf("failed to get pool from database: %w", err)
+	} else if dbPool != nil {
+		return "", fmt.Errorf("user %s still has a pool", username)
+	}
+	managed, err := pm.db.GetManagedUser(username)
+	if err != nil {
+		return "", fmt.Errorf("failed to get managed user: %w", err)
+	}
+	if managed == nil {
+		return "", fmt.Errorf("user %s was not created by lightweight-php and is left alone", username)
+	}
+	t, err := target.Parse(managed.Target)
+	if err != nil {
+		return "", err
+	}
+
+	mode := config.Get().Users.RemoveMode
+	if mode == "lock" {
+		if err := runUserCommand(t, "usermod", "--lock", "--expiredate", "1", "--shell", nologinShell(t), username); err != nil {
+			return "", err
+		}
+		if err := pm.db.UpdateManagedUserStatus(username, db.UserLocked); err != nil {
+			return "", fmt.Errorf("failed to record locked user: %w", err)
+		}
+		return "locked", nil
+	}
+	if err := deleteSystemUser(t, username, mode == "delete-home"); err != nil {
+		return "", err
+	}
+	if err := pm.db.DeleteManagedUser(username); err != nil {
+		return "", fmt.Errorf("failed to forget deleted user: %w", err)
+	}
+	return "deleted", nil
+}
+
+// provisionUser creates a user in t following the users policy and reports
+// whether it did; an existing user is left as it is
+func (pm *PoolManager) provisionUser(t target.Target, username string, opts UserOptions) (bool, error) {
+	if _, err := t.LookupUser(username); err == nil {
+		return false, nil
+	}
+	if err := validation.Field("username", username, validation.Username); err != nil {
+		return false, err
+	}
+	if err := opts.Validate(); err != nil {
+		return false, err
+	}
+
+	policy := config.Get().Users
+	shell := policy.Shell
+	if opts.Shell != "" {
+		shell = opts.Shell
+	}
+	args := []string{
+		"--create-home",
+		"--home-dir", filepath.Join(policy.HomeBase, username),
+		"--shell", shell,
+		"--user-group",
+		"-K", "UID_MIN=" + strconv.Itoa(policy.UIDMin), "-K", "UID_MAX=" + strconv.Itoa(policy.UIDMax),
+		"-K", "GID_MIN=" + strconv.Itoa(policy.UIDMin), "-K", "GID_MAX=" + strconv.Itoa(policy.UIDMax),
+	}
+	if policy.SkeletonDir != "" {
+		args = append(args, "--skel", policy.SkeletonDir)
+	}
+	if len(policy.Groups) > 0 {
+		args = append(args, "--groups", strings.Join(policy.Groups, ","))
+	}
+	if err := runUserCommand(t, "useradd", append(args, username)...); err != nil {
+		return false, err
+	}
+
+	u, err := t.LookupUser(username)
+	if err != nil {
+		deleteSystemUser(t, username, true)
+		return false, fmt.Errorf("user %s was created but cannot be looked up: %w", username, err)
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	if err := pm.db.CreateManagedUser(username, t.String(), uid); err != nil {
+		deleteSystemUser(t, username, true)
+		return false, fmt.Errorf("failed to record created user: %w", err)
+	}
+	if opts.SSHKey != "" {
+		if err := installAuthorizedKey(t, u.HomeDir, u.Uid, u.Gid, strings.TrimSpace(opts.SSHKey)); err != nil {
+			return true, fmt.Errorf("user %s was created but installing the SSH key failed: %w", username, err)
+		}
+	}
+	return true, nil
+}
+
+func installAuthorizedKey(t target.Target, home, uid, gid, key string) error {
+	hostHome, err := t.Path(home)
+	if err != nil {
+		return err
+	}
+	sshDir := filepath.Join(hostHome, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return err
+	}
+	keysPath := filepath.Join(sshDir, "authorized_keys")
+	if err := os.WriteFile(keysPath, []byte(key+"\n"), 0600); err != nil {
+		return err
+	}
+
+	u, _ := strconv.Atoi(uid)
+	g, _ := strconv.Atoi(gid)
+	hostUID, hostGID, err := t.HostIDs(u, g)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{sshDir, keysPath} {
+		if err := os.Chown(path, hostUID, hostGID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteSystemUser(t target.Target, username string, removeHome bool) error {
+	args := []string{username}
+	if removeHome {
+		args = []string{"--remove", username}
+	}
+	return runUserCommand(t, "userdel", args...)
+}
+
+func runUserCommand(t target.Target, name string, args ...string) error {
+	if output, err := t.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// nologinShell returns the target's nologin binary
+func nologinShell(t target.Target) string {
+	for _, shell := range []string{"/usr/sbin/nologin", "/sbin/nologin"} {
+		if path, err := t.Path(shell); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				return shell
+			}
+		}
+	}
+	return "/sbin/nologin"
+}
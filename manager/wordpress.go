@@ -0,0 +1,264 @@
+package manager
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lightweight-php/config"
+	"lightweight-php/dbprovision"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+// WPCLIPath is the wp-cli archive pools run with their own PHP
+const WPCLIPath = "/usr/local/lib/lightweight-php/wp-cli.phar"
+
+// wpCLIURL is the latest wp-cli release; its SHA-512 is published next to it
+const wpCLIURL = "https://raw.githubusercontent.com/wp-cli/builds/gh-pages/phar/wp-cli.phar"
+
+// WordPressOptions describe a WordPress site set up by InstallWordPress
+type WordPressOptions struct {
+	Username string
+	Domain   string
+	// PHPVersion and Provider are used when the user has no pool yet
+	PHPVersion string
+	Provider   string
+	// CreateUser creates the system user first, like CreatePoolWithUser
+	CreateUser bool
+	User       UserOptions
+	// DocumentRoot defaults to public_html in the user's home
+	DocumentRoot string
+	// Title defaults to the domain, AdminUser to "admin" and AdminEmail
+	// to admin@ the domain; an empty AdminPassword is generated
+	Title         string
+	AdminUser     string
+	AdminEmail    string
+	AdminPassword string
+	// Step is called with a description before each step
+	Step func(string)
+}
+
+// WordPressInstall is an installed WordPress site
+type WordPressInstall struct {
+	Site      *Site  `json:"site"`
+	URL       string `json:"url"`
+	AdminUser string `json:"admin_user"`
+	// AdminPassword is only set when WordPress was installed by this run
+	AdminPassword string `json:"admin_password,omitempty"`
+}
+
+// InstallWordPress sets up a WordPress site in one go: the user's pool with
+// the wordpress profile, its MySQL database, the site with its nginx
+// snippet, wp-cli and a non-interactive WordPress install run as the user.
+// Every step keeps what an earlier run left, so a failed install can be
+// repeated with the same options.
+func (pm *PoolManager) InstallWordPress(sm *SiteManager, opts WordPressOptions) (_ *WordPressInstall, err error) {
+	defer recordAudit(pm.context(), pm.db, "app.install", "wordpress "+opts.Domain, &err)
+	var invalid validation.Errors
+	invalid.Check("username", opts.Username, validation.Username)
+	invalid.Check("domain", opts.Domain, validation.Domain)
+	if err := invalid.Err(); err != nil {
+		return nil, err
+	}
+	if config.Get().Databases.AdminDSN == "" {
+		return nil, fmt.Errorf("WordPress needs a database: set databases.admin_dsn in the config file")
+	}
+	if opts.Title == "" {
+		opts.Title = opts.Domain
+	}
+	if opts.AdminUser == "" {
+		opts.AdminUser = "admin"
+	}
+	if opts.AdminEmail == "" {
+		opts.AdminEmail = "admin@" + opts.Domain
+	}
+	step := opts.Step
+	if step == nil {
+		step = func(string) {}
+	}
+
+	dbPool, err := pm.db.GetPool(opts.Username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	existed := dbPool != nil
+	if !existed {
+		step(fmt.Sprintf("Creating the pool of %s (PHP %s, wordpress profile)", opts.Username, opts.PHPVersion))
+		if opts.CreateUser {
+			err = pm.CreatePoolWithUser(opts.Username, opts.PHPVersion, opts.Provider, "wordpress", opts.User)
+		} else {
+			err = pm.CreatePoolWithProfile(opts.Username, opts.PHPVersion, opts.Provider, "wordpress")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if dbPool, err = pm.db.GetPool(opts.Username); err != nil {
+			return nil, fmt.Errorf("failed to get pool from database: %w", err)
+		}
+	}
+
+	step("Provisioning the database")
+	dbEnv, err := pm.provisionDatabase(opts.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existed {
+		// Pools from before get the variables a new pool starts with
+		if err := pm.PatchPoolConfig(opts.Username, map[string]interface{}{"env": dbEnv}); err != nil {
+			return nil, fmt.Errorf("failed to add the database to the pool's env: %w", err)
+		}
+	}
+
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.LookupUser(opts.Username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, opts.Username, err)
+	}
+	docroot := opts.DocumentRoot
+	if docroot == "" {
+		docroot = filepath.Join(u.HomeDir, "public_html")
+	}
+	if !filepath.IsAbs(docroot) {
+		return nil, fmt.Errorf("document root must be an absolute path: %s", docroot)
+	}
+	if err := ensureDocroot(t, u, docroot); err != nil {
+		return nil, err
+	}
+	hostDocroot, err := t.Path(docroot)
+	if err != nil {
+		return nil, err
+	}
+
+	site, err := sm.GetSite(opts.Domain)
+	switch {
+	case errors.Is(err, ErrSiteNotFound):
+		step("Creating the site " + opts.Domain)
+		if site, err = sm.CreateSite(opts.Domain, opts.Username, docroot, dbPool.PHPVersion); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case site.Username != opts.Username || site.DocumentRoot != docroot:
+		return nil, fmt.Errorf("site %s already exists for %s in %s", opts.Domain, site.Username, site.DocumentRoot)
+	}
+
+	step("Downloading wp-cli")
+	hostWPCLI, err := t.Path(WPCLIPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := downloadWPCLI(hostWPCLI); err != nil {
+		return nil, err
+	}
+
+	// wp runs a wp-cli command as the user with the pool's PHP
+	wp := func(stdin string, args ...string) error {
+		cmd, err := pm.PoolShell(opts.Username, append([]string{"php", WPCLIPath, "--path=" + docroot}, args...))
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = strings.NewReader(stdin)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("wp %s %s failed: %v: %s", args[0], args[1], err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+
+	hostDocrootFile := func(name string) bool {
+		_, err := os.Stat(filepath.Join(hostDocroot, name))
+		return err == nil
+	}
+	if !hostDocrootFile("wp-includes/version.php") {
+		step("Downloading WordPress")
+		if err := wp("", "core", "download"); err != nil {
+			return nil, err
+		}
+	}
+	if !hostDocrootFile("wp-config.php") {
+		step("Writing wp-config.php")
+		// Secrets go through stdin so they never show up in ps
+		if err := wp(fmt.Sprint(dbEnv["DB_PASSWORD"])+"\n", "config", "create",
+			"--dbname="+fmt.Sprint(dbEnv["DB_DATABASE"]),
+			"--dbuser="+fmt.Sprint(dbEnv["DB_USERNAME"]),
+			"--dbhost="+net.JoinHostPort(fmt.Sprint(dbEnv["DB_HOST"]), fmt.Sprint(dbEnv["DB_PORT"])),
+			"--prompt=dbpass",
+			"--skip-check",
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	scheme := "http"
+	if site.Certificate != nil {
+		scheme = "https"
+	}
+	result := &WordPressInstall{Site: site, URL: scheme + "://" + opts.Domain + "/", AdminUser: opts.AdminUser}
+	if err := wp("", "core", "is-installed"); err == nil {
+		return result, nil
+	}
+
+	step("Installing WordPress")
+	password := opts.AdminPassword
+	if password == "" {
+		if password, err = dbprovision.Password(); err != nil {
+			return nil, fmt.Errorf("failed to generate a password: %w", err)
+		}
+	}
+	if err := wp(password+"\n", "core", "install",
+		"--url="+result.URL,
+		"--title="+opts.Title,
+		"--admin_user="+opts.AdminUser,
+		"--admin_email="+opts.AdminEmail,
+		"--prompt=admin_password",
+		"--skip-email",
+	); err != nil {
+		return nil, err
+	}
+	result.AdminPassword = password
+	return result, nil
+}
+
+// downloadWPCLI fetches wp-cli to path unless it is there, checking it
+// against the published SHA-512
+func downloadWPCLI(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+	for _, download := range [][]string{{tmp, wpCLIURL}, {tmp + ".sha512", wpCLIURL + ".sha512"}} {
+		if output, err := target.Host.Command("curl", "-fsSL", "-o", download[0], download[1]).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to download %s: %v: %s", download[1], err, strings.TrimSpace(string(output)))
+		}
+	}
+	defer os.Remove(tmp + ".sha512")
+
+	want, err := os.ReadFile(tmp + ".sha512")
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	sum := sha512.Sum512(data)
+	if fields := strings.Fields(string(want)); len(fields) == 0 || !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+		return fmt.Errorf("downloaded wp-cli does not match its published SHA-512")
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,561 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/db"
+	"lightweight-php/lock"
+	"lightweight-php/servicemgr"
+	"lightweight-php/target"
+	"lightweight-php/validation"
+)
+
+var (
+	// ErrWorkerNotFound is returned for an unknown worker of a pool
+	ErrWorkerNotFound = errors.New("worker not found")
+	// ErrWorkerExists is returned when a pool already has a worker of the
+	// name
+	ErrWorkerExists = errors.New("worker already exists")
+	// ErrWorkersUnsupported is returned on targets whose service manager
+	// cannot run workers
+	ErrWorkersUnsupported = errors.New("workers need systemd or supervisord")
+)
+
+const (
+	// MaxWorkerProcesses bounds the processes of one worker
+	MaxWorkerProcesses = 32
+	// workerLogDir holds the logs of workers run by supervisord; systemd
+	// keeps them in the journal
+	workerLogDir = "/var/log/lightweight-php/workers"
+)
+
+// Arguments and directories end up unquoted in systemd units and
+// supervisord programs, where %, $, quotes and spaces mean something
+var (
+	workerArgPattern = regexp.MustCompile(`^[A-Za-z0-9_./:=,@+-]+$`)
+	workerDirPattern = regexp.MustCompile(`^/[A-Za-z0-9_./@+-]*$`)
+)
+
+// WorkerSpec describes a worker to add to a pool
+type WorkerSpec struct {
+	Name string `json:"name"`
+	// Command is what the pool's PHP runs, e.g. "artisan queue:work"
+	Command string `json:"command"`
+	// Directory is the working directory; empty is the user's home
+	Directory string `json:"directory,omitempty"`
+	// Processes is the number of copies to run (default 1)
+	Processes int `json:"processes,omitempty"`
+}
+
+// Validate checks a worker spec
+func (s WorkerSpec) Validate() error {
+	var errs validation.Errors
+	errs.Check("name", s.Name, validation.WorkerName)
+	errs.Check("command", s.Command, func(command string) error {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return fmt.Errorf("is required")
+		}
+		for _, arg := range args {
+			if !workerArgPattern.MatchString(arg) {
+				return fmt.Errorf("invalid argument %q: only letters, digits and _ . / : = , @ + - are allowed", arg)
+			}
+		}
+		return nil
+	})
+	if s.Directory != "" {
+		errs.Check("directory", s.Directory, func(dir string) error {
+			if !workerDirPattern.MatchString(dir) || strings.Contains(dir, "..") {
+				return fmt.Errorf("must be an absolute path of letters, digits and _ . / @ + -")
+			}
+			return nil
+		})
+	}
+	if s.Processes < 0 || s.Processes > MaxWorkerProcesses {
+		errs = append(errs, validation.FieldError{Field: "processes", Message: fmt.Sprintf("must be between 1 and %d", MaxWorkerProcesses)})
+	}
+	return errs.Err()
+}
+
+// Worker is a long-running PHP command kept running next to a pool, as the
+// pool's user with the pool's PHP
+type Worker struct {
+	Name      string `json:"name"`
+	Command   string `json:"command"`
+	Directory string `json:"directory"`
+	Processes int    `json:"processes"`
+	// Status is active when every process runs, failed when one failed
+	// and inactive otherwise
+	Status servicemgr.Status `json:"status"`
+	// Service is the systemd template unit or supervisord program
+	Service   string    `json:"service"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkerLog is the tail of a worker's output
+type WorkerLog struct {
+	Worker string `json:"worker"`
+	// Source is "journal" for systemd and "file" for supervisord
+	Source string   `json:"source"`
+	Lines  []string `json:"lines"`
+}
+
+// workerService names the unit or program of a worker; the dot keeps user
+// and worker names apart, since neither may contain one
+func workerService(username, name string) string {
+	return "lightweight-php-worker-" + username + "." + name
+}
+
+// workerProcesses returns the service names of a worker's processes
+func workerProcesses(kind, service string, processes int) []string {
+	names := make([]string, 0, processes)
+	for i := 1; i <= processes; i++ {
+		if kind == servicemgr.KindSupervisord {
+			names = append(names, fmt.Sprintf("%s:%s_%d", service, service, i))
+		} else {
+			names = append(names, fmt.Sprintf("%s@%d", service, i))
+		}
+	}
+	return names
+}
+
+// workerServices returns the service manager of a pool's target, which
+// must be able to run workers
+func (pm *PoolManager) workerServices(t target.Target) (servicemgr.Manager, error) {
+	services := servicemgr.ForTarget(t, servicemgr.TargetRunner(pm.context(), t))
+	switch services.Kind() {
+	case servicemgr.KindSystemd, servicemgr.KindSupervisord:
+		return services, nil
+	}
+	return nil, fmt.Errorf("%w; target %s uses %s", ErrWorkersUnsupported, t, services.Kind())
+}
+
+// supervisordConfigPath returns where a program file goes: conf.d of
+// Debian's supervisor package, or supervisord.d of the RHEL one
+func supervisordConfigPath(t target.Target, service string) (string, error) {
+	debian, err := t.Path("/etc/supervisor/conf.d")
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(debian); err == nil && info.IsDir() {
+		return filepath.Join(debian, service+".conf"), nil
+	}
+	rhel, err := t.Path("/etc/supervisord.d")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(rhel, service+".ini"), nil
+}
+
+// workerConfigPath returns the host path of a worker's unit or program file
+func workerConfigPath(t target.Target, kind, service string) (string, error) {
+	if kind == servicemgr.KindSupervisord {
+		return supervisordConfigPath(t, service)
+	}
+	return t.Path("/etc/systemd/system/" + service + "@.service")
+}
+
+// renderWorker returns the systemd template unit or supervisord program of
+// a worker. Both run the user's CLI wrapper, so the worker uses the pool's
+// PHP version and ini settings.
+func renderWorker(kind, service, username, home string, w *db.PoolWorker) string {
+	command := CLIWrapperPath(username) + " " + strings.Join(strings.Fields(w.Command), " ")
+	var b strings.Builder
+	if kind == servicemgr.KindSupervisord {
+		fmt.Fprintf(&b, "; Generated by lightweight-php for worker %s of pool %s.\n", w.Name, username)
+		fmt.Fprintf(&b, "[program:%s]\n", service)
+		fmt.Fprintf(&b, "command=%s\n", command)
+		b.WriteString("process_name=%(program_name)s_%(process_num)d\n")
+		fmt.Fprintf(&b, "numprocs=%d\n", w.Processes)
+		b.WriteString("numprocs_start=1\n")
+		fmt.Fprintf(&b, "directory=%s\n", w.Directory)
+		fmt.Fprintf(&b, "user=%s\n", username)
+		fmt.Fprintf(&b, "environment=HOME=\"%s\",USER=\"%s\"\n", home, username)
+		b.WriteString("autostart=true\nautorestart=true\nstartsecs=1\nstopwaitsecs=60\nredirect_stderr=true\n")
+		fmt.Fprintf(&b, "stdout_logfile=%s/%s.%s-%%(process_num)d.log\n", workerLogDir, username, w.Name)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "# Generated by lightweight-php for worker %s of pool %s.\n", w.Name, username)
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=lightweight-php worker %s of %s, process %%i\n", w.Name, username)
+	b.WriteString("After=network.target\n\n[Service]\n")
+	fmt.Fprintf(&b, "User=%s\n", username)
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", w.Directory)
+	fmt.Fprintf(&b, "ExecStart=%s\n", command)
+	b.WriteString("Restart=always\nRestartSec=5\nTimeoutStopSec=60\n\n[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// reloadWorkerConfigs makes the service manager read changed worker files;
+// supervisord also starts added programs and stops removed ones
+func reloadWorkerConfigs(services servicemgr.Manager, t target.Target, service string) error {
+	var commands [][]string
+	if services.Kind() == servicemgr.KindSupervisord {
+		commands = [][]string{{"supervisorctl", "reread"}, {"supervisorctl", "update", service}}
+	} else {
+		commands = [][]string{{"systemctl", "daemon-reload"}}
+	}
+	for _, c := range commands {
+		if output, err := t.Command(c[0], c[1:]...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", strings.Join(c, " "), err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// targetPool returns a pool with the target it runs in
+func (pm *PoolManager) targetPool(username string) (*db.Pool, target.Target, error) {
+	dbPool, err := pm.db.GetPool(username)
+	if err != nil {
+		return nil, target.Target{}, fmt.Errorf("failed to get pool from database: %w", err)
+	}
+	if dbPool == nil {
+		return nil, target.Target{}, fmt.Errorf("%w: %s", ErrPoolNotFound, username)
+	}
+	t, _, err := pm.poolTarget(dbPool)
+	if err != nil {
+		return nil, target.Target{}, err
+	}
+	return dbPool, t, nil
+}
+
+// workerRecord returns a worker of a pool or ErrWorkerNotFound
+func (pm *PoolManager) workerRecord(username, name string) (*db.PoolWorker, error) {
+	w, err := pm.db.GetPoolWorker(username, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worker from database: %w", err)
+	}
+	if w == nil {
+		return nil, fmt.Errorf("%w: %s of %s", ErrWorkerNotFound, name, username)
+	}
+	return w, nil
+}
+
+// toWorker adds the status of a worker's processes to its record
+func toWorker(services servicemgr.Manager, username string, w *db.PoolWorker) Worker {
+	service := workerService(username, w.Name)
+	worker := Worker{
+		Name:      w.Name,
+		Command:   w.Command,
+		Directory: w.Directory,
+		Processes: w.Processes,
+		Status:    servicemgr.StatusUnknown,
+		Service:   service,
+		CreatedAt: w.CreatedAt,
+	}
+	if services == nil {
+		return worker
+	}
+	worker.Status = servicemgr.StatusActive
+	for _, process := range workerProcesses(services.Kind(), service, w.Processes) {
+		status, _ := services.Status(process)
+		switch {
+		case status == servicemgr.StatusFailed:
+			worker.Status = servicemgr.StatusFailed
+		case status != servicemgr.StatusActive && worker.Status == servicemgr.StatusActive:
+			worker.Status = status
+		}
+	}
+	return worker
+}
+
+// ListWorkers returns the workers of a pool with their status
+func (pm *PoolManager) ListWorkers(username string) ([]Worker, error) {
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	records, err := pm.db.ListPoolWorkers(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	services, _ := pm.workerServices(t)
+	workers := make([]Worker, 0, len(records))
+	for i := range records {
+		workers = append(workers, toWorker(services, username, &records[i]))
+	}
+	return workers, nil
+}
+
+// GetWorker returns a worker of a pool with its status
+func (pm *PoolManager) GetWorker(username, name string) (*Worker, error) {
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	record, err := pm.workerRecord(username, name)
+	if err != nil {
+		return nil, err
+	}
+	services, _ := pm.workerServices(t)
+	worker := toWorker(services, username, record)
+	return &worker, nil
+}
+
+// AddWorker defines a worker of a pool, writes its systemd unit or
+// supervisord program and starts it
+func (pm *PoolManager) AddWorker(username string, spec WorkerSpec) (_ *Worker, err error) {
+	defer recordAudit(pm.context(), pm.db, "worker.add", username+"/"+spec.Name, &err)
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if spec.Processes == 0 {
+		spec.Processes = 1
+	}
+	l, err := pm.acquire(lock.PoolKey(username), "add worker "+spec.Name+" to "+username)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	if existing, err := pm.db.GetPoolWorker(username, spec.Name); err != nil {
+		return nil, fmt.Errorf("failed to get worker from database: %w", err)
+	} else if existing != nil {
+		return nil, fmt.Errorf("%w: %s of %s", ErrWorkerExists, spec.Name, username)
+	}
+	services, err := pm.workerServices(t)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.LookupUser(username)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrUserMissing, username, err)
+	}
+	// Pools created before wrappers existed get theirs here
+	if err := pm.syncCLIWrapper(t, username); err != nil {
+		return nil, err
+	}
+	if hostWrapper, err := t.Path(CLIWrapperPath(username)); err != nil {
+		return nil, err
+	} else if _, err := os.Stat(hostWrapper); err != nil {
+		return nil, fmt.Errorf("pool of %s has no PHP CLI wrapper; providers without a CLI binary on the host cannot run workers", username)
+	}
+
+	record := &db.PoolWorker{
+		Pool:      username,
+		Name:      spec.Name,
+		Command:   strings.Join(strings.Fields(spec.Command), " "),
+		Directory: spec.Directory,
+		Processes: spec.Processes,
+		CreatedAt: time.Now(),
+	}
+	if record.Directory == "" {
+		record.Directory = u.HomeDir
+	}
+	service := workerService(username, spec.Name)
+	configPath, err := workerConfigPath(t, services.Kind(), service)
+	if err != nil {
+		return nil, err
+	}
+	if services.Kind() == servicemgr.KindSupervisord {
+		hostLogDir, err := t.Path(workerLogDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(hostLogDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", workerLogDir, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(configPath), err)
+	}
+	if err := os.WriteFile(configPath, []byte(renderWorker(services.Kind(), service, username, u.HomeDir, record)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write worker config: %w", err)
+	}
+	if err := pm.db.CreatePoolWorker(*record); err != nil {
+		os.Remove(configPath)
+		return nil, fmt.Errorf("failed to save worker to database: %w", err)
+	}
+	if err := reloadWorkerConfigs(services, t, service); err != nil {
+		return nil, fmt.Errorf("worker added but not loaded: %w", err)
+	}
+	if err := startWorker(services, service, record.Processes); err != nil {
+		return nil, fmt.Errorf("worker added but failed to start: %w", err)
+	}
+	worker := toWorker(services, username, record)
+	return &worker, nil
+}
+
+// DeleteWorker stops a worker and removes its unit or program
+func (pm *PoolManager) DeleteWorker(username, name string) (err error) {
+	defer recordAudit(pm.context(), pm.db, "worker.delete", username+"/"+name, &err)
+	l, err := pm.acquire(lock.PoolKey(username), "delete worker "+name+" of "+username)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return err
+	}
+	record, err := pm.workerRecord(username, name)
+	if err != nil {
+		return err
+	}
+	return pm.removeWorker(t, username, record)
+}
+
+func (pm *PoolManager) removeWorker(t target.Target, username string, record *db.PoolWorker) error {
+	services, err := pm.workerServices(t)
+	if err != nil {
+		return err
+	}
+	service := workerService(username, record.Name)
+	stopWorker(services, service, record.Processes)
+	configPath, err := workerConfigPath(t, services.Kind(), service)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(configPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove worker config: %w", err)
+	}
+	if err := reloadWorkerConfigs(services, t, service); err != nil {
+		return err
+	}
+	if err := pm.db.DeletePoolWorker(username, record.Name); err != nil {
+		return fmt.Errorf("failed to delete worker from database: %w", err)
+	}
+	return nil
+}
+
+// removePoolWorkers removes every worker of a pool being deleted
+func (pm *PoolManager) removePoolWorkers(t target.Target, username string) error {
+	records, err := pm.db.ListPoolWorkers(username)
+	if err != nil {
+		return fmt.Errorf("failed to list workers: %w", err)
+	}
+	for i := range records {
+		if err := pm.removeWorker(t, username, &records[i]); err != nil {
+			return fmt.Errorf("worker %s: %w", records[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Worker actions accepted by ControlWorker
+const (
+	WorkerStart   = "start"
+	WorkerStop    = "stop"
+	WorkerRestart = "restart"
+)
+
+// ControlWorker starts, stops or restarts every process of a worker. A
+// stopped worker stays stopped across reboots until it is started again.
+func (pm *PoolManager) ControlWorker(username, name, action string) (_ *Worker, err error) {
+	defer recordAudit(pm.context(), pm.db, "worker."+action, username+"/"+name, &err)
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	record, err := pm.workerRecord(username, name)
+	if err != nil {
+		return nil, err
+	}
+	services, err := pm.workerServices(t)
+	if err != nil {
+		return nil, err
+	}
+	service := workerService(username, name)
+	switch action {
+	case WorkerStart:
+		err = startWorker(services, service, record.Processes)
+	case WorkerStop:
+		err = stopWorker(services, service, record.Processes)
+	case WorkerRestart:
+		for _, process := range workerProcesses(services.Kind(), service, record.Processes) {
+			if err = services.ReloadOrRestart(process); err != nil {
+				break
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid worker action %q; use start, stop or restart", action)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s worker %s: %w", action, name, err)
+	}
+	worker := toWorker(services, username, record)
+	return &worker, nil
+}
+
+func startWorker(services servicemgr.Manager, service string, processes int) error {
+	for _, process := range workerProcesses(services.Kind(), service, processes) {
+		if err := services.Enable(process); err != nil {
+			return err
+		}
+		if err := services.Start(process); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stopWorker(services servicemgr.Manager, service string, processes int) error {
+	var firstErr error
+	for _, process := range workerProcesses(services.Kind(), service, processes) {
+		if err := services.Disable(process); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// WorkerLogs returns the last lines a worker's processes printed: from the
+// journal under systemd, or from the per-process log files of supervisord
+// with each line prefixed by its process number
+func (pm *PoolManager) WorkerLogs(username, name string, lines int) (*WorkerLog, error) {
+	if lines <= 0 || lines > maxFPMLogLines {
+		return nil, fmt.Errorf("lines must be between 1 and %d", maxFPMLogLines)
+	}
+	_, t, err := pm.targetPool(username)
+	if err != nil {
+		return nil, err
+	}
+	record, err := pm.workerRecord(username, name)
+	if err != nil {
+		return nil, err
+	}
+	services, err := pm.workerServices(t)
+	if err != nil {
+		return nil, err
+	}
+	service := workerService(username, name)
+
+	if services.Kind() == servicemgr.KindSystemd {
+		output, err := t.Command("journalctl", "--unit", service+"@*", "--lines", strconv.Itoa(lines), "--no-pager", "--output", "short-iso").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the journal of %s: %w", service, err)
+		}
+		return &WorkerLog{Worker: name, Source: "journal", Lines: splitLines(string(output))}, nil
+	}
+
+	log := &WorkerLog{Worker: name, Source: "file", Lines: []string{}}
+	for i := 1; i <= record.Processes; i++ {
+		hostPath, err := t.Path(fmt.Sprintf("%s/%s.%s-%d.log", workerLogDir, username, name, i))
+		if err != nil {
+			return nil, err
+		}
+		tail, err := tailFile(hostPath, lines)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read worker log: %w", err)
+		}
+		for _, line := range tail {
+			log.Lines = append(log.Lines, fmt.Sprintf("[%d] %s", i, line))
+		}
+	}
+	return log, nil
+}
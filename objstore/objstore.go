@@ -0,0 +1,86 @@
+// Package objstore stores opaque objects (database snapshots, backups) in a
+// local directory, on a second host over SSH, or in S3-compatible storage.
+package objstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+)
+
+// ErrNotFound is returned by Get for a missing key
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object
+type Object struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Store is a flat key/value object store. Keys use "/" as separator and are
+// relative to the store's prefix.
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any object
+	Put(key string, r io.Reader, size int64) error
+	// Get opens the object under key; the caller closes it
+	Get(key string) (io.ReadCloser, error)
+	// List returns the objects whose key starts with prefix, sorted by key
+	List(prefix string) ([]Object, error)
+	Delete(key string) error
+	// String returns the store's URL without credentials
+	String() string
+}
+
+// Open returns the store for a URL:
+//
+//	s3://bucket/prefix         S3 or an S3-compatible service (see config.S3Config)
+//	ssh://[user@]host/path     a directory on another host, over ssh and rsync
+//	file:///path or /path      a local directory, e.g. a mounted share
+func Open(rawURL string, s3 config.S3Config) (Store, error) {
+	if strings.HasPrefix(rawURL, "/") {
+		return NewDir(rawURL), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %w", rawURL, err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid store URL %q: missing bucket", rawURL)
+		}
+		return NewS3(u.Host, prefix, s3)
+	case "ssh":
+		if u.Host == "" || prefix == "" {
+			return nil, fmt.Errorf("invalid store URL %q: expected ssh://[user@]host/path", rawURL)
+		}
+		return NewSSH(u.User.Username(), u.Host, "/"+prefix), nil
+	case "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("invalid store URL %q: missing path", rawURL)
+		}
+		return NewDir(u.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported store URL %q; expected s3://, ssh:// or file://", rawURL)
+	}
+}
+
+// validKey rejects keys that would escape a directory store
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
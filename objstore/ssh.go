@@ -0,0 +1,133 @@
+package objstore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshNotFound is the exit status Get's remote command uses for a missing file
+const sshNotFound = 44
+
+// SSH stores objects as files in a directory on another host. It runs ssh
+// in batch mode, so key-based authentication must be set up, and expects a
+// POSIX shell and GNU find on the remote side.
+type SSH struct {
+	destination string
+	root        string
+}
+
+// NewSSH returns a store in dir on host, logging in as user when set
+func NewSSH(user, host, dir string) *SSH {
+	destination := host
+	if user != "" {
+		destination = user + "@" + host
+	}
+	return &SSH{destination: destination, root: path.Clean(dir)}
+}
+
+func (s *SSH) String() string {
+	return "ssh://" + s.destination + s.root
+}
+
+// command runs a shell command on the remote host. ssh joins its arguments
+// into one command line, so every path is quoted.
+func (s *SSH) command(script string) *exec.Cmd {
+	return exec.Command("ssh", "-o", "BatchMode=yes", s.destination, "--", script)
+}
+
+func (s *SSH) Put(key string, r io.Reader, size int64) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	dest := path.Join(s.root, key)
+	tmp := dest + ".tmp"
+	cmd := s.command(fmt.Sprintf("mkdir -p %s && cat > %s && mv %s %s",
+		shellQuote(path.Dir(dest)), shellQuote(tmp), shellQuote(tmp), shellQuote(dest)))
+	cmd.Stdin = r
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to upload %s to %s: %s", key, s.destination, sshError(err, output))
+	}
+	return nil
+}
+
+func (s *SSH) Get(key string) (io.ReadCloser, error) {
+	if err := validKey(key); err != nil {
+		return nil, err
+	}
+	p := shellQuote(path.Join(s.root, key))
+	cmd := s.command(fmt.Sprintf("test -f %s || exit %d; cat %s", p, sshNotFound, p))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == sshNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+		}
+		return nil, fmt.Errorf("failed to download %s from %s: %s", key, s.destination, sshError(err, stderr.Bytes()))
+	}
+	return io.NopCloser(&stdout), nil
+}
+
+func (s *SSH) List(prefix string) ([]Object, error) {
+	root := shellQuote(s.root)
+	cmd := s.command(fmt.Sprintf("test -d %s || exit 0; find %s -type f ! -name '*.tmp' -printf '%%P\\t%%s\\t%%T@\\n'", root, root))
+	output, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		var stderr []byte
+		if errors.As(err, &exitErr) {
+			stderr = exitErr.Stderr
+		}
+		return nil, fmt.Errorf("failed to list %s: %s", s, sshError(err, stderr))
+	}
+
+	objects := make([]Object, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 || !strings.HasPrefix(fields[0], prefix) {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
+		seconds, _ := strconv.ParseFloat(fields[2], 64)
+		objects = append(objects, Object{
+			Key:      fields[0],
+			Size:     size,
+			Modified: time.Unix(int64(seconds), 0).UTC(),
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *SSH) Delete(key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if output, err := s.command("rm -f " + shellQuote(path.Join(s.root, key))).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete %s on %s: %s", key, s.destination, sshError(err, output))
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func sshError(err error, output []byte) string {
+	if msg := strings.TrimSpace(string(output)); msg != "" {
+		return msg
+	}
+	return err.Error()
+}
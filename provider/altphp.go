@@ -11,6 +11,7 @@ import (
 
 // AltPHPProvider implements PHPProvider for Alternative PHP (alt-php)
 type AltPHPProvider struct {
+	runner
 	db       *db.Database
 	osFamily system.OSFamily
 }
@@ -41,11 +42,53 @@ func (p *AltPHPProvider) GetConfigPath(username, version string) string {
 	return filepath.Join("/etc/opt/alt/php%s", versionNum, "php-fpm.d", fmt.Sprintf("%s.conf", username))
 }
 
+func (p *AltPHPProvider) GetFPMConfigPath(version string) string {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return filepath.Join("/opt/alt", fmt.Sprintf("php%s", versionNum), "etc/php-fpm.conf")
+}
+
+func (p *AltPHPProvider) GetConfDir(version string) string {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return filepath.Join("/opt/alt", fmt.Sprintf("php%s", versionNum), "etc/php.d")
+}
+
+func (p *AltPHPProvider) GetBinaryPath(version string) string {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return filepath.Join("/opt/alt", fmt.Sprintf("php%s", versionNum), "usr/bin/php")
+}
+
+func (p *AltPHPProvider) GetFPMBinaryPath(version string) string {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return filepath.Join("/opt/alt", fmt.Sprintf("php%s", versionNum), "usr/sbin/php-fpm")
+}
+
+func (p *AltPHPProvider) InstallExtension(version, extension string) error {
+	// alt-php ships all PECL extensions in one package per version
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return p.installPackages(p.osFamily, fmt.Sprintf("alt-php%s-pecl-ext", versionNum))
+}
+
+func (p *AltPHPProvider) InstallBuildTools(version string) error {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return p.installBuildTools(p.osFamily, fmt.Sprintf("alt-php%s-devel", versionNum))
+}
+
 func (p *AltPHPProvider) InstallPHP(version string) error {
+	// CloudLinux builds alt-php for x86_64 only
+	if arch, err := p.arch(); err != nil {
+		return err
+	} else if arch != system.ArchX86_64 {
+		return fmt.Errorf("%w: alt-php is not built for %s", ErrArchNotShipped, arch)
+	}
 	// TODO: Implement Alt-PHP installation
 	return fmt.Errorf("Alt-PHP provider not yet implemented")
 }
 
+func (p *AltPHPProvider) UninstallPHP(version string) error {
+	// TODO: Implement Alt-PHP removal
+	return fmt.Errorf("Alt-PHP provider not yet implemented")
+}
+
 func (p *AltPHPProvider) ListInstalledPHP() ([]string, error) {
 	// TODO: Implement listing installed Alt-PHP versions
 	return []string{}, nil
@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+
+	"lightweight-php/system"
+)
+
+// ErrArchNotShipped is returned when a provider publishes no packages of a
+// PHP version for the target's architecture
+var ErrArchNotShipped = errors.New("not available for this architecture")
+
+// arch returns the architecture of the runner's target
+func (r *runner) arch() (system.Arch, error) {
+	root, err := r.target.Root()
+	if err != nil {
+		return "", err
+	}
+	return system.NewOSDetectorAt(root).DetectArch()
+}
+
+// rpmArch returns the target's architecture as used in yum repository
+// paths, x86_64 when it cannot be told
+func (r *runner) rpmArch() string {
+	arch, err := r.arch()
+	if err != nil {
+		return string(system.ArchX86_64)
+	}
+	return string(arch)
+}
+
+// requireELForArch refuses installs on aarch64 RHEL-family releases older
+// than minMajor, for repositories that publish no aarch64 packages for
+// them. An unknown release is let through to the package manager.
+func (r *runner) requireELForArch(repo, version string, minMajor int) error {
+	arch, err := r.arch()
+	if err != nil {
+		return err
+	}
+	if arch != system.ArchAArch64 {
+		return nil
+	}
+	major := r.osInfo().Major()
+	if major == 0 || major >= minMajor {
+		return nil
+	}
+	return fmt.Errorf("%w: %s publishes no PHP %s packages for %s on EL %d; use EL %d or later", ErrArchNotShipped, repo, version, arch, major, minMajor)
+}
@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// InstalledCacheTTL is how long the installed versions a provider detected
+// are reused. Installs and uninstalls drop them at once with
+// InvalidateInstalled; the TTL catches packages changed outside
+// lightweight-php or by another process.
+const InstalledCacheTTL = time.Minute
+
+type installedEntry struct {
+	versions []string
+	expires  time.Time
+}
+
+// installedCache keeps the result of ListInstalledPHP per provider and
+// target, as detection runs dnf, yum, rpm or dpkg
+var installedCache = struct {
+	sync.Mutex
+	entries map[string]installedEntry
+	// generation counts invalidations, so a detection that ran across one
+	// is not cached
+	generation int
+}{entries: make(map[string]installedEntry)}
+
+// cachedInstalled returns the cached versions for key, or runs detect and
+// caches its result. Errors are not cached. Callers get their own copy, as
+// they sort it.
+func cachedInstalled(key string, detect func() ([]string, error)) ([]string, error) {
+	installedCache.Lock()
+	entry, ok := installedCache.entries[key]
+	generation := installedCache.generation
+	installedCache.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return append([]string{}, entry.versions...), nil
+	}
+
+	versions, err := detect()
+	if err != nil {
+		return versions, err
+	}
+	installedCache.Lock()
+	if installedCache.generation == generation {
+		installedCache.entries[key] = installedEntry{
+			versions: append([]string{}, versions...),
+			expires:  time.Now().Add(InstalledCacheTTL),
+		}
+	}
+	installedCache.Unlock()
+	return versions, nil
+}
+
+// InvalidateInstalled drops the cached installed versions of every
+// provider, so the next ListInstalledPHP detects them again
+func InvalidateInstalled() {
+	installedCache.Lock()
+	installedCache.entries = make(map[string]installedEntry)
+	installedCache.generation++
+	installedCache.Unlock()
+}
+
+// installedKey is the cache key of a provider's installed versions on the
+// runner's target
+func (r *runner) installedKey(providerType ProviderType) string {
+	return string(providerType) + "@" + r.target.String()
+}
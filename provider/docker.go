@@ -10,6 +10,7 @@ import (
 
 // DockerProvider implements PHPProvider for Docker-hosted PHP
 type DockerProvider struct {
+	runner
 	db       *db.Database
 	osFamily system.OSFamily
 }
@@ -31,8 +32,8 @@ func (p *DockerProvider) GetServiceName(version string) string {
 }
 
 func (p *DockerProvider) GetSocketPath(username, version string) string {
-	// Docker socket path (could be TCP or Unix socket)
-	return fmt.Sprintf("/var/run/docker/php-%s-%s.sock", version, username)
+	// One directory per pool, mounted into the pool's container alone
+	return fmt.Sprintf("/run/lightweight-php/docker/%s/php-%s.sock", username, version)
 }
 
 func (p *DockerProvider) GetConfigPath(username, version string) string {
@@ -40,11 +41,43 @@ func (p *DockerProvider) GetConfigPath(username, version string) string {
 	return filepath.Join("/etc/docker/php", version, fmt.Sprintf("%s.conf", username))
 }
 
+func (p *DockerProvider) GetFPMConfigPath(version string) string {
+	// Mounted over the container's php-fpm.conf
+	return filepath.Join("/etc/docker/php", version, "php-fpm.conf")
+}
+
+func (p *DockerProvider) GetConfDir(version string) string {
+	// Mounted into the container's conf.d directory
+	return filepath.Join("/etc/docker/php", version, "conf.d")
+}
+
+func (p *DockerProvider) GetBinaryPath(version string) string {
+	// PHP only exists inside the container
+	return ""
+}
+
+func (p *DockerProvider) GetFPMBinaryPath(version string) string {
+	return ""
+}
+
+func (p *DockerProvider) InstallExtension(version, extension string) error {
+	return fmt.Errorf("extension %s must be built into the PHP %s image", extension, version)
+}
+
+func (p *DockerProvider) InstallBuildTools(version string) error {
+	return fmt.Errorf("extensions for PHP %s must be built into its image", version)
+}
+
 func (p *DockerProvider) InstallPHP(version string) error {
 	// TODO: Implement Docker PHP installation
 	return fmt.Errorf("Docker PHP provider not yet implemented")
 }
 
+func (p *DockerProvider) UninstallPHP(version string) error {
+	// TODO: Implement Docker PHP removal
+	return fmt.Errorf("Docker PHP provider not yet implemented")
+}
+
 func (p *DockerProvider) ListInstalledPHP() ([]string, error) {
 	// TODO: Implement listing installed Docker PHP versions
 	// This would query Docker containers
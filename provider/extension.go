@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"lightweight-php/system"
+)
+
+// installPackages installs distribution packages with the native package tool
+func (r *runner) installPackages(osFamily system.OSFamily, packages ...string) error {
+	if osFamily == system.OSSUSE {
+		if err := r.runQuiet("zypper", append([]string{"--non-interactive", "install"}, packages...)...); err != nil {
+			return fmt.Errorf("failed to install %v: %w", packages, err)
+		}
+		return nil
+	}
+	if osFamily == system.OSRHEL {
+		pkgTool := "yum"
+		if r.hasCommand("dnf") {
+			pkgTool = "dnf"
+		}
+		if err := r.runQuiet(pkgTool, append([]string{"install", "-y"}, packages...)...); err != nil {
+			return fmt.Errorf("failed to install %v: %w", packages, err)
+		}
+		return nil
+	}
+
+	if err := r.runQuiet("apt-get", append([]string{"install", "-y"}, packages...)...); err != nil {
+		return fmt.Errorf("failed to install %v: %w", packages, err)
+	}
+	return nil
+}
+
+// installBuildTools installs a C toolchain with autoconf and the given PHP
+// development packages, which provide phpize and php-config
+func (r *runner) installBuildTools(osFamily system.OSFamily, develPackages ...string) error {
+	toolchain := []string{"build-essential", "autoconf", "pkg-config"}
+	if osFamily == system.OSRHEL {
+		toolchain = []string{"gcc", "gcc-c++", "make", "autoconf", "pkgconf-pkg-config"}
+	} else if osFamily == system.OSSUSE {
+		toolchain = []string{"gcc", "gcc-c++", "make", "autoconf", "pkg-config"}
+	}
+	return r.installPackages(osFamily, append(toolchain, develPackages...)...)
+}
+
+// removePackages removes distribution packages with the native package tool
+func (r *runner) removePackages(osFamily system.OSFamily, packages ...string) error {
+	if osFamily == system.OSSUSE {
+		if err := r.runQuiet("zypper", append([]string{"--non-interactive", "remove"}, packages...)...); err != nil {
+			return fmt.Errorf("failed to remove %v: %w", packages, err)
+		}
+		return nil
+	}
+	if osFamily == system.OSRHEL {
+		pkgTool := "yum"
+		if r.hasCommand("dnf") {
+			pkgTool = "dnf"
+		}
+		if err := r.runQuiet(pkgTool, append([]string{"remove", "-y"}, packages...)...); err != nil {
+			return fmt.Errorf("failed to remove %v: %w", packages, err)
+		}
+		return nil
+	}
+
+	if err := r.runQuiet("apt-get", append([]string{"remove", "-y"}, packages...)...); err != nil {
+		return fmt.Errorf("failed to remove %v: %w", packages, err)
+	}
+	return nil
+}
+
+// upgradePackages upgrades the installed packages whose names match glob,
+// e.g. "php8.3-*", to the newest version of the configured repositories.
+// It installs nothing new.
+func (r *runner) upgradePackages(osFamily system.OSFamily, glob string) error {
+	var packages []string
+	if osFamily.UsesRPM() {
+		out, err := r.output(r.command("rpm", "-qa", "--qf", "%{NAME}\\n", glob))
+		if err != nil {
+			return fmt.Errorf("failed to list %s packages: %w", glob, err)
+		}
+		packages = strings.Fields(string(out))
+	} else {
+		out, err := r.output(r.command("dpkg-query", "-W", "-f=${Package} ${Status}\\n", glob))
+		if err != nil {
+			return fmt.Errorf("failed to list %s packages: %w", glob, err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && strings.HasSuffix(line, " installed") {
+				packages = append(packages, fields[0])
+			}
+		}
+	}
+	if len(packages) == 0 {
+		return fmt.Errorf("no installed packages match %s", glob)
+	}
+
+	switch {
+	case osFamily == system.OSSUSE:
+		err := r.runQuiet("zypper", append([]string{"--non-interactive", "update"}, packages...)...)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade %v: %w", packages, err)
+		}
+	case osFamily == system.OSRHEL:
+		pkgTool := "yum"
+		if r.hasCommand("dnf") {
+			pkgTool = "dnf"
+		}
+		if err := r.runQuiet(pkgTool, append([]string{"upgrade", "-y"}, packages...)...); err != nil {
+			return fmt.Errorf("failed to upgrade %v: %w", packages, err)
+		}
+	default:
+		if err := r.runQuiet("apt-get", "update"); err != nil {
+			return fmt.Errorf("failed to update package list: %w", err)
+		}
+		if err := r.runQuiet("apt-get", append([]string{"install", "--only-upgrade", "-y"}, packages...)...); err != nil {
+			return fmt.Errorf("failed to upgrade %v: %w", packages, err)
+		}
+	}
+	return nil
+}
+
+// restartUpgraded restarts a PHP-FPM service so it runs the upgraded binary
+func (r *runner) restartUpgraded(serviceName string) error {
+	if err := r.services().ReloadOrRestart(serviceName); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", serviceName, err)
+	}
+	return nil
+}
+
+// stopService stops and disables a PHP-FPM service before its packages go
+// away
+func (r *runner) stopService(serviceName string) {
+	r.services().Disable(serviceName)
+}
@@ -1,16 +1,22 @@
 package provider
 
 import (
+	"context"
 	"fmt"
+	"io"
 
 	"lightweight-php/db"
 	"lightweight-php/system"
+	"lightweight-php/target"
 )
 
 // ProviderFactory creates PHP providers based on type
 type ProviderFactory struct {
-	db       *db.Database
-	osFamily system.OSFamily
+	db         *db.Database
+	osFamily   system.OSFamily
+	target     target.Target
+	transcript io.Writer
+	ctx        context.Context
 }
 
 func NewProviderFactory() (*ProviderFactory, error) {
@@ -20,16 +26,81 @@ func NewProviderFactory() (*ProviderFactory, error) {
 	}
 
 	detector := system.NewOSDetector()
-	osFamily, _ := detector.Detect()
+	osFamily, err := detector.Detect()
+	if err != nil {
+		database.Close()
+		return nil, err
+	}
+
+	return NewProviderFactoryWithDeps(database, osFamily), nil
+}
 
+// NewProviderFactoryWithDeps creates a factory on an existing database handle
+func NewProviderFactoryWithDeps(database *db.Database, osFamily system.OSFamily) *ProviderFactory {
 	return &ProviderFactory{
 		db:       database,
 		osFamily: osFamily,
-	}, nil
+	}
+}
+
+// ForTarget returns a factory whose providers run inside t, with the OS
+// family detected from t's root filesystem
+func (f *ProviderFactory) ForTarget(t target.Target) (*ProviderFactory, error) {
+	if t == f.target {
+		return f, nil
+	}
+	c := *f
+	c.target = t
+	root, err := t.Root()
+	if err != nil {
+		return nil, err
+	}
+	if c.osFamily, err = system.NewOSDetectorAt(root).Detect(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// WithTranscript returns a factory whose providers copy every command they
+// run and its output to w
+func (f *ProviderFactory) WithTranscript(w io.Writer) *ProviderFactory {
+	c := *f
+	c.transcript = w
+	return &c
+}
+
+// WithContext returns a factory whose providers trace the commands they run
+// under the span in ctx
+func (f *ProviderFactory) WithContext(ctx context.Context) *ProviderFactory {
+	c := *f
+	c.ctx = ctx
+	return &c
+}
+
+// Target returns the execution target of the factory's providers
+func (f *ProviderFactory) Target() target.Target {
+	return f.target
 }
 
 // CreateProvider creates a PHP provider based on the provider type
 func (f *ProviderFactory) CreateProvider(providerType ProviderType) (PHPProvider, error) {
+	p, err := f.createProvider(providerType)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := p.(interface{ setTarget(target.Target) }); ok {
+		r.setTarget(f.target)
+	}
+	if r, ok := p.(interface{ setTranscript(io.Writer) }); ok && f.transcript != nil {
+		r.setTranscript(f.transcript)
+	}
+	if r, ok := p.(interface{ setContext(context.Context) }); ok && f.ctx != nil {
+		r.setContext(f.ctx)
+	}
+	return p, nil
+}
+
+func (f *ProviderFactory) createProvider(providerType ProviderType) (PHPProvider, error) {
 	switch providerType {
 	case ProviderRemi:
 		return NewRemiProvider(f.db, f.osFamily)
@@ -39,6 +110,8 @@ func (f *ProviderFactory) CreateProvider(providerType ProviderType) (PHPProvider
 		return NewAltPHPProvider(f.db, f.osFamily)
 	case ProviderDocker:
 		return NewDockerProvider(f.db, f.osFamily)
+	case ProviderSystem:
+		return NewSystemProvider(f.db, f.osFamily)
 	default:
 		return nil, fmt.Errorf("unknown provider type: %s", providerType)
 	}
@@ -0,0 +1,197 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Repository setup writes files and fetches keys in Go rather than through
+// sh -c, so nothing read from the target or the network is ever parsed by
+// a shell.
+
+// downloadTimeout bounds fetching a signing key
+const downloadTimeout = time.Minute
+
+// maxDownloadSize caps a downloaded key; real ones are a few kilobytes
+const maxDownloadSize = 1 << 20
+
+// codenamePattern is what a release codename may look like, so one read
+// from os-release cannot add lines to an apt source
+var codenamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+
+// checkCodename rejects codenames that are not a single plain word
+func checkCodename(codename string) error {
+	if codename == "" {
+		return fmt.Errorf("cannot tell the release codename from /etc/os-release")
+	}
+	if !codenamePattern.MatchString(codename) {
+		return fmt.Errorf("invalid release codename %q in /etc/os-release", codename)
+	}
+	return nil
+}
+
+// writeFile writes a file in the runner's target, creating its directory.
+// The path must be absolute and clean; inside a chroot or container no part
+// of it may be a symlink, since the host would resolve it against its own
+// root.
+func (r *runner) writeFile(path string, data []byte, perm os.FileMode) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("refusing to write %q: not a clean absolute path", path)
+	}
+	root, err := r.target.Root()
+	if err != nil {
+		return err
+	}
+	if root != "/" {
+		if err := checkNoSymlinks(root, path); err != nil {
+			return err
+		}
+	}
+	hostPath, err := r.target.Path(path)
+	if err != nil {
+		return err
+	}
+	if r.transcript != nil {
+		fmt.Fprintf(r.transcript, "# writing %s\n", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(hostPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(hostPath, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// checkNoSymlinks fails if any existing component of path under root is a
+// symlink. Components that do not exist yet are created by writeFile.
+func checkNoSymlinks(root, path string) error {
+	current := root
+	for _, part := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write %s: %s is a symlink", path, strings.TrimPrefix(current, root))
+		}
+	}
+	return nil
+}
+
+// download fetches a URL with the proxy from the environment
+func download(url string) ([]byte, error) {
+	client := &http.Client{Timeout: downloadTimeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// dearmor decodes an ASCII-armored OpenPGP key into the binary keyring
+// that apt reads from .gpg files, like gpg --dearmor. The block's checksum,
+// when present, must match, and the key must pass checkPublicKey.
+func dearmor(armored []byte) ([]byte, error) {
+	var body, checksum strings.Builder
+	inBlock, inHeaders := false, false
+	for _, line := range strings.Split(string(armored), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case !inBlock:
+			if line == "-----BEGIN PGP PUBLIC KEY BLOCK-----" {
+				inBlock, inHeaders = true, true
+			}
+		case strings.HasPrefix(line, "-----END PGP"):
+			if line != "-----END PGP PUBLIC KEY BLOCK-----" {
+				return nil, fmt.Errorf("invalid OpenPGP armor: mismatched end line")
+			}
+			data, err := base64.StdEncoding.DecodeString(body.String())
+			if err != nil || len(data) == 0 {
+				return nil, fmt.Errorf("invalid OpenPGP armor")
+			}
+			if checksum.Len() > 0 {
+				sum, err := base64.StdEncoding.DecodeString(checksum.String())
+				if err != nil || len(sum) != 3 || uint32(sum[0])<<16|uint32(sum[1])<<8|uint32(sum[2]) != crc24(data) {
+					return nil, fmt.Errorf("invalid OpenPGP armor: checksum mismatch")
+				}
+			}
+			if err := checkPublicKey(data); err != nil {
+				return nil, err
+			}
+			return data, nil
+		case inHeaders:
+			// Armor headers such as Comment: end at the first blank line
+			if line == "" {
+				inHeaders = false
+			} else if !strings.Contains(line, ":") {
+				inHeaders = false
+				body.WriteString(line)
+			}
+		case strings.HasPrefix(line, "="):
+			checksum.WriteString(line[1:])
+		default:
+			body.WriteString(line)
+		}
+	}
+	return nil, fmt.Errorf("no OpenPGP public key block found")
+}
+
+// checkPublicKey reports an error unless data starts with an OpenPGP
+// public key packet, so that whatever a keyserver or proxy returns in its
+// place is not installed as a keyring
+func checkPublicKey(data []byte) error {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return fmt.Errorf("not an OpenPGP public key")
+	}
+	tag := data[0] & 0x3f
+	if data[0]&0x40 == 0 {
+		// Old-format packet header
+		tag = (data[0] >> 2) & 0x0f
+	}
+	if tag != 6 {
+		return fmt.Errorf("not an OpenPGP public key")
+	}
+	return nil
+}
+
+// crc24 is the checksum of RFC 4880 ASCII armor
+func crc24(data []byte) uint32 {
+	crc := uint32(0xb704ce)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864cfb
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// isArmored reports whether data is an ASCII-armored key rather than a
+// binary one
+func isArmored(data []byte) bool {
+	return bytes.Contains(data, []byte("-----BEGIN PGP"))
+}
@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"encoding/base64"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"lightweight-php/target"
+)
+
+// testKey is an ed25519 public key exported with gpg --armor --export
+const testKey = `-----BEGIN PGP PUBLIC KEY BLOCK-----
+
+mDMEatCyehYJKwYBBAHaRw8BAQdAlGcjU3iEplbmjOnUVl0ldyF8QkElHO+3gy91
+opVc5rS0H1Rlc3QgS2V5IDx0ZXN0QGV4YW1wbGUuaW52YWxpZD6IkAQTFggAOBYh
+BH16320SJ9JSl7E1EASigcDeR+GKBQJq0LJ6AhsDBQsJCAcCBhUKCQgLAgQWAgMB
+Ah4BAheAAAoJEASigcDeR+GKg/cBAK4g+UG2OdbYnLcnm34qVo9PjZiaY9MCKlZn
++86b2aLtAQCYmYHuPnPESSnryzTK5swFVjHc4rInWGpNxRnkCCswBw==
+=td8m
+-----END PGP PUBLIC KEY BLOCK-----
+`
+
+func TestCheckCodename(t *testing.T) {
+	for _, codename := range []string{"jammy", "noble", "bookworm", "15.5", "el-9"} {
+		if err := checkCodename(codename); err != nil {
+			t.Errorf("checkCodename(%q) = %v, want nil", codename, err)
+		}
+	}
+
+	hostile := []string{
+		"",
+		"jammy main\ndeb http://evil.example/ jammy",
+		"jammy\n",
+		"jammy main",
+		"$(id)",
+		"`id`",
+		"jammy;id",
+		"../../etc",
+		"jammy/evil",
+		"-jammy",
+		".jammy",
+		"Jammy",
+		"jammy\x00",
+	}
+	for _, codename := range hostile {
+		if err := checkCodename(codename); err == nil {
+			t.Errorf("checkCodename(%q) = nil, want an error", codename)
+		}
+	}
+}
+
+func TestDearmor(t *testing.T) {
+	data, err := dearmor([]byte(testKey))
+	if err != nil {
+		t.Fatalf("dearmor: %v", err)
+	}
+	if data[0] != 0x98 {
+		t.Errorf("dearmor returned a packet starting with %#x, want a public key packet", data[0])
+	}
+
+	// Armor headers and surrounding text are allowed
+	withHeaders := strings.Replace(testKey, "\n\n", "\nComment: test\n\n", 1)
+	if _, err := dearmor([]byte("keyserver says:\n" + withHeaders + "trailer\n")); err != nil {
+		t.Errorf("dearmor with headers: %v", err)
+	}
+	// The checksum is optional
+	if _, err := dearmor([]byte(strings.Replace(testKey, "=td8m\n", "", 1))); err != nil {
+		t.Errorf("dearmor without checksum: %v", err)
+	}
+}
+
+func TestDearmorRejectsHostileInput(t *testing.T) {
+	notAKey := base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\nrm -rf /\n"))
+	hostile := map[string]string{
+		"empty":             "",
+		"html error page":   "<html><body>Service Unavailable</body></html>",
+		"no end line":       strings.Replace(testKey, "-----END PGP PUBLIC KEY BLOCK-----\n", "", 1),
+		"private key block": strings.ReplaceAll(testKey, "PUBLIC", "PRIVATE"),
+		"mismatched end":    strings.Replace(testKey, "END PGP PUBLIC KEY", "END PGP SIGNATURE", 1),
+		"begin inside text": strings.Replace(testKey, "-----BEGIN", "x -----BEGIN", 1),
+		"bad base64":        strings.Replace(testKey, "mDMEat", "mDM*at", 1),
+		"bad checksum":      strings.Replace(testKey, "=td8m", "=AAAA", 1),
+		"tampered body":     strings.Replace(testKey, "mDMEat", "mDMEau", 1),
+		"not a public key":  "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n" + notAKey + "\n-----END PGP PUBLIC KEY BLOCK-----\n",
+		"empty block":       "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n-----END PGP PUBLIC KEY BLOCK-----\n",
+		"header only block": "-----BEGIN PGP PUBLIC KEY BLOCK-----\nComment: x\n-----END PGP PUBLIC KEY BLOCK-----\n",
+		"binary in armor":   "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n\x00\x01\x02\n-----END PGP PUBLIC KEY BLOCK-----\n",
+		"signature not key": "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\n" + base64.StdEncoding.EncodeToString([]byte{0x88, 0x01, 0x00}) + "\n-----END PGP PUBLIC KEY BLOCK-----\n",
+		"shell in end line": strings.Replace(testKey, "-----END PGP PUBLIC KEY BLOCK-----", "-----END PGP PUBLIC KEY BLOCK-----; id", 1),
+	}
+	for name, input := range hostile {
+		if _, err := dearmor([]byte(input)); err == nil {
+			t.Errorf("%s: dearmor accepted it", name)
+		}
+	}
+}
+
+func TestCheckPublicKey(t *testing.T) {
+	// Old- and new-format public key packet headers
+	for _, data := range [][]byte{{0x98, 0x33}, {0x99, 0x00, 0x33}, {0xc6, 0x33}} {
+		if err := checkPublicKey(data); err != nil {
+			t.Errorf("checkPublicKey(%x) = %v, want nil", data, err)
+		}
+	}
+	for _, data := range [][]byte{nil, {0x98}, []byte("<html>"), {0x88, 0x01}, {0xc2, 0x01}, {0x95, 0x01}} {
+		if err := checkPublicKey(data); err == nil {
+			t.Errorf("checkPublicKey(%x) = nil, want an error", data)
+		}
+	}
+}
+
+// chrootRunner returns a runner whose target is a chroot in a temporary
+// directory, along with that directory
+func chrootRunner(t *testing.T) (*runner, string) {
+	t.Helper()
+	root := t.TempDir()
+	return &runner{target: target.Target{Kind: target.KindChroot, Name: root}}, root
+}
+
+func TestWriteFile(t *testing.T) {
+	r, root := chrootRunner(t)
+	if err := r.writeFile("/etc/apt/sources.list.d/ondrej-php.list", []byte("deb x\n"), 0644); err != nil {
+		t.Fatalf("writeFile: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "etc/apt/sources.list.d/ondrej-php.list"))
+	if err != nil || string(data) != "deb x\n" {
+		t.Fatalf("file in the chroot = %q, %v", data, err)
+	}
+}
+
+func TestWriteFileRejectsHostilePaths(t *testing.T) {
+	outside := t.TempDir()
+	for _, path := range []string{
+		"",
+		"etc/apt/x.list",
+		"../etc/x.list",
+		"/../../etc/x.list",
+		"/etc/apt/../../../x.list",
+		"/etc/apt/./x.list",
+		"/etc/apt/",
+		"//etc/x.list",
+	} {
+		r, root := chrootRunner(t)
+		if err := r.writeFile(path, []byte("x"), 0644); err == nil {
+			t.Errorf("writeFile(%q) = nil, want an error", path)
+		}
+		if entries, _ := os.ReadDir(root); len(entries) != 0 {
+			t.Errorf("writeFile(%q) created %s in the chroot", path, entries[0].Name())
+		}
+	}
+
+	// A symlink in the chroot would be followed on the host
+	r, root := chrootRunner(t)
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "etc/apt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.writeFile("/etc/apt/sources.list.d/x.list", []byte("x"), 0644); err == nil {
+		t.Error("writeFile through a symlinked directory = nil, want an error")
+	}
+
+	r, root = chrootRunner(t)
+	if err := os.MkdirAll(filepath.Join(root, "etc/apt/trusted.gpg.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(outside, "victim")
+	if err := os.WriteFile(victim, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(root, "etc/apt/trusted.gpg.d/ondrej-php.gpg")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.writeFile(ondrejKeyPath, []byte("x"), 0644); err == nil {
+		t.Error("writeFile onto a symlink = nil, want an error")
+	}
+
+	if entries, _ := os.ReadDir(outside); len(entries) != 1 {
+		t.Errorf("writeFile wrote outside the chroot: %d entries", len(entries))
+	}
+	if data, _ := os.ReadFile(victim); string(data) != "keep" {
+		t.Errorf("writeFile overwrote a file outside the chroot: %q", data)
+	}
+}
+
+// shells are the commands whose -c argument is parsed as a script
+var shells = map[string]bool{"sh": true, "bash": true, "dash": true, "zsh": true, "ash": true}
+
+// TestNoShellCommands checks that no command built in this package runs
+// through a shell: the first string argument of a call may not be a shell
+// when -c follows it.
+func TestNoShellCommands(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	checked := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checked++
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			var strs []string
+			for _, arg := range call.Args {
+				if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					if s, err := strconv.Unquote(lit.Value); err == nil {
+						strs = append(strs, s)
+					}
+				}
+			}
+			for i, s := range strs {
+				if shells[filepath.Base(s)] && containsDashC(strs[i+1:]) {
+					t.Errorf("%s: command runs through %s -c", fset.Position(call.Pos()), s)
+				}
+			}
+			return true
+		})
+	}
+	if checked == 0 {
+		t.Fatal("no source files checked")
+	}
+}
+
+func containsDashC(args []string) bool {
+	for _, arg := range args {
+		if arg == "-c" || (strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c")) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestContainsDashC(t *testing.T) {
+	if !containsDashC([]string{"-c", "id"}) || !containsDashC([]string{"-ec", "id"}) {
+		t.Error("containsDashC missed -c")
+	}
+	if containsDashC([]string{"--check"}) || containsDashC([]string{"script.sh"}) || containsDashC(nil) {
+		t.Error("containsDashC matched an argument that is not -c")
+	}
+}
@@ -0,0 +1,212 @@
+package provider
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/system"
+)
+
+// Statuses of a HealthCheck
+const (
+	HealthOK      = "ok"
+	HealthWarning = "warning"
+	HealthError   = "error"
+)
+
+// HealthCheck is the result of checking one part of a provider's package
+// repository: that its metadata can be fetched, that its signing key is
+// valid, or that the package manager's cache of it is recent
+type HealthCheck struct {
+	Check   string `json:"check"`
+	Subject string `json:"subject"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+// HealthChecker is implemented by providers that install from third-party
+// package repositories, so that a network policy blocking them is found
+// before an install waits for the package manager to time out
+type HealthChecker interface {
+	CheckHealth() []HealthCheck
+}
+
+// repoTimeout bounds each reachability check; package managers retry for
+// minutes before giving up
+const repoTimeout = 10 * time.Second
+
+// staleCacheAge is the age after which a repository's cached metadata is
+// reported as stale
+const staleCacheAge = 7 * 24 * time.Hour
+
+// keyExpiryWarning is how long before a signing key expires that it is
+// reported
+const keyExpiryWarning = 30 * 24 * time.Hour
+
+// checkURL fetches a repository URL the way the package manager would, with
+// the proxy from the environment. Any response short of a server error
+// shows the host is reachable; a missing metadata file is an error too, as
+// the package manager would fail on it.
+func checkURL(name, url string) HealthCheck {
+	c := HealthCheck{Check: "reachability", Subject: name}
+	client := &http.Client{Timeout: repoTimeout, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment}}
+	started := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		c.Status, c.Message = HealthError, fmt.Sprintf("%s is unreachable: %v", url, err)
+		c.Fix = "Allow outbound connections to " + urlHost(url) + " in the firewall, or set HTTPS_PROXY and HTTP_PROXY to a proxy that does"
+		return c
+	}
+	resp.Body.Close()
+	elapsed := time.Since(started).Round(time.Millisecond)
+	if resp.StatusCode >= 400 {
+		c.Status, c.Message = HealthError, fmt.Sprintf("%s answered %s", url, resp.Status)
+		if resp.StatusCode < 500 {
+			c.Fix = "The repository does not publish this release; check that the OS version is supported"
+		}
+		return c
+	}
+	c.Status, c.Message = HealthOK, fmt.Sprintf("%s answered %s in %s", url, resp.Status, elapsed)
+	return c
+}
+
+func urlHost(url string) string {
+	host := url[strings.Index(url, "://")+3:]
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
+
+// checkKeys checks the OpenPGP keys in the target's key files matching
+// pattern: at least one must be present, and neither revoked nor expired
+func (r *runner) checkKeys(name, pattern, fix string) HealthCheck {
+	c := HealthCheck{Check: "signing key", Subject: name}
+	files, err := r.glob(pattern)
+	if err != nil || len(files) == 0 {
+		c.Status, c.Message, c.Fix = HealthError, "no signing key at "+pattern, fix
+		return c
+	}
+	if !r.hasCommand("gpg") {
+		c.Status, c.Message = HealthWarning, fmt.Sprintf("%s is installed, but gpg is not available to check it", strings.Join(files, ", "))
+		return c
+	}
+
+	var problems []string
+	for _, file := range files {
+		output, err := r.output(r.command("gpg", "--show-keys", "--with-colons", file))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s cannot be read: %v", file, err))
+			continue
+		}
+		status, message := keyStatus(string(output))
+		if status == HealthOK {
+			c.Status, c.Message = HealthOK, file+": "+message
+			continue
+		}
+		problems = append(problems, file+": "+message)
+		if c.Status != HealthOK && status == HealthWarning {
+			c.Status, c.Message = HealthWarning, file+": "+message
+		}
+	}
+	// One valid key is enough, as repositories keep older keys around
+	if c.Status == "" {
+		c.Status, c.Message, c.Fix = HealthError, strings.Join(problems, "; "), fix
+	}
+	if c.Status == HealthWarning {
+		c.Fix = fix
+	}
+	return c
+}
+
+// keyStatus reads the primary keys of gpg --with-colons output
+func keyStatus(output string) (string, string) {
+	status, message := HealthError, "contains no public key"
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || fields[0] != "pub" {
+			continue
+		}
+		var expires time.Time
+		if seconds, err := strconv.ParseInt(fields[6], 10, 64); err == nil {
+			expires = time.Unix(seconds, 0)
+		}
+		switch {
+		case fields[1] == "r":
+			message = "key " + fields[4] + " is revoked"
+		case fields[1] == "e" || (!expires.IsZero() && expires.Before(time.Now())):
+			message = fmt.Sprintf("key %s expired on %s", fields[4], expires.Format("2006-01-02"))
+		case !expires.IsZero() && time.Until(expires) < keyExpiryWarning:
+			status, message = HealthWarning, fmt.Sprintf("key %s expires on %s", fields[4], expires.Format("2006-01-02"))
+		case expires.IsZero():
+			return HealthOK, "key " + fields[4] + " is valid and does not expire"
+		default:
+			return HealthOK, fmt.Sprintf("key %s is valid until %s", fields[4], expires.Format("2006-01-02"))
+		}
+	}
+	return status, message
+}
+
+// checkCache reports the age of the newest cached metadata matching
+// pattern. A missing cache is an error for apt, which cannot install
+// without it, and a warning for dnf, which downloads it on demand.
+func (r *runner) checkCache(name, pattern string, required bool, fix string) HealthCheck {
+	c := HealthCheck{Check: "cache", Subject: name}
+	files, _ := r.glob(pattern)
+	var newest time.Time
+	for _, file := range files {
+		hostPath, err := r.target.Path(file)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(hostPath); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	switch {
+	case newest.IsZero() && required:
+		c.Status, c.Message, c.Fix = HealthError, "no cached metadata at "+pattern, fix
+	case newest.IsZero():
+		c.Status, c.Message = HealthWarning, "no cached metadata yet; it is downloaded on the next install"
+	case time.Since(newest) > staleCacheAge:
+		c.Status, c.Message, c.Fix = HealthWarning, fmt.Sprintf("metadata was last refreshed %s ago", time.Since(newest).Round(time.Hour)), fix
+	default:
+		c.Status, c.Message = HealthOK, fmt.Sprintf("metadata was refreshed %s ago", time.Since(newest).Round(time.Minute))
+	}
+	return c
+}
+
+// glob returns the paths inside the target that match pattern
+func (r *runner) glob(pattern string) ([]string, error) {
+	root, err := r.target.Path("/")
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(root, pattern))
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matches {
+		matches[i] = "/" + strings.TrimPrefix(strings.TrimPrefix(m, root), "/")
+	}
+	return matches, nil
+}
+
+// osInfo returns the target's os-release, empty when it cannot be read
+func (r *runner) osInfo() *system.OSInfo {
+	root, err := r.target.Root()
+	if err != nil {
+		return &system.OSInfo{}
+	}
+	info, err := system.NewOSDetectorAt(root).Info()
+	if err != nil {
+		return &system.OSInfo{}
+	}
+	return info
+}
@@ -4,6 +4,9 @@ package provider
 type PHPProvider interface {
 	// InstallPHP installs a PHP version
 	InstallPHP(version string) error
+
+	// UninstallPHP stops a PHP version's FPM service and removes its packages
+	UninstallPHP(version string) error
 	
 	// ListInstalledPHP returns list of installed PHP versions
 	ListInstalledPHP() ([]string, error)
@@ -22,6 +25,24 @@ type PHPProvider interface {
 	
 	// GetConfigPath returns the pool configuration file path
 	GetConfigPath(username, version string) string
+
+	// GetConfDir returns the directory scanned for additional .ini files
+	GetConfDir(version string) string
+
+	// GetFPMConfigPath returns the FPM master config (php-fpm.conf) of a version, or "" if the version has no FPM master
+	GetFPMConfigPath(version string) string
+
+	// GetBinaryPath returns the PHP CLI binary of a version, or "" if there is none on the host
+	GetBinaryPath(version string) string
+
+	// GetFPMBinaryPath returns the php-fpm binary of a version, used to test its configuration, or "" if there is none on the host
+	GetFPMBinaryPath(version string) string
+
+	// InstallExtension installs a PECL extension such as "apcu" for a PHP version
+	InstallExtension(version, extension string) error
+
+	// InstallBuildTools installs a compiler and the phpize/php-config package of a version, for building PECL extensions from source
+	InstallBuildTools(version string) error
 }
 
 // ProviderType represents different PHP provider types
@@ -32,4 +53,5 @@ const (
 	ProviderLiteSpeed ProviderType = "lsphp"
 	ProviderAltPHP  ProviderType = "alt-php"
 	ProviderDocker  ProviderType = "docker"
+	ProviderSystem  ProviderType = "system"
 )
@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"lightweight-php/servicemgr"
+	"lightweight-php/system"
+)
+
+// Change is a step of an install that changed the system, with how to undo
+// it
+type Change struct {
+	Description string
+	undo        func() error
+}
+
+// UndoResult is what a rollback did with one change
+type UndoResult struct {
+	Change string `json:"change"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Transactional is implemented by providers that journal the changes their
+// installs make, so that a failed install can be undone. Every provider
+// embedding runner is; steps a provider does not journal are not undone.
+type Transactional interface {
+	// Journal returns the changes made since the provider was created,
+	// oldest first
+	Journal() []Change
+	// Rollback undoes the journaled changes, newest first, and clears the
+	// journal
+	Rollback() []UndoResult
+}
+
+// InstallChecker is implemented by providers that can tell whether an
+// installed version works
+type InstallChecker interface {
+	CheckInstall(version string) error
+}
+
+// Upgrader is implemented by providers that can upgrade an installed
+// version to the newest release of its branch, e.g. 8.3.10 to 8.3.12
+type Upgrader interface {
+	UpgradePHP(version string) error
+}
+
+// RepositoryChecker is implemented by providers that install from a
+// third-party package repository and can tell whether it is still set up
+type RepositoryChecker interface {
+	CheckRepository() error
+}
+
+// record journals a change made by the provider
+func (r *runner) record(description string, undo func() error) {
+	r.journal = append(r.journal, Change{Description: description, undo: undo})
+}
+
+func (r *runner) Journal() []Change {
+	return append([]Change(nil), r.journal...)
+}
+
+func (r *runner) Rollback() []UndoResult {
+	results := make([]UndoResult, 0, len(r.journal))
+	for i := len(r.journal) - 1; i >= 0; i-- {
+		c := r.journal[i]
+		result := UndoResult{Change: c.Description}
+		if err := c.undo(); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	r.journal = nil
+	return results
+}
+
+// packageInstalled reports whether a distribution package is installed
+func (r *runner) packageInstalled(osFamily system.OSFamily, name string) bool {
+	if osFamily.UsesRPM() {
+		return r.run(r.command("rpm", "-q", name)) == nil
+	}
+	out, err := r.output(r.command("dpkg-query", "-W", "-f=${Status}", name))
+	return err == nil && strings.HasSuffix(strings.TrimSpace(string(out)), " installed")
+}
+
+// missingPackages returns the packages that are not installed yet; call it
+// before an install step and pass the result to journalPackages after it
+func (r *runner) missingPackages(osFamily system.OSFamily, packages ...string) []string {
+	var missing []string
+	for _, name := range packages {
+		if !r.packageInstalled(osFamily, name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// journalPackages records which of the packages missing before an install
+// step are installed now. It is called whether or not the step succeeded,
+// since apt can leave part of a failed run installed.
+func (r *runner) journalPackages(osFamily system.OSFamily, missing []string) {
+	var installed []string
+	for _, name := range missing {
+		if r.packageInstalled(osFamily, name) {
+			installed = append(installed, name)
+		}
+	}
+	if len(installed) == 0 {
+		return
+	}
+	r.record("installed packages "+strings.Join(installed, ", "), func() error {
+		return r.removePackages(osFamily, installed...)
+	})
+}
+
+// enableService enables a service, journaling it when it was not enabled
+// before
+func (r *runner) enableService(serviceName string) {
+	services := r.services()
+	wasEnabled := services.Enabled(serviceName)
+	if services.Enable(serviceName) == nil && !wasEnabled {
+		r.record("enabled service "+serviceName, func() error {
+			return services.Disable(serviceName)
+		})
+	}
+}
+
+// checkService returns an error unless a service is running. Without a
+// service manager there is nothing to check.
+func (r *runner) checkService(serviceName string) error {
+	status, err := r.services().Status(serviceName)
+	if err != nil {
+		return fmt.Errorf("failed to check service %s: %w", serviceName, err)
+	}
+	if status != servicemgr.StatusActive && status != servicemgr.StatusUnknown {
+		return fmt.Errorf("service %s is %s after the install", serviceName, status)
+	}
+	return nil
+}
+
+// checkBinary returns an error unless a PHP binary runs
+func (r *runner) checkBinary(path string) error {
+	if err := r.runQuiet(path, "-v"); err != nil {
+		return fmt.Errorf("%s does not run after the install: %w", path, err)
+	}
+	return nil
+}
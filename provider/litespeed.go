@@ -1,17 +1,21 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"lightweight-php/db"
 	"lightweight-php/system"
+	"lightweight-php/validation"
 )
 
 // LiteSpeedProvider implements PHPProvider for LiteSpeed PHP (lsphp)
 type LiteSpeedProvider struct {
+	runner
 	db       *db.Database
 	osFamily system.OSFamily
 }
@@ -43,21 +47,65 @@ func (p *LiteSpeedProvider) GetConfigPath(username, version string) string {
 	return filepath.Join("/usr/local/lsws/conf", fmt.Sprintf("%s-%s.conf", username, version))
 }
 
+func (p *LiteSpeedProvider) GetFPMConfigPath(version string) string {
+	// lsphp processes are spawned by the web server, there is no FPM master
+	return ""
+}
+
+func (p *LiteSpeedProvider) GetConfDir(version string) string {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	if p.osFamily == system.OSRHEL {
+		return filepath.Join("/usr/local/lsws", fmt.Sprintf("lsphp%s", versionNum), "etc/php.d")
+	}
+	return filepath.Join("/usr/local/lsws", fmt.Sprintf("lsphp%s", versionNum), "etc/php", version, "mods-available")
+}
+
+func (p *LiteSpeedProvider) GetBinaryPath(version string) string {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return filepath.Join("/usr/local/lsws", fmt.Sprintf("lsphp%s", versionNum), "bin/php")
+}
+
+func (p *LiteSpeedProvider) GetFPMBinaryPath(version string) string {
+	// lsphp processes are spawned by the web server, there is no FPM master
+	return ""
+}
+
+func (p *LiteSpeedProvider) InstallExtension(version, extension string) error {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	if p.osFamily == system.OSRHEL {
+		return p.installPackages(p.osFamily, fmt.Sprintf("lsphp%s-pecl-%s", versionNum, extension))
+	}
+	return p.installPackages(p.osFamily, fmt.Sprintf("lsphp%s-%s", versionNum, extension))
+}
+
+func (p *LiteSpeedProvider) InstallBuildTools(version string) error {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	if p.osFamily == system.OSRHEL {
+		return p.installBuildTools(p.osFamily, fmt.Sprintf("lsphp%s-devel", versionNum))
+	}
+	return p.installBuildTools(p.osFamily, fmt.Sprintf("lsphp%s-dev", versionNum))
+}
+
 func (p *LiteSpeedProvider) InstallPHP(version string) error {
 	// Validate minimum PHP version (7.4)
-	parts := strings.Split(version, ".")
-	if len(parts) < 2 {
-		return fmt.Errorf("invalid PHP version format: %s", version)
+	supported, err := validation.PHPVersionAtLeast(version, "7.4")
+	if err != nil {
+		return err
 	}
-	
-	major, minor := parts[0], parts[1]
-	if major < "7" || (major == "7" && minor < "4") {
+	if !supported {
 		return fmt.Errorf("PHP version %s is not supported. Minimum version is 7.4", version)
 	}
+	if p.osFamily == system.OSSUSE {
+		return errLiteSpeedSUSE
+	}
 
 	versionNum := strings.ReplaceAll(version, ".", "")
 
 	if p.osFamily == system.OSRHEL {
+		// The LiteSpeed repository has aarch64 packages from EL 8 on
+		if err := p.requireELForArch("the LiteSpeed repository", version, 8); err != nil {
+			return err
+		}
 		return p.installLSPHPRHEL(version, versionNum)
 	} else {
 		return p.installLSPHPDebian(version, versionNum)
@@ -65,23 +113,32 @@ func (p *LiteSpeedProvider) InstallPHP(version string) error {
 }
 
 func (p *LiteSpeedProvider) installLSPHPRHEL(version, versionNum string) error {
+	repoPhase := p.startPhase("php.repo_check")
+	if err := p.ensureLiteSpeedRepo(); err != nil {
+		return repoPhase.end(fmt.Errorf("failed to setup LiteSpeed repository: %w", err))
+	}
+	repoPhase.end(nil)
+
 	// LiteSpeed PHP packages for RHEL are typically: lsphp82, lsphp82-common, lsphp82-process, etc.
 	packages := []string{
 		fmt.Sprintf("lsphp%s", versionNum),
 		fmt.Sprintf("lsphp%s-common", versionNum),
 		fmt.Sprintf("lsphp%s-process", versionNum),
 	}
+	missing := p.missingPackages(p.osFamily, packages...)
 
 	var installCmd *exec.Cmd
 	if p.hasCommand("dnf") {
-		installCmd = exec.Command("dnf", append([]string{"install", "-y"}, packages...)...)
+		installCmd = p.command("dnf", append([]string{"install", "-y"}, packages...)...)
 	} else {
-		installCmd = exec.Command("yum", append([]string{"install", "-y"}, packages...)...)
+		installCmd = p.command("yum", append([]string{"install", "-y"}, packages...)...)
 	}
 
 	installCmd.Stdout = nil
 	installCmd.Stderr = nil
-	if err := installCmd.Run(); err != nil {
+	err := p.run(installCmd)
+	p.journalPackages(p.osFamily, missing)
+	if err != nil {
 		return fmt.Errorf("failed to install LiteSpeed PHP packages: %w", err)
 	}
 
@@ -101,17 +158,26 @@ func (p *LiteSpeedProvider) installLSPHPDebian(version, versionNum string) error
 		fmt.Sprintf("lsphp%s-process", versionNum),
 	}
 
+	repoPhase := p.startPhase("php.repo_check")
+	if err := p.ensureLiteSpeedRepo(); err != nil {
+		return repoPhase.end(fmt.Errorf("failed to setup LiteSpeed repository: %w", err))
+	}
+
 	// Update package list
-	updateCmd := exec.Command("apt-get", "update")
+	updateCmd := p.command("apt-get", "update")
 	updateCmd.Stdout = nil
 	updateCmd.Stderr = nil
-	updateCmd.Run()
+	p.run(updateCmd)
+	repoPhase.end(nil)
 
-	installCmd := exec.Command("apt-get", "install", "-y")
+	missing := p.missingPackages(p.osFamily, packages...)
+	installCmd := p.command("apt-get", "install", "-y")
 	installCmd.Args = append(installCmd.Args, packages...)
 	installCmd.Stdout = nil
 	installCmd.Stderr = nil
-	if err := installCmd.Run(); err != nil {
+	err := p.run(installCmd)
+	p.journalPackages(p.osFamily, missing)
+	if err != nil {
 		return fmt.Errorf("failed to install LiteSpeed PHP packages: %w", err)
 	}
 
@@ -123,7 +189,161 @@ func (p *LiteSpeedProvider) installLSPHPDebian(version, versionNum string) error
 	return nil
 }
 
+const (
+	// liteSpeedKeyPath is where the LiteSpeed apt signing key is installed
+	liteSpeedKeyPath = "/etc/apt/trusted.gpg.d/lst_debian_repo.gpg"
+	// liteSpeedSourcePath is the apt source of the LiteSpeed repository
+	liteSpeedSourcePath = "/etc/apt/sources.list.d/lst_debian_repo.list"
+)
+
+// ensureLiteSpeedRepo sets up the LiteSpeed repository when it is missing:
+// the litespeed-repo package on RHEL, which also installs the signing key,
+// or an apt source and key on Debian. Existing setups are left alone.
+func (p *LiteSpeedProvider) ensureLiteSpeedRepo() error {
+	if p.osFamily == system.OSRHEL {
+		if p.run(p.command("rpm", "-q", "litespeed-repo")) == nil {
+			return nil // Already installed
+		}
+		major := p.osInfo().Major()
+		switch major {
+		case 7, 8, 9:
+		default:
+			// Newer releases use the latest repository package
+			major = 9
+		}
+		repoURL := fmt.Sprintf("http://rpms.litespeedtech.com/centos/litespeed-repo-1.3-1.el%d.noarch.rpm", major)
+		tool := "yum"
+		if p.hasCommand("dnf") {
+			tool = "dnf"
+		}
+		repoCmd := p.command(tool, "install", "-y", repoURL)
+		repoCmd.Stdout = nil
+		repoCmd.Stderr = nil
+		err := p.run(repoCmd)
+		p.journalPackages(p.osFamily, []string{"litespeed-repo"})
+		if err != nil {
+			return fmt.Errorf("failed to install litespeed-repo: %w", err)
+		}
+		return nil
+	}
+
+	if !p.hasLiteSpeedRepo() {
+		codename := p.osInfo().Codename
+		if err := checkCodename(codename); err != nil {
+			return err
+		}
+		arch, err := p.arch()
+		if err != nil {
+			return err
+		}
+		// Pinned to the target's architecture, so apt does not look for
+		// the others
+		source := fmt.Sprintf("deb [arch=%s] http://rpms.litespeedtech.com/debian/ %s main", arch.Debian(), codename)
+		if err := p.writeFile(liteSpeedSourcePath, []byte(source+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to add the apt source: %w", err)
+		}
+		p.record("added the LiteSpeed repository", func() error {
+			if err := p.runQuiet("rm", "-f", liteSpeedSourcePath); err != nil {
+				return err
+			}
+			return p.runQuiet("apt-get", "update")
+		})
+	}
+	if p.run(p.command("test", "-s", liteSpeedKeyPath)) != nil {
+		if err := p.runQuiet("curl", "-fsSL", "-o", liteSpeedKeyPath, "http://rpms.litespeedtech.com/debian/lst_debian_repo.gpg"); err != nil {
+			p.run(p.command("rm", "-f", liteSpeedKeyPath))
+			return fmt.Errorf("failed to fetch the signing key: %w", err)
+		}
+		p.record("added the LiteSpeed signing key", func() error {
+			return p.runQuiet("rm", "-f", liteSpeedKeyPath)
+		})
+	}
+	return nil
+}
+
+// hasLiteSpeedRepo reports whether an apt source for the LiteSpeed
+// repository exists
+func (p *LiteSpeedProvider) hasLiteSpeedRepo() bool {
+	return p.run(p.command("grep", "-rqs", "rpms.litespeedtech.com/debian", "/etc/apt/sources.list", "/etc/apt/sources.list.d")) == nil
+}
+
+// errLiteSpeedSUSE is returned for lsphp on SUSE, which the LiteSpeed
+// repository does not publish packages for
+var errLiteSpeedSUSE = errors.New("the LiteSpeed repository has no lsphp packages for SUSE; use the remi or system provider")
+
+// CheckRepository verifies that the litespeed-repo package is installed,
+// or on Debian that an apt source for the LiteSpeed repository exists
+func (p *LiteSpeedProvider) CheckRepository() error {
+	if p.osFamily == system.OSSUSE {
+		return errLiteSpeedSUSE
+	}
+	if p.osFamily != system.OSRHEL {
+		if !p.hasLiteSpeedRepo() {
+			return fmt.Errorf("no apt source for rpms.litespeedtech.com in /etc/apt")
+		}
+		return nil
+	}
+	if p.run(p.command("rpm", "-q", "litespeed-repo")) != nil {
+		return fmt.Errorf("the litespeed-repo package is not installed")
+	}
+	return nil
+}
+
+// CheckInstall verifies that the lsphp binary runs; lsphp has no FPM
+// service of its own to check
+func (p *LiteSpeedProvider) CheckInstall(version string) error {
+	return p.checkBinary(p.GetBinaryPath(version))
+}
+
+// CheckHealth checks that the LiteSpeed repository can be reached, that
+// its signing key is valid and that its cached metadata is recent
+func (p *LiteSpeedProvider) CheckHealth() []HealthCheck {
+	const fix = "Install a PHP version with the lsphp provider again to set up the repository (POST /api/v1/providers/lsphp/install/VERSION)"
+	if p.osFamily == system.OSSUSE {
+		// Nothing is installed from it
+		return []HealthCheck{}
+	}
+	if p.osFamily != system.OSRHEL {
+		return []HealthCheck{
+			checkURL("litespeed", "http://rpms.litespeedtech.com/debian/dists/"+p.osInfo().Codename+"/Release"),
+			p.checkKeys("litespeed", "/etc/apt/trusted.gpg.d/lst_*.gpg", fix),
+			p.checkCache("litespeed", "/var/lib/apt/lists/rpms.litespeedtech.com_*", true, "Run 'apt-get update'"),
+		}
+	}
+
+	major := strconv.Itoa(p.osInfo().Major())
+	if major == "0" {
+		major = "9"
+	}
+	cache, makecache := "/var/cache/dnf/litespeed-*/repodata/repomd.xml", "Run 'dnf makecache'"
+	if !p.hasCommand("dnf") {
+		cache, makecache = "/var/cache/yum/*/*/litespeed*/repomd.xml", "Run 'yum makecache'"
+	}
+	return []HealthCheck{
+		checkURL("litespeed", "http://rpms.litespeedtech.com/centos/"+major+"/"+p.rpmArch()+"/repodata/repomd.xml"),
+		p.checkKeys("litespeed", "/etc/pki/rpm-gpg/RPM-GPG-KEY-litespeed*", fix),
+		p.checkCache("litespeed", cache, false, makecache),
+	}
+}
+
+func (p *LiteSpeedProvider) UninstallPHP(version string) error {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	return p.removePackages(p.osFamily,
+		fmt.Sprintf("lsphp%s", versionNum),
+		fmt.Sprintf("lsphp%s-common", versionNum),
+		fmt.Sprintf("lsphp%s-process", versionNum),
+	)
+}
+
+// ListInstalledPHP returns the installed versions, cached for
+// InstalledCacheTTL
 func (p *LiteSpeedProvider) ListInstalledPHP() ([]string, error) {
+	return cachedInstalled(p.installedKey(ProviderLiteSpeed), p.detectInstalled)
+}
+
+// detectInstalled reads the versions recorded in the database, or asks
+// the package manager when none are
+func (p *LiteSpeedProvider) detectInstalled() ([]string, error) {
 	// Try to get from database first
 	dbVersions, err := p.db.ListPHPVersions()
 	if err == nil && dbVersions != nil && len(dbVersions) > 0 {
@@ -144,11 +364,11 @@ func (p *LiteSpeedProvider) ListInstalledPHP() ([]string, error) {
 	if p.osFamily == system.OSRHEL {
 		var cmd *exec.Cmd
 		if p.hasCommand("dnf") {
-			cmd = exec.Command("rpm", "-qa", "--queryformat", "%{NAME}\n")
+			cmd = p.command("rpm", "-qa", "--queryformat", "%{NAME}\n")
 		} else {
-			cmd = exec.Command("rpm", "-qa", "--queryformat", "%{NAME}\n")
+			cmd = p.command("rpm", "-qa", "--queryformat", "%{NAME}\n")
 		}
-		output, err := cmd.Output()
+		output, err := p.output(cmd)
 		if err == nil {
 			lines := strings.Split(string(output), "\n")
 			for _, line := range lines {
@@ -170,8 +390,8 @@ func (p *LiteSpeedProvider) ListInstalledPHP() ([]string, error) {
 		}
 	} else {
 		// Check for installed lsphp packages via dpkg
-		cmd := exec.Command("dpkg", "-l")
-		output, err := cmd.Output()
+		cmd := p.command("dpkg", "-l")
+		output, err := p.output(cmd)
 		if err == nil {
 			lines := strings.Split(string(output), "\n")
 			for _, line := range lines {
@@ -212,8 +432,3 @@ func (p *LiteSpeedProvider) ListAvailablePHP() ([]string, error) {
 	}
 	return versions, nil
 }
-
-func (p *LiteSpeedProvider) hasCommand(cmd string) bool {
-	_, err := exec.LookPath(cmd)
-	return err == nil
-}
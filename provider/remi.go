@@ -5,14 +5,18 @@ import (
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"lightweight-php/db"
 	"lightweight-php/system"
+	"lightweight-php/validation"
 )
 
-// RemiProvider implements PHPProvider for Remi repository (RHEL) and ondrej PPA (Debian)
+// RemiProvider implements PHPProvider for Remi repository (RHEL), ondrej PPA (Debian)
+// and the devel:languages:php repository (SUSE)
 type RemiProvider struct {
+	runner
 	db       *db.Database
 	osFamily system.OSFamily
 }
@@ -29,6 +33,9 @@ func (p *RemiProvider) GetProviderType() string {
 }
 
 func (p *RemiProvider) GetServiceName(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseServiceName()
+	}
 	versionNum := strings.ReplaceAll(version, ".", "")
 	if p.osFamily == system.OSRHEL {
 		return fmt.Sprintf("php%s-php-fpm", versionNum)
@@ -37,6 +44,9 @@ func (p *RemiProvider) GetServiceName(version string) string {
 }
 
 func (p *RemiProvider) GetSocketPath(username, version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseSocketPath(username)
+	}
 	if p.osFamily == system.OSRHEL {
 		versionNum := strings.ReplaceAll(version, ".", "")
 		return fmt.Sprintf("/var/opt/remi/php%s/run/php-fpm/%s.sock", versionNum, username)
@@ -45,6 +55,9 @@ func (p *RemiProvider) GetSocketPath(username, version string) string {
 }
 
 func (p *RemiProvider) GetConfigPath(username, version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseConfigPath(username, version)
+	}
 	if p.osFamily == system.OSRHEL {
 		versionNum := strings.ReplaceAll(version, ".", "")
 		return filepath.Join("/etc/opt/remi", fmt.Sprintf("php%s", versionNum), "php-fpm.d", fmt.Sprintf("%s.conf", username))
@@ -52,31 +65,130 @@ func (p *RemiProvider) GetConfigPath(username, version string) string {
 	return filepath.Join("/etc/php", version, "fpm/pool.d", fmt.Sprintf("%s.conf", username))
 }
 
+func (p *RemiProvider) GetFPMConfigPath(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseFPMConfigPath(version)
+	}
+	if p.osFamily == system.OSRHEL {
+		versionNum := strings.ReplaceAll(version, ".", "")
+		return filepath.Join("/etc/opt/remi", fmt.Sprintf("php%s", versionNum), "php-fpm.conf")
+	}
+	return filepath.Join("/etc/php", version, "fpm/php-fpm.conf")
+}
+
+func (p *RemiProvider) GetConfDir(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseConfDir(version)
+	}
+	if p.osFamily == system.OSRHEL {
+		versionNum := strings.ReplaceAll(version, ".", "")
+		return filepath.Join("/etc/opt/remi", fmt.Sprintf("php%s", versionNum), "php.d")
+	}
+	return filepath.Join("/etc/php", version, "fpm/conf.d")
+}
+
+func (p *RemiProvider) GetBinaryPath(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseBinaryPath(version)
+	}
+	if p.osFamily == system.OSRHEL {
+		versionNum := strings.ReplaceAll(version, ".", "")
+		return filepath.Join("/opt/remi", fmt.Sprintf("php%s", versionNum), "root/usr/bin/php")
+	}
+	return fmt.Sprintf("/usr/bin/php%s", version)
+}
+
+func (p *RemiProvider) GetFPMBinaryPath(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseFPMBinaryPath()
+	}
+	if p.osFamily == system.OSRHEL {
+		versionNum := strings.ReplaceAll(version, ".", "")
+		return filepath.Join("/opt/remi", fmt.Sprintf("php%s", versionNum), "root/usr/sbin/php-fpm")
+	}
+	return fmt.Sprintf("/usr/sbin/php-fpm%s", version)
+}
+
+func (p *RemiProvider) InstallExtension(version, extension string) error {
+	if p.osFamily == system.OSSUSE {
+		return p.installPackages(p.osFamily, fmt.Sprintf("%s-%s", susePackage(version), extension))
+	}
+	if p.osFamily == system.OSRHEL {
+		versionNum := strings.ReplaceAll(version, ".", "")
+		return p.installPackages(p.osFamily, fmt.Sprintf("php%s-php-pecl-%s", versionNum, extension))
+	}
+	return p.installPackages(p.osFamily, fmt.Sprintf("php%s-%s", version, extension))
+}
+
+func (p *RemiProvider) InstallBuildTools(version string) error {
+	if p.osFamily == system.OSSUSE {
+		return p.installBuildTools(p.osFamily, susePackage(version)+"-devel")
+	}
+	if p.osFamily == system.OSRHEL {
+		versionNum := strings.ReplaceAll(version, ".", "")
+		return p.installBuildTools(p.osFamily, fmt.Sprintf("php%s-php-devel", versionNum))
+	}
+	return p.installBuildTools(p.osFamily, fmt.Sprintf("php%s-dev", version))
+}
+
 func (p *RemiProvider) InstallPHP(version string) error {
 	// Validate minimum PHP version (7.4)
-	parts := strings.Split(version, ".")
-	if len(parts) < 2 {
-		return fmt.Errorf("invalid PHP version format: %s", version)
+	supported, err := validation.PHPVersionAtLeast(version, "7.4")
+	if err != nil {
+		return err
 	}
-	
-	major, minor := parts[0], parts[1]
-	if major < "7" || (major == "7" && minor < "4") {
+	if !supported {
 		return fmt.Errorf("PHP version %s is not supported. Minimum version is 7.4", version)
 	}
 
 	versionNum := strings.ReplaceAll(version, ".", "")
 
 	if p.osFamily == system.OSRHEL {
+		// Remi builds aarch64 packages from EL 8 on; ondrej/php builds arm64
+		// for every release
+		if err := p.requireELForArch("Remi", version, 8); err != nil {
+			return err
+		}
 		return p.installPHPRHEL(version, versionNum)
+	} else if p.osFamily == system.OSSUSE {
+		return p.installPHPSUSEWithRepo(version)
 	} else {
 		return p.installPHPDebian(version, versionNum)
 	}
 }
 
+// installPHPSUSEWithRepo adds the devel:languages:php repository and
+// installs the version from it
+func (p *RemiProvider) installPHPSUSEWithRepo(version string) error {
+	repoPhase := p.startPhase("php.repo_check")
+	if err := p.ensureSUSERepo(); err != nil {
+		return repoPhase.end(err)
+	}
+	repoPhase.end(nil)
+
+	if err := p.installPHPSUSE(version); err != nil {
+		return err
+	}
+	if err := p.startService(version); err != nil {
+		return err
+	}
+	if err := p.db.CreatePHPVersion(version, "remi", string(system.OSSUSE)); err != nil {
+		fmt.Printf("Warning: failed to save PHP version to database: %v\n", err)
+	}
+	return nil
+}
+
 func (p *RemiProvider) installPHPRHEL(version, versionNum string) error {
+	repoPhase := p.startPhase("php.repo_check")
+
 	// Check if Remi repository is installed
 	if err := p.ensureRemiRepo(); err != nil {
-		return fmt.Errorf("failed to setup Remi repository: %w", err)
+		return repoPhase.end(fmt.Errorf("failed to setup Remi repository: %w", err))
+	}
+
+	// Sort out dnf module streams on RHEL 8+ before resolving packages
+	if err := p.ensureModuleStream(version); err != nil {
+		return repoPhase.end(fmt.Errorf("failed to prepare php module stream: %w", err))
 	}
 
 	// Try to enable Remi repository for the specific PHP version (non-fatal if it fails)
@@ -85,40 +197,52 @@ func (p *RemiProvider) installPHPRHEL(version, versionNum string) error {
 	// Check if repository exists before trying to enable it
 	var repoExists bool
 	if p.hasCommand("dnf") {
-		checkCmd := exec.Command("dnf", "repolist", "--all", "--quiet")
-		output, err := checkCmd.Output()
+		checkCmd := p.command("dnf", "repolist", "--all", "--quiet")
+		output, err := p.output(checkCmd)
 		if err == nil {
 			repoExists = strings.Contains(string(output), repoName)
 		}
 	} else {
-		checkCmd := exec.Command("yum", "repolist", "all", "-q")
-		output, err := checkCmd.Output()
+		checkCmd := p.command("yum", "repolist", "all", "-q")
+		output, err := p.output(checkCmd)
 		if err == nil {
 			repoExists = strings.Contains(string(output), repoName)
 		}
 	}
 	
 	// Only try to enable if repository exists
-	if repoExists {
+	if repoExists && !p.repoEnabled(repoName) {
+		var enabled bool
 		if p.hasCommand("yum-config-manager") {
-			enableCmd := exec.Command("yum-config-manager", "--enable", repoName)
+			enableCmd := p.command("yum-config-manager", "--enable", repoName)
 			enableCmd.Stdout = nil
 			enableCmd.Stderr = nil
-			enableCmd.Run() // Ignore errors
+			enabled = p.run(enableCmd) == nil // Ignore errors
 		} else if p.hasCommand("dnf") {
-			enableCmd := exec.Command("dnf", "config-manager", "--enable", repoName)
+			enableCmd := p.command("dnf", "config-manager", "--enable", repoName)
 			enableCmd.Stdout = nil
 			enableCmd.Stderr = nil
-			enableCmd.Run() // Ignore errors
+			enabled = p.run(enableCmd) == nil // Ignore errors
+		}
+		if enabled {
+			p.record("enabled repository "+repoName, func() error {
+				if p.hasCommand("yum-config-manager") {
+					return p.runQuiet("yum-config-manager", "--disable", repoName)
+				}
+				return p.runQuiet("dnf", "config-manager", "--disable", repoName)
+			})
 		}
 	}
+	repoPhase.end(nil)
 
 	// Install PHP and PHP-FPM with repository enabled
+	installPhase := p.startPhase("php.package_install")
 	packages := []string{
 		fmt.Sprintf("php%s-php-fpm", versionNum),
 		fmt.Sprintf("php%s-php-cli", versionNum),
 		fmt.Sprintf("php%s-php-common", versionNum),
 	}
+	missing := p.missingPackages(p.osFamily, packages...)
 
 	var installCmd *exec.Cmd
 	var stderr bytes.Buffer
@@ -126,56 +250,55 @@ func (p *RemiProvider) installPHPRHEL(version, versionNum string) error {
 	// Try installation - use --enablerepo only if repository exists
 	if repoExists {
 		if p.hasCommand("dnf") {
-			installCmd = exec.Command("dnf", append([]string{"install", "-y", fmt.Sprintf("--enablerepo=%s", repoName)}, packages...)...)
+			installCmd = p.command("dnf", append([]string{"install", "-y", fmt.Sprintf("--enablerepo=%s", repoName)}, packages...)...)
 		} else {
-			installCmd = exec.Command("yum", append([]string{"install", "-y", fmt.Sprintf("--enablerepo=%s", repoName)}, packages...)...)
+			installCmd = p.command("yum", append([]string{"install", "-y", fmt.Sprintf("--enablerepo=%s", repoName)}, packages...)...)
 		}
 	} else {
 		if p.hasCommand("dnf") {
-			installCmd = exec.Command("dnf", append([]string{"install", "-y"}, packages...)...)
+			installCmd = p.command("dnf", append([]string{"install", "-y"}, packages...)...)
 		} else {
-			installCmd = exec.Command("yum", append([]string{"install", "-y"}, packages...)...)
+			installCmd = p.command("yum", append([]string{"install", "-y"}, packages...)...)
 		}
 	}
 
 	installCmd.Stderr = &stderr
 	installCmd.Stdout = nil
 
-	if err := installCmd.Run(); err != nil {
+	if err := p.run(installCmd); err != nil {
 		if repoExists {
 			stderr.Reset()
 			if p.hasCommand("dnf") {
-				installCmd = exec.Command("dnf", append([]string{"install", "-y"}, packages...)...)
+				installCmd = p.command("dnf", append([]string{"install", "-y"}, packages...)...)
 			} else {
-				installCmd = exec.Command("yum", append([]string{"install", "-y"}, packages...)...)
+				installCmd = p.command("yum", append([]string{"install", "-y"}, packages...)...)
 			}
 			installCmd.Stderr = &stderr
 			installCmd.Stdout = nil
 			
-			if err := installCmd.Run(); err != nil {
+			if err := p.run(installCmd); err != nil {
+				p.journalPackages(p.osFamily, missing)
 				errorMsg := strings.TrimSpace(stderr.String())
 				if errorMsg == "" {
 					errorMsg = err.Error()
 				}
-				return fmt.Errorf("failed to install PHP packages: %s", errorMsg)
+				return installPhase.end(fmt.Errorf("failed to install PHP packages: %s", errorMsg))
 			}
 		} else {
+			p.journalPackages(p.osFamily, missing)
 			errorMsg := strings.TrimSpace(stderr.String())
 			if errorMsg == "" {
 				errorMsg = err.Error()
 			}
-			return fmt.Errorf("failed to install PHP packages: %s", errorMsg)
+			return installPhase.end(fmt.Errorf("failed to install PHP packages: %s", errorMsg))
 		}
 	}
+	p.journalPackages(p.osFamily, missing)
+	installPhase.end(nil)
 
 	// Enable and start PHP-FPM service
-	serviceName := p.GetServiceName(version)
-	enableService := exec.Command("systemctl", "enable", serviceName)
-	enableService.Run()
-
-	startService := exec.Command("systemctl", "start", serviceName)
-	if err := startService.Run(); err != nil {
-		return fmt.Errorf("failed to start PHP-FPM service: %w", err)
+	if err := p.startService(version); err != nil {
+		return err
 	}
 
 	// Save to database
@@ -187,57 +310,92 @@ func (p *RemiProvider) installPHPRHEL(version, versionNum string) error {
 }
 
 func (p *RemiProvider) installPHPDebian(version, versionNum string) error {
+	repoPhase := p.startPhase("php.repo_check")
+
+	// The PPA is published per Ubuntu release
+	codename := p.osInfo().Codename
+	if err := checkCodename(codename); err != nil {
+		return repoPhase.end(err)
+	}
+
 	// Update package list
-	updateCmd := exec.Command("apt-get", "update")
+	updateCmd := p.command("apt-get", "update")
 	updateCmd.Stdout = nil
 	updateCmd.Stderr = nil
-	if err := updateCmd.Run(); err != nil {
-		return fmt.Errorf("failed to update package list: %w", err)
+	if err := p.run(updateCmd); err != nil {
+		return repoPhase.end(fmt.Errorf("failed to update package list: %w", err))
 	}
 
 	// Install prerequisites
-	prereqCmd := exec.Command("apt-get", "install", "-y", "software-properties-common", "apt-transport-https", "lsb-release", "ca-certificates", "gnupg2")
+	prereqs := []string{"software-properties-common", "apt-transport-https", "ca-certificates", "gnupg2"}
+	missingPrereqs := p.missingPackages(p.osFamily, prereqs...)
+	prereqCmd := p.command("apt-get", append([]string{"install", "-y"}, prereqs...)...)
 	prereqCmd.Stdout = nil
 	prereqCmd.Stderr = nil
-	prereqCmd.Run()
+	p.run(prereqCmd)
+	p.journalPackages(p.osFamily, missingPrereqs)
+
+	hadRepo := p.hasOndrejRepo()
+	hadKey := p.run(p.command("test", "-e", ondrejKeyPath)) == nil
+
+	// Add ondrej/php PPA, or its apt source where add-apt-repository is
+	// missing or fails
+	if !p.hasCommand("add-apt-repository") || p.runQuiet("add-apt-repository", "-y", "ppa:ondrej/php") != nil {
+		source := "deb https://ppa.launchpadcontent.net/ondrej/php/ubuntu " + codename + " main\n"
+		if err := p.writeFile(ondrejSourcePath, []byte(source), 0644); err != nil {
+			return repoPhase.end(fmt.Errorf("failed to add the ondrej/php apt source: %w", err))
+		}
+	}
 
-	// Add ondrej/php PPA
-	addRepoScript := `add-apt-repository -y ppa:ondrej/php 2>/dev/null || echo "deb https://ppa.launchpadcontent.net/ondrej/php/ubuntu $(lsb_release -sc) main" > /etc/apt/sources.list.d/ondrej-php.list`
-	addRepoCmd := exec.Command("sh", "-c", addRepoScript)
-	addRepoCmd.Run()
+	// Add GPG key, falling back to apt-key where the keyserver cannot be
+	// fetched from directly
+	if err := p.installOndrejKey(); err != nil {
+		p.run(p.command("apt-key", "adv", "--keyserver", "keyserver.ubuntu.com", "--recv-keys", ondrejKeyID))
+	}
 
-	// Add GPG key
-	addKeyScript := `curl -fsSL "https://keyserver.ubuntu.com/pks/lookup?op=get&search=0x14AA40EC0831756756D7F66C4F4EA0AAE5267A6C" | gpg --dearmor -o /etc/apt/trusted.gpg.d/ondrej-php.gpg 2>/dev/null || apt-key adv --keyserver keyserver.ubuntu.com --recv-keys 14AA40EC0831756756D7F66C4F4EA0AAE5267A6C 2>/dev/null`
-	addKeyCmd := exec.Command("sh", "-c", addKeyScript)
-	addKeyCmd.Run()
+	if !hadRepo && p.hasOndrejRepo() {
+		p.record("added the ondrej/php repository", func() error {
+			p.run(p.command("add-apt-repository", "-r", "-y", "ppa:ondrej/php"))
+			if err := p.runQuiet("rm", "-f", ondrejSourcePath); err != nil {
+				return err
+			}
+			return p.runQuiet("apt-get", "update")
+		})
+	}
+	if !hadKey && p.run(p.command("test", "-e", ondrejKeyPath)) == nil {
+		p.record("added the ondrej/php signing key", func() error {
+			return p.runQuiet("rm", "-f", ondrejKeyPath)
+		})
+	}
 
 	// Update again after adding repository
-	updateCmd.Run()
+	p.run(p.command("apt-get", "update"))
+	repoPhase.end(nil)
 
 	// Install PHP version
+	installPhase := p.startPhase("php.package_install")
 	packages := []string{
 		fmt.Sprintf("php%s", version),
 		fmt.Sprintf("php%s-fpm", version),
 		fmt.Sprintf("php%s-cli", version),
 		fmt.Sprintf("php%s-common", version),
 	}
+	missing := p.missingPackages(p.osFamily, packages...)
 
-	installCmd := exec.Command("apt-get", "install", "-y")
+	installCmd := p.command("apt-get", "install", "-y")
 	installCmd.Args = append(installCmd.Args, packages...)
 	installCmd.Stdout = nil
 	installCmd.Stderr = nil
-	if err := installCmd.Run(); err != nil {
-		return fmt.Errorf("failed to install PHP packages: %w", err)
+	err := p.run(installCmd)
+	p.journalPackages(p.osFamily, missing)
+	if err != nil {
+		return installPhase.end(fmt.Errorf("failed to install PHP packages: %w", err))
 	}
+	installPhase.end(nil)
 
 	// Enable and start PHP-FPM service
-	serviceName := p.GetServiceName(version)
-	enableService := exec.Command("systemctl", "enable", serviceName)
-	enableService.Run()
-
-	startService := exec.Command("systemctl", "start", serviceName)
-	if err := startService.Run(); err != nil {
-		return fmt.Errorf("failed to start PHP-FPM service: %w", err)
+	if err := p.startService(version); err != nil {
+		return err
 	}
 
 	// Save to database
@@ -248,64 +406,170 @@ func (p *RemiProvider) installPHPDebian(version, versionNum string) error {
 	return nil
 }
 
+// startService enables and starts the PHP-FPM service of a version
+func (p *RemiProvider) startService(version string) error {
+	ph := p.startPhase("php-fpm.start")
+	serviceName := p.GetServiceName(version)
+	p.enableService(serviceName)
+
+	if err := p.services().Start(serviceName); err != nil {
+		return ph.end(fmt.Errorf("failed to start PHP-FPM service: %w", err))
+	}
+	return ph.end(nil)
+}
+
+const (
+	// ondrejKeyPath is where the ondrej/php signing key is installed
+	ondrejKeyPath = "/etc/apt/trusted.gpg.d/ondrej-php.gpg"
+	// ondrejKeyID is the fingerprint of the ondrej/php signing key
+	ondrejKeyID = "14AA40EC0831756756D7F66C4F4EA0AAE5267A6C"
+	// ondrejSourcePath is the apt source written when add-apt-repository
+	// is not available
+	ondrejSourcePath = "/etc/apt/sources.list.d/ondrej-php.list"
+)
+
+// installOndrejKey fetches the ondrej/php signing key from the Ubuntu
+// keyserver and installs it dearmored
+func (p *RemiProvider) installOndrejKey() error {
+	key, err := download("https://keyserver.ubuntu.com/pks/lookup?op=get&options=mr&search=0x" + ondrejKeyID)
+	if err != nil {
+		return err
+	}
+	if isArmored(key) {
+		if key, err = dearmor(key); err != nil {
+			return err
+		}
+	} else if err := checkPublicKey(key); err != nil {
+		return err
+	}
+	return p.writeFile(ondrejKeyPath, key, 0644)
+}
+
+// hasOndrejRepo reports whether an apt source for ondrej/php exists
+func (p *RemiProvider) hasOndrejRepo() bool {
+	return p.run(p.command("grep", "-rqs", "ondrej/php", "/etc/apt/sources.list.d")) == nil
+}
+
+// repoEnabled reports whether a yum/dnf repository is enabled
+func (p *RemiProvider) repoEnabled(repoName string) bool {
+	tool := "yum"
+	if p.hasCommand("dnf") {
+		tool = "dnf"
+	}
+	output, err := p.output(p.command(tool, "repolist", "--enabled", "--quiet"))
+	return err == nil && strings.Contains(string(output), repoName)
+}
+
+// CheckRepository verifies that the Remi repository is enabled, on Debian
+// that an apt source for ondrej/php exists, and on SUSE that the
+// devel:languages:php repository is added
+func (p *RemiProvider) CheckRepository() error {
+	if p.osFamily == system.OSSUSE {
+		if !p.hasSUSERepo() {
+			return fmt.Errorf("the devel:languages:php repository is not added")
+		}
+		return nil
+	}
+	if p.osFamily != system.OSRHEL {
+		if !p.hasOndrejRepo() {
+			return fmt.Errorf("no apt source for ondrej/php in /etc/apt/sources.list.d")
+		}
+		return nil
+	}
+	if !p.repoEnabled("remi") {
+		return fmt.Errorf("the remi repository is not enabled")
+	}
+	return nil
+}
+
+// CheckHealth checks that the Remi and EPEL repositories, or on Debian the
+// ondrej/php PPA, can be reached, that their signing keys are valid and
+// that their cached metadata is recent
+func (p *RemiProvider) CheckHealth() []HealthCheck {
+	if p.osFamily == system.OSSUSE {
+		var checks []HealthCheck
+		if url, err := p.suseRepoURL(); err == nil {
+			checks = append(checks, checkURL("devel:languages:php", url+"repodata/repomd.xml"))
+		}
+		cache := "/var/cache/zypp/raw/" + suseRepoAlias + "/repodata/"
+		return append(checks,
+			p.checkKeys("devel:languages:php", cache+"repomd.xml.key", "Run 'zypper --gpg-auto-import-keys refresh'"),
+			p.checkCache("devel:languages:php", cache+"repomd.xml", false, "Run 'zypper refresh'"),
+		)
+	}
+	if p.osFamily != system.OSRHEL {
+		codename := p.osInfo().Codename
+		return []HealthCheck{
+			checkURL("ondrej/php", "https://ppa.launchpadcontent.net/ondrej/php/ubuntu/dists/"+codename+"/InRelease"),
+			checkURL("keyserver.ubuntu.com", "https://keyserver.ubuntu.com/"),
+			p.checkKeys("ondrej/php", "/etc/apt/trusted.gpg.d/ondrej*.gpg",
+				"Install a PHP version again to fetch the key, e.g. 'php install VERSION'"),
+			p.checkCache("ondrej/php", "/var/lib/apt/lists/ppa.launchpadcontent.net_ondrej_php_*", true, "Run 'apt-get update'"),
+		}
+	}
+
+	major := strconv.Itoa(p.osInfo().Major())
+	if major == "0" {
+		major = "9"
+	}
+	cache, makecache := "/var/cache/dnf/remi-*/repodata/repomd.xml", "Run 'dnf makecache'"
+	if !p.hasCommand("dnf") {
+		cache, makecache = "/var/cache/yum/*/*/remi*/repomd.xml", "Run 'yum makecache'"
+	}
+	return []HealthCheck{
+		checkURL("remi", "https://rpms.remirepo.net/enterprise/"+major+"/remi/"+p.rpmArch()+"/repodata/repomd.xml"),
+		checkURL("epel", "https://dl.fedoraproject.org/pub/epel/"+major+"/Everything/"+p.rpmArch()+"/repodata/repomd.xml"),
+		p.checkKeys("remi", "/etc/pki/rpm-gpg/RPM-GPG-KEY-remi*", "Reinstall the remi-release package"),
+		p.checkKeys("epel", "/etc/pki/rpm-gpg/RPM-GPG-KEY-EPEL-"+major, "Reinstall the epel-release package"),
+		p.checkCache("remi", cache, false, makecache),
+	}
+}
+
+// CheckInstall verifies that the PHP binary runs and the FPM service is up
+func (p *RemiProvider) CheckInstall(version string) error {
+	if err := p.checkBinary(p.GetBinaryPath(version)); err != nil {
+		return err
+	}
+	return p.checkService(p.GetServiceName(version))
+}
+
 func (p *RemiProvider) ensureRemiRepo() error {
 	if p.osFamily != system.OSRHEL {
 		return nil // Not needed for Debian
 	}
 
 	// Check if remi-release is installed
-	checkCmd := exec.Command("rpm", "-q", "remi-release")
-	if checkCmd.Run() == nil {
+	checkCmd := p.command("rpm", "-q", "remi-release")
+	if p.run(checkCmd) == nil {
 		return nil // Already installed
 	}
 
-	// Detect RHEL version
-	releaseCmd := exec.Command("rpm", "-q", "--qf", "%{VERSION}", "redhat-release-server")
-	output, err := releaseCmd.Output()
-	if err != nil {
-		releaseCmd = exec.Command("cat", "/etc/redhat-release")
-		output, err = releaseCmd.Output()
-	}
-
-	releaseStr := strings.TrimSpace(string(output))
-	var epelURL, remiURL string
-	var useDnf bool
-
-	// Determine EPEL and Remi URLs based on RHEL version
-	if strings.Contains(releaseStr, "10") {
-		epelURL = "https://dl.fedoraproject.org/pub/epel/epel-release-latest-10.noarch.rpm"
-		remiURL = "https://rpms.remirepo.net/enterprise/remi-release-10.rpm"
-		useDnf = true
-	} else if strings.Contains(releaseStr, "9") {
-		epelURL = "https://dl.fedoraproject.org/pub/epel/epel-release-latest-9.noarch.rpm"
-		remiURL = "https://rpms.remirepo.net/enterprise/remi-release-9.rpm"
-		useDnf = true
-	} else if strings.Contains(releaseStr, "8") {
-		epelURL = "https://dl.fedoraproject.org/pub/epel/epel-release-latest-8.noarch.rpm"
-		remiURL = "https://rpms.remirepo.net/enterprise/remi-release-8.rpm"
-		useDnf = true
-	} else if strings.Contains(releaseStr, "7") {
-		epelURL = "https://dl.fedoraproject.org/pub/epel/epel-release-latest-7.noarch.rpm"
-		remiURL = "https://rpms.remirepo.net/enterprise/remi-release-7.rpm"
-		useDnf = false
-	} else {
-		epelURL = "https://dl.fedoraproject.org/pub/epel/epel-release-latest-9.noarch.rpm"
-		remiURL = "https://rpms.remirepo.net/enterprise/remi-release-9.rpm"
-		useDnf = true
+	// Pick the EPEL and Remi release packages of the target's RHEL
+	// release; unknown releases get the latest supported one
+	major := p.osInfo().Major()
+	switch major {
+	case 7, 8, 9, 10:
+	default:
+		major = 9
 	}
+	epelURL := fmt.Sprintf("https://dl.fedoraproject.org/pub/epel/epel-release-latest-%d.noarch.rpm", major)
+	remiURL := fmt.Sprintf("https://rpms.remirepo.net/enterprise/remi-release-%d.rpm", major)
+	useDnf := major >= 8
 
 	// Install EPEL first (required for Remi)
-	checkEpelCmd := exec.Command("rpm", "-q", "epel-release")
-	if checkEpelCmd.Run() != nil {
+	checkEpelCmd := p.command("rpm", "-q", "epel-release")
+	if p.run(checkEpelCmd) != nil {
 		var epelCmd *exec.Cmd
 		if useDnf {
-			epelCmd = exec.Command("dnf", "install", "-y", epelURL)
+			epelCmd = p.command("dnf", "install", "-y", epelURL)
 		} else {
-			epelCmd = exec.Command("yum", "install", "-y", epelURL)
+			epelCmd = p.command("yum", "install", "-y", epelURL)
 		}
 		epelCmd.Stdout = nil
 		epelCmd.Stderr = nil
-		if err := epelCmd.Run(); err != nil {
+		err := p.run(epelCmd)
+		p.journalPackages(p.osFamily, []string{"epel-release"})
+		if err != nil {
 			return fmt.Errorf("failed to install EPEL repository: %w", err)
 		}
 	}
@@ -313,20 +577,66 @@ func (p *RemiProvider) ensureRemiRepo() error {
 	// Install Remi repository
 	var remiCmd *exec.Cmd
 	if useDnf {
-		remiCmd = exec.Command("dnf", "install", "-y", remiURL)
+		remiCmd = p.command("dnf", "install", "-y", remiURL)
 	} else {
-		remiCmd = exec.Command("yum", "install", "-y", remiURL)
+		remiCmd = p.command("yum", "install", "-y", remiURL)
 	}
 	remiCmd.Stdout = nil
 	remiCmd.Stderr = nil
-	if err := remiCmd.Run(); err != nil {
+	err := p.run(remiCmd)
+	p.journalPackages(p.osFamily, []string{"remi-release"})
+	if err != nil {
 		return fmt.Errorf("failed to install Remi repository: %w", err)
 	}
 
 	return nil
 }
 
+func (p *RemiProvider) UninstallPHP(version string) error {
+	versionNum := strings.ReplaceAll(version, ".", "")
+	p.stopService(p.GetServiceName(version))
+
+	if p.osFamily == system.OSSUSE {
+		return p.removePackages(p.osFamily, susePackage(version)+"-fpm", susePackage(version))
+	}
+	if p.osFamily == system.OSRHEL {
+		return p.removePackages(p.osFamily,
+			fmt.Sprintf("php%s-php-fpm", versionNum),
+			fmt.Sprintf("php%s-php-cli", versionNum),
+			fmt.Sprintf("php%s-php-common", versionNum),
+		)
+	}
+	return p.removePackages(p.osFamily,
+		fmt.Sprintf("php%s", version),
+		fmt.Sprintf("php%s-fpm", version),
+		fmt.Sprintf("php%s-cli", version),
+		fmt.Sprintf("php%s-common", version),
+	)
+}
+
+// UpgradePHP upgrades a version's packages and restarts its FPM service
+func (p *RemiProvider) UpgradePHP(version string) error {
+	glob := fmt.Sprintf("php%s-*", version)
+	if p.osFamily == system.OSSUSE {
+		glob = susePackage(version) + "*"
+	} else if p.osFamily == system.OSRHEL {
+		glob = fmt.Sprintf("php%s-*", strings.ReplaceAll(version, ".", ""))
+	}
+	if err := p.upgradePackages(p.osFamily, glob); err != nil {
+		return err
+	}
+	return p.restartUpgraded(p.GetServiceName(version))
+}
+
+// ListInstalledPHP returns the installed versions, cached for
+// InstalledCacheTTL
 func (p *RemiProvider) ListInstalledPHP() ([]string, error) {
+	return cachedInstalled(p.installedKey(ProviderRemi), p.detectInstalled)
+}
+
+// detectInstalled reads the versions recorded in the database, or asks
+// the package manager when none are
+func (p *RemiProvider) detectInstalled() ([]string, error) {
 	// Try to get from database first
 	dbVersions, err := p.db.ListPHPVersions()
 	if err == nil && dbVersions != nil && len(dbVersions) > 0 {
@@ -344,11 +654,13 @@ func (p *RemiProvider) ListInstalledPHP() ([]string, error) {
 	// Fallback to system detection
 	versions := make([]string, 0)
 
-	if p.osFamily == system.OSRHEL {
+	if p.osFamily == system.OSSUSE {
+		versions = append(versions, p.suseInstalled()...)
+	} else if p.osFamily == system.OSRHEL {
 		// Check for installed PHP packages
 		if p.hasCommand("dnf") {
-			cmd := exec.Command("dnf", "list", "installed", "php*-php-fpm")
-			output, err := cmd.Output()
+			cmd := p.command("dnf", "list", "installed", "php*-php-fpm")
+			output, err := p.output(cmd)
 			if err == nil {
 				lines := strings.Split(string(output), "\n")
 				for _, line := range lines {
@@ -371,8 +683,8 @@ func (p *RemiProvider) ListInstalledPHP() ([]string, error) {
 				}
 			}
 		} else {
-			cmd := exec.Command("yum", "list", "installed", "php*-php-fpm")
-			output, err := cmd.Output()
+			cmd := p.command("yum", "list", "installed", "php*-php-fpm")
+			output, err := p.output(cmd)
 			if err == nil {
 				lines := strings.Split(string(output), "\n")
 				for _, line := range lines {
@@ -397,7 +709,7 @@ func (p *RemiProvider) ListInstalledPHP() ([]string, error) {
 		}
 	} else {
 		// Check /etc/php directory
-		entries, err := exec.Command("ls", "/etc/php").Output()
+		entries, err := p.output(p.command("ls", "/etc/php"))
 		if err == nil {
 			lines := strings.Split(strings.TrimSpace(string(entries)), "\n")
 			for _, line := range lines {
@@ -418,12 +730,20 @@ func (p *RemiProvider) ListInstalledPHP() ([]string, error) {
 func (p *RemiProvider) ListAvailablePHP() ([]string, error) {
 	if p.osFamily == system.OSRHEL {
 		return p.listAvailablePHPRHEL()
+	} else if p.osFamily == system.OSSUSE {
+		// Before the repository is added this is the distribution's PHP
+		return p.suseAvailable(), nil
 	} else {
 		return p.listAvailablePHPDebian()
 	}
 }
 
 func (p *RemiProvider) listAvailablePHPRHEL() ([]string, error) {
+	// Prefer the streams Remi actually publishes for this release
+	if versions := p.listRemiStreamVersions(); len(versions) > 0 {
+		return versions, nil
+	}
+
 	versions := []string{
 		"8.3",
 		"8.2",
@@ -445,10 +765,5 @@ func (p *RemiProvider) listAvailablePHPDebian() ([]string, error) {
 	return versions, nil
 }
 
-func (p *RemiProvider) hasCommand(cmd string) bool {
-	_, err := exec.LookPath(cmd)
-	return err == nil
-}
-
 // installPHPRHEL and installPHPDebian methods will be moved here from package.go
 // For now, keeping the structure - these will be implemented in the next step
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"lightweight-php/version"
+)
+
+// moduleStream is one row of `dnf module list php`
+type moduleStream struct {
+	Name    string
+	Enabled bool
+	Default bool
+}
+
+var (
+	moduleListPattern = regexp.MustCompile(`^php\s+(\S+)`)
+)
+
+// phpModuleStreams lists the streams of the php module known to dnf
+func (p *RemiProvider) phpModuleStreams() ([]moduleStream, error) {
+	output, err := p.output(p.command("dnf", "module", "list", "php", "-q"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list php module streams: %w", err)
+	}
+
+	streams := make([]moduleStream, 0)
+	for _, line := range strings.Split(string(output), "\n") {
+		m := moduleListPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name := m[1]
+		streams = append(streams, moduleStream{
+			Name:    strings.TrimSuffix(strings.TrimSuffix(name, "[e]"), "[d]"),
+			Enabled: strings.Contains(line, "[e]"),
+			Default: strings.Contains(line, "[d]"),
+		})
+	}
+	return streams, nil
+}
+
+// usesModuleStreams reports whether dnf module streams apply on this host
+func (p *RemiProvider) usesModuleStreams() bool {
+	return p.osInfo().Major() >= 8 && p.hasCommand("dnf")
+}
+
+// ensureModuleStream prepares dnf module state before installing the
+// SCL-style phpXY-php-* packages on RHEL 8+:
+//   - when Remi publishes module streams, the requested version must have a
+//     remi-X.Y stream, so an unknown version fails early instead of
+//     silently resolving to another stream
+//   - a php stream left enabled without base php installed is reset, since
+//     an enabled non-Remi stream makes dnf filter out Remi's packages
+func (p *RemiProvider) ensureModuleStream(version string) error {
+	if !p.usesModuleStreams() {
+		return nil
+	}
+
+	streams, err := p.phpModuleStreams()
+	if err != nil {
+		return err
+	}
+
+	var remiStreams []string
+	var enabled *moduleStream
+	for i, s := range streams {
+		if strings.HasPrefix(s.Name, "remi-") {
+			remiStreams = append(remiStreams, strings.TrimPrefix(s.Name, "remi-"))
+		}
+		if s.Enabled {
+			enabled = &streams[i]
+		}
+	}
+
+	if len(remiStreams) > 0 && !containsVersion(remiStreams, version) {
+		return fmt.Errorf("PHP %s is not published by Remi for this release (available streams: %s)", version, strings.Join(remiStreams, ", "))
+	}
+
+	if enabled != nil && !strings.HasPrefix(enabled.Name, "remi-") {
+		if p.run(p.command("rpm", "-q", "php-common")) == nil {
+			// Base php from that stream is installed (e.g. by the system
+			// provider); SCL packages install alongside it
+			return nil
+		}
+		if err := p.runQuiet("dnf", "module", "reset", "-y", "php"); err != nil {
+			return fmt.Errorf("failed to reset php module stream %s: %w", enabled.Name, err)
+		}
+		stream := enabled.Name
+		p.record("reset php module stream "+stream, func() error {
+			return p.runQuiet("dnf", "module", "enable", "-y", "php:"+stream)
+		})
+	}
+
+	return nil
+}
+
+// listRemiStreamVersions returns the versions Remi publishes as module streams
+func (p *RemiProvider) listRemiStreamVersions() []string {
+	if !p.usesModuleStreams() {
+		return nil
+	}
+	streams, err := p.phpModuleStreams()
+	if err != nil {
+		return nil
+	}
+
+	versions := make([]string, 0)
+	for i := len(streams) - 1; i >= 0; i-- {
+		if v := strings.TrimPrefix(streams[i].Name, "remi-"); v != streams[i].Name && !containsVersion(versions, v) {
+			versions = append(versions, v)
+		}
+	}
+	version.Sort(versions)
+	return versions
+}
@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"lightweight-php/servicemgr"
+	"lightweight-php/target"
+	"lightweight-php/tracing"
+)
+
+// runner runs a provider's commands on its execution target. Providers embed
+// it; the factory sets the target and, for recorded installs, a transcript.
+type runner struct {
+	target target.Target
+	// transcript receives every command run and its output when set
+	transcript io.Writer
+	// ctx carries the span that commands are traced under
+	ctx context.Context
+	// journal lists the changes made by installs, for Rollback
+	journal []Change
+}
+
+func (r *runner) setTarget(t target.Target) {
+	r.target = t
+}
+
+func (r *runner) setTranscript(w io.Writer) {
+	r.transcript = w
+}
+
+func (r *runner) setContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+func (r *runner) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// phase is a traced step of an operation, such as the repository setup of
+// an install
+type phase struct {
+	r      *runner
+	parent context.Context
+	span   *tracing.Span
+}
+
+// startPhase starts a span that the commands run until end are traced
+// under
+func (r *runner) startPhase(name string) *phase {
+	ph := &phase{r: r, parent: r.ctx}
+	r.ctx, ph.span = tracing.Start(r.context(), name)
+	return ph
+}
+
+// end finishes the phase with err and returns err
+func (ph *phase) end(err error) error {
+	ph.span.SetError(err)
+	ph.span.End()
+	ph.r.ctx = ph.parent
+	return err
+}
+
+// command returns a command that runs inside the provider's target
+func (r *runner) command(name string, args ...string) *exec.Cmd {
+	return r.target.Command(name, args...)
+}
+
+// services returns the service manager of the provider's target. Its
+// commands go through run, so they are traced and transcribed like the
+// provider's own.
+func (r *runner) services() servicemgr.Manager {
+	return servicemgr.ForTarget(r.target, serviceRunner{r})
+}
+
+// serviceRunner lets a service manager run commands through a runner
+type serviceRunner struct {
+	r *runner
+}
+
+func (s serviceRunner) Command(name string, args ...string) *exec.Cmd {
+	return s.r.command(name, args...)
+}
+
+func (s serviceRunner) Run(cmd *exec.Cmd) error {
+	return s.r.run(cmd)
+}
+
+func (s serviceRunner) Output(cmd *exec.Cmd) ([]byte, error) {
+	return s.r.output(cmd)
+}
+
+func (r *runner) hasCommand(cmd string) bool {
+	return r.target.LookPath(cmd) == nil
+}
+
+// run runs a command like cmd.Run, copying its output to the transcript.
+// Output the caller discards is still recorded.
+func (r *runner) run(cmd *exec.Cmd) error {
+	if r.transcript == nil {
+		return tracing.Run(r.context(), cmd)
+	}
+	r.logCommand(cmd)
+	cmd.Stdout = teeTo(cmd.Stdout, r.transcript)
+	cmd.Stderr = teeTo(cmd.Stderr, r.transcript)
+	err := tracing.Run(r.context(), cmd)
+	r.logResult(err)
+	return err
+}
+
+// output runs a command like cmd.Output, copying its output to the
+// transcript
+func (r *runner) output(cmd *exec.Cmd) ([]byte, error) {
+	if r.transcript == nil {
+		return tracing.Output(r.context(), cmd)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := r.run(cmd)
+	return stdout.Bytes(), err
+}
+
+// runQuiet runs a command, returning its stderr in the error on failure
+func (r *runner) runQuiet(name string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := r.command(name, args...)
+	cmd.Stderr = &stderr
+	if err := r.run(cmd); err != nil {
+		errorMsg := strings.TrimSpace(stderr.String())
+		if errorMsg == "" {
+			errorMsg = err.Error()
+		}
+		return fmt.Errorf("%s", errorMsg)
+	}
+	return nil
+}
+
+func (r *runner) logCommand(cmd *exec.Cmd) {
+	fmt.Fprintf(r.transcript, "$ %s\n", strings.Join(cmd.Args, " "))
+}
+
+func (r *runner) logResult(err error) {
+	if err != nil {
+		fmt.Fprintf(r.transcript, "# %v\n", err)
+	}
+}
+
+func teeTo(w, transcript io.Writer) io.Writer {
+	if w == nil {
+		return transcript
+	}
+	return io.MultiWriter(w, transcript)
+}
@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"lightweight-php/system"
+)
+
+// SUSE packages one PHP per major version (php7, php8) with a single
+// php-fpm service, so like RHEL module streams only one minor version of
+// each major can be installed, and which one depends on the repositories.
+
+// suseRepoAlias is the zypper alias of the devel:languages:php repository,
+// the SUSE counterpart of Remi and ondrej/php
+const suseRepoAlias = "devel_languages_php"
+
+// susePackage returns the package name of a PHP version's major version,
+// e.g. php8 for 8.2
+func susePackage(version string) string {
+	major, _, _ := strings.Cut(version, ".")
+	return "php" + major
+}
+
+func suseServiceName() string {
+	return "php-fpm"
+}
+
+func suseSocketPath(username string) string {
+	return fmt.Sprintf("/run/php-fpm/%s.sock", username)
+}
+
+func suseConfigPath(username, version string) string {
+	return filepath.Join("/etc", susePackage(version), "fpm/php-fpm.d", username+".conf")
+}
+
+func suseFPMConfigPath(version string) string {
+	return filepath.Join("/etc", susePackage(version), "fpm/php-fpm.conf")
+}
+
+func suseConfDir(version string) string {
+	return filepath.Join("/etc", susePackage(version), "conf.d")
+}
+
+func suseBinaryPath(version string) string {
+	return "/usr/bin/" + susePackage(version)
+}
+
+func suseFPMBinaryPath() string {
+	return "/usr/sbin/php-fpm"
+}
+
+// suseCandidate returns the major.minor version of a package that zypper
+// would install, or "" when no enabled repository has it
+func (r *runner) suseCandidate(pkg string) string {
+	output, err := r.output(r.command("zypper", "--non-interactive", "--quiet", "info", pkg))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) != "Version" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		// Versions may carry an epoch, as in 1:8.2.20
+		if i := strings.Index(value, ":"); i >= 0 {
+			value = value[i+1:]
+		}
+		if parts := strings.SplitN(value, ".", 3); len(parts) >= 2 {
+			return parts[0] + "." + parts[1]
+		}
+	}
+	return ""
+}
+
+// suseAvailable returns the PHP versions the enabled repositories offer
+func (r *runner) suseAvailable() []string {
+	var versions []string
+	for _, pkg := range []string{"php8-fpm", "php7-fpm"} {
+		if v := r.suseCandidate(pkg); v != "" {
+			versions = append(versions, v)
+		}
+	}
+	return versions
+}
+
+// installPHPSUSE installs a PHP version with zypper. The repositories
+// must offer exactly that minor version; anything else is refused before
+// a package is installed. openSUSE ships php-fpm.conf as a .default file
+// only, which is copied into place.
+func (r *runner) installPHPSUSE(version string) error {
+	repoPhase := r.startPhase("php.repo_check")
+	if err := r.runQuiet("zypper", "--non-interactive", "--gpg-auto-import-keys", "refresh"); err != nil {
+		return repoPhase.end(fmt.Errorf("failed to refresh repositories: %w", err))
+	}
+	pkg := susePackage(version)
+	if candidate := r.suseCandidate(pkg + "-fpm"); candidate != version {
+		if candidate == "" {
+			return repoPhase.end(fmt.Errorf("PHP %s is not available: no enabled repository has %s-fpm", version, pkg))
+		}
+		return repoPhase.end(fmt.Errorf("PHP %s is not available: the enabled repositories have %s %s", version, pkg, candidate))
+	}
+	repoPhase.end(nil)
+
+	installPhase := r.startPhase("php.package_install")
+	packages := []string{pkg, pkg + "-fpm"}
+	missing := r.missingPackages(system.OSSUSE, packages...)
+	err := r.installPackages(system.OSSUSE, packages...)
+	r.journalPackages(system.OSSUSE, missing)
+	if err != nil {
+		return installPhase.end(err)
+	}
+
+	fpmConfig := suseFPMConfigPath(version)
+	if r.run(r.command("test", "-e", fpmConfig)) != nil {
+		if err := r.runQuiet("cp", "-p", fpmConfig+".default", fpmConfig); err != nil {
+			return installPhase.end(fmt.Errorf("failed to create %s: %w", fpmConfig, err))
+		}
+		r.record("created "+fpmConfig, func() error {
+			return r.runQuiet("rm", "-f", fpmConfig)
+		})
+	}
+	return installPhase.end(nil)
+}
+
+// suseInstalled returns the PHP versions installed from any repository
+func (r *runner) suseInstalled() []string {
+	var versions []string
+	for _, pkg := range []string{"php8-fpm", "php7-fpm"} {
+		output, err := r.output(r.command("rpm", "-q", "--qf", "%{VERSION}", pkg))
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(string(output)), ".")
+		if len(parts) >= 2 {
+			versions = append(versions, parts[0]+"."+parts[1])
+		}
+	}
+	return versions
+}
+
+// hasSUSERepo reports whether the devel:languages:php repository is added
+func (r *runner) hasSUSERepo() bool {
+	output, err := r.output(r.command("zypper", "--non-interactive", "--quiet", "repos"))
+	return err == nil && strings.Contains(string(output), suseRepoAlias)
+}
+
+// suseRepoURL returns the devel:languages:php repository of the target's
+// release: Tumbleweed, or Leap and SLE by their shared VERSION_ID (15.6)
+func (r *runner) suseRepoURL() (string, error) {
+	info := r.osInfo()
+	dist := info.VersionID
+	if strings.Contains(info.ID, "tumbleweed") {
+		dist = "openSUSE_Tumbleweed"
+	}
+	if dist == "" {
+		return "", fmt.Errorf("cannot tell the SUSE release from /etc/os-release")
+	}
+	return "https://download.opensuse.org/repositories/devel:/languages:/php/" + dist + "/", nil
+}
+
+// ensureSUSERepo adds the devel:languages:php repository with a higher
+// priority than the distribution's, so its PHP wins
+func (r *runner) ensureSUSERepo() error {
+	if r.hasSUSERepo() {
+		return nil
+	}
+	url, err := r.suseRepoURL()
+	if err != nil {
+		return err
+	}
+	if err := r.runQuiet("zypper", "--non-interactive", "addrepo", "--refresh", "--priority", "90", url, suseRepoAlias); err != nil {
+		return fmt.Errorf("failed to add the devel:languages:php repository: %w", err)
+	}
+	r.record("added the devel:languages:php repository", func() error {
+		return r.runQuiet("zypper", "--non-interactive", "removerepo", suseRepoAlias)
+	})
+	return nil
+}
@@ -0,0 +1,352 @@
+package provider
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"lightweight-php/db"
+	"lightweight-php/system"
+	"lightweight-php/version"
+)
+
+// SystemProvider implements PHPProvider for the distribution's own PHP
+// packages (AppStream module streams on RHEL, stock packages on Debian and
+// SUSE) without adding third-party repositories
+type SystemProvider struct {
+	runner
+	db       *db.Database
+	osFamily system.OSFamily
+}
+
+var (
+	moduleStreamPattern = regexp.MustCompile(`^php\s+(\d+\.\d+)\b`)
+	debianFPMPattern    = regexp.MustCompile(`php(\d+\.\d+)-fpm`)
+)
+
+func NewSystemProvider(database *db.Database, osFamily system.OSFamily) (*SystemProvider, error) {
+	return &SystemProvider{
+		db:       database,
+		osFamily: osFamily,
+	}, nil
+}
+
+func (p *SystemProvider) GetProviderType() string {
+	return string(ProviderSystem)
+}
+
+func (p *SystemProvider) GetServiceName(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseServiceName()
+	}
+	if p.osFamily == system.OSRHEL {
+		// Only one stream can be installed at a time, so there is a single service
+		return "php-fpm"
+	}
+	return fmt.Sprintf("php%s-fpm", version)
+}
+
+func (p *SystemProvider) GetSocketPath(username, version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseSocketPath(username)
+	}
+	if p.osFamily == system.OSRHEL {
+		return fmt.Sprintf("/run/php-fpm/%s.sock", username)
+	}
+	return fmt.Sprintf("/run/php/php%s-%s.sock", version, username)
+}
+
+func (p *SystemProvider) GetConfigPath(username, version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseConfigPath(username, version)
+	}
+	if p.osFamily == system.OSRHEL {
+		return filepath.Join("/etc/php-fpm.d", fmt.Sprintf("%s.conf", username))
+	}
+	return filepath.Join("/etc/php", version, "fpm/pool.d", fmt.Sprintf("%s.conf", username))
+}
+
+func (p *SystemProvider) GetFPMConfigPath(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseFPMConfigPath(version)
+	}
+	if p.osFamily == system.OSRHEL {
+		return "/etc/php-fpm.conf"
+	}
+	return filepath.Join("/etc/php", version, "fpm/php-fpm.conf")
+}
+
+func (p *SystemProvider) GetConfDir(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseConfDir(version)
+	}
+	if p.osFamily == system.OSRHEL {
+		return "/etc/php.d"
+	}
+	return filepath.Join("/etc/php", version, "fpm/conf.d")
+}
+
+func (p *SystemProvider) GetBinaryPath(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseBinaryPath(version)
+	}
+	if p.osFamily == system.OSRHEL {
+		return "/usr/bin/php"
+	}
+	return fmt.Sprintf("/usr/bin/php%s", version)
+}
+
+func (p *SystemProvider) GetFPMBinaryPath(version string) string {
+	if p.osFamily == system.OSSUSE {
+		return suseFPMBinaryPath()
+	}
+	if p.osFamily == system.OSRHEL {
+		return "/usr/sbin/php-fpm"
+	}
+	return fmt.Sprintf("/usr/sbin/php-fpm%s", version)
+}
+
+func (p *SystemProvider) InstallExtension(version, extension string) error {
+	if p.osFamily == system.OSSUSE {
+		return p.installPackages(p.osFamily, fmt.Sprintf("%s-%s", susePackage(version), extension))
+	}
+	if p.osFamily == system.OSRHEL {
+		return p.installPackages(p.osFamily, fmt.Sprintf("php-pecl-%s", extension))
+	}
+	return p.installPackages(p.osFamily, fmt.Sprintf("php%s-%s", version, extension))
+}
+
+func (p *SystemProvider) InstallBuildTools(version string) error {
+	if p.osFamily == system.OSSUSE {
+		return p.installBuildTools(p.osFamily, susePackage(version)+"-devel")
+	}
+	if p.osFamily == system.OSRHEL {
+		return p.installBuildTools(p.osFamily, "php-devel")
+	}
+	return p.installBuildTools(p.osFamily, fmt.Sprintf("php%s-dev", version))
+}
+
+func (p *SystemProvider) InstallPHP(version string) error {
+	available, err := p.ListAvailablePHP()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, v := range available {
+		if v == version {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("PHP %s is not provided by the distribution packages (available: %s)", version, strings.Join(available, ", "))
+	}
+
+	if p.osFamily == system.OSRHEL {
+		return p.installPHPRHEL(version)
+	}
+	if p.osFamily == system.OSSUSE {
+		if err := p.installPHPSUSE(version); err != nil {
+			return err
+		}
+		return p.startAndRecord(version, string(system.OSSUSE))
+	}
+	return p.installPHPDebian(version)
+}
+
+func (p *SystemProvider) installPHPRHEL(version string) error {
+	// Select the AppStream module stream for the requested version
+	if p.hasCommand("dnf") {
+		if err := p.runQuiet("dnf", "module", "reset", "-y", "php"); err != nil {
+			return fmt.Errorf("failed to reset php module: %w", err)
+		}
+		if err := p.runQuiet("dnf", "module", "enable", "-y", fmt.Sprintf("php:%s", version)); err != nil {
+			return fmt.Errorf("failed to enable php:%s module stream: %w", version, err)
+		}
+	}
+
+	pkgTool := "yum"
+	if p.hasCommand("dnf") {
+		pkgTool = "dnf"
+	}
+	missing := p.missingPackages(p.osFamily, "php-fpm", "php-cli", "php-common")
+	err := p.runQuiet(pkgTool, "install", "-y", "php-fpm", "php-cli", "php-common")
+	p.journalPackages(p.osFamily, missing)
+	if err != nil {
+		return fmt.Errorf("failed to install PHP packages: %w", err)
+	}
+
+	return p.startAndRecord(version, "rhel")
+}
+
+func (p *SystemProvider) installPHPDebian(version string) error {
+	if err := p.runQuiet("apt-get", "update"); err != nil {
+		return fmt.Errorf("failed to update package list: %w", err)
+	}
+
+	packages := []string{
+		fmt.Sprintf("php%s-fpm", version),
+		fmt.Sprintf("php%s-cli", version),
+		fmt.Sprintf("php%s-common", version),
+	}
+	missing := p.missingPackages(p.osFamily, packages...)
+	err := p.runQuiet("apt-get", append([]string{"install", "-y"}, packages...)...)
+	p.journalPackages(p.osFamily, missing)
+	if err != nil {
+		return fmt.Errorf("failed to install PHP packages: %w", err)
+	}
+
+	return p.startAndRecord(version, "debian")
+}
+
+func (p *SystemProvider) startAndRecord(version, osFamily string) error {
+	serviceName := p.GetServiceName(version)
+	p.enableService(serviceName)
+	if err := p.services().Start(serviceName); err != nil {
+		return fmt.Errorf("failed to start PHP-FPM service: %w", err)
+	}
+
+	if err := p.db.CreatePHPVersion(version, string(ProviderSystem), osFamily); err != nil {
+		fmt.Printf("Warning: failed to save PHP version to database: %v\n", err)
+	}
+	return nil
+}
+
+// CheckInstall verifies that the PHP binary runs and the FPM service is up
+func (p *SystemProvider) CheckInstall(version string) error {
+	if err := p.checkBinary(p.GetBinaryPath(version)); err != nil {
+		return err
+	}
+	return p.checkService(p.GetServiceName(version))
+}
+
+func (p *SystemProvider) UninstallPHP(version string) error {
+	p.stopService(p.GetServiceName(version))
+
+	if p.osFamily == system.OSRHEL {
+		return p.removePackages(p.osFamily, "php-fpm", "php-cli", "php-common")
+	}
+	if p.osFamily == system.OSSUSE {
+		return p.removePackages(p.osFamily, susePackage(version)+"-fpm", susePackage(version))
+	}
+	return p.removePackages(p.osFamily,
+		fmt.Sprintf("php%s-fpm", version),
+		fmt.Sprintf("php%s-cli", version),
+		fmt.Sprintf("php%s-common", version),
+	)
+}
+
+// UpgradePHP upgrades a version's packages and restarts its FPM service
+func (p *SystemProvider) UpgradePHP(version string) error {
+	glob := fmt.Sprintf("php%s-*", version)
+	if p.osFamily == system.OSRHEL {
+		glob = "php-*"
+	} else if p.osFamily == system.OSSUSE {
+		glob = susePackage(version) + "*"
+	}
+	if err := p.upgradePackages(p.osFamily, glob); err != nil {
+		return err
+	}
+	return p.restartUpgraded(p.GetServiceName(version))
+}
+
+// ListInstalledPHP returns the installed versions, cached for
+// InstalledCacheTTL
+func (p *SystemProvider) ListInstalledPHP() ([]string, error) {
+	return cachedInstalled(p.installedKey(ProviderSystem), p.detectInstalled)
+}
+
+// detectInstalled reads the versions recorded in the database, or asks
+// the package manager when none are
+func (p *SystemProvider) detectInstalled() ([]string, error) {
+	// Try to get from database first
+	dbVersions, err := p.db.ListPHPVersions()
+	if err == nil && len(dbVersions) > 0 {
+		versions := make([]string, 0, len(dbVersions))
+		for _, v := range dbVersions {
+			if v.Status == "active" && v.PackageManager == string(ProviderSystem) {
+				versions = append(versions, v.Version)
+			}
+		}
+		if len(versions) > 0 {
+			return versions, nil
+		}
+	}
+
+	// Fallback: ask the installed binary
+	versions := make([]string, 0)
+	if p.osFamily == system.OSSUSE {
+		versions = append(versions, p.suseInstalled()...)
+	} else if p.osFamily == system.OSRHEL {
+		output, err := p.output(p.command("rpm", "-q", "--qf", "%{VERSION}", "php-fpm"))
+		if err == nil {
+			parts := strings.Split(strings.TrimSpace(string(output)), ".")
+			if len(parts) >= 2 {
+				versions = append(versions, parts[0]+"."+parts[1])
+			}
+		}
+	} else {
+		output, err := p.output(p.command("dpkg-query", "-W", "-f", "${Package} ${Status}\n", "php*-fpm"))
+		if err == nil {
+			for _, line := range strings.Split(string(output), "\n") {
+				if !strings.Contains(line, "install ok installed") {
+					continue
+				}
+				if m := debianFPMPattern.FindStringSubmatch(line); m != nil {
+					versions = append(versions, m[1])
+				}
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+func (p *SystemProvider) ListAvailablePHP() ([]string, error) {
+	versions := make([]string, 0)
+
+	if p.osFamily == system.OSSUSE {
+		versions = append(versions, p.suseAvailable()...)
+		version.Sort(versions)
+		return versions, nil
+	}
+
+	if p.osFamily == system.OSRHEL {
+		if !p.hasCommand("dnf") {
+			return versions, nil
+		}
+		output, err := p.output(p.command("dnf", "module", "list", "php", "-q"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list php module streams: %w", err)
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if m := moduleStreamPattern.FindStringSubmatch(line); m != nil && !containsVersion(versions, m[1]) {
+				versions = append(versions, m[1])
+			}
+		}
+		return versions, nil
+	}
+
+	// The php-fpm metapackage depends on the distribution's default phpX.Y-fpm
+	output, err := p.output(p.command("apt-cache", "depends", "php-fpm"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query php-fpm package: %w", err)
+	}
+	for _, m := range debianFPMPattern.FindAllStringSubmatch(string(output), -1) {
+		if !containsVersion(versions, m[1]) {
+			versions = append(versions, m[1])
+		}
+	}
+	version.Sort(versions)
+	return versions, nil
+}
+
+func containsVersion(versions []string, version string) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
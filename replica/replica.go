@@ -0,0 +1,324 @@
+// Package replica keeps a warm standby of the state database: it uploads a
+// snapshot whenever the database changes, so a lost host can be rebuilt by
+// restoring the state as of any point within the retention period.
+package replica
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+	"lightweight-php/db"
+	"lightweight-php/objstore"
+)
+
+const (
+	snapshotPrefix = "snapshots/"
+	snapshotSuffix = ".db.gz"
+	timeLayout     = "20060102T150405Z"
+)
+
+// Snapshot is one stored copy of the database
+type Snapshot struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+	// Hash is a prefix of the SHA-256 of the uncompressed database
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Replicator uploads snapshots of a database to a store
+type Replicator struct {
+	db        *db.Database
+	store     objstore.Store
+	retention time.Duration
+	lastHash  string
+}
+
+// New returns a replicator that keeps snapshots for retentionDays
+func New(database *db.Database, store objstore.Store, retentionDays int) *Replicator {
+	return &Replicator{
+		db:        database,
+		store:     store,
+		retention: time.Duration(retentionDays) * 24 * time.Hour,
+	}
+}
+
+// OpenStore returns the store configured in replication.url
+func OpenStore(cfg *config.Config) (objstore.Store, error) {
+	if cfg.Replication.URL == "" {
+		return nil, fmt.Errorf("replication is not configured; set replication.url in %s", config.DefaultConfigPath)
+	}
+	return objstore.Open(cfg.Replication.URL, cfg.S3)
+}
+
+// Store returns the replicator's store
+func (r *Replicator) Store() objstore.Store {
+	return r.store
+}
+
+// Sync uploads a snapshot if the database changed since the last uploaded
+// one and prunes snapshots past the retention. It returns nil when nothing
+// changed.
+func (r *Replicator) Sync() (*Snapshot, error) {
+	if r.lastHash == "" {
+		// Pick up where a previous run left off instead of re-uploading
+		snapshots, err := List(r.store)
+		if err != nil {
+			return nil, err
+		}
+		if len(snapshots) > 0 {
+			r.lastHash = snapshots[len(snapshots)-1].Hash
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "lightweight-php-replica-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	raw := filepath.Join(dir, "snapshot.db")
+	if err := r.db.SnapshotTo(raw); err != nil {
+		return nil, err
+	}
+	hash, err := fileHash(raw)
+	if err != nil {
+		return nil, err
+	}
+	if hash == r.lastHash {
+		return nil, nil
+	}
+
+	compressed := filepath.Join(dir, "snapshot.db.gz")
+	size, err := gzipFile(raw, compressed)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(compressed)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	now := time.Now().UTC()
+	snapshot := &Snapshot{
+		Key:  snapshotPrefix + now.Format(timeLayout) + "-" + hash + snapshotSuffix,
+		Time: now.Truncate(time.Second),
+		Hash: hash,
+		Size: size,
+	}
+	if err := r.store.Put(snapshot.Key, f, size); err != nil {
+		return nil, err
+	}
+	r.lastHash = hash
+
+	if r.retention > 0 {
+		if _, err := Prune(r.store, now.Add(-r.retention)); err != nil {
+			return snapshot, err
+		}
+	}
+	return snapshot, nil
+}
+
+// Run calls Sync every interval until stop is closed, logging uploads and
+// errors through logf
+func (r *Replicator) Run(interval time.Duration, stop <-chan struct{}, logf func(format string, args ...interface{})) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if snapshot, err := r.Sync(); err != nil {
+			logf("Replication to %s failed: %v", r.store, err)
+		} else if snapshot != nil {
+			logf("Replicated database to %s/%s", r.store, snapshot.Key)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// List returns the snapshots in a store, oldest first
+func List(store objstore.Store) ([]Snapshot, error) {
+	objects, err := store.List(snapshotPrefix)
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]Snapshot, 0, len(objects))
+	for _, o := range objects {
+		name := strings.TrimSuffix(strings.TrimPrefix(o.Key, snapshotPrefix), snapshotSuffix)
+		stamp, hash, ok := strings.Cut(name, "-")
+		if !ok || !strings.HasSuffix(o.Key, snapshotSuffix) {
+			continue
+		}
+		t, err := time.Parse(timeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{Key: o.Key, Time: t, Hash: hash, Size: o.Size})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// Prune deletes snapshots taken before cutoff. The newest snapshot is
+// always kept, as is the newest one before cutoff, so the state as of
+// cutoff stays restorable.
+func Prune(store objstore.Store, cutoff time.Time) (int, error) {
+	snapshots, err := List(store)
+	if err != nil {
+		return 0, err
+	}
+	var old []Snapshot
+	for _, s := range snapshots {
+		if s.Time.Before(cutoff) {
+			old = append(old, s)
+		}
+	}
+	if len(old) > 0 {
+		old = old[:len(old)-1]
+	}
+
+	deleted := 0
+	for _, s := range old {
+		if err := store.Delete(s.Key); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Restore writes the database as of at (the newest snapshot taken at or
+// before it) to dest. The snapshot is checked before it replaces dest; an
+// existing dest is kept as dest.before-restore.
+func Restore(store objstore.Store, at time.Time, dest string) (*Snapshot, error) {
+	snapshots, err := List(store)
+	if err != nil {
+		return nil, err
+	}
+	var chosen *Snapshot
+	for i := range snapshots {
+		if !snapshots[i].Time.After(at) {
+			chosen = &snapshots[i]
+		}
+	}
+	if chosen == nil {
+		if len(snapshots) == 0 {
+			return nil, fmt.Errorf("no snapshots in %s", store)
+		}
+		return nil, fmt.Errorf("no snapshot at or before %s; the oldest is from %s",
+			at.UTC().Format(time.RFC3339), snapshots[0].Time.Format(time.RFC3339))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	tmp := dest + ".restore"
+	os.Remove(tmp)
+	if err := download(store, chosen.Key, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	restored, err := db.OpenSQLite(tmp)
+	if err == nil {
+		err = restored.CheckIntegrity()
+		restored.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("snapshot %s is not usable: %w", chosen.Key, err)
+	}
+
+	if _, err := os.Stat(dest); err == nil {
+		if err := os.Rename(dest, dest+".before-restore"); err != nil {
+			os.Remove(tmp)
+			return nil, fmt.Errorf("failed to keep the current database: %w", err)
+		}
+	}
+	// Stale WAL and journal files belong to the replaced database
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		os.Remove(dest + suffix)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, fmt.Errorf("failed to install restored database: %w", err)
+	}
+	return chosen, nil
+}
+
+// download fetches and decompresses a snapshot into path
+func download(store objstore.Store, key, path string) error {
+	body, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot %s: %w", key, err)
+	}
+	defer gz.Close()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := io.Copy(f, gz); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to read snapshot %s: %w", key, err)
+	}
+	return f.Close()
+}
+
+// fileHash returns the first 16 hex digits of a file's SHA-256
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// gzipFile compresses src into dest and returns the compressed size
+func gzipFile(src, dest string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return 0, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	info, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
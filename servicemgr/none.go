@@ -0,0 +1,48 @@
+package servicemgr
+
+// none is used where no init system runs, as in a Docker container whose
+// PHP-FPM is the container's process: services are managed from outside, so
+// every operation succeeds without doing anything and the status is unknown.
+// A reload of a changed pool must then come from the container's
+// orchestration, e.g. by restarting the container.
+type none struct{}
+
+func (none) Kind() string {
+	return KindNone
+}
+
+func (none) Enable(string) error {
+	return nil
+}
+
+func (none) Enabled(string) bool {
+	return true
+}
+
+func (none) Disable(string) error {
+	return nil
+}
+
+func (none) Start(string) error {
+	return nil
+}
+
+func (none) Stop(string) error {
+	return nil
+}
+
+func (none) Reload(string) error {
+	return nil
+}
+
+func (none) ReloadOrRestart(string) error {
+	return nil
+}
+
+func (none) Signal(string, string) error {
+	return nil
+}
+
+func (none) Status(string) (Status, error) {
+	return StatusUnknown, nil
+}
@@ -0,0 +1,83 @@
+package servicemgr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// openRC drives init scripts with rc-service and rc-update, as on Alpine
+type openRC struct {
+	r Runner
+}
+
+// openRCRunlevel is the runlevel services are added to
+const openRCRunlevel = "default"
+
+func (m *openRC) Kind() string {
+	return KindOpenRC
+}
+
+func (m *openRC) Enable(service string) error {
+	return m.r.Run(m.r.Command("rc-update", "add", service, openRCRunlevel))
+}
+
+func (m *openRC) Enabled(service string) bool {
+	out, err := m.r.Output(m.r.Command("rc-update", "show", openRCRunlevel))
+	if err != nil {
+		return false
+	}
+	// Lines read "   php-fpm83 | default"
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, _, ok := strings.Cut(line, "|"); ok && strings.TrimSpace(name) == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *openRC) Disable(service string) error {
+	m.Stop(service)
+	return m.r.Run(m.r.Command("rc-update", "del", service, openRCRunlevel))
+}
+
+func (m *openRC) Start(service string) error {
+	return m.r.Run(m.r.Command("rc-service", service, "start"))
+}
+
+func (m *openRC) Stop(service string) error {
+	return m.r.Run(m.r.Command("rc-service", service, "stop"))
+}
+
+func (m *openRC) Reload(service string) error {
+	if err := m.r.Run(m.r.Command("rc-service", service, "reload")); err != nil {
+		return m.r.Run(m.r.Command("rc-service", service, "restart"))
+	}
+	return nil
+}
+
+func (m *openRC) ReloadOrRestart(service string) error {
+	// Init scripts without a reload command fail it
+	return m.Reload(service)
+}
+
+func (m *openRC) Signal(service, signal string) error {
+	return fmt.Errorf("OpenRC cannot signal %s: %w", service, ErrUnsupported)
+}
+
+func (m *openRC) Status(service string) (Status, error) {
+	// rc-service exits 3 for a stopped service and prints " * status: stopped"
+	out, err := m.r.Output(m.r.Command("rc-service", service, "status"))
+	text := string(out)
+	switch {
+	case strings.Contains(text, "status: started"):
+		return StatusActive, nil
+	case strings.Contains(text, "status: stopped"), strings.Contains(text, "status: stopping"), strings.Contains(text, "status: starting"):
+		return StatusInactive, nil
+	case strings.Contains(text, "status: crashed"):
+		return StatusFailed, nil
+	}
+	if err != nil {
+		return StatusUnknown, err
+	}
+	return StatusActive, nil
+}
@@ -0,0 +1,150 @@
+// Package servicemgr starts, stops and reloads PHP-FPM services through the
+// init system of an execution target: systemd, OpenRC (Alpine, Gentoo),
+// supervisord, or none at all in containers whose services are managed
+// from outside.
+package servicemgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"lightweight-php/config"
+	"lightweight-php/target"
+	"lightweight-php/tracing"
+)
+
+// Kinds of service managers, as set in server.service_manager
+const (
+	KindSystemd     = "systemd"
+	KindOpenRC      = "openrc"
+	KindSupervisord = "supervisord"
+	KindNone        = "none"
+)
+
+// Kinds lists the service managers that can be configured
+var Kinds = []string{KindSystemd, KindOpenRC, KindSupervisord, KindNone}
+
+// Status is the state of a service
+type Status string
+
+const (
+	StatusActive   Status = "active"
+	StatusInactive Status = "inactive"
+	StatusFailed   Status = "failed"
+	// StatusUnknown is reported where no service manager is in charge
+	StatusUnknown Status = "unknown"
+)
+
+// ErrUnsupported is returned for operations a service manager cannot do
+var ErrUnsupported = errors.New("not supported by the service manager")
+
+// Manager controls services of one target
+type Manager interface {
+	// Kind returns the service manager's name, one of Kinds
+	Kind() string
+	// Enable makes a service start at boot
+	Enable(service string) error
+	// Enabled reports whether a service starts at boot
+	Enabled(service string) bool
+	// Disable stops a service and keeps it from starting at boot
+	Disable(service string) error
+	Start(service string) error
+	Stop(service string) error
+	// Reload makes a service reread its configuration, restarting it when
+	// it cannot reload
+	Reload(service string) error
+	// ReloadOrRestart reloads a service that supports reloading and
+	// restarts any other, without trying a reload first
+	ReloadOrRestart(service string) error
+	// Signal sends a signal such as "USR2" to a service's main process
+	Signal(service, signal string) error
+	Status(service string) (Status, error)
+}
+
+// Runner runs commands inside the target the services live in
+type Runner interface {
+	Command(name string, args ...string) *exec.Cmd
+	Run(cmd *exec.Cmd) error
+	Output(cmd *exec.Cmd) ([]byte, error)
+}
+
+// New returns the service manager of a kind, running its commands with r
+func New(kind string, r Runner) (Manager, error) {
+	switch kind {
+	case KindSystemd:
+		return &systemd{r: r}, nil
+	case KindOpenRC:
+		return &openRC{r: r}, nil
+	case KindSupervisord:
+		return &supervisord{r: r}, nil
+	case KindNone:
+		return none{}, nil
+	}
+	return nil, fmt.Errorf("unknown service manager %q", kind)
+}
+
+// ForTarget returns the service manager of a target: the one set in
+// server.service_manager, or else the detected one
+func ForTarget(t target.Target, r Runner) Manager {
+	kind := config.Get().Server.ServiceManager
+	if kind == "" {
+		kind = Detect(t)
+	}
+	m, err := New(kind, r)
+	if err != nil {
+		// config validation rejects unknown kinds
+		return &systemd{r: r}
+	}
+	return m
+}
+
+// Detect finds the init system of a target. systemd counts only when it is
+// running (/run/systemd/system), since container images often ship
+// systemctl without it; a chroot has no init of its own and uses systemctl's
+// offline mode when it has one. Development mode always uses systemd, so
+// the command log shows what would run on a real host.
+func Detect(t target.Target) string {
+	if target.DevRoot() != "" {
+		return KindSystemd
+	}
+	if path, err := t.Path("/run/systemd/system"); err == nil {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return KindSystemd
+		}
+	}
+	if t.Kind == target.KindChroot && t.LookPath("systemctl") == nil {
+		return KindSystemd
+	}
+	if t.LookPath("openrc") == nil || t.LookPath("rc-service") == nil {
+		return KindOpenRC
+	}
+	if t.LookPath("supervisorctl") == nil {
+		return KindSupervisord
+	}
+	return KindNone
+}
+
+// TargetRunner runs commands in a target, traced under the span in ctx
+func TargetRunner(ctx context.Context, t target.Target) Runner {
+	return targetRunner{ctx: ctx, t: t}
+}
+
+type targetRunner struct {
+	ctx context.Context
+	t   target.Target
+}
+
+func (r targetRunner) Command(name string, args ...string) *exec.Cmd {
+	return r.t.Command(name, args...)
+}
+
+func (r targetRunner) Run(cmd *exec.Cmd) error {
+	return tracing.Run(r.ctx, cmd)
+}
+
+func (r targetRunner) Output(cmd *exec.Cmd) ([]byte, error) {
+	return tracing.Output(r.ctx, cmd)
+}
@@ -0,0 +1,78 @@
+package servicemgr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// supervisord drives programs with supervisorctl. Programs are defined in
+// supervisord's configuration, which also decides whether they start with
+// it, so enabling a program only checks that it is defined.
+type supervisord struct {
+	r Runner
+}
+
+func (m *supervisord) Kind() string {
+	return KindSupervisord
+}
+
+func (m *supervisord) Enable(service string) error {
+	if !m.Enabled(service) {
+		return fmt.Errorf("supervisord has no program %s; add a [program:%s] section for it", service, service)
+	}
+	return nil
+}
+
+func (m *supervisord) Enabled(service string) bool {
+	out, _ := m.r.Output(m.r.Command("supervisorctl", "status", service))
+	return !strings.Contains(string(out), "no such process") && !strings.Contains(string(out), "ERROR")
+}
+
+func (m *supervisord) Disable(service string) error {
+	return m.Stop(service)
+}
+
+func (m *supervisord) Start(service string) error {
+	return m.r.Run(m.r.Command("supervisorctl", "start", service))
+}
+
+func (m *supervisord) Stop(service string) error {
+	return m.r.Run(m.r.Command("supervisorctl", "stop", service))
+}
+
+func (m *supervisord) Reload(service string) error {
+	// USR2 makes the FPM master reload its configuration gracefully
+	if err := m.r.Run(m.r.Command("supervisorctl", "signal", "USR2", service)); err != nil {
+		return m.r.Run(m.r.Command("supervisorctl", "restart", service))
+	}
+	return nil
+}
+
+func (m *supervisord) ReloadOrRestart(service string) error {
+	return m.r.Run(m.r.Command("supervisorctl", "restart", service))
+}
+
+func (m *supervisord) Signal(service, signal string) error {
+	return m.r.Run(m.r.Command("supervisorctl", "signal", signal, service))
+}
+
+func (m *supervisord) Status(service string) (Status, error) {
+	// Lines read "php-fpm83   RUNNING   pid 42, uptime 1:02:03"; the exit
+	// code is non-zero for anything but RUNNING
+	out, err := m.r.Output(m.r.Command("supervisorctl", "status", service))
+	fields := strings.Fields(string(out))
+	if len(fields) >= 2 {
+		switch fields[1] {
+		case "RUNNING":
+			return StatusActive, nil
+		case "STOPPED", "STOPPING", "STARTING", "EXITED":
+			return StatusInactive, nil
+		case "FATAL", "BACKOFF":
+			return StatusFailed, nil
+		}
+	}
+	if err != nil {
+		return StatusUnknown, err
+	}
+	return StatusActive, nil
+}
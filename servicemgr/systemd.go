@@ -0,0 +1,66 @@
+package servicemgr
+
+import "strings"
+
+// systemd drives units with systemctl
+type systemd struct {
+	r Runner
+}
+
+func (m *systemd) Kind() string {
+	return KindSystemd
+}
+
+func (m *systemd) Enable(service string) error {
+	return m.r.Run(m.r.Command("systemctl", "enable", service))
+}
+
+func (m *systemd) Enabled(service string) bool {
+	return m.r.Run(m.r.Command("systemctl", "is-enabled", "--quiet", service)) == nil
+}
+
+func (m *systemd) Disable(service string) error {
+	return m.r.Run(m.r.Command("systemctl", "disable", "--now", service))
+}
+
+func (m *systemd) Start(service string) error {
+	return m.r.Run(m.r.Command("systemctl", "start", service))
+}
+
+func (m *systemd) Stop(service string) error {
+	return m.r.Run(m.r.Command("systemctl", "stop", service))
+}
+
+func (m *systemd) Reload(service string) error {
+	if err := m.r.Run(m.r.Command("systemctl", "reload", service)); err != nil {
+		return m.r.Run(m.r.Command("systemctl", "reload-or-restart", service))
+	}
+	return nil
+}
+
+func (m *systemd) ReloadOrRestart(service string) error {
+	return m.r.Run(m.r.Command("systemctl", "reload-or-restart", service))
+}
+
+func (m *systemd) Signal(service, signal string) error {
+	return m.r.Run(m.r.Command("systemctl", "kill", "--kill-whom=main", "--signal="+signal, service))
+}
+
+func (m *systemd) Status(service string) (Status, error) {
+	// is-active exits non-zero for anything but active; the state it
+	// prints is what counts
+	out, err := m.r.Output(m.r.Command("systemctl", "is-active", service))
+	switch strings.TrimSpace(string(out)) {
+	case "active", "reloading":
+		return StatusActive, nil
+	case "inactive", "deactivating", "activating":
+		return StatusInactive, nil
+	case "failed":
+		return StatusFailed, nil
+	}
+	if err != nil {
+		return StatusUnknown, err
+	}
+	// Development mode's executor prints nothing and succeeds
+	return StatusActive, nil
+}
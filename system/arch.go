@@ -0,0 +1,68 @@
+package system
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// Arch is a CPU architecture, named as uname -m and RPM repositories do
+type Arch string
+
+const (
+	ArchX86_64  Arch = "x86_64"
+	ArchAArch64 Arch = "aarch64"
+)
+
+// ErrUnsupportedArch is returned for architectures other than x86_64 and
+// aarch64
+var ErrUnsupportedArch = errors.New("unsupported architecture")
+
+// Debian returns the architecture's Debian name, as in apt sources
+func (a Arch) Debian() string {
+	if a == ArchAArch64 {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// Platform returns the architecture's Docker platform
+func (a Arch) Platform() string {
+	return "linux/" + a.Debian()
+}
+
+// archBinaries are read to tell the architecture of a root filesystem;
+// the first that exists decides
+var archBinaries = []string{"/bin/sh", "/usr/bin/env"}
+
+// DetectArch returns the architecture of the OS: the machine type of its
+// shell binary, so a chroot or container of another architecture is told
+// apart from the host. Without such a binary, as in the development
+// sandbox, the architecture this program was built for is assumed.
+func (d *OSDetector) DetectArch() (Arch, error) {
+	for _, name := range archBinaries {
+		f, err := elf.Open(filepath.Join(d.root, name))
+		if err != nil {
+			continue
+		}
+		machine := f.Machine
+		f.Close()
+		switch machine {
+		case elf.EM_X86_64:
+			return ArchX86_64, nil
+		case elf.EM_AARCH64:
+			return ArchAArch64, nil
+		}
+		return "", fmt.Errorf("%w: %s is built for %s; x86_64 and aarch64 are supported", ErrUnsupportedArch, name, machine)
+	}
+
+	switch runtime.GOARCH {
+	case "amd64":
+		return ArchX86_64, nil
+	case "arm64":
+		return ArchAArch64, nil
+	}
+	return "", fmt.Errorf("%w: %s; x86_64 and aarch64 are supported", ErrUnsupportedArch, runtime.GOARCH)
+}
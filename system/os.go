@@ -1,9 +1,15 @@
 package system
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 	"strings"
+
+	"lightweight-php/target"
 )
 
 type OSFamily string
@@ -11,39 +17,85 @@ type OSFamily string
 const (
 	OSRHEL   OSFamily = "rhel"
 	OSDebian OSFamily = "debian"
+	// OSSUSE is openSUSE Leap, Tumbleweed and SUSE Linux Enterprise
+	OSSUSE OSFamily = "suse"
 )
 
-type OSDetector struct{}
+// UsesRPM reports whether the family's packages are RPMs
+func (f OSFamily) UsesRPM() bool {
+	return f == OSRHEL || f == OSSUSE
+}
+
+// ErrUnsupportedOS is returned when the OS is not a RHEL, Debian or SUSE
+// family Linux distribution
+var ErrUnsupportedOS = errors.New("unsupported operating system")
+
+type OSDetector struct {
+	root string
+}
 
+// NewOSDetector detects the host OS, or the development sandbox's when
+// target.EnableDev is in effect
 func NewOSDetector() *OSDetector {
-	return &OSDetector{}
+	root, _ := target.Host.Root()
+	return &OSDetector{root: root}
+}
+
+// NewOSDetectorAt detects the OS installed under root, such as a chroot or
+// a container's root filesystem
+func NewOSDetectorAt(root string) *OSDetector {
+	return &OSDetector{root: root}
 }
 
+// Detect returns the OS family named by os-release's ID and ID_LIKE.
+// Without an os-release file, or one that names no known family, the
+// release files of each family and lsb_release are looked for instead.
 func (d *OSDetector) Detect() (OSFamily, error) {
+	if d.root == "/" && runtime.GOOS != "linux" {
+		return "", fmt.Errorf("%w: %s; PHP-FPM pools can only be managed on Linux (use --dev to run against a local sandbox)", ErrUnsupportedOS, runtime.GOOS)
+	}
+
+	if info, err := d.Info(); err == nil && info.Family != "" {
+		return info.Family, nil
+	}
+
 	// Check for /etc/redhat-release (RHEL, CentOS, Rocky, etc.)
-	if _, err := os.Stat("/etc/redhat-release"); err == nil {
+	if _, err := os.Stat(filepath.Join(d.root, "/etc/redhat-release")); err == nil {
 		return OSRHEL, nil
 	}
 
 	// Check for /etc/debian_version (Debian, Ubuntu, etc.)
-	if _, err := os.Stat("/etc/debian_version"); err == nil {
+	if _, err := os.Stat(filepath.Join(d.root, "/etc/debian_version")); err == nil {
 		return OSDebian, nil
 	}
 
-	// Try to detect via lsb_release
-	output, err := exec.Command("lsb_release", "-is").Output()
-	if err == nil {
-		distro := strings.ToLower(strings.TrimSpace(string(output)))
-		if strings.Contains(distro, "redhat") || strings.Contains(distro, "centos") ||
-			strings.Contains(distro, "rocky") || strings.Contains(distro, "alma") ||
-			strings.Contains(distro, "fedora") {
-			return OSRHEL, nil
-		}
-		if strings.Contains(distro, "debian") || strings.Contains(distro, "ubuntu") {
-			return OSDebian, nil
+	// Check for /etc/SUSE-brand (openSUSE, SLES)
+	if _, err := os.Stat(filepath.Join(d.root, "/etc/SUSE-brand")); err == nil {
+		return OSSUSE, nil
+	}
+
+	if d.root == "/" {
+		// Try to detect via lsb_release
+		output, err := exec.Command("lsb_release", "-is").Output()
+		if err == nil {
+			distro := strings.ToLower(strings.TrimSpace(string(output)))
+			if strings.Contains(distro, "redhat") || strings.Contains(distro, "centos") ||
+				strings.Contains(distro, "rocky") || strings.Contains(distro, "alma") ||
+				strings.Contains(distro, "fedora") {
+				return OSRHEL, nil
+			}
+			if strings.Contains(distro, "debian") || strings.Contains(distro, "ubuntu") {
+				return OSDebian, nil
+			}
+			if strings.Contains(distro, "suse") || strings.Contains(distro, "sles") {
+				return OSSUSE, nil
+			}
 		}
 	}
 
-	// Default to RHEL if uncertain
-	return OSRHEL, nil
+	where := ""
+	if d.root != "/" {
+		where = " in " + d.root
+	}
+	return "", fmt.Errorf("%w: no RHEL, Debian or SUSE family distribution found%s (no known ID in /etc/os-release, and missing /etc/redhat-release, /etc/debian_version and /etc/SUSE-brand)", ErrUnsupportedOS, where)
 }
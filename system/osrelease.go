@@ -0,0 +1,111 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// OSInfo is what /etc/os-release says about a distribution
+type OSInfo struct {
+	// ID is the distribution, as in rocky, ubuntu or opensuse-leap
+	ID string `json:"id"`
+	// IDLike lists the distributions it derives from, closest first
+	IDLike []string `json:"id_like,omitempty"`
+	// Name is the human-readable PRETTY_NAME
+	Name string `json:"name,omitempty"`
+	// VersionID is the release, as in 9.4, 22.04 or 15.6; rolling
+	// releases have none
+	VersionID string `json:"version_id,omitempty"`
+	// Codename is VERSION_CODENAME, or UBUNTU_CODENAME on derivatives
+	// that only set that
+	Codename string `json:"codename,omitempty"`
+	// Family is the family told from ID and ID_LIKE, "" when unknown
+	Family OSFamily `json:"family,omitempty"`
+}
+
+// osReleaseFiles are read for os-release; the first that exists decides
+var osReleaseFiles = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// familyIDs maps os-release IDs to their family
+var familyIDs = map[string]OSFamily{
+	"rhel":                OSRHEL,
+	"centos":              OSRHEL,
+	"fedora":              OSRHEL,
+	"rocky":               OSRHEL,
+	"almalinux":           OSRHEL,
+	"ol":                  OSRHEL,
+	"cloudlinux":          OSRHEL,
+	"debian":              OSDebian,
+	"ubuntu":              OSDebian,
+	"suse":                OSSUSE,
+	"opensuse":            OSSUSE,
+	"sles":                OSSUSE,
+	"opensuse-leap":       OSSUSE,
+	"opensuse-tumbleweed": OSSUSE,
+}
+
+// Major returns the major release, as in 9 for 9.4, or 0 when there is
+// none
+func (i *OSInfo) Major() int {
+	major, _, _ := strings.Cut(i.VersionID, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// ParseOSRelease parses the content of an os-release file
+func ParseOSRelease(content string) *OSInfo {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		fields[key] = value
+	}
+
+	info := &OSInfo{
+		ID:        fields["ID"],
+		IDLike:    strings.Fields(fields["ID_LIKE"]),
+		Name:      fields["PRETTY_NAME"],
+		VersionID: fields["VERSION_ID"],
+		Codename:  fields["VERSION_CODENAME"],
+	}
+	if info.Name == "" {
+		info.Name = fields["NAME"]
+	}
+	if info.Codename == "" {
+		info.Codename = fields["UBUNTU_CODENAME"]
+	}
+	for _, id := range append([]string{info.ID}, info.IDLike...) {
+		if family, ok := familyIDs[id]; ok {
+			info.Family = family
+			break
+		}
+	}
+	return info
+}
+
+// Info reads the os-release of the OS
+func (d *OSDetector) Info() (*OSInfo, error) {
+	for _, name := range osReleaseFiles {
+		content, err := os.ReadFile(filepath.Join(d.root, name))
+		if err == nil {
+			return ParseOSRelease(string(content)), nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+	return nil, fmt.Errorf("no os-release file found in %s", d.root)
+}
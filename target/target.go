@@ -0,0 +1,302 @@
+package target
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of execution targets
+const (
+	KindHost   = ""
+	KindChroot = "chroot"
+	KindNspawn = "nspawn"
+	KindLXC    = "lxc"
+)
+
+// Host is the machine lightweight-php runs on
+var Host = Target{}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// dev is set by EnableDev
+var dev struct {
+	root     string
+	executor []string
+}
+
+// EnableDev turns the host into a sandbox for development on machines
+// without root or systemd: host paths resolve under root, every command is
+// handed to executor (which records it and succeeds) and any username
+// resolves to the current user.
+func EnableDev(root string, executor ...string) {
+	dev.root = root
+	dev.executor = executor
+}
+
+// DevRoot returns the sandbox directory, or "" outside development mode
+func DevRoot() string {
+	return dev.root
+}
+
+// Target is where PHP is installed and pools run: the host, a chroot
+// directory, a systemd-nspawn machine or an LXC container. It is written as
+// "chroot:/srv/jail", "nspawn:web1" or "lxc:web1"; "" and "host" mean the host.
+type Target struct {
+	Kind string
+	Name string
+}
+
+// Parse reads a target written by String
+func Parse(spec string) (Target, error) {
+	if spec == "" || spec == "host" {
+		return Host, nil
+	}
+	kind, name, ok := strings.Cut(spec, ":")
+	if !ok || name == "" {
+		return Host, fmt.Errorf("invalid target %q; expected host, chroot:DIR, nspawn:NAME or lxc:NAME", spec)
+	}
+	switch kind {
+	case KindChroot:
+		if !filepath.IsAbs(name) {
+			return Host, fmt.Errorf("invalid target %q: chroot directory must be absolute", spec)
+		}
+		name = filepath.Clean(name)
+	case KindNspawn, KindLXC:
+		if !namePattern.MatchString(name) {
+			return Host, fmt.Errorf("invalid target %q: invalid container name", spec)
+		}
+	default:
+		return Host, fmt.Errorf("invalid target kind %q; expected chroot, nspawn or lxc", kind)
+	}
+	return Target{Kind: kind, Name: name}, nil
+}
+
+// String returns the target in the form accepted by Parse; "" for the host
+func (t Target) String() string {
+	if t.IsHost() {
+		return ""
+	}
+	return t.Kind + ":" + t.Name
+}
+
+// IsHost reports whether t is the host itself
+func (t Target) IsHost() bool {
+	return t.Kind == KindHost
+}
+
+// Command returns a command that runs name inside the target
+func (t Target) Command(name string, args ...string) *exec.Cmd {
+	switch t.Kind {
+	case KindChroot:
+		args = append([]string{t.Name, name}, args...)
+		name = "chroot"
+	case KindNspawn:
+		// machinectl shell needs an absolute path; env searches the machine's PATH
+		args = append([]string{"shell", "--quiet", t.Name, "/usr/bin/env", name}, args...)
+		name = "machinectl"
+	case KindLXC:
+		args = append([]string{"exec", t.Name, "--", name}, args...)
+		name = "lxc"
+	}
+	if dev.root != "" {
+		prefix := append(append([]string{}, dev.executor[1:]...), name)
+		args = append(prefix, args...)
+		name = dev.executor[0]
+	}
+	return exec.Command(name, args...)
+}
+
+// Root returns the host path of the target's root directory. Containers are
+// reached through their init process, which also exposes their /run.
+func (t Target) Root() (string, error) {
+	if dev.root != "" && t.IsHost() {
+		return dev.root, nil
+	}
+	switch t.Kind {
+	case KindChroot:
+		return t.Name, nil
+	case KindNspawn, KindLXC:
+		pid, err := t.leaderPID()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("/proc/%d/root", pid), nil
+	}
+	return "/", nil
+}
+
+// Path returns the host path of a path inside the target
+func (t Target) Path(path string) (string, error) {
+	if t.IsHost() && dev.root == "" {
+		return path, nil
+	}
+	root, err := t.Root()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, path), nil
+}
+
+// LookPath reports whether a command exists on the target's PATH
+func (t Target) LookPath(name string) error {
+	if dev.root != "" {
+		// The executor stands in for every command
+		return nil
+	}
+	if t.IsHost() {
+		_, err := exec.LookPath(name)
+		return err
+	}
+	root, err := t.Root()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"} {
+		if info, err := os.Stat(filepath.Join(root, dir, name)); err == nil && !info.IsDir() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s not found in %s", name, t)
+}
+
+// LookupUser finds a user in the target's passwd database. Uid and Gid are
+// the IDs inside the target; see HostIDs for chown from the host.
+func (t Target) LookupUser(username string) (*user.User, error) {
+	if dev.root != "" && t.IsHost() {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		return &user.User{Username: username, Uid: current.Uid, Gid: current.Gid, Name: username, HomeDir: "/home/" + username}, nil
+	}
+	if t.IsHost() {
+		return user.Lookup(username)
+	}
+	fields, err := t.findEntry("/etc/passwd", 0, username)
+	if err != nil {
+		return nil, err
+	}
+	if fields == nil || len(fields) < 6 {
+		return nil, user.UnknownUserError(username)
+	}
+	return &user.User{Username: fields[0], Uid: fields[2], Gid: fields[3], Name: fields[4], HomeDir: fields[5]}, nil
+}
+
+// LookupGroupName returns the name of a group ID inside the target
+func (t Target) LookupGroupName(gid string) (string, error) {
+	if t.IsHost() {
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	}
+	fields, err := t.findEntry("/etc/group", 2, gid)
+	if err != nil {
+		return "", err
+	}
+	if fields == nil {
+		return "", user.UnknownGroupIdError(gid)
+	}
+	return fields[0], nil
+}
+
+// HostIDs maps a uid and gid inside the target to the IDs the host sees,
+// which differ in user-namespaced (unprivileged) containers
+func (t Target) HostIDs(uid, gid int) (int, int, error) {
+	if t.Kind != KindNspawn && t.Kind != KindLXC {
+		return uid, gid, nil
+	}
+	pid, err := t.leaderPID()
+	if err != nil {
+		return 0, 0, err
+	}
+	hostUID, err := mapID(fmt.Sprintf("/proc/%d/uid_map", pid), uid)
+	if err != nil {
+		return 0, 0, err
+	}
+	hostGID, err := mapID(fmt.Sprintf("/proc/%d/gid_map", pid), gid)
+	if err != nil {
+		return 0, 0, err
+	}
+	return hostUID, hostGID, nil
+}
+
+// leaderPID returns the host PID of the container's init process
+func (t Target) leaderPID() (int, error) {
+	if t.Kind == KindNspawn {
+		output, err := exec.Command("machinectl", "show", t.Name, "--property=Leader", "--value").Output()
+		if err != nil {
+			return 0, fmt.Errorf("machine %s is not running: %w", t.Name, err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(output)))
+		if err != nil || pid <= 0 {
+			return 0, fmt.Errorf("machine %s is not running", t.Name)
+		}
+		return pid, nil
+	}
+
+	output, err := exec.Command("lxc", "query", "/1.0/instances/"+t.Name+"/state").Output()
+	if err != nil {
+		return 0, fmt.Errorf("container %s not found: %w", t.Name, err)
+	}
+	var state struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(output, &state); err != nil {
+		return 0, fmt.Errorf("failed to read state of container %s: %w", t.Name, err)
+	}
+	if state.PID <= 0 {
+		return 0, fmt.Errorf("container %s is not running", t.Name)
+	}
+	return state.PID, nil
+}
+
+// findEntry returns the fields of the first line of a colon-separated
+// database in the target whose field at index equals value
+func (t Target) findEntry(path string, index int, value string) ([]string, error) {
+	hostPath, err := t.Path(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s in %s: %w", path, t, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) > index && fields[index] == value {
+			return fields, nil
+		}
+	}
+	return nil, scanner.Err()
+}
+
+// mapID translates an ID through a /proc/PID/{uid,gid}_map file
+func mapID(mapFile string, id int) (int, error) {
+	content, err := os.ReadFile(mapFile)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		var inside, outside, count int
+		if n, _ := fmt.Sscan(line, &inside, &outside, &count); n != 3 {
+			continue
+		}
+		if id >= inside && id < inside+count {
+			return outside + id - inside, nil
+		}
+	}
+	return 0, fmt.Errorf("id %d is not mapped by %s", id, mapFile)
+}
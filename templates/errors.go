@@ -0,0 +1,101 @@
+package templates
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RenderError describes a template that failed to parse or execute, with
+// the position and field text/template reported
+type RenderError struct {
+	Template string `json:"template"`
+	// Source is "embedded", "override" or "candidate" for content that is
+	// neither, such as a file being validated
+	Source string `json:"source"`
+	// Line and Column are 1-based; 0 when the error has no position
+	Line   int `json:"line,omitempty"`
+	Column int `json:"column,omitempty"`
+	// Field is the action that failed, e.g. ".MemoryLimit"
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+func (e *RenderError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "template %s (%s)", e.Template, e.Source)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " line %d", e.Line)
+	}
+	if e.Field != "" {
+		fmt.Fprintf(&b, " field %s", e.Field)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// text/template reports "template: NAME:LINE[:COL]: MESSAGE"; execution
+// errors add `executing "NAME" at <FIELD>: ` before the message
+var (
+	templateErrorPattern = regexp.MustCompile(`^template: [^:]+:(\d+)(?::(\d+))?: (.*)$`)
+	executingPattern     = regexp.MustCompile(`^executing "[^"]*" at <([^>]*)>: (.*)$`)
+)
+
+func newRenderError(name, content string, err error) *RenderError {
+	re := &RenderError{Template: name, Source: Source(name, content), Message: err.Error(), Err: err}
+	m := templateErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return re
+	}
+	re.Line, _ = strconv.Atoi(m[1])
+	re.Column, _ = strconv.Atoi(m[2])
+	re.Message = m[3]
+	if m := executingPattern.FindStringSubmatch(re.Message); m != nil {
+		re.Field, re.Message = m[1], m[2]
+	}
+	return re
+}
+
+// Source tells whether content is the embedded template, the active
+// override or something else ("candidate")
+func Source(name, content string) string {
+	if content == embeddedTemplates[name] {
+		return "embedded"
+	}
+	if override, path, err := readOverride(name); err == nil && path != "" && override == content {
+		return "override"
+	}
+	return "candidate"
+}
+
+// Excerpt returns the lines of content around line, numbered, with the
+// given line marked; it is empty when line is out of range
+func Excerpt(content string, line, context int) string {
+	lines := strings.Split(content, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	first, last := line-context, line+context
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	var b strings.Builder
+	for n := first; n <= last; n++ {
+		marker := "  "
+		if n == line {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%4d | %s\n", marker, n, lines[n-1])
+	}
+	return b.String()
+}
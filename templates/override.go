@@ -0,0 +1,155 @@
+package templates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// OverrideDir holds administrator copies of templates that take precedence
+// over the embedded versions, using the same file names
+const OverrideDir = "/etc/lightweight-php/templates"
+
+// TemplateInfo describes where a template is loaded from
+type TemplateInfo struct {
+	Name string
+	// Source is "embedded" or "override"
+	Source string
+	Path   string
+}
+
+// ListTemplates reports every known template and its effective source
+func ListTemplates() ([]TemplateInfo, error) {
+	names := make([]string, 0, len(embeddedTemplates))
+	for name := range embeddedTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]TemplateInfo, 0, len(names))
+	for _, name := range names {
+		info := TemplateInfo{Name: name, Source: "embedded"}
+		if _, path, err := readOverride(name); err != nil {
+			return nil, err
+		} else if path != "" {
+			info.Source = "override"
+			info.Path = path
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Override returns the administrator override of a template as it is on
+// disk, without validating it, or "" when there is none
+func Override(name string) (string, error) {
+	content, _, err := readOverride(name)
+	return content, err
+}
+
+// ValidateTemplate parses content and renders it against sample data for
+// the named template, so unknown fields and syntax errors surface before
+// the template is used on a real pool
+func ValidateTemplate(name, content string) error {
+	sample, ok := sampleData(name)
+	if !ok {
+		return fmt.Errorf("template %s not found", name)
+	}
+	_, err := render(name, content, sample)
+	return err
+}
+
+// readOverride returns the override for name and its path, or an empty path
+// when there is none
+func readOverride(name string) (string, string, error) {
+	path := filepath.Join(OverrideDir, name)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read template override %s: %w", path, err)
+	}
+	return string(content), path, nil
+}
+
+func sampleData(name string) (interface{}, bool) {
+	switch name {
+	case "pool.conf.tmpl":
+		data := DefaultPoolConfigData("example", "example", "/run/php-fpm/example.sock")
+		data.ListenAllowedClients = "127.0.0.1,::1"
+		data.APCuEnabled = "1"
+		data.DisableFunctions = "exec,system"
+		data.ExposePHP = "0"
+		data.AllowURLFopen = "0"
+		data.SessionCookieHTTPOnly = "1"
+		data.SessionCookieSecure = "1"
+		data.SessionCookieSameSite = "Lax"
+		data.SessionUseStrictMode = "1"
+		data.ClearEnv = "yes"
+		data.Env = []EnvVar{{Name: "APP_ENV", Value: "production"}}
+		return data, true
+	case "pool-suspended.conf.tmpl":
+		return &SuspendedPoolConfigData{
+			PoolConfigData:    DefaultPoolConfigData("example", "example", "/run/php-fpm/example.sock"),
+			PlaceholderScript: "/etc/lightweight-php/suspended.php",
+		}, true
+	case "opcache.ini.tmpl":
+		return &OpcacheConfigData{
+			MemoryConsumption:   "128",
+			MaxAcceleratedFiles: "10000",
+			ValidateTimestamps:  "1",
+			JIT:                 "tracing",
+			JITBufferSize:       "64M",
+		}, true
+	case "fpm-global.conf.tmpl":
+		return &FPMGlobalConfigData{
+			ErrorLog:                  "/var/log/php-fpm/error.log",
+			LogLevel:                  "notice",
+			Daemonize:                 "yes",
+			EmergencyRestartThreshold: "10",
+			EmergencyRestartInterval:  "60",
+			ProcessControlTimeout:     "10",
+			ProcessMax:                "128",
+		}, true
+	case "lsws-app.conf.tmpl":
+		return &LiteSpeedAppData{
+			Name:       "lsphp82-example",
+			Username:   "example",
+			Group:      "example",
+			PHPVersion: "8.2",
+			Address:    "uds://tmp/lsphp82-example.sock",
+			Binary:     "/usr/local/lsws/lsphp82/bin/lsphp",
+			MaxConns:   10,
+			Env:        []EnvVar{{Name: "APP_ENV", Value: "production"}},
+		}, true
+	case "Dockerfile.tmpl":
+		return &DockerfileData{
+			BaseImage:      "php:8.2-fpm",
+			Username:       "example",
+			Group:          "example",
+			UID:            "1000",
+			GID:            "1000",
+			HomeDir:        "/home/example",
+			PHPVersion:     "8.2",
+			Packages:       []string{"libpng-dev", "libjpeg62-turbo-dev", "libfreetype6-dev"},
+			Extensions:     []ImageExtension{{Name: "gd", Configure: "--with-freetype --with-jpeg"}, {Name: "pdo_mysql"}},
+			PECLExtensions: []string{"redis"},
+			Ini:            []IniSetting{{Name: "memory_limit", Value: "256M"}},
+		}, true
+	case "nginx-site.conf.tmpl":
+		return &SiteConfigData{
+			Domain:             "example.com",
+			Username:           "example",
+			DocumentRoot:       "/home/example/public_html",
+			CertificatePath:    "/etc/lightweight-php/certs/example.com/fullchain.pem",
+			CertificateKeyPath: "/etc/lightweight-php/certs/example.com/privkey.pem",
+			Locations: []SiteLocation{
+				{PathPrefix: "/legacy/", PHPVersion: "7.4", FastCGIPass: "unix:/run/php/legacy.sock"},
+				{PathPrefix: "/", PHPVersion: "8.2", FastCGIPass: "unix:/run/php/example.sock", Default: true},
+			},
+		}, true
+	}
+	return nil, false
+}
@@ -4,89 +4,318 @@ import (
 	"bytes"
 	_ "embed"
 	"fmt"
+	"path/filepath"
+	"strings"
 	"text/template"
 )
 
+const (
+	// SessionBaseDir holds one session directory per pool user
+	SessionBaseDir = "/var/lib/php/sessions"
+	// TmpBaseDir holds one temporary/upload directory per pool user
+	TmpBaseDir = "/var/lib/php/tmp"
+	// PoolStatusPath is the PHP-FPM status page of every pool. nginx only
+	// passes .php requests to pools, so it is reachable only through the
+	// pool's own listener.
+	PoolStatusPath = "/lightweight-php-status"
+)
+
 //go:embed pool.conf.tmpl
 var defaultPoolTemplate string
 
+//go:embed opcache.ini.tmpl
+var defaultOpcacheTemplate string
+
+//go:embed nginx-site.conf.tmpl
+var defaultSiteTemplate string
+
+//go:embed pool-suspended.conf.tmpl
+var defaultSuspendedPoolTemplate string
+
+//go:embed fpm-global.conf.tmpl
+var defaultFPMGlobalTemplate string
+
+//go:embed lsws-app.conf.tmpl
+var defaultLiteSpeedAppTemplate string
+
+//go:embed Dockerfile.tmpl
+var defaultDockerfileTemplate string
+
 // PoolConfigData holds the data for pool configuration template
 type PoolConfigData struct {
-	PoolName            string
-	Username            string
-	Group               string
-	SocketPath          string
-	ListenMode          string
-	ProcessManager      string
-	MaxChildren         int
-	StartServers         int
-	MinSpareServers      int
-	MaxSpareServers      int
-	MaxRequests          int
-	ProcessIdleTimeout   string
-	SendmailPath         string
-	DisplayErrors        string
-	ErrorLog             string
-	LogErrors            string
-	MemoryLimit          string
-	MaxExecutionTime     string
-	UploadMaxFilesize    string
-	PostMaxSize          string
-	DateTimezone         string
+	PoolName                   string
+	Username                   string
+	Group                      string
+	SocketPath                 string
+	ListenMode                 string
+	ListenAllowedClients       string
+	ListenTCP                  bool // SocketPath is an address and port
+	ProcessManager             string
+	MaxChildren                int
+	StartServers               int
+	MinSpareServers            int
+	MaxSpareServers            int
+	MaxRequests                int
+	ProcessIdleTimeout         string
+	StatusPath                 string
+	SendmailPath               string
+	DisplayErrors              string
+	ErrorLog                   string
+	LogErrors                  string
+	MemoryLimit                string
+	MaxExecutionTime           string
+	UploadMaxFilesize          string
+	PostMaxSize                string
+	DateTimezone               string
+	SessionSavePath            string
+	SysTempDir                 string
+	UploadTmpDir               string
+	OpcacheMemoryConsumption   string
+	OpcacheMaxAcceleratedFiles string
+	OpcacheValidateTimestamps  string
+	OpcacheJIT                 string
+	APCuEnabled                string
+	DisableFunctions           string
+	ExposePHP                  string
+	AllowURLFopen              string
+	SessionCookieHTTPOnly      string
+	SessionCookieSecure        string
+	SessionCookieSameSite      string
+	SessionUseStrictMode       string
+	// ClearEnv is "yes" or "no"; empty keeps PHP-FPM's default (yes)
+	ClearEnv string
+	Env      []EnvVar
+	// CustomConfig are verbatim lines appended to the pool
+	CustomConfig []string
+}
+
+// EnvVar is an env[...] directive of a pool
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// SuspendedPoolConfigData holds the data for the placeholder pool of a
+// suspended account
+type SuspendedPoolConfigData struct {
+	*PoolConfigData
+	// PlaceholderScript is prepended to every request and answers it with 503
+	PlaceholderScript string
+}
+
+// OpcacheConfigData holds the data for the per-version opcache ini template
+type OpcacheConfigData struct {
+	MemoryConsumption   string
+	MaxAcceleratedFiles string
+	ValidateTimestamps  string
+	JIT                 string
+	JITBufferSize       string
+}
+
+// FPMGlobalConfigData holds the managed [global] settings of a version's
+// php-fpm.conf; empty values are left to the distro's file
+type FPMGlobalConfigData struct {
+	ErrorLog                  string
+	LogLevel                  string
+	Daemonize                 string
+	EmergencyRestartThreshold string
+	EmergencyRestartInterval  string
+	ProcessControlTimeout     string
+	ProcessMax                string
+}
+
+// LiteSpeedAppData holds the data for the OpenLiteSpeed external app of an
+// lsphp pool
+type LiteSpeedAppData struct {
+	Name       string
+	Username   string
+	Group      string
+	PHPVersion string
+	// Address is where lsphp listens: uds://PATH or HOST:PORT
+	Address  string
+	Binary   string
+	MaxConns int
+	Env      []EnvVar
+}
+
+// DockerfileData holds the data for the Dockerfile of a Docker pool's
+// image
+type DockerfileData struct {
+	BaseImage  string
+	Username   string
+	Group      string
+	UID        string
+	GID        string
+	HomeDir    string
+	PHPVersion string
+	// Packages are the Debian packages extensions are built against
+	Packages []string
+	// Extensions are built from the PHP source in the image
+	Extensions []ImageExtension
+	// PECLExtensions are installed with pecl
+	PECLExtensions []string
+	Ini            []IniSetting
+}
+
+// ImageExtension is an extension built with docker-php-ext-install;
+// Configure holds its docker-php-ext-configure arguments, if any
+type ImageExtension struct {
+	Name      string
+	Configure string
+}
+
+// IniSetting is a php.ini directive
+type IniSetting struct {
+	Name  string
+	Value string
+}
+
+// SiteConfigData holds the data for the nginx site snippet template
+type SiteConfigData struct {
+	Domain       string
+	Username     string
+	DocumentRoot string
+	Locations    []SiteLocation
+	// CertificatePath and CertificateKeyPath are set once an ACME
+	// certificate was issued for the site
+	CertificatePath    string
+	CertificateKeyPath string
+	// ProxyPass is set once the site's account migrated to another
+	// server: every request is proxied there instead of served locally
+	ProxyPass string
+}
+
+// SiteLocation routes PHP requests under PathPrefix to a pool.
+// Default marks the "/" binding, which catches all remaining paths.
+type SiteLocation struct {
+	PathPrefix  string
+	PHPVersion  string
+	FastCGIPass string
+	Default     bool
 }
 
 // DefaultPoolConfigData returns default values for pool configuration
 func DefaultPoolConfigData(username, group, socketPath string) *PoolConfigData {
 	return &PoolConfigData{
-		PoolName:          username,
-		Username:          username,
-		Group:             group,
-		SocketPath:        socketPath,
-		ListenMode:        "0660",
-		ProcessManager:    "dynamic",
-		MaxChildren:       50,
-		StartServers:      5,
-		MinSpareServers:   5,
-		MaxSpareServers:   35,
-		MaxRequests:       500,
+		PoolName:           username,
+		Username:           username,
+		Group:              group,
+		SocketPath:         socketPath,
+		ListenTCP:          !strings.HasPrefix(socketPath, "/"),
+		ListenMode:         "0660",
+		ProcessManager:     "dynamic",
+		MaxChildren:        50,
+		StartServers:       5,
+		MinSpareServers:    5,
+		MaxSpareServers:    35,
+		MaxRequests:        500,
 		ProcessIdleTimeout: "",
-		SendmailPath:      "/usr/sbin/sendmail -t -i -f www@my.domain.com",
+		StatusPath:         PoolStatusPath,
+		SendmailPath:       "/usr/sbin/sendmail -t -i -f www@my.domain.com",
 		DisplayErrors:      "off",
-		ErrorLog:          fmt.Sprintf("/var/log/fpm-php.%s.log", username),
-		LogErrors:         "on",
-		MemoryLimit:       "128M",
-		MaxExecutionTime:  "",
-		UploadMaxFilesize: "",
-		PostMaxSize:       "",
-		DateTimezone:      "",
+		ErrorLog:           fmt.Sprintf("/var/log/fpm-php.%s.log", username),
+		LogErrors:          "on",
+		MemoryLimit:        "128M",
+		MaxExecutionTime:   "",
+		UploadMaxFilesize:  "",
+		PostMaxSize:        "",
+		DateTimezone:       "",
+		SessionSavePath:    filepath.Join(SessionBaseDir, username),
+		SysTempDir:         filepath.Join(TmpBaseDir, username),
+		UploadTmpDir:       filepath.Join(TmpBaseDir, username),
 	}
 }
 
 // RenderPoolConfig renders the pool configuration template with the provided data
 func RenderPoolConfig(templateContent string, data *PoolConfigData) (string, error) {
-	tmpl, err := template.New("pool").Parse(templateContent)
+	return render("pool.conf.tmpl", templateContent, data)
+}
+
+// RenderSuspendedPoolConfig renders the placeholder pool template with the provided data
+func RenderSuspendedPoolConfig(templateContent string, data *SuspendedPoolConfigData) (string, error) {
+	return render("pool-suspended.conf.tmpl", templateContent, data)
+}
+
+// RenderOpcacheConfig renders the opcache ini template with the provided data
+func RenderOpcacheConfig(templateContent string, data *OpcacheConfigData) (string, error) {
+	return render("opcache.ini.tmpl", templateContent, data)
+}
+
+// RenderFPMGlobalConfig renders the managed php-fpm.conf settings template with the provided data
+func RenderFPMGlobalConfig(templateContent string, data *FPMGlobalConfigData) (string, error) {
+	return render("fpm-global.conf.tmpl", templateContent, data)
+}
+
+// RenderLiteSpeedApp renders the OpenLiteSpeed external app template with the provided data
+func RenderLiteSpeedApp(templateContent string, data *LiteSpeedAppData) (string, error) {
+	return render("lsws-app.conf.tmpl", templateContent, data)
+}
+
+// RenderDockerfile renders the Docker pool image template with the provided data
+func RenderDockerfile(templateContent string, data *DockerfileData) (string, error) {
+	return render("Dockerfile.tmpl", templateContent, data)
+}
+
+// RenderSiteConfig renders the nginx site snippet template with the provided data
+func RenderSiteConfig(templateContent string, data *SiteConfigData) (string, error) {
+	return render("nginx-site.conf.tmpl", templateContent, data)
+}
+
+// render parses and executes a template; failures are *RenderError
+func render(name, templateContent string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Parse(templateContent)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		return "", newRenderError(name, templateContent, err)
 	}
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+		return "", newRenderError(name, templateContent, err)
 	}
 
 	return buf.String(), nil
 }
 
-// LoadTemplate loads a template from embedded files
+// embeddedTemplates maps template names to the versions built into the binary
+var embeddedTemplates = map[string]string{
+	"pool.conf.tmpl":           defaultPoolTemplate,
+	"opcache.ini.tmpl":         defaultOpcacheTemplate,
+	"nginx-site.conf.tmpl":     defaultSiteTemplate,
+	"pool-suspended.conf.tmpl": defaultSuspendedPoolTemplate,
+	"fpm-global.conf.tmpl":     defaultFPMGlobalTemplate,
+	"lsws-app.conf.tmpl":       defaultLiteSpeedAppTemplate,
+	"Dockerfile.tmpl":          defaultDockerfileTemplate,
+}
+
+// LoadTemplate loads a template, preferring an administrator override in
+// OverrideDir over the embedded version. An override that fails validation
+// is an error rather than silently falling back, so enforced defaults are
+// never bypassed.
 func LoadTemplate(name string) (string, error) {
-	templates := map[string]string{
-		"pool.conf.tmpl": defaultPoolTemplate,
+	content, ok := embeddedTemplates[name]
+	if !ok {
+		return "", fmt.Errorf("template %s not found", name)
 	}
 
-	content, ok := templates[name]
+	override, path, err := readOverride(name)
+	if err != nil {
+		return "", err
+	}
+	if path == "" {
+		return content, nil
+	}
+
+	if err := ValidateTemplate(name, override); err != nil {
+		return "", fmt.Errorf("invalid template override %s: %w", path, err)
+	}
+	return override, nil
+}
+
+// EmbeddedTemplate returns the built-in version of a template
+func EmbeddedTemplate(name string) (string, error) {
+	content, ok := embeddedTemplates[name]
 	if !ok {
 		return "", fmt.Errorf("template %s not found", name)
 	}
-
 	return content, nil
 }
@@ -0,0 +1,285 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lightweight-php/config"
+)
+
+const (
+	// queueSize bounds the finished spans waiting for export; spans are
+	// dropped rather than blocking the operations being traced
+	queueSize = 2048
+	// batchSize is the most spans sent in one export
+	batchSize = 512
+	// flushInterval is how long finished spans wait for a batch to fill
+	flushInterval = 5 * time.Second
+)
+
+type exporter struct {
+	url       string
+	headers   map[string]string
+	service   string
+	threshold uint64
+	client    *http.Client
+	queue     chan *Span
+	flush     chan chan struct{}
+	done      chan struct{}
+}
+
+// Setup starts exporting spans as configured. Tracing stays off when no
+// endpoint is configured.
+func Setup(cfg config.TracingConfig) error {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return nil
+	}
+	if !strings.HasPrefix(endpoint, "https://") && !strings.HasPrefix(endpoint, "http://") {
+		return fmt.Errorf("invalid OTLP endpoint %q: must be an http or https URL", endpoint)
+	}
+
+	e := &exporter{
+		url:       strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:   cfg.Headers,
+		service:   cfg.ServiceName,
+		threshold: sampleThreshold(cfg.SampleRatio),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		queue:     make(chan *Span, queueSize),
+		flush:     make(chan chan struct{}),
+		done:      make(chan struct{}),
+	}
+	tracerMu.Lock()
+	previous := tracer
+	tracer = e
+	tracerMu.Unlock()
+	if previous != nil {
+		previous.stop(context.Background())
+	}
+	go e.loop()
+	return nil
+}
+
+// Enabled reports whether spans are being recorded
+func Enabled() bool {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer != nil
+}
+
+// Shutdown stops tracing and sends the spans still queued, waiting until
+// ctx is done at most
+func Shutdown(ctx context.Context) {
+	tracerMu.Lock()
+	e := tracer
+	tracer = nil
+	tracerMu.Unlock()
+	if e != nil {
+		e.stop(ctx)
+	}
+}
+
+func (e *exporter) stop(ctx context.Context) {
+	flushed := make(chan struct{})
+	select {
+	case e.flush <- flushed:
+		select {
+		case <-flushed:
+		case <-ctx.Done():
+		}
+	case <-ctx.Done():
+	}
+	close(e.done)
+}
+
+// sample keeps a new trace when the low bits of its ID fall under the
+// threshold, so every service sampling at the same ratio agrees
+func (e *exporter) sample(traceID [16]byte) bool {
+	if e.threshold == 0 {
+		return false
+	}
+	return binary.BigEndian.Uint64(traceID[8:]) <= e.threshold
+}
+
+func (e *exporter) enqueue(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+func (e *exporter) loop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to export %d spans: %v\n", len(batch), err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case flushed := <-e.flush:
+			for drained := false; !drained; {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+					if len(batch) >= batchSize {
+						send()
+					}
+				default:
+					drained = true
+				}
+			}
+			send()
+			close(flushed)
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// export posts spans as an OTLP ExportTraceServiceRequest
+func (e *exporter) export(spans []*Span) error {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": e.resource()},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "lightweight-php"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (e *exporter) resource() []otlpAttr {
+	attrs := []otlpAttr{attr("service.name", e.service)}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, attr("host.name", host))
+	}
+	return attrs
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != nil {
+		span.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+	}
+	keys := make([]string, 0, len(s.attrs))
+	for k := range s.attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		span.Attributes = append(span.Attributes, attr(k, s.attrs[k]))
+	}
+	return span
+}
+
+// attr encodes an attribute as an OTLP AnyValue; 64-bit integers are
+// strings in OTLP's JSON mapping
+func attr(key string, value interface{}) otlpAttr {
+	var v map[string]interface{}
+	switch value := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": value}
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return otlpAttr{Key: key, Value: v}
+}
@@ -0,0 +1,233 @@
+// Package tracing records spans of API requests, manager operations and the
+// commands they run, and exports them to an OpenTelemetry collector over
+// OTLP/HTTP with the JSON encoding. Tracing is off until Setup is called
+// with an endpoint; spans started before that, or not sampled, are nil and
+// every method of a nil *Span does nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of spans, as numbered by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+// Span is one timed operation of a trace
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+type spanKey struct{}
+
+// remoteParent is the caller's span taken from a traceparent header
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type remoteKey struct{}
+
+// tracer is the active exporter; nil while tracing is off
+var (
+	tracerMu sync.RWMutex
+	tracer   *exporter
+)
+
+// Start starts a span as a child of the span in ctx and returns a context
+// carrying it. The span is nil when tracing is off or the trace is not
+// sampled.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartKind(ctx, name, KindInternal)
+}
+
+// StartKind is Start for a span of the given kind
+func StartKind(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	tracerMu.RLock()
+	t := tracer
+	tracerMu.RUnlock()
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(remoteParent); ok {
+		if !remote.sampled {
+			return ctx, nil
+		}
+		span.traceID = remote.traceID
+		span.parentID = remote.spanID
+	} else {
+		rand.Read(span.traceID[:])
+		if !t.sample(span.traceID) {
+			return ctx, nil
+		}
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span carried by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttr records an attribute of the operation. Values are strings,
+// integers, floats or booleans; others are formatted with %v.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]interface{})
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks the span as failed with err; nil leaves it unchanged
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export. Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	tracerMu.RLock()
+	t := tracer
+	tracerMu.RUnlock()
+	if t != nil {
+		t.enqueue(s)
+	}
+}
+
+// Finish sets *errp as the span's error and ends it, for use as
+// "defer span.Finish(&err)" in functions with a named error result
+func (s *Span) Finish(errp *error) {
+	if errp != nil {
+		s.SetError(*errp)
+	}
+	s.End()
+}
+
+// Traceparent returns the W3C traceparent header value of the span
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]))
+}
+
+// TraceID returns the span's trace ID in hex, or "" for a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// WithTraceparent returns a context whose next root span continues the
+// trace of a W3C traceparent header. Invalid headers are ignored.
+func WithTraceparent(ctx context.Context, header string) context.Context {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var remote remoteParent
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil || remote.traceID == [16]byte{} {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil || remote.spanID == [8]byte{} {
+		return ctx
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	remote.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, remoteKey{}, remote)
+}
+
+// Run runs a command like cmd.Run inside a span named after the program
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	span := startCommand(ctx, cmd)
+	err := cmd.Run()
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+// Output runs a command like cmd.Output inside a span named after the
+// program
+func Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	span := startCommand(ctx, cmd)
+	out, err := cmd.Output()
+	span.SetError(err)
+	span.End()
+	return out, err
+}
+
+// StartCommand starts the span of a command that the caller runs itself
+func StartCommand(ctx context.Context, cmd *exec.Cmd) *Span {
+	return startCommand(ctx, cmd)
+}
+
+func startCommand(ctx context.Context, cmd *exec.Cmd) *Span {
+	_, span := StartKind(ctx, "exec "+filepath.Base(cmd.Path), KindClient)
+	span.SetAttr("process.command_args", strings.Join(cmd.Args, " "))
+	return span
+}
+
+// sampleThreshold turns a ratio into a bound on the trace ID's low bits,
+// like OpenTelemetry's TraceIDRatioBased sampler
+func sampleThreshold(ratio float64) uint64 {
+	if ratio >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(ratio * (1 << 63) * 2)
+}
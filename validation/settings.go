@@ -0,0 +1,76 @@
+package validation
+
+// countBounds are the allowed ranges of integer pool settings; a max of 0
+// leaves the range open
+var countBounds = map[string]struct{ min, max int }{
+	"max_children":      {1, 10000},
+	"start_servers":     {1, 10000},
+	"min_spare_servers": {1, 10000},
+	"max_spare_servers": {1, 10000},
+	"max_requests":      {0, 0},
+	"listen_port":       {1, 65535},
+}
+
+// processManagers are the values of pm
+var processManagers = []string{"static", "dynamic", "ondemand"}
+
+// SettingCount checks an integer pool setting against its bounds. Keys
+// without bounds only need to be non-negative.
+func SettingCount(errs *Errors, key string, value float64) {
+	if value != float64(int64(value)) {
+		errs.Add(key, "must be an integer")
+		return
+	}
+	bounds, ok := countBounds[key]
+	if !ok {
+		if value < 0 {
+			errs.Add(key, "must be a non-negative integer")
+		}
+		return
+	}
+	if value < float64(bounds.min) || (bounds.max > 0 && value > float64(bounds.max)) {
+		if bounds.max > 0 {
+			errs.Add(key, "must be from %d to %d", bounds.min, bounds.max)
+		} else {
+			errs.Add(key, "must be at least %d", bounds.min)
+		}
+	}
+}
+
+// ProcessManager checks the effective process manager settings of a pool
+// the way PHP-FPM does when it loads the pool, so a bad combination is
+// refused before the file is written
+func ProcessManager(pm string, maxChildren, startServers, minSpare, maxSpare int) Errors {
+	var errs Errors
+	valid := false
+	for _, p := range processManagers {
+		valid = valid || pm == p
+	}
+	if !valid {
+		errs.Add("process_manager", "must be one of: static, dynamic, ondemand")
+		return errs
+	}
+	if maxChildren < 1 {
+		errs.Add("max_children", "must be at least 1")
+		return errs
+	}
+	if pm != "dynamic" {
+		return nil
+	}
+	if minSpare < 1 {
+		errs.Add("min_spare_servers", "must be at least 1")
+	}
+	if maxSpare < 1 {
+		errs.Add("max_spare_servers", "must be at least 1")
+	}
+	if maxSpare > maxChildren {
+		errs.Add("max_spare_servers", "must not be greater than max_children (%d)", maxChildren)
+	}
+	if minSpare > maxSpare {
+		errs.Add("min_spare_servers", "must not be greater than max_spare_servers (%d)", maxSpare)
+	}
+	if startServers < minSpare || startServers > maxSpare {
+		errs.Add("start_servers", "must be from min_spare_servers (%d) to max_spare_servers (%d)", minSpare, maxSpare)
+	}
+	return errs
+}
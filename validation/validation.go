@@ -0,0 +1,160 @@
+// Package validation checks the names, versions and settings that end up in
+// file paths, unit names and commands. The API reports its errors per field
+// with 422; the managers run the same checks so the CLI, bundles and
+// backups cannot bypass them.
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"lightweight-php/version"
+)
+
+var (
+	// usernamePattern is what useradd accepts on every supported
+	// distribution; it also keeps a name from being read as an option or
+	// from leaving the directory it is joined to
+	usernamePattern    = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	domainPattern      = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,}$`)
+	profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	tenantNamePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+)
+
+// reservedUsernames may not own a pool: their PHP would run with the
+// privileges of the system itself
+var reservedUsernames = map[string]bool{"root": true}
+
+// FieldError describes one invalid field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects invalid fields; a non-empty list is an error
+type Errors []FieldError
+
+// Add records an invalid field
+func (e *Errors) Add(field, format string, args ...interface{}) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check records the error of check(value) for field, if any
+func (e *Errors) Check(field, value string, check func(string) error) {
+	if err := check(value); err != nil {
+		e.Add(field, "%s", err)
+	}
+}
+
+func (e Errors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, f := range e {
+		parts = append(parts, "invalid "+f.Field+": "+f.Message)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Err returns e as an error, or nil when it is empty
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Field checks one value and returns the failure as Errors, for callers
+// outside the API that return a single error
+func Field(field, value string, check func(string) error) error {
+	var errs Errors
+	errs.Check(field, value, check)
+	return errs.Err()
+}
+
+// Username checks the name of a pool's system user
+func Username(s string) error {
+	if !usernamePattern.MatchString(s) {
+		return fmt.Errorf("must be a valid system username: lowercase letters, digits, _ or -, starting with a letter or _, at most 32 characters")
+	}
+	if reservedUsernames[s] {
+		return fmt.Errorf("must not be a system account such as %s", s)
+	}
+	return nil
+}
+
+// PHPVersion checks a PHP version in major.minor form, such as 8.2
+func PHPVersion(s string) error {
+	_, _, err := ParsePHPVersion(s)
+	return err
+}
+
+// ParsePHPVersion splits a major.minor PHP version into numbers, so
+// versions compare numerically: 10.0 is newer than 8.4
+func ParsePHPVersion(s string) (major, minor int, err error) {
+	v, err := version.Parse(s)
+	if err != nil || v.Parts != 2 {
+		return 0, 0, fmt.Errorf("must be a version such as 8.2")
+	}
+	return v.Major, v.Minor, nil
+}
+
+// PHPVersionAtLeast reports whether v is min or newer
+func PHPVersionAtLeast(v, min string) (bool, error) {
+	if _, _, err := ParsePHPVersion(v); err != nil {
+		return false, fmt.Errorf("invalid PHP version %q: %w", v, err)
+	}
+	d, err := version.Compare(v, min)
+	if err != nil {
+		return false, err
+	}
+	return d >= 0, nil
+}
+
+// PHPVersionSpec checks a version to install: a major.minor version or a
+// constraint that resolves to one, such as 8 or ">=7.4 <8.4"
+func PHPVersionSpec(s string) error {
+	if _, err := version.ParseConstraint(s); err != nil {
+		return fmt.Errorf("must be a version such as 8.2 or a constraint such as 8 or >=7.4 <8.4")
+	}
+	return nil
+}
+
+// Domain checks a site's domain name
+func Domain(s string) error {
+	if len(s) > 253 || !domainPattern.MatchString(s) {
+		return fmt.Errorf("must be a domain name such as example.com")
+	}
+	return nil
+}
+
+// ProfileName checks the name of a pool profile
+func ProfileName(s string) error {
+	if !profileNamePattern.MatchString(s) {
+		return fmt.Errorf("must be lowercase letters, digits, - or _, at most 32 characters")
+	}
+	return nil
+}
+
+// ScheduleName checks the name of a recurring schedule such as "nightly-backup"
+func ScheduleName(s string) error {
+	if !profileNamePattern.MatchString(s) {
+		return fmt.Errorf("must be lowercase letters, digits, - or _, at most 32 characters")
+	}
+	return nil
+}
+
+// WorkerName checks the name of a pool's worker such as "queue"
+func WorkerName(s string) error {
+	if !profileNamePattern.MatchString(s) {
+		return fmt.Errorf("must be lowercase letters, digits, - or _, at most 32 characters")
+	}
+	return nil
+}
+
+// TenantName checks the name of a tenant such as "shared-1"
+func TenantName(s string) error {
+	if !tenantNamePattern.MatchString(s) {
+		return fmt.Errorf("must be lowercase letters, digits, - or _, at most 32 characters")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// comparison is one operator applied to a version
+type comparison struct {
+	op      string
+	version Version
+}
+
+// Constraint is a set of alternatives separated by ||; each alternative is
+// a list of comparisons that must all hold, such as ">=7.4 <8.4"
+type Constraint struct {
+	alternatives [][]comparison
+	source       string
+}
+
+// operators are tried longest first so ">=" is not read as ">"
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "~"}
+
+// ParseConstraint reads a constraint. Comparisons use >=, >, <=, <, = and
+// !=; ~8.1 means 8.1 or newer within 8.x. A bare version matches the
+// versions it is a prefix of: 8 matches any 8.x and 8.3 matches 8.3.x.
+// A trailing .x or .* is accepted for the same meaning, as in 8.x.
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{source: strings.TrimSpace(s)}
+	for _, alt := range strings.Split(s, "||") {
+		var list []comparison
+		for _, term := range strings.FieldsFunc(alt, func(r rune) bool { return r == ' ' || r == ',' }) {
+			cmp, err := parseComparison(term)
+			if err != nil {
+				return Constraint{}, err
+			}
+			list = append(list, cmp...)
+		}
+		if len(list) == 0 {
+			return Constraint{}, fmt.Errorf("invalid version constraint %q", s)
+		}
+		c.alternatives = append(c.alternatives, list)
+	}
+	return c, nil
+}
+
+func parseComparison(term string) ([]comparison, error) {
+	op := ""
+	for _, o := range operators {
+		if strings.HasPrefix(term, o) {
+			op = o
+			break
+		}
+	}
+	rest := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(term, op), ".x"), ".*")
+	v, err := Parse(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q", term)
+	}
+	switch op {
+	case "":
+		// A prefix: from v up to, but not including, the next value of its
+		// last given field
+		upper := v
+		switch v.Parts {
+		case 1:
+			upper.Major++
+		case 2:
+			upper.Minor++
+		default:
+			return []comparison{{"=", v}}, nil
+		}
+		return []comparison{{">=", v}, {"<", upper}}, nil
+	case "~":
+		return []comparison{{">=", v}, {"<", Version{Major: v.Major + 1, Parts: 1}}}, nil
+	}
+	return []comparison{{op, v}}, nil
+}
+
+// Check reports whether v satisfies the constraint
+func (c Constraint) Check(v Version) bool {
+	for _, list := range c.alternatives {
+		ok := true
+		for _, cmp := range list {
+			ok = ok && cmp.holds(v)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Constraint) String() string {
+	return c.source
+}
+
+func (cmp comparison) holds(v Version) bool {
+	d := v.Compare(cmp.version)
+	switch cmp.op {
+	case ">=":
+		return d >= 0
+	case "<=":
+		return d <= 0
+	case ">":
+		return d > 0
+	case "<":
+		return d < 0
+	case "!=":
+		return d != 0
+	default:
+		return d == 0
+	}
+}
+
+// Satisfies parses v and c and reports whether v satisfies c
+func Satisfies(v, c string) (bool, error) {
+	parsed, err := Parse(v)
+	if err != nil {
+		return false, err
+	}
+	constraint, err := ParseConstraint(c)
+	if err != nil {
+		return false, err
+	}
+	return constraint.Check(parsed), nil
+}
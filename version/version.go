@@ -0,0 +1,121 @@
+// Package version parses and compares PHP versions numerically and matches
+// them against constraints such as ">=7.4 <8.4". Versions are compared
+// field by field, so 10.0 is newer than 8.4 and 8.10 newer than 8.9.
+package version
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is a PHP version of one to three numeric fields. Fields that were
+// not given are zero and Parts tells how many were.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+	Parts int
+}
+
+// Parse reads a version such as 8, 8.3 or 8.3.12
+func Parse(s string) (Version, error) {
+	fields := strings.Split(s, ".")
+	if s == "" || len(fields) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	var nums [3]int
+	for i, f := range fields {
+		// Short fields keep to the shape of a real PHP version and rule
+		// out overflow
+		if f == "" || len(f) > 3 || (i < 2 && len(f) > 2) || strings.Trim(f, "0123456789") != "" {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i], _ = strconv.Atoi(f)
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2], Parts: len(fields)}, nil
+}
+
+// ParseRelease reads the version at the start of a release string such as
+// PHP_VERSION, which distributions suffix with their build: 8.1.2-1ubuntu2
+// is 8.1.2
+func ParseRelease(s string) (Version, error) {
+	end := strings.IndexFunc(s, func(r rune) bool { return r != '.' && (r < '0' || r > '9') })
+	if end < 0 {
+		end = len(s)
+	}
+	v, err := Parse(strings.TrimSuffix(s[:end], "."))
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid release %q", s)
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	switch v.Parts {
+	case 1:
+		return strconv.Itoa(v.Major)
+	case 3:
+		return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	default:
+		return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+	}
+}
+
+// Compare returns -1, 0 or 1 as v is older than, equal to or newer than w.
+// Missing fields count as zero.
+func (v Version) Compare(w Version) int {
+	for _, d := range []int{v.Major - w.Major, v.Minor - w.Minor, v.Patch - w.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Compare parses and compares two versions
+func Compare(a, b string) (int, error) {
+	va, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// Sort orders versions newest first. Strings that are not versions go last
+// in their original order.
+func Sort(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		vi, erri := Parse(versions[i])
+		vj, errj := Parse(versions[j])
+		if erri != nil || errj != nil {
+			return erri == nil && errj != nil
+		}
+		return vi.Compare(vj) > 0
+	})
+}
+
+// Latest returns the newest of versions that satisfies c, or false if none
+// does. Strings that are not versions are skipped.
+func Latest(versions []string, c Constraint) (string, bool) {
+	var best Version
+	found := ""
+	for _, s := range versions {
+		v, err := Parse(s)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if found == "" || v.Compare(best) > 0 {
+			best, found = v, s
+		}
+	}
+	return found, found != ""
+}